@@ -0,0 +1,446 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/hmac"
+	crand "crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"flag"
+	"fmt"
+	"hash"
+	"math"
+	"math/big"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+var (
+	apiKeysSpec    string
+	apiRateLimit   int
+	apiJWTSecret   string
+	apiJWTKeyFile  string
+	apiJWTIssuer   string
+	apiJWTAudience string
+)
+
+func init() {
+	flag.StringVar(&apiKeysSpec, "apiKeys", "", "API keys the API server accepts in the X-API-Key header, as name:key or name:key:requests-per-minute, one per line or comma-separated; accepts {{env:NAME}} and {{file:path}}")
+	flag.IntVar(&apiRateLimit, "apiRateLimit", 0, "Requests per minute allowed to each API key or JWT subject without a limit of its own (0 means no limit)")
+	flag.StringVar(&apiJWTSecret, "apiJWTSecret", "", "Accept JWT bearer tokens signed with this HS256, HS384 or HS512 secret; accepts {{env:NAME}} and {{file:path}}")
+	flag.StringVar(&apiJWTKeyFile, "apiJWTKey", "", "Accept JWT bearer tokens signed with the RSA or ECDSA public key (or certificate) in this PEM file")
+	flag.StringVar(&apiJWTIssuer, "apiJWTIssuer", "", "Issuer (iss) that JWT bearer tokens must have")
+	flag.StringVar(&apiJWTAudience, "apiJWTAudience", "", "Audience (aud) that JWT bearer tokens must include")
+}
+
+// jwtLeeway allows for clocks that disagree on when a token expires.
+const jwtLeeway = time.Minute
+
+// apiClient is a caller the API server knows: an API key, or the subject
+// of a JWT.
+type apiClient struct {
+	name string
+	// rate is the requests per minute the client may send; 0 is no limit.
+	rate int
+}
+
+// apiAuthenticator checks the credentials of API requests.
+type apiAuthenticator struct {
+	// keys holds the API keys by their SHA-256, so a lookup takes no longer
+	// for a key that nearly matches.
+	keys      map[string]apiClient
+	jwtSecret []byte
+	jwtKey    crypto.PublicKey
+	issuer    string
+	audience  string
+	rate      int
+	limiter   *apiRateLimiter
+}
+
+// apiAuth is nil while the API is open to every caller.
+var apiAuth *apiAuthenticator
+
+// setupAPIAuth checks the -apiKeys and -apiJWT flags. Without keys or a JWT
+// key the API stays open, as before.
+func setupAPIAuth() error {
+	if apiRateLimit < 0 {
+		return fmt.Errorf("-apiRateLimit must be 0 or more, not %d", apiRateLimit)
+	}
+	spec, err := resolveSecretPlaceholders(apiKeysSpec)
+	if err != nil {
+		return fmt.Errorf("-apiKeys: %w", err)
+	}
+	keys, err := parseAPIKeys(spec, apiRateLimit)
+	if err != nil {
+		return fmt.Errorf("-apiKeys: %w", err)
+	}
+	a := &apiAuthenticator{keys: keys, issuer: apiJWTIssuer, audience: apiJWTAudience, rate: apiRateLimit, limiter: newAPIRateLimiter()}
+	if apiJWTSecret != "" {
+		secret, err := resolveSecretPlaceholders(apiJWTSecret)
+		if err != nil {
+			return fmt.Errorf("-apiJWTSecret: %w", err)
+		}
+		a.jwtSecret = []byte(secret)
+	}
+	if apiJWTKeyFile != "" {
+		if a.jwtKey, err = loadJWTPublicKey(apiJWTKeyFile); err != nil {
+			return fmt.Errorf("-apiJWTKey: %w", err)
+		}
+	}
+	jwt := a.jwtSecret != nil || a.jwtKey != nil
+	if !jwt && (a.issuer != "" || a.audience != "") {
+		return fmt.Errorf("-apiJWTIssuer and -apiJWTAudience need -apiJWTSecret or -apiJWTKey")
+	}
+	if len(a.keys) == 0 && !jwt {
+		if apiRateLimit > 0 {
+			return fmt.Errorf("-apiRateLimit needs -apiKeys, -apiJWTSecret or -apiJWTKey to tell callers apart")
+		}
+		return nil
+	}
+	apiAuth = a
+	return nil
+}
+
+// parseAPIKeys reads the -apiKeys entries. A key given without a name is
+// named after its position.
+func parseAPIKeys(spec string, rate int) (map[string]apiClient, error) {
+	keys := make(map[string]apiClient)
+	names := make(map[string]bool)
+	entries := strings.FieldsFunc(spec, func(r rune) bool { return r == '\n' || r == ',' })
+	for i, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if entry == "" || strings.HasPrefix(entry, "#") {
+			continue
+		}
+		client := apiClient{name: fmt.Sprintf("key%d", i+1), rate: rate}
+		key := entry
+		if parts := strings.Split(entry, ":"); len(parts) > 1 {
+			if len(parts) > 3 {
+				return nil, fmt.Errorf("entry %d should be name:key or name:key:requests-per-minute", i+1)
+			}
+			client.name, key = strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1])
+			if len(parts) == 3 {
+				n, err := strconv.Atoi(strings.TrimSpace(parts[2]))
+				if err != nil || n < 0 {
+					return nil, fmt.Errorf("key %s: requests per minute %q should be a number, 0 for no limit", client.name, parts[2])
+				}
+				client.rate = n
+			}
+		}
+		if client.name == "" || key == "" {
+			return nil, fmt.Errorf("entry %d has an empty name or key", i+1)
+		}
+		if names[client.name] {
+			return nil, fmt.Errorf("key %s is given twice", client.name)
+		}
+		names[client.name] = true
+		keys[apiKeyDigest(key)] = client
+	}
+	return keys, nil
+}
+
+func apiKeyDigest(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// loadJWTPublicKey reads the RSA or ECDSA public key that signs JWTs from a
+// PEM public key or certificate.
+func loadJWTPublicKey(path string) (crypto.PublicKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("%s holds no PEM data", path)
+	}
+	var key crypto.PublicKey
+	switch block.Type {
+	case "CERTIFICATE":
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		key = cert.PublicKey
+	case "RSA PUBLIC KEY":
+		key, err = x509.ParsePKCS1PublicKey(block.Bytes)
+	default:
+		key, err = x509.ParsePKIXPublicKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, err
+	}
+	switch key.(type) {
+	case *rsa.PublicKey, *ecdsa.PublicKey:
+		return key, nil
+	}
+	return nil, fmt.Errorf("%s holds a %T, not an RSA or ECDSA public key", path, key)
+}
+
+// jwtClaims are the claims the API server checks.
+type jwtClaims struct {
+	Subject   string      `json:"sub"`
+	Issuer    string      `json:"iss"`
+	Audience  jwtAudience `json:"aud"`
+	ExpiresAt *float64    `json:"exp"`
+	NotBefore *float64    `json:"nbf"`
+}
+
+// jwtAudience is the aud claim, which is a string or a list of them.
+type jwtAudience []string
+
+func (a *jwtAudience) UnmarshalJSON(data []byte) error {
+	var one string
+	if err := json.Unmarshal(data, &one); err == nil {
+		*a = jwtAudience{one}
+		return nil
+	}
+	var many []string
+	if err := json.Unmarshal(data, &many); err != nil {
+		return fmt.Errorf("aud should be a string or a list of strings")
+	}
+	*a = many
+	return nil
+}
+
+// verifyJWT checks the signature and claims of a compact JWT. Tokens must
+// expire; "none" and algorithms the configured keys do not sign with are
+// refused.
+func (a *apiAuthenticator) verifyJWT(token string, now time.Time) (*jwtClaims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("malformed JWT")
+	}
+	var header struct {
+		Alg string `json:"alg"`
+	}
+	if err := decodeJWTPart(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("JWT header: %w", err)
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, errors.New("malformed JWT signature")
+	}
+	if err := a.verifyJWTSignature(header.Alg, parts[0]+"."+parts[1], sig); err != nil {
+		return nil, err
+	}
+	var claims jwtClaims
+	if err := decodeJWTPart(parts[1], &claims); err != nil {
+		return nil, fmt.Errorf("JWT claims: %w", err)
+	}
+	if claims.ExpiresAt == nil {
+		return nil, errors.New("JWT has no expiry (exp)")
+	}
+	if now.After(jwtTime(*claims.ExpiresAt).Add(jwtLeeway)) {
+		return nil, errors.New("JWT has expired")
+	}
+	if claims.NotBefore != nil && now.Add(jwtLeeway).Before(jwtTime(*claims.NotBefore)) {
+		return nil, errors.New("JWT is not valid yet")
+	}
+	if a.issuer != "" && claims.Issuer != a.issuer {
+		return nil, fmt.Errorf("JWT issuer %q is not %q", claims.Issuer, a.issuer)
+	}
+	if a.audience != "" {
+		found := false
+		for _, aud := range claims.Audience {
+			found = found || aud == a.audience
+		}
+		if !found {
+			return nil, fmt.Errorf("JWT audience does not include %q", a.audience)
+		}
+	}
+	return &claims, nil
+}
+
+func decodeJWTPart(part string, v any) error {
+	data, err := base64.RawURLEncoding.DecodeString(part)
+	if err != nil {
+		return errors.New("not base64url")
+	}
+	return json.Unmarshal(data, v)
+}
+
+func jwtTime(seconds float64) time.Time {
+	whole, frac := math.Modf(seconds)
+	return time.Unix(int64(whole), int64(frac*1e9))
+}
+
+// verifyJWTSignature checks sig over signed with the key alg calls for.
+func (a *apiAuthenticator) verifyJWTSignature(alg, signed string, sig []byte) error {
+	var newHash func() hash.Hash
+	var hashID crypto.Hash
+	switch alg[min(len(alg), 2):] {
+	case "256":
+		newHash, hashID = sha256.New, crypto.SHA256
+	case "384":
+		newHash, hashID = sha512.New384, crypto.SHA384
+	case "512":
+		newHash, hashID = sha512.New, crypto.SHA512
+	default:
+		return fmt.Errorf("JWT algorithm %q is not accepted", alg)
+	}
+	h := newHash()
+	h.Write([]byte(signed))
+	digest := h.Sum(nil)
+	switch key := a.jwtKey.(type) {
+	case *rsa.PublicKey:
+		if strings.HasPrefix(alg, "RS") {
+			if rsa.VerifyPKCS1v15(key, hashID, digest, sig) != nil {
+				return errors.New("JWT signature does not verify")
+			}
+			return nil
+		}
+	case *ecdsa.PublicKey:
+		if strings.HasPrefix(alg, "ES") {
+			size := (key.Curve.Params().BitSize + 7) / 8
+			if len(sig) != 2*size {
+				return errors.New("JWT signature does not verify")
+			}
+			r, s := new(big.Int).SetBytes(sig[:size]), new(big.Int).SetBytes(sig[size:])
+			if !ecdsa.Verify(key, digest, r, s) {
+				return errors.New("JWT signature does not verify")
+			}
+			return nil
+		}
+	}
+	if strings.HasPrefix(alg, "HS") && a.jwtSecret != nil {
+		mac := hmac.New(newHash, a.jwtSecret)
+		mac.Write([]byte(signed))
+		if !hmac.Equal(mac.Sum(nil), sig) {
+			return errors.New("JWT signature does not verify")
+		}
+		return nil
+	}
+	return fmt.Errorf("JWT algorithm %q is not accepted", alg)
+}
+
+// authenticate finds who sent r, from its X-API-Key header or its bearer
+// token.
+func (a *apiAuthenticator) authenticate(r *http.Request, now time.Time) (apiClient, error) {
+	if key := r.Header.Get("X-API-Key"); key != "" {
+		client, ok := a.keys[apiKeyDigest(key)]
+		if !ok {
+			return apiClient{}, errors.New("unknown API key")
+		}
+		return client, nil
+	}
+	if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok && (a.jwtSecret != nil || a.jwtKey != nil) {
+		claims, err := a.verifyJWT(strings.TrimSpace(token), now)
+		if err != nil {
+			return apiClient{}, err
+		}
+		return apiClient{name: "jwt:" + claims.Subject, rate: a.rate}, nil
+	}
+	if a.jwtSecret != nil || a.jwtKey != nil {
+		return apiClient{}, errors.New("send an API key in X-API-Key or a JWT in Authorization: Bearer")
+	}
+	return apiClient{}, errors.New("send an API key in X-API-Key")
+}
+
+// middleware refuses requests without valid credentials with 401, and
+// those over their client's rate with 429.
+func (a *apiAuthenticator) middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		now := time.Now()
+		client, err := a.authenticate(c.Request, now)
+		if err != nil {
+			apiLog.Warn(fmt.Sprintf("Refused %s %s from %s: %v", c.Request.Method, c.Request.URL.Path, c.ClientIP(), err))
+			if a.jwtSecret != nil || a.jwtKey != nil {
+				c.Header("WWW-Authenticate", `Bearer realm="3270Connect"`)
+			}
+			sendErrorResponse(c, http.StatusUnauthorized, "Authentication required", err)
+			c.Abort()
+			return
+		}
+		if wait, ok := a.limiter.allow(client.name, client.rate, now); !ok {
+			c.Header("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			sendErrorResponse(c, http.StatusTooManyRequests, "Rate limit exceeded", fmt.Errorf("%s may send %d requests a minute", client.name, client.rate))
+			c.Abort()
+			return
+		}
+		c.Set(apiClientKey, client.name)
+		c.Next()
+	}
+}
+
+// apiRateLimiter keeps a token bucket per client, holding up to a
+// minute's worth of requests.
+type apiRateLimiter struct {
+	mu      sync.Mutex
+	buckets map[string]*rateBucket
+}
+
+type rateBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func newAPIRateLimiter() *apiRateLimiter {
+	return &apiRateLimiter{buckets: make(map[string]*rateBucket)}
+}
+
+// allow takes a request from the bucket of client, or says how long until
+// there is one.
+func (l *apiRateLimiter) allow(client string, perMinute int, now time.Time) (time.Duration, bool) {
+	if perMinute <= 0 {
+		return 0, true
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	b := l.buckets[client]
+	if b == nil {
+		b = &rateBucket{tokens: float64(perMinute), last: now}
+		l.buckets[client] = b
+	}
+	perSecond := float64(perMinute) / 60
+	b.tokens = math.Min(float64(perMinute), b.tokens+now.Sub(b.last).Seconds()*perSecond)
+	b.last = now
+	if b.tokens < 1 {
+		return time.Duration((1 - b.tokens) / perSecond * float64(time.Second)), false
+	}
+	b.tokens--
+	return 0, true
+}
+
+// dashboardAPIKeyPath is where the API server of pid leaves the key the
+// dashboard uses to cancel its jobs.
+func dashboardAPIKeyPath(pid int) string {
+	return filepath.Join(dashboardMetricsDir(), fmt.Sprintf("apikey_%d", pid))
+}
+
+// writeDashboardAPIKey gives the dashboard a key of its own, readable only
+// by this user, when the API needs one.
+func writeDashboardAPIKey() {
+	if apiAuth == nil {
+		return
+	}
+	var raw [24]byte
+	crand.Read(raw[:])
+	key := hex.EncodeToString(raw[:])
+	path := dashboardAPIKeyPath(os.Getpid())
+	err := os.MkdirAll(filepath.Dir(path), 0755)
+	if err == nil {
+		err = os.WriteFile(path, []byte(key), 0600)
+	}
+	if err != nil {
+		apiLog.Warn(fmt.Sprintf("The dashboard cannot cancel API jobs: writing its key failed: %v", err))
+		return
+	}
+	apiAuth.keys[apiKeyDigest(key)] = apiClient{name: "dashboard"}
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	connect3270 "github.com/3270io/3270Connect/connect3270"
+)
+
+var apiCallbackSecretSpec string
+
+func init() {
+	flag.StringVar(&apiCallbackSecretSpec, "api-callback-secret", "", "Sign what API jobs post to their CallbackURL with HMAC-SHA256 of this secret, in the X-3270Connect-Signature header; use {{env:NAME}} or {{file:path}} to keep it off the command line")
+}
+
+// apiCallbackSecret signs job callbacks; nil leaves them unsigned.
+var apiCallbackSecret []byte
+
+const (
+	// apiCallbackAttempts is how many times a callback is posted before
+	// it is given up.
+	apiCallbackAttempts = 5
+	apiCallbackEvent    = "job.finished"
+)
+
+// apiCallbackBackoff is the wait before a callback is posted again; it
+// doubles with each retry.
+var apiCallbackBackoff = time.Second
+
+// setupAPICallbacks reads -api-callback-secret.
+func setupAPICallbacks() error {
+	if apiCallbackSecretSpec == "" {
+		return nil
+	}
+	secret, err := resolveSecretPlaceholders(apiCallbackSecretSpec)
+	if err != nil {
+		return fmt.Errorf("-api-callback-secret: %w", err)
+	}
+	if secret == "" {
+		return errors.New("-api-callback-secret is empty")
+	}
+	apiCallbackSecret = []byte(secret)
+	return nil
+}
+
+// checkCallbackURL checks the CallbackURL of an API workflow.
+func checkCallbackURL(raw string) error {
+	if raw == "" {
+		return nil
+	}
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("CallbackURL needs an http or https URL, not %q", redactURL(raw))
+	}
+	return nil
+}
+
+// apiJobCallback is what a job posts to its CallbackURL when it finishes:
+// its status, and what GET /api/jobs/{id}/result answers.
+type apiJobCallback struct {
+	Event string `json:"event"`
+	apiJobStatus
+	ReturnCode int    `json:"returnCode"`
+	Message    string `json:"message,omitempty"`
+	Output     string `json:"output,omitempty"`
+	// FailedStep is the step that failed the job.
+	FailedStep *apiStepEvent `json:"failedStep,omitempty"`
+}
+
+// callback is the apiJobCallback of the finished job.
+func (j *apiJob) callback() apiJobCallback {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	c := apiJobCallback{Event: apiCallbackEvent, apiJobStatus: j.viewLocked(), ReturnCode: http.StatusOK, Output: j.outcome.output}
+	if j.outcome.err != nil {
+		c.ReturnCode, c.Message = j.outcome.code, j.outcome.message
+	}
+	for i := len(j.events) - 1; i >= 0; i-- {
+		if step, ok := j.events[i].data.(apiStepEvent); ok && !step.Success {
+			c.FailedStep = &step
+			break
+		}
+	}
+	return c
+}
+
+// postCallback posts the result of the finished job to callbackURL. It
+// tries again, waiting longer each time, while the receiver cannot be
+// reached or answers 429 or a 5xx status.
+func (j *apiJob) postCallback(callbackURL string) {
+	body, err := json.Marshal(j.callback())
+	if err != nil {
+		apiLog.Error(fmt.Sprintf("Cannot build the callback of job %s: %v", j.id, err))
+		return
+	}
+	client := &http.Client{Timeout: notifyTimeout}
+	wait := apiCallbackBackoff
+	for attempt := 1; ; attempt++ {
+		retry, err := sendAPICallback(client, callbackURL, j.id, body)
+		if err == nil {
+			apiLog.Info(fmt.Sprintf("Posted the result of job %s to %s", j.id, redactURL(callbackURL)))
+			return
+		}
+		if !retry || attempt == apiCallbackAttempts {
+			apiLog.Error(fmt.Sprintf("Gave up posting the result of job %s to %s after %d attempts: %v", j.id, redactURL(callbackURL), attempt, err))
+			return
+		}
+		apiLog.Warn(fmt.Sprintf("Posting the result of job %s to %s failed, trying again in %s: %v", j.id, redactURL(callbackURL), wait, err))
+		select {
+		case <-time.After(wait):
+		case <-connect3270.ShutdownContext().Done():
+			apiLog.Warn(fmt.Sprintf("Shut down before the result of job %s was posted to %s", j.id, redactURL(callbackURL)))
+			return
+		}
+		wait *= 2
+	}
+}
+
+// sendAPICallback posts body once, and says whether a failure is worth
+// trying again.
+func sendAPICallback(client *http.Client, callbackURL, jobID string, body []byte) (bool, error) {
+	req, err := http.NewRequest(http.MethodPost, callbackURL, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "3270Connect/"+version)
+	req.Header.Set("X-3270Connect-Event", apiCallbackEvent)
+	req.Header.Set("X-3270Connect-Delivery", jobID)
+	if apiCallbackSecret != nil {
+		timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+		req.Header.Set("X-3270Connect-Timestamp", timestamp)
+		req.Header.Set("X-3270Connect-Signature", "sha256="+signAPICallback(apiCallbackSecret, timestamp, body))
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		// The error carries the URL, and with it any secret in it.
+		if urlErr, ok := err.(*url.Error); ok {
+			err = urlErr.Err
+		}
+		return true, err
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	switch {
+	case resp.StatusCode < 300:
+		return false, nil
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+		return true, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return false, fmt.Errorf("unexpected status %s", resp.Status)
+}
+
+// signAPICallback is the hex HMAC-SHA256 of the timestamp, a dot and the
+// body. Signing the timestamp lets receivers refuse replayed callbacks.
+func signAPICallback(secret []byte, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
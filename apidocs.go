@@ -0,0 +1,345 @@
+package main
+
+import (
+	_ "embed"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// apiDocsPage is the Swagger UI page of /api/docs.
+//
+//go:embed templates/apidocs.html
+var apiDocsPage []byte
+
+// registerDocRoutes serves the OpenAPI document of the API at
+// /api/openapi.json and Swagger UI for it at /api/docs. They are
+// registered before the authentication middleware, so the docs can be read
+// without a key; trying requests from Swagger UI still needs one.
+func registerDocRoutes(r *gin.Engine) {
+	r.GET("/api/openapi.json", func(c *gin.Context) {
+		c.JSON(http.StatusOK, openAPIDocument())
+	})
+	r.GET("/api/docs", func(c *gin.Context) {
+		c.Data(http.StatusOK, "text/html; charset=utf-8", apiDocsPage)
+	})
+}
+
+// openAPIDocument describes the API in OpenAPI 3.1. Workflow bodies use
+// the published workflow schema, whose $defs become component schemas.
+func openAPIDocument() gin.H {
+	schemas := gin.H{}
+	var workflow map[string]any
+	if err := json.Unmarshal(workflowSchema, &workflow); err != nil {
+		panic("embedded workflow schema: " + err.Error())
+	}
+	for name, def := range workflow["$defs"].(map[string]any) {
+		schemas[name] = moveSchemaRefs(def)
+	}
+	for _, key := range []string{"$schema", "$id", "$defs"} {
+		delete(workflow, key)
+	}
+	schemas["Workflow"] = moveSchemaRefs(workflow)
+	schemas["Error"] = gin.H{
+		"type":     "object",
+		"required": []string{"returnCode", "status", "message", "error"},
+		"properties": gin.H{
+			"returnCode": gin.H{"type": "integer", "description": "The HTTP status code."},
+			"status":     gin.H{"const": "error"},
+			"message":    gin.H{"type": "string"},
+			"error":      gin.H{"type": "string", "description": "What went wrong."},
+			"errors":     gin.H{"type": "array", "items": gin.H{"$ref": "#/components/schemas/Issue"}, "description": "Set when a workflow is refused: each problem found in it."},
+			"jobId":      gin.H{"type": "string", "description": "Set in job results."},
+		},
+	}
+	schemas["Issue"] = gin.H{
+		"type":     "object",
+		"required": []string{"message"},
+		"properties": gin.H{
+			"path":    gin.H{"type": "string", "description": "The field, such as Steps[4].Coordinates."},
+			"line":    gin.H{"type": "integer", "description": "1-based line of the field in the request body."},
+			"column":  gin.H{"type": "integer", "description": "1-based column of the field in the request body."},
+			"message": gin.H{"type": "string"},
+		},
+	}
+	schemas["Result"] = gin.H{
+		"type":     "object",
+		"required": []string{"returnCode", "status", "message", "output"},
+		"properties": gin.H{
+			"returnCode": gin.H{"const": http.StatusOK},
+			"status":     gin.H{"const": "okay"},
+			"message":    gin.H{"type": "string"},
+			"output":     gin.H{"type": "string", "description": "What the workflow's screen grab steps wrote."},
+			"jobId":      gin.H{"type": "string", "description": "Set in job results."},
+		},
+	}
+	schemas["Job"] = gin.H{
+		"type":     "object",
+		"required": []string{"jobId", "status", "step", "steps", "createdAt", "resultUrl"},
+		"properties": gin.H{
+			"jobId":      gin.H{"type": "string"},
+			"status":     gin.H{"enum": []string{jobQueued, jobRunning, jobSucceeded, jobFailed, jobCancelled}},
+			"step":       gin.H{"type": "integer", "description": "The step running, or the last one run, numbered from 1."},
+			"steps":      gin.H{"type": "integer", "description": "Steps in the workflow, counting those of included files."},
+			"stepType":   gin.H{"type": "string", "description": "Type of the step running, or the last one run."},
+			"createdAt":  gin.H{"type": "string", "format": "date-time"},
+			"startedAt":  gin.H{"type": "string", "format": "date-time", "description": "When a worker took the job; missing while it is queued."},
+			"finishedAt": gin.H{"type": "string", "format": "date-time"},
+			"error":      gin.H{"type": "string", "description": "Why the job failed or was cancelled."},
+			"resultUrl":  gin.H{"type": "string", "description": "Where to collect the result once the job has finished."},
+		},
+	}
+	schemas["StepEvent"] = gin.H{
+		"type":     "object",
+		"required": []string{"step", "steps", "type", "durationMs", "success"},
+		"properties": gin.H{
+			"step":       gin.H{"type": "integer", "description": "The step, numbered from 1."},
+			"steps":      gin.H{"type": "integer"},
+			"type":       gin.H{"type": "string"},
+			"durationMs": gin.H{"type": "integer"},
+			"success":    gin.H{"type": "boolean"},
+			"error":      gin.H{"type": "string", "description": "Why the step failed."},
+		},
+	}
+	schemas["JobCallback"] = gin.H{
+		"description": "What a job posts to its CallbackURL when it finishes: the Job, and what its result answers.",
+		"allOf": []gin.H{
+			{"$ref": "#/components/schemas/Job"},
+			{
+				"type":     "object",
+				"required": []string{"event", "returnCode"},
+				"properties": gin.H{
+					"event":      gin.H{"const": apiCallbackEvent},
+					"returnCode": gin.H{"type": "integer", "description": "200, or the status code of the job's failure."},
+					"message":    gin.H{"type": "string"},
+					"output":     gin.H{"type": "string"},
+					"failedStep": gin.H{"$ref": "#/components/schemas/StepEvent"},
+				},
+			},
+		},
+	}
+	schemas["Screen"] = gin.H{
+		"type":     "object",
+		"required": []string{"jobId", "step", "rows", "columns", "cursorRow", "cursorColumn", "lines", "fields"},
+		"properties": gin.H{
+			"jobId":        gin.H{"type": "string"},
+			"session":      gin.H{"type": "string", "description": "The named session read; missing for the main session."},
+			"step":         gin.H{"type": "integer", "description": "The step running, numbered from 1."},
+			"stepType":     gin.H{"type": "string"},
+			"rows":         gin.H{"type": "integer"},
+			"columns":      gin.H{"type": "integer"},
+			"cursorRow":    gin.H{"type": "integer", "description": "1-based."},
+			"cursorColumn": gin.H{"type": "integer", "description": "1-based."},
+			"lines":        gin.H{"type": "array", "items": gin.H{"type": "string"}, "description": "Text of every row."},
+			"fields":       gin.H{"type": "array", "items": gin.H{"$ref": "#/components/schemas/ScreenField"}},
+		},
+	}
+	schemas["ScreenField"] = gin.H{
+		"type":        "object",
+		"description": "A 3270 field. Row and column locate the first character after the field attribute.",
+		"required":    []string{"row", "column", "length", "text", "protected", "numeric", "hidden", "intensified", "modified"},
+		"properties": gin.H{
+			"row":         gin.H{"type": "integer"},
+			"column":      gin.H{"type": "integer"},
+			"length":      gin.H{"type": "integer"},
+			"text":        gin.H{"type": "string"},
+			"protected":   gin.H{"type": "boolean"},
+			"numeric":     gin.H{"type": "boolean"},
+			"hidden":      gin.H{"type": "boolean"},
+			"intensified": gin.H{"type": "boolean"},
+			"modified":    gin.H{"type": "boolean"},
+			"color":       gin.H{"type": "string"},
+			"highlight":   gin.H{"type": "string"},
+		},
+	}
+
+	jobID := gin.H{"name": "id", "in": "path", "required": true, "schema": gin.H{"type": "string"}, "description": "The jobId POST /jobs answered with."}
+	workflowBody := gin.H{"required": true, "content": jsonContent("Workflow")}
+	busy := apiResponse("All workers are busy and the queue is full, or the client went over its rate limit. Retry-After says when to try again.", "Error")
+	busy["headers"] = gin.H{"Retry-After": gin.H{"schema": gin.H{"type": "integer"}, "description": "Seconds to wait."}}
+	notFound := apiResponse("No such job, or it finished too long ago.", "Error")
+
+	doc := gin.H{
+		"openapi": "3.1.0",
+		"info": gin.H{
+			"title":       "3270Connect API",
+			"version":     version,
+			"description": "Runs 3270Connect workflows against TN3270 hosts, at once or as asynchronous jobs. This is version 1 of the API, under /api/v1; the same routes under /api, without a version, are kept for older clients and answer exactly as v1 does.",
+		},
+		"servers": []gin.H{{"url": "/api/v1"}},
+		"paths": gin.H{
+			"/execute": gin.H{"post": gin.H{
+				"operationId": "executeWorkflow",
+				"summary":     "Run a workflow and answer with its output",
+				"description": "The request waits in the queue for a free worker for as long as the client does.",
+				"requestBody": workflowBody,
+				"responses": gin.H{
+					"200": apiResponse("The workflow ran.", "Result"),
+					"400": apiResponse("The workflow is not valid.", "Error"),
+					"429": busy,
+					"500": apiResponse("The workflow failed.", "Error"),
+				},
+			}},
+			"/jobs": gin.H{"post": gin.H{
+				"operationId": "createJob",
+				"summary":     "Queue a workflow as a job",
+				"parameters": []gin.H{{
+					"name": idempotencyKeyHeader, "in": "header", "schema": gin.H{"type": "string", "maxLength": maxIdempotencyKey},
+					"description": "Makes retrying safe: while the job is kept, the same key with the same workflow answers with that job instead of running the workflow again.",
+				}},
+				"requestBody": workflowBody,
+				"callbacks": gin.H{"jobFinished": gin.H{"{$request.body#/CallbackURL}": gin.H{"post": gin.H{
+					"summary": "The job has finished",
+					"description": "Sent when the workflow has a CallbackURL, and tried again with growing waits while the receiver answers 429 or 5xx or cannot be reached. " +
+						"With -api-callback-secret, X-3270Connect-Signature is sha256= and the hex HMAC-SHA256 of X-3270Connect-Timestamp, a dot and the body.",
+					"parameters": []gin.H{
+						{"name": "X-3270Connect-Event", "in": "header", "schema": gin.H{"const": apiCallbackEvent}},
+						{"name": "X-3270Connect-Delivery", "in": "header", "schema": gin.H{"type": "string"}, "description": "The job's ID."},
+						{"name": "X-3270Connect-Timestamp", "in": "header", "schema": gin.H{"type": "string"}, "description": "Unix time of the post."},
+						{"name": "X-3270Connect-Signature", "in": "header", "schema": gin.H{"type": "string"}},
+					},
+					"requestBody": gin.H{"required": true, "content": jsonContent("JobCallback")},
+					"responses":   gin.H{"2XX": gin.H{"description": "The callback was received."}},
+				}}}},
+				"responses": gin.H{
+					"202": withHeader(withHeader(apiResponse("The job is queued, or was already by an earlier submission with the Idempotency-Key.", "Job"), "Location", "Where to poll the job."), "Idempotent-Replayed", "true when the Idempotency-Key answered with an earlier job."),
+					"400": apiResponse("The workflow or the Idempotency-Key is not valid.", "Error"),
+					"422": apiResponse("The Idempotency-Key was used for a different workflow.", "Error"),
+					"429": busy,
+				},
+			}},
+			"/jobs/{id}": gin.H{
+				"parameters": []gin.H{jobID},
+				"get": gin.H{
+					"operationId": "getJob",
+					"summary":     "Poll a job",
+					"responses": gin.H{
+						"200": apiResponse("The job's status.", "Job"),
+						"404": notFound,
+					},
+				},
+				"delete": gin.H{
+					"operationId": "cancelJob",
+					"summary":     "Cancel a job, disconnecting its sessions",
+					"responses": gin.H{
+						"200": apiResponse("The job is cancelled.", "Job"),
+						"202": apiResponse("The job is still disconnecting; poll it to tell when it is done.", "Job"),
+						"404": notFound,
+						"409": apiResponse("The job has already finished.", "Error"),
+					},
+				},
+			},
+			"/jobs/{id}/result": gin.H{
+				"parameters": []gin.H{jobID},
+				"get": gin.H{
+					"operationId": "getJobResult",
+					"summary":     "Collect the result of a finished job",
+					"description": "A job that failed or was cancelled answers 200 with an Error body whose returnCode says how it ended.",
+					"responses": gin.H{
+						"200": gin.H{"description": "The job has finished.", "content": gin.H{"application/json": gin.H{"schema": gin.H{"oneOf": []gin.H{
+							{"$ref": "#/components/schemas/Result"},
+							{"$ref": "#/components/schemas/Error"},
+						}}}}},
+						"404": notFound,
+						"409": apiResponse("The job has not finished.", "Error"),
+					},
+				},
+			},
+			"/jobs/{id}/events": gin.H{
+				"parameters": []gin.H{jobID},
+				"get": gin.H{
+					"operationId": "streamJobEvents",
+					"summary":     "Follow a job's progress as Server-Sent Events",
+					"description": "Sends a `status` event with the Job each time its status changes, and a `step` event with a StepEvent after each step. The stream ends after the status event of the finished job. Event IDs count from 1.",
+					"parameters": []gin.H{{
+						"name": "Last-Event-ID", "in": "header", "schema": gin.H{"type": "integer"},
+						"description": "The last event received, to get only those after it when reconnecting.",
+					}},
+					"responses": gin.H{
+						"200": gin.H{"description": "The events.", "content": gin.H{"text/event-stream": gin.H{"schema": gin.H{"type": "string"}}}},
+						"404": notFound,
+					},
+				},
+			},
+			"/sessions/{id}/screen": gin.H{
+				"parameters": []gin.H{jobID},
+				"get": gin.H{
+					"operationId": "getSessionScreen",
+					"summary":     "Read the screen of a running job",
+					"parameters": []gin.H{{
+						"name": "session", "in": "query", "schema": gin.H{"type": "string"},
+						"description": "A session named in the workflow's Sessions; the main session when missing.",
+					}},
+					"responses": gin.H{
+						"200": apiResponse("The screen.", "Screen"),
+						"404": apiResponse("No such job, or the session has not connected.", "Error"),
+						"409": apiResponse("The job is not running, or the session is not open.", "Error"),
+					},
+				},
+			},
+		},
+		"components": gin.H{"schemas": schemas},
+	}
+	if apiAuth != nil {
+		security := []gin.H{}
+		schemes := gin.H{}
+		if len(apiAuth.keys) > 0 {
+			schemes["apiKey"] = gin.H{"type": "apiKey", "in": "header", "name": "X-API-Key"}
+			security = append(security, gin.H{"apiKey": []string{}})
+		}
+		if apiAuth.jwtSecret != nil || apiAuth.jwtKey != nil {
+			schemes["bearer"] = gin.H{"type": "http", "scheme": "bearer", "bearerFormat": "JWT"}
+			security = append(security, gin.H{"bearer": []string{}})
+		}
+		doc["components"].(gin.H)["securitySchemes"] = schemes
+		doc["security"] = security
+		for _, item := range doc["paths"].(gin.H) {
+			for _, op := range item.(gin.H) {
+				if op, ok := op.(gin.H); ok {
+					op["responses"].(gin.H)["401"] = apiResponse("No valid API key or token.", "Error")
+				}
+			}
+		}
+	}
+	return doc
+}
+
+func jsonContent(schema string) gin.H {
+	return gin.H{"application/json": gin.H{"schema": gin.H{"$ref": "#/components/schemas/" + schema}}}
+}
+
+func apiResponse(description, schema string) gin.H {
+	return gin.H{"description": description, "content": jsonContent(schema)}
+}
+
+func withHeader(response gin.H, name, description string) gin.H {
+	headers, _ := response["headers"].(gin.H)
+	if headers == nil {
+		headers = gin.H{}
+		response["headers"] = headers
+	}
+	headers[name] = gin.H{"schema": gin.H{"type": "string"}, "description": description}
+	return response
+}
+
+// moveSchemaRefs points the $defs references of the workflow schema at the
+// component schemas they become.
+func moveSchemaRefs(v any) any {
+	switch v := v.(type) {
+	case map[string]any:
+		for key, value := range v {
+			if ref, ok := value.(string); key == "$ref" && ok {
+				v[key] = strings.Replace(ref, "#/$defs/", "#/components/schemas/", 1)
+				continue
+			}
+			v[key] = moveSchemaRefs(value)
+		}
+	case []any:
+		for i, value := range v {
+			v[i] = moveSchemaRefs(value)
+		}
+	}
+	return v
+}
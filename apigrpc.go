@@ -0,0 +1,536 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	connect3270 "github.com/3270io/3270Connect/connect3270"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+var apiGRPCPort int
+
+func init() {
+	flag.IntVar(&apiGRPCPort, "api-grpc-port", 0, "Also serve the API over gRPC on this port, on the -api-bind host; see docs/connect3270.proto (0 turns it off)")
+}
+
+const (
+	// grpcMaxMessage is the largest request message taken, as in grpc-go.
+	grpcMaxMessage = 4 << 20
+	// grpcScreenInterval is how often StreamScreen looks for a new screen.
+	grpcScreenInterval = 250 * time.Millisecond
+	grpcService        = "/connect3270.v1.Connect3270/"
+)
+
+// gRPC status codes.
+const (
+	grpcOK                 = 0
+	grpcCancelled          = 1
+	grpcUnknown            = 2
+	grpcInvalidArgument    = 3
+	grpcDeadlineExceeded   = 4
+	grpcNotFound           = 5
+	grpcAlreadyExists      = 6
+	grpcResourceExhausted  = 8
+	grpcFailedPrecondition = 9
+	grpcUnimplemented      = 12
+	grpcInternal           = 13
+	grpcUnavailable        = 14
+	grpcUnauthenticated    = 16
+)
+
+// ManageSession actions and Job statuses of docs/connect3270.proto.
+const (
+	grpcActionStart  = 1
+	grpcActionStatus = 2
+	grpcActionCancel = 3
+)
+
+var grpcJobStatuses = map[string]uint64{
+	jobQueued:    1,
+	jobRunning:   2,
+	jobSucceeded: 3,
+	jobFailed:    4,
+	jobCancelled: 5,
+}
+
+// setupAPIGRPC checks -api-grpc-port.
+func setupAPIGRPC() error {
+	if apiGRPCPort < 0 || apiGRPCPort > 65535 {
+		return fmt.Errorf("-api-grpc-port %d is not a port number", apiGRPCPort)
+	}
+	if apiGRPCPort != 0 && apiGRPCPort == apiPort {
+		return fmt.Errorf("-api-grpc-port %d is the port of the REST API", apiGRPCPort)
+	}
+	return nil
+}
+
+// serveAPIGRPC serves the gRPC service until it fails. Without TLS it
+// takes HTTP/2 without TLS (h2c), as gRPC clients send it.
+func serveAPIGRPC() {
+	host, _, _ := net.SplitHostPort(apiListenAddr)
+	addr := net.JoinHostPort(host, strconv.Itoa(apiGRPCPort))
+	server := &http.Server{Addr: addr, Handler: h2c.NewHandler(http.HandlerFunc(serveGRPC), &http2.Server{})}
+	serve := server.ListenAndServe
+	if apiTLS != nil {
+		server.Handler = http.HandlerFunc(serveGRPC)
+		server.TLSConfig = apiTLS.Clone()
+		serve = func() error { return server.ListenAndServeTLS("", "") }
+	}
+	pterm.Success.Printf("gRPC API listening on %s\n", addr)
+	if err := serve(); err != nil {
+		pterm.Error.Printf("gRPC API server failed: %v\n", err)
+	}
+}
+
+// grpcError is a call's gRPC status other than OK.
+type grpcError struct {
+	code    int
+	message string
+}
+
+func (e *grpcError) Error() string { return e.message }
+
+func grpcErrorf(code int, format string, args ...any) error {
+	return &grpcError{code: code, message: fmt.Sprintf(format, args...)}
+}
+
+// grpcStatusOf maps the HTTP status an API workflow ended with to a gRPC
+// status.
+func grpcStatusOf(outcome apiOutcome) error {
+	code := grpcUnknown
+	switch {
+	case errors.Is(outcome.err, errJobCancelled):
+		code = grpcCancelled
+	case outcome.code == http.StatusBadRequest:
+		code = grpcInvalidArgument
+	case outcome.code == http.StatusServiceUnavailable:
+		code = grpcUnavailable
+	case outcome.code == http.StatusInternalServerError:
+		code = grpcInternal
+	}
+	return grpcErrorf(code, "%s: %v", outcome.message, outcome.err)
+}
+
+// grpcCall is one call of the gRPC service.
+type grpcCall struct {
+	w http.ResponseWriter
+	r *http.Request
+	// client is the name of the authenticated client; "" without
+	// authentication.
+	client string
+}
+
+// receive reads the request message; every method takes exactly one.
+func (c *grpcCall) receive() ([]byte, error) {
+	var prefix [5]byte
+	if _, err := io.ReadFull(c.r.Body, prefix[:]); err != nil {
+		return nil, grpcErrorf(grpcInvalidArgument, "reading request: %v", err)
+	}
+	if prefix[0] != 0 {
+		return nil, grpcErrorf(grpcUnimplemented, "compressed messages are not supported")
+	}
+	size := binary.BigEndian.Uint32(prefix[1:])
+	if size > grpcMaxMessage {
+		return nil, grpcErrorf(grpcResourceExhausted, "request of %d bytes is over %d", size, grpcMaxMessage)
+	}
+	message := make([]byte, size)
+	if _, err := io.ReadFull(c.r.Body, message); err != nil {
+		return nil, grpcErrorf(grpcInvalidArgument, "reading request: %v", err)
+	}
+	return message, nil
+}
+
+// send writes a response message and flushes it, so a client that is not
+// reading holds the call up through HTTP/2 flow control.
+func (c *grpcCall) send(message []byte) error {
+	frame := make([]byte, 5, 5+len(message))
+	binary.BigEndian.PutUint32(frame[1:], uint32(len(message)))
+	if _, err := c.w.Write(append(frame, message...)); err != nil {
+		return err
+	}
+	c.w.(http.Flusher).Flush()
+	return nil
+}
+
+// grpcMethods are the methods of the service, by the last element of their
+// paths.
+var grpcMethods = map[string]func(ctx context.Context, c *grpcCall, request []byte) error{
+	"ExecuteWorkflow": grpcExecuteWorkflow,
+	"StreamScreen":    grpcStreamScreen,
+	"ManageSession":   grpcManageSession,
+}
+
+// serveGRPC answers a gRPC call, with the same credentials and rate limits
+// as the REST API.
+func serveGRPC(w http.ResponseWriter, r *http.Request) {
+	if r.ProtoMajor != 2 || r.Method != http.MethodPost || !strings.HasPrefix(r.Header.Get("Content-Type"), "application/grpc") {
+		http.Error(w, "this port only serves gRPC", http.StatusUnsupportedMediaType)
+		return
+	}
+	done := apiServerMetrics.grpcStarted(r.URL.Path)
+	w.Header().Set("Content-Type", "application/grpc")
+	w.Header().Set("Trailer", "Grpc-Status, Grpc-Message")
+	w.WriteHeader(http.StatusOK)
+	w.(http.Flusher).Flush()
+	err := handleGRPC(&grpcCall{w: w, r: r})
+	code, message := grpcOK, ""
+	if err != nil {
+		var status *grpcError
+		if !errors.As(err, &status) {
+			status = &grpcError{code: grpcUnknown, message: err.Error()}
+		}
+		code, message = status.code, status.message
+		apiLog.Debug(fmt.Sprintf("gRPC %s from %s: status %d: %s", r.URL.Path, r.RemoteAddr, code, message))
+	}
+	w.Header().Set("Grpc-Status", strconv.Itoa(code))
+	if message != "" {
+		w.Header().Set("Grpc-Message", grpcPercentEncode(message))
+	}
+	done(code)
+}
+
+func handleGRPC(c *grpcCall) error {
+	method, ok := grpcMethods[strings.TrimPrefix(c.r.URL.Path, grpcService)]
+	if !ok || !strings.HasPrefix(c.r.URL.Path, grpcService) {
+		return grpcErrorf(grpcUnimplemented, "unknown method %s", c.r.URL.Path)
+	}
+	if apiAuth != nil {
+		now := time.Now()
+		client, err := apiAuth.authenticate(c.r, now)
+		if err != nil {
+			apiLog.Warn(fmt.Sprintf("Refused gRPC %s from %s: %v", c.r.URL.Path, c.r.RemoteAddr, err))
+			return grpcErrorf(grpcUnauthenticated, "authentication required: %v", err)
+		}
+		if _, ok := apiAuth.limiter.allow(client.name, client.rate, now); !ok {
+			return grpcErrorf(grpcResourceExhausted, "rate limit exceeded: %s may send %d requests a minute", client.name, client.rate)
+		}
+		c.client = client.name
+	}
+	ctx := c.r.Context()
+	if timeout, ok := parseGRPCTimeout(c.r.Header.Get("Grpc-Timeout")); ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	request, err := c.receive()
+	if err != nil {
+		return err
+	}
+	return method(ctx, c, request)
+}
+
+// grpcContextError is the status of a call whose context ended.
+func grpcContextError(ctx context.Context) error {
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return grpcErrorf(grpcDeadlineExceeded, "deadline exceeded")
+	}
+	return grpcErrorf(grpcCancelled, "call cancelled")
+}
+
+// parseGRPCWorkflow reads the workflow of a request.
+func parseGRPCWorkflow(workflowJSON string) (*Configuration, error) {
+	config, message, err := parseAPIWorkflow([]byte(workflowJSON))
+	if err != nil {
+		var issues []string
+		for _, issue := range apiWorkflowIssues([]byte(workflowJSON), err) {
+			issues = append(issues, issue.format("workflow_json"))
+		}
+		return nil, grpcErrorf(grpcInvalidArgument, "%s: %s", message, strings.Join(issues, "; "))
+	}
+	return config, nil
+}
+
+// grpcBusy is the status of a workflow apiWorkers has no room for.
+func grpcBusy() error {
+	running, queued := apiWorkers.load()
+	apiLog.Warn(fmt.Sprintf("Refused a gRPC workflow: %d workflows running and %d queued", running, queued))
+	return grpcErrorf(grpcResourceExhausted, "%v: %d running, %d queued", errAPIBusy, running, queued)
+}
+
+func grpcExecuteWorkflow(ctx context.Context, c *grpcCall, request []byte) error {
+	var workflowJSON string
+	if err := protoFields(request, func(num protowire.Number, data []byte, _ uint64) {
+		if num == 1 {
+			workflowJSON = string(data)
+		}
+	}); err != nil {
+		return err
+	}
+	config, err := parseGRPCWorkflow(workflowJSON)
+	if err != nil {
+		return err
+	}
+	if !apiWorkers.admit() {
+		return grpcBusy()
+	}
+	worker, err := apiWorkers.start(ctx)
+	if err != nil {
+		return grpcContextError(ctx)
+	}
+	defer apiWorkers.done(worker)
+	outcome := executeAPIWorkflow(connect3270.ShutdownContext(), config, apiWorkers.scriptPort(worker), nil)
+	if outcome.err != nil {
+		return grpcStatusOf(outcome)
+	}
+	return c.send(protoMessage{}.str(1, outcome.output))
+}
+
+func grpcManageSession(_ context.Context, c *grpcCall, request []byte) error {
+	var action uint64
+	var jobID, workflowJSON string
+	if err := protoFields(request, func(num protowire.Number, data []byte, value uint64) {
+		switch num {
+		case 1:
+			action = value
+		case 2:
+			jobID = string(data)
+		case 3:
+			workflowJSON = string(data)
+		}
+	}); err != nil {
+		return err
+	}
+	if action == grpcActionStart {
+		// Idempotency-Key comes as idempotency-key metadata.
+		key := c.r.Header.Get(idempotencyKeyHeader)
+		if err := checkIdempotencyKey(key); err != nil {
+			return grpcErrorf(grpcInvalidArgument, "%v", err)
+		}
+		config, err := parseGRPCWorkflow(workflowJSON)
+		if err != nil {
+			return err
+		}
+		job, _, err := submitJob(config, "/api/v1", c.client, key)
+		switch {
+		case errors.Is(err, errAPIBusy):
+			return grpcBusy()
+		case errors.Is(err, errIdempotencyKeyReused):
+			return grpcErrorf(grpcAlreadyExists, "%v", err)
+		case err != nil:
+			return grpcErrorf(grpcInternal, "starting the job: %v", err)
+		}
+		return c.send(grpcJob(job))
+	}
+	job := apiJobs.get(jobID)
+	switch {
+	case action != grpcActionStatus && action != grpcActionCancel:
+		return grpcErrorf(grpcInvalidArgument, "unknown action %d", action)
+	case job == nil:
+		return grpcErrorf(grpcNotFound, "no job %s, or it finished over %s ago", jobID, apiJobRetention)
+	case action == grpcActionCancel:
+		if _, finished := job.done(); finished {
+			return grpcErrorf(grpcFailedPrecondition, "job %s %s", job.id, job.view().Status)
+		}
+		// A job still disconnecting answers as cancelled later.
+		job.stop()
+	}
+	return c.send(grpcJob(job))
+}
+
+func grpcStreamScreen(ctx context.Context, c *grpcCall, request []byte) error {
+	var jobID, session string
+	if err := protoFields(request, func(num protowire.Number, data []byte, _ uint64) {
+		switch num {
+		case 1:
+			jobID = string(data)
+		case 2:
+			session = string(data)
+		}
+	}); err != nil {
+		return err
+	}
+	job := apiJobs.get(jobID)
+	if job == nil {
+		return grpcErrorf(grpcNotFound, "no job %s, or it finished over %s ago", jobID, apiJobRetention)
+	}
+	ticker := time.NewTicker(grpcScreenInterval)
+	defer ticker.Stop()
+	var last *connect3270.Screen
+	for {
+		// Until the session connects there is nothing to send; after it
+		// disconnects the stream ends with the job.
+		screen, err := job.readScreen(session)
+		if err == nil && !reflect.DeepEqual(screen, last) {
+			v := job.view()
+			if err := c.send(grpcScreen(job.id, session, v, screen)); err != nil {
+				return grpcContextError(ctx)
+			}
+			last = screen
+		}
+		if _, finished := job.done(); finished {
+			if last == nil {
+				return grpcErrorf(grpcFailedPrecondition, "job %s %s without a screen to send", job.id, job.view().Status)
+			}
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return grpcContextError(ctx)
+		case <-ticker.C:
+		case <-job.ended:
+		}
+	}
+}
+
+// grpcJob is the Job message of job.
+func grpcJob(job *apiJob) []byte {
+	v := job.view()
+	m := protoMessage{}.
+		str(1, v.JobID).
+		varint(2, grpcJobStatuses[v.Status]).
+		integer(3, v.Step).
+		integer(4, v.Steps).
+		str(5, v.StepType).
+		timestamp(6, &v.CreatedAt).
+		timestamp(7, v.StartedAt).
+		timestamp(8, v.FinishedAt).
+		str(9, v.Error)
+	if outcome, finished := job.done(); finished && outcome.err == nil {
+		m = m.str(10, outcome.output)
+	}
+	return m
+}
+
+// grpcScreen is the Screen message of a job's session.
+func grpcScreen(jobID, session string, v apiJobStatus, screen *connect3270.Screen) []byte {
+	m := protoMessage{}.
+		str(1, jobID).
+		str(2, session).
+		integer(3, v.Step).
+		str(4, v.StepType).
+		integer(5, screen.Rows).
+		integer(6, screen.Columns).
+		integer(7, screen.CursorRow).
+		integer(8, screen.CursorColumn)
+	for _, line := range screen.Lines {
+		m = protowire.AppendString(protowire.AppendTag(m, 9, protowire.BytesType), line)
+	}
+	for _, f := range screen.Fields {
+		m = m.message(10, protoMessage{}.
+			integer(1, f.Row).
+			integer(2, f.Column).
+			integer(3, f.Length).
+			str(4, f.Text).
+			flag(5, f.Protected).
+			flag(6, f.Numeric).
+			flag(7, f.Hidden).
+			flag(8, f.Intensified).
+			flag(9, f.Modified).
+			str(10, f.Color).
+			str(11, f.Highlight))
+	}
+	return m
+}
+
+// protoMessage builds a protobuf message, leaving out fields with zero
+// values as proto3 does.
+type protoMessage []byte
+
+func (m protoMessage) str(num protowire.Number, s string) protoMessage {
+	if s == "" {
+		return m
+	}
+	return protowire.AppendString(protowire.AppendTag(m, num, protowire.BytesType), s)
+}
+
+func (m protoMessage) varint(num protowire.Number, v uint64) protoMessage {
+	if v == 0 {
+		return m
+	}
+	return protowire.AppendVarint(protowire.AppendTag(m, num, protowire.VarintType), v)
+}
+
+func (m protoMessage) integer(num protowire.Number, v int) protoMessage {
+	return m.varint(num, uint64(int64(v)))
+}
+
+func (m protoMessage) flag(num protowire.Number, v bool) protoMessage {
+	return m.varint(num, protowire.EncodeBool(v))
+}
+
+func (m protoMessage) message(num protowire.Number, sub protoMessage) protoMessage {
+	return protowire.AppendBytes(protowire.AppendTag(m, num, protowire.BytesType), sub)
+}
+
+// timestamp adds a google.protobuf.Timestamp, unless t is nil.
+func (m protoMessage) timestamp(num protowire.Number, t *time.Time) protoMessage {
+	if t == nil {
+		return m
+	}
+	return m.message(num, protoMessage{}.varint(1, uint64(t.Unix())).integer(2, t.Nanosecond()))
+}
+
+// protoFields calls field with each field of a protobuf message: the
+// contents of length-delimited fields, or the value of varints.
+func protoFields(data []byte, field func(num protowire.Number, data []byte, value uint64)) error {
+	for len(data) > 0 {
+		num, typ, n := protowire.ConsumeTag(data)
+		if n < 0 {
+			return grpcErrorf(grpcInvalidArgument, "bad request message: %v", protowire.ParseError(n))
+		}
+		data = data[n:]
+		switch typ {
+		case protowire.BytesType:
+			v, n := protowire.ConsumeBytes(data)
+			if n < 0 {
+				return grpcErrorf(grpcInvalidArgument, "bad request message: %v", protowire.ParseError(n))
+			}
+			field(num, v, 0)
+			data = data[n:]
+		case protowire.VarintType:
+			v, n := protowire.ConsumeVarint(data)
+			if n < 0 {
+				return grpcErrorf(grpcInvalidArgument, "bad request message: %v", protowire.ParseError(n))
+			}
+			field(num, nil, v)
+			data = data[n:]
+		default:
+			n := protowire.ConsumeFieldValue(num, typ, data)
+			if n < 0 {
+				return grpcErrorf(grpcInvalidArgument, "bad request message: %v", protowire.ParseError(n))
+			}
+			data = data[n:]
+		}
+	}
+	return nil
+}
+
+// parseGRPCTimeout reads a grpc-timeout header such as "30S" or "500m".
+func parseGRPCTimeout(s string) (time.Duration, bool) {
+	if len(s) < 2 {
+		return 0, false
+	}
+	units := map[byte]time.Duration{'H': time.Hour, 'M': time.Minute, 'S': time.Second, 'm': time.Millisecond, 'u': time.Microsecond, 'n': time.Nanosecond}
+	unit, ok := units[s[len(s)-1]]
+	n, err := strconv.ParseInt(s[:len(s)-1], 10, 64)
+	if !ok || err != nil || n < 0 {
+		return 0, false
+	}
+	return time.Duration(n) * unit, true
+}
+
+// grpcPercentEncode encodes a grpc-message header value.
+func grpcPercentEncode(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if c := s[i]; c < ' ' || c > '~' || c == '%' {
+			fmt.Fprintf(&b, "%%%02X", c)
+		} else {
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
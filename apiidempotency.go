@@ -0,0 +1,120 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// idempotencyKeyHeader names the header, and the gRPC metadata, whose
+	// value makes submitting a job again safe.
+	idempotencyKeyHeader = "Idempotency-Key"
+	maxIdempotencyKey    = 255
+	// apiClientKey is where the authentication middleware leaves the name
+	// of the client in the gin context.
+	apiClientKey = "apiClient"
+)
+
+// errIdempotencyKeyReused means a key came back with another workflow.
+var errIdempotencyKeyReused = errors.New("the Idempotency-Key was already used for a different workflow")
+
+// keyedJob is the job an Idempotency-Key started, and a digest of its
+// workflow.
+type keyedJob struct {
+	job         *apiJob
+	fingerprint [sha256.Size]byte
+}
+
+// idempotencyKeys holds the jobs started with an Idempotency-Key, by the
+// client and the key, for as long as the jobs themselves are kept.
+// submitMu makes a submission and its retries start one job between them
+// even when they arrive together.
+type idempotencyKeys struct {
+	submitMu sync.Mutex
+	mu       sync.Mutex
+	jobs     map[string]keyedJob
+}
+
+var apiIdempotencyKeys = &idempotencyKeys{jobs: make(map[string]keyedJob)}
+
+// checkIdempotencyKey checks the Idempotency-Key of a submission: up to
+// 255 printable ASCII characters.
+func checkIdempotencyKey(key string) error {
+	if len(key) > maxIdempotencyKey {
+		return fmt.Errorf("%s is %d characters long, over %d", idempotencyKeyHeader, len(key), maxIdempotencyKey)
+	}
+	for _, r := range key {
+		if r < ' ' || r > '~' {
+			return fmt.Errorf("%s may only hold printable ASCII characters", idempotencyKeyHeader)
+		}
+	}
+	return nil
+}
+
+// submitJob starts config as a job under base, the caller not yet
+// admitted to apiWorkers. With a key it starts the job once: a submission
+// repeating the key of a job still kept answers with that job, and says
+// it was replayed, rather than running the workflow again. client scopes
+// the key, so clients cannot reach each other's jobs through it.
+func submitJob(config *Configuration, base, client, key string) (*apiJob, bool, error) {
+	if key == "" {
+		if !apiWorkers.admit() {
+			return nil, false, errAPIBusy
+		}
+		return apiJobs.start(config, base), false, nil
+	}
+	// The parsed workflow, so that whitespace and the order of properties
+	// do not matter.
+	data, err := json.Marshal(config)
+	if err != nil {
+		return nil, false, err
+	}
+	fingerprint := sha256.Sum256(data)
+	scoped := client + "\x00" + key
+
+	k := apiIdempotencyKeys
+	k.submitMu.Lock()
+	defer k.submitMu.Unlock()
+	k.mu.Lock()
+	previous, ok := k.jobs[scoped]
+	k.mu.Unlock()
+	if ok {
+		if previous.fingerprint != fingerprint {
+			return nil, false, errIdempotencyKeyReused
+		}
+		return previous.job, true, nil
+	}
+	if !apiWorkers.admit() {
+		return nil, false, errAPIBusy
+	}
+	job := apiJobs.start(config, base)
+	k.mu.Lock()
+	k.jobs[scoped] = keyedJob{job: job, fingerprint: fingerprint}
+	k.mu.Unlock()
+	return job, false, nil
+}
+
+// forget drops the keys of the jobs the job store has forgotten.
+func (k *idempotencyKeys) forget(forgotten map[string]bool) {
+	if len(forgotten) == 0 {
+		return
+	}
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	for scoped, keyed := range k.jobs {
+		if forgotten[keyed.job.id] {
+			delete(k.jobs, scoped)
+		}
+	}
+}
+
+// apiClientName is the client the authentication middleware let through;
+// "" when the API has no authentication.
+func apiClientName(c *gin.Context) string {
+	return c.GetString(apiClientKey)
+}
@@ -0,0 +1,609 @@
+package main
+
+import (
+	"context"
+	crand "crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	connect3270 "github.com/3270io/3270Connect/connect3270"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// apiJobRetention is how long a finished job stays around to be polled.
+	apiJobRetention = time.Hour
+	// apiCancelWait is how long DELETE /api/jobs/{id} waits for the job to
+	// disconnect before it answers.
+	apiCancelWait = 10 * time.Second
+	// apiEventKeepalive is how often GET /api/jobs/{id}/events sends a
+	// comment while nothing happens, so proxies keep the stream open.
+	apiEventKeepalive = 15 * time.Second
+)
+
+var (
+	// errJobCancelled is why a cancelled job stopped.
+	errJobCancelled = errors.New("job cancelled")
+	// errSessionClosed and errNoSession are why a job's screen cannot be
+	// read: the job is not running, or has no session by that name.
+	errSessionClosed = errors.New("session is not open")
+	errNoSession     = errors.New("no such session")
+)
+
+// API job states.
+const (
+	jobQueued    = "queued"
+	jobRunning   = "running"
+	jobSucceeded = "succeeded"
+	jobFailed    = "failed"
+	jobCancelled = "cancelled"
+)
+
+// apiJob is a workflow submitted to POST /api/jobs, which runs it in the
+// background while the caller polls for its status and result.
+type apiJob struct {
+	id string
+	// base is the API version the job was started under, such as
+	// "/api/v1", which its links keep to.
+	base   string
+	ctx    context.Context
+	cancel context.CancelCauseFunc
+	// ended is closed when the job has finished.
+	ended chan struct{}
+
+	mu       sync.Mutex
+	status   string
+	steps    int
+	step     int
+	stepType string
+	created  time.Time
+	started  time.Time
+	finished time.Time
+	outcome  apiOutcome
+	// events are the job's progress so far, which GET
+	// /api/jobs/{id}/events streams; changed is closed and replaced when
+	// one is added.
+	events  []apiJobEvent
+	changed chan struct{}
+
+	// sessionsMu guards the emulators screen reads use: "" is the main
+	// session, other names those of the workflow's Sessions. Emulators
+	// cannot be read while they connect or disconnect, so the workflow
+	// holds the lock for those steps.
+	sessionsMu sync.RWMutex
+	sessions   map[string]*connect3270.Emulator
+}
+
+// apiJobStatus is what GET /api/jobs/{id} answers with.
+type apiJobStatus struct {
+	JobID  string `json:"jobId"`
+	Status string `json:"status"`
+	// Step is the step running, or the last one run, numbered from 1 out
+	// of Steps.
+	Step       int        `json:"step"`
+	Steps      int        `json:"steps"`
+	StepType   string     `json:"stepType,omitempty"`
+	CreatedAt  time.Time  `json:"createdAt"`
+	StartedAt  *time.Time `json:"startedAt,omitempty"`
+	FinishedAt *time.Time `json:"finishedAt,omitempty"`
+	Error      string     `json:"error,omitempty"`
+	ResultURL  string     `json:"resultUrl"`
+}
+
+// apiJobEvent is a Server-Sent Event of a job: a "status" event carries
+// the job's apiJobStatus, a "step" event an apiStepEvent.
+type apiJobEvent struct {
+	name string
+	data any
+}
+
+// apiStepEvent is how one step of a job went.
+type apiStepEvent struct {
+	Step       int    `json:"step"`
+	Steps      int    `json:"steps"`
+	Type       string `json:"type"`
+	DurationMs int64  `json:"durationMs"`
+	Success    bool   `json:"success"`
+	Error      string `json:"error,omitempty"`
+}
+
+func (j *apiJob) view() apiJobStatus {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.viewLocked()
+}
+
+func (j *apiJob) viewLocked() apiJobStatus {
+	v := apiJobStatus{
+		JobID:     j.id,
+		Status:    j.status,
+		Step:      j.step,
+		Steps:     j.steps,
+		StepType:  j.stepType,
+		CreatedAt: j.created,
+		ResultURL: j.base + "/jobs/" + j.id + "/result",
+	}
+	if !j.started.IsZero() {
+		started := j.started
+		v.StartedAt = &started
+	}
+	if !j.finished.IsZero() {
+		finished := j.finished
+		v.FinishedAt = &finished
+	}
+	if j.outcome.err != nil {
+		v.Error = j.outcome.err.Error()
+	}
+	return v
+}
+
+// done reports whether the job has finished, and how.
+func (j *apiJob) done() (apiOutcome, bool) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.outcome, !j.finished.IsZero()
+}
+
+// publish adds an event of the job; j.mu is held.
+func (j *apiJob) publish(name string, data any) {
+	j.events = append(j.events, apiJobEvent{name: name, data: data})
+	close(j.changed)
+	j.changed = make(chan struct{})
+}
+
+// publishStatus adds a status event; j.mu is held.
+func (j *apiJob) publishStatus() {
+	j.publish("status", j.viewLocked())
+}
+
+// eventsSince returns the events after the first n, the number of events
+// before them, and a channel closed when there are more.
+func (j *apiJob) eventsSince(n int) ([]apiJobEvent, int, <-chan struct{}) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	n = min(max(n, 0), len(j.events))
+	return j.events[n:len(j.events):len(j.events)], n, j.changed
+}
+
+// stepDone notes how step went. Like progress it does nothing on the nil
+// job of /api/execute.
+func (j *apiJob) stepDone(step int, stepType string, took time.Duration, err error) {
+	if j == nil {
+		return
+	}
+	event := apiStepEvent{Step: step, Type: stepType, DurationMs: took.Milliseconds(), Success: err == nil}
+	if err != nil {
+		event.Error = err.Error()
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	event.Steps = j.steps
+	j.publish("step", event)
+}
+
+// stop cancels the job and waits up to apiCancelWait for it to
+// disconnect, reporting whether it has.
+func (j *apiJob) stop() bool {
+	apiLog.Info(fmt.Sprintf("Cancelling job %s", j.id))
+	j.cancel(errJobCancelled)
+	select {
+	case <-j.ended:
+		return true
+	case <-time.After(apiCancelWait):
+		return false
+	}
+}
+
+// progress notes the step the job's workflow has reached. Like the other
+// hooks of executeAPIWorkflow it does nothing on the nil job of
+// /api/execute.
+func (j *apiJob) progress(step int, stepType string) {
+	if j == nil {
+		return
+	}
+	j.mu.Lock()
+	j.step, j.stepType = step, stepType
+	j.mu.Unlock()
+}
+
+// attach lets the screen of e, the workflow's main session, be read.
+func (j *apiJob) attach(e *connect3270.Emulator) {
+	if j == nil {
+		return
+	}
+	j.sessionsMu.Lock()
+	j.sessions = map[string]*connect3270.Emulator{"": e}
+	j.sessionsMu.Unlock()
+}
+
+// detach stops screen reads, once those under way are done, so the
+// workflow can disconnect its sessions.
+func (j *apiJob) detach() {
+	if j == nil {
+		return
+	}
+	j.sessionsMu.Lock()
+	j.sessions = nil
+	j.sessionsMu.Unlock()
+}
+
+// runStep runs step, holding the sessions when it may connect or
+// disconnect one, and then makes the named sessions it opened readable.
+func (j *apiJob) runStep(e *connect3270.Emulator, step Step, state *workflowState) error {
+	if j == nil || !changesConnection(step) {
+		return executeStep(e, step, state)
+	}
+	j.sessionsMu.Lock()
+	defer j.sessionsMu.Unlock()
+	err := executeStep(e, step, state)
+	if j.sessions != nil {
+		for name, session := range state.sessions {
+			j.sessions[name] = session
+		}
+	}
+	return err
+}
+
+// changesConnection reports whether step, or a step inside it, is a
+// Connect or Disconnect.
+func changesConnection(step Step) bool {
+	if step.Type == "Connect" || step.Type == "Disconnect" {
+		return true
+	}
+	for _, inner := range append(append([]Step{}, step.Steps...), step.Else...) {
+		if changesConnection(inner) {
+			return true
+		}
+	}
+	return false
+}
+
+// readScreen reads the screen of the job's session name, "" for the main
+// one, while the job runs.
+func (j *apiJob) readScreen(name string) (*connect3270.Screen, error) {
+	j.sessionsMu.RLock()
+	defer j.sessionsMu.RUnlock()
+	if j.sessions == nil {
+		return nil, errSessionClosed
+	}
+	e := j.sessions[name]
+	if e == nil {
+		return nil, errNoSession
+	}
+	return e.ReadScreen()
+}
+
+// apiScreen is what GET /api/sessions/{id}/screen answers with: the
+// screen as JSONScreenGrab captures it, and where the workflow was.
+type apiScreen struct {
+	JobID    string `json:"jobId"`
+	Session  string `json:"session,omitempty"`
+	Step     int    `json:"step"`
+	StepType string `json:"stepType,omitempty"`
+	*connect3270.Screen
+}
+
+// execute waits in the queue for a worker, then runs the job's workflow.
+func (j *apiJob) execute(config *Configuration) apiOutcome {
+	worker, err := apiWorkers.start(j.ctx)
+	if err != nil {
+		return cancelledOutcome(j.ctx)
+	}
+	defer apiWorkers.done(worker)
+	j.mu.Lock()
+	j.status, j.started = jobRunning, time.Now().UTC()
+	j.publishStatus()
+	j.mu.Unlock()
+	apiLog.Info(fmt.Sprintf("Job %s started", j.id))
+	return executeAPIWorkflow(j.ctx, config, apiWorkers.scriptPort(worker), j)
+}
+
+// run runs the job's workflow, keeping its status current.
+func (j *apiJob) run(config *Configuration) {
+	outcome := j.execute(config)
+	j.mu.Lock()
+	j.outcome, j.finished = outcome, time.Now().UTC()
+	switch {
+	case errors.Is(outcome.err, errJobCancelled):
+		j.status = jobCancelled
+	case outcome.err != nil:
+		j.status = jobFailed
+	default:
+		j.status = jobSucceeded
+	}
+	status, elapsed := j.status, j.finished.Sub(j.started)
+	if j.started.IsZero() {
+		// Cancelled while queued.
+		elapsed = j.finished.Sub(j.created)
+	}
+	j.publishStatus()
+	j.mu.Unlock()
+	j.cancel(nil)
+	close(j.ended)
+	apiServerMetrics.jobFinished(status)
+	apiLog.Info(fmt.Sprintf("Job %s %s after %s", j.id, status, elapsed.Round(time.Millisecond)))
+	if config.CallbackURL != "" {
+		j.postCallback(config.CallbackURL)
+	}
+}
+
+// apiJobStore holds the jobs of the API server by ID.
+type apiJobStore struct {
+	mu   sync.Mutex
+	jobs map[string]*apiJob
+}
+
+var apiJobs = &apiJobStore{jobs: make(map[string]*apiJob)}
+
+// add registers a new job of steps steps, started under the API base,
+// forgetting the jobs that finished more than apiJobRetention ago.
+func (s *apiJobStore) add(steps int, base string) *apiJob {
+	var id [8]byte
+	crand.Read(id[:])
+	now := time.Now().UTC()
+	job := &apiJob{id: hex.EncodeToString(id[:]), base: base, status: jobQueued, steps: steps, created: now, ended: make(chan struct{}), changed: make(chan struct{})}
+	job.publishStatus()
+	job.ctx, job.cancel = context.WithCancelCause(connect3270.ShutdownContext())
+	forgotten := make(map[string]bool)
+	s.mu.Lock()
+	for key, old := range s.jobs {
+		old.mu.Lock()
+		expired := !old.finished.IsZero() && now.Sub(old.finished) > apiJobRetention
+		old.mu.Unlock()
+		if expired {
+			delete(s.jobs, key)
+			forgotten[key] = true
+		}
+	}
+	s.jobs[job.id] = job
+	s.mu.Unlock()
+	apiIdempotencyKeys.forget(forgotten)
+	return job
+}
+
+// start runs config as a new job, which waits in the queue for a worker;
+// the caller has been admitted to apiWorkers.
+func (s *apiJobStore) start(config *Configuration, base string) *apiJob {
+	job := s.add(len(sessionSteps(config, config.Steps)), base)
+	go job.run(config)
+	return job
+}
+
+func (s *apiJobStore) get(id string) *apiJob {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.jobs[id]
+}
+
+// active lists the jobs queued or running, oldest first, for the dashboard.
+func (s *apiJobStore) active() []apiJobStatus {
+	s.mu.Lock()
+	jobs := make([]*apiJob, 0, len(s.jobs))
+	for _, job := range s.jobs {
+		jobs = append(jobs, job)
+	}
+	s.mu.Unlock()
+	var views []apiJobStatus
+	for _, job := range jobs {
+		if v := job.view(); v.FinishedAt == nil {
+			views = append(views, v)
+		}
+	}
+	sort.Slice(views, func(i, k int) bool { return views[i].CreatedAt.Before(views[k].CreatedAt) })
+	return views
+}
+
+// registerJobRoutes adds the asynchronous job API to an API version: POST
+// /jobs takes a workflow as /execute does and answers at once with the
+// job's ID, to poll at GET /jobs/{id} and collect from GET
+// /jobs/{id}/result. The job stays queued until one of the apiWorkers is
+// free. While it runs, GET /sessions/{id}/screen reads its screen.
+func registerJobRoutes(r *gin.RouterGroup) {
+	r.POST("/jobs", func(c *gin.Context) {
+		key := c.GetHeader(idempotencyKeyHeader)
+		if err := checkIdempotencyKey(key); err != nil {
+			sendErrorResponse(c, http.StatusBadRequest, "Invalid Idempotency-Key", err)
+			return
+		}
+		workflowConfig, ok := bindAPIWorkflow(c)
+		if !ok {
+			return
+		}
+		job, replayed, err := submitJob(workflowConfig, r.BasePath(), apiClientName(c), key)
+		switch {
+		case errors.Is(err, errAPIBusy):
+			refuseBusy(c)
+			return
+		case errors.Is(err, errIdempotencyKeyReused):
+			sendErrorResponse(c, http.StatusUnprocessableEntity, "Idempotency-Key reused", err)
+			return
+		case err != nil:
+			sendErrorResponse(c, http.StatusInternalServerError, "Failed to start the job", err)
+			return
+		}
+		if replayed {
+			apiLog.Info(fmt.Sprintf("Job %s submitted again with its Idempotency-Key; not run again", job.id))
+			c.Header("Idempotent-Replayed", "true")
+		}
+		// A replay points where the first submission did.
+		c.Header("Location", job.base+"/jobs/"+job.id)
+		c.JSON(http.StatusAccepted, job.view())
+	})
+	r.GET("/jobs/:id", func(c *gin.Context) {
+		job := apiJobs.get(c.Param("id"))
+		if job == nil {
+			sendErrorResponse(c, http.StatusNotFound, "Job not found", fmt.Errorf("no job %s, or it finished over %s ago", c.Param("id"), apiJobRetention))
+			return
+		}
+		c.JSON(http.StatusOK, job.view())
+	})
+	r.GET("/jobs/:id/result", func(c *gin.Context) {
+		job := apiJobs.get(c.Param("id"))
+		if job == nil {
+			sendErrorResponse(c, http.StatusNotFound, "Job not found", fmt.Errorf("no job %s, or it finished over %s ago", c.Param("id"), apiJobRetention))
+			return
+		}
+		outcome, finished := job.done()
+		if !finished {
+			sendErrorResponse(c, http.StatusConflict, "Job has not finished", fmt.Errorf("job %s is %s", job.id, job.view().Status))
+			return
+		}
+		body := outcome.body()
+		body["jobId"] = job.id
+		c.JSON(http.StatusOK, body)
+	})
+	r.GET("/jobs/:id/events", func(c *gin.Context) {
+		job := apiJobs.get(c.Param("id"))
+		if job == nil {
+			sendErrorResponse(c, http.StatusNotFound, "Job not found", fmt.Errorf("no job %s, or it finished over %s ago", c.Param("id"), apiJobRetention))
+			return
+		}
+		streamJobEvents(c, job)
+	})
+	r.DELETE("/jobs/:id", func(c *gin.Context) {
+		job := apiJobs.get(c.Param("id"))
+		if job == nil {
+			sendErrorResponse(c, http.StatusNotFound, "Job not found", fmt.Errorf("no job %s, or it finished over %s ago", c.Param("id"), apiJobRetention))
+			return
+		}
+		if _, finished := job.done(); finished {
+			sendErrorResponse(c, http.StatusConflict, "Job has already finished", fmt.Errorf("job %s %s", job.id, job.view().Status))
+			return
+		}
+		if job.stop() {
+			c.JSON(http.StatusOK, job.view())
+		} else {
+			// Still disconnecting; polling the job tells when it is done.
+			c.JSON(http.StatusAccepted, job.view())
+		}
+	})
+	r.GET("/sessions/:id/screen", func(c *gin.Context) {
+		job := apiJobs.get(c.Param("id"))
+		if job == nil {
+			sendErrorResponse(c, http.StatusNotFound, "Session not found", fmt.Errorf("no job %s, or it finished over %s ago", c.Param("id"), apiJobRetention))
+			return
+		}
+		name := c.Query("session")
+		screen, err := job.readScreen(name)
+		switch {
+		case errors.Is(err, errSessionClosed):
+			sendErrorResponse(c, http.StatusConflict, "Session is not open", fmt.Errorf("job %s is %s", job.id, job.view().Status))
+			return
+		case errors.Is(err, errNoSession):
+			sendErrorResponse(c, http.StatusNotFound, "Session not found", fmt.Errorf("job %s has not connected session %q", job.id, name))
+			return
+		case err != nil:
+			sendErrorResponse(c, http.StatusConflict, "Screen unavailable", err)
+			return
+		}
+		v := job.view()
+		c.JSON(http.StatusOK, apiScreen{JobID: job.id, Session: name, Step: v.Step, StepType: v.StepType, Screen: screen})
+	})
+}
+
+// streamJobEvents sends the events of job as Server-Sent Events until it
+// finishes. Event IDs count from 1, so a client that reconnects with
+// Last-Event-ID gets only the events it missed.
+func streamJobEvents(c *gin.Context, job *apiJob) {
+	next, _ := strconv.Atoi(c.GetHeader("Last-Event-ID"))
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+	send := func() <-chan struct{} {
+		events, first, changed := job.eventsSince(next)
+		next = first
+		for _, event := range events {
+			data, _ := json.Marshal(event.data)
+			next++
+			fmt.Fprintf(c.Writer, "id: %d\nevent: %s\ndata: %s\n\n", next, event.name, data)
+		}
+		c.Writer.Flush()
+		return changed
+	}
+	keepalive := time.NewTicker(apiEventKeepalive)
+	defer keepalive.Stop()
+	for {
+		changed := send()
+		select {
+		case <-changed:
+		case <-job.ended:
+			// The last status event is in by now.
+			send()
+			return
+		case <-keepalive.C:
+			fmt.Fprint(c.Writer, ": keepalive\n\n")
+		case <-c.Request.Context().Done():
+			return
+		}
+	}
+}
+
+// killJobHandler serves the dashboard's Kill button for an API job: it
+// finds the API server of the process from what it reported and cancels the job
+// with DELETE /api/jobs/{id}.
+func killJobHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	pid, err := strconv.Atoi(r.URL.Query().Get("pid"))
+	jobID := r.URL.Query().Get("job")
+	if err != nil || jobID == "" {
+		http.Error(w, "Missing PID or job", http.StatusBadRequest)
+		return
+	}
+	m, ok := dashboardRuns.get(pid)
+	if !ok || m.APIPort == 0 {
+		http.Error(w, fmt.Sprintf("Process %d is not a running API server", pid), http.StatusNotFound)
+		return
+	}
+	dashboardLog.Info(fmt.Sprintf("Cancelling API job %s of PID %d", jobID, pid))
+	scheme := "http"
+	client := &http.Client{Timeout: apiCancelWait + 5*time.Second}
+	if m.APITLSCert != "" {
+		scheme = "https"
+		if client.Transport, err = pinnedTransport(m.APITLSCert); err != nil {
+			http.Error(w, fmt.Sprintf("Cannot check the certificate of the API server of PID %d: %v", pid, err), http.StatusBadGateway)
+			return
+		}
+	}
+	addr := m.APIAddr
+	if addr == "" {
+		addr = fmt.Sprintf("localhost:%d", m.APIPort)
+	}
+	req, err := http.NewRequest(http.MethodDelete, fmt.Sprintf("%s://%s/api/jobs/%s", scheme, addr, url.PathEscape(jobID)), nil)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if key, err := os.ReadFile(dashboardAPIKeyPath(pid)); err == nil {
+		req.Header.Set("X-API-Key", string(key))
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Cannot reach the API server of PID %d: %v", pid, err), http.StatusBadGateway)
+		return
+	}
+	defer resp.Body.Close()
+	var answer struct {
+		Status  string `json:"status"`
+		Message string `json:"message"`
+		Error   string `json:"error"`
+	}
+	json.NewDecoder(resp.Body).Decode(&answer)
+	if resp.StatusCode >= 300 {
+		http.Error(w, fmt.Sprintf("%s: %s", answer.Message, answer.Error), resp.StatusCode)
+		return
+	}
+	fmt.Fprintf(w, "API job %s %s", jobID, answer.Status)
+}
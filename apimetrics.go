@@ -0,0 +1,225 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// apiMetricsPrefix starts the names of the API server's Prometheus
+// metrics. -metricsPrefix does not apply: it starts with a digit, which
+// Prometheus names cannot.
+const apiMetricsPrefix = "connect3270_api_"
+
+// apiLatencyBuckets are the upper bounds, in seconds, of the request
+// duration histograms. /execute lasts as long as its workflow, so they
+// reach into minutes.
+var apiLatencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 120, 300}
+
+// latencyHistogram counts durations into apiLatencyBuckets.
+type latencyHistogram struct {
+	counts []uint64 // cumulative, one per bucket
+	sum    float64
+	count  uint64
+}
+
+func (h *latencyHistogram) observe(seconds float64) {
+	if h.counts == nil {
+		h.counts = make([]uint64, len(apiLatencyBuckets))
+	}
+	for i, bound := range apiLatencyBuckets {
+		if seconds <= bound {
+			h.counts[i]++
+		}
+	}
+	h.sum += seconds
+	h.count++
+}
+
+// apiMetrics is what the API server has answered, served to Prometheus at
+// /metrics. It is about the service itself; the workflows' own metrics go
+// to -statsd and -influxURL. Series are kept by their rendered labels.
+type apiMetrics struct {
+	mu            sync.Mutex
+	inFlight      int
+	requests      map[string]uint64
+	durations     map[string]*latencyHistogram
+	grpcInFlight  int
+	grpcCalls     map[string]uint64
+	grpcDurations map[string]*latencyHistogram
+	jobsFinished  map[string]uint64
+}
+
+func newAPIMetrics() *apiMetrics {
+	return &apiMetrics{
+		requests:      make(map[string]uint64),
+		durations:     make(map[string]*latencyHistogram),
+		grpcCalls:     make(map[string]uint64),
+		grpcDurations: make(map[string]*latencyHistogram),
+		jobsFinished:  make(map[string]uint64),
+	}
+}
+
+var apiServerMetrics = newAPIMetrics()
+
+var promLabelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// promLabels renders label pairs, given as name, value, name, value...
+func promLabels(pairs ...string) string {
+	var b strings.Builder
+	for i := 0; i+1 < len(pairs); i += 2 {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		fmt.Fprintf(&b, `%s="%s"`, pairs[i], promLabelEscaper.Replace(pairs[i+1]))
+	}
+	return b.String()
+}
+
+// countRequest counts a request or call of labels that answered code.
+func countRequest(requests map[string]uint64, durations map[string]*latencyHistogram, labels, code string, took time.Duration) {
+	requests[labels+","+promLabels("code", code)]++
+	h := durations[labels]
+	if h == nil {
+		h = &latencyHistogram{}
+		durations[labels] = h
+	}
+	h.observe(took.Seconds())
+}
+
+// middleware counts the requests of the REST API by method, route and
+// status. Routes are the router's patterns, such as /api/v1/jobs/:id, so
+// job IDs do not each make a series.
+func (m *apiMetrics) middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		m.mu.Lock()
+		m.inFlight++
+		m.mu.Unlock()
+		start := time.Now()
+		c.Next()
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		m.inFlight--
+		countRequest(m.requests, m.durations, promLabels("method", c.Request.Method, "route", route), strconv.Itoa(c.Writer.Status()), time.Since(start))
+	}
+}
+
+// grpcStarted counts a gRPC call in flight; the function it returns
+// counts it done, by method and gRPC status code.
+func (m *apiMetrics) grpcStarted(path string) func(code int) {
+	method := strings.TrimPrefix(path, grpcService)
+	if _, ok := grpcMethods[method]; !ok || !strings.HasPrefix(path, grpcService) {
+		method = "unknown"
+	}
+	m.mu.Lock()
+	m.grpcInFlight++
+	m.mu.Unlock()
+	start := time.Now()
+	return func(code int) {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		m.grpcInFlight--
+		countRequest(m.grpcCalls, m.grpcDurations, promLabels("method", method), strconv.Itoa(code), time.Since(start))
+	}
+}
+
+// jobFinished counts a job that ended as status.
+func (m *apiMetrics) jobFinished(status string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.jobsFinished[promLabels("status", status)]++
+}
+
+// write writes the metrics in the Prometheus text format, with the
+// workers, the queue and the jobs as they are now.
+func (m *apiMetrics) write(w io.Writer) {
+	running, queued := apiWorkers.load()
+	workers, queue := apiWorkers.capacity()
+	jobs := map[string]int{jobQueued: 0, jobRunning: 0}
+	for _, job := range apiJobs.active() {
+		jobs[job.Status]++
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	gauge := func(name, help string, value float64) {
+		writePromHeader(w, name, help, "gauge")
+		fmt.Fprintf(w, "%s%s %s\n", apiMetricsPrefix, name, formatPromValue(value))
+	}
+	writePromHeader(w, "info", "Version of the API server, as the version label.", "gauge")
+	fmt.Fprintf(w, "%sinfo{%s} 1\n", apiMetricsPrefix, promLabels("version", version))
+	writePromCounter(w, "requests_total", "REST requests answered, by method, route and status code.", m.requests)
+	writePromHistogram(w, "request_duration_seconds", "How long REST requests took to answer, by method and route.", m.durations)
+	gauge("requests_in_flight", "REST requests being answered, including open event streams.", float64(m.inFlight))
+	writePromCounter(w, "grpc_calls_total", "gRPC calls answered, by method and gRPC status code.", m.grpcCalls)
+	writePromHistogram(w, "grpc_call_duration_seconds", "How long gRPC calls took, by method.", m.grpcDurations)
+	gauge("grpc_calls_in_flight", "gRPC calls being answered.", float64(m.grpcInFlight))
+	gauge("workers", "Workflows the API server runs at once (-api-workers).", float64(workers))
+	gauge("workflows_running", "Workflows running, from /execute and jobs.", float64(running))
+	gauge("queue_capacity", "Workflows that can wait for a worker (-api-queue).", float64(queue))
+	gauge("queue_depth", "Workflows waiting for a worker.", float64(queued))
+	writePromHeader(w, "jobs", "Jobs not finished yet, by status.", "gauge")
+	for _, status := range []string{jobQueued, jobRunning} {
+		fmt.Fprintf(w, "%sjobs{%s} %d\n", apiMetricsPrefix, promLabels("status", status), jobs[status])
+	}
+	writePromCounter(w, "jobs_finished_total", "Jobs finished, by status.", m.jobsFinished)
+}
+
+func writePromHeader(w io.Writer, name, help, kind string) {
+	fmt.Fprintf(w, "# HELP %s%s %s\n# TYPE %s%s %s\n", apiMetricsPrefix, name, help, apiMetricsPrefix, name, kind)
+}
+
+func writePromCounter(w io.Writer, name, help string, series map[string]uint64) {
+	writePromHeader(w, name, help, "counter")
+	for _, labels := range sortedKeys(series) {
+		fmt.Fprintf(w, "%s%s{%s} %d\n", apiMetricsPrefix, name, labels, series[labels])
+	}
+}
+
+func writePromHistogram(w io.Writer, name, help string, series map[string]*latencyHistogram) {
+	writePromHeader(w, name, help, "histogram")
+	for _, labels := range sortedKeys(series) {
+		h := series[labels]
+		for i, bound := range apiLatencyBuckets {
+			fmt.Fprintf(w, "%s%s_bucket{%s,%s} %d\n", apiMetricsPrefix, name, labels, promLabels("le", formatPromValue(bound)), h.counts[i])
+		}
+		fmt.Fprintf(w, "%s%s_bucket{%s,%s} %d\n", apiMetricsPrefix, name, labels, promLabels("le", "+Inf"), h.count)
+		fmt.Fprintf(w, "%s%s_sum{%s} %s\n", apiMetricsPrefix, name, labels, formatPromValue(h.sum))
+		fmt.Fprintf(w, "%s%s_count{%s} %d\n", apiMetricsPrefix, name, labels, h.count)
+	}
+}
+
+func formatPromValue(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// registerMetricsRoute serves the metrics at /metrics. It is registered
+// after the authentication middleware, so with -apiKeys or a JWT secret
+// Prometheus scrapes with a key like any other client.
+func registerMetricsRoute(r *gin.Engine) {
+	r.GET("/metrics", func(c *gin.Context) {
+		c.Header("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		c.Status(http.StatusOK)
+		apiServerMetrics.write(c.Writer)
+	})
+}
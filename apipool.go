@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+var (
+	apiWorkerCount int
+	apiQueueDepth  int
+)
+
+func init() {
+	flag.IntVar(&apiWorkerCount, "api-workers", defaultAPIWorkers, "Workflows the API server runs at once; more wait in the queue")
+	flag.IntVar(&apiQueueDepth, "api-queue", defaultAPIQueue, "Workflows the API server queues once all -api-workers are busy; beyond that requests get 429 Too Many Requests")
+}
+
+const (
+	defaultAPIWorkers = 10
+	defaultAPIQueue   = 100
+	// apiBusyRetryAfter is the Retry-After of a request refused because
+	// the queue is full.
+	apiBusyRetryAfter = 5 * time.Second
+)
+
+// apiWorkerPool bounds the workflows the API server runs at once. Each
+// worker keeps its script port label, so requests reuse the same few
+// instead of using up the range.
+type apiWorkerPool struct {
+	free chan int // numbers of the idle workers
+
+	mu       sync.Mutex
+	admitted int // workflows running or waiting for a worker
+	limit    int // workers plus queue depth
+}
+
+func newAPIWorkerPool(workers, queue int) *apiWorkerPool {
+	p := &apiWorkerPool{free: make(chan int, workers), limit: workers + queue}
+	for i := 0; i < workers; i++ {
+		p.free <- i
+	}
+	return p
+}
+
+var apiWorkers = newAPIWorkerPool(defaultAPIWorkers, defaultAPIQueue)
+
+// setupAPIWorkers checks -api-workers and -api-queue.
+func setupAPIWorkers() error {
+	if apiWorkerCount < 1 {
+		return fmt.Errorf("-api-workers must be at least 1, not %d", apiWorkerCount)
+	}
+	if apiQueueDepth < 0 {
+		return fmt.Errorf("-api-queue must be 0 or more, not %d", apiQueueDepth)
+	}
+	apiWorkers = newAPIWorkerPool(apiWorkerCount, apiQueueDepth)
+	return nil
+}
+
+// admit takes a place for a workflow, running or queued, or reports that
+// the queue is full.
+func (p *apiWorkerPool) admit() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.admitted >= p.limit {
+		return false
+	}
+	p.admitted++
+	return true
+}
+
+// start waits for a worker for an admitted workflow. When ctx ends first
+// the workflow gives up its place.
+func (p *apiWorkerPool) start(ctx context.Context) (int, error) {
+	select {
+	case worker := <-p.free:
+		return worker, nil
+	case <-ctx.Done():
+		p.leave()
+		return 0, context.Cause(ctx)
+	}
+}
+
+// done frees the worker of a finished workflow.
+func (p *apiWorkerPool) done(worker int) {
+	p.free <- worker
+	p.leave()
+}
+
+func (p *apiWorkerPool) leave() {
+	p.mu.Lock()
+	p.admitted--
+	p.mu.Unlock()
+}
+
+// load reports the workflows running and those queued.
+func (p *apiWorkerPool) load() (running, queued int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	running = cap(p.free) - len(p.free)
+	return running, p.admitted - running
+}
+
+// capacity reports the workers and how many workflows can wait for one.
+func (p *apiWorkerPool) capacity() (workers, queue int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return cap(p.free), p.limit - cap(p.free)
+}
+
+// scriptPort is the script port label of worker.
+func (p *apiWorkerPool) scriptPort(worker int) int {
+	return startPort + 1 + worker
+}
+
+// errAPIBusy is why a request was refused with 429.
+var errAPIBusy = errors.New("all workers are busy and the queue is full")
+
+// refuseBusy answers a request the pool has no room for.
+func refuseBusy(c *gin.Context) {
+	running, queued := apiWorkers.load()
+	apiLog.Warn(fmt.Sprintf("Refused %s %s from %s: %d workflows running and %d queued", c.Request.Method, c.Request.URL.Path, c.ClientIP(), running, queued))
+	c.Header("Retry-After", strconv.Itoa(int(apiBusyRetryAfter.Seconds())))
+	sendErrorResponse(c, http.StatusTooManyRequests, "API is busy", fmt.Errorf("%w: %d running, %d queued", errAPIBusy, running, queued))
+}
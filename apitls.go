@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+)
+
+var (
+	apiTLSCert     string
+	apiTLSKey      string
+	apiTLSClientCA string
+)
+
+func init() {
+	flag.StringVar(&apiTLSCert, "api-tls-cert", "", "Serve the API over HTTPS with this PEM certificate, followed by any intermediates")
+	flag.StringVar(&apiTLSKey, "api-tls-key", "", "PEM private key of -api-tls-cert")
+	flag.StringVar(&apiTLSClientCA, "api-tls-client-ca", "", "Require API clients to present a certificate issued by a CA in this PEM file (mutual TLS)")
+}
+
+// apiTLS is the TLS configuration of the API server; nil serves plain HTTP.
+var apiTLS *tls.Config
+
+// setupAPITLS checks the -api-tls flags and loads the server certificate
+// and the client CAs.
+func setupAPITLS() error {
+	if apiTLSCert == "" && apiTLSKey == "" {
+		if apiTLSClientCA != "" {
+			return errors.New("-api-tls-client-ca needs -api-tls-cert and -api-tls-key")
+		}
+		return nil
+	}
+	if apiTLSCert == "" || apiTLSKey == "" {
+		return errors.New("-api-tls-cert and -api-tls-key go together")
+	}
+	cert, err := tls.LoadX509KeyPair(apiTLSCert, apiTLSKey)
+	if err != nil {
+		return fmt.Errorf("-api-tls-cert: %w", err)
+	}
+	config := &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}
+	if apiTLSClientCA != "" {
+		caData, err := os.ReadFile(apiTLSClientCA)
+		if err != nil {
+			return fmt.Errorf("-api-tls-client-ca: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caData) {
+			return fmt.Errorf("-api-tls-client-ca %s contains no certificates", apiTLSClientCA)
+		}
+		config.ClientCAs = pool
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	apiTLS = config
+	return nil
+}
+
+// apiTLSCertPath is the absolute path of -api-tls-cert, which the metrics
+// give the dashboard to recognise the API server by.
+func apiTLSCertPath() string {
+	if apiTLS == nil {
+		return ""
+	}
+	path, err := filepath.Abs(apiTLSCert)
+	if err != nil {
+		return apiTLSCert
+	}
+	return path
+}
+
+// pinnedTransport reaches a local server whose certificate is in certPath:
+// an API server, from the dashboard, or the dashboard, from a run. The
+// server is found by port rather than by name, so its certificate is
+// compared with that file instead of checked for the host name.
+func pinnedTransport(certPath string) (*http.Transport, error) {
+	data, err := os.ReadFile(certPath)
+	if err != nil {
+		return nil, err
+	}
+	block, rest := pem.Decode(data)
+	for block != nil && block.Type != "CERTIFICATE" {
+		block, rest = pem.Decode(rest)
+	}
+	if block == nil {
+		return nil, fmt.Errorf("%s contains no certificate", certPath)
+	}
+	leaf := block.Bytes
+	return &http.Transport{TLSClientConfig: &tls.Config{
+		// VerifyPeerCertificate checks the certificate instead.
+		InsecureSkipVerify: true,
+		VerifyPeerCertificate: func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+			if len(rawCerts) == 0 || !bytes.Equal(rawCerts[0], leaf) {
+				return fmt.Errorf("the server's certificate is not the one in %s", certPath)
+			}
+			return nil
+		},
+	}}, nil
+}
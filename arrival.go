@@ -0,0 +1,106 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+var arrivalRateFlag float64
+
+func init() {
+	flag.Float64Var(&arrivalRateFlag, "arrivalRate", 0, "Start this many workflows per second however many are in flight (open model); -concurrent caps the workflows in flight")
+}
+
+// droppedArrivals counts the arrivals of an open-model run that found every
+// vUser busy.
+var droppedArrivals int64
+
+// arrivalRate returns the workflows per second an open-model run starts, or
+// 0 for the closed model, where each vUser starts its next workflow when
+// its last one ends. The -arrivalRate flag overrides ArrivalRate.
+func arrivalRate(config *Configuration) float64 {
+	if arrivalRateFlag > 0 {
+		return arrivalRateFlag
+	}
+	return config.ArrivalRate
+}
+
+func validateArrivalRate(config *Configuration) error {
+	if config.ArrivalRate < 0 {
+		return fmt.Errorf("ArrivalRate cannot be negative")
+	}
+	return nil
+}
+
+// newIdleVUsers returns the pool of free vUsers of an open-model run, full.
+// The scheduler takes one for each arrival and the worker hands it back when
+// the workflow ends.
+func newIdleVUsers(vUsers int) chan struct{} {
+	idle := make(chan struct{}, vUsers)
+	for i := 0; i < vUsers; i++ {
+		idle <- struct{}{}
+	}
+	return idle
+}
+
+// jobDone hands the worker back to the idle pool of an open-model run.
+func (w *workflowWorker) jobDone() {
+	if w.idle != nil {
+		w.idle <- struct{}{}
+	}
+}
+
+// scheduleArrivals starts workflows on the open model until the deadline:
+// one every 1/rate seconds, however many are in flight. An arrival that
+// finds every vUser busy is dropped and counted instead of queued, so a
+// slow host shows up as dropped arrivals rather than as a quietly lower
+// rate. The rate is read again for each arrival to follow hot reloads.
+func scheduleArrivals(live *liveRunConfig, mix *suiteMix, jobs chan<- *Configuration, idle chan struct{}, deadline time.Time, budget *iterationBudget, injectionCursor *int, checkpoints *checkpointWriter) {
+	next := time.Now()
+	for beforeDeadline(next, deadline) && !budget.spent() && !runAborted() && !stopRequested() {
+		time.Sleep(time.Until(next))
+		runConfig, rows := live.current()
+		if len(rows) == 0 {
+			rows = []map[string]string{{}}
+		}
+		select {
+		case <-idle:
+			*injectionCursor %= len(rows)
+			picked, suiteName := mix.next(runConfig)
+			cfg := injectDynamicValues(picked, rows[*injectionCursor])
+			if len(rows[*injectionCursor]) > 0 {
+				cfg.dataRow = *injectionCursor + 1
+			}
+			jobs <- cfg
+			*injectionCursor = (*injectionCursor + 1) % len(rows)
+			mix.record(suiteName)
+			budget.take()
+		default:
+			if atomic.AddInt64(&droppedArrivals, 1) == 1 {
+				msg := fmt.Sprintf("Every vUser is busy - dropping arrivals until one frees up. Raise -concurrent to keep up with %.2f workflows/s.", arrivalRate(runConfig))
+				infoIfBarsDisabled(msg)
+				storeLog(msg)
+			}
+		}
+		checkpoints.maybeSave(*injectionCursor)
+		next = next.Add(time.Duration(float64(time.Second) / arrivalRate(runConfig)))
+	}
+}
+
+// printArrivalSummary compares the rate an open-model run achieved with its
+// target.
+func printArrivalSummary(rate float64, started int64, elapsed float64) {
+	if rate <= 0 {
+		return
+	}
+	achieved := 0.0
+	if elapsed > 0 {
+		achieved = float64(started) / elapsed
+	}
+	pterm.Info.Printf("Arrival rate: %.2f workflows/s target, %.2f workflows/s started\n", rate, achieved)
+	if n := atomic.LoadInt64(&droppedArrivals); n > 0 {
+		pterm.Warning.Printf("Dropped arrivals (every vUser busy): %d - raise -concurrent to keep up with the arrival rate\n", n)
+	}
+}
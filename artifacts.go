@@ -0,0 +1,419 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+var artifactsURL string
+
+func init() {
+	flag.StringVar(&artifactsURL, "artifacts", "", "Upload the run's summary, results, reports, logs and failure screens under a per-run prefix of s3://bucket/prefix, gs://bucket/prefix or an Azure Blob container URL; accepts {{env:NAME}} and {{file:path}}")
+}
+
+// artifactsTimeout bounds the uploads at the end of a run.
+const artifactsTimeout = 5 * time.Minute
+
+// artifactStore is an object store bucket the run's artifacts are
+// uploaded to.
+type artifactStore interface {
+	// name says where the artifacts go, without credentials.
+	name() string
+	// put stores data as the object key, under the store's prefix.
+	put(ctx context.Context, key string, data []byte, contentType string) error
+}
+
+// openArtifactStore reads -artifacts: s3://bucket/prefix, gs://bucket/prefix,
+// or the https URL of an Azure Blob container with an optional prefix.
+func openArtifactStore(raw string) (artifactStore, error) {
+	u, err := url.Parse(raw)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("-artifacts must be s3://bucket/prefix, gs://bucket/prefix or an Azure Blob container URL, not %q", redactURL(raw))
+	}
+	prefix := strings.Trim(u.Path, "/")
+	switch u.Scheme {
+	case "s3":
+		return newS3Store(u.Host, prefix)
+	case "gs":
+		return newGCSStore(u.Host, prefix)
+	case "http", "https":
+		return newAzureStore(u)
+	}
+	return nil, fmt.Errorf("-artifacts must be s3://, gs:// or an Azure Blob https:// URL, not %s://", u.Scheme)
+}
+
+func joinKey(parts ...string) string {
+	var kept []string
+	for _, p := range parts {
+		if p = strings.Trim(p, "/"); p != "" {
+			kept = append(kept, p)
+		}
+	}
+	return strings.Join(kept, "/")
+}
+
+// escapeKey escapes each segment of an object key for a URL path.
+func escapeKey(key string) string {
+	segments := strings.Split(key, "/")
+	for i, s := range segments {
+		segments[i] = url.PathEscape(s)
+	}
+	return strings.Join(segments, "/")
+}
+
+// putObject sends an upload and checks the store accepted it.
+func putObject(req *http.Request) error {
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		// The error carries the URL, which may carry a SAS token.
+		if urlErr, ok := err.(*url.Error); ok {
+			err = urlErr.Err
+		}
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		var body [256]byte
+		n, _ := resp.Body.Read(body[:])
+		return fmt.Errorf("unexpected status %s: %s", resp.Status, strings.TrimSpace(string(body[:n])))
+	}
+	return nil
+}
+
+// s3Store uploads to Amazon S3, or an S3 compatible store at
+// AWS_ENDPOINT_URL, with the AWS credentials of the environment.
+type s3Store struct {
+	bucket, prefix       string
+	region               string
+	endpoint             *url.URL
+	pathStyle            bool
+	accessKey, secretKey string
+	sessionToken         string
+}
+
+func newS3Store(bucket, prefix string) (*s3Store, error) {
+	s := &s3Store{
+		bucket:       bucket,
+		prefix:       prefix,
+		region:       os.Getenv("AWS_REGION"),
+		accessKey:    os.Getenv("AWS_ACCESS_KEY_ID"),
+		secretKey:    os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		sessionToken: os.Getenv("AWS_SESSION_TOKEN"),
+	}
+	if s.region == "" {
+		s.region = os.Getenv("AWS_DEFAULT_REGION")
+	}
+	if s.region == "" {
+		s.region = "us-east-1"
+	}
+	if s.accessKey == "" || s.secretKey == "" {
+		return nil, fmt.Errorf("-artifacts s3:// needs AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY")
+	}
+	if endpoint := os.Getenv("AWS_ENDPOINT_URL"); endpoint != "" {
+		u, err := url.Parse(endpoint)
+		if err != nil || u.Host == "" {
+			return nil, fmt.Errorf("AWS_ENDPOINT_URL %q is not a URL", endpoint)
+		}
+		// S3 compatible stores, such as MinIO, address buckets by path.
+		s.endpoint, s.pathStyle = u, true
+	} else {
+		s.endpoint = &url.URL{Scheme: "https", Host: fmt.Sprintf("%s.s3.%s.amazonaws.com", bucket, s.region)}
+	}
+	return s, nil
+}
+
+func (s *s3Store) name() string {
+	return "s3://" + joinKey(s.bucket, s.prefix)
+}
+
+func (s *s3Store) put(ctx context.Context, key string, data []byte, contentType string) error {
+	object := joinKey(s.prefix, key)
+	target := *s.endpoint
+	if s.pathStyle {
+		target.Path = "/" + s.bucket + "/" + object
+		target.RawPath = "/" + url.PathEscape(s.bucket) + "/" + escapeKey(object)
+	} else {
+		target.Path = "/" + object
+		target.RawPath = "/" + escapeKey(object)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, target.String(), bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentType)
+	if s.sessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", s.sessionToken)
+	}
+	sum := sha256.Sum256(data)
+	signAWSV4(req, hex.EncodeToString(sum[:]), s.accessKey, s.secretKey, s.region, "s3", time.Now())
+	return putObject(req)
+}
+
+// signAWSV4 signs req with AWS Signature Version 4, covering the host and
+// every header set on req.
+func signAWSV4(req *http.Request, payloadHash, accessKey, secretKey, region, service string, now time.Time) {
+	amzDate := now.UTC().Format("20060102T150405Z")
+	day := amzDate[:8]
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		headers[strings.ToLower(name)] = strings.TrimSpace(strings.Join(values, ","))
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		fmt.Fprintf(&canonicalHeaders, "%s:%s\n", name, headers[name])
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		canonicalQuery(req.URL.Query()),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	scope := fmt.Sprintf("%s/%s/%s/aws4_request", day, region, service)
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := strings.Join([]string{"AWS4-HMAC-SHA256", amzDate, scope, hex.EncodeToString(requestHash[:])}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+secretKey), day)
+	for _, part := range []string{region, service, "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s", accessKey, scope, signedHeaders, signature))
+}
+
+func canonicalQuery(q url.Values) string {
+	keys := make([]string, 0, len(q))
+	for k := range q {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var parts []string
+	for _, k := range keys {
+		values := append([]string(nil), q[k]...)
+		sort.Strings(values)
+		for _, v := range values {
+			parts = append(parts, awsEscape(k)+"="+awsEscape(v))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// awsEscape escapes as Signature Version 4 asks: everything but unreserved
+// characters, with spaces as %20.
+func awsEscape(s string) string {
+	return strings.ReplaceAll(url.QueryEscape(s), "+", "%20")
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// gcsStore uploads to Google Cloud Storage with the OAuth access token in
+// GOOGLE_OAUTH_ACCESS_TOKEN, such as from gcloud auth print-access-token.
+type gcsStore struct {
+	bucket, prefix string
+	endpoint       string
+	token          string
+}
+
+func newGCSStore(bucket, prefix string) (*gcsStore, error) {
+	s := &gcsStore{bucket: bucket, prefix: prefix, endpoint: "https://storage.googleapis.com", token: os.Getenv("GOOGLE_OAUTH_ACCESS_TOKEN")}
+	if emulator := os.Getenv("STORAGE_EMULATOR_HOST"); emulator != "" {
+		s.endpoint = strings.TrimSuffix(emulator, "/")
+		if !strings.Contains(s.endpoint, "://") {
+			s.endpoint = "http://" + s.endpoint
+		}
+	} else if s.token == "" {
+		return nil, fmt.Errorf("-artifacts gs:// needs GOOGLE_OAUTH_ACCESS_TOKEN")
+	}
+	return s, nil
+}
+
+func (s *gcsStore) name() string {
+	return "gs://" + joinKey(s.bucket, s.prefix)
+}
+
+func (s *gcsStore) put(ctx context.Context, key string, data []byte, contentType string) error {
+	target := fmt.Sprintf("%s/upload/storage/v1/b/%s/o?uploadType=media&name=%s", s.endpoint, url.PathEscape(s.bucket), url.QueryEscape(joinKey(s.prefix, key)))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentType)
+	if s.token != "" {
+		req.Header.Set("Authorization", "Bearer "+s.token)
+	}
+	return putObject(req)
+}
+
+// azureStore uploads block blobs to an Azure Blob Storage container, with
+// the SAS token of its URL or of AZURE_STORAGE_SAS_TOKEN.
+type azureStore struct {
+	container *url.URL
+	prefix    string
+	sas       string
+}
+
+func newAzureStore(u *url.URL) (*azureStore, error) {
+	s := &azureStore{sas: u.RawQuery}
+	if s.sas == "" {
+		s.sas = strings.TrimPrefix(os.Getenv("AZURE_STORAGE_SAS_TOKEN"), "?")
+	}
+	if s.sas == "" {
+		return nil, fmt.Errorf("-artifacts for Azure Blob needs a SAS token in its URL or in AZURE_STORAGE_SAS_TOKEN")
+	}
+	segments := strings.Split(strings.Trim(u.Path, "/"), "/")
+	if strings.HasSuffix(u.Hostname(), ".blob.core.windows.net") {
+		// The first segment is the container.
+		s.container = &url.URL{Scheme: u.Scheme, Host: u.Host, Path: "/" + segments[0]}
+		s.prefix = strings.Join(segments[1:], "/")
+	} else {
+		// Azurite and other endpoints put the account before the container.
+		if len(segments) < 2 {
+			return nil, fmt.Errorf("-artifacts %s needs an account and a container in its path", redactURL(u.String()))
+		}
+		s.container = &url.URL{Scheme: u.Scheme, Host: u.Host, Path: "/" + segments[0] + "/" + segments[1]}
+		s.prefix = strings.Join(segments[2:], "/")
+	}
+	if s.container.Path == "/" {
+		return nil, fmt.Errorf("-artifacts %s needs a container in its path", redactURL(u.String()))
+	}
+	return s, nil
+}
+
+func (s *azureStore) name() string {
+	return s.container.String() + "/" + s.prefix
+}
+
+func (s *azureStore) put(ctx context.Context, key string, data []byte, contentType string) error {
+	target := *s.container
+	target.Path = s.container.Path + "/" + joinKey(s.prefix, key)
+	target.RawPath = s.container.EscapedPath() + "/" + escapeKey(joinKey(s.prefix, key))
+	target.RawQuery = s.sas
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, target.String(), bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("X-Ms-Blob-Type", "BlockBlob")
+	req.Header.Set("X-Ms-Version", "2021-08-06")
+	return putObject(req)
+}
+
+var artifacts artifactStore
+
+// setupArtifacts checks -artifacts before the run, so a bad bucket URL or
+// missing credentials stop it before it starts.
+func setupArtifacts() error {
+	if artifactsURL == "" {
+		return nil
+	}
+	raw, err := resolveSecretPlaceholders(artifactsURL)
+	if err != nil {
+		return fmt.Errorf("-artifacts: %w", err)
+	}
+	store, err := openArtifactStore(raw)
+	if err != nil {
+		return err
+	}
+	artifacts = store
+	return nil
+}
+
+// runArtifactPrefix names the folder of this run's artifacts: the workflow,
+// when the run started, and the process, so runs never overwrite each other.
+func runArtifactPrefix(configPath string) string {
+	name := strings.TrimSuffix(filepath.Base(configPath), filepath.Ext(configPath))
+	return sanitizeArtifactName(name) + "/" + runID()
+}
+
+// runArtifactFiles lists the files the run wrote, keyed by where they go
+// under the run's prefix.
+func runArtifactFiles(config *Configuration) map[string]string {
+	files := make(map[string]string)
+	add := func(key, file string) {
+		if info, err := os.Stat(file); err == nil && !info.IsDir() {
+			files[key] = file
+		}
+	}
+	pid := os.Getpid()
+	add(fmt.Sprintf("summary_%d.txt", pid), filepath.Join("logs", fmt.Sprintf("summary_%d.txt", pid)))
+	add(fmt.Sprintf("logs_%d.json", pid), filepath.Join("logs", fmt.Sprintf("logs_%d.json", pid)))
+	if resultsPath != "" {
+		add(filepath.Base(resultsPath), resultsPath)
+	}
+	if junitPath != "" {
+		add(filepath.Base(junitPath), junitPath)
+	}
+	if config != nil && config.OutputFilePath != "" {
+		add(filepath.Base(config.OutputFilePath), config.OutputFilePath)
+	}
+	screens, _ := filepath.Glob(filepath.Join(failureArtifactsDir, fmt.Sprintf("failure_%d_*.txt", pid)))
+	for _, screen := range screens {
+		add(path.Join("failures", filepath.Base(screen)), screen)
+	}
+	return files
+}
+
+// uploadRunArtifacts uploads what the run wrote to -artifacts. A file that
+// fails to upload is warned about, and does not fail the run.
+func uploadRunArtifacts(configPath string, config *Configuration) {
+	store := artifacts
+	if store == nil {
+		return
+	}
+	flushLogs()
+	files := runArtifactFiles(config)
+	keys := make([]string, 0, len(files))
+	for key := range files {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	prefix := runArtifactPrefix(configPath)
+	ctx, cancel := context.WithTimeout(context.Background(), artifactsTimeout)
+	defer cancel()
+	uploaded := 0
+	for _, key := range keys {
+		data, err := os.ReadFile(files[key])
+		if err == nil {
+			contentType := mime.TypeByExtension(filepath.Ext(key))
+			if contentType == "" {
+				contentType = "application/octet-stream"
+			}
+			err = store.put(ctx, path.Join(prefix, key), data, contentType)
+		}
+		if err != nil {
+			reportLog.Warn(fmt.Sprintf("Uploading %s to %s failed: %v", files[key], store.name(), err))
+			continue
+		}
+		uploaded++
+	}
+	reportLog.Info(fmt.Sprintf("Uploaded %d of %d run artifacts to %s", uploaded, len(keys), joinKey(store.name(), prefix)))
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+
+	connect3270 "github.com/3270io/3270Connect/connect3270"
+)
+
+// backendFlag is the -backend value, checked when the flag is parsed.
+type backendFlag string
+
+func (b *backendFlag) String() string {
+	return string(*b)
+}
+
+func (b *backendFlag) Set(value string) error {
+	switch value {
+	case connect3270.BackendX3270, connect3270.BackendNative:
+		*b = backendFlag(value)
+		return nil
+	}
+	return fmt.Errorf("unknown backend %q - use %s or %s", value, connect3270.BackendX3270, connect3270.BackendNative)
+}
+
+var emulatorBackend = backendFlag(connect3270.BackendX3270)
+
+func init() {
+	flag.Var(&emulatorBackend, "backend", "Emulator backend: x3270 (bundled s3270/x3270 processes) or native (built-in Go TN3270 client, no processes or script ports)")
+}
+
+func nativeBackendSelected() bool {
+	return emulatorBackend == connect3270.BackendNative
+}
+
+// applyBackendSettings hands the -backend choice to connect3270. Call once
+// after flag parsing.
+func applyBackendSettings() {
+	connect3270.Backend = string(emulatorBackend)
+	if nativeBackendSelected() && !headless {
+		pterm.Info.Println("The native backend has no emulator window - sessions run headless.")
+	}
+}
+
+// validateBackendSettings rejects settings the selected backend cannot honour.
+func validateBackendSettings(config *Configuration) error {
+	if !nativeBackendSelected() {
+		return nil
+	}
+	if config.CodePage != "" && !connect3270.NativeSupportsCodePage(config.CodePage) {
+		return fmt.Errorf("CodePage %q is not available with -backend native, which only speaks cp037", config.CodePage)
+	}
+	if config.TLSKeyPassword != "" {
+		return fmt.Errorf("TLSKeyPassword is not supported with -backend native - decrypt the key or use -backend x3270")
+	}
+	return nil
+}
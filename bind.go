@@ -0,0 +1,107 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net"
+	"strconv"
+)
+
+var (
+	apiBind       string
+	dashboardBind string
+)
+
+func init() {
+	flag.StringVar(&apiBind, "api-bind", "", "Address the API server listens on, as host:port, or a host to listen on at -api-port; 0.0.0.0 listens on every interface (default localhost)")
+	flag.StringVar(&dashboardBind, "dashboard-bind", "", "Address the dashboard listens on, as host:port, or a host to listen on at -dashboardPort; 0.0.0.0 listens on every interface (default localhost)")
+}
+
+// Listen addresses of the API server and the dashboard, set by
+// setupBindAddresses.
+var (
+	apiListenAddr       string
+	dashboardListenAddr string
+)
+
+// setupBindAddresses checks -api-bind and -dashboard-bind. A port given
+// there replaces -api-port or -dashboardPort, which the rest of the run
+// reports.
+func setupBindAddresses() error {
+	var err error
+	if apiListenAddr, apiPort, err = resolveBind(apiBind, apiPort); err != nil {
+		return fmt.Errorf("-api-bind: %w", err)
+	}
+	if dashboardListenAddr, dashboardPort, err = resolveBind(dashboardBind, dashboardPort); err != nil {
+		return fmt.Errorf("-dashboard-bind: %w", err)
+	}
+	return nil
+}
+
+// resolveBind turns a bind flag into the address to listen on, keeping
+// localhost when it is empty and port when it has none.
+func resolveBind(bind string, port int) (string, int, error) {
+	if bind == "" {
+		return net.JoinHostPort("localhost", strconv.Itoa(port)), port, nil
+	}
+	host, portText, err := net.SplitHostPort(bind)
+	if err != nil {
+		// A bare host, including IPv6 addresses without brackets.
+		host, portText = bind, strconv.Itoa(port)
+		if ip := net.ParseIP(bind); ip == nil && !isHostName(bind) {
+			return "", 0, fmt.Errorf("%q should be host:port or a host", bind)
+		}
+	}
+	n, err := strconv.Atoi(portText)
+	if err != nil || n < 0 || n > 65535 {
+		return "", 0, fmt.Errorf("port %q of %q is not a port number", portText, bind)
+	}
+	return net.JoinHostPort(host, strconv.Itoa(n)), n, nil
+}
+
+// isHostName reports whether s can be a host name.
+func isHostName(s string) bool {
+	for _, r := range s {
+		if !(r == '-' || r == '.' || r >= '0' && r <= '9' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z') {
+			return false
+		}
+	}
+	return s != ""
+}
+
+// localAddr is how this machine reaches a server listening on addr: the
+// same host, except that a server on every interface is reached on
+// localhost.
+func localAddr(addr string) string {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	if ip := net.ParseIP(host); host == "" || ip != nil && ip.IsUnspecified() {
+		host = "localhost"
+	}
+	return net.JoinHostPort(host, port)
+}
+
+// isLoopbackAddr reports whether addr only takes connections from this
+// machine.
+func isLoopbackAddr(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// dashboardURL is where this machine finds the dashboard.
+func dashboardURL() string {
+	scheme := "http"
+	if dashboardTLS != nil {
+		scheme = "https"
+	}
+	return scheme + "://" + localAddr(dashboardListenAddr) + dashboardPath("/dashboard")
+}
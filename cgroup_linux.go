@@ -0,0 +1,108 @@
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+)
+
+const cgroupRoot = "/sys/fs/cgroup"
+
+type cgroupSampler struct {
+	v2       bool
+	root     string
+	cpus     float64
+	memLimit uint64
+	tracker  cpuUsageTracker
+}
+
+// newContainerSampler returns a cgroup-backed sampler when the process runs
+// under a CPU or memory limit, or nil to fall back to host-wide metrics.
+func newContainerSampler() containerSampler {
+	return newCgroupSampler(cgroupRoot)
+}
+
+func newCgroupSampler(root string) containerSampler {
+	s := &cgroupSampler{root: root}
+	if _, err := os.Stat(filepath.Join(root, "cgroup.controllers")); err == nil {
+		s.v2 = true
+		if cpus, ok := parseCgroupCPUMax(readCgroupFile(filepath.Join(root, "cpu.max"))); ok {
+			s.cpus = cpus
+		}
+		if limit, ok := parseCgroupLimit(readCgroupFile(filepath.Join(root, "memory.max"))); ok {
+			s.memLimit = limit
+		}
+	} else {
+		quota := strings.TrimSpace(readCgroupFile(filepath.Join(root, "cpu", "cpu.cfs_quota_us")))
+		period := strings.TrimSpace(readCgroupFile(filepath.Join(root, "cpu", "cpu.cfs_period_us")))
+		if cpus, ok := parseCgroupCPUMax(quota + " " + period); ok {
+			s.cpus = cpus
+		}
+		if limit, ok := parseCgroupLimit(readCgroupFile(filepath.Join(root, "memory", "memory.limit_in_bytes"))); ok {
+			s.memLimit = limit
+		}
+	}
+	if s.cpus == 0 && s.memLimit == 0 {
+		return nil
+	}
+	if s.cpus == 0 {
+		s.cpus = float64(runtime.NumCPU())
+	}
+	return s
+}
+
+func (s *cgroupSampler) cpuPercent() (float64, bool) {
+	var used time.Duration
+	if s.v2 {
+		usec, ok := parseCgroupStat(readCgroupFile(filepath.Join(s.root, "cpu.stat")), "usage_usec")
+		if !ok {
+			return 0, false
+		}
+		used = time.Duration(usec) * time.Microsecond
+	} else {
+		nsec, ok := parseCgroupLimit(readCgroupFile(filepath.Join(s.root, "cpuacct", "cpuacct.usage")))
+		if !ok {
+			return 0, false
+		}
+		used = time.Duration(nsec)
+	}
+	return s.tracker.percent(used, time.Now(), s.cpus)
+}
+
+func (s *cgroupSampler) memPercent() (float64, bool) {
+	if s.memLimit == 0 {
+		return 0, false
+	}
+	var usageFile, statFile, inactiveKey string
+	if s.v2 {
+		usageFile = filepath.Join(s.root, "memory.current")
+		statFile = filepath.Join(s.root, "memory.stat")
+		inactiveKey = "inactive_file"
+	} else {
+		usageFile = filepath.Join(s.root, "memory", "memory.usage_in_bytes")
+		statFile = filepath.Join(s.root, "memory", "memory.stat")
+		inactiveKey = "total_inactive_file"
+	}
+	usage, ok := parseCgroupLimit(readCgroupFile(usageFile))
+	if !ok {
+		return 0, false
+	}
+	// Report the working set (usage minus reclaimable page cache), as the kubelet does.
+	if inactive, ok := parseCgroupStat(readCgroupFile(statFile), inactiveKey); ok && inactive < usage {
+		usage -= inactive
+	}
+	return float64(usage) / float64(s.memLimit) * 100, true
+}
+
+func readCgroupFile(path string) string {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
@@ -1,8 +1,10 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"io"
+	"log/slog"
 	"os"
 	"strings"
 	"sync"
@@ -69,10 +71,12 @@ type Prefix struct {
 	Style Style
 }
 
-// Message printer with a styled prefix.
+// Message printer with a styled prefix. Messages are logged at level on
+// the console; see logging.go.
 type MessagePrinter struct {
 	Prefix Prefix
 	style  lipgloss.Style
+	level  slog.Level
 }
 
 func (m MessagePrinter) Println(args ...interface{}) {
@@ -84,15 +88,18 @@ func (m MessagePrinter) Printf(format string, args ...interface{}) {
 }
 
 func (m MessagePrinter) print(msg string) {
-	var line string
+	consoleLog.Log(context.Background(), m.level, msg, slog.Any(printerKey, &m))
+}
+
+// render returns msg as the printer shows it.
+func (m MessagePrinter) render(msg string) string {
+	line := msg
 	if m.Prefix.Text != "" {
 		// Add a small pad around the prefix for readability.
 		prefix := m.Prefix.Style.Render(" " + m.Prefix.Text + " ")
 		line = fmt.Sprintf("%s %s", prefix, msg)
-	} else {
-		line = msg
 	}
-	fmt.Println(m.style.Render(line))
+	return m.style.Render(line)
 }
 
 // Section printer for headlines.
@@ -533,14 +540,17 @@ func newCharmPterm() *charmPterm {
 	ui.Warning = &MessagePrinter{
 		Prefix: Prefix{Text: "WARN", Style: ui.NewStyle(ui.BgYellow, ui.FgBlack)},
 		style:  lipgloss.NewStyle(),
+		level:  slog.LevelWarn,
 	}
 	ui.Error = &MessagePrinter{
 		Prefix: Prefix{Text: "ERROR", Style: ui.NewStyle(ui.BgRed, ui.FgWhite)},
 		style:  lipgloss.NewStyle(),
+		level:  slog.LevelError,
 	}
 	ui.Success = &MessagePrinter{
 		Prefix: Prefix{Text: "SUCCESS", Style: ui.NewStyle(ui.BgGreen, ui.FgBlack)},
 		style:  lipgloss.NewStyle(),
+		level:  levelSuccess,
 	}
 
 	ui.DefaultSection = SectionPrinter{Style: ui.NewStyle(ui.FgCyan, ui.Bold)}
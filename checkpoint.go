@@ -0,0 +1,190 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	checkpointPath     string
+	checkpointInterval int
+	resumePath         string
+)
+
+func init() {
+	flag.StringVar(&checkpointPath, "checkpoint", "", "Periodically save concurrent run state to this file so the run can be resumed")
+	flag.IntVar(&checkpointInterval, "checkpointInterval", 30, "Seconds between run checkpoints")
+	flag.StringVar(&resumePath, "resume", "", "Resume a concurrent run from a checkpoint file written by -checkpoint")
+}
+
+// runCheckpoint is the resumable state of a concurrent run.
+type runCheckpoint struct {
+	Version         string    `json:"version"`
+	ConfigPath      string    `json:"configPath"`
+	InjectionPath   string    `json:"injectionPath,omitempty"`
+	RuntimeSeconds  int       `json:"runtimeSeconds"`
+	ElapsedSeconds  float64   `json:"elapsedSeconds"`
+	Started         int64     `json:"started"`
+	Completed       int64     `json:"completed"`
+	Failed          int64     `json:"failed"`
+	DurationSum     float64   `json:"durationSum"`
+	DurationCount   int64     `json:"durationCount"`
+	InjectionCursor int       `json:"injectionCursor"`
+	Finished        bool      `json:"finished"`
+	SavedAt         time.Time `json:"savedAt"`
+
+	// DurationHistogram keeps the duration distribution for percentiles.
+	DurationHistogram map[int]int64 `json:"durationHistogram,omitempty"`
+}
+
+func (c *runCheckpoint) elapsed() time.Duration {
+	return time.Duration(c.ElapsedSeconds * float64(time.Second))
+}
+
+func loadRunCheckpoint(path string) (*runCheckpoint, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var cp runCheckpoint
+	if err := json.Unmarshal(data, &cp); err != nil {
+		return nil, fmt.Errorf("decoding checkpoint %s: %w", path, err)
+	}
+	return &cp, nil
+}
+
+// saveRunCheckpoint writes the checkpoint atomically so a crash mid-write
+// never leaves a truncated file behind.
+func saveRunCheckpoint(path string, cp *runCheckpoint) error {
+	data, err := json.MarshalIndent(cp, "", "  ")
+	if err != nil {
+		return err
+	}
+	if dir := filepath.Dir(path); dir != "" {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// prepareResume loads -resume and seeds the run counters from it. It returns
+// nil when there is nothing to resume.
+func prepareResume(configPath, injectionPath string) *runCheckpoint {
+	if resumePath == "" {
+		return nil
+	}
+	cp, err := loadRunCheckpoint(resumePath)
+	if err != nil {
+		pterm.Warning.Printf("Could not resume from %s: %v - starting from zero.\n", resumePath, err)
+		return nil
+	}
+	if cp.Finished {
+		pterm.Warning.Printf("Checkpoint %s belongs to a finished run - starting from zero.\n", resumePath)
+		return nil
+	}
+	if cp.ConfigPath != configPath || cp.InjectionPath != injectionPath {
+		pterm.Warning.Printf("Checkpoint was taken with -config %s -injectionConfig %q; resuming with the current files anyway.\n", cp.ConfigPath, cp.InjectionPath)
+	}
+
+	// Workflows in flight when the checkpoint was taken never finished; they
+	// are dropped from the started count rather than reported as completed.
+	interrupted := cp.Started - cp.Completed - cp.Failed
+	atomic.StoreInt64(&totalWorkflowsStarted, cp.Completed+cp.Failed)
+	atomic.StoreInt64(&totalWorkflowsCompleted, cp.Completed)
+	atomic.StoreInt64(&totalWorkflowsFailed, cp.Failed)
+	seedDurationTotals(cp.DurationSum, cp.DurationCount)
+	seedDurationHistogram(cp.DurationHistogram)
+
+	msg := fmt.Sprintf("Resuming run from %s at %s elapsed (%d completed, %d failed, %d interrupted).",
+		resumePath, formatSeconds(cp.ElapsedSeconds), cp.Completed, cp.Failed, interrupted)
+	schedulerLog.Info(msg)
+	return cp
+}
+
+func seedDurationTotals(sum float64, count int64) {
+	shard := &durationShards[0]
+	shard.mu.Lock()
+	shard.sum += sum
+	shard.count += count
+	shard.mu.Unlock()
+}
+
+// checkpointWriter saves run state on an interval from the scheduler loop.
+type checkpointWriter struct {
+	path          string
+	interval      time.Duration
+	last          time.Time
+	configPath    string
+	injectionPath string
+	overallStart  time.Time
+}
+
+func newCheckpointWriter(configPath, injectionPath string, overallStart time.Time) *checkpointWriter {
+	path := checkpointPath
+	if path == "" {
+		// Keep checkpointing into the file we resumed from.
+		path = resumePath
+	}
+	if path == "" {
+		return nil
+	}
+	interval := time.Duration(checkpointInterval) * time.Second
+	if interval <= 0 {
+		interval = 30 * time.Second
+	}
+	return &checkpointWriter{
+		path:          path,
+		interval:      interval,
+		last:          time.Now(),
+		configPath:    configPath,
+		injectionPath: injectionPath,
+		overallStart:  overallStart,
+	}
+}
+
+func (w *checkpointWriter) maybeSave(injectionCursor int) {
+	if w == nil || time.Since(w.last) < w.interval {
+		return
+	}
+	w.save(injectionCursor, false)
+}
+
+func (w *checkpointWriter) save(injectionCursor int, finished bool) {
+	if w == nil {
+		return
+	}
+	w.last = time.Now()
+	sum, count := shardedDurationTotals()
+	cp := &runCheckpoint{
+		Version:         version,
+		ConfigPath:      w.configPath,
+		InjectionPath:   w.injectionPath,
+		RuntimeSeconds:  runtimeDuration,
+		ElapsedSeconds:  time.Since(w.overallStart).Seconds(),
+		Started:         atomic.LoadInt64(&totalWorkflowsStarted),
+		Completed:       atomic.LoadInt64(&totalWorkflowsCompleted),
+		Failed:          atomic.LoadInt64(&totalWorkflowsFailed),
+		DurationSum:     sum,
+		DurationCount:   count,
+		InjectionCursor: injectionCursor,
+		Finished:        finished,
+		SavedAt:         w.last,
+
+		DurationHistogram: durationHistogram(),
+	}
+	if err := saveRunCheckpoint(w.path, cp); err != nil {
+		pterm.Warning.Printf("Failed to save checkpoint %s: %v\n", w.path, err)
+		return
+	}
+	storeLog(fmt.Sprintf("Checkpoint saved to %s (%.0fs elapsed)", w.path, cp.ElapsedSeconds))
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"text/tabwriter"
+)
+
+// regressionExitCode is the exit status of a comparison that found a
+// regression, the same as a run that broke a threshold.
+const regressionExitCode = thresholdExitCode
+
+// comparisonRow compares one metric of two runs.
+type comparisonRow struct {
+	Metric   string  `json:"metric"`
+	Baseline float64 `json:"baseline"`
+	Current  float64 `json:"current"`
+	// Change is relative, in percent, except for error rates, whose change
+	// is in percentage points.
+	Change float64 `json:"change"`
+	Unit   string  `json:"unit"`
+	// Result is "ok", "improved", "regression", or "new" when the baseline
+	// has nothing to compare with.
+	Result string `json:"result"`
+}
+
+// compareRuns compares current with baseline. Throughput that falls, or
+// times that grow, by more than tolerance percent, and error rates that
+// grow by more than errorTolerance percentage points, are regressions.
+func compareRuns(baseline, current runRecord, tolerance, errorTolerance float64) []comparisonRow {
+	var rows []comparisonRow
+	// higherIsWorse says whether a rise in the metric is a regression.
+	relative := func(metric, unit string, base, cur float64, higherIsWorse bool) {
+		row := comparisonRow{Metric: metric, Baseline: base, Current: cur, Unit: unit, Result: "ok"}
+		if base == 0 {
+			// There is nothing to measure a change against.
+			if cur != 0 {
+				row.Result = "new"
+			}
+			rows = append(rows, row)
+			return
+		}
+		row.Change = (cur - base) / base * 100
+		worse := row.Change
+		if !higherIsWorse {
+			worse = -worse
+		}
+		switch {
+		case worse > tolerance:
+			row.Result = "regression"
+		case worse < -tolerance:
+			row.Result = "improved"
+		}
+		rows = append(rows, row)
+	}
+
+	relative("Throughput", "workflows/s", baseline.throughput(), current.throughput(), false)
+	errRow := comparisonRow{Metric: "Error Rate", Baseline: baseline.errorRate(), Current: current.errorRate(), Unit: "%", Result: "ok"}
+	errRow.Change = errRow.Current - errRow.Baseline
+	switch {
+	case errRow.Change > errorTolerance:
+		errRow.Result = "regression"
+	case errRow.Change < -errorTolerance:
+		errRow.Result = "improved"
+	}
+	rows = append(rows, errRow)
+	relative("Average Workflow Time", "s", baseline.Avg, current.Avg, true)
+	relative("P50 Workflow Time", "s", baseline.P50, current.P50, true)
+	relative("P90 Workflow Time", "s", baseline.P90, current.P90, true)
+	relative("P95 Workflow Time", "s", baseline.P95, current.P95, true)
+	relative("P99 Workflow Time", "s", baseline.P99, current.P99, true)
+
+	baseTimings := make(map[string]timingRecord, len(baseline.Timings))
+	for _, t := range baseline.Timings {
+		baseTimings[t.Kind+" "+t.Name] = t
+	}
+	for _, t := range current.Timings {
+		name := timingKindLabels[t.Kind] + " " + t.Name
+		base := baseTimings[t.Kind+" "+t.Name]
+		relative(name+" Avg", "s", base.Avg, t.Avg, true)
+		relative(name+" P95", "s", base.P95, t.P95, true)
+	}
+	return rows
+}
+
+var timingKindLabels = map[string]string{"transaction": "Transaction", "step": "Step"}
+
+// throughput returns the workflows the run completed per second.
+func (r runRecord) throughput() float64 {
+	if seconds := r.EndedAt.Sub(r.StartedAt).Seconds(); seconds > 0 {
+		return float64(r.Completed) / seconds
+	}
+	return 0
+}
+
+func comparisonRegressed(rows []comparisonRow) bool {
+	for _, row := range rows {
+		if row.Result == "regression" {
+			return true
+		}
+	}
+	return false
+}
+
+// loadComparedRun reads a run to compare: a run ID in the history, or a
+// file written by 3270Connect report -json.
+func loadComparedRun(arg, dbPath string) (runRecord, error) {
+	if id, err := strconv.ParseInt(arg, 10, 64); err == nil {
+		if _, err := os.Stat(dbPath); err != nil {
+			return runRecord{}, fmt.Errorf("no run history at %s: %w", dbPath, err)
+		}
+		db, err := openHistory(dbPath)
+		if err != nil {
+			return runRecord{}, err
+		}
+		defer db.Close()
+		r, err := loadRun(db, id)
+		if err != nil {
+			return runRecord{}, err
+		}
+		return *r, nil
+	}
+	data, err := os.ReadFile(arg)
+	if err != nil {
+		return runRecord{}, err
+	}
+	var r runRecord
+	if err := json.Unmarshal(data, &r); err != nil {
+		// A list from report -json holds the run when it is the only one.
+		var runs []runRecord
+		if listErr := json.Unmarshal(data, &runs); listErr != nil || len(runs) != 1 {
+			return runRecord{}, fmt.Errorf("%s is not a run written by 3270Connect report -json: %v", arg, err)
+		}
+		r = runs[0]
+	}
+	return r, nil
+}
+
+func describeRun(r runRecord) string {
+	return fmt.Sprintf("run %d of %s, started %s", r.ID, filepath.Base(r.ConfigPath), r.StartedAt.Local().Format("2006-01-02 15:04"))
+}
+
+// comparison is what compare -json prints.
+type comparison struct {
+	Baseline       runRecord       `json:"baseline"`
+	Current        runRecord       `json:"current"`
+	Tolerance      float64         `json:"tolerance"`
+	ErrorTolerance float64         `json:"errorTolerance"`
+	Regressed      bool            `json:"regressed"`
+	Metrics        []comparisonRow `json:"metrics"`
+}
+
+// runCompareCommand compares a run with a baseline and exits with
+// regressionExitCode when it regressed.
+func runCompareCommand(args []string, out io.Writer) int {
+	fs := flag.NewFlagSet("compare", flag.ContinueOnError)
+	dbPath := fs.String("db", filepath.Join("logs", "history.db"), "History database the run IDs are in")
+	tolerance := fs.Float64("tolerance", 10, "Percent that throughput may fall, or times grow, before it is a regression")
+	errorTolerance := fs.Float64("errorTolerance", 1, "Percentage points the error rate may grow before it is a regression")
+	asJSON := fs.Bool("json", false, "Print the comparison as JSON")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: 3270Connect compare [-db logs/history.db] [-tolerance 10] [-errorTolerance 1] [-json] baseline current")
+		fmt.Fprintln(fs.Output(), "baseline and current are run IDs in the history, or files written by 3270Connect report -json.")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() != 2 {
+		fs.Usage()
+		return 2
+	}
+	if *tolerance < 0 || *errorTolerance < 0 {
+		fmt.Fprintln(os.Stderr, "-tolerance and -errorTolerance cannot be negative")
+		return 2
+	}
+	var runs [2]runRecord
+	for i, arg := range fs.Args() {
+		r, err := loadComparedRun(arg, *dbPath)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", arg, err)
+			return 1
+		}
+		runs[i] = r
+	}
+	rows := compareRuns(runs[0], runs[1], *tolerance, *errorTolerance)
+	regressed := comparisonRegressed(rows)
+	if *asJSON {
+		c := comparison{Baseline: runs[0], Current: runs[1], Tolerance: *tolerance, ErrorTolerance: *errorTolerance, Regressed: regressed, Metrics: rows}
+		if code := writeReportJSON(out, c); code != 0 {
+			return code
+		}
+	} else {
+		printComparison(out, describeRun(runs[0]), describeRun(runs[1]), rows, *tolerance, *errorTolerance)
+	}
+	if regressed {
+		return regressionExitCode
+	}
+	return 0
+}
+
+func printComparison(out io.Writer, baseline, current string, rows []comparisonRow, tolerance, errorTolerance float64) {
+	fmt.Fprintf(out, "Baseline: %s\n", baseline)
+	fmt.Fprintf(out, "Current:  %s\n\n", current)
+	tw := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "Metric\tBaseline\tCurrent\tChange\tResult")
+	for _, row := range rows {
+		base, cur := formatComparedValue(row.Baseline, row.Unit), formatComparedValue(row.Current, row.Unit)
+		change := "-"
+		switch {
+		case row.Result == "new":
+			base = "-"
+		case row.Unit == "%":
+			change = fmt.Sprintf("%+.2f pts", row.Change)
+		default:
+			change = fmt.Sprintf("%+.1f%%", row.Change)
+		}
+		result := row.Result
+		if result == "regression" {
+			result = "REGRESSION"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", row.Metric, base, cur, change, result)
+	}
+	tw.Flush()
+	fmt.Fprintln(out)
+	if comparisonRegressed(rows) {
+		fmt.Fprintf(out, "Regressions beyond the tolerance of %g%% (error rate %g points).\n", tolerance, errorTolerance)
+		return
+	}
+	fmt.Fprintf(out, "No regressions beyond the tolerance of %g%% (error rate %g points).\n", tolerance, errorTolerance)
+}
+
+func formatComparedValue(v float64, unit string) string {
+	switch unit {
+	case "s":
+		return fmt.Sprintf("%.3fs", v)
+	case "%":
+		return fmt.Sprintf("%.2f%%", v)
+	}
+	return fmt.Sprintf("%.2f %s", v, unit)
+}
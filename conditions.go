@@ -0,0 +1,109 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	connect3270 "github.com/3270io/3270Connect/connect3270"
+)
+
+// evaluateCondition checks an If step's predicates against the current screen.
+func evaluateCondition(e *connect3270.Emulator, cond *StepCondition, state *workflowState) (bool, error) {
+	if cond.ScreenContains != "" {
+		text, err := state.resolve(cond.ScreenContains)
+		if err != nil {
+			return false, err
+		}
+		rows, err := e.ScreenText()
+		if err != nil {
+			return false, err
+		}
+		if !strings.Contains(strings.Join(rows, "\n"), text) {
+			return false, nil
+		}
+	}
+	if cond.ValueEquals != nil {
+		expected, err := state.resolve(cond.ValueEquals.Text)
+		if err != nil {
+			return false, err
+		}
+		coords := cond.ValueEquals.Coordinates
+		value, err := e.GetValue(coords.Row, coords.Column, coords.Length)
+		if err != nil {
+			return false, err
+		}
+		if strings.TrimSpace(value) != strings.TrimSpace(expected) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// executeNestedSteps runs the branch of an If step, pausing EveryStepDelay
+// between steps like the top-level loop does.
+func executeNestedSteps(e *connect3270.Emulator, steps []Step, state *workflowState) error {
+	for idx, step := range steps {
+		if err := workflowContextErr(state.ctx); err != nil {
+			return err
+		}
+		if idx > 0 {
+			delay, err := randomDuration(state.everyStepDelay, true)
+			if err != nil {
+				return err
+			}
+			if delay > 0 {
+				if err := state.pause(delay); err != nil {
+					return err
+				}
+			}
+		}
+		if err := executeStep(e, step, state); err != nil {
+			return fmt.Errorf("%s step in If block: %w", step.Type, err)
+		}
+	}
+	return nil
+}
+
+func validateCondition(cond *StepCondition, definedVars map[string]bool) error {
+	if cond == nil || (cond.ScreenContains == "" && cond.ValueEquals == nil) {
+		return fmt.Errorf("If step needs a Condition with ScreenContains or ValueEquals")
+	}
+	texts := []string{cond.ScreenContains}
+	if cond.ValueEquals != nil {
+		coords := cond.ValueEquals.Coordinates
+		if coords.Row == 0 || coords.Column == 0 || coords.Length <= 0 {
+			return fmt.Errorf("ValueEquals needs Row, Column and Length")
+		}
+		if cond.ValueEquals.Text == "" {
+			return fmt.Errorf("ValueEquals needs the Text to compare against")
+		}
+		texts = append(texts, cond.ValueEquals.Text)
+	}
+	for _, text := range texts {
+		for _, name := range referencedVars(text) {
+			if !definedVars[name] {
+				return fmt.Errorf("{{var:%s}} used in If condition before an ExtractValue step sets it", name)
+			}
+		}
+	}
+	return nil
+}
+
+// checkFieldAttributes compares a field's attributes with the expected ones
+// and reports every mismatch at once.
+func checkFieldAttributes(want *FieldAttributeCheck, field *connect3270.ScreenField) error {
+	var mismatches []string
+	compare := func(name string, expected *bool, actual bool) {
+		if expected != nil && *expected != actual {
+			mismatches = append(mismatches, fmt.Sprintf("%s expected %t, found %t", name, *expected, actual))
+		}
+	}
+	compare("Protected", want.Protected, field.Protected)
+	compare("Hidden", want.Hidden, field.Hidden)
+	compare("Intensified", want.Intensified, field.Intensified)
+	compare("Numeric", want.Numeric, field.Numeric)
+	if len(mismatches) > 0 {
+		return fmt.Errorf("CheckFieldAttributes failed for field at row %d, column %d: %s", field.Row, field.Column, strings.Join(mismatches, "; "))
+	}
+	return nil
+}
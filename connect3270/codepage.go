@@ -0,0 +1,53 @@
+package connect3270
+
+import "strings"
+
+// codePages lists the host code pages of the bundled emulators, with their
+// aliases, as reported by s3270 -v.
+var codePages = []string{
+	"cp037", "cp37", "us", "us-intl",
+	"cp273", "german",
+	"cp275", "brazilian",
+	"cp277", "norwegian",
+	"cp278", "finnish", "swedish",
+	"cp280", "italian",
+	"cp284", "spanish",
+	"cp285", "uk",
+	"cp297", "french",
+	"cp424", "hebrew",
+	"cp500", "belgian",
+	"cp803", "hebrew-old",
+	"cp870", "polish", "slovenian",
+	"cp871", "icelandic",
+	"cp875", "greek",
+	"cp880", "russian",
+	"cp1026", "turkish",
+	"cp1047",
+	"cp1140", "us-euro",
+	"cp1141", "german-euro",
+	"cp1142", "norwegian-euro",
+	"cp1143", "finnish-euro", "swedish-euro",
+	"cp1144", "italian-euro",
+	"cp1145", "spanish-euro",
+	"cp1146", "uk-euro",
+	"cp1147", "french-euro",
+	"cp1148", "belgian-euro",
+	"cp1149", "icelandic-euro",
+	"cp1160", "thai",
+	"bracket", "oldibm", "bracket437",
+	"cp930", "japanese-kana",
+	"cp935", "simplified-chinese",
+	"cp937", "traditional-chinese",
+	"cp939", "japanese-latin",
+	"cp1388", "chinese-gb18030",
+}
+
+// ValidCodePage reports whether name is a host code page the emulators know.
+func ValidCodePage(name string) bool {
+	for _, cp := range codePages {
+		if strings.EqualFold(cp, name) {
+			return true
+		}
+	}
+	return false
+}
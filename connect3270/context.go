@@ -0,0 +1,184 @@
+package connect3270
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrShutdown is returned by operations interrupted by RequestShutdown.
+var ErrShutdown = errors.New("shutdown requested")
+
+var (
+	shutdownMu     sync.Mutex
+	shutdownCtx    context.Context
+	shutdownCancel context.CancelCauseFunc
+)
+
+func init() {
+	shutdownCtx, shutdownCancel = context.WithCancelCause(context.Background())
+}
+
+// ShutdownContext is cancelled by RequestShutdown. ResetShutdown replaces it
+// with a fresh one.
+func ShutdownContext() context.Context {
+	shutdownMu.Lock()
+	defer shutdownMu.Unlock()
+	return shutdownCtx
+}
+
+// withShutdown derives a context from ctx that is also cancelled by
+// RequestShutdown. The returned function releases it.
+func withShutdown(ctx context.Context) (context.Context, func()) {
+	merged, cancel := context.WithCancelCause(ctx)
+	stop := context.AfterFunc(ShutdownContext(), func() { cancel(ErrShutdown) })
+	return merged, func() {
+		stop()
+		cancel(context.Canceled)
+	}
+}
+
+// contextErr returns ErrShutdown when ctx ended because of RequestShutdown,
+// otherwise ctx.Err().
+func contextErr(ctx context.Context) error {
+	if ctx.Err() == nil {
+		return nil
+	}
+	if errors.Is(context.Cause(ctx), ErrShutdown) {
+		return ErrShutdown
+	}
+	return ctx.Err()
+}
+
+// sleepCtx waits for d, or less if ctx ends first.
+func sleepCtx(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return contextErr(ctx)
+	case <-timer.C:
+		return nil
+	}
+}
+
+// SetContext makes ctx govern every later operation on e: waits, retries
+// and script commands stop as soon as it is cancelled or its deadline
+// passes. RequestShutdown still applies. nil restores the default, where
+// only RequestShutdown interrupts.
+func (e *Emulator) SetContext(ctx context.Context) {
+	e.ctxMu.Lock()
+	defer e.ctxMu.Unlock()
+	if e.ctxStop != nil {
+		e.ctxStop()
+	}
+	e.ctx, e.ctxStop = nil, nil
+	if ctx != nil {
+		e.ctx, e.ctxStop = withShutdown(ctx)
+	}
+}
+
+// opContext returns the context operations on e run under.
+func (e *Emulator) opContext() context.Context {
+	e.ctxMu.Lock()
+	defer e.ctxMu.Unlock()
+	if e.ctx != nil {
+		return e.ctx
+	}
+	return ShutdownContext()
+}
+
+// withContext runs fn with ctx governing e in place of the one set by
+// SetContext.
+func (e *Emulator) withContext(ctx context.Context, fn func() error) error {
+	e.ctxMu.Lock()
+	prev, prevStop := e.ctx, e.ctxStop
+	e.ctx, e.ctxStop = withShutdown(ctx)
+	e.ctxMu.Unlock()
+	defer func() {
+		e.ctxMu.Lock()
+		e.ctxStop()
+		e.ctx, e.ctxStop = prev, prevStop
+		e.ctxMu.Unlock()
+	}()
+	return fn()
+}
+
+// ConnectCtx is Connect, abandoned when ctx ends.
+func (e *Emulator) ConnectCtx(ctx context.Context) error {
+	return e.withContext(ctx, e.Connect)
+}
+
+// DisconnectCtx is Disconnect, abandoned when ctx ends.
+func (e *Emulator) DisconnectCtx(ctx context.Context) error {
+	return e.withContext(ctx, e.Disconnect)
+}
+
+// PressCtx is Press, abandoned when ctx ends.
+func (e *Emulator) PressCtx(ctx context.Context, key string) error {
+	return e.withContext(ctx, func() error { return e.Press(key) })
+}
+
+// FillStringCtx is FillString, abandoned when ctx ends.
+func (e *Emulator) FillStringCtx(ctx context.Context, x, y int, value string) error {
+	return e.withContext(ctx, func() error { return e.FillString(x, y, value) })
+}
+
+// SetStringCtx is SetString, abandoned when ctx ends.
+func (e *Emulator) SetStringCtx(ctx context.Context, value string) error {
+	return e.withContext(ctx, func() error { return e.SetString(value) })
+}
+
+// MoveCursorCtx is MoveCursor, abandoned when ctx ends.
+func (e *Emulator) MoveCursorCtx(ctx context.Context, row, column int) error {
+	return e.withContext(ctx, func() error { return e.MoveCursor(row, column) })
+}
+
+// GetValueCtx is GetValue, abandoned when ctx ends.
+func (e *Emulator) GetValueCtx(ctx context.Context, x, y, length int) (string, error) {
+	var value string
+	err := e.withContext(ctx, func() error {
+		var err error
+		value, err = e.GetValue(x, y, length)
+		return err
+	})
+	return value, err
+}
+
+// ScreenTextCtx is ScreenText, abandoned when ctx ends.
+func (e *Emulator) ScreenTextCtx(ctx context.Context) ([]string, error) {
+	var rows []string
+	err := e.withContext(ctx, func() error {
+		var err error
+		rows, err = e.ScreenText()
+		return err
+	})
+	return rows, err
+}
+
+// ReadScreenCtx is ReadScreen, abandoned when ctx ends.
+func (e *Emulator) ReadScreenCtx(ctx context.Context) (*Screen, error) {
+	var screen *Screen
+	err := e.withContext(ctx, func() error {
+		var err error
+		screen, err = e.ReadScreen()
+		return err
+	})
+	return screen, err
+}
+
+// WaitForFieldCtx is WaitForField, abandoned when ctx ends.
+func (e *Emulator) WaitForFieldCtx(ctx context.Context, timeout time.Duration) error {
+	return e.withContext(ctx, func() error { return e.WaitForField(timeout) })
+}
+
+// WaitForTextCtx is WaitForText, abandoned when ctx ends.
+func (e *Emulator) WaitForTextCtx(ctx context.Context, text string, row, column int, timeout time.Duration) error {
+	return e.withContext(ctx, func() error { return e.WaitForText(text, row, column, timeout) })
+}
+
+// AsciiScreenGrabCtx is AsciiScreenGrab, abandoned when ctx ends.
+func (e *Emulator) AsciiScreenGrabCtx(ctx context.Context, filePath string, apiMode bool) error {
+	return e.withContext(ctx, func() error { return e.AsciiScreenGrab(filePath, apiMode) })
+}
@@ -0,0 +1,38 @@
+// Package connect3270 scripts IBM 3270 hosts: it connects a terminal
+// session, types into fields, presses attention keys and reads the screen
+// back.
+//
+// Programs embedding the package describe a session with Options and open
+// it:
+//
+//	s, err := connect3270.Open(ctx, connect3270.Options{Host: "mainframe", Port: 23})
+//	if err != nil {
+//		return err
+//	}
+//	defer s.Disconnect()
+//	if err := s.WaitForFieldCtx(ctx, 10*time.Second); err != nil {
+//		return err
+//	}
+//	if err := s.FillStringCtx(ctx, 5, 21, "user"); err != nil {
+//		return err
+//	}
+//	if err := s.PressCtx(ctx, connect3270.Enter); err != nil {
+//		return err
+//	}
+//	err = s.WaitForTextCtx(ctx, "READY", 0, 0, 10*time.Second)
+//
+// # Stability
+//
+// Options, NewSession, Open, the Session interface, the Emulator methods it
+// lists, Screen, Printer, the key constants and the Err values follow
+// semantic versioning from Version 1.0.0: they change incompatibly only
+// with a new major version.
+//
+// The package-level variables (Backend, Headless, Verbose, ReuseProcesses)
+// and NewEmulator configure the 3270Connect command and are kept for it.
+// Sessions made by NewSession do not read Backend, Headless, Verbose or
+// ReuseProcesses; Options carries their own choices.
+package connect3270
+
+// Version is the version of the package's public API.
+const Version = "1.0.0"
@@ -0,0 +1,53 @@
+package connect3270
+
+import (
+	"errors"
+	"strings"
+)
+
+// Errors to test for with errors.Is. Both backends report them, whatever
+// the wording of the emulator's own message.
+var (
+	// ErrNotConnected means the session has no host connection.
+	ErrNotConnected = errors.New("not connected")
+	// ErrKeyboardLocked means the host had the keyboard locked when the
+	// command tried to type or press a key.
+	ErrKeyboardLocked = errors.New("keyboard locked")
+	// ErrTimeout means a wait ended before its condition was met.
+	ErrTimeout = errors.New("timed out")
+	// ErrInvalidKey means Press was given a key it does not know.
+	ErrInvalidKey = errors.New("invalid key")
+)
+
+// CommandError is a command the emulator refused, such as String on a
+// protected field. Message is the emulator's own text.
+type CommandError struct {
+	Command string
+	Message string
+	err     error
+}
+
+func (e *CommandError) Error() string { return e.Message }
+
+func (e *CommandError) Unwrap() error { return e.err }
+
+// Is matches the emulator's message against the package's Err values.
+func (e *CommandError) Is(target error) bool {
+	message := strings.ToLower(e.Message)
+	switch target {
+	case ErrNotConnected:
+		return strings.Contains(message, "not connected")
+	case ErrKeyboardLocked:
+		return strings.Contains(message, "keyboard locked")
+	case ErrTimeout:
+		return strings.Contains(message, "timed out")
+	}
+	return false
+}
+
+// timeoutError is a wait that gave up; its text says what was awaited.
+type timeoutError struct{ message string }
+
+func (e *timeoutError) Error() string { return e.message }
+
+func (e *timeoutError) Is(target error) bool { return target == ErrTimeout }
@@ -0,0 +1,368 @@
+package connect3270
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Emulator backends.
+const (
+	// BackendX3270 drives the bundled x3270/s3270 binaries over their
+	// script port.
+	BackendX3270 = "x3270"
+	// BackendNative speaks TN3270 from Go, with no external process.
+	BackendNative = "native"
+)
+
+// Backend selects how emulators reach the host. Empty means BackendX3270.
+var Backend string
+
+const nativePollInterval = 50 * time.Millisecond
+
+// nativeCodePages are the code page names the native backend understands.
+var nativeCodePages = []string{"cp037", "cp37", "us", "us-intl"}
+
+// NativeSupportsCodePage reports whether the native backend can use the
+// host code page name.
+func NativeSupportsCodePage(name string) bool {
+	for _, cp := range nativeCodePages {
+		if strings.EqualFold(cp, name) {
+			return true
+		}
+	}
+	return false
+}
+
+func nativeBackend() bool {
+	return Backend == BackendNative
+}
+
+// createNativeSession replaces the emulator process with an in-process
+// TN3270 session.
+func (e *Emulator) createNativeSession() error {
+	session, err := dialNative(e.opContext(), e)
+	if err != nil {
+		return err
+	}
+	e.scriptMu.Lock()
+	e.native = session
+	e.scriptMu.Unlock()
+	return nil
+}
+
+func (e *Emulator) nativeRequest(command string) (string, error) {
+	e.scriptMu.Lock()
+	session := e.native
+	e.scriptMu.Unlock()
+	if session == nil {
+		return "", ErrNotConnected
+	}
+	ctx := e.opContext()
+	output, err := session.execute(ctx, command)
+	if err != nil && ctx.Err() == nil {
+		// Report refusals the way the x3270 backend does.
+		err = &CommandError{Command: strings.TrimSpace(command), Message: err.Error(), err: err}
+	}
+	if err == nil && strings.EqualFold(strings.TrimSpace(command), "quit") {
+		e.scriptMu.Lock()
+		if e.native == session {
+			e.native = nil
+		}
+		e.scriptMu.Unlock()
+	}
+	return output, err
+}
+
+// splitAction splits "Name(arg1, arg2)" into its name and raw argument text.
+func splitAction(command string) (string, string) {
+	command = strings.TrimSpace(command)
+	open := strings.Index(command, "(")
+	if open < 0 || !strings.HasSuffix(command, ")") {
+		return command, ""
+	}
+	return strings.TrimSpace(command[:open]), command[open+1 : len(command)-1]
+}
+
+func splitArgs(raw string) []string {
+	if strings.TrimSpace(raw) == "" {
+		return nil
+	}
+	args := strings.Split(raw, ",")
+	for i := range args {
+		args[i] = strings.TrimSpace(args[i])
+	}
+	return args
+}
+
+func intArgs(name string, args []string) ([]int, error) {
+	values := make([]int, len(args))
+	for i, arg := range args {
+		n, err := strconv.Atoi(arg)
+		if err != nil {
+			return nil, fmt.Errorf("%s(): invalid argument %q", name, arg)
+		}
+		values[i] = n
+	}
+	return values, nil
+}
+
+// execute runs one script command against the session and answers the way
+// s3270 does: optional "data:" lines followed by the status line.
+func (s *nativeSession) execute(ctx context.Context, command string) (string, error) {
+	start := time.Now()
+	name, raw := splitAction(command)
+	args := splitArgs(raw)
+	var data []string
+	var err error
+	switch strings.ToLower(name) {
+	case "wait":
+		err = s.waitInputField(ctx, args)
+	case "movecursor":
+		err = s.moveCursor(args)
+	case "string":
+		err = s.withUnlockedKeyboard(ctx, func(screen *nativeScreen) error { return screen.typeString(raw) })
+	case "tab", "home", "eraseinput":
+		s.mu.Lock()
+		switch strings.ToLower(name) {
+		case "tab":
+			s.screen.tab()
+		case "home":
+			s.screen.home()
+		default:
+			s.screen.eraseUnprotected()
+		}
+		s.mu.Unlock()
+	case "eraseeof":
+		err = s.withUnlockedKeyboard(ctx, func(screen *nativeScreen) error { return screen.eraseEOF() })
+	case "enter":
+		err = s.sendAID(ctx, aidEnter)
+	case "clear":
+		err = s.sendAID(ctx, aidClear)
+	case "pf", "pa":
+		n, convErr := strconv.Atoi(raw)
+		switch {
+		case convErr != nil:
+			err = fmt.Errorf("%s(): invalid argument %q", name, raw)
+		case strings.EqualFold(name, "pf") && n >= 1 && n < len(aidPF):
+			err = s.sendAID(ctx, aidPF[n])
+		case strings.EqualFold(name, "pa") && n >= 1 && n < len(aidPA):
+			err = s.sendAID(ctx, aidPA[n])
+		default:
+			err = fmt.Errorf("%s(): argument %d out of range", name, n)
+		}
+	case "query":
+		data, err = s.query(raw)
+	case "snap":
+		switch strings.ToLower(raw) {
+		case "rows":
+			data = []string{strconv.Itoa(nativeRows)}
+		case "cols":
+			data = []string{strconv.Itoa(nativeColumns)}
+		default:
+			err = fmt.Errorf("Snap(): unsupported argument %q", raw)
+		}
+	case "ascii":
+		data, err = s.ascii(args)
+	case "readbuffer":
+		if raw != "" && !strings.EqualFold(raw, "Ascii") {
+			err = fmt.Errorf("ReadBuffer(): unsupported argument %q", raw)
+			break
+		}
+		s.mu.Lock()
+		for row := 0; row < nativeRows; row++ {
+			data = append(data, s.screen.readBufferRow(row))
+		}
+		s.mu.Unlock()
+	case "quit":
+		s.close()
+		return "", nil
+	default:
+		err = fmt.Errorf("Unknown action: %s", name)
+	}
+	if err != nil {
+		return "", err
+	}
+	lines := make([]string, 0, len(data)+1)
+	for _, line := range data {
+		lines = append(lines, "data: "+line)
+	}
+	lines = append(lines, s.status(time.Since(start)))
+	return strings.Join(lines, "\n"), nil
+}
+
+// waitInputField implements Wait(timeout, InputField): it returns once the
+// host has sent a formatted screen with an input field and unlocked the
+// keyboard.
+func (s *nativeSession) waitInputField(ctx context.Context, args []string) error {
+	timeout := scriptIOTimeout
+	if len(args) > 0 {
+		seconds, err := strconv.Atoi(args[0])
+		if err != nil {
+			return fmt.Errorf("Wait(): invalid timeout %q", args[0])
+		}
+		timeout = time.Duration(seconds) * time.Second
+	}
+	if len(args) > 1 && !strings.EqualFold(args[1], "InputField") {
+		return fmt.Errorf("Wait(): unsupported condition %q", args[1])
+	}
+	deadline := time.Now().Add(timeout)
+	for {
+		s.mu.Lock()
+		closed := s.closed
+		ready := !s.screen.locked && s.screen.hasInputField()
+		s.mu.Unlock()
+		switch {
+		case closed:
+			return errors.New("Wait(): Not connected")
+		case ready:
+			return nil
+		case time.Now().After(deadline):
+			return errors.New("Wait(): Timed out")
+		}
+		if err := sleepCtx(ctx, nativePollInterval); err != nil {
+			return err
+		}
+	}
+}
+
+// withUnlockedKeyboard waits for the host to unlock the keyboard, as s3270
+// queues keystrokes typed ahead, then runs fn on the screen.
+func (s *nativeSession) withUnlockedKeyboard(ctx context.Context, fn func(*nativeScreen) error) error {
+	deadline := time.Now().Add(scriptIOTimeout)
+	for {
+		s.mu.Lock()
+		if s.closed {
+			s.mu.Unlock()
+			return errors.New("Not connected")
+		}
+		if !s.screen.locked {
+			err := fn(s.screen)
+			s.mu.Unlock()
+			return err
+		}
+		s.mu.Unlock()
+		if time.Now().After(deadline) {
+			return errKeyboardLocked
+		}
+		if err := sleepCtx(ctx, nativePollInterval); err != nil {
+			return err
+		}
+	}
+}
+
+func (s *nativeSession) sendAID(ctx context.Context, aid byte) error {
+	var reply []byte
+	err := s.withUnlockedKeyboard(ctx, func(screen *nativeScreen) error {
+		var err error
+		reply, err = screen.aidReply(aid)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if err := s.sendRecord(reply); err != nil {
+		return err
+	}
+	// Like s3270, an attention key completes once the host has answered
+	// and unlocked the keyboard (or dropped the connection).
+	deadline := time.Now().Add(scriptIOTimeout)
+	for time.Now().Before(deadline) {
+		s.mu.Lock()
+		done := s.closed || !s.screen.locked
+		s.mu.Unlock()
+		if done {
+			break
+		}
+		if err := sleepCtx(ctx, nativePollInterval); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *nativeSession) moveCursor(args []string) error {
+	values, err := intArgs("MoveCursor", args)
+	if err != nil {
+		return err
+	}
+	if len(values) != 2 || values[0] < 0 || values[0] >= nativeRows || values[1] < 0 || values[1] >= nativeColumns {
+		return fmt.Errorf("MoveCursor(): invalid coordinates %v", args)
+	}
+	s.mu.Lock()
+	s.screen.cursor = values[0]*nativeColumns + values[1]
+	s.mu.Unlock()
+	return nil
+}
+
+func (s *nativeSession) query(keyword string) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch strings.ToLower(keyword) {
+	case "connectionstate":
+		switch {
+		case s.closed:
+			return []string{"not-connected"}, nil
+		case s.tn3270e:
+			return []string{"connected-tn3270e"}, nil
+		default:
+			return []string{"connected-3270"}, nil
+		}
+	case "cursor":
+		return []string{fmt.Sprintf("%d %d", s.screen.cursor/nativeColumns, s.screen.cursor%nativeColumns)}, nil
+	case "luname":
+		return []string{s.luName}, nil
+	}
+	return nil, fmt.Errorf("Query(): unknown keyword %q", keyword)
+}
+
+// ascii implements Ascii() for the whole screen and Ascii(row, col, len).
+func (s *nativeSession) ascii(args []string) ([]string, error) {
+	values, err := intArgs("Ascii", args)
+	if err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch len(values) {
+	case 0:
+		lines := make([]string, nativeRows)
+		for row := range lines {
+			lines[row] = s.screen.text(row*nativeColumns, nativeColumns)
+		}
+		return lines, nil
+	case 3:
+		row, column, length := values[0], values[1], values[2]
+		if row < 0 || row >= nativeRows || column < 0 || column >= nativeColumns || length < 0 {
+			return nil, fmt.Errorf("Ascii(): invalid coordinates %v", args)
+		}
+		return []string{s.screen.text(row*nativeColumns+column, length)}, nil
+	}
+	return nil, fmt.Errorf("Ascii(): unsupported arguments %v", args)
+}
+
+// status renders the s3270 status line: keyboard, formatting, protection at
+// the cursor, connection, mode, model, size, cursor, window and timing.
+func (s *nativeSession) status(elapsed time.Duration) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	keyboard, formatted, protected := "U", "U", "U"
+	if s.screen.locked {
+		keyboard = "L"
+	}
+	if s.screen.formatted() {
+		formatted = "F"
+	}
+	if s.screen.protectedAt(s.screen.cursor) {
+		protected = "P"
+	}
+	connection, mode := "C("+s.host+")", "I"
+	if s.closed {
+		connection, mode = "N", "N"
+	}
+	return fmt.Sprintf("%s %s %s %s %s %s %d %d %d %d 0x0 %.3f", keyboard, formatted, protected, connection, mode,
+		nativeModel, nativeRows, nativeColumns, s.screen.cursor/nativeColumns, s.screen.cursor%nativeColumns, elapsed.Seconds())
+}
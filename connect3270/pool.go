@@ -0,0 +1,114 @@
+package connect3270
+
+import (
+	"bufio"
+	"io"
+	"log/slog"
+	"os/exec"
+	"sync"
+	"time"
+)
+
+// ReuseProcesses keeps emulator processes running between sessions.
+// Disconnect then only drops the host connection and parks the process in
+// a pool; the next Connect with the same emulator options takes it back
+// and reconnects it with the Connect() action instead of starting a new
+// binary.
+var ReuseProcesses bool
+
+const poolQuitGrace = 2 * time.Second
+
+// emulatorProcess is a running x3270 or s3270 child and its script channel.
+type emulatorProcess struct {
+	cmd  *exec.Cmd
+	key  string        // binary and options, without the host
+	done chan struct{} // closed once the process has exited
+
+	conn       scriptChannel
+	reader     *bufio.Reader
+	listenPort int
+}
+
+func (p *emulatorProcess) exited() bool {
+	select {
+	case <-p.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// stop asks the process to quit and kills it if it does not.
+func (p *emulatorProcess) stop() {
+	if p.conn != nil {
+		_ = p.conn.SetWriteDeadline(time.Now().Add(time.Second))
+		_, _ = io.WriteString(p.conn, "quit\n")
+		p.conn.Close()
+	}
+	go func() {
+		select {
+		case <-p.done:
+		case <-time.After(poolQuitGrace):
+			if p.cmd.Process != nil {
+				_ = p.cmd.Process.Kill()
+			}
+		}
+	}()
+}
+
+var (
+	processPoolMu sync.Mutex
+	processPool   []*emulatorProcess
+)
+
+// takePooledProcess returns an idle process started with the options in
+// key, or nil.
+func takePooledProcess(key string) *emulatorProcess {
+	processPoolMu.Lock()
+	defer processPoolMu.Unlock()
+	for i := len(processPool) - 1; i >= 0; i-- {
+		p := processPool[i]
+		if p.exited() {
+			processPool = append(processPool[:i], processPool[i+1:]...)
+			continue
+		}
+		if p.key == key {
+			processPool = append(processPool[:i], processPool[i+1:]...)
+			return p
+		}
+	}
+	return nil
+}
+
+func returnToPool(p *emulatorProcess) {
+	if p.exited() || ShutdownRequested() {
+		p.stop()
+		return
+	}
+	processPoolMu.Lock()
+	processPool = append(processPool, p)
+	processPoolMu.Unlock()
+}
+
+// PooledProcesses reports how many idle emulator processes are waiting
+// for reuse.
+func PooledProcesses() int {
+	processPoolMu.Lock()
+	defer processPoolMu.Unlock()
+	return len(processPool)
+}
+
+// DrainProcessPool stops every idle pooled process. Call it when a run has
+// finished with its emulators.
+func DrainProcessPool() {
+	processPoolMu.Lock()
+	idle := processPool
+	processPool = nil
+	processPoolMu.Unlock()
+	if Verbose && len(idle) > 0 {
+		logPackagef(slog.LevelDebug, "Stopping %d pooled emulator processes", len(idle))
+	}
+	for _, p := range idle {
+		p.stop()
+	}
+}
@@ -0,0 +1,295 @@
+package connect3270
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const nativePrinterType = "IBM-3287-1"
+
+// TN3270E data types carried by a printer session.
+const (
+	dataType3270 = 0x00
+	dataTypeSCS  = 0x01
+)
+
+// Printer is a TN3270E printer (3287) session opened next to a display
+// session. Whatever the host prints, as SCS or as 3270 data, is rendered
+// as text and appended to a file.
+type Printer struct {
+	session *nativeSession
+	file    *os.File
+	ctx     context.Context
+	logf    func(format string, args ...any)
+
+	screen *nativeScreen // buffer for LU 3 (3270 data stream) printing
+	scs    scsDecoder
+
+	mu      sync.Mutex
+	printed strings.Builder
+	changed chan struct{} // closed and replaced whenever output arrives
+}
+
+// StartPrinter connects a printer session to e's host and appends its output
+// to filePath. With an empty luName the printer is associated with the LU
+// of e's display session, so the host sends that terminal's print to it;
+// otherwise it connects to the printer LU named. The session always runs
+// in-process, whatever the backend, using e's TLS and proxy settings.
+func (e *Emulator) StartPrinter(luName, filePath string) (*Printer, error) {
+	device := nativeDevice{terminal: nativePrinterType, deviceType: nativePrinterType}
+	if luName != "" {
+		device.luNames = []string{luName}
+	} else {
+		output, err := e.query("LuName")
+		if err != nil {
+			return nil, fmt.Errorf("printer: %w", err)
+		}
+		var display string
+		if strings.Contains(output, "data:") {
+			display = normalizeAsciiData(output)
+		}
+		if display == "" {
+			return nil, fmt.Errorf("printer: the display session has no LU name to associate with - name a printer LU")
+		}
+		device.associate = display
+	}
+	file, err := os.OpenFile(filePath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("printer: %w", err)
+	}
+	p := &Printer{
+		file:    file,
+		ctx:     e.opContext(),
+		logf:    e.logf,
+		screen:  newNativeScreen(nativeRows, nativeColumns),
+		changed: make(chan struct{}),
+	}
+	p.session, err = dialDevice(p.ctx, e, device, p.receive)
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("printer: %w", err)
+	}
+	p.logf("Printer session %s started, writing to %s", p.LUName(), filePath)
+	return p, nil
+}
+
+// LUName is the printer LU the host bound, when it said.
+func (p *Printer) LUName() string {
+	p.session.mu.Lock()
+	defer p.session.mu.Unlock()
+	return p.session.luName
+}
+
+// Output returns everything printed so far.
+func (p *Printer) Output() string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.printed.String()
+}
+
+// WaitForOutput waits until the printed output contains text, or until
+// anything has been printed when text is empty.
+func (p *Printer) WaitForOutput(text string, timeout time.Duration) error {
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+	for {
+		p.mu.Lock()
+		output, changed := p.printed.String(), p.changed
+		p.mu.Unlock()
+		if (text == "" && output != "") || (text != "" && strings.Contains(output, text)) {
+			return nil
+		}
+		select {
+		case <-changed:
+		case <-p.session.done:
+			return fmt.Errorf("printer session closed before %q was printed: %w", text, p.session.failure())
+		case <-deadline.C:
+			if text == "" {
+				return fmt.Errorf("nothing was printed within %.1fs", timeout.Seconds())
+			}
+			return fmt.Errorf("%q was not printed within %.1fs", text, timeout.Seconds())
+		case <-p.ctx.Done():
+			return contextErr(p.ctx)
+		}
+	}
+}
+
+// Close ends the printer session and closes the output file.
+func (p *Printer) Close() error {
+	p.session.close()
+	if rest := p.scs.flush(); rest != "" {
+		p.write(rest)
+	}
+	return p.file.Close()
+}
+
+// receive handles one record from the host on the reader goroutine.
+func (p *Printer) receive(dataType byte, data []byte) {
+	switch {
+	case dataType == dataTypeSCS:
+		p.write(p.scs.decode(data))
+	case dataType == dataType3270 && len(data) > 1:
+		p.screen.apply(data)
+		// Only a Write whose WCC says "start printer" produces a page.
+		switch data[0] {
+		case 0xf1, 0x01, 0xf5, 0x05, 0x7e, 0x0d:
+			if data[1]&0x08 != 0 {
+				p.write(printBuffer(p.screen, data[1]))
+			}
+		}
+	}
+}
+
+func (p *Printer) write(text string) {
+	if text == "" {
+		return
+	}
+	if _, err := p.file.WriteString(text); err != nil {
+		p.logf("Printer output: %v", err)
+	}
+	p.mu.Lock()
+	p.printed.WriteString(text)
+	close(p.changed)
+	p.changed = make(chan struct{})
+	p.mu.Unlock()
+}
+
+// printBuffer renders an LU 3 print buffer. The WCC picks the line length;
+// unformatted print (the default) ends lines at NL orders and stops at EM.
+func printBuffer(screen *nativeScreen, wcc byte) string {
+	width := [4]int{screen.columns, 40, 64, 80}[(wcc>>4)&0x03]
+	unformatted := wcc&0x30 == 0
+	var out strings.Builder
+	line := make([]rune, 0, width)
+	flush := func() {
+		out.WriteString(strings.TrimRight(string(line), " "))
+		out.WriteByte('\n')
+		line = line[:0]
+	}
+	for at, b := range screen.buffer {
+		if unformatted && b == 0x15 { // NL
+			flush()
+			continue
+		}
+		if unformatted && b == 0x19 { // EM
+			break
+		}
+		if screen.attrs[at] >= 0 || screen.hiddenAt(at) {
+			line = append(line, ' ')
+		} else {
+			line = append(line, EBCDICToRune(b))
+		}
+		if len(line) == width {
+			flush()
+		}
+	}
+	if len(line) > 0 {
+		flush()
+	}
+	return strings.TrimRight(out.String(), "\n") + "\n"
+}
+
+// scsDecoder renders SNA Character String data as text. A line may span
+// several records, so the one being built is kept between calls.
+type scsDecoder struct {
+	line   []rune
+	column int
+}
+
+func (d *scsDecoder) put(r rune) {
+	for len(d.line) <= d.column {
+		d.line = append(d.line, ' ')
+	}
+	d.line[d.column] = r
+	d.column++
+}
+
+func (d *scsDecoder) newline(out *strings.Builder) {
+	out.WriteString(strings.TrimRight(string(d.line), " "))
+	out.WriteByte('\n')
+	d.line = d.line[:0]
+	d.column = 0
+}
+
+// flush returns a line left unfinished at the end of the stream.
+func (d *scsDecoder) flush() string {
+	if len(d.line) == 0 {
+		return ""
+	}
+	var out strings.Builder
+	d.newline(&out)
+	return out.String()
+}
+
+// decode returns the text of data. Controls that only affect layout on
+// paper (fonts, margins, page size) are skipped.
+func (d *scsDecoder) decode(data []byte) string {
+	var out strings.Builder
+	for i := 0; i < len(data); i++ {
+		switch b := data[i]; b {
+		case 0x15, 0x06, 0x1e, 0x0b: // NL, RNL, IRS, VT
+			d.newline(&out)
+		case 0x25: // LF keeps the column
+			column := d.column
+			d.newline(&out)
+			d.column = column
+		case 0x0d: // CR
+			d.column = 0
+		case 0x0c: // FF
+			if len(d.line) > 0 {
+				d.newline(&out)
+			}
+			out.WriteByte('\f')
+		case 0x05: // HT
+			d.put(' ')
+			for d.column%8 != 0 {
+				d.put(' ')
+			}
+		case 0x16: // BS
+			if d.column > 0 {
+				d.column--
+			}
+		case 0x2b: // CSP: class, then a length that counts itself
+			if i+2 >= len(data) || data[i+2] == 0 {
+				return out.String()
+			}
+			i += 1 + int(data[i+2])
+		case 0x34: // PP: type and value
+			if i+2 >= len(data) {
+				return out.String()
+			}
+			value := int(data[i+2])
+			switch data[i+1] {
+			case 0xc0: // absolute horizontal
+				d.column = max(value-1, 0)
+			case 0xc8: // relative horizontal
+				d.column += value
+			case 0xc4: // relative vertical
+				for n := 0; n < value; n++ {
+					column := d.column
+					d.newline(&out)
+					d.column = column
+				}
+			}
+			i += 2
+		case 0x35: // TRN: a length, then characters printed as they are
+			if i+1 >= len(data) {
+				return out.String()
+			}
+			end := min(i+2+int(data[i+1]), len(data))
+			for _, c := range data[i+2 : end] {
+				d.put(EBCDICToRune(c))
+			}
+			i = end - 1
+		default:
+			if b >= 0x40 {
+				d.put(EBCDICToRune(b))
+			}
+		}
+	}
+	return out.String()
+}
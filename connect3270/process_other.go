@@ -0,0 +1,10 @@
+//go:build !windows
+// +build !windows
+
+package connect3270
+
+import "os/exec"
+
+// configureEmulatorProcess is a no-op outside Windows; s3270 never opens a
+// window there.
+func configureEmulatorProcess(cmd *exec.Cmd, headless bool) {}
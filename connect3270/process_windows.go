@@ -0,0 +1,25 @@
+//go:build windows
+// +build windows
+
+package connect3270
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// createNoWindow keeps console programs from allocating a console window.
+const createNoWindow = 0x08000000
+
+// configureEmulatorProcess detaches headless emulators from any console so
+// Windows servers without an interactive session do not open a window per
+// session.
+func configureEmulatorProcess(cmd *exec.Cmd, headless bool) {
+	if !headless {
+		return
+	}
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		HideWindow:    true,
+		CreationFlags: createNoWindow,
+	}
+}
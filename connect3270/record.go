@@ -0,0 +1,34 @@
+package connect3270
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+)
+
+// RecordSession runs an interactive x3270 (wc3270 on Windows) session against
+// host:port with data stream tracing written to traceFile, and returns once
+// the user closes the emulator.
+func RecordSession(host string, port int, traceFile string) error {
+	binaryName, resource := "x3270", "x3270.traceMonitor: False"
+	if runtime.GOOS == "windows" {
+		binaryName, resource = "wc3270", "wc3270.traceMonitor: False"
+	}
+	binaryFileMutex.Lock()
+	binaryFilePath, err := getOrCreateBinaryFile(binaryName)
+	binaryFileMutex.Unlock()
+	if err != nil {
+		return err
+	}
+
+	cmd := exec.Command(binaryFilePath, "-utf8", "-model", "3279-2", "-trace", "-tracefile", traceFile,
+		"-xrm", resource, fmt.Sprintf("%s:%d", host, port))
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%s exited: %w", binaryName, err)
+	}
+	return nil
+}
@@ -0,0 +1,257 @@
+package connect3270
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Screen is a structured snapshot of the terminal: the text of every row and
+// the fields defined on it. Rows and columns are 1-based.
+type Screen struct {
+	Rows         int           `json:"rows"`
+	Columns      int           `json:"columns"`
+	CursorRow    int           `json:"cursorRow"`
+	CursorColumn int           `json:"cursorColumn"`
+	Lines        []string      `json:"lines"`
+	Fields       []ScreenField `json:"fields"`
+}
+
+// ScreenField describes one 3270 field. Row and Column locate the first
+// character after the field attribute.
+type ScreenField struct {
+	Row         int    `json:"row"`
+	Column      int    `json:"column"`
+	Length      int    `json:"length"`
+	Text        string `json:"text"`
+	Protected   bool   `json:"protected"`
+	Numeric     bool   `json:"numeric"`
+	Hidden      bool   `json:"hidden"`
+	Intensified bool   `json:"intensified"`
+	Modified    bool   `json:"modified"`
+	Color       string `json:"color,omitempty"`
+	Highlight   string `json:"highlight,omitempty"`
+}
+
+// 3270 field attribute bits.
+const (
+	faProtected   = 0x20
+	faNumeric     = 0x10
+	faDisplayMask = 0x0c
+	faIntensify   = 0x08
+	faNonDisplay  = 0x0c
+	faModified    = 0x01
+)
+
+var fieldColors = map[uint64]string{
+	0xf1: "blue",
+	0xf2: "red",
+	0xf3: "pink",
+	0xf4: "green",
+	0xf5: "turquoise",
+	0xf6: "yellow",
+	0xf7: "white",
+}
+
+var fieldHighlights = map[uint64]string{
+	0xf1: "blink",
+	0xf2: "reverse",
+	0xf4: "underscore",
+}
+
+type rawField struct {
+	start int // buffer offset of the attribute cell
+	attrs map[uint64]uint64
+}
+
+// ParseReadBuffer builds a Screen from the output of ReadBuffer(Ascii).
+// Cursor information is not part of that output and is left at zero.
+func ParseReadBuffer(raw string) (*Screen, error) {
+	var cells []rune
+	var fields []rawField
+	rows, columns := 0, 0
+	for _, line := range strings.Split(raw, "\n") {
+		line = strings.TrimRight(line, "\r")
+		if !strings.HasPrefix(line, "data:") {
+			continue
+		}
+		rowCells := 0
+		for _, token := range strings.Fields(strings.TrimPrefix(line, "data:")) {
+			switch {
+			case strings.HasPrefix(token, "SF("):
+				attrs, err := parseOrderAttributes(token)
+				if err != nil {
+					return nil, err
+				}
+				fields = append(fields, rawField{start: len(cells), attrs: attrs})
+				cells = append(cells, ' ')
+				rowCells++
+			case strings.HasPrefix(token, "SA("):
+				// Character attributes do not occupy a cell.
+			default:
+				r, err := decodeBufferCell(token)
+				if err != nil {
+					return nil, err
+				}
+				cells = append(cells, r)
+				rowCells++
+			}
+		}
+		if rows == 0 {
+			columns = rowCells
+		} else if rowCells != columns {
+			return nil, fmt.Errorf("ReadBuffer row %d has %d cells, expected %d", rows+1, rowCells, columns)
+		}
+		rows++
+	}
+	if rows == 0 || columns == 0 {
+		return nil, fmt.Errorf("ReadBuffer returned no screen data")
+	}
+
+	screen := &Screen{Rows: rows, Columns: columns, Lines: make([]string, rows), Fields: []ScreenField{}}
+	for r := 0; r < rows; r++ {
+		screen.Lines[r] = string(cells[r*columns : (r+1)*columns])
+	}
+	total := len(cells)
+	for i, f := range fields {
+		// A field runs to the next attribute, wrapping past the end of the
+		// buffer for the last one.
+		next := fields[(i+1)%len(fields)].start
+		length := next - f.start - 1
+		if length < 0 {
+			length += total
+		}
+		var text strings.Builder
+		for j := 1; j <= length; j++ {
+			text.WriteRune(cells[(f.start+j)%total])
+		}
+		dataStart := (f.start + 1) % total
+		fa := f.attrs[0xc0]
+		screen.Fields = append(screen.Fields, ScreenField{
+			Row:         dataStart/columns + 1,
+			Column:      dataStart%columns + 1,
+			Length:      length,
+			Text:        text.String(),
+			Protected:   fa&faProtected != 0,
+			Numeric:     fa&faNumeric != 0,
+			Hidden:      fa&faDisplayMask == faNonDisplay,
+			Intensified: fa&faDisplayMask == faIntensify,
+			Modified:    fa&faModified != 0,
+			Color:       fieldColors[f.attrs[0x42]],
+			Highlight:   fieldHighlights[f.attrs[0x41]],
+		})
+	}
+	return screen, nil
+}
+
+// decodeBufferCell decodes one character cell. With -utf8 the emulator
+// writes each cell as the hex of its UTF-8 bytes, so "c3a9" is é; code pages
+// other than cp037 depend on this for their accented characters.
+func decodeBufferCell(token string) (rune, error) {
+	raw, err := hex.DecodeString(token)
+	if err != nil || len(raw) == 0 {
+		return 0, fmt.Errorf("unexpected ReadBuffer token %q", token)
+	}
+	r, size := utf8.DecodeRune(raw)
+	if r == utf8.RuneError && size <= 1 {
+		// Not UTF-8: a single byte is a Latin-1 code point.
+		if len(raw) != 1 {
+			return 0, fmt.Errorf("unexpected ReadBuffer token %q", token)
+		}
+		r = rune(raw[0])
+	}
+	if r < ' ' {
+		r = ' '
+	}
+	return r, nil
+}
+
+// parseOrderAttributes decodes "SF(c0=e8,42=f4)" into attribute type/value pairs.
+func parseOrderAttributes(token string) (map[uint64]uint64, error) {
+	body := strings.TrimSuffix(token[strings.Index(token, "(")+1:], ")")
+	attrs := make(map[uint64]uint64)
+	for _, pair := range strings.Split(body, ",") {
+		if pair == "" {
+			continue
+		}
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("malformed field order %q", token)
+		}
+		key, err := strconv.ParseUint(kv[0], 16, 8)
+		if err != nil {
+			return nil, fmt.Errorf("malformed field order %q", token)
+		}
+		value, err := strconv.ParseUint(kv[1], 16, 8)
+		if err != nil {
+			return nil, fmt.Errorf("malformed field order %q", token)
+		}
+		attrs[key] = value
+	}
+	return attrs, nil
+}
+
+// ReadScreen returns the current screen with its fields and cursor position.
+func (e *Emulator) ReadScreen() (*Screen, error) {
+	output, err := e.execCommandOutput("ReadBuffer(Ascii)")
+	if err != nil {
+		return nil, err
+	}
+	screen, err := ParseReadBuffer(output)
+	if err != nil {
+		return nil, err
+	}
+	if row, column, err := e.Cursor(); err == nil {
+		screen.CursorRow = row
+		screen.CursorColumn = column
+	}
+	return screen, nil
+}
+
+// FieldAt returns the field containing the 1-based row and column, or nil
+// when the position is outside every field (or is a field attribute).
+func (s *Screen) FieldAt(row, column int) *ScreenField {
+	total := s.Rows * s.Columns
+	if total == 0 || row < 1 || row > s.Rows || column < 1 || column > s.Columns {
+		return nil
+	}
+	pos := (row-1)*s.Columns + column - 1
+	for i := range s.Fields {
+		f := &s.Fields[i]
+		start := (f.Row-1)*s.Columns + f.Column - 1
+		if (pos-start+total)%total < f.Length {
+			return f
+		}
+	}
+	return nil
+}
+
+// InputFieldAfter returns the first unprotected field that starts after the
+// first occurrence of label on the screen, or nil when there is none.
+func (s *Screen) InputFieldAfter(label string) *ScreenField {
+	if label == "" {
+		return nil
+	}
+	buffer := []rune(strings.Join(s.Lines, ""))
+	needle := []rune(label)
+	labelEnd := -1
+	for i := 0; i+len(needle) <= len(buffer); i++ {
+		if string(buffer[i:i+len(needle)]) == label {
+			labelEnd = i + len(needle)
+			break
+		}
+	}
+	if labelEnd < 0 {
+		return nil
+	}
+	for i := range s.Fields {
+		f := &s.Fields[i]
+		start := (f.Row-1)*s.Columns + f.Column - 1
+		if !f.Protected && start >= labelEnd {
+			return f
+		}
+	}
+	return nil
+}
@@ -0,0 +1,26 @@
+package connect3270
+
+import (
+	"errors"
+
+	psnet "github.com/shirou/gopsutil/net"
+)
+
+// errScriptPortPending means the emulator has not opened its script port yet.
+var errScriptPortPending = errors.New("emulator has not opened its script port yet")
+
+// listeningPort returns the TCP port the emulator process pid listens on.
+// Emulators are started with -scriptport 0, so the OS picks a free port and
+// no range has to be probed beforehand.
+func listeningPort(pid int) (int, error) {
+	conns, err := psnet.ConnectionsPid("tcp", int32(pid))
+	if err != nil {
+		return 0, err
+	}
+	for _, c := range conns {
+		if c.Status == "LISTEN" && c.Laddr.Port != 0 {
+			return int(c.Laddr.Port), nil
+		}
+	}
+	return 0, errScriptPortPending
+}
@@ -0,0 +1,202 @@
+package connect3270
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"log/slog"
+	"time"
+)
+
+// Session is the scripting surface of a 3270 session. *Emulator implements
+// it; code that drives a host can take a Session and be handed a fake in
+// its tests. Rows and columns are 1-based.
+type Session interface {
+	ConnectCtx(ctx context.Context) error
+	DisconnectCtx(ctx context.Context) error
+	HostConnected() (bool, error)
+	PressCtx(ctx context.Context, key string) error
+	FillStringCtx(ctx context.Context, row, column int, value string) error
+	SetStringCtx(ctx context.Context, value string) error
+	MoveCursorCtx(ctx context.Context, row, column int) error
+	GetValueCtx(ctx context.Context, row, column, length int) (string, error)
+	ScreenTextCtx(ctx context.Context) ([]string, error)
+	ReadScreenCtx(ctx context.Context) (*Screen, error)
+	WaitForFieldCtx(ctx context.Context, timeout time.Duration) error
+	WaitForTextCtx(ctx context.Context, text string, row, column int, timeout time.Duration) error
+}
+
+var _ Session = (*Emulator)(nil)
+
+// Options describes a session for NewSession.
+type Options struct {
+	Host string
+	Port int
+	// Name labels the session in log messages. Empty uses host:port.
+	Name string
+	// LUName binds the session to a logical unit; a comma-separated list
+	// is tried in turn.
+	LUName string
+	// CodePage is the host EBCDIC code page. The native backend only
+	// supports cp037, the default.
+	CodePage string
+	// Proxy is a SOCKS5 or HTTP CONNECT proxy URL, as for ParseProxy.
+	Proxy      string
+	TLS        bool
+	TLSOptions TLSOptions
+	// Backend is BackendNative (the default), which needs no external
+	// program, or BackendX3270.
+	Backend string
+	// Display runs the x3270 window instead of the headless s3270. It
+	// needs BackendX3270 and a display.
+	Display bool
+	// ReuseProcesses parks the x3270 backend's emulator process in the
+	// pool on Disconnect, as the package variable does for NewEmulator.
+	ReuseProcesses bool
+	// Logger receives the session's diagnostic messages: progress at debug
+	// level and failures at error level. Nil uses the package Logger; a
+	// session with neither stays quiet.
+	Logger *slog.Logger
+}
+
+// settings are an emulator's own choices for what the package variables
+// decide for emulators made by NewEmulator.
+type settings struct {
+	backend string
+	display bool
+	reuse   bool
+	logger  *slog.Logger
+}
+
+// NewSession checks opts and returns an unconnected session.
+func NewSession(opts Options) (*Emulator, error) {
+	if opts.Host == "" {
+		return nil, errors.New("Host is empty")
+	}
+	if opts.Port < 1 || opts.Port > 65535 {
+		return nil, fmt.Errorf("Port %d is out of range", opts.Port)
+	}
+	backend := opts.Backend
+	switch backend {
+	case "":
+		backend = BackendNative
+	case BackendNative, BackendX3270:
+	default:
+		return nil, fmt.Errorf("unknown backend %q - use %s or %s", backend, BackendNative, BackendX3270)
+	}
+	if opts.Display && backend != BackendX3270 {
+		return nil, errors.New("Display needs the x3270 backend")
+	}
+	if opts.CodePage != "" {
+		if backend == BackendNative && !NativeSupportsCodePage(opts.CodePage) {
+			return nil, fmt.Errorf("the native backend only supports code page cp037, not %s", opts.CodePage)
+		}
+		if !ValidCodePage(opts.CodePage) {
+			return nil, fmt.Errorf("unknown code page %q", opts.CodePage)
+		}
+	}
+	if opts.Proxy != "" {
+		if _, err := ParseProxy(opts.Proxy); err != nil {
+			return nil, err
+		}
+	}
+	name := opts.Name
+	if name == "" {
+		name = fmt.Sprintf("%s:%d", opts.Host, opts.Port)
+	}
+	e := NewEmulator(opts.Host, opts.Port, name)
+	e.LUName = opts.LUName
+	e.CodePage = opts.CodePage
+	e.Proxy = opts.Proxy
+	e.TLS = opts.TLS
+	e.TLSOptions = opts.TLSOptions
+	e.settings = &settings{backend: backend, display: opts.Display, reuse: opts.ReuseProcesses, logger: opts.Logger}
+	return e, nil
+}
+
+// Open is NewSession followed by ConnectCtx.
+func Open(ctx context.Context, opts Options) (*Emulator, error) {
+	e, err := NewSession(opts)
+	if err != nil {
+		return nil, err
+	}
+	if err := e.ConnectCtx(ctx); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+func (e *Emulator) usesNative() bool {
+	if e.settings != nil {
+		return e.settings.backend == BackendNative
+	}
+	return nativeBackend()
+}
+
+// headless reports whether the emulator runs s3270 rather than x3270.
+func (e *Emulator) headless() bool {
+	if e.settings != nil {
+		return !e.settings.display
+	}
+	return Headless
+}
+
+// reusesProcesses reports whether Disconnect parks the emulator process in
+// the pool for the next Connect.
+func (e *Emulator) reusesProcesses() bool {
+	if e.settings != nil {
+		return e.settings.reuse
+	}
+	return ReuseProcesses
+}
+
+// usesScriptPort reports whether the emulator listens on a TCP script port.
+func (e *Emulator) usesScriptPort() bool {
+	return !e.usesNative() && !e.headless()
+}
+
+// logf logs a diagnostic message: at debug level to the session's logger,
+// or for emulators made by NewEmulator, to the standard logger under
+// Verbose.
+func (e *Emulator) logf(format string, args ...any) {
+	if e.settings != nil {
+		e.settings.log(slog.LevelDebug, format, args...)
+		return
+	}
+	if Verbose {
+		logPackagef(slog.LevelDebug, format, args...)
+	}
+}
+
+// errorf logs a failure the 3270Connect command always reports; a session
+// made by NewSession logs it at error level to its logger.
+func (e *Emulator) errorf(format string, args ...any) {
+	if e.settings != nil {
+		e.settings.log(slog.LevelError, format, args...)
+		return
+	}
+	logPackagef(slog.LevelError, format, args...)
+}
+
+// log logs to the session's Logger, or else to the package Logger; with
+// neither, the session stays quiet.
+func (s *settings) log(level slog.Level, format string, args ...any) {
+	logger := s.logger
+	if logger == nil {
+		logger = Logger
+	}
+	if logger != nil {
+		logger.Log(context.Background(), level, fmt.Sprintf(format, args...))
+	}
+}
+
+// logPackagef logs for emulators made by NewEmulator: to Logger at level,
+// or else to the standard logger.
+func logPackagef(level slog.Level, format string, args ...any) {
+	if Logger != nil {
+		Logger.Log(context.Background(), level, fmt.Sprintf(format, args...))
+		return
+	}
+	log.Printf(format, args...)
+}
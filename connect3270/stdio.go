@@ -0,0 +1,66 @@
+package connect3270
+
+import (
+	"io"
+	"os"
+	"os/exec"
+	"time"
+)
+
+// scriptChannel carries script commands to the emulator and its answers
+// back: a TCP script port connection or the stdin/stdout of s3270.
+type scriptChannel interface {
+	io.ReadWriteCloser
+	SetReadDeadline(time.Time) error
+	SetWriteDeadline(time.Time) error
+}
+
+// UsesScriptPorts reports whether emulators made by NewEmulator listen on a
+// TCP script port. Only the x3270 window needs one: s3270 is scripted over
+// stdin/stdout and the native backend has no process at all.
+func UsesScriptPorts() bool {
+	return !nativeBackend() && !Headless
+}
+
+// stdioScript is the script channel of an s3270 started without
+// -scriptport. Closing it closes s3270's stdin, which makes it exit.
+type stdioScript struct {
+	in  *os.File // s3270's stdin
+	out *os.File // s3270's stdout
+}
+
+func (s *stdioScript) Read(p []byte) (int, error)  { return s.out.Read(p) }
+func (s *stdioScript) Write(p []byte) (int, error) { return s.in.Write(p) }
+
+func (s *stdioScript) Close() error {
+	s.in.Close()
+	return s.out.Close()
+}
+
+// Pipe deadlines are not supported on Windows; there a stuck command is
+// ended by the emulator's own Wait timeouts instead.
+func (s *stdioScript) SetReadDeadline(t time.Time) error  { return s.out.SetReadDeadline(t) }
+func (s *stdioScript) SetWriteDeadline(t time.Time) error { return s.in.SetWriteDeadline(t) }
+
+// attachStdioScript connects cmd's stdin and stdout to a new script
+// channel. The returned function closes the child's ends of the pipes and
+// must be called once cmd has started (or failed to).
+func attachStdioScript(cmd *exec.Cmd) (*stdioScript, func(), error) {
+	stdinR, stdinW, err := os.Pipe()
+	if err != nil {
+		return nil, nil, err
+	}
+	stdoutR, stdoutW, err := os.Pipe()
+	if err != nil {
+		stdinR.Close()
+		stdinW.Close()
+		return nil, nil, err
+	}
+	cmd.Stdin = stdinR
+	cmd.Stdout = stdoutW
+	release := func() {
+		stdinR.Close()
+		stdoutW.Close()
+	}
+	return &stdioScript{in: stdinW, out: stdoutR}, release, nil
+}
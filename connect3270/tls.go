@@ -0,0 +1,69 @@
+package connect3270
+
+import (
+	"fmt"
+	"runtime"
+)
+
+// TLSOptions holds the certificate settings of a TLS session. File paths
+// are PEM files; KeyFile may be left empty when CertFile also holds the key.
+type TLSOptions struct {
+	CertFile    string
+	KeyFile     string
+	KeyPassword string
+	CAFile      string
+	SkipVerify  bool
+	// ServerName is the host name expected in the certificate when it
+	// differs from the one dialled, as through a local tunnel.
+	ServerName string
+}
+
+// tlsArgs returns the emulator command-line options for e.TLSOptions.
+func (e *Emulator) tlsArgs() ([]string, error) {
+	if !e.TLS {
+		return nil, nil
+	}
+	o := e.TLSOptions
+	var args []string
+	if o.CertFile != "" || o.KeyFile != "" || o.CAFile != "" {
+		// The Windows emulators use the Windows certificate store instead
+		// of certificate files.
+		if runtime.GOOS == "windows" {
+			return nil, fmt.Errorf("TLS certificate files are not supported on Windows - import them into the Windows certificate store")
+		}
+		if o.CertFile != "" {
+			args = append(args, "-certfile", o.CertFile)
+		}
+		if o.KeyFile != "" {
+			args = append(args, "-keyfile", o.KeyFile)
+		}
+		if o.KeyPassword != "" {
+			args = append(args, "-keypasswd", "string:"+o.KeyPassword)
+		}
+		if o.CAFile != "" {
+			args = append(args, "-cafile", o.CAFile)
+		}
+	}
+	if o.SkipVerify {
+		args = append(args, "-noverifycert")
+	}
+	if o.ServerName != "" {
+		args = append(args, "-accepthostname", o.ServerName)
+	}
+	return args, nil
+}
+
+// redactArgs hides the key and proxy passwords in logged command lines.
+func redactArgs(args []string) []string {
+	out := make([]string, len(args))
+	copy(out, args)
+	for i := 1; i < len(out); i++ {
+		switch out[i-1] {
+		case "-keypasswd":
+			out[i] = "string:****"
+		case "-proxy":
+			out[i] = redactProxySpec(out[i])
+		}
+	}
+	return out
+}
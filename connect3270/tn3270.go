@@ -0,0 +1,469 @@
+package connect3270
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Telnet commands and options used by TN3270 and TN3270E (RFC 1576, 2355).
+const (
+	telnetSE   = 240
+	telnetSB   = 250
+	telnetWILL = 251
+	telnetWONT = 252
+	telnetDO   = 253
+	telnetDONT = 254
+	telnetIAC  = 255
+	telnetEOR  = 239
+
+	optBinary  = 0
+	optTTYPE   = 24
+	optEOR     = 25
+	optTN3270E = 40
+
+	ttypeIS   = 0
+	ttypeSEND = 1
+
+	tn3270eAssociate  = 0
+	tn3270eConnect    = 1
+	tn3270eDeviceType = 2
+	tn3270eFunctions  = 3
+	tn3270eIS         = 4
+	tn3270eReason     = 5
+	tn3270eReject     = 6
+	tn3270eRequest    = 7
+	tn3270eSend       = 8
+)
+
+const (
+	nativeModel       = "2"
+	nativeRows        = 24
+	nativeColumns     = 80
+	nativeTerminal    = "IBM-3279-2-E"
+	nativeDeviceType  = "IBM-3278-2-E"
+	nativeDialTimeout = 10 * time.Second
+)
+
+// nativeDevice is what a native session asks the host to be.
+type nativeDevice struct {
+	terminal   string   // terminal type without TN3270E
+	deviceType string   // TN3270E device type
+	luNames    []string // LUs to connect to, tried in order
+	associate  string   // display LU a printer session is associated with
+}
+
+// nativeSession is a TN3270 connection run in-process. A reader goroutine
+// negotiates telnet options and applies the host's records to the screen;
+// script commands read and type on the screen under mu.
+type nativeSession struct {
+	host   string
+	conn   net.Conn
+	device nativeDevice
+
+	writeMu sync.Mutex
+
+	mu      sync.Mutex
+	screen  *nativeScreen
+	ready   bool // negotiated into 3270 mode
+	closed  bool
+	err     error // why the connection ended
+	tn3270e bool
+	luNames []string // LUs still to try
+	luName  string   // LU the host bound, when it said
+
+	// print receives the records of a printer session instead of the
+	// screen: the TN3270E data type and the data.
+	print func(dataType byte, data []byte)
+
+	readyCh chan struct{}
+	done    chan struct{}
+	logf    func(format string, args ...any)
+
+	// Telnet option state, only touched by the reader goroutine.
+	local  map[byte]bool // options we agreed to perform (WILL)
+	remote map[byte]bool // options we asked the host to perform (DO)
+}
+
+// dialNative connects to the emulator's host and waits until the session
+// is in 3270 mode.
+func dialNative(ctx context.Context, e *Emulator) (*nativeSession, error) {
+	device := nativeDevice{terminal: nativeTerminal, deviceType: nativeDeviceType}
+	for _, lu := range strings.Split(e.LUName, ",") {
+		if lu = strings.TrimSpace(lu); lu != "" {
+			device.luNames = append(device.luNames, lu)
+		}
+	}
+	return dialDevice(ctx, e, device, nil)
+}
+
+// dialDevice opens a session as device to the emulator's host, using its
+// TLS and proxy settings. A non-nil print makes it a printer session.
+func dialDevice(ctx context.Context, e *Emulator, device nativeDevice, print func(byte, []byte)) (*nativeSession, error) {
+	if e.CodePage != "" && !NativeSupportsCodePage(e.CodePage) {
+		return nil, fmt.Errorf("the native backend only supports code page cp037, not %s", e.CodePage)
+	}
+	addr := net.JoinHostPort(e.Host, strconv.Itoa(e.Port))
+	var conn net.Conn
+	var err error
+	if e.Proxy != "" {
+		conn, err = dialProxy(ctx, e.Proxy, addr, nativeDialTimeout)
+	} else {
+		dialer := net.Dialer{Timeout: nativeDialTimeout}
+		conn, err = dialer.DialContext(ctx, "tcp", addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if e.TLS {
+		config, err := e.nativeTLSConfig()
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+		tlsConn := tls.Client(conn, config)
+		_ = tlsConn.SetDeadline(time.Now().Add(nativeDialTimeout))
+		if err := tlsConn.Handshake(); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("TLS handshake with %s: %w", addr, err)
+		}
+		_ = tlsConn.SetDeadline(time.Time{})
+		conn = tlsConn
+	}
+
+	s := &nativeSession{
+		host:    e.Host,
+		conn:    conn,
+		device:  device,
+		luNames: device.luNames,
+		print:   print,
+		screen:  newNativeScreen(nativeRows, nativeColumns),
+		readyCh: make(chan struct{}),
+		done:    make(chan struct{}),
+		local:   make(map[byte]bool),
+		remote:  make(map[byte]bool),
+		logf:    e.logf,
+	}
+	go s.readLoop()
+
+	deadline := time.After(startupConnectTimeout)
+	for {
+		select {
+		case <-s.readyCh:
+			return s, nil
+		case <-s.done:
+			return nil, fmt.Errorf("connection to %s closed during negotiation: %w", addr, s.failure())
+		case <-deadline:
+			s.close()
+			return nil, fmt.Errorf("timed out negotiating TN3270 with %s after %.1fs", addr, startupConnectTimeout.Seconds())
+		case <-ctx.Done():
+			s.close()
+			return nil, contextErr(ctx)
+		}
+	}
+}
+
+// nativeTLSConfig builds the TLS settings from e.TLSOptions.
+func (e *Emulator) nativeTLSConfig() (*tls.Config, error) {
+	o := e.TLSOptions
+	config := &tls.Config{ServerName: e.Host, InsecureSkipVerify: o.SkipVerify}
+	if o.ServerName != "" {
+		config.ServerName = o.ServerName
+	}
+	if o.CAFile != "" {
+		pem, err := os.ReadFile(o.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", o.CAFile)
+		}
+		config.RootCAs = pool
+	}
+	if o.CertFile != "" {
+		if o.KeyPassword != "" {
+			return nil, fmt.Errorf("the native backend does not support encrypted keys - decrypt the key or use the x3270 backend")
+		}
+		keyFile := o.KeyFile
+		if keyFile == "" {
+			keyFile = o.CertFile
+		}
+		cert, err := tls.LoadX509KeyPair(o.CertFile, keyFile)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return config, nil
+}
+
+func (s *nativeSession) readLoop() {
+	defer close(s.done)
+	r := bufio.NewReader(s.conn)
+	var record []byte
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			s.fail(err)
+			return
+		}
+		if b != telnetIAC {
+			record = append(record, b)
+			continue
+		}
+		cmd, err := r.ReadByte()
+		if err != nil {
+			s.fail(err)
+			return
+		}
+		switch cmd {
+		case telnetIAC:
+			record = append(record, telnetIAC)
+		case telnetEOR:
+			s.handleRecord(record)
+			record = nil
+		case telnetDO, telnetDONT, telnetWILL, telnetWONT:
+			opt, err := r.ReadByte()
+			if err != nil {
+				s.fail(err)
+				return
+			}
+			// Anything before 3270 mode is NVT text, which is not shown.
+			record = nil
+			s.negotiate(cmd, opt)
+		case telnetSB:
+			var payload []byte
+			for {
+				c, err := r.ReadByte()
+				if err != nil {
+					s.fail(err)
+					return
+				}
+				if c == telnetIAC {
+					if c, err = r.ReadByte(); err != nil {
+						s.fail(err)
+						return
+					}
+					if c == telnetSE {
+						break
+					}
+				}
+				payload = append(payload, c)
+			}
+			record = nil
+			s.subnegotiate(payload)
+		}
+	}
+}
+
+func (s *nativeSession) negotiate(cmd, opt byte) {
+	switch cmd {
+	case telnetDO:
+		switch opt {
+		case optBinary, optEOR, optTTYPE, optTN3270E:
+			if !s.local[opt] {
+				s.local[opt] = true
+				s.sendRaw(telnetIAC, telnetWILL, opt)
+			}
+		default:
+			s.sendRaw(telnetIAC, telnetWONT, opt)
+		}
+	case telnetWILL:
+		switch opt {
+		case optBinary, optEOR:
+			if !s.remote[opt] {
+				s.remote[opt] = true
+				s.sendRaw(telnetIAC, telnetDO, opt)
+			}
+		default:
+			s.sendRaw(telnetIAC, telnetDONT, opt)
+		}
+	case telnetDONT:
+		if s.local[opt] {
+			s.local[opt] = false
+			s.sendRaw(telnetIAC, telnetWONT, opt)
+		}
+	case telnetWONT:
+		s.remote[opt] = false
+	}
+	if s.local[optBinary] && s.local[optEOR] && s.remote[optBinary] && s.remote[optEOR] && !s.local[optTN3270E] {
+		s.setReady(false)
+	}
+}
+
+func (s *nativeSession) subnegotiate(p []byte) {
+	if len(p) < 2 {
+		return
+	}
+	switch {
+	case p[0] == optTTYPE && p[1] == ttypeSEND:
+		// Without TN3270E an LU is requested RFC 1646 style, as TYPE@LU.
+		terminal := s.device.terminal
+		if len(s.luNames) > 0 {
+			terminal += "@" + s.luNames[0]
+		}
+		out := append([]byte{telnetIAC, telnetSB, optTTYPE, ttypeIS}, terminal...)
+		s.sendRaw(append(out, telnetIAC, telnetSE)...)
+	case p[0] == optTN3270E && len(p) >= 3:
+		s.tn3270eNegotiate(p[1], p[2], p[3:])
+	}
+}
+
+// tn3270eNegotiate handles the TN3270E device-type and functions exchange.
+// No optional functions are requested, so records only carry 3270 data.
+func (s *nativeSession) tn3270eNegotiate(op, arg byte, rest []byte) {
+	switch {
+	case op == tn3270eSend && arg == tn3270eDeviceType:
+		s.requestDeviceType()
+	case op == tn3270eDeviceType && arg == tn3270eIS:
+		if i := strings.IndexByte(string(rest), tn3270eConnect); i >= 0 {
+			s.mu.Lock()
+			s.luName = string(rest[i+1:])
+			s.mu.Unlock()
+		}
+		s.sendRaw(telnetIAC, telnetSB, optTN3270E, tn3270eFunctions, tn3270eRequest, telnetIAC, telnetSE)
+	case op == tn3270eDeviceType && arg == tn3270eReject:
+		reason := -1
+		if len(rest) >= 2 && rest[0] == tn3270eReason {
+			reason = int(rest[1])
+		}
+		if len(s.luNames) > 1 {
+			s.luNames = s.luNames[1:]
+			s.requestDeviceType()
+			return
+		}
+		s.fail(fmt.Errorf("host rejected the TN3270E device request (reason %d)", reason))
+		s.conn.Close()
+	case op == tn3270eFunctions && arg == tn3270eRequest:
+		s.sendRaw(telnetIAC, telnetSB, optTN3270E, tn3270eFunctions, tn3270eIS, telnetIAC, telnetSE)
+		s.setReady(true)
+	case op == tn3270eFunctions && arg == tn3270eIS:
+		s.setReady(true)
+	}
+}
+
+func (s *nativeSession) requestDeviceType() {
+	out := append([]byte{telnetIAC, telnetSB, optTN3270E, tn3270eDeviceType, tn3270eRequest}, s.device.deviceType...)
+	switch {
+	case s.device.associate != "":
+		out = append(append(out, tn3270eAssociate), s.device.associate...)
+	case len(s.luNames) > 0:
+		out = append(append(out, tn3270eConnect), s.luNames[0]...)
+	}
+	s.sendRaw(append(out, telnetIAC, telnetSE)...)
+}
+
+func (s *nativeSession) setReady(tn3270e bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ready {
+		return
+	}
+	s.ready = true
+	s.tn3270e = tn3270e
+	close(s.readyCh)
+}
+
+func (s *nativeSession) handleRecord(record []byte) {
+	s.mu.Lock()
+	if !s.ready {
+		s.mu.Unlock()
+		return
+	}
+	if s.print != nil {
+		tn3270e := s.tn3270e
+		s.mu.Unlock()
+		switch {
+		case !tn3270e:
+			s.print(0x00, record)
+		case len(record) >= 5:
+			s.print(record[0], record[5:])
+		}
+		return
+	}
+	if s.tn3270e {
+		// Only 3270-DATA records describe the screen.
+		if len(record) < 5 || record[0] != 0x00 {
+			s.mu.Unlock()
+			return
+		}
+		record = record[5:]
+	}
+	var reply []byte
+	if len(record) > 0 {
+		reply = s.screen.apply(record)
+	}
+	s.mu.Unlock()
+	if reply != nil {
+		if err := s.sendRecord(reply); err != nil {
+			s.logf("native session: sending reply: %v", err)
+		}
+	}
+}
+
+// sendRecord frames an inbound 3270 record for the host.
+func (s *nativeSession) sendRecord(data []byte) error {
+	s.mu.Lock()
+	tn3270e := s.tn3270e
+	s.mu.Unlock()
+	out := make([]byte, 0, len(data)+8)
+	if tn3270e {
+		out = append(out, 0x00, 0x00, 0x00, 0x00, 0x00) // 3270-DATA, no response, sequence 0
+	}
+	for _, b := range data {
+		out = append(out, b)
+		if b == telnetIAC {
+			out = append(out, telnetIAC)
+		}
+	}
+	return s.write(append(out, telnetIAC, telnetEOR))
+}
+
+func (s *nativeSession) sendRaw(data ...byte) {
+	if err := s.write(data); err != nil {
+		s.logf("native session: telnet negotiation: %v", err)
+	}
+}
+
+func (s *nativeSession) write(data []byte) error {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	_ = s.conn.SetWriteDeadline(time.Now().Add(scriptIOTimeout))
+	_, err := s.conn.Write(data)
+	return err
+}
+
+func (s *nativeSession) fail(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.closed {
+		s.closed = true
+		s.err = err
+	}
+}
+
+// failure returns why the connection ended.
+func (s *nativeSession) failure() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err == nil {
+		return errors.New("connection closed")
+	}
+	return s.err
+}
+
+func (s *nativeSession) close() {
+	s.fail(errors.New("session closed"))
+	s.conn.Close()
+	<-s.done
+}
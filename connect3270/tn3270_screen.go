@@ -0,0 +1,508 @@
+package connect3270
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// 3270 attention identifiers.
+const (
+	aidNone  = 0x60
+	aidEnter = 0x7d
+	aidClear = 0x6d
+	aidQuery = 0x88
+)
+
+var aidPF = [25]byte{0,
+	0xf1, 0xf2, 0xf3, 0xf4, 0xf5, 0xf6, 0xf7, 0xf8, 0xf9, 0x7a, 0x7b, 0x7c,
+	0xc1, 0xc2, 0xc3, 0xc4, 0xc5, 0xc6, 0xc7, 0xc8, 0xc9, 0x4a, 0x4b, 0x4c}
+
+var aidPA = [4]byte{0, 0x6c, 0x6e, 0x6b}
+
+// 3270 orders.
+const (
+	orderSF  = 0x1d
+	orderSFE = 0x29
+	orderSBA = 0x11
+	orderSA  = 0x28
+	orderMF  = 0x2c
+	orderIC  = 0x13
+	orderPT  = 0x05
+	orderRA  = 0x3c
+	orderEUA = 0x12
+	orderGE  = 0x08
+)
+
+// addressCodes is the 6-bit to EBCDIC table used by 12-bit buffer addresses.
+var addressCodes = [64]byte{
+	0x40, 0xc1, 0xc2, 0xc3, 0xc4, 0xc5, 0xc6, 0xc7, 0xc8, 0xc9, 0x4a, 0x4b, 0x4c, 0x4d, 0x4e, 0x4f,
+	0x50, 0xd1, 0xd2, 0xd3, 0xd4, 0xd5, 0xd6, 0xd7, 0xd8, 0xd9, 0x5a, 0x5b, 0x5c, 0x5d, 0x5e, 0x5f,
+	0x60, 0x61, 0xe2, 0xe3, 0xe4, 0xe5, 0xe6, 0xe7, 0xe8, 0xe9, 0x6a, 0x6b, 0x6c, 0x6d, 0x6e, 0x6f,
+	0xf0, 0xf1, 0xf2, 0xf3, 0xf4, 0xf5, 0xf6, 0xf7, 0xf8, 0xf9, 0x7a, 0x7b, 0x7c, 0x7d, 0x7e, 0x7f,
+}
+
+var errKeyboardLocked = errors.New("Keyboard locked")
+
+// nativeScreen is the presentation space of a native session: EBCDIC
+// characters, field attributes and the cursor, kept up to date from the
+// host's data stream and from typing.
+type nativeScreen struct {
+	rows, columns int
+	buffer        []byte // EBCDIC characters
+	attrs         []int  // field attribute at each cell, or -1
+	highlight     []byte // extended highlighting of the field starting here
+	color         []byte // extended color of the field starting here
+	cursor        int
+	locked        bool // keyboard locked until the host restores it
+	aid           byte // attention identifier of the last key sent
+}
+
+func newNativeScreen(rows, columns int) *nativeScreen {
+	s := &nativeScreen{rows: rows, columns: columns, aid: aidNone}
+	s.erase()
+	return s
+}
+
+func (s *nativeScreen) erase() {
+	size := s.rows * s.columns
+	s.buffer = make([]byte, size)
+	s.attrs = make([]int, size)
+	s.highlight = make([]byte, size)
+	s.color = make([]byte, size)
+	for i := range s.attrs {
+		s.attrs[i] = -1
+	}
+	s.cursor = 0
+}
+
+func (s *nativeScreen) address(b1, b2 byte) int {
+	var addr int
+	if b1&0xc0 == 0 {
+		addr = int(b1&0x3f)<<8 | int(b2) // 14-bit addressing
+	} else {
+		addr = int(b1&0x3f)<<6 | int(b2&0x3f) // 12-bit addressing
+	}
+	return addr % len(s.buffer)
+}
+
+func encodeAddress(addr int) []byte {
+	return []byte{addressCodes[(addr>>6)&0x3f], addressCodes[addr&0x3f]}
+}
+
+// apply processes one record from the host and returns the inbound reply
+// it asks for, if any.
+func (s *nativeScreen) apply(data []byte) []byte {
+	switch data[0] {
+	case 0xf5, 0x05, 0x7e, 0x0d: // Erase/Write, Erase/Write Alternate
+		s.erase()
+		s.writeOrders(data[1:])
+	case 0xf1, 0x01: // Write
+		s.writeOrders(data[1:])
+	case 0x6f, 0x0f: // Erase All Unprotected
+		s.eraseUnprotected()
+		s.locked = false
+		s.aid = aidNone
+	case 0xf2, 0x02: // Read Buffer
+		return s.readBuffer()
+	case 0xf6, 0x06, 0x6e, 0x0e: // Read Modified, Read Modified All
+		return s.readModified(s.aid)
+	case 0xf3, 0x11: // Write Structured Field
+		var reply []byte
+		for p := 1; p+3 <= len(data); {
+			length := int(data[p])<<8 | int(data[p+1])
+			if length < 3 || p+length > len(data) {
+				length = len(data) - p
+			}
+			switch {
+			case data[p+2] == 0x01 && length >= 5 && data[p+3] == 0xff: // Read Partition Query
+				reply = queryReply(s.rows, s.columns)
+			case data[p+2] == 0x40 && length > 4: // Outbound 3270DS
+				if r := s.apply(data[p+4 : p+length]); r != nil {
+					reply = r
+				}
+			}
+			p += length
+		}
+		return reply
+	}
+	return nil
+}
+
+func (s *nativeScreen) writeOrders(data []byte) {
+	if len(data) == 0 {
+		return
+	}
+	wcc := data[0]
+	if wcc&0x01 != 0 { // reset modified data tags
+		for i, a := range s.attrs {
+			if a >= 0 {
+				s.attrs[i] = a &^ faModified
+			}
+		}
+	}
+	size := len(s.buffer)
+	addr := s.cursor
+	put := func(b byte) {
+		s.buffer[addr] = b
+		s.attrs[addr] = -1
+		addr = (addr + 1) % size
+	}
+	for p := 1; p < len(data); p++ {
+		switch b := data[p]; b {
+		case orderSBA:
+			if p+2 < len(data) {
+				addr = s.address(data[p+1], data[p+2])
+			}
+			p += 2
+		case orderSF:
+			if p+1 < len(data) {
+				s.startField(addr, int(data[p+1]), 0, 0)
+				addr = (addr + 1) % size
+			}
+			p++
+		case orderSFE, orderMF:
+			if p+1 >= len(data) {
+				return
+			}
+			pairs := int(data[p+1])
+			fa, highlight, color := 0, byte(0), byte(0)
+			for i := 0; i < pairs && p+3+2*i < len(data); i++ {
+				value := data[p+3+2*i]
+				switch data[p+2+2*i] {
+				case 0xc0:
+					fa = int(value)
+				case 0x41:
+					highlight = value
+				case 0x42:
+					color = value
+				}
+			}
+			if b == orderSFE {
+				s.startField(addr, fa, highlight, color)
+				addr = (addr + 1) % size
+			} else if s.attrs[addr] >= 0 {
+				s.startField(addr, fa, highlight, color)
+			}
+			p += 1 + 2*pairs
+		case orderSA:
+			p += 2
+		case orderIC:
+			s.cursor = addr
+		case orderPT:
+			if next := s.nextInputField(addr); next >= 0 {
+				addr = next
+			}
+		case orderRA:
+			if p+3 >= len(data) {
+				return
+			}
+			stop := s.address(data[p+1], data[p+2])
+			char := data[p+3]
+			p += 3
+			if char == orderGE && p+1 < len(data) {
+				p++
+				char = data[p]
+			}
+			for {
+				put(char)
+				if addr == stop {
+					break
+				}
+			}
+		case orderEUA:
+			if p+2 >= len(data) {
+				return
+			}
+			stop := s.address(data[p+1], data[p+2])
+			p += 2
+			for addr != stop {
+				if s.attrs[addr] < 0 && !s.protectedAt(addr) {
+					s.buffer[addr] = 0
+				}
+				addr = (addr + 1) % size
+			}
+		case orderGE:
+			if p+1 < len(data) {
+				p++
+				put(data[p])
+			}
+		default:
+			put(b)
+		}
+	}
+	if wcc&0x02 != 0 { // keyboard restore
+		s.locked = false
+		s.aid = aidNone
+	}
+}
+
+func (s *nativeScreen) startField(addr, fa int, highlight, color byte) {
+	s.buffer[addr] = 0
+	s.attrs[addr] = fa
+	s.highlight[addr] = highlight
+	s.color[addr] = color
+}
+
+// fieldStart returns the attribute cell of the field containing pos, or -1
+// on an unformatted screen.
+func (s *nativeScreen) fieldStart(pos int) int {
+	size := len(s.buffer)
+	for i := 0; i < size; i++ {
+		if at := (pos - i + size) % size; s.attrs[at] >= 0 {
+			return at
+		}
+	}
+	return -1
+}
+
+func (s *nativeScreen) formatted() bool {
+	return s.fieldStart(0) >= 0
+}
+
+// protectedAt reports whether typing at pos is refused: attribute cells and
+// protected fields are.
+func (s *nativeScreen) protectedAt(pos int) bool {
+	if s.attrs[pos] >= 0 {
+		return true
+	}
+	start := s.fieldStart(pos)
+	return start >= 0 && s.attrs[start]&faProtected != 0
+}
+
+func (s *nativeScreen) hiddenAt(pos int) bool {
+	start := s.fieldStart(pos)
+	return start >= 0 && s.attrs[start]&faDisplayMask == faNonDisplay
+}
+
+// nextInputField returns the first cell of the next unprotected field at or
+// after pos, or -1 when the screen has none.
+func (s *nativeScreen) nextInputField(pos int) int {
+	size := len(s.buffer)
+	for i := 0; i < size; i++ {
+		at := (pos + i) % size
+		if a := s.attrs[at]; a >= 0 && a&faProtected == 0 {
+			return (at + 1) % size
+		}
+	}
+	return -1
+}
+
+func (s *nativeScreen) hasInputField() bool {
+	return s.nextInputField(0) >= 0
+}
+
+func (s *nativeScreen) eraseUnprotected() {
+	for i := range s.buffer {
+		if s.attrs[i] < 0 && !s.protectedAt(i) {
+			s.buffer[i] = 0
+		}
+	}
+	for i, a := range s.attrs {
+		if a >= 0 && a&faProtected == 0 {
+			s.attrs[i] = a &^ faModified
+		}
+	}
+	if next := s.nextInputField(0); next >= 0 {
+		s.cursor = next
+	} else {
+		s.cursor = 0
+	}
+}
+
+func (s *nativeScreen) setModified(pos int) {
+	if start := s.fieldStart(pos); start >= 0 {
+		s.attrs[start] |= faModified
+	}
+}
+
+// typeString types text at the cursor, skipping to the next input field
+// when one fills up.
+func (s *nativeScreen) typeString(text string) error {
+	if s.locked {
+		return errKeyboardLocked
+	}
+	size := len(s.buffer)
+	for _, r := range text {
+		if s.protectedAt(s.cursor) {
+			return fmt.Errorf("%w: the cursor at row %d, column %d is not in an input field", errKeyboardLocked, s.cursor/s.columns+1, s.cursor%s.columns+1)
+		}
+		b, ok := runeToEBCDIC(r)
+		if !ok {
+			return fmt.Errorf("%q cannot be typed in code page cp037", r)
+		}
+		s.buffer[s.cursor] = b
+		s.setModified(s.cursor)
+		s.cursor = (s.cursor + 1) % size
+		if s.attrs[s.cursor] >= 0 {
+			if next := s.nextInputField(s.cursor); next >= 0 {
+				s.cursor = next
+			}
+		}
+	}
+	return nil
+}
+
+func (s *nativeScreen) tab() {
+	if next := s.nextInputField(s.cursor); next >= 0 {
+		s.cursor = next
+	} else {
+		s.cursor = 0
+	}
+}
+
+func (s *nativeScreen) home() {
+	if next := s.nextInputField(0); next >= 0 {
+		s.cursor = next
+	} else {
+		s.cursor = 0
+	}
+}
+
+func (s *nativeScreen) eraseEOF() error {
+	if s.locked {
+		return errKeyboardLocked
+	}
+	if s.protectedAt(s.cursor) {
+		return errKeyboardLocked
+	}
+	// A formatted screen always ends the field at an attribute; an
+	// unformatted one is erased to the end of the buffer.
+	formatted := s.formatted()
+	for pos := s.cursor; s.attrs[pos] < 0; {
+		s.buffer[pos] = 0
+		if pos++; pos == len(s.buffer) {
+			if !formatted {
+				break
+			}
+			pos = 0
+		}
+	}
+	s.setModified(s.cursor)
+	return nil
+}
+
+// aidReply locks the keyboard and builds the inbound record for an
+// attention key.
+func (s *nativeScreen) aidReply(aid byte) ([]byte, error) {
+	if s.locked {
+		return nil, errKeyboardLocked
+	}
+	if aid == aidClear {
+		s.erase()
+	}
+	s.locked = true
+	s.aid = aid
+	return s.readModified(aid), nil
+}
+
+// readModified builds a Read Modified reply: the AID, the cursor address and
+// the data of every modified field. Clear and the PA keys send the AID only.
+func (s *nativeScreen) readModified(aid byte) []byte {
+	switch aid {
+	case aidClear, aidPA[1], aidPA[2], aidPA[3]:
+		return []byte{aid}
+	}
+	out := append([]byte{aid}, encodeAddress(s.cursor)...)
+	if !s.formatted() {
+		for _, b := range s.buffer {
+			if b != 0 {
+				out = append(out, b)
+			}
+		}
+		return out
+	}
+	size := len(s.buffer)
+	for start, a := range s.attrs {
+		if a < 0 || a&faModified == 0 {
+			continue
+		}
+		out = append(out, orderSBA)
+		out = append(out, encodeAddress((start+1)%size)...)
+		for pos := (start + 1) % size; s.attrs[pos] < 0; pos = (pos + 1) % size {
+			if b := s.buffer[pos]; b != 0 {
+				out = append(out, b)
+			}
+		}
+	}
+	return out
+}
+
+// readBuffer builds a Read Buffer reply with every cell of the screen.
+func (s *nativeScreen) readBuffer() []byte {
+	out := append([]byte{s.aid}, encodeAddress(s.cursor)...)
+	for i, b := range s.buffer {
+		if a := s.attrs[i]; a >= 0 {
+			out = append(out, orderSF, addressCodes[a&0x3f])
+		} else {
+			out = append(out, b)
+		}
+	}
+	return out
+}
+
+// queryReply answers a Read Partition Query with the usable area, the
+// implicit partition, colors and highlighting of a 3279 display.
+func queryReply(rows, columns int) []byte {
+	w0, w1 := byte(columns>>8), byte(columns)
+	h0, h1 := byte(rows>>8), byte(rows)
+	size := rows * columns
+	return append([]byte{aidQuery},
+		// Summary
+		0x00, 0x09, 0x81, 0x80, 0x80, 0x81, 0x86, 0x87, 0xa6,
+		// Usable Area
+		0x00, 0x17, 0x81, 0x81, 0x01, 0x00, w0, w1, h0, h1, 0x01,
+		0x00, 0x0a, 0x02, 0xe5, 0x00, 0x02, 0x00, 0x6f, 0x09, 0x0c, byte(size>>8), byte(size),
+		// Color
+		0x00, 0x16, 0x81, 0x86, 0x00, 0x08, 0x00, 0xf4, 0xf1, 0xf1, 0xf2, 0xf2,
+		0xf3, 0xf3, 0xf4, 0xf4, 0xf5, 0xf5, 0xf6, 0xf6, 0xf7, 0xf7,
+		// Highlighting
+		0x00, 0x0d, 0x81, 0x87, 0x04, 0x00, 0xf0, 0xf1, 0xf1, 0xf2, 0xf2, 0xf4, 0xf4,
+		// Implicit Partition
+		0x00, 0x11, 0x81, 0xa6, 0x00, 0x00, 0x0b, 0x01, 0x00, w0, w1, h0, h1, w0, w1, h0, h1,
+	)
+}
+
+// text returns length characters starting at pos the way Ascii() shows
+// them: attributes, nulls and hidden fields read as spaces.
+func (s *nativeScreen) text(pos, length int) string {
+	var b strings.Builder
+	size := len(s.buffer)
+	for i := 0; i < length && pos+i < size; i++ {
+		at := pos + i
+		if s.attrs[at] >= 0 || s.hiddenAt(at) {
+			b.WriteByte(' ')
+			continue
+		}
+		b.WriteRune(EBCDICToRune(s.buffer[at]))
+	}
+	return b.String()
+}
+
+// readBufferRow renders one row in the format of ReadBuffer(Ascii).
+func (s *nativeScreen) readBufferRow(row int) string {
+	tokens := make([]string, 0, s.columns)
+	for at := row * s.columns; at < (row+1)*s.columns; at++ {
+		if a := s.attrs[at]; a >= 0 {
+			token := fmt.Sprintf("SF(c0=%02x", a|0xc0) // printable form, as x3270 shows it
+			if h := s.highlight[at]; h != 0 {
+				token += fmt.Sprintf(",41=%02x", h)
+			}
+			if c := s.color[at]; c != 0 {
+				token += fmt.Sprintf(",42=%02x", c)
+			}
+			tokens = append(tokens, token+")")
+			continue
+		}
+		if s.buffer[at] == 0 {
+			tokens = append(tokens, "00")
+			continue
+		}
+		var cell [utf8.UTFMax]byte
+		n := utf8.EncodeRune(cell[:], EBCDICToRune(s.buffer[at]))
+		tokens = append(tokens, hex.EncodeToString(cell[:n]))
+	}
+	return strings.Join(tokens, " ")
+}
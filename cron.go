@@ -0,0 +1,184 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cronSchedule is a parsed cron expression: the five fields minute, hour,
+// day of month, month and day of week, each a bit set of the values it
+// matches.
+type cronSchedule struct {
+	minute, hour, dom, month, dow uint64
+	// domStar and dowStar note the fields starting with *, such as * or
+	// */2. When neither does, a day matches either of them, as in cron.
+	domStar, dowStar bool
+}
+
+type cronField struct {
+	name     string
+	min, max int
+	names    []string
+}
+
+var cronFields = [5]cronField{
+	{name: "minute", min: 0, max: 59},
+	{name: "hour", min: 0, max: 23},
+	{name: "day of month", min: 1, max: 31},
+	{name: "month", min: 1, max: 12, names: []string{"jan", "feb", "mar", "apr", "may", "jun", "jul", "aug", "sep", "oct", "nov", "dec"}},
+	{name: "day of week", min: 0, max: 7, names: []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}},
+}
+
+var cronDescriptors = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// parseCron reads a cron expression: five fields, or one of @yearly,
+// @monthly, @weekly, @daily and @hourly. Fields take *, numbers, names of
+// months and days, ranges, lists and steps, as in 0 2 * * MON-FRI or
+// */15 * * * *.
+func parseCron(spec string) (cronSchedule, error) {
+	spec = strings.TrimSpace(spec)
+	if expanded, ok := cronDescriptors[strings.ToLower(spec)]; ok {
+		spec = expanded
+	}
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return cronSchedule{}, fmt.Errorf("cron expression %q should have 5 fields (minute hour day-of-month month day-of-week), has %d", spec, len(fields))
+	}
+	var sets [5]uint64
+	for i, field := range fields {
+		set, err := parseCronField(field, cronFields[i])
+		if err != nil {
+			return cronSchedule{}, err
+		}
+		sets[i] = set
+	}
+	s := cronSchedule{minute: sets[0], hour: sets[1], dom: sets[2], month: sets[3], dow: sets[4], domStar: strings.HasPrefix(fields[2], "*"), dowStar: strings.HasPrefix(fields[4], "*")}
+	// Sunday is 0 or 7.
+	if s.dow&(1<<7) != 0 {
+		s.dow |= 1
+	}
+	return s, nil
+}
+
+func parseCronField(field string, f cronField) (uint64, error) {
+	var set uint64
+	for _, part := range strings.Split(field, ",") {
+		rangePart, stepPart, hasStep := strings.Cut(part, "/")
+		step := 1
+		if hasStep {
+			n, err := strconv.Atoi(stepPart)
+			if err != nil || n <= 0 {
+				return 0, fmt.Errorf("%s: invalid step %q", f.name, stepPart)
+			}
+			step = n
+		}
+		low, high := f.min, f.max
+		switch {
+		case rangePart == "*":
+		case strings.Contains(rangePart, "-"):
+			from, to, _ := strings.Cut(rangePart, "-")
+			var err error
+			if low, err = cronValue(from, f); err != nil {
+				return 0, err
+			}
+			if high, err = cronValue(to, f); err != nil {
+				return 0, err
+			}
+			if low > high {
+				return 0, fmt.Errorf("%s: range %q runs backwards", f.name, rangePart)
+			}
+		default:
+			n, err := cronValue(rangePart, f)
+			if err != nil {
+				return 0, err
+			}
+			low = n
+			// 5/10 starts at 5 and steps to the end of the field.
+			if !hasStep {
+				high = n
+			}
+		}
+		for n := low; n <= high; n += step {
+			set |= 1 << n
+		}
+	}
+	return set, nil
+}
+
+func cronValue(value string, f cronField) (int, error) {
+	for i, name := range f.names {
+		if strings.EqualFold(value, name) {
+			return i + f.min, nil
+		}
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < f.min || n > f.max {
+		return 0, fmt.Errorf("%s: %q is not between %d and %d", f.name, value, f.min, f.max)
+	}
+	return n, nil
+}
+
+// matchesDay reports whether the day of t is in the schedule.
+func (s cronSchedule) matchesDay(t time.Time) bool {
+	dom := s.dom&(1<<t.Day()) != 0
+	dow := s.dow&(1<<t.Weekday()) != 0
+	if s.domStar || s.dowStar {
+		return dom && dow
+	}
+	return dom || dow
+}
+
+// errCronNever is the error of a schedule that matches no date, such as
+// 0 0 30 2 *.
+var errCronNever = errors.New("the cron expression matches no date")
+
+// next gives the first minute after t the schedule matches, in the
+// location of t.
+func (s cronSchedule) next(t time.Time) (time.Time, error) {
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	// Any schedule that matches at all does so within 8 years, for
+	// 29 February on a Monday.
+	limit := t.AddDate(8, 0, 0)
+	for t.Before(limit) {
+		switch {
+		case s.month&(1<<t.Month()) == 0:
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+		case !s.matchesDay(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+		case s.hour&(1<<t.Hour()) == 0:
+			// Added rather than set, so hours repeated or skipped by
+			// daylight saving time are handled.
+			t = t.Add(time.Duration(60-t.Minute()) * time.Minute)
+		case s.minute&(1<<t.Minute()) == 0:
+			t = t.Add(time.Minute)
+		default:
+			return t, nil
+		}
+	}
+	return time.Time{}, errCronNever
+}
+
+// upcoming gives the next n times after t the schedule matches.
+func (s cronSchedule) upcoming(t time.Time, n int) ([]time.Time, error) {
+	times := make([]time.Time, 0, n)
+	for len(times) < n {
+		next, err := s.next(t)
+		if err != nil {
+			return nil, err
+		}
+		times = append(times, next)
+		t = next
+	}
+	return times, nil
+}
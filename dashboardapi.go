@@ -0,0 +1,281 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// dashboardAPIBase is where the dashboard serves its data as JSON, for
+// tools and other front ends. Like the API server's /api/v1, version 1
+// only changes in ways clients can ignore.
+const dashboardAPIBase = "/dashboard/api/v1"
+
+// dashboardAPIError is an answer of the dashboard API other than 200.
+type dashboardAPIError struct {
+	status  int
+	message string
+}
+
+func (e *dashboardAPIError) Error() string { return e.message }
+
+// dashboardAPIRoute is a read-only route of the dashboard API. Segments of
+// its path in braces, such as {pid}, match any segment and are passed to
+// serve in order.
+type dashboardAPIRoute struct {
+	Path        string `json:"path"`
+	Description string `json:"description"`
+	serve       func(r *http.Request, args []string) (any, error)
+}
+
+var dashboardAPIRoutes []dashboardAPIRoute
+
+func init() {
+	// Set here, as the index route lists the routes.
+	dashboardAPIRoutes = []dashboardAPIRoute{
+		{"", "The routes of the dashboard API", dashboardAPIIndex},
+		{"metrics", "The totals of the runs with every run's metrics; running=true keeps to the runs still running", dashboardAPIMetrics},
+		{"runs", "Every run the dashboard knows, in the order they started", dashboardAPIRuns},
+		{"runs/{pid}", "The metrics of a run, with its latest failures", dashboardAPIRun},
+		{"runs/{pid}/logs", "The log of a run, newest first", dashboardAPIRunLogs},
+		{"runs/{pid}/summary", "The performance summary a run wrote when it ended", dashboardAPIRunSummary},
+		{"runs/{pid}/failures", "The failed workflows of a run by category", dashboardAPIRunFailures},
+		{"runs/{pid}/workflow", "The workflow file of a run", dashboardAPIRunWorkflow},
+		{"logs", "The logs of every run, newest first", dashboardAPILogs},
+		{"history", "The runs in the run history, newest first; archived=1 lists the archived ones, pid= the runs of a process", dashboardAPIHistory},
+		{"history/{id}", "A run in the run history with its timings, errors, summary and charts", dashboardAPIHistoryRun},
+		{"profiles", "The saved launch profiles, without their files", dashboardAPIProfiles},
+		{"schedules", "The scheduled runs with their next start and latest results", dashboardAPISchedules},
+	}
+}
+
+// match reports whether path, split in segments, is the route's, and gives
+// the segments standing for its parameters.
+func (route dashboardAPIRoute) match(segments []string) ([]string, bool) {
+	pattern := strings.Split(route.Path, "/")
+	if len(pattern) != len(segments) {
+		return nil, false
+	}
+	var args []string
+	for i, part := range pattern {
+		switch {
+		case strings.HasPrefix(part, "{"):
+			args = append(args, segments[i])
+		case part != segments[i]:
+			return nil, false
+		}
+	}
+	return args, true
+}
+
+// dashboardAPIHandler serves the dashboard API under dashboardAPIBase.
+// Errors are answered as {"error": "..."}.
+func dashboardAPIHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		writeDashboardAPIError(w, &dashboardAPIError{http.StatusMethodNotAllowed, "The dashboard API is read-only"})
+		return
+	}
+	segments := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, dashboardAPIBase), "/"), "/")
+	for _, route := range dashboardAPIRoutes {
+		if args, ok := route.match(segments); ok {
+			v, err := route.serve(r, args)
+			if err != nil {
+				writeDashboardAPIError(w, err)
+				return
+			}
+			writeLiveJSON(w, v)
+			return
+		}
+	}
+	writeDashboardAPIError(w, &dashboardAPIError{http.StatusNotFound, "No such route - see " + dashboardAPIBase})
+}
+
+func writeDashboardAPIError(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	var apiErr *dashboardAPIError
+	switch {
+	case errors.As(err, &apiErr):
+		status = apiErr.status
+	case errors.Is(err, os.ErrNotExist), errors.Is(err, errNoHistory), errors.Is(err, errNoRun):
+		status = http.StatusNotFound
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+}
+
+func dashboardAPIIndex(r *http.Request, _ []string) (any, error) {
+	routes := make([]dashboardAPIRoute, len(dashboardAPIRoutes))
+	for i, route := range dashboardAPIRoutes {
+		route.Path = strings.TrimSuffix(dashboardAPIBase+"/"+route.Path, "/")
+		routes[i] = route
+	}
+	return map[string]any{"version": version, "routes": routes}, nil
+}
+
+func dashboardAPIMetrics(r *http.Request, _ []string) (any, error) {
+	_, runs := dashboardRuns.list()
+	if r.URL.Query().Get("running") == "true" {
+		running := make([]ExtendedMetrics, 0, len(runs))
+		for _, m := range runs {
+			if m.IsRunning {
+				running = append(running, m)
+			}
+		}
+		runs = running
+	}
+	if runs == nil {
+		runs = []ExtendedMetrics{}
+	}
+	return struct {
+		Aggregated Metrics           `json:"aggregated"`
+		Runs       []ExtendedMetrics `json:"runs"`
+		Timestamp  int64             `json:"timestamp"`
+	}{aggregateExtendedMetrics(runs), runs, time.Now().Unix()}, nil
+}
+
+func dashboardAPIRuns(r *http.Request, _ []string) (any, error) {
+	_, runs := dashboardRuns.list()
+	if runs == nil {
+		runs = []ExtendedMetrics{}
+	}
+	return runs, nil
+}
+
+// dashboardAPIRunByPID reads the {pid} of a route, which must be a run the
+// dashboard knows.
+func dashboardAPIRunByPID(value string) (ExtendedMetrics, error) {
+	pid, err := strconv.Atoi(value)
+	if err != nil || pid <= 0 {
+		return ExtendedMetrics{}, &dashboardAPIError{http.StatusBadRequest, fmt.Sprintf("Invalid pid %q", value)}
+	}
+	m, ok := dashboardRuns.get(pid)
+	if !ok {
+		return ExtendedMetrics{}, &dashboardAPIError{http.StatusNotFound, fmt.Sprintf("No run reported with PID %d", pid)}
+	}
+	return m, nil
+}
+
+func dashboardAPIRun(r *http.Request, args []string) (any, error) {
+	return dashboardAPIRunByPID(args[0])
+}
+
+func dashboardAPIRunLogs(r *http.Request, args []string) (any, error) {
+	m, err := dashboardAPIRunByPID(args[0])
+	if err != nil {
+		return nil, err
+	}
+	return loadLogEntries(strconv.Itoa(m.PID))
+}
+
+func dashboardAPIRunSummary(r *http.Request, args []string) (any, error) {
+	m, err := dashboardAPIRunByPID(args[0])
+	if err != nil {
+		return nil, err
+	}
+	summary, err := os.ReadFile(filepath.Join("logs", fmt.Sprintf("summary_%d.txt", m.PID)))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, &dashboardAPIError{http.StatusNotFound, fmt.Sprintf("PID %d has written no summary", m.PID)}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return map[string]any{"pid": m.PID, "summary": string(summary)}, nil
+}
+
+func dashboardAPIRunFailures(r *http.Request, args []string) (any, error) {
+	m, err := dashboardAPIRunByPID(args[0])
+	if err != nil {
+		return nil, err
+	}
+	return groupFailures(m.Metrics), nil
+}
+
+func dashboardAPIRunWorkflow(r *http.Request, args []string) (any, error) {
+	m, err := dashboardAPIRunByPID(args[0])
+	if err != nil {
+		return nil, err
+	}
+	if m.ConfigFilePath == "" {
+		return nil, &dashboardAPIError{http.StatusNotFound, fmt.Sprintf("Workflow configuration is not available for PID %d", m.PID)}
+	}
+	data, err := os.ReadFile(m.ConfigFilePath)
+	if err != nil {
+		return nil, err
+	}
+	if !json.Valid(data) {
+		return nil, &dashboardAPIError{http.StatusUnprocessableEntity, "The workflow file of PID " + strconv.Itoa(m.PID) + " is not JSON"}
+	}
+	return json.RawMessage(data), nil
+}
+
+func dashboardAPILogs(r *http.Request, _ []string) (any, error) {
+	entries, err := loadLogEntries("")
+	if entries == nil && err == nil {
+		entries = []LogEntry{}
+	}
+	return entries, err
+}
+
+func dashboardAPIHistory(r *http.Request, _ []string) (any, error) {
+	f := historyFilter{archived: -1, limit: dashboardHistoryLimit}
+	if r.URL.Query().Get("archived") == "1" {
+		f.archived = 1
+	}
+	if pid := r.URL.Query().Get("pid"); pid != "" {
+		n, err := strconv.Atoi(pid)
+		if err != nil || n <= 0 {
+			return nil, &dashboardAPIError{http.StatusBadRequest, "Invalid pid"}
+		}
+		f.pid, f.archived = n, 0
+	}
+	var runs []runRecord
+	err := readDashboardHistory(func(db *sql.DB) (err error) {
+		runs, err = loadRuns(db, f)
+		return err
+	})
+	if runs == nil {
+		runs = []runRecord{}
+	}
+	return runs, err
+}
+
+func dashboardAPIHistoryRun(r *http.Request, args []string) (any, error) {
+	id, err := strconv.ParseInt(args[0], 10, 64)
+	if err != nil || id <= 0 {
+		return nil, &dashboardAPIError{http.StatusBadRequest, "Invalid run id"}
+	}
+	var run *runRecord
+	err = readDashboardHistory(func(db *sql.DB) (err error) {
+		run, err = loadRun(db, id)
+		return err
+	})
+	return run, err
+}
+
+func dashboardAPIProfiles(r *http.Request, _ []string) (any, error) {
+	profiles, err := dashboardProfiles.list()
+	if err != nil {
+		return nil, err
+	}
+	for i := range profiles {
+		profiles[i].Config, profiles[i].Injection = "", ""
+	}
+	if profiles == nil {
+		profiles = []launchProfile{}
+	}
+	return profiles, nil
+}
+
+func dashboardAPISchedules(r *http.Request, _ []string) (any, error) {
+	return dashboardSchedules.list(time.Now()), nil
+}
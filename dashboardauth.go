@@ -0,0 +1,748 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	crand "crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"html"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/term"
+)
+
+var (
+	dashboardUsersSpec        string
+	dashboardOIDCIssuer       string
+	dashboardOIDCClientID     string
+	dashboardOIDCClientSecret string
+	dashboardOIDCRedirectURL  string
+	dashboardOIDCOperators    string
+	dashboardOIDCGroupsClaim  string
+)
+
+func init() {
+	flag.StringVar(&dashboardUsersSpec, "dashboardUsers", "", "Users who may sign in to the dashboard with basic auth, as name:bcrypt-hash or name:bcrypt-hash:role (operator or viewer, the default), one per line or comma-separated; accepts {{env:NAME}} and {{file:path}}. See 3270Connect hash-password")
+	flag.StringVar(&dashboardOIDCIssuer, "dashboardOIDCIssuer", "", "Sign in to the dashboard with this OpenID Connect issuer")
+	flag.StringVar(&dashboardOIDCClientID, "dashboardOIDCClientID", "", "Client ID of the dashboard at the OpenID Connect issuer")
+	flag.StringVar(&dashboardOIDCClientSecret, "dashboardOIDCClientSecret", "", "Client secret of the dashboard at the OpenID Connect issuer; accepts {{env:NAME}} and {{file:path}}")
+	flag.StringVar(&dashboardOIDCRedirectURL, "dashboardOIDCRedirectURL", "", "URL the issuer sends users back to, ending in "+dashboardCallbackPath+" (default: the address the browser used)")
+	flag.StringVar(&dashboardOIDCOperators, "dashboardOIDCOperators", "", "OpenID Connect users who may start and kill runs, by email, subject or group, comma-separated; the others may only watch (default: every user)")
+	flag.StringVar(&dashboardOIDCGroupsClaim, "dashboardOIDCGroupsClaim", "groups", "ID token claim that lists the groups of a user, for -dashboardOIDCOperators")
+}
+
+const (
+	// roleOperator may do anything in the dashboard; roleViewer may only
+	// watch: it cannot start or kill runs, nor change the run history.
+	roleOperator = "operator"
+	roleViewer   = "viewer"
+
+	dashboardLoginPath    = "/dashboard/auth/login"
+	dashboardCallbackPath = "/dashboard/auth/callback"
+	dashboardLogoutPath   = "/dashboard/auth/logout"
+
+	dashboardSessionCookie   = "3270connect_session"
+	dashboardSignInCookie    = "3270connect_signin"
+	dashboardSessionLifetime = 12 * time.Hour
+	dashboardSignInLifetime  = 10 * time.Minute
+)
+
+// dashboardUser is who signed in to the dashboard.
+type dashboardUser struct {
+	Name string `json:"n"`
+	Role string `json:"r"`
+}
+
+func (u dashboardUser) canOperate() bool {
+	return u.Role == roleOperator
+}
+
+// dashboardAccount is a -dashboardUsers entry.
+type dashboardAccount struct {
+	hash []byte
+	role string
+}
+
+// dashboardAuthenticator checks who may use the dashboard, and what they
+// may do there.
+type dashboardAuthenticator struct {
+	accounts map[string]dashboardAccount
+	oidc     *oidcProvider
+	// sessionKey signs the session cookies. It is made anew when the
+	// dashboard starts, which signs everyone out.
+	sessionKey []byte
+	// verified remembers the basic auth credentials that checked out, by
+	// their SHA-256, so that bcrypt runs once per user and password rather
+	// than on every request of the page.
+	mu       sync.Mutex
+	verified map[[sha256.Size]byte]dashboardUser
+}
+
+// dashboardAuth is nil while the dashboard is open to every caller.
+var dashboardAuth *dashboardAuthenticator
+
+type dashboardUserKey struct{}
+
+// setupDashboardAuth checks the -dashboardUsers and -dashboardOIDC flags.
+// Without users or an issuer the dashboard stays open, as before.
+func setupDashboardAuth() error {
+	spec, err := resolveSecretPlaceholders(dashboardUsersSpec)
+	if err != nil {
+		return fmt.Errorf("-dashboardUsers: %w", err)
+	}
+	accounts, err := parseDashboardUsers(spec)
+	if err != nil {
+		return fmt.Errorf("-dashboardUsers: %w", err)
+	}
+	a := &dashboardAuthenticator{accounts: accounts, verified: make(map[[sha256.Size]byte]dashboardUser)}
+	if dashboardOIDCIssuer != "" {
+		if dashboardOIDCClientID == "" {
+			return errors.New("-dashboardOIDCIssuer needs -dashboardOIDCClientID")
+		}
+		secret, err := resolveSecretPlaceholders(dashboardOIDCClientSecret)
+		if err != nil {
+			return fmt.Errorf("-dashboardOIDCClientSecret: %w", err)
+		}
+		if dashboardOIDCRedirectURL != "" {
+			if u, err := url.Parse(dashboardOIDCRedirectURL); err != nil || !u.IsAbs() || u.Path != dashboardPath(dashboardCallbackPath) {
+				return fmt.Errorf("-dashboardOIDCRedirectURL must be an absolute URL ending in %s", dashboardPath(dashboardCallbackPath))
+			}
+		}
+		a.oidc = &oidcProvider{
+			issuer:       strings.TrimSuffix(dashboardOIDCIssuer, "/"),
+			clientID:     dashboardOIDCClientID,
+			clientSecret: secret,
+			redirectURL:  dashboardOIDCRedirectURL,
+			operators:    splitList(dashboardOIDCOperators),
+			groupsClaim:  dashboardOIDCGroupsClaim,
+			client:       &http.Client{Timeout: 10 * time.Second},
+		}
+	} else if dashboardOIDCClientID != "" || dashboardOIDCClientSecret != "" || dashboardOIDCRedirectURL != "" || dashboardOIDCOperators != "" {
+		return errors.New("-dashboardOIDCClientID, -dashboardOIDCClientSecret, -dashboardOIDCRedirectURL and -dashboardOIDCOperators need -dashboardOIDCIssuer")
+	}
+	if len(a.accounts) == 0 && a.oidc == nil {
+		return nil
+	}
+	a.sessionKey = make([]byte, 32)
+	if _, err := crand.Read(a.sessionKey); err != nil {
+		return err
+	}
+	dashboardAuth = a
+	return nil
+}
+
+// parseDashboardUsers reads the -dashboardUsers entries.
+func parseDashboardUsers(spec string) (map[string]dashboardAccount, error) {
+	accounts := make(map[string]dashboardAccount)
+	entries := strings.FieldsFunc(spec, func(r rune) bool { return r == '\n' || r == ',' })
+	for i, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if entry == "" || strings.HasPrefix(entry, "#") {
+			continue
+		}
+		parts := strings.Split(entry, ":")
+		if len(parts) < 2 || len(parts) > 3 {
+			return nil, fmt.Errorf("entry %d should be name:bcrypt-hash or name:bcrypt-hash:role", i+1)
+		}
+		name, hash := strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1])
+		if name == "" {
+			return nil, fmt.Errorf("entry %d has an empty name", i+1)
+		}
+		if _, err := bcrypt.Cost([]byte(hash)); err != nil {
+			return nil, fmt.Errorf("user %s: the password must be a bcrypt hash, as 3270Connect hash-password prints: %v", name, err)
+		}
+		account := dashboardAccount{hash: []byte(hash), role: roleViewer}
+		if len(parts) == 3 {
+			switch role := strings.TrimSpace(parts[2]); role {
+			case roleOperator, roleViewer:
+				account.role = role
+			default:
+				return nil, fmt.Errorf("user %s: role %q should be %s or %s", name, role, roleOperator, roleViewer)
+			}
+		}
+		if _, ok := accounts[name]; ok {
+			return nil, fmt.Errorf("user %s is given twice", name)
+		}
+		accounts[name] = account
+	}
+	return accounts, nil
+}
+
+func splitList(list string) []string {
+	var items []string
+	for _, item := range strings.Split(list, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// wrap lets through to next the requests of signed-in users, and of them
+// only those of operators when they would change anything. Probes and the
+// reports of the runs on this machine need no sign-in.
+func (a *dashboardAuthenticator) wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/healthz", "/readyz", "/dashboard/report":
+			next.ServeHTTP(w, r)
+			return
+		case dashboardLoginPath, dashboardCallbackPath:
+			if a.oidc == nil {
+				http.NotFound(w, r)
+			} else if r.URL.Path == dashboardLoginPath {
+				a.oidc.login(w, r, a)
+			} else {
+				a.oidc.callback(w, r, a)
+			}
+			return
+		case dashboardLogoutPath:
+			a.logout(w, r)
+			return
+		}
+		user, err := a.authenticate(r, time.Now())
+		if err != nil {
+			a.challenge(w, r, err)
+			return
+		}
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			if !user.canOperate() {
+				dashboardLog.Warn(fmt.Sprintf("Refused %s %s to %s, a %s", r.Method, r.URL.Path, user.Name, user.Role))
+				http.Error(w, "Viewers may not start or kill runs, nor change the run history, profiles or schedules", http.StatusForbidden)
+				return
+			}
+			// Browsers send credentials along with requests other sites
+			// make, so changes must come from the dashboard's own pages.
+			// Behind a reverse proxy, they come from the proxy's host.
+			// Other sites cannot set X-Forwarded-Host on a request.
+			if origin := r.Header.Get("Origin"); origin != "" && !sameHost(origin, r.Host) && !sameHost(origin, r.Header.Get("X-Forwarded-Host")) {
+				dashboardLog.Warn(fmt.Sprintf("Refused %s %s from a page of %s", r.Method, r.URL.Path, origin))
+				http.Error(w, "Cross-origin request refused", http.StatusForbidden)
+				return
+			}
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), dashboardUserKey{}, user)))
+	})
+}
+
+func sameHost(origin, host string) bool {
+	u, err := url.Parse(origin)
+	return err == nil && host != "" && strings.EqualFold(u.Host, host)
+}
+
+// signedInUser is who made r; false while the dashboard has no sign-in.
+func signedInUser(r *http.Request) (dashboardUser, bool) {
+	user, ok := r.Context().Value(dashboardUserKey{}).(dashboardUser)
+	return user, ok
+}
+
+var errSignInNeeded = errors.New("sign in to use the dashboard")
+
+// authenticate finds who sent r, from its basic auth credentials or its
+// session cookie.
+func (a *dashboardAuthenticator) authenticate(r *http.Request, now time.Time) (dashboardUser, error) {
+	if name, password, ok := r.BasicAuth(); ok && len(a.accounts) > 0 {
+		return a.checkPassword(name, password)
+	}
+	if cookie, err := r.Cookie(dashboardSessionCookie); err == nil {
+		var session struct {
+			dashboardUser
+			Expires int64 `json:"e"`
+		}
+		if err := a.openCookie(cookie.Value, &session); err != nil {
+			return dashboardUser{}, err
+		}
+		if now.Unix() > session.Expires {
+			return dashboardUser{}, errors.New("the session has expired")
+		}
+		return session.dashboardUser, nil
+	}
+	return dashboardUser{}, errSignInNeeded
+}
+
+func (a *dashboardAuthenticator) checkPassword(name, password string) (dashboardUser, error) {
+	digest := sha256.Sum256([]byte(name + "\x00" + password))
+	a.mu.Lock()
+	user, ok := a.verified[digest]
+	a.mu.Unlock()
+	if ok {
+		return user, nil
+	}
+	account, ok := a.accounts[name]
+	if !ok || bcrypt.CompareHashAndPassword(account.hash, []byte(password)) != nil {
+		return dashboardUser{}, errors.New("unknown user or wrong password")
+	}
+	user = dashboardUser{Name: name, Role: account.role}
+	a.mu.Lock()
+	a.verified[digest] = user
+	a.mu.Unlock()
+	return user, nil
+}
+
+// challenge answers a request without a signed-in user: pages are sent to
+// sign in with the issuer, the rest are refused with 401.
+func (a *dashboardAuthenticator) challenge(w http.ResponseWriter, r *http.Request, err error) {
+	if a.oidc != nil && r.Method == http.MethodGet && strings.Contains(r.Header.Get("Accept"), "text/html") {
+		http.Redirect(w, r, dashboardPath(dashboardLoginPath)+"?next="+url.QueryEscape(dashboardPath(r.URL.RequestURI())), http.StatusFound)
+		return
+	}
+	if len(a.accounts) > 0 {
+		w.Header().Set("WWW-Authenticate", `Basic realm="3270Connect Dashboard", charset="UTF-8"`)
+	}
+	if !errors.Is(err, errSignInNeeded) {
+		dashboardLog.Warn(fmt.Sprintf("Refused %s %s from %s: %v", r.Method, r.URL.Path, r.RemoteAddr, err))
+	}
+	http.Error(w, "Authentication required: "+err.Error(), http.StatusUnauthorized)
+}
+
+// startSession signs user in with a session cookie.
+func (a *dashboardAuthenticator) startSession(w http.ResponseWriter, r *http.Request, user dashboardUser) {
+	session := struct {
+		dashboardUser
+		Expires int64 `json:"e"`
+	}{user, time.Now().Add(dashboardSessionLifetime).Unix()}
+	a.setCookie(w, r, dashboardSessionCookie, a.sealCookie(session), dashboardSessionLifetime)
+}
+
+func (a *dashboardAuthenticator) logout(w http.ResponseWriter, r *http.Request) {
+	a.setCookie(w, r, dashboardSessionCookie, "", -1)
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	fmt.Fprintf(w, `<!DOCTYPE html><title>3270Connect Dashboard</title><p>You are signed out of the dashboard. <a href="%s">Sign in again</a></p>`, dashboardPath("/dashboard"))
+}
+
+func (a *dashboardAuthenticator) setCookie(w http.ResponseWriter, r *http.Request, name, value string, lifetime time.Duration) {
+	cookie := &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     dashboardPath("/"),
+		HttpOnly: true,
+		Secure:   isHTTPS(r),
+		SameSite: http.SameSiteLaxMode,
+	}
+	if lifetime < 0 {
+		cookie.MaxAge = -1
+	} else {
+		cookie.MaxAge = int(lifetime.Seconds())
+	}
+	http.SetCookie(w, cookie)
+}
+
+// sealCookie signs v, as JSON, for a cookie.
+func (a *dashboardAuthenticator) sealCookie(v any) string {
+	data, _ := json.Marshal(v)
+	payload := base64.RawURLEncoding.EncodeToString(data)
+	mac := hmac.New(sha256.New, a.sessionKey)
+	mac.Write([]byte(payload))
+	return payload + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// openCookie checks the signature of a cookie sealCookie made and reads
+// it into v.
+func (a *dashboardAuthenticator) openCookie(value string, v any) error {
+	payload, sig, ok := strings.Cut(value, ".")
+	if !ok {
+		return errors.New("malformed session cookie")
+	}
+	mac := hmac.New(sha256.New, a.sessionKey)
+	mac.Write([]byte(payload))
+	got, err := base64.RawURLEncoding.DecodeString(sig)
+	if err != nil || !hmac.Equal(got, mac.Sum(nil)) {
+		return errors.New("the session is not valid, or the dashboard has restarted")
+	}
+	return decodeJWTPart(payload, v)
+}
+
+// oidcProvider signs users in with an OpenID Connect issuer, with the
+// authorization code flow and PKCE.
+type oidcProvider struct {
+	issuer       string
+	clientID     string
+	clientSecret string
+	redirectURL  string
+	operators    []string
+	groupsClaim  string
+	client       *http.Client
+
+	mu     sync.Mutex
+	config *oidcConfig
+	keys   map[string]crypto.PublicKey
+}
+
+// oidcConfig is the part of the issuer's discovery document the dashboard
+// uses.
+type oidcConfig struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// oidcSignIn is what the sign-in cookie keeps while the user is away at
+// the issuer.
+type oidcSignIn struct {
+	State    string `json:"s"`
+	Nonce    string `json:"n"`
+	Verifier string `json:"v"`
+	Next     string `json:"x"`
+	Expires  int64  `json:"e"`
+}
+
+// discover reads the issuer's discovery document, once it has been read
+// right.
+func (p *oidcProvider) discover() (*oidcConfig, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.config != nil {
+		return p.config, nil
+	}
+	var config oidcConfig
+	if err := p.getJSON(p.issuer+"/.well-known/openid-configuration", &config); err != nil {
+		return nil, err
+	}
+	if strings.TrimSuffix(config.Issuer, "/") != p.issuer {
+		return nil, fmt.Errorf("the discovery document is of issuer %q, not %q", config.Issuer, p.issuer)
+	}
+	if config.AuthorizationEndpoint == "" || config.TokenEndpoint == "" || config.JWKSURI == "" {
+		return nil, errors.New("the discovery document lacks an authorization, token or JWKS endpoint")
+	}
+	p.config = &config
+	return p.config, nil
+}
+
+func (p *oidcProvider) getJSON(target string, v any) error {
+	resp, err := p.client.Get(target)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s answered %s", target, resp.Status)
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(v)
+}
+
+func (p *oidcProvider) redirectURI(r *http.Request) string {
+	if p.redirectURL != "" {
+		return p.redirectURL
+	}
+	scheme := "http"
+	if isHTTPS(r) {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host + dashboardPath(dashboardCallbackPath)
+}
+
+// login sends the user to sign in at the issuer.
+func (p *oidcProvider) login(w http.ResponseWriter, r *http.Request, a *dashboardAuthenticator) {
+	config, err := p.discover()
+	if err != nil {
+		dashboardLog.Error(fmt.Sprintf("OpenID Connect issuer %s: %v", p.issuer, err))
+		http.Error(w, "The sign-in service cannot be reached: "+err.Error(), http.StatusBadGateway)
+		return
+	}
+	next := r.URL.Query().Get("next")
+	// Only paths of the dashboard, lest the sign-in redirect elsewhere.
+	if !strings.HasPrefix(next, "/") || strings.HasPrefix(next, "//") || strings.HasPrefix(next, "/\\") {
+		next = dashboardPath("/dashboard")
+	}
+	signIn := oidcSignIn{State: randomToken(), Nonce: randomToken(), Verifier: randomToken(), Next: next,
+		Expires: time.Now().Add(dashboardSignInLifetime).Unix()}
+	a.setCookie(w, r, dashboardSignInCookie, a.sealCookie(signIn), dashboardSignInLifetime)
+	challenge := sha256.Sum256([]byte(signIn.Verifier))
+	query := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {p.clientID},
+		"redirect_uri":          {p.redirectURI(r)},
+		"scope":                 {"openid email profile"},
+		"state":                 {signIn.State},
+		"nonce":                 {signIn.Nonce},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(challenge[:])},
+		"code_challenge_method": {"S256"},
+	}
+	separator := "?"
+	if strings.Contains(config.AuthorizationEndpoint, "?") {
+		separator = "&"
+	}
+	http.Redirect(w, r, config.AuthorizationEndpoint+separator+query.Encode(), http.StatusFound)
+}
+
+// callback takes the user back from the issuer, and signs them in with
+// the ID token the code is exchanged for.
+func (p *oidcProvider) callback(w http.ResponseWriter, r *http.Request, a *dashboardAuthenticator) {
+	fail := func(status int, err error) {
+		dashboardLog.Warn(fmt.Sprintf("OpenID Connect sign-in from %s failed: %v", r.RemoteAddr, err))
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.WriteHeader(status)
+		fmt.Fprintf(w, `<!DOCTYPE html><title>3270Connect Dashboard</title><p>Sign-in failed: %s. <a href="%s">Try again</a></p>`,
+			html.EscapeString(err.Error()), dashboardPath(dashboardLoginPath))
+	}
+	var signIn oidcSignIn
+	cookie, err := r.Cookie(dashboardSignInCookie)
+	if err != nil {
+		fail(http.StatusBadRequest, errors.New("the sign-in was not started here, or took too long"))
+		return
+	}
+	a.setCookie(w, r, dashboardSignInCookie, "", -1)
+	if err := a.openCookie(cookie.Value, &signIn); err != nil || time.Now().Unix() > signIn.Expires {
+		fail(http.StatusBadRequest, errors.New("the sign-in was not started here, or took too long"))
+		return
+	}
+	query := r.URL.Query()
+	if subtle.ConstantTimeCompare([]byte(query.Get("state")), []byte(signIn.State)) != 1 {
+		fail(http.StatusBadRequest, errors.New("the state does not match the sign-in"))
+		return
+	}
+	if e := query.Get("error"); e != "" {
+		fail(http.StatusUnauthorized, fmt.Errorf("the issuer answered %s %s", e, query.Get("error_description")))
+		return
+	}
+	user, err := p.exchange(r, query.Get("code"), signIn)
+	if err != nil {
+		fail(http.StatusUnauthorized, err)
+		return
+	}
+	dashboardLog.Info(fmt.Sprintf("%s signed in to the dashboard with the %s role", user.Name, user.Role))
+	a.startSession(w, r, user)
+	http.Redirect(w, r, signIn.Next, http.StatusFound)
+}
+
+// exchange trades the authorization code for an ID token at the issuer,
+// and finds the user it names.
+func (p *oidcProvider) exchange(r *http.Request, code string, signIn oidcSignIn) (dashboardUser, error) {
+	config, err := p.discover()
+	if err != nil {
+		return dashboardUser{}, err
+	}
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {p.redirectURI(r)},
+		"code_verifier": {signIn.Verifier},
+	}
+	req, err := http.NewRequest(http.MethodPost, config.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return dashboardUser{}, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(url.QueryEscape(p.clientID), url.QueryEscape(p.clientSecret))
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return dashboardUser{}, err
+	}
+	defer resp.Body.Close()
+	var token struct {
+		IDToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&token); err != nil && resp.StatusCode == http.StatusOK {
+		return dashboardUser{}, fmt.Errorf("reading the token response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK || token.IDToken == "" {
+		return dashboardUser{}, fmt.Errorf("the token endpoint answered %s %s %s", resp.Status, token.Error, token.ErrorDescription)
+	}
+	return p.verifyIDToken(token.IDToken, signIn.Nonce, time.Now())
+}
+
+// verifyIDToken checks an ID token was signed by the issuer for the
+// dashboard and this sign-in, and finds the user and the role it gives.
+func (p *oidcProvider) verifyIDToken(token, nonce string, now time.Time) (dashboardUser, error) {
+	config, err := p.discover()
+	if err != nil {
+		return dashboardUser{}, err
+	}
+	header, _, _ := strings.Cut(token, ".")
+	var h struct {
+		Kid string `json:"kid"`
+	}
+	if err := decodeJWTPart(header, &h); err != nil {
+		return dashboardUser{}, fmt.Errorf("ID token header: %w", err)
+	}
+	key, err := p.signingKey(config, h.Kid)
+	if err != nil {
+		return dashboardUser{}, err
+	}
+	// The API's JWT checks serve here too: signature, expiry, issuer and
+	// audience.
+	verifier := &apiAuthenticator{jwtKey: key, issuer: config.Issuer, audience: p.clientID}
+	if _, err := verifier.verifyJWT(token, now); err != nil {
+		return dashboardUser{}, fmt.Errorf("ID token: %w", err)
+	}
+	var claims map[string]any
+	parts := strings.Split(token, ".")
+	if err := decodeJWTPart(parts[1], &claims); err != nil {
+		return dashboardUser{}, fmt.Errorf("ID token claims: %w", err)
+	}
+	if got, _ := claims["nonce"].(string); subtle.ConstantTimeCompare([]byte(got), []byte(nonce)) != 1 {
+		return dashboardUser{}, errors.New("the ID token is not of this sign-in (nonce)")
+	}
+	subject, _ := claims["sub"].(string)
+	email, _ := claims["email"].(string)
+	name := email
+	if name == "" {
+		name, _ = claims["preferred_username"].(string)
+	}
+	if name == "" {
+		name = subject
+	}
+	if name == "" {
+		return dashboardUser{}, errors.New("the ID token names no user")
+	}
+	identities := map[string]bool{subject: true, email: true}
+	if groups, ok := claims[p.groupsClaim].([]any); ok {
+		for _, group := range groups {
+			if g, ok := group.(string); ok {
+				identities[g] = true
+			}
+		}
+	}
+	user := dashboardUser{Name: name, Role: roleViewer}
+	if len(p.operators) == 0 {
+		user.Role = roleOperator
+	}
+	for _, operator := range p.operators {
+		if identities[operator] {
+			user.Role = roleOperator
+		}
+	}
+	return user, nil
+}
+
+// signingKey finds the issuer's key kid, reading its JWKS again when the
+// key is new, as issuers roll their keys.
+func (p *oidcProvider) signingKey(config *oidcConfig, kid string) (crypto.PublicKey, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if key, ok := p.keys[kid]; ok {
+		return key, nil
+	}
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := p.getJSON(config.JWKSURI, &set); err != nil {
+		return nil, fmt.Errorf("reading the issuer's keys: %w", err)
+	}
+	p.keys = make(map[string]crypto.PublicKey)
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		if key, err := k.publicKey(); err == nil {
+			p.keys[k.Kid] = key
+		}
+	}
+	if key, ok := p.keys[kid]; ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("the issuer has no signing key %q", kid)
+}
+
+// jsonWebKey is an RSA or EC public key of a JWKS.
+type jsonWebKey struct {
+	Kid string `json:"kid"`
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func (k jsonWebKey) publicKey() (crypto.PublicKey, error) {
+	number := func(s string) (*big.Int, error) {
+		b, err := base64.RawURLEncoding.DecodeString(s)
+		if err != nil || len(b) == 0 {
+			return nil, fmt.Errorf("key %s: malformed number", k.Kid)
+		}
+		return new(big.Int).SetBytes(b), nil
+	}
+	switch k.Kty {
+	case "RSA":
+		n, err := number(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := number(k.E)
+		if err != nil || !e.IsInt64() || e.Int64() > 1<<31 {
+			return nil, fmt.Errorf("key %s: malformed exponent", k.Kid)
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		curves := map[string]elliptic.Curve{"P-256": elliptic.P256(), "P-384": elliptic.P384(), "P-521": elliptic.P521()}
+		curve, ok := curves[k.Crv]
+		if !ok {
+			return nil, fmt.Errorf("key %s: curve %q is not supported", k.Kid, k.Crv)
+		}
+		x, err := number(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := number(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	}
+	return nil, fmt.Errorf("key %s: type %q is not supported", k.Kid, k.Kty)
+}
+
+func randomToken() string {
+	b := make([]byte, 32)
+	crand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// runHashPasswordCommand prints the bcrypt hash of a password for
+// -dashboardUsers. The password is read without echo from a terminal, or
+// as the first line of in.
+func runHashPasswordCommand(in *os.File, out io.Writer) int {
+	var password []byte
+	var err error
+	if term.IsTerminal(int(in.Fd())) {
+		fmt.Fprint(os.Stderr, "Password: ")
+		password, err = term.ReadPassword(int(in.Fd()))
+		fmt.Fprintln(os.Stderr)
+	} else {
+		var line string
+		line, err = bufio.NewReader(in).ReadString('\n')
+		if errors.Is(err, io.EOF) {
+			err = nil
+		}
+		password = []byte(strings.TrimRight(line, "\r\n"))
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if len(password) == 0 {
+		fmt.Fprintln(os.Stderr, "usage: 3270Connect hash-password, then type the password, or pipe it in")
+		return 2
+	}
+	hash, err := bcrypt.GenerateFromPassword(password, bcrypt.DefaultCost)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	fmt.Fprintln(out, string(hash))
+	return 0
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// maxEditedWorkflow is the largest workflow the editor takes, as for the
+// Start Process form.
+const maxEditedWorkflow = 10 << 20
+
+// workflowDiagnostics is what the editor is told of a workflow: every
+// problem found in it, as `3270Connect validate` reports them.
+type workflowDiagnostics struct {
+	Valid  bool              `json:"valid"`
+	Errors []validationIssue `json:"errors"`
+}
+
+func diagnoseWorkflow(data []byte, baseDir string) workflowDiagnostics {
+	issues := validateWorkflowJSON(data, baseDir)
+	if issues == nil {
+		issues = []validationIssue{}
+	}
+	return workflowDiagnostics{Valid: len(issues) == 0, Errors: issues}
+}
+
+// workflowETag tags a version of a workflow, so a save does not overwrite
+// edits made elsewhere since the editor loaded it.
+func workflowETag(data []byte) string {
+	sum := sha256.Sum256(data)
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// readEditedWorkflow reads the workflow in the body of r.
+func readEditedWorkflow(w http.ResponseWriter, r *http.Request) ([]byte, bool) {
+	data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxEditedWorkflow))
+	if err != nil {
+		http.Error(w, "Failed to read the workflow: "+err.Error(), http.StatusRequestEntityTooLarge)
+		return nil, false
+	}
+	return data, true
+}
+
+// saveEditedWorkflow checks the workflow in the body of r and passes it to
+// save, answering with its diagnostics when it has problems. current is
+// the workflow being replaced: a save whose If-Match names another version
+// is refused.
+func saveEditedWorkflow(w http.ResponseWriter, r *http.Request, current []byte, baseDir string, save func([]byte) error) {
+	if match := r.Header.Get("If-Match"); match != "" && match != workflowETag(current) {
+		http.Error(w, "The workflow changed since it was loaded - reload it before saving", http.StatusPreconditionFailed)
+		return
+	}
+	data, ok := readEditedWorkflow(w, r)
+	if !ok {
+		return
+	}
+	if diagnostics := diagnoseWorkflow(data, baseDir); !diagnostics.Valid {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		json.NewEncoder(w).Encode(diagnostics)
+		return
+	}
+	if err := save(data); err != nil {
+		http.Error(w, "Failed to save the workflow: "+err.Error(), launchStatus(err))
+		return
+	}
+	w.Header().Set("ETag", workflowETag(data))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// writeEditedWorkflow answers with a workflow for the editor.
+func writeEditedWorkflow(w http.ResponseWriter, data []byte) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("ETag", workflowETag(data))
+	w.Write(data)
+}
+
+// dashboardWorkflowValidateHandler checks the workflow in the body without
+// saving it. Includes and Setup and Teardown files are looked up from the
+// working directory of the dashboard.
+func dashboardWorkflowValidateHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	data, ok := readEditedWorkflow(w, r)
+	if !ok {
+		return
+	}
+	writeLiveJSON(w, diagnoseWorkflow(data, "."))
+}
+
+// saveRunWorkflow replaces the workflow file of a run with the one in the
+// body of r. Runs started with -hotReload pick up what they can of it.
+func saveRunWorkflow(w http.ResponseWriter, r *http.Request, configPath string) {
+	info, err := os.Stat(configPath)
+	var current []byte
+	if err == nil {
+		current, err = os.ReadFile(configPath)
+	}
+	if err != nil {
+		http.Error(w, "Failed to open workflow file: "+err.Error(), http.StatusNotFound)
+		return
+	}
+	saveEditedWorkflow(w, r, current, filepath.Dir(configPath), func(data []byte) error {
+		// Written aside and renamed, so a run reading it never sees half a
+		// file.
+		tmp := configPath + ".tmp"
+		if err := os.WriteFile(tmp, data, info.Mode().Perm()); err != nil {
+			return &launchError{http.StatusInternalServerError, err.Error()}
+		}
+		if err := os.Rename(tmp, configPath); err != nil {
+			os.Remove(tmp)
+			return &launchError{http.StatusInternalServerError, err.Error()}
+		}
+		dashboardLog.Info(fmt.Sprintf("Saved the workflow %s from the editor", configPath))
+		return nil
+	})
+}
+
+// dashboardProfileWorkflowHandler reads the workflow of profile name=, or
+// replaces it with the one in the body.
+func dashboardProfileWorkflowHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPut {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	name := r.URL.Query().Get("name")
+	p, err := dashboardProfiles.get(name)
+	if errors.Is(err, errNoProfile) {
+		http.Error(w, "No profile named "+strconv.Quote(name), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to read the profiles: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if r.Method == http.MethodGet {
+		writeEditedWorkflow(w, []byte(p.Config))
+		return
+	}
+	saveEditedWorkflow(w, r, []byte(p.Config), ".", func(data []byte) error {
+		p.Config = string(data)
+		// The overrides must still apply to the edited workflow.
+		if _, err := p.workflow(); err != nil {
+			return &launchError{http.StatusUnprocessableEntity, err.Error()}
+		}
+		p.SavedAt = time.Now().UTC()
+		if user, ok := signedInUser(r); ok {
+			p.SavedBy = user.Name
+		}
+		if _, err := dashboardProfiles.save(p); err != nil {
+			return &launchError{http.StatusInternalServerError, err.Error()}
+		}
+		dashboardLog.Info(fmt.Sprintf("Saved the workflow of launch profile %q from the editor", name))
+		return nil
+	})
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"os"
+	"sort"
+)
+
+// failureGroup is a category of failed workflows: how many failed so, and
+// the latest of them, newest first.
+type failureGroup struct {
+	Category string          `json:"category"`
+	Count    int64           `json:"count"`
+	Failures []failureRecord `json:"failures"`
+}
+
+// failureDrillDown is what the dashboard shows of the failed workflows of
+// a run, the categories with the most failures first.
+type failureDrillDown struct {
+	PID        int            `json:"pid"`
+	Failed     int64          `json:"failed"`
+	Categories []failureGroup `json:"categories"`
+}
+
+func groupFailures(m Metrics) failureDrillDown {
+	d := failureDrillDown{PID: m.PID, Failed: m.TotalWorkflowsFailed, Categories: []failureGroup{}}
+	groups := map[string]*failureGroup{}
+	for category, count := range m.FailureCategories {
+		groups[category] = &failureGroup{Category: category, Count: count, Failures: []failureRecord{}}
+	}
+	for i := len(m.Failures) - 1; i >= 0; i-- {
+		f := m.Failures[i]
+		g, ok := groups[f.Category]
+		if !ok {
+			g = &failureGroup{Category: f.Category, Failures: []failureRecord{}}
+			groups[f.Category] = g
+		}
+		g.Failures = append(g.Failures, f)
+		if n := int64(len(g.Failures)); n > g.Count {
+			g.Count = n
+		}
+	}
+	for _, g := range groups {
+		d.Categories = append(d.Categories, *g)
+	}
+	sort.Slice(d.Categories, func(i, j int) bool {
+		if d.Categories[i].Count != d.Categories[j].Count {
+			return d.Categories[i].Count > d.Categories[j].Count
+		}
+		return d.Categories[i].Category < d.Categories[j].Category
+	})
+	return d
+}
+
+// dashboardFailuresHandler lists the failed workflows of run pid= by
+// category, with the step each failed at, its screen and its injection
+// row.
+func dashboardFailuresHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	pid := r.URL.Query().Get("pid")
+	metric, err := loadExtendedMetricByPID(pid)
+	if errors.Is(err, os.ErrNotExist) {
+		http.Error(w, "No run reported with PID "+pid, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	writeLiveJSON(w, groupFailures(metric.Metrics))
+}
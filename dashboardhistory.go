@@ -0,0 +1,232 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// dashboardHistoryLimit is how many runs the dashboard lists at most.
+const dashboardHistoryLimit = 200
+
+// errNoHistory means the dashboard was started with an empty -historyDB.
+var errNoHistory = errors.New("the dashboard keeps no run history: -historyDB is empty")
+
+// dashboardHistoryHandler lists the runs in the -historyDB database of the
+// dashboard, newest first: those not archived, or with archived=1 those
+// archived. pid= lists the runs of a process.
+func dashboardHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	f := historyFilter{archived: -1, limit: dashboardHistoryLimit}
+	if r.URL.Query().Get("archived") == "1" {
+		f.archived = 1
+	}
+	if pid := r.URL.Query().Get("pid"); pid != "" {
+		n, err := strconv.Atoi(pid)
+		if err != nil || n <= 0 {
+			http.Error(w, "Invalid pid", http.StatusBadRequest)
+			return
+		}
+		f.pid, f.archived = n, 0
+	}
+	withDashboardHistory(w, func(db *sql.DB) error {
+		runs, err := loadRuns(db, f)
+		if err != nil {
+			return err
+		}
+		if runs == nil {
+			runs = []runRecord{}
+		}
+		writeLiveJSON(w, runs)
+		return nil
+	})
+}
+
+// dashboardHistoryRunHandler shows the run of id= with its timings, errors,
+// summary and charts, or deletes it.
+func dashboardHistoryRunHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodDelete {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	id, ok := historyRunID(w, r)
+	if !ok {
+		return
+	}
+	withDashboardHistory(w, func(db *sql.DB) error {
+		if r.Method == http.MethodDelete {
+			if err := deleteRun(db, id); err != nil {
+				return err
+			}
+			dashboardLog.Info(fmt.Sprintf("Deleted run %d from %s", id, historyDBFlag))
+			w.WriteHeader(http.StatusNoContent)
+			return nil
+		}
+		run, err := loadRun(db, id)
+		if err != nil {
+			return err
+		}
+		writeLiveJSON(w, run)
+		return nil
+	})
+}
+
+// dashboardHistoryArchiveHandler archives the run of id=, or with
+// archived=false brings it back to the list.
+func dashboardHistoryArchiveHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	id, ok := historyRunID(w, r)
+	if !ok {
+		return
+	}
+	archived := true
+	if value := r.URL.Query().Get("archived"); value != "" {
+		var err error
+		if archived, err = strconv.ParseBool(value); err != nil {
+			http.Error(w, "Invalid archived", http.StatusBadRequest)
+			return
+		}
+	}
+	withDashboardHistory(w, func(db *sql.DB) error {
+		if err := archiveRun(db, id, archived); err != nil {
+			return err
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return nil
+	})
+}
+
+func openDashboardHistory() (*sql.DB, error) {
+	if historyDBFlag == "" {
+		return nil, errNoHistory
+	}
+	return openHistory(historyDBFlag)
+}
+
+// readDashboardHistory runs fn on the history database.
+func readDashboardHistory(fn func(db *sql.DB) error) error {
+	db, err := openDashboardHistory()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	return fn(db)
+}
+
+func historyRunID(w http.ResponseWriter, r *http.Request) (int64, bool) {
+	id, err := strconv.ParseInt(r.URL.Query().Get("id"), 10, 64)
+	if err != nil || id <= 0 {
+		http.Error(w, "Missing or invalid run id", http.StatusBadRequest)
+		return 0, false
+	}
+	return id, true
+}
+
+// withDashboardHistory runs fn on the history database, answering its
+// error when it fails.
+func withDashboardHistory(w http.ResponseWriter, fn func(db *sql.DB) error) {
+	err := readDashboardHistory(fn)
+	switch {
+	case err == nil:
+	case errors.Is(err, errNoHistory), errors.Is(err, errNoRun):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	default:
+		dashboardLog.Warn(fmt.Sprintf("Run history %s: %v", historyDBFlag, err))
+		http.Error(w, "Failed to read the run history: "+err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// recordKilledRun adds a run that died without recording itself, killed
+// from the dashboard or otherwise, to the history from what it last
+// reported. A run already there, by PID and start time, is left alone.
+func recordKilledRun(run registeredRun) error {
+	db, err := openDashboardHistory()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	previous, err := loadRuns(db, historyFilter{pid: run.PID})
+	if err != nil {
+		return err
+	}
+	for _, r := range previous {
+		if r.StartedAt.Unix() == run.StartTimestamp {
+			return nil
+		}
+	}
+	id, err := saveRunRecord(db, killedRunRecord(run))
+	if err == nil {
+		dashboardLog.Info(fmt.Sprintf("PID %d was killed; recorded what it last reported in %s as run %d", run.PID, historyDBFlag, id))
+	}
+	return err
+}
+
+// killedRunRecord is the record of a killed run, from its last report.
+// The run never summed itself up: its workflow times are those of the
+// durations it reported. It has no exit code, and no configuration hash,
+// as the workflow file may have changed since it started.
+func killedRunRecord(run registeredRun) runRecord {
+	var all timingStat
+	for _, d := range run.Durations {
+		all.add(d)
+	}
+	vUsers, _ := strconv.Atoi(paramValue(run.Params, "concurrent"))
+	mode := "single"
+	if vUsers > 1 || run.RuntimeDuration > 0 {
+		mode = "concurrent"
+	}
+	r := runRecord{
+		ConfigPath: run.ConfigFilePath,
+		StartedAt:  time.Unix(run.StartTimestamp, 0),
+		EndedAt:    run.Updated,
+		Mode:       mode,
+		VUsers:     max(vUsers, 1),
+		Started:    run.TotalWorkflowsStarted,
+		Completed:  run.TotalWorkflowsCompleted,
+		Failed:     run.TotalWorkflowsFailed,
+		Avg:        all.Avg,
+		P50:        all.percentile(50),
+		P90:        all.percentile(90),
+		P95:        all.percentile(95),
+		P99:        all.percentile(99),
+		ExitCode:   -1,
+		Outcome:    "killed",
+		PID:        run.PID,
+		Charts: &runCharts{
+			Durations:   run.Durations,
+			CPUUsage:    run.CPUUsage,
+			MemoryUsage: run.MemoryUsage,
+		},
+	}
+	r.Timings = append(timingRecords("transaction", sortedKeys(run.Transactions), run.Transactions),
+		timingRecords("step", sortedKeys(run.Steps), run.Steps)...)
+	return r
+}
+
+// paramValue finds the value of flag name in the parameters of a run.
+func paramValue(params, name string) string {
+	fields := strings.Fields(params)
+	for i, field := range fields {
+		flagName, value, hasValue := strings.Cut(strings.TrimLeft(field, "-"), "=")
+		if !strings.HasPrefix(field, "-") || flagName != name {
+			continue
+		}
+		if hasValue {
+			return value
+		}
+		if i+1 < len(fields) {
+			return fields[i+1]
+		}
+	}
+	return ""
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	defaultInjectionPreviewRows = 10
+	maxInjectionPreviewRows     = 100
+)
+
+// injectionPlaceholderPattern matches placeholders that injection data can
+// fill: {{name}} without a provider, as {{env:NAME}} or {{var:name}} have.
+var injectionPlaceholderPattern = regexp.MustCompile(`\{\{[^{}:]+\}\}`)
+
+// builtinPlaceholders are filled by 3270Connect itself rather than by
+// injection data.
+var builtinPlaceholders = map[string]bool{
+	"{{token}}":     true,
+	"{{uuid}}":      true,
+	"{{timestamp}}": true,
+	"{{date}}":      true,
+}
+
+// injectionPreview is what the Start Process form is told of injection data
+// before a run starts: its first rows, its columns and the placeholders of
+// the workflow it leaves unfilled.
+type injectionPreview struct {
+	InjectionFileName string              `json:"injectionFileName,omitempty"`
+	Rows              int                 `json:"rows"`
+	Columns           []injectionColumn   `json:"columns"`
+	Preview           []map[string]string `json:"preview"`
+	// The rest is set when there is a workflow to check the data against.
+	ConfigFileName string   `json:"configFileName,omitempty"`
+	Placeholders   []string `json:"placeholders"`
+	Missing        []string `json:"missing"`
+	WorkflowError  string   `json:"workflowError,omitempty"`
+}
+
+// injectionColumn is a key of the injection rows. Used tells whether the
+// workflow refers to it, and Rows how many rows give it: rows without it
+// leave its placeholder as typed.
+type injectionColumn struct {
+	Name string `json:"name"`
+	Used bool   `json:"used"`
+	Rows int    `json:"rows"`
+}
+
+// injectableTexts gives the texts of the workflow that injection data is
+// filled into, as injectDynamicValues does.
+func injectableTexts(config *Configuration) []string {
+	var texts []string
+	var walk func(steps []Step)
+	walk = func(steps []Step) {
+		for _, step := range steps {
+			texts = append(texts, step.Text, step.Near)
+			if cond := step.Condition; cond != nil {
+				texts = append(texts, cond.ScreenContains)
+				if cond.ValueEquals != nil {
+					texts = append(texts, cond.ValueEquals.Text)
+				}
+			}
+			walk(step.Steps)
+			walk(step.Else)
+		}
+	}
+	walk(config.Steps)
+	walk(config.OnError)
+	walk(config.SessionSetup)
+	walk(config.SessionTeardown)
+	for _, w := range config.Workflows {
+		walk(w.Steps)
+		walk(w.OnError)
+	}
+	return texts
+}
+
+// workflowPlaceholders lists, sorted, the placeholders of the workflow that
+// injection data is expected to fill.
+func workflowPlaceholders(config *Configuration) []string {
+	seen := map[string]bool{}
+	placeholders := []string{}
+	for _, text := range injectableTexts(config) {
+		for _, placeholder := range injectionPlaceholderPattern.FindAllString(text, -1) {
+			if !builtinPlaceholders[placeholder] && !seen[placeholder] {
+				seen[placeholder] = true
+				placeholders = append(placeholders, placeholder)
+			}
+		}
+	}
+	sort.Strings(placeholders)
+	return placeholders
+}
+
+// previewInjection describes the injection rows, with their first n rows,
+// and checks them against config when it is not nil.
+func previewInjection(rows []map[string]string, config *Configuration, n int) injectionPreview {
+	preview := injectionPreview{Rows: len(rows), Columns: []injectionColumn{}, Preview: rows[:min(n, len(rows))]}
+	if preview.Preview == nil {
+		preview.Preview = []map[string]string{}
+	}
+	counts := map[string]int{}
+	for _, row := range rows {
+		for key := range row {
+			counts[key]++
+		}
+	}
+	for name, count := range counts {
+		preview.Columns = append(preview.Columns, injectionColumn{Name: name, Rows: count})
+	}
+	sort.Slice(preview.Columns, func(i, j int) bool { return preview.Columns[i].Name < preview.Columns[j].Name })
+	if config == nil {
+		return preview
+	}
+	texts := injectableTexts(config)
+	for i, column := range preview.Columns {
+		for _, text := range texts {
+			if strings.Contains(text, column.Name) {
+				preview.Columns[i].Used = true
+				break
+			}
+		}
+	}
+	preview.Placeholders = workflowPlaceholders(config)
+	preview.Missing = []string{}
+	for _, placeholder := range preview.Placeholders {
+		if counts[placeholder] == 0 {
+			preview.Missing = append(preview.Missing, placeholder)
+		}
+	}
+	return preview
+}
+
+// dashboardInjectionPreviewHandler previews the injection file uploaded as
+// injectionConfig and checks it against the workflow uploaded as
+// configFile. With profile=, the files of that saved profile are used for
+// those not uploaded. rows= sets how many rows are shown.
+func dashboardInjectionPreviewHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := r.ParseMultipartForm(10 << 20); err != nil && !errors.Is(err, http.ErrNotMultipart) {
+		http.Error(w, "Failed to parse form data", http.StatusBadRequest)
+		return
+	}
+	n := defaultInjectionPreviewRows
+	if value := r.FormValue("rows"); value != "" {
+		var err error
+		if n, err = strconv.Atoi(value); err != nil || n < 0 {
+			http.Error(w, "Invalid rows", http.StatusBadRequest)
+			return
+		}
+		n = min(n, maxInjectionPreviewRows)
+	}
+	var p launchProfile
+	if name := r.FormValue("profile"); name != "" {
+		var err error
+		if p, err = dashboardProfiles.get(name); errors.Is(err, errNoProfile) {
+			http.Error(w, "No profile named "+strconv.Quote(name), http.StatusNotFound)
+			return
+		} else if err != nil {
+			http.Error(w, "Failed to read the profiles: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	for _, upload := range []struct {
+		field      string
+		name, body *string
+	}{{"configFile", &p.ConfigFileName, &p.Config}, {"injectionConfig", &p.InjectionFileName, &p.Injection}} {
+		file, handler, err := r.FormFile(upload.field)
+		if err != nil {
+			continue
+		}
+		data, err := io.ReadAll(file)
+		file.Close()
+		if err != nil {
+			http.Error(w, "Failed to read "+upload.field, http.StatusInternalServerError)
+			return
+		}
+		*upload.name, *upload.body = filepath.Base(handler.Filename), string(data)
+	}
+	if p.InjectionFileName == "" && p.ConfigFileName == "" {
+		http.Error(w, "Upload an injection file or a workflow to preview", http.StatusBadRequest)
+		return
+	}
+	rows, err := p.injectionRows()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var config *Configuration
+	var workflowErr error
+	if p.ConfigFileName != "" {
+		var c Configuration
+		if c, workflowErr = p.workflow(); workflowErr == nil {
+			config = &c
+		}
+	}
+	preview := previewInjection(rows, config, n)
+	preview.InjectionFileName, preview.ConfigFileName = p.InjectionFileName, p.ConfigFileName
+	if workflowErr != nil {
+		preview.WorkflowError = workflowErr.Error()
+	}
+	writeLiveJSON(w, preview)
+}
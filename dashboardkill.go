@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// killTarget is a run that Kill All would kill.
+type killTarget struct {
+	PID             int    `json:"pid"`
+	Params          string `json:"params,omitempty"`
+	ActiveWorkflows int    `json:"activeWorkflows"`
+	StartTimestamp  int64  `json:"startTimestamp"`
+}
+
+// killFailure is a run that Kill All could not kill.
+type killFailure struct {
+	PID   int    `json:"pid"`
+	Error string `json:"error"`
+}
+
+type killAllResult struct {
+	Killed []int         `json:"killed"`
+	Failed []killFailure `json:"failed"`
+}
+
+// killTargets lists the runs the dashboard knows that are still running,
+// but for the dashboard's own process.
+func killTargets() []killTarget {
+	_, runs := dashboardRuns.list()
+	targets := []killTarget{}
+	for _, m := range runs {
+		if m.IsRunning && m.PID != os.Getpid() {
+			targets = append(targets, killTarget{PID: m.PID, Params: m.Params, ActiveWorkflows: m.ActiveWorkflows, StartTimestamp: m.StartTimestamp})
+		}
+	}
+	return targets
+}
+
+// killAllHandler lists, on GET, the runs Kill All would kill, so they can
+// be confirmed, and kills them on POST. pids= keeps a POST to the runs
+// that were confirmed; a run started since is left alone, and a PID that
+// is not a running run is reported as failed rather than killed.
+func killAllHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		writeLiveJSON(w, map[string][]killTarget{"runs": killTargets()})
+		return
+	case http.MethodPost:
+	default:
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	running := map[int]bool{}
+	var pids []int
+	for _, t := range killTargets() {
+		running[t.PID] = true
+		pids = append(pids, t.PID)
+	}
+	if value := r.URL.Query().Get("pids"); value != "" {
+		pids = nil
+		for _, field := range strings.Split(value, ",") {
+			pid, err := strconv.Atoi(strings.TrimSpace(field))
+			if err != nil || pid <= 0 {
+				http.Error(w, "Invalid PID "+strconv.Quote(field), http.StatusBadRequest)
+				return
+			}
+			pids = append(pids, pid)
+		}
+	}
+	sort.Ints(pids)
+	storeLog(fmt.Sprintf("Received kill-all request for %d processes", len(pids)))
+
+	result := killAllResult{Killed: []int{}, Failed: []killFailure{}}
+	for _, pid := range pids {
+		if !running[pid] {
+			result.Failed = append(result.Failed, killFailure{pid, "Not a running process the dashboard knows"})
+			continue
+		}
+		if _, err := killRun(pid); err != nil {
+			result.Failed = append(result.Failed, killFailure{pid, err.Error()})
+			continue
+		}
+		result.Killed = append(result.Killed, pid)
+	}
+	if len(result.Failed) > 0 {
+		dashboardLog.Warn(fmt.Sprintf("Kill all: killed %d processes, %d failed", len(result.Killed), len(result.Failed)))
+	}
+	writeLiveJSON(w, result)
+}
@@ -0,0 +1,487 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	// dashboardProfilesFile is where the dashboard keeps the saved launch
+	// profiles, in dashboardMetricsDir.
+	dashboardProfilesFile = "profiles.json"
+	maxProfileName        = 100
+)
+
+// launchProfile is what the Start Process form sends: the workflow and
+// injection files with the parameters of the run. Saved under a name, it
+// starts the same run again without uploading the files. The RSA token is
+// never saved, as it is only good once.
+type launchProfile struct {
+	Name              string          `json:"name"`
+	ConfigFileName    string          `json:"configFileName"`
+	Config            string          `json:"config,omitempty"`
+	InjectionFileName string          `json:"injectionFileName,omitempty"`
+	Injection         string          `json:"injection,omitempty"`
+	Concurrent        int             `json:"concurrent"`
+	Runtime           int             `json:"runtime"`
+	StartPort         int             `json:"startPort"`
+	Headless          bool            `json:"headless"`
+	Overrides         launchOverrides `json:"overrides"`
+	SavedAt           time.Time       `json:"savedAt,omitempty"`
+	SavedBy           string          `json:"savedBy,omitempty"`
+}
+
+// launchOverrides replace settings of the workflow, as typed in the form.
+// Empty ones keep the value of the file.
+type launchOverrides struct {
+	Host            string `json:"host,omitempty"`
+	Port            string `json:"port,omitempty"`
+	OutputFilePath  string `json:"outputFilePath,omitempty"`
+	RampUpBatchSize string `json:"rampUpBatchSize,omitempty"`
+	RampUpDelay     string `json:"rampUpDelay,omitempty"`
+}
+
+// launchError is a launch refused with an HTTP status other than 400.
+type launchError struct {
+	status  int
+	message string
+}
+
+func (e *launchError) Error() string { return e.message }
+
+// launchStatus is the HTTP status to answer a failed launch with.
+func launchStatus(err error) int {
+	var le *launchError
+	if errors.As(err, &le) {
+		return le.status
+	}
+	return http.StatusBadRequest
+}
+
+// launchProfileFromForm reads the Start Process form of r, already parsed.
+func launchProfileFromForm(r *http.Request) (launchProfile, error) {
+	file, handler, err := r.FormFile("configFile")
+	if err != nil {
+		return launchProfile{}, errors.New("Failed to retrieve file")
+	}
+	defer file.Close()
+	config, err := io.ReadAll(file)
+	if err != nil {
+		return launchProfile{}, &launchError{http.StatusInternalServerError, "Failed to read configuration file"}
+	}
+	p := launchProfile{
+		ConfigFileName: filepath.Base(handler.Filename),
+		Config:         string(config),
+		Headless:       r.FormValue("headless") == "on", // use "on" for checked
+		Overrides: launchOverrides{
+			Host:            strings.TrimSpace(r.FormValue("overrideHost")),
+			Port:            strings.TrimSpace(r.FormValue("overridePort")),
+			OutputFilePath:  strings.TrimSpace(r.FormValue("overrideOutputFilePath")),
+			RampUpBatchSize: strings.TrimSpace(r.FormValue("overrideRampUpBatchSize")),
+			RampUpDelay:     strings.TrimSpace(r.FormValue("overrideRampUpDelay")),
+		},
+	}
+	// The injection configuration file is optional.
+	if injectionFile, injectionHandler, err := r.FormFile("injectionConfig"); err == nil {
+		defer injectionFile.Close()
+		injection, err := io.ReadAll(injectionFile)
+		if err != nil {
+			return launchProfile{}, &launchError{http.StatusInternalServerError, "Failed to read injection configuration file"}
+		}
+		p.InjectionFileName, p.Injection = filepath.Base(injectionHandler.Filename), string(injection)
+	}
+	for _, field := range []struct {
+		name  string
+		value *int
+	}{{"concurrent", &p.Concurrent}, {"runtime", &p.Runtime}, {"startPort", &p.StartPort}} {
+		n, err := strconv.Atoi(strings.TrimSpace(r.FormValue(field.name)))
+		if err != nil || n < 0 {
+			return launchProfile{}, fmt.Errorf("Invalid %s", field.name)
+		}
+		*field.value = n
+	}
+	return p, nil
+}
+
+// workflow is the workflow of p with its overrides applied, its includes
+// inlined and validated, ready to be written for the run.
+func (p launchProfile) workflow() (Configuration, error) {
+	var config Configuration
+	if err := json.Unmarshal([]byte(p.Config), &config); err != nil {
+		storeLog("Failed to parse configuration JSON: " + err.Error())
+		return config, errors.New("Invalid configuration file")
+	}
+	o := p.Overrides
+	if o.Host != "" {
+		config.Host = o.Host
+	}
+	if o.Port != "" {
+		portValue, err := strconv.Atoi(o.Port)
+		if err != nil {
+			return config, errors.New("Invalid port override")
+		}
+		config.Port, config.portText = portValue, ""
+	}
+	if o.OutputFilePath != "" {
+		config.OutputFilePath = o.OutputFilePath
+	}
+	if o.RampUpBatchSize != "" {
+		batchValue, err := strconv.Atoi(o.RampUpBatchSize)
+		if err != nil {
+			return config, errors.New("Invalid ramp up batch size override")
+		}
+		config.RampUpBatchSize = batchValue
+	}
+	if o.RampUpDelay != "" {
+		delayValue, err := strconv.ParseFloat(o.RampUpDelay, 64)
+		if err != nil {
+			return config, errors.New("Invalid ramp up delay override")
+		}
+		config.RampUpDelay = delayValue
+	}
+
+	// The workflow is rewritten to the temp dir, so inline includes now
+	// while relative paths still resolve from the working directory.
+	var err error
+	if config.Steps, err = expandIncludes(config.Steps, "."); err != nil {
+		return config, err
+	}
+	if config.OnError, err = expandIncludes(config.OnError, "."); err != nil {
+		return config, err
+	}
+	if err := expandSessionIncludes(&config, "."); err != nil {
+		return config, err
+	}
+	if config.Workflows, err = expandSuiteWorkflows(config.Workflows, "."); err != nil {
+		return config, err
+	}
+	if err := validateConfiguration(&config); err != nil {
+		return config, err
+	}
+	return config, nil
+}
+
+// injectionRows reads the injection file of p, if it has one.
+func (p launchProfile) injectionRows() ([]map[string]string, error) {
+	if p.InjectionFileName == "" {
+		return nil, nil
+	}
+	rows, err := parseInjectionData([]byte(p.Injection))
+	if err != nil {
+		return nil, errors.New("Invalid injection configuration file: " + err.Error())
+	}
+	return rows, nil
+}
+
+// launchCommand writes the files of p to the temp dir and gives the
+// command that runs it, reporting to this dashboard.
+func launchCommand(p launchProfile, token string) ([]string, error) {
+	config, err := p.workflow()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := p.injectionRows(); err != nil {
+		return nil, err
+	}
+	updatedJSON, err := json.MarshalIndent(config, "", "  ")
+	if err != nil {
+		return nil, &launchError{http.StatusInternalServerError, "Failed to serialize configuration"}
+	}
+	tempFilePath := filepath.Join(os.TempDir(), filepath.Base(p.ConfigFileName))
+	if err := os.WriteFile(tempFilePath, updatedJSON, 0644); err != nil {
+		return nil, &launchError{http.StatusInternalServerError, "Failed to save file"}
+	}
+	var injectionConfigPath string
+	if p.InjectionFileName != "" {
+		injectionConfigPath = filepath.Join(os.TempDir(), filepath.Base(p.InjectionFileName))
+		if err := os.WriteFile(injectionConfigPath, []byte(p.Injection), 0644); err != nil {
+			return nil, &launchError{http.StatusInternalServerError, "Failed to save injection configuration file"}
+		}
+	}
+
+	commandArgs := []string{
+		getExecutablePath(),
+		"-config", tempFilePath,
+		"-concurrent", strconv.Itoa(p.Concurrent),
+		"-runtime", strconv.Itoa(p.Runtime),
+		"-startPort", strconv.Itoa(p.StartPort),
+		// Runs report to this dashboard instead of starting their own.
+		"-dashboard-bind", dashboardListenAddr,
+		// The workflow came from a dashboard user, not from the server.
+		"-submittedWorkflow",
+	}
+	if submittedSecrets != "" {
+		commandArgs = append(commandArgs, "-submittedSecrets", submittedSecrets)
+	}
+	if p.Headless {
+		commandArgs = append(commandArgs, "-headless")
+	}
+	if injectionConfigPath != "" {
+		commandArgs = append(commandArgs, "-injectionConfig", injectionConfigPath)
+	}
+	if token != "" {
+		commandArgs = append(commandArgs, "-token", token)
+	}
+	return commandArgs, nil
+}
+
+// launch starts the run of p in a process of its own and gives its PID.
+// exited, if not nil, is called with the outcome once the run ends.
+func launch(p launchProfile, token string, exited func(error)) (int, error) {
+	commandArgs, err := launchCommand(p, token)
+	if err != nil {
+		return 0, err
+	}
+	maskedArgs := make([]string, len(commandArgs))
+	copy(maskedArgs, commandArgs)
+	for i := 0; i < len(maskedArgs); i++ {
+		if maskedArgs[i] == "-token" && i+1 < len(maskedArgs) {
+			maskedArgs[i+1] = "[REDACTED]"
+		}
+	}
+	commandForLog := strings.Join(maskedArgs, " ")
+	storeLog("Command to execute: " + commandForLog)
+	dashboardLog.Info("Executing command: " + commandForLog)
+
+	cmd := exec.Command(commandArgs[0], commandArgs[1:]...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Start(); err != nil {
+		dashboardLog.Error(fmt.Sprintf("Failed to execute command: %v", err))
+		return 0, &launchError{http.StatusInternalServerError, "Failed to start the process: " + err.Error()}
+	}
+	go func() {
+		err := cmd.Wait()
+		if err != nil {
+			dashboardLog.Error(fmt.Sprintf("Failed to execute command: %v", err))
+		}
+		if exited != nil {
+			exited(err)
+		}
+	}()
+	return cmd.Process.Pid, nil
+}
+
+// profileStore keeps the launch profiles in dashboardProfilesFile. Only
+// their owner may read the file, as workflows may hold credentials.
+type profileStore struct {
+	mu sync.Mutex
+}
+
+var dashboardProfiles = &profileStore{}
+
+var errNoProfile = errors.New("no such profile")
+
+func (s *profileStore) path() string {
+	return filepath.Join(dashboardMetricsDir(), dashboardProfilesFile)
+}
+
+func (s *profileStore) read() ([]launchProfile, error) {
+	data, err := os.ReadFile(s.path())
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var profiles []launchProfile
+	if err := json.Unmarshal(data, &profiles); err != nil {
+		return nil, fmt.Errorf("%s: %w", dashboardProfilesFile, err)
+	}
+	return profiles, nil
+}
+
+func (s *profileStore) write(profiles []launchProfile) error {
+	sort.Slice(profiles, func(i, k int) bool { return strings.ToLower(profiles[i].Name) < strings.ToLower(profiles[k].Name) })
+	data, err := json.MarshalIndent(profiles, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(s.path()), 0755); err != nil {
+		return err
+	}
+	// Written aside and renamed, so a crash never leaves half a file.
+	tmp := s.path() + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path())
+}
+
+// list gives the profiles by name.
+func (s *profileStore) list() ([]launchProfile, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.read()
+}
+
+func (s *profileStore) get(name string) (launchProfile, error) {
+	profiles, err := s.list()
+	if err != nil {
+		return launchProfile{}, err
+	}
+	for _, p := range profiles {
+		if p.Name == name {
+			return p, nil
+		}
+	}
+	return launchProfile{}, errNoProfile
+}
+
+// save adds p, replacing the profile of the same name. It reports whether
+// one was replaced.
+func (s *profileStore) save(p launchProfile) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	profiles, err := s.read()
+	if err != nil {
+		return false, err
+	}
+	for i := range profiles {
+		if profiles[i].Name == p.Name {
+			profiles[i] = p
+			return true, s.write(profiles)
+		}
+	}
+	return false, s.write(append(profiles, p))
+}
+
+func (s *profileStore) delete(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	profiles, err := s.read()
+	if err != nil {
+		return err
+	}
+	for i := range profiles {
+		if profiles[i].Name == name {
+			return s.write(append(profiles[:i], profiles[i+1:]...))
+		}
+	}
+	return errNoProfile
+}
+
+// profileName checks the name a profile is saved under.
+func profileName(name string) (string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return "", errors.New("Missing profile name")
+	}
+	if utf8.RuneCountInString(name) > maxProfileName {
+		return "", fmt.Errorf("Profile names are at most %d characters", maxProfileName)
+	}
+	return name, nil
+}
+
+// saveLaunchProfile saves p under name, once its workflow checks out.
+func saveLaunchProfile(r *http.Request, name string, p launchProfile) (bool, error) {
+	if _, err := p.workflow(); err != nil {
+		return false, err
+	}
+	if _, err := p.injectionRows(); err != nil {
+		return false, err
+	}
+	p.Name, p.SavedAt = name, time.Now().UTC()
+	if user, ok := signedInUser(r); ok {
+		p.SavedBy = user.Name
+	}
+	replaced, err := dashboardProfiles.save(p)
+	if err != nil {
+		dashboardLog.Warn(fmt.Sprintf("Failed to save launch profile %q: %v", name, err))
+		return false, &launchError{http.StatusInternalServerError, "Failed to save profile: " + err.Error()}
+	}
+	dashboardLog.Info(fmt.Sprintf("Saved launch profile %q", name))
+	return replaced, nil
+}
+
+// dashboardProfilesHandler lists the launch profiles, without their files;
+// saves the Start Process form as profile name=; or deletes profile name=.
+func dashboardProfilesHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		profiles, err := dashboardProfiles.list()
+		if err != nil {
+			http.Error(w, "Failed to read the profiles: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		for i := range profiles {
+			profiles[i].Config, profiles[i].Injection = "", ""
+		}
+		if profiles == nil {
+			profiles = []launchProfile{}
+		}
+		writeLiveJSON(w, profiles)
+	case http.MethodPost:
+		if err := r.ParseMultipartForm(10 << 20); err != nil { // 10 MB max file size
+			http.Error(w, "Failed to parse form data", http.StatusBadRequest)
+			return
+		}
+		name, err := profileName(r.FormValue("name"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		p, err := launchProfileFromForm(r)
+		if err == nil {
+			var replaced bool
+			if replaced, err = saveLaunchProfile(r, name, p); err == nil && !replaced {
+				w.WriteHeader(http.StatusCreated)
+			}
+		}
+		if err != nil {
+			http.Error(w, err.Error(), launchStatus(err))
+		}
+	case http.MethodDelete:
+		name := r.URL.Query().Get("name")
+		switch err := dashboardProfiles.delete(name); {
+		case errors.Is(err, errNoProfile):
+			http.Error(w, "No profile named "+strconv.Quote(name), http.StatusNotFound)
+		case err != nil:
+			http.Error(w, "Failed to delete the profile: "+err.Error(), http.StatusInternalServerError)
+		default:
+			dashboardLog.Info(fmt.Sprintf("Deleted launch profile %q", name))
+			w.WriteHeader(http.StatusNoContent)
+		}
+	default:
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// dashboardProfileLaunchHandler starts a run of profile name=, with the RSA
+// token of the form if it has one.
+func dashboardProfileLaunchHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	name := r.URL.Query().Get("name")
+	p, err := dashboardProfiles.get(name)
+	if errors.Is(err, errNoProfile) {
+		http.Error(w, "No profile named "+strconv.Quote(name), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to read the profiles: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	storeLog("Launching profile " + strconv.Quote(name))
+	if _, err := launch(p, strings.TrimSpace(r.FormValue("token")), nil); err != nil {
+		http.Error(w, err.Error(), launchStatus(err))
+		return
+	}
+	storeLog("Process started successfully")
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("Process started successfully"))
+}
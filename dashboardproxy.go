@@ -0,0 +1,116 @@
+package main
+
+import (
+	"crypto/tls"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var (
+	dashboardBasePath string
+	dashboardTLSCert  string
+	dashboardTLSKey   string
+)
+
+func init() {
+	flag.StringVar(&dashboardBasePath, "dashboardBasePath", "", "URL path a reverse proxy serves the dashboard under, such as /loadtest")
+	flag.StringVar(&dashboardTLSCert, "dashboardTLSCert", "", "Serve the dashboard over HTTPS with this PEM certificate, followed by any intermediates")
+	flag.StringVar(&dashboardTLSKey, "dashboardTLSKey", "", "PEM private key of -dashboardTLSCert")
+}
+
+// dashboardTLS is the TLS configuration of the dashboard; nil serves plain
+// HTTP.
+var dashboardTLS *tls.Config
+
+// basePathPattern keeps base paths to characters that need no escaping in
+// a URL, an HTML attribute or a script.
+var basePathPattern = regexp.MustCompile(`^(/[A-Za-z0-9._~-]+)+$`)
+
+// setupDashboardServing checks -dashboardBasePath, which loses any
+// trailing slash, and loads the certificate of -dashboardTLSCert.
+func setupDashboardServing() error {
+	base := strings.TrimSuffix(dashboardBasePath, "/")
+	if base != "" && (!basePathPattern.MatchString(base) || strings.Contains(base+"/", "/./") || strings.Contains(base+"/", "/../")) {
+		return fmt.Errorf("-dashboardBasePath %q should be a path such as /loadtest", dashboardBasePath)
+	}
+	dashboardBasePath = base
+	if dashboardTLSCert == "" && dashboardTLSKey == "" {
+		return nil
+	}
+	if dashboardTLSCert == "" || dashboardTLSKey == "" {
+		return errors.New("-dashboardTLSCert and -dashboardTLSKey go together")
+	}
+	cert, err := tls.LoadX509KeyPair(dashboardTLSCert, dashboardTLSKey)
+	if err != nil {
+		return fmt.Errorf("-dashboardTLSCert: %w", err)
+	}
+	dashboardTLS = &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}
+	return nil
+}
+
+// dashboardPath is where browsers find path of the dashboard, under
+// -dashboardBasePath.
+func dashboardPath(path string) string {
+	return dashboardBasePath + path
+}
+
+// withBasePath serves the requests under -dashboardBasePath as the same
+// requests without it. Requests without it are served too, as they come
+// from proxies that strip the base path and from the runs reporting to the
+// dashboard directly. The base path itself leads to the dashboard.
+func withBasePath(next http.Handler) http.Handler {
+	if dashboardBasePath == "" {
+		return next
+	}
+	stripped := http.StripPrefix(dashboardBasePath, next)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/" || r.URL.Path == dashboardBasePath || r.URL.Path == dashboardBasePath+"/":
+			http.Redirect(w, r, dashboardPath("/dashboard"), http.StatusFound)
+		case strings.HasPrefix(r.URL.Path, dashboardBasePath+"/"):
+			stripped.ServeHTTP(w, r)
+		default:
+			next.ServeHTTP(w, r)
+		}
+	})
+}
+
+// isHTTPS reports whether the browser reached the dashboard over HTTPS,
+// directly or through a reverse proxy that says so.
+func isHTTPS(r *http.Request) bool {
+	return r.TLS != nil || strings.EqualFold(r.Header.Get("X-Forwarded-Proto"), "https")
+}
+
+// dashboardCertPath is where the dashboard listening on port leaves its
+// certificate, for the runs reporting to it to recognise it by.
+func dashboardCertPath(port int) string {
+	return filepath.Join(dashboardMetricsDir(), "dashboardcert_"+strconv.Itoa(port)+".pem")
+}
+
+// publishDashboardCert leaves the dashboard's certificate where the runs
+// reporting to it look, or removes one a dashboard on the same port left
+// before when this one serves plain HTTP.
+func publishDashboardCert() error {
+	path := dashboardCertPath(dashboardPort)
+	if dashboardTLS == nil {
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		return nil
+	}
+	data, err := os.ReadFile(dashboardTLSCert)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
@@ -0,0 +1,324 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// dashboardRunsFile is where the dashboard keeps the runs it knows,
+	// in dashboardMetricsDir.
+	dashboardRunsFile = "runs.json"
+	// dashboardReportHeartbeat is how often a run reports even when its
+	// metrics have not changed, so that a dashboard started since learns
+	// of it.
+	dashboardReportHeartbeat = 30 * time.Second
+	dashboardReportTimeout   = 2 * time.Second
+	maxDashboardReport       = 8 << 20
+)
+
+// registeredRun is the latest metrics a run reported, and when. Recorded
+// is set once a killed run is in the run history.
+type registeredRun struct {
+	Metrics
+	Updated  time.Time `json:"updated"`
+	Recorded bool      `json:"recorded,omitempty"`
+}
+
+// dashboardRegistry holds what each run last reported to the dashboard,
+// by PID. Runs post their metrics to /dashboard/report; the run hosting
+// the dashboard reports in process. The registry is saved to
+// dashboardRunsFile, so a dashboard started later still shows the runs
+// before it.
+type dashboardRegistry struct {
+	mu    sync.Mutex
+	runs  map[int]registeredRun
+	dirty bool
+}
+
+var dashboardRuns = &dashboardRegistry{runs: make(map[int]registeredRun)}
+
+func (d *dashboardRegistry) report(m Metrics) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.runs[m.PID] = registeredRun{Metrics: m, Updated: time.Now()}
+	d.dirty = true
+}
+
+// markKilled clears the workflows in flight of a run the dashboard
+// killed, keeping what it ran so the totals stay right.
+func (d *dashboardRegistry) markKilled(pid int) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	run, ok := d.runs[pid]
+	if !ok {
+		return false
+	}
+	run.ActiveWorkflows = 0
+	d.runs[pid] = run
+	d.dirty = true
+	return true
+}
+
+func (d *dashboardRegistry) get(pid int) (ExtendedMetrics, bool) {
+	d.mu.Lock()
+	run, ok := d.runs[pid]
+	d.mu.Unlock()
+	if !ok {
+		return ExtendedMetrics{}, false
+	}
+	return run.extend(), true
+}
+
+// list gives the runs in the order they started, forgetting those killed
+// over 10 minutes ago along with their logs. A run found killed is first
+// recorded in the run history, as it could not record itself, unless it
+// never started a workflow.
+func (d *dashboardRegistry) list() ([]Metrics, []ExtendedMetrics) {
+	d.mu.Lock()
+	runs := make([]registeredRun, 0, len(d.runs))
+	for _, run := range d.runs {
+		runs = append(runs, run)
+	}
+	d.mu.Unlock()
+	sort.Slice(runs, func(i, k int) bool {
+		if runs[i].StartTimestamp != runs[k].StartTimestamp {
+			return runs[i].StartTimestamp < runs[k].StartTimestamp
+		}
+		return runs[i].PID < runs[k].PID
+	})
+
+	var metricsList []Metrics
+	var extendedList []ExtendedMetrics
+	for _, run := range runs {
+		// extend looks for the process, so it runs without the lock.
+		extended := run.extend()
+		if extended.Status == "Killed" && !run.Recorded && run.ConfigFilePath != "" && run.TotalWorkflowsStarted > 0 && d.markRecorded(run.PID, run.Updated) {
+			if err := recordKilledRun(run); err != nil && !errors.Is(err, errNoHistory) {
+				dashboardLog.Warn(fmt.Sprintf("Failed to record killed PID %d in %s: %v", run.PID, historyDBFlag, err))
+			}
+		}
+		if shouldCleanupMetric(extended, run.Updated) {
+			d.forget(run.PID, run.Updated)
+			cleanupProcessArtifacts(run.PID)
+			continue
+		}
+		// The failures, with their screens, are only sent when asked for.
+		run.Failures, extended.Failures = nil, nil
+		metricsList = append(metricsList, run.Metrics)
+		extendedList = append(extendedList, extended)
+	}
+	return metricsList, extendedList
+}
+
+// markRecorded notes that the run of pid, as reported at updated, is in
+// the run history. It reports false when another call already did.
+func (d *dashboardRegistry) markRecorded(pid int, updated time.Time) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	run, ok := d.runs[pid]
+	if !ok || run.Recorded || !run.Updated.Equal(updated) {
+		return false
+	}
+	run.Recorded = true
+	d.runs[pid] = run
+	d.dirty = true
+	return true
+}
+
+// forget drops the run of pid, unless it reported again after updated.
+func (d *dashboardRegistry) forget(pid int, updated time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if run, ok := d.runs[pid]; ok && !run.Updated.After(updated) {
+		delete(d.runs, pid)
+		d.dirty = true
+	}
+}
+
+// save writes the registry to dashboardRunsFile when it has changed.
+func (d *dashboardRegistry) save() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if !d.dirty {
+		return nil
+	}
+	runs := make([]registeredRun, 0, len(d.runs))
+	for _, run := range d.runs {
+		runs = append(runs, run)
+	}
+	sort.Slice(runs, func(i, k int) bool { return runs[i].PID < runs[k].PID })
+	data, err := json.Marshal(runs)
+	if err != nil {
+		return err
+	}
+	dir := dashboardMetricsDir()
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	// Written aside and renamed, so a crash never leaves half a file.
+	tmp := filepath.Join(dir, dashboardRunsFile+".tmp")
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, filepath.Join(dir, dashboardRunsFile)); err != nil {
+		return err
+	}
+	d.dirty = false
+	return nil
+}
+
+// load reads the registry a previous dashboard saved, and the reports
+// runs spooled while no dashboard was listening.
+func (d *dashboardRegistry) load() error {
+	dir := dashboardMetricsDir()
+	data, err := os.ReadFile(filepath.Join(dir, dashboardRunsFile))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	var runs []registeredRun
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &runs); err != nil {
+			return fmt.Errorf("%s: %w", dashboardRunsFile, err)
+		}
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, run := range runs {
+		d.runs[run.PID] = run
+	}
+	spooled, _ := filepath.Glob(filepath.Join(dir, "report_*.json"))
+	for _, path := range spooled {
+		info, statErr := os.Stat(path)
+		data, readErr := os.ReadFile(path)
+		os.Remove(path)
+		var m Metrics
+		if statErr != nil || readErr != nil || json.Unmarshal(data, &m) != nil || m.PID <= 0 {
+			continue
+		}
+		if run, ok := d.runs[m.PID]; !ok || info.ModTime().After(run.Updated) {
+			d.runs[m.PID] = registeredRun{Metrics: m, Updated: info.ModTime()}
+			d.dirty = true
+		}
+	}
+	return nil
+}
+
+// dashboardReportHandler takes the metrics of a run on this machine.
+func dashboardReportHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !isLocalRequest(r) {
+		http.Error(w, "Runs report from this machine only", http.StatusForbidden)
+		return
+	}
+	var m Metrics
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxDashboardReport)).Decode(&m); err != nil || m.PID <= 0 {
+		http.Error(w, "Invalid metrics report", http.StatusBadRequest)
+		return
+	}
+	dashboardRuns.report(m)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// isLocalRequest reports whether r comes from this machine: from a
+// loopback address, or from one of its own when the dashboard listens on
+// a network address.
+func isLocalRequest(r *http.Request) bool {
+	// A reverse proxy on this machine passes on requests from anywhere.
+	if r.Header.Get("X-Forwarded-For") != "" || r.Header.Get("Forwarded") != "" {
+		return false
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	ip := net.ParseIP(host)
+	if err != nil || ip == nil {
+		return false
+	}
+	if ip.IsLoopback() {
+		return true
+	}
+	addrs, _ := net.InterfaceAddrs()
+	for _, addr := range addrs {
+		if ipNet, ok := addr.(*net.IPNet); ok && ipNet.IP.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// What this run last reported to a dashboard in another process.
+var (
+	metricsReportMu     sync.Mutex
+	lastMetricsReport   []byte
+	lastMetricsReportAt time.Time
+	metricsSpooled      bool
+)
+
+// deliverMetrics reports snapshot, the metrics of this run, to the
+// dashboard of another process, unless it has not changed since the last
+// heartbeat. While no dashboard listens it is spooled instead, for the
+// next dashboard to pick up when it starts.
+func deliverMetrics(pid int, snapshot []byte) {
+	metricsReportMu.Lock()
+	defer metricsReportMu.Unlock()
+	changed := !bytes.Equal(snapshot, lastMetricsReport)
+	if !changed && time.Since(lastMetricsReportAt) < dashboardReportHeartbeat {
+		return
+	}
+	lastMetricsReport, lastMetricsReportAt = snapshot, time.Now()
+	spool := filepath.Join(dashboardMetricsDir(), "report_"+strconv.Itoa(pid)+".json")
+	if err := postDashboardReport(snapshot); err != nil {
+		dashboardLog.Debug(fmt.Sprintf("No dashboard took the metrics of PID %d: %v", pid, err))
+		if changed || !metricsSpooled {
+			os.MkdirAll(filepath.Dir(spool), 0755)
+			if err := os.WriteFile(spool, snapshot, 0644); err != nil {
+				dashboardLog.Warn(fmt.Sprintf("Cannot spool the metrics of PID %d: %v", pid, err))
+			} else {
+				metricsSpooled = true
+			}
+		}
+		return
+	}
+	if metricsSpooled {
+		os.Remove(spool)
+		metricsSpooled = false
+	}
+}
+
+// postDashboardReport sends snapshot to the dashboard over HTTPS when the
+// dashboard has left its certificate to recognise it by.
+func postDashboardReport(snapshot []byte) error {
+	scheme := "http"
+	client := &http.Client{Timeout: dashboardReportTimeout}
+	if certPath := dashboardCertPath(dashboardPort); fileExists(certPath) {
+		transport, err := pinnedTransport(certPath)
+		if err != nil {
+			return err
+		}
+		scheme, client.Transport = "https", transport
+	}
+	resp, err := client.Post(scheme+"://"+localAddr(dashboardListenAddr)+"/dashboard/report", "application/json", bytes.NewReader(snapshot))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("dashboard answered %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
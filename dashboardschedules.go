@@ -0,0 +1,470 @@
+package main
+
+import (
+	crand "crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	// dashboardSchedulesFile is where the dashboard keeps the scheduled
+	// runs and how they went, in dashboardMetricsDir.
+	dashboardSchedulesFile = "schedules.json"
+	// scheduleResultsKept is how many results of each schedule are kept.
+	scheduleResultsKept = 10
+	// scheduleUpcoming is how many of its next times a schedule lists.
+	scheduleUpcoming = 5
+	maxScheduleName  = 100
+)
+
+// runSchedule starts the launch profile Profile at the times of the cron
+// expression Cron, in the time zone of the dashboard.
+type runSchedule struct {
+	ID        string           `json:"id"`
+	Name      string           `json:"name"`
+	Cron      string           `json:"cron"`
+	Profile   string           `json:"profile"`
+	Enabled   bool             `json:"enabled"`
+	CreatedAt time.Time        `json:"createdAt"`
+	CreatedBy string           `json:"createdBy,omitempty"`
+	Results   []scheduleResult `json:"results"`
+}
+
+// scheduleResult is how a scheduled run went: running, passed, failed
+// with ExitCode, killed, skipped while the previous run was still going,
+// or error when it could not start.
+type scheduleResult struct {
+	At       time.Time  `json:"at"`
+	Outcome  string     `json:"outcome"`
+	PID      int        `json:"pid,omitempty"`
+	ExitCode int        `json:"exitCode,omitempty"`
+	EndedAt  *time.Time `json:"endedAt,omitempty"`
+	Message  string     `json:"message,omitempty"`
+}
+
+// scheduleView is a schedule as the dashboard lists it, with its next
+// times.
+type scheduleView struct {
+	runSchedule
+	Next  []time.Time `json:"next"`
+	Error string      `json:"error,omitempty"`
+}
+
+// runScheduler starts the scheduled runs while the dashboard is up. Runs
+// due while it is down are not made up for.
+type runScheduler struct {
+	mu        sync.Mutex
+	schedules []runSchedule
+	// running holds, by schedule ID, when its run in flight started.
+	running map[string]time.Time
+	// fired holds the minute each schedule last fired, so a minute is not
+	// run twice.
+	fired map[string]time.Time
+}
+
+var dashboardSchedules = &runScheduler{running: make(map[string]time.Time), fired: make(map[string]time.Time)}
+
+var errNoSchedule = errors.New("no such schedule")
+
+func (s *runScheduler) path() string {
+	return filepath.Join(dashboardMetricsDir(), dashboardSchedulesFile)
+}
+
+// load reads the schedules a previous dashboard saved. A run still going
+// when it stopped is no longer followed.
+func (s *runScheduler) load() error {
+	data, err := os.ReadFile(s.path())
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var schedules []runSchedule
+	if err := json.Unmarshal(data, &schedules); err != nil {
+		return fmt.Errorf("%s: %w", dashboardSchedulesFile, err)
+	}
+	for i := range schedules {
+		for k := range schedules[i].Results {
+			if r := &schedules[i].Results[k]; r.Outcome == "running" {
+				r.Outcome, r.Message = "unknown", "the dashboard stopped while it ran"
+			}
+		}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.schedules = schedules
+	return nil
+}
+
+// save writes the schedules, with s.mu held.
+func (s *runScheduler) save() error {
+	data, err := json.MarshalIndent(s.schedules, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(s.path()), 0755); err != nil {
+		return err
+	}
+	// Written aside and renamed, so a crash never leaves half a file.
+	tmp := s.path() + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path())
+}
+
+func (s *runScheduler) saveOrWarn() {
+	if err := s.save(); err != nil {
+		schedulerLog.Warn(fmt.Sprintf("Failed to save %s: %v", dashboardSchedulesFile, err))
+	}
+}
+
+func (s *runScheduler) find(id string) int {
+	for i := range s.schedules {
+		if s.schedules[i].ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// list gives the schedules with their next times after now.
+func (s *runScheduler) list(now time.Time) []scheduleView {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	views := make([]scheduleView, 0, len(s.schedules))
+	for _, sc := range s.schedules {
+		view := scheduleView{runSchedule: sc, Next: []time.Time{}}
+		view.Results = append([]scheduleResult{}, sc.Results...)
+		if cron, err := parseCron(sc.Cron); err != nil {
+			view.Error = err.Error()
+		} else if sc.Enabled {
+			if next, err := cron.upcoming(now, scheduleUpcoming); err != nil {
+				view.Error = err.Error()
+			} else {
+				view.Next = next
+			}
+		}
+		views = append(views, view)
+	}
+	return views
+}
+
+func (s *runScheduler) add(sc runSchedule) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.schedules = append(s.schedules, sc)
+	return s.save()
+}
+
+func (s *runScheduler) remove(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i := s.find(id)
+	if i < 0 {
+		return errNoSchedule
+	}
+	s.schedules = append(s.schedules[:i], s.schedules[i+1:]...)
+	return s.save()
+}
+
+func (s *runScheduler) setEnabled(id string, enabled bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i := s.find(id)
+	if i < 0 {
+		return errNoSchedule
+	}
+	s.schedules[i].Enabled = enabled
+	return s.save()
+}
+
+// record adds result as the latest of schedule id, with s.mu held.
+func (s *runScheduler) record(id string, result scheduleResult) {
+	i := s.find(id)
+	if i < 0 {
+		return
+	}
+	results := append([]scheduleResult{result}, s.schedules[i].Results...)
+	if len(results) > scheduleResultsKept {
+		results = results[:scheduleResultsKept]
+	}
+	s.schedules[i].Results = results
+	s.saveOrWarn()
+}
+
+// update changes the result of schedule id that started at, with s.mu
+// held.
+func (s *runScheduler) update(id string, at time.Time, fn func(*scheduleResult)) {
+	i := s.find(id)
+	if i < 0 {
+		return
+	}
+	for k := range s.schedules[i].Results {
+		if r := &s.schedules[i].Results[k]; r.At.Equal(at) {
+			fn(r)
+			s.saveOrWarn()
+			return
+		}
+	}
+}
+
+// tick starts the enabled schedules due in the minute of now.
+func (s *runScheduler) tick(now time.Time) {
+	minute := now.Truncate(time.Minute)
+	var due []string
+	s.mu.Lock()
+	for _, sc := range s.schedules {
+		if !sc.Enabled || s.fired[sc.ID].Equal(minute) {
+			continue
+		}
+		cron, err := parseCron(sc.Cron)
+		if err != nil {
+			continue
+		}
+		if next, err := cron.next(minute.Add(-time.Minute)); err == nil && next.Equal(minute) {
+			s.fired[sc.ID] = minute
+			due = append(due, sc.ID)
+		}
+	}
+	s.mu.Unlock()
+	for _, id := range due {
+		s.start(id, now)
+	}
+}
+
+// start runs the profile of schedule id, unless its previous run is still
+// going.
+func (s *runScheduler) start(id string, at time.Time) error {
+	s.mu.Lock()
+	i := s.find(id)
+	if i < 0 {
+		s.mu.Unlock()
+		return errNoSchedule
+	}
+	sc := s.schedules[i]
+	if started, ok := s.running[id]; ok {
+		message := fmt.Sprintf("the run started at %s is still going", started.Format(time.RFC3339))
+		s.record(id, scheduleResult{At: at, Outcome: "skipped", Message: message})
+		s.mu.Unlock()
+		schedulerLog.Warn(fmt.Sprintf("Skipped schedule %q: %s", sc.Name, message))
+		return &launchError{http.StatusConflict, "Skipped: " + message}
+	}
+	s.running[id] = at
+	s.record(id, scheduleResult{At: at, Outcome: "running"})
+	s.mu.Unlock()
+
+	p, err := dashboardProfiles.get(sc.Profile)
+	if errors.Is(err, errNoProfile) {
+		err = fmt.Errorf("no profile named %q", sc.Profile)
+	}
+	var pid int
+	if err == nil {
+		pid, err = launch(p, "", func(err error) { s.finished(id, at, err) })
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err != nil {
+		delete(s.running, id)
+		s.update(id, at, func(r *scheduleResult) {
+			r.Outcome, r.Message = "error", err.Error()
+		})
+		schedulerLog.Error(fmt.Sprintf("Schedule %q could not start profile %q: %v", sc.Name, sc.Profile, err))
+		return err
+	}
+	s.update(id, at, func(r *scheduleResult) { r.PID = pid })
+	schedulerLog.Info(fmt.Sprintf("Schedule %q started profile %q as PID %d", sc.Name, sc.Profile, pid))
+	return nil
+}
+
+// finished records how the run schedule id started at ended.
+func (s *runScheduler) finished(id string, at time.Time, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.running[id].Equal(at) {
+		delete(s.running, id)
+	}
+	ended := time.Now()
+	s.update(id, at, func(r *scheduleResult) {
+		r.EndedAt = &ended
+		var exitErr *exec.ExitError
+		switch {
+		case err == nil:
+			r.Outcome = "passed"
+		case errors.As(err, &exitErr) && exitErr.ExitCode() < 0:
+			r.Outcome, r.Message = "killed", exitErr.Error()
+		case errors.As(err, &exitErr):
+			r.Outcome, r.ExitCode = "failed", exitErr.ExitCode()
+		default:
+			r.Outcome, r.Message = "error", err.Error()
+		}
+	})
+}
+
+// run starts the schedules as they fall due, at the start of each minute.
+func (s *runScheduler) run() {
+	for {
+		now := time.Now()
+		time.Sleep(now.Truncate(time.Minute).Add(time.Minute).Sub(now))
+		s.tick(time.Now())
+	}
+}
+
+// startScheduler loads the schedules of the dashboard and starts them as
+// they fall due.
+func startScheduler() {
+	if err := dashboardSchedules.load(); err != nil {
+		schedulerLog.Warn(fmt.Sprintf("Failed to load the scheduled runs: %v", err))
+	}
+	go dashboardSchedules.run()
+}
+
+// dashboardSchedulesHandler lists the schedules, adds one from a JSON body
+// of name, cron, profile and enabled, or deletes schedule id=.
+func dashboardSchedulesHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		writeLiveJSON(w, dashboardSchedules.list(time.Now()))
+	case http.MethodPost:
+		var body struct {
+			Name    string `json:"name"`
+			Cron    string `json:"cron"`
+			Profile string `json:"profile"`
+			Enabled *bool  `json:"enabled"`
+		}
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<10)).Decode(&body); err != nil {
+			http.Error(w, "Invalid schedule: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		sc := runSchedule{
+			Name:      strings.TrimSpace(body.Name),
+			Cron:      strings.Join(strings.Fields(body.Cron), " "),
+			Profile:   body.Profile,
+			Enabled:   body.Enabled == nil || *body.Enabled,
+			CreatedAt: time.Now().UTC(),
+			Results:   []scheduleResult{},
+		}
+		if err := checkSchedule(sc); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		id := make([]byte, 8)
+		crand.Read(id)
+		sc.ID = hex.EncodeToString(id)
+		if user, ok := signedInUser(r); ok {
+			sc.CreatedBy = user.Name
+		}
+		if err := dashboardSchedules.add(sc); err != nil {
+			http.Error(w, "Failed to save the schedule: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		schedulerLog.Info(fmt.Sprintf("Scheduled profile %q at %q as %q", sc.Profile, sc.Cron, sc.Name))
+		w.WriteHeader(http.StatusCreated)
+		for _, view := range dashboardSchedules.list(time.Now()) {
+			if view.ID == sc.ID {
+				writeLiveJSON(w, view)
+			}
+		}
+	case http.MethodDelete:
+		id := r.URL.Query().Get("id")
+		writeScheduleResult(w, dashboardSchedules.remove(id))
+	default:
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// checkSchedule checks a new schedule: its name, that its cron expression
+// has times to run at, and that its profile exists.
+func checkSchedule(sc runSchedule) error {
+	if sc.Name == "" {
+		return errors.New("Missing schedule name")
+	}
+	if utf8.RuneCountInString(sc.Name) > maxScheduleName {
+		return fmt.Errorf("Schedule names are at most %d characters", maxScheduleName)
+	}
+	cron, err := parseCron(sc.Cron)
+	if err != nil {
+		return err
+	}
+	if _, err := cron.next(time.Now()); err != nil {
+		return err
+	}
+	if _, err := dashboardProfiles.get(sc.Profile); errors.Is(err, errNoProfile) {
+		return fmt.Errorf("No profile named %q", sc.Profile)
+	} else if err != nil {
+		return err
+	}
+	return nil
+}
+
+// dashboardScheduleEnableHandler pauses schedule id= with enabled=false,
+// or resumes it.
+func dashboardScheduleEnableHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	enabled := true
+	if value := r.URL.Query().Get("enabled"); value != "" {
+		var err error
+		if enabled, err = strconv.ParseBool(value); err != nil {
+			http.Error(w, "Invalid enabled", http.StatusBadRequest)
+			return
+		}
+	}
+	writeScheduleResult(w, dashboardSchedules.setEnabled(r.URL.Query().Get("id"), enabled))
+}
+
+// dashboardScheduleRunHandler starts schedule id= now, enabled or not.
+func dashboardScheduleRunHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	switch err := dashboardSchedules.start(r.URL.Query().Get("id"), time.Now()); {
+	case err == nil:
+		w.WriteHeader(http.StatusNoContent)
+	case errors.Is(err, errNoSchedule):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	default:
+		http.Error(w, err.Error(), launchStatus(err))
+	}
+}
+
+// dashboardSchedulePreviewHandler gives the next times of cron=, to check
+// an expression before scheduling it.
+func dashboardSchedulePreviewHandler(w http.ResponseWriter, r *http.Request) {
+	cron, err := parseCron(r.URL.Query().Get("cron"))
+	var next []time.Time
+	if err == nil {
+		next, err = cron.upcoming(time.Now(), scheduleUpcoming)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	writeLiveJSON(w, map[string]any{"next": next})
+}
+
+func writeScheduleResult(w http.ResponseWriter, err error) {
+	switch {
+	case err == nil:
+		w.WriteHeader(http.StatusNoContent)
+	case errors.Is(err, errNoSchedule):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	default:
+		http.Error(w, "Failed to save the schedules: "+err.Error(), http.StatusInternalServerError)
+	}
+}
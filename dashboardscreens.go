@@ -0,0 +1,410 @@
+package main
+
+import (
+	"bytes"
+	crand "crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	connect3270 "github.com/3270io/3270Connect/connect3270"
+)
+
+const (
+	// dashboardScreenInterval is how often the live screen reads a session
+	// again while the dashboard watches it.
+	dashboardScreenInterval = time.Second
+	// dashboardScreenGrace is how long a session may be gone before its
+	// stream ends: between iterations a vUser is briefly not running.
+	dashboardScreenGrace = 5 * time.Second
+	// screenKeyHeader carries the key of a run's screen server.
+	screenKeyHeader = "X-Screen-Key"
+)
+
+// errNoScreens means a run has no workflows whose screens can be read.
+var errNoScreens = errors.New("no workflows running")
+
+// liveSession is a workflow running in this process, as the dashboard's
+// live screen lists it. Session labels its emulator, as in the logs; it
+// changes between iterations, while VUser stays.
+type liveSession struct {
+	VUser     int       `json:"vUser"`
+	Session   string    `json:"session"`
+	Host      string    `json:"host"`
+	Port      int       `json:"port"`
+	Step      int       `json:"step"`
+	Steps     int       `json:"steps"`
+	StepType  string    `json:"stepType,omitempty"`
+	StartedAt time.Time `json:"startedAt"`
+}
+
+func (s workflowStatus) live() liveSession {
+	return liveSession{
+		VUser:     s.VUser,
+		Session:   s.ScriptPort,
+		Host:      s.Host,
+		Port:      s.Port,
+		Step:      s.CurrentStep,
+		Steps:     s.TotalSteps,
+		StepType:  s.StepType,
+		StartedAt: s.StartedAt,
+	}
+}
+
+// liveScreen is the screen of a running workflow, laid out as
+// JSONScreenGrab captures it, with the step the workflow is on.
+type liveScreen struct {
+	liveSession
+	*connect3270.Screen
+}
+
+// liveSessions lists the workflows running in this process.
+func liveSessions() []liveSession {
+	statuses := snapshotWorkflowStatuses()
+	sessions := make([]liveSession, 0, len(statuses))
+	for _, status := range statuses {
+		sessions = append(sessions, status.live())
+	}
+	return sessions
+}
+
+// liveTarget picks the workflow a live screen reads: by its session, or
+// else by its vUser, following the vUser from one iteration to the next.
+type liveTarget struct {
+	session string
+	vUser   int
+}
+
+func (t liveTarget) query() string {
+	if t.session != "" {
+		return "session=" + url.QueryEscape(t.session)
+	}
+	return "vuser=" + strconv.Itoa(t.vUser)
+}
+
+// readLiveScreen reads the screen of target, a workflow running in this
+// process.
+func readLiveScreen(target liveTarget) (*liveScreen, error) {
+	workflowStatusMu.Lock()
+	var status workflowStatus
+	found := workflowStatuses[target.session]
+	if target.session == "" {
+		for _, candidate := range workflowStatuses {
+			if candidate.VUser == target.vUser {
+				found = candidate
+				break
+			}
+		}
+	}
+	if found != nil {
+		status = *found
+	}
+	workflowStatusMu.Unlock()
+	if found == nil || status.emulator == nil {
+		return nil, errNoSession
+	}
+	screen, err := status.emulator.ReadScreen()
+	if err != nil {
+		// Still connecting, or between iterations.
+		return nil, fmt.Errorf("%w: %v", errSessionClosed, err)
+	}
+	return &liveScreen{liveSession: status.live(), Screen: screen}, nil
+}
+
+// dashboardScreenKeyPath is where the screen server of pid leaves the key
+// the dashboard reads its screens with.
+func dashboardScreenKeyPath(pid int) string {
+	return filepath.Join(dashboardMetricsDir(), fmt.Sprintf("screenkey_%d", pid))
+}
+
+var (
+	screenServerOnce sync.Once
+	screenServerBind atomic.Int64
+)
+
+// screenServerPort is the port of this run's screen server; 0 when it has
+// none.
+func screenServerPort() int {
+	return int(screenServerBind.Load())
+}
+
+// startScreenServer serves the screens of this run's workflows on a port
+// of localhost, for the dashboard of another process to mirror. Only the
+// dashboard has its key, which is readable by this user alone. The run
+// hosting the dashboard reads its own screens and needs no server.
+func startScreenServer() {
+	screenServerOnce.Do(func() {
+		if dashboardStarted {
+			return
+		}
+		var raw [24]byte
+		crand.Read(raw[:])
+		key := hex.EncodeToString(raw[:])
+		path := dashboardScreenKeyPath(os.Getpid())
+		err := os.MkdirAll(filepath.Dir(path), 0755)
+		if err == nil {
+			err = os.WriteFile(path, []byte(key), 0600)
+		}
+		if err != nil {
+			dashboardLog.Warn(fmt.Sprintf("The dashboard cannot show this run's screens: writing its key failed: %v", err))
+			return
+		}
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			dashboardLog.Warn(fmt.Sprintf("The dashboard cannot show this run's screens: %v", err))
+			return
+		}
+		screenServerBind.Store(int64(ln.Addr().(*net.TCPAddr).Port))
+		go http.Serve(ln, newScreenMux(key))
+	})
+}
+
+func newScreenMux(key string) *http.ServeMux {
+	mux := http.NewServeMux()
+	authorized := func(w http.ResponseWriter, r *http.Request) bool {
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get(screenKeyHeader)), []byte(key)) != 1 {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return false
+		}
+		return true
+	}
+	mux.HandleFunc("/sessions", func(w http.ResponseWriter, r *http.Request) {
+		if authorized(w, r) {
+			writeLiveJSON(w, liveSessions())
+		}
+	})
+	mux.HandleFunc("/screen", func(w http.ResponseWriter, r *http.Request) {
+		if !authorized(w, r) {
+			return
+		}
+		target, err := parseLiveTarget(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		screen, err := readLiveScreen(target)
+		if err != nil {
+			http.Error(w, err.Error(), screenErrorStatus(err))
+			return
+		}
+		writeLiveJSON(w, screen)
+	})
+	return mux
+}
+
+func screenErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, errNoScreens), errors.Is(err, errNoSession):
+		return http.StatusNotFound
+	case errors.Is(err, errSessionClosed):
+		return http.StatusConflict
+	default:
+		return http.StatusBadGateway
+	}
+}
+
+func writeLiveJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(v)
+}
+
+var screenClient = &http.Client{Timeout: 10 * time.Second}
+
+// fetchLiveSessions lists the workflows running in run pid.
+func fetchLiveSessions(pid int) ([]liveSession, error) {
+	if pid == os.Getpid() {
+		return liveSessions(), nil
+	}
+	var sessions []liveSession
+	if err := readRunScreens(pid, "/sessions", &sessions); err != nil {
+		return nil, err
+	}
+	return sessions, nil
+}
+
+// fetchLiveScreen reads the screen of target in run pid.
+func fetchLiveScreen(pid int, target liveTarget) (*liveScreen, error) {
+	if pid == os.Getpid() {
+		return readLiveScreen(target)
+	}
+	var screen liveScreen
+	if err := readRunScreens(pid, "/screen?"+target.query(), &screen); err != nil {
+		return nil, err
+	}
+	return &screen, nil
+}
+
+// readRunScreens asks the screen server of run pid for path.
+func readRunScreens(pid int, path string, v any) error {
+	m, ok := dashboardRuns.get(pid)
+	if !ok || !m.IsRunning || m.ScreenPort == 0 {
+		return fmt.Errorf("%w in PID %d", errNoScreens, pid)
+	}
+	key, err := os.ReadFile(dashboardScreenKeyPath(pid))
+	if err != nil {
+		return fmt.Errorf("cannot read the screen key of PID %d: %w", pid, err)
+	}
+	req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("http://127.0.0.1:%d%s", m.ScreenPort, path), nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set(screenKeyHeader, string(key))
+	resp, err := screenClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("cannot reach PID %d: %w", pid, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		message := strings.TrimSpace(string(body))
+		// The run's own errors, given back as they were.
+		switch resp.StatusCode {
+		case http.StatusNotFound:
+			return fmt.Errorf("%w%s", errNoSession, strings.TrimPrefix(message, errNoSession.Error()))
+		case http.StatusConflict:
+			return fmt.Errorf("%w%s", errSessionClosed, strings.TrimPrefix(message, errSessionClosed.Error()))
+		}
+		return fmt.Errorf("PID %d answered %s: %s", pid, resp.Status, message)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// parseLiveTarget reads the session, or the vUser, a live screen request
+// is for.
+func parseLiveTarget(r *http.Request) (liveTarget, error) {
+	target := liveTarget{session: r.URL.Query().Get("session")}
+	if target.session != "" {
+		return target, nil
+	}
+	vUser, err := strconv.Atoi(r.URL.Query().Get("vuser"))
+	if err != nil || vUser <= 0 {
+		return target, errors.New("missing session or vuser")
+	}
+	target.vUser = vUser
+	return target, nil
+}
+
+// screenQuery reads the run, and with wantTarget the workflow, that a live
+// screen request is for.
+func screenQuery(w http.ResponseWriter, r *http.Request, wantTarget bool) (int, liveTarget, bool) {
+	pid, err := strconv.Atoi(r.URL.Query().Get("pid"))
+	if err != nil || pid <= 0 {
+		http.Error(w, "Missing or invalid PID", http.StatusBadRequest)
+		return 0, liveTarget{}, false
+	}
+	if !wantTarget {
+		return pid, liveTarget{}, true
+	}
+	target, err := parseLiveTarget(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return 0, liveTarget{}, false
+	}
+	return pid, target, true
+}
+
+// dashboardSessionsHandler lists the workflows a run is running, for the
+// live screen to pick from.
+func dashboardSessionsHandler(w http.ResponseWriter, r *http.Request) {
+	pid, _, ok := screenQuery(w, r, false)
+	if !ok {
+		return
+	}
+	sessions, err := fetchLiveSessions(pid)
+	if err != nil {
+		http.Error(w, err.Error(), screenErrorStatus(err))
+		return
+	}
+	writeLiveJSON(w, sessions)
+}
+
+// dashboardScreenHandler reads the screen of a running workflow once.
+func dashboardScreenHandler(w http.ResponseWriter, r *http.Request) {
+	pid, target, ok := screenQuery(w, r, true)
+	if !ok {
+		return
+	}
+	screen, err := fetchLiveScreen(pid, target)
+	if err != nil {
+		http.Error(w, err.Error(), screenErrorStatus(err))
+		return
+	}
+	writeLiveJSON(w, screen)
+}
+
+// dashboardScreenStreamHandler streams the screen of a running workflow as
+// server-sent events: a screen event each time it changes, a waiting event
+// while the session is not connected, and an end event once the workflow
+// has been gone for dashboardScreenGrace, or the run has ended.
+func dashboardScreenStreamHandler(w http.ResponseWriter, r *http.Request) {
+	pid, target, ok := screenQuery(w, r, true)
+	if !ok {
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	ticker := time.NewTicker(dashboardScreenInterval)
+	defer ticker.Stop()
+	var last []byte
+	lastSent := time.Now()
+	send := func(event string, v any) {
+		data, _ := json.Marshal(v)
+		message := []byte(fmt.Sprintf("event: %s\ndata: %s\n\n", event, data))
+		if bytes.Equal(message, last) {
+			return
+		}
+		last, lastSent = message, time.Now()
+		w.Write(message)
+	}
+	var missingSince time.Time
+	for {
+		screen, err := fetchLiveScreen(pid, target)
+		if errors.Is(err, errNoSession) && missingSince.IsZero() {
+			missingSince = time.Now()
+		} else if !errors.Is(err, errNoSession) {
+			missingSince = time.Time{}
+		}
+		switch {
+		case err == nil:
+			send("screen", screen)
+		case errors.Is(err, errNoScreens), !missingSince.IsZero() && time.Since(missingSince) >= dashboardScreenGrace:
+			send("end", map[string]string{"message": err.Error()})
+			flusher.Flush()
+			return
+		default:
+			send("waiting", map[string]string{"message": err.Error()})
+		}
+		if time.Since(lastSent) >= apiEventKeepalive {
+			fmt.Fprint(w, ": keepalive\n\n")
+			lastSent = time.Now()
+		}
+		flusher.Flush()
+		select {
+		case <-r.Context().Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
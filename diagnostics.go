@@ -0,0 +1,92 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	"strconv"
+	"time"
+
+	connect3270 "github.com/3270io/3270Connect/connect3270"
+)
+
+var (
+	enablePprof bool
+	pprofPort   int
+)
+
+func init() {
+	flag.BoolVar(&enablePprof, "pprof", false, "Expose pprof and runtime diagnostics on localhost")
+	flag.IntVar(&pprofPort, "pprofPort", 6060, "Port for the pprof diagnostics server")
+}
+
+type runtimeDiagnostics struct {
+	Goroutines        int     `json:"goroutines"`
+	EmulatorProcesses int64   `json:"emulatorProcesses"`
+	ActiveWorkflows   int     `json:"activeWorkflows"`
+	HeapAllocBytes    uint64  `json:"heapAllocBytes"`
+	HeapObjects       uint64  `json:"heapObjects"`
+	NumGC             uint32  `json:"numGC"`
+	UptimeSeconds     float64 `json:"uptimeSeconds"`
+}
+
+func collectRuntimeDiagnostics() runtimeDiagnostics {
+	var memStats runtime.MemStats
+	runtime.ReadMemStats(&memStats)
+	uptime := 0.0
+	if !programStart.IsZero() {
+		uptime = time.Since(programStart).Seconds()
+	}
+	return runtimeDiagnostics{
+		Goroutines:        runtime.NumGoroutine(),
+		EmulatorProcesses: connect3270.RunningProcesses(),
+		ActiveWorkflows:   getActiveWorkflows(),
+		HeapAllocBytes:    memStats.HeapAlloc,
+		HeapObjects:       memStats.HeapObjects,
+		NumGC:             memStats.NumGC,
+		UptimeSeconds:     uptime,
+	}
+}
+
+func newDiagnosticsMux() *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	mux.HandleFunc("/debug/runtime", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		json.NewEncoder(w).Encode(collectRuntimeDiagnostics())
+	})
+	return mux
+}
+
+// listenDiagnostics listens on localhost only, as pprof hands out the
+// command line, secrets and all, and CPU profiles to whoever asks.
+func listenDiagnostics() (net.Listener, error) {
+	return net.Listen("tcp", net.JoinHostPort("localhost", strconv.Itoa(pprofPort)))
+}
+
+// startDiagnosticsServer serves pprof and runtime counters on localhost when
+// -pprof is set. It never blocks the caller.
+func startDiagnosticsServer() {
+	if !enablePprof {
+		return
+	}
+	listener, err := listenDiagnostics()
+	if err != nil {
+		pterm.Warning.Printf("Diagnostics server stopped: %v\n", err)
+		return
+	}
+	go func() {
+		pterm.Info.Printf("Diagnostics server on %s\n", pterm.FgBlue.Sprintf("http://%s/debug/pprof/", listener.Addr()))
+		if err := http.Serve(listener, newDiagnosticsMux()); err != nil {
+			pterm.Warning.Printf("Diagnostics server stopped: %v\n", err)
+		}
+	}()
+}
@@ -0,0 +1,297 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+var dryRun bool
+
+func init() {
+	flag.BoolVar(&dryRun, "dry-run", false, "Print the resolved steps and ramp-up schedule without connecting to the host")
+}
+
+// dryRunRampRows caps how many ramp-up batches the plan lists.
+const dryRunRampRows = 12
+
+// rampBatch is one ramp-up step of a concurrent run.
+type rampBatch struct {
+	At      float64
+	Started int
+	Total   int
+}
+
+// rampSchedule mirrors the scheduler: up to batchSize new vUsers every delay
+// seconds until all vUsers are busy.
+func rampSchedule(vUsers, batchSize int, delay float64) []rampBatch {
+	if batchSize <= 0 {
+		batchSize = 10
+	}
+	if delay <= 0 {
+		delay = 1
+	}
+	var batches []rampBatch
+	for total := 0; total < vUsers; {
+		started := batchSize
+		if vUsers-total < started {
+			started = vUsers - total
+		}
+		total += started
+		batches = append(batches, rampBatch{At: float64(len(batches)) * delay, Started: started, Total: total})
+	}
+	return batches
+}
+
+// redactStepText hides the token and secret placeholders while checking
+// that the secrets resolve. Injection values are already substituted.
+func redactStepText(text, token string) (string, error) {
+	if token != "" {
+		text = strings.ReplaceAll(text, "{{token}}", "[redacted token]")
+	}
+	var resolveErr error
+	text = secretPattern.ReplaceAllStringFunc(text, func(match string) string {
+		m := secretPattern.FindStringSubmatch(match)
+		provider, ok := secretProviders[m[1]]
+		if !ok {
+			return match
+		}
+		if _, err := provider(m[2]); err != nil && resolveErr == nil {
+			resolveErr = err
+		}
+		return fmt.Sprintf("[redacted %s:%s]", m[1], m[2])
+	})
+	return text, resolveErr
+}
+
+// describeStep renders one step on a single line.
+func describeStep(step Step, token string) (string, error) {
+	parts := []string{step.Type}
+	c := step.Coordinates
+	if c.Row > 0 || c.Column > 0 {
+		coords := fmt.Sprintf("row %d, col %d", c.Row, c.Column)
+		if c.Length > 0 {
+			coords += fmt.Sprintf(", len %d", c.Length)
+		}
+		parts = append(parts, "("+coords+")")
+	}
+	var err error
+	if step.Text != "" {
+		var text string
+		text, err = redactStepText(step.Text, token)
+		parts = append(parts, fmt.Sprintf("%q", text))
+	}
+	if step.Near != "" {
+		parts = append(parts, fmt.Sprintf("near %q", step.Near))
+	}
+	if step.Type == "FillFieldByIndex" {
+		parts = append(parts, fmt.Sprintf("field #%d", step.Index))
+	}
+	if step.Variable != "" {
+		parts = append(parts, "-> "+step.Variable)
+	}
+	if step.File != "" {
+		parts = append(parts, "file "+step.File)
+	}
+	if step.Timeout > 0 {
+		parts = append(parts, "timeout "+formatSeconds(step.Timeout))
+	}
+	if step.StepDelay.Min > 0 || step.StepDelay.Max > 0 {
+		parts = append(parts, "think "+formatDelayRange(step.StepDelay))
+	}
+	if cond := step.Condition; cond != nil {
+		if cond.ScreenContains != "" {
+			parts = append(parts, fmt.Sprintf("screen contains %q", cond.ScreenContains))
+		}
+		if v := cond.ValueEquals; v != nil {
+			parts = append(parts, fmt.Sprintf("value at row %d, col %d equals %q", v.Coordinates.Row, v.Coordinates.Column, v.Text))
+		}
+	}
+	return strings.Join(parts, " "), err
+}
+
+// writeStepPlan lists steps with their nested If branches. Secret problems
+// are collected in problems rather than stopping the listing.
+func writeStepPlan(sb *strings.Builder, steps []Step, token, indent string, problems *[]string) {
+	for i, step := range steps {
+		line, err := describeStep(step, token)
+		if err != nil {
+			*problems = append(*problems, fmt.Sprintf("%s step %d: %v", step.Type, i+1, err))
+		}
+		fmt.Fprintf(sb, "%s%d. %s\n", indent, i+1, line)
+		if len(step.Steps) > 0 {
+			fmt.Fprintf(sb, "%s   then:\n", indent)
+			writeStepPlan(sb, step.Steps, token, indent+"      ", problems)
+		}
+		if len(step.Else) > 0 {
+			fmt.Fprintf(sb, "%s   else:\n", indent)
+			writeStepPlan(sb, step.Else, token, indent+"      ", problems)
+		}
+	}
+}
+
+// dryRunPlan describes what a run of config would do. rows are the
+// injection entries; the first one is applied to the listed steps.
+func dryRunPlan(config *Configuration, rows []map[string]string, vUsers, runtimeSeconds int) (string, []string) {
+	var sb strings.Builder
+	var problems []string
+	if environmentName != "" {
+		fmt.Fprintf(&sb, "Environment: %s\n", environmentName)
+	}
+	port := strconv.Itoa(config.Port)
+	if config.portText != "" {
+		port = config.portText
+	}
+	target := config.Host + ":" + port
+	if config.TLS {
+		target += " (TLS)"
+	}
+	if config.Proxy != "" {
+		target += " via proxy " + displayProxy(config.Proxy)
+	}
+	if config.SSHTunnel != nil {
+		target += " via SSH " + config.SSHTunnel.User + "@" + config.SSHTunnel.address()
+	}
+	// The connection settings keep their placeholders until a run resolves
+	// them; check that they would.
+	target, err := redactStepText(target, "")
+	if err != nil {
+		problems = append(problems, "Target: "+err.Error())
+	}
+	for _, text := range []string{config.TLSCertFile, config.TLSKeyFile, config.TLSKeyPassword, config.TLSCAFile} {
+		if _, err := redactStepText(text, ""); err != nil {
+			problems = append(problems, "TLS settings: "+err.Error())
+		}
+	}
+	fmt.Fprintf(&sb, "Target: %s\n", target)
+	fmt.Fprintf(&sb, "Think time: every step %s, end of task %s\n", formatDelayRange(config.EveryStepDelay), formatDelayRange(config.EndOfTaskDelay))
+	if len(rows) > 0 {
+		fmt.Fprintf(&sb, "Injection: %d row(s); steps below use row 1\n", len(rows))
+		config = injectDynamicValues(config, rows[0])
+	}
+
+	sb.WriteString("\n")
+	if len(config.SessionSetup) > 0 {
+		sb.WriteString("SessionSetup (once per vUser):\n")
+		writeStepPlan(&sb, config.SessionSetup, config.Token, "  ", &problems)
+	}
+	if len(config.Workflows) > 0 {
+		totalWeight := 0.0
+		for _, w := range config.Workflows {
+			totalWeight += w.weight()
+		}
+		for _, w := range config.Workflows {
+			fmt.Fprintf(&sb, "Workflow %s (%.0f%% of iterations):\n", w.Name, w.weight()/totalWeight*100)
+			writeStepPlan(&sb, w.Steps, config.Token, "  ", &problems)
+			if len(w.OnError) > 0 {
+				sb.WriteString("  OnError:\n")
+				writeStepPlan(&sb, w.OnError, config.Token, "    ", &problems)
+			}
+		}
+	} else {
+		sb.WriteString("Steps:\n")
+		writeStepPlan(&sb, config.Steps, config.Token, "  ", &problems)
+	}
+	if len(config.OnError) > 0 {
+		sb.WriteString("OnError:\n")
+		writeStepPlan(&sb, config.OnError, config.Token, "  ", &problems)
+	}
+	if len(config.SessionTeardown) > 0 {
+		sb.WriteString("SessionTeardown (once per vUser, at the end of the run):\n")
+		writeStepPlan(&sb, config.SessionTeardown, config.Token, "  ", &problems)
+	}
+
+	sb.WriteString("\n")
+	if searchMode() {
+		fmt.Fprintf(&sb, "Search: %d more vUser(s) every %s, up to %d, until a step breaks the SLA\n", searchStepFlag, formatSeconds(searchStepTime), max(vUsers, 1))
+		if err := validateSearch(config); err != nil {
+			problems = append(problems, err.Error())
+		}
+		return sb.String(), problems
+	}
+	if iterationMode() {
+		fmt.Fprintf(&sb, "Iterations: %d workflow run(s) in all\n", iterationTotal(config, len(rows), max(vUsers, 1)))
+	} else if vUsers <= 1 && runtimeSeconds <= 0 {
+		sb.WriteString("Schedule: a single workflow run\n")
+		return sb.String(), problems
+	}
+	if vUsers < 1 {
+		vUsers = 1
+	}
+	span := ""
+	if runtimeSeconds > 0 {
+		span = fmt.Sprintf(" for %ds", runtimeSeconds)
+	}
+	if rate := arrivalRate(config); rate > 0 {
+		fmt.Fprintf(&sb, "Schedule: %g workflow(s) per second%s, at most %d in flight\n", rate, span, vUsers)
+		if runtimeSeconds <= 0 && !iterationMode() {
+			problems = append(problems, "an arrival rate needs -runtime")
+		}
+		return sb.String(), problems
+	}
+	fmt.Fprintf(&sb, "Schedule: %d vUser(s)%s, ramping up %d every %s\n", vUsers, span, config.RampUpBatchSize, formatSeconds(config.RampUpDelay))
+	batches := rampSchedule(vUsers, config.RampUpBatchSize, config.RampUpDelay)
+	for i, b := range batches {
+		if len(batches) > dryRunRampRows && i == dryRunRampRows-2 {
+			fmt.Fprintf(&sb, "  ... %d more batch(es)\n", len(batches)-dryRunRampRows+1)
+		}
+		if len(batches) > dryRunRampRows && i >= dryRunRampRows-2 && i < len(batches)-1 {
+			continue
+		}
+		fmt.Fprintf(&sb, "  t+%-8s +%-4d -> %d active\n", formatSeconds(b.At), b.Started, b.Total)
+	}
+	if last := batches[len(batches)-1]; runtimeSeconds > 0 && last.At >= float64(runtimeSeconds) {
+		problems = append(problems, fmt.Sprintf("ramp-up needs %s but the run lasts only %ds", formatSeconds(last.At), runtimeSeconds))
+	}
+	return sb.String(), problems
+}
+
+// runDryRun loads the workflow as a real run would and prints the plan.
+func runDryRun(configPath, injectionPath string) error {
+	config, err := readConfiguration(configPath)
+	if err != nil {
+		return err
+	}
+	if rsaToken != "" {
+		config.Token = rsaToken
+	}
+	if config.InputFilePath != "" {
+		if config.Steps, err = loadInputFile(config.InputFilePath); err != nil {
+			return err
+		}
+	}
+	var rows []map[string]string
+	if injectionPath != "" {
+		if _, statErr := os.Stat(injectionPath); statErr == nil {
+			if rows, err = loadInjectionData(injectionPath); err != nil {
+				return fmt.Errorf("loading injection data: %w", err)
+			}
+		} else {
+			pterm.Warning.Printf("Injection file %s not found. Planning without injection.\n", injectionPath)
+		}
+	}
+
+	plan, problems := dryRunPlan(config, rows, concurrent, runtimeDuration)
+	pterm.Info.Printf("Dry run of %s - nothing will touch the host.\n", configPath)
+	pterm.Println(plan)
+	for _, phase := range []struct{ name, flagValue, configured string }{
+		{"Setup", setupWorkflowFlag, config.Setup},
+		{"Teardown", teardownWorkflowFlag, config.Teardown},
+	} {
+		path := phaseWorkflowPath(phase.flagValue, phase.configured, filepath.Dir(configPath))
+		if path == "" {
+			continue
+		}
+		pterm.Info.Printf("%s workflow: %s (runs once)\n", phase.name, path)
+		if _, err := readConfiguration(path); err != nil {
+			problems = append(problems, fmt.Sprintf("%s workflow %s: %v", phase.name, path, err))
+		}
+	}
+	for _, p := range problems {
+		pterm.Warning.Println(p)
+	}
+	return nil
+}
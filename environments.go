@@ -0,0 +1,104 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+var environmentName string
+
+func init() {
+	flag.StringVar(&environmentName, "env", "", "Name of the Environments profile in the workflow file to run against (e.g. dev, qa, prod)")
+}
+
+// EnvironmentProfile holds the connection settings of one named environment.
+// Set fields replace the top-level values of the workflow.
+type EnvironmentProfile struct {
+	Host   string `json:"Host,omitempty"`
+	Port   int    `json:"Port,omitempty"`
+	Token  string `json:"Token,omitempty"`
+	TLS    *bool  `json:"TLS,omitempty"`
+	LUName string `json:"LUName,omitempty"`
+	Proxy  string `json:"Proxy,omitempty"`
+
+	// portText is a Port given as a secret placeholder.
+	portText string
+}
+
+// UnmarshalJSON decodes Port the way the top-level Port is, leaving any
+// secret placeholder for resolveSecrets.
+func (p *EnvironmentProfile) UnmarshalJSON(data []byte) error {
+	type plainProfile EnvironmentProfile
+	aux := struct {
+		*plainProfile
+		Port json.RawMessage
+	}{plainProfile: (*plainProfile)(p)}
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+	return decodePort(aux.Port, &p.Port, &p.portText)
+}
+
+// MarshalJSON writes a Port still to be resolved as its placeholder.
+func (p EnvironmentProfile) MarshalJSON() ([]byte, error) {
+	type plainProfile EnvironmentProfile
+	if p.portText == "" {
+		return json.Marshal(plainProfile(p))
+	}
+	return json.Marshal(struct {
+		plainProfile
+		Port string
+	}{plainProfile(p), p.portText})
+}
+
+// environmentNames lists the profiles of config in a stable order.
+func environmentNames(config *Configuration) []string {
+	names := make([]string, 0, len(config.Environments))
+	for name := range config.Environments {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// applyEnvironment overlays the named profile onto config. An empty name
+// keeps the top-level settings.
+func applyEnvironment(config *Configuration, name string) error {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		if config.Host == "" && len(config.Environments) > 0 {
+			return fmt.Errorf("no top-level Host - pick an environment with -env (%s)", strings.Join(environmentNames(config), ", "))
+		}
+		return nil
+	}
+	profile, ok := config.Environments[name]
+	if !ok {
+		if len(config.Environments) == 0 {
+			return fmt.Errorf("-env %s given but the workflow defines no Environments", name)
+		}
+		return fmt.Errorf("unknown environment %q - choose one of %s", name, strings.Join(environmentNames(config), ", "))
+	}
+	if profile.Host != "" {
+		config.Host = profile.Host
+	}
+	if profile.Port != 0 || profile.portText != "" {
+		config.Port, config.portText = profile.Port, profile.portText
+	}
+	if profile.Token != "" {
+		config.Token = profile.Token
+	}
+	if profile.LUName != "" {
+		config.LUName = profile.LUName
+		config.LUPool = nil
+	}
+	if profile.TLS != nil {
+		config.TLS = *profile.TLS
+	}
+	if profile.Proxy != "" {
+		config.Proxy = profile.Proxy
+	}
+	return nil
+}
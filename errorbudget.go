@@ -0,0 +1,173 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultErrorBudgetWindow  = 60
+	defaultErrorBudgetMinimum = 10
+
+	// abortExitCode is the exit status of a run stopped by its error budget.
+	abortExitCode = 4
+)
+
+var (
+	abortErrorRateFlag float64
+	abortWindowFlag    float64
+)
+
+func init() {
+	flag.Float64Var(&abortErrorRateFlag, "abortErrorRate", -1, "Stop starting workflows and drain the run (exit code 4) when more than this percentage of workflows fail within -abortWindow")
+	flag.Float64Var(&abortWindowFlag, "abortWindow", 0, "Seconds of recent workflows -abortErrorRate looks at (default 60)")
+}
+
+// ErrorBudgetConfig stops a concurrent run whose recent workflows mostly
+// fail, so a broken host or test is not hammered for the full runtime.
+type ErrorBudgetConfig struct {
+	// MaxErrorRate is the highest share of failed workflows, in percent,
+	// over the window. 0 stops the run at the first failure.
+	MaxErrorRate float64 `json:"MaxErrorRate"`
+	// Window is how many seconds of recent workflows count (default 60).
+	Window float64 `json:"Window,omitempty"`
+	// MinWorkflows is how many workflows must finish within the window
+	// before the rate is judged (default 10).
+	MinWorkflows int64 `json:"MinWorkflows,omitempty"`
+}
+
+func validateErrorBudget(config *Configuration) error {
+	b := config.ErrorBudget
+	if b == nil {
+		return nil
+	}
+	if b.MaxErrorRate < 0 || b.MaxErrorRate > 100 {
+		return fmt.Errorf("ErrorBudget.MaxErrorRate must be a percentage between 0 and 100")
+	}
+	if b.Window < 0 {
+		return fmt.Errorf("ErrorBudget.Window cannot be negative")
+	}
+	if b.MinWorkflows < 0 {
+		return fmt.Errorf("ErrorBudget.MinWorkflows cannot be negative")
+	}
+	return nil
+}
+
+// outcomeSample is the run's totals at one moment.
+type outcomeSample struct {
+	at        time.Time
+	completed int64
+	failed    int64
+}
+
+// errorBudget judges the error rate of the workflows that finished within
+// a sliding window, from regular samples of the run's totals.
+type errorBudget struct {
+	limit   float64
+	window  time.Duration
+	minimum int64
+	samples []outcomeSample
+}
+
+// newErrorBudget returns the budget of config with the -abortErrorRate and
+// -abortWindow flags applied, or nil when the run has none.
+func newErrorBudget(config *Configuration) *errorBudget {
+	var settings ErrorBudgetConfig
+	if config.ErrorBudget != nil {
+		settings = *config.ErrorBudget
+	} else if abortErrorRateFlag < 0 {
+		return nil
+	}
+	if abortErrorRateFlag >= 0 {
+		settings.MaxErrorRate = abortErrorRateFlag
+	}
+	if abortWindowFlag > 0 {
+		settings.Window = abortWindowFlag
+	}
+	if settings.Window <= 0 {
+		settings.Window = defaultErrorBudgetWindow
+	}
+	if settings.MinWorkflows <= 0 {
+		settings.MinWorkflows = defaultErrorBudgetMinimum
+	}
+	return &errorBudget{
+		limit:   settings.MaxErrorRate,
+		window:  time.Duration(settings.Window * float64(time.Second)),
+		minimum: settings.MinWorkflows,
+	}
+}
+
+// check adds a sample of the run's totals and returns the error rate over
+// the window, how many workflows it covers and whether the budget is broken.
+func (b *errorBudget) check(now time.Time, completed, failed int64) (float64, int64, bool) {
+	b.samples = append(b.samples, outcomeSample{at: now, completed: completed, failed: failed})
+	// Keep the newest sample from before the window as the baseline.
+	cutoff := now.Add(-b.window)
+	for len(b.samples) > 1 && !b.samples[1].at.After(cutoff) {
+		b.samples = b.samples[1:]
+	}
+	base := b.samples[0]
+	failedInWindow := failed - base.failed
+	finished := completed - base.completed + failedInWindow
+	if finished == 0 || finished < b.minimum {
+		return 0, finished, false
+	}
+	rate := float64(failedInWindow) / float64(finished) * 100
+	return rate, finished, rate > b.limit
+}
+
+// runAbortReason says why the error budget stopped the run; nil while it
+// has not.
+var runAbortReason atomic.Pointer[string]
+
+func runAborted() bool {
+	return runAbortReason.Load() != nil
+}
+
+func abortRun(reason string) {
+	if runAbortReason.CompareAndSwap(nil, &reason) {
+		schedulerLog.Error(fmt.Sprintf("Run aborted: %s. Stopping new workflows and draining the run.", reason))
+		notifyBreach("error budget broken", nil, []string{reason})
+	}
+}
+
+// watchErrorBudget samples the run's totals every second until stop is
+// closed, and aborts the run when they break config's error budget.
+func watchErrorBudget(config *Configuration, stop <-chan struct{}) {
+	b := newErrorBudget(config)
+	if b == nil {
+		return
+	}
+	b.check(time.Now(), atomic.LoadInt64(&totalWorkflowsCompleted), atomic.LoadInt64(&totalWorkflowsFailed))
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case now := <-ticker.C:
+			rate, finished, broken := b.check(now, atomic.LoadInt64(&totalWorkflowsCompleted), atomic.LoadInt64(&totalWorkflowsFailed))
+			if broken {
+				abortRun(fmt.Sprintf("%.1f%% of the %d workflows that finished in the last %s failed (error budget %g%%)",
+					rate, finished, formatSeconds(b.window.Seconds()), b.limit))
+				return
+			}
+		}
+	}
+}
+
+func abortReasonText() string {
+	if reason := runAbortReason.Load(); reason != nil {
+		return *reason
+	}
+	return ""
+}
+
+// printAbortSummary notes under the run summary that the run was aborted.
+func printAbortSummary() {
+	if reason := abortReasonText(); reason != "" {
+		pterm.Error.Printf("Run aborted: %s\n", reason)
+	}
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	connect3270 "github.com/3270io/3270Connect/connect3270"
+)
+
+// failureArtifactsDir holds the screen captures written for failed steps.
+var failureArtifactsDir = filepath.Join("logs", "failures")
+
+// captureFailureScreen reads the screen a step failed on.
+func captureFailureScreen(e *connect3270.Emulator) ([]string, error) {
+	rows, err := e.ScreenText()
+	if err != nil {
+		return nil, fmt.Errorf("screen capture failed: %w", err)
+	}
+	return rows, nil
+}
+
+// writeFailureScreen writes the screen rows next to the failing step's
+// details and returns the artifact path.
+func writeFailureScreen(rows []string, label string, stepIndex int, step Step, stepErr error) (string, error) {
+	if err := os.MkdirAll(failureArtifactsDir, 0755); err != nil {
+		return "", err
+	}
+	now := time.Now()
+	name := fmt.Sprintf("failure_%d_%s_%s_step%d.txt", os.Getpid(), sanitizeArtifactName(label), now.Format("20060102T150405.000"), stepIndex)
+	path := filepath.Join(failureArtifactsDir, name)
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "Time: %s\n", now.Format(time.RFC3339Nano))
+	fmt.Fprintf(&b, "Workflow: %s\n", label)
+	fmt.Fprintf(&b, "Step: %d (%s)\n", stepIndex, step.Type)
+	if step.Coordinates.Row > 0 || step.Coordinates.Column > 0 {
+		fmt.Fprintf(&b, "Coordinates: row %d, column %d, length %d\n", step.Coordinates.Row, step.Coordinates.Column, step.Coordinates.Length)
+	}
+	fmt.Fprintf(&b, "Error: %v\n\n", stepErr)
+	for _, row := range rows {
+		b.WriteString(row)
+		b.WriteByte('\n')
+	}
+	if err := os.WriteFile(path, []byte(b.String()), 0644); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+func sanitizeArtifactName(name string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '_':
+			return r
+		default:
+			return '_'
+		}
+	}, name)
+}
+
+// maxFailuresPerCategory is how many of the latest failed workflows of each
+// category a run reports, for the dashboard to drill into. Older ones are
+// only counted.
+const maxFailuresPerCategory = 10
+
+// failureRecord is a failed workflow: where it failed, the screen it
+// failed on and the injection row it used.
+type failureRecord struct {
+	At       time.Time `json:"at"`
+	Category string    `json:"category"`
+	VUser    int       `json:"vUser"`
+	Workflow string    `json:"workflow,omitempty"`
+	// Step names the failing step as the step timings do, and StepIndex
+	// numbers it from 1 in the steps run. Definition is the step, without
+	// the text it types or the injection values filled into it.
+	Step       string `json:"step,omitempty"`
+	StepIndex  int    `json:"stepIndex,omitempty"`
+	Definition *Step  `json:"definition,omitempty"`
+	Error      string `json:"error"`
+	// Screen is the screen at the failure, and ScreenFile where it was
+	// written with -verbose.
+	Screen     []string `json:"screen,omitempty"`
+	ScreenFile string   `json:"screenFile,omitempty"`
+	// DataRow numbers the injection row from 1, and Data lists its
+	// placeholders, with the values redacted.
+	DataRow int               `json:"dataRow,omitempty"`
+	Data    map[string]string `json:"data,omitempty"`
+}
+
+// redactedValue stands in for the text a failure record hides.
+const redactedValue = "[redacted]"
+
+// typingSteps are the step types whose Text is typed into the host, such
+// as passwords.
+var typingSteps = map[string]bool{"FillString": true, "FillBlock": true, "FillFieldByIndex": true, "FillFieldNear": true}
+
+// redactFailureStep copies step for a failure record, which viewers of the
+// dashboard and its API see. The text typed into fields is hidden, and
+// elsewhere the values of the injection row are put back as their
+// placeholders, including in nested If steps.
+func redactFailureStep(step Step, data map[string]string) *Step {
+	placeholders := make([]string, 0, len(data))
+	for placeholder, value := range data {
+		if value != "" {
+			placeholders = append(placeholders, placeholder)
+		}
+	}
+	// The longest values first, so that a value within another one is not
+	// replaced instead.
+	sort.Slice(placeholders, func(i, j int) bool {
+		a, b := data[placeholders[i]], data[placeholders[j]]
+		if len(a) != len(b) {
+			return len(a) > len(b)
+		}
+		return placeholders[i] < placeholders[j]
+	})
+	pairs := make([]string, 0, 2*len(placeholders))
+	for _, placeholder := range placeholders {
+		pairs = append(pairs, data[placeholder], placeholder)
+	}
+	redacted := redactStepTree(step, strings.NewReplacer(pairs...))
+	return &redacted
+}
+
+func redactStepTree(step Step, values *strings.Replacer) Step {
+	if typingSteps[step.Type] && step.Text != "" {
+		step.Text = redactedValue
+	} else {
+		step.Text = values.Replace(step.Text)
+	}
+	step.Near = values.Replace(step.Near)
+	if cond := step.Condition; cond != nil {
+		redacted := *cond
+		redacted.ScreenContains = values.Replace(cond.ScreenContains)
+		if cond.ValueEquals != nil {
+			valueEquals := *cond.ValueEquals
+			valueEquals.Text = values.Replace(valueEquals.Text)
+			redacted.ValueEquals = &valueEquals
+		}
+		step.Condition = &redacted
+	}
+	step.Steps = redactStepList(step.Steps, values)
+	step.Else = redactStepList(step.Else, values)
+	return step
+}
+
+func redactStepList(steps []Step, values *strings.Replacer) []Step {
+	if steps == nil {
+		return nil
+	}
+	out := make([]Step, len(steps))
+	for i, step := range steps {
+		out[i] = redactStepTree(step, values)
+	}
+	return out
+}
+
+// redactFailureData keeps the placeholders of an injection row for a
+// failure record, but not their values.
+func redactFailureData(data map[string]string) map[string]string {
+	if len(data) == 0 {
+		return nil
+	}
+	redacted := make(map[string]string, len(data))
+	for placeholder := range data {
+		redacted[placeholder] = redactedValue
+	}
+	return redacted
+}
+
+// failureCategory sorts a failed workflow by what went wrong: its status,
+// else the type of the step that failed.
+func failureCategory(status string, step *Step, err error) string {
+	switch {
+	case status == "connect_failed":
+		return "Connection failed"
+	case status == "timeout":
+		return "Workflow timed out"
+	case err != nil && strings.Contains(err.Error(), "reconnect failed"):
+		return "Session lost"
+	case step != nil:
+		return step.Type + " failed"
+	}
+	return "Failed"
+}
+
+var (
+	failuresMu sync.Mutex
+	// failureCounts counts every failed workflow by category, and
+	// recentFailures keeps the latest of each.
+	failureCounts  = map[string]int64{}
+	recentFailures = map[string][]failureRecord{}
+)
+
+func recordFailure(f failureRecord) {
+	failuresMu.Lock()
+	defer failuresMu.Unlock()
+	failureCounts[f.Category]++
+	kept := append(recentFailures[f.Category], f)
+	if len(kept) > maxFailuresPerCategory {
+		kept = append([]failureRecord(nil), kept[len(kept)-maxFailuresPerCategory:]...)
+	}
+	recentFailures[f.Category] = kept
+}
+
+// failureSnapshot returns copies of the counts and of the latest failures,
+// oldest first.
+func failureSnapshot() (map[string]int64, []failureRecord) {
+	failuresMu.Lock()
+	defer failuresMu.Unlock()
+	if len(failureCounts) == 0 {
+		return nil, nil
+	}
+	counts := make(map[string]int64, len(failureCounts))
+	var records []failureRecord
+	for category, count := range failureCounts {
+		counts[category] = count
+		records = append(records, recentFailures[category]...)
+	}
+	sort.SliceStable(records, func(i, j int) bool { return records[i].At.Before(records[j].At) })
+	return counts, records
+}
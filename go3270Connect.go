@@ -3,21 +3,26 @@ package main
 import (
 	"bufio"
 	"bytes"
+	"context"
 	crand "crypto/rand"
+	"crypto/tls"
 	"embed"
 	"encoding/binary"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"html/template"
 	"io"
 	"io/fs"
+	"log/slog"
 	"math/rand"
 	"net"
 	"net/http"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"regexp"
 	"runtime"
 	"sort"
 	"strconv"
@@ -33,8 +38,7 @@ import (
 	"github.com/charmbracelet/lipgloss"
 
 	"github.com/gin-gonic/gin"
-	"github.com/shirou/gopsutil/cpu"
-	"github.com/shirou/gopsutil/mem"
+	"github.com/gin-gonic/gin/binding"
 )
 
 const version = "1.8.3"
@@ -44,9 +48,9 @@ const (
 	memHistoryLimit              = 120
 	workflowDurationHistoryLimit = 500
 	inMemoryLogLimit             = 500
-	dashboardCleanupInterval     = time.Minute
 	liveStatsHistoryLimit        = 12
 	defaultGracePeriod           = 30 * time.Second
+	defaultWaitForTextTimeout    = 30 * time.Second
 )
 
 var errorList []error
@@ -64,16 +68,59 @@ type DelayRange struct {
 type Configuration struct {
 	Host            string
 	Port            int
-	OutputFilePath  string `json:"OutputFilePath"`
-	WaitForField    bool   `json:"WaitForField,omitempty"`
+	TLS             bool                     `json:"TLS,omitempty"`
+	TLSCertFile     string                   `json:"TLSCertFile,omitempty"`
+	TLSKeyFile      string                   `json:"TLSKeyFile,omitempty"`
+	TLSKeyPassword  string                   `json:"TLSKeyPassword,omitempty"`
+	TLSCAFile       string                   `json:"TLSCAFile,omitempty"`
+	TLSSkipVerify   bool                     `json:"TLSSkipVerify,omitempty"`
+	LUName          string                   `json:"LUName,omitempty"`
+	LUPool          []string                 `json:"LUPool,omitempty"`
+	CodePage        string                   `json:"CodePage,omitempty"`
+	Proxy           string                   `json:"Proxy,omitempty"`
+	SSHTunnel       *SSHTunnelConfig         `json:"SSHTunnel,omitempty"`
+	Printer         *PrinterConfig           `json:"Printer,omitempty"`
+	Sessions        map[string]SessionConfig `json:"Sessions,omitempty"`
+	Reconnect       *ReconnectConfig         `json:"Reconnect,omitempty"`
+	Thresholds      *ThresholdConfig         `json:"Thresholds,omitempty"`
+	ErrorBudget     *ErrorBudgetConfig       `json:"ErrorBudget,omitempty"`
+	OutputFilePath  string                   `json:"OutputFilePath"`
+	WaitForField    bool                     `json:"WaitForField,omitempty"`
 	Steps           []Step
-	EveryStepDelay  DelayRange `json:"EveryStepDelay,omitempty"`
-	EndOfTaskDelay  DelayRange `json:"EndOfTaskDelay,omitempty"`
-	Token           string     `json:"Token,omitempty"`
-	InputFilePath   string     `json:"InputFilePath"`
-	RampUpBatchSize int        `json:"RampUpBatchSize"`
-	RampUpDelay     float64    `json:"RampUpDelay"`
-	LegacyDelay     float64    `json:"Delay,omitempty"`
+	EveryStepDelay  DelayRange                    `json:"EveryStepDelay,omitempty"`
+	EndOfTaskDelay  DelayRange                    `json:"EndOfTaskDelay,omitempty"`
+	Token           string                        `json:"Token,omitempty"`
+	InputFilePath   string                        `json:"InputFilePath"`
+	RampUpBatchSize int                           `json:"RampUpBatchSize"`
+	RampUpDelay     float64                       `json:"RampUpDelay"`
+	ArrivalRate     float64                       `json:"ArrivalRate,omitempty"`
+	LegacyDelay     float64                       `json:"Delay,omitempty"`
+	OnError         []Step                        `json:"OnError,omitempty"`
+	SessionSetup    []Step                        `json:"SessionSetup,omitempty"`
+	SessionTeardown []Step                        `json:"SessionTeardown,omitempty"`
+	Setup           string                        `json:"Setup,omitempty"`
+	Teardown        string                        `json:"Teardown,omitempty"`
+	Workflows       []SuiteWorkflow               `json:"Workflows,omitempty"`
+	Environments    map[string]EnvironmentProfile `json:"Environments,omitempty"`
+	CallbackURL     string                        `json:"CallbackURL,omitempty"`
+
+	// workflowName is the suite workflow this configuration runs.
+	workflowName string
+	// phase is Setup or Teardown for the workflows run once around the
+	// load, which are kept out of its totals.
+	phase string
+	// vUser numbers the vUser running this configuration from 1, and
+	// dataRow the injection row filled into it from 1, for -results.
+	// dataValues holds the values of that row.
+	vUser      int
+	dataRow    int
+	dataValues map[string]string
+	// portText is a Port given as a secret placeholder, until
+	// resolveSecrets fills in Port.
+	portText string
+	// submitted is set for workflows sent through the API, gRPC or the
+	// dashboard, which may only use the secrets -submittedSecrets allows.
+	submitted bool
 }
 
 // Step represents an individual action to be taken on the terminal.
@@ -81,8 +128,43 @@ type Step struct {
 	Type        string
 	Coordinates connect3270.Coordinates
 	Text        string
-	Delay       float64    `json:"Delay,omitempty"`
-	StepDelay   DelayRange `json:"StepDelay,omitempty"`
+	Delay       float64              `json:"Delay,omitempty"`
+	StepDelay   DelayRange           `json:"StepDelay,omitempty"`
+	Timeout     float64              `json:"Timeout,omitempty"`
+	Variable    string               `json:"Variable,omitempty"`
+	File        string               `json:"File,omitempty"`
+	Attributes  *FieldAttributeCheck `json:"Attributes,omitempty"`
+	Index       int                  `json:"Index,omitempty"`
+	Near        string               `json:"Near,omitempty"`
+	Condition   *StepCondition       `json:"Condition,omitempty"`
+	Steps       []Step               `json:"Steps,omitempty"`
+	Else        []Step               `json:"Else,omitempty"`
+	Session     string               `json:"Session,omitempty"`
+	Checkpoint  bool                 `json:"Checkpoint,omitempty"`
+	Transaction string               `json:"Transaction,omitempty"`
+}
+
+// StepCondition is the predicate of an If step. When several predicates are
+// set, all of them must hold.
+type StepCondition struct {
+	ScreenContains string          `json:"ScreenContains,omitempty"`
+	ValueEquals    *ValueCondition `json:"ValueEquals,omitempty"`
+}
+
+// FieldAttributeCheck lists the attributes CheckFieldAttributes expects.
+// Attributes left unset are not checked.
+type FieldAttributeCheck struct {
+	Protected   *bool `json:"Protected,omitempty"`
+	Hidden      *bool `json:"Hidden,omitempty"`
+	Intensified *bool `json:"Intensified,omitempty"`
+	Numeric     *bool `json:"Numeric,omitempty"`
+}
+
+// ValueCondition compares the trimmed text at Coordinates with Text, like
+// CheckValue does.
+type ValueCondition struct {
+	Coordinates connect3270.Coordinates
+	Text        string
 }
 
 var configPrinter *MessagePrinter
@@ -140,7 +222,7 @@ func workflowMetadataText(configPath string, config *Configuration) string {
 		outputPath = "(auto temp file)"
 	}
 
-	return strings.Join([]string{
+	lines := []string{
 		//fmt.Sprintf("Config file: %s", label),
 		fmt.Sprintf("CLI args: %s", cliArgsString()),
 		fmt.Sprintf("Host: %s", config.Host),
@@ -150,7 +232,11 @@ func workflowMetadataText(configPath string, config *Configuration) string {
 		fmt.Sprintf("RampUpBatchSize: %d", config.RampUpBatchSize),
 		fmt.Sprintf("RampUpDelay: %s", formatSeconds(config.RampUpDelay)),
 		fmt.Sprintf("EndOfTaskDelay: %s", formatDelayRange(config.EndOfTaskDelay)),
-	}, "\n")
+	}
+	if rate := arrivalRate(config); rate > 0 {
+		lines = append(lines, fmt.Sprintf("ArrivalRate: %g workflows/s", rate))
+	}
+	return strings.Join(lines, "\n")
 }
 
 func printWorkflowMetadata(configPath string, config *Configuration) {
@@ -184,12 +270,30 @@ func printWorkflowMetadata(configPath string, config *Configuration) {
 		pterm.Println()
 		return
 	}
+	if environmentName != "" {
+		configPrinter.Printf("Environment: %s", pterm.LightGreen(environmentName))
+	}
 	configPrinter.Printf("Host: %s", pterm.LightGreen(config.Host))
 	configPrinter.Printf("Port: %s", pterm.LightGreen(fmt.Sprintf("%d", config.Port)))
+	if config.TLS {
+		configPrinter.Printf("TLS: %s", pterm.LightGreen("on"))
+	}
+	if config.Proxy != "" {
+		configPrinter.Printf("Proxy: %s", pterm.LightGreen(displayProxy(config.Proxy)))
+	}
+	if config.SSHTunnel != nil {
+		configPrinter.Printf("SSH tunnel: %s", pterm.LightGreen(config.SSHTunnel.User+"@"+config.SSHTunnel.address()))
+	}
+	if config.Printer != nil {
+		configPrinter.Printf("Printer: %s", pterm.LightGreen(describePrinter(config.Printer)))
+	}
 	configPrinter.Printf("EveryStepDelay: %s", pterm.LightGreen(formatDelayRange(config.EveryStepDelay)))
 	configPrinter.Printf("OutputFilePath: %s", pterm.LightGreen(outputPath))
 	configPrinter.Printf("RampUpBatchSize: %s", pterm.LightGreen(fmt.Sprintf("%d", config.RampUpBatchSize)))
 	configPrinter.Printf("RampUpDelay: %s", pterm.LightGreen(formatSeconds(config.RampUpDelay)))
+	if rate := arrivalRate(config); rate > 0 {
+		configPrinter.Printf("ArrivalRate: %s", pterm.LightGreen(fmt.Sprintf("%g workflows/s", rate)))
+	}
 	configPrinter.Printf("EndOfTaskDelay: %s", pterm.LightGreen(formatDelayRange(config.EndOfTaskDelay)))
 	pterm.Println()
 }
@@ -209,6 +313,116 @@ func resolveTokenPlaceholder(original, token string) string {
 	return strings.ReplaceAll(original, "{{token}}", token)
 }
 
+var workflowVarPattern = regexp.MustCompile(`\{\{var:([A-Za-z0-9_.-]+)\}\}`)
+
+// workflowState carries the values shared by the steps of one workflow run:
+// the output file, the RSA token and variables captured by ExtractValue.
+type workflowState struct {
+	tmpFileName    string
+	token          string
+	vars           map[string]string
+	generated      map[string]string
+	started        time.Time
+	everyStepDelay DelayRange
+	ctx            context.Context // ends on workflowTimeout or shutdown
+	printer        *connect3270.Printer
+	sessions       map[string]*connect3270.Emulator // named sessions opened so far
+	sessionConfigs map[string]SessionConfig
+	submitted      bool // only the secrets -submittedSecrets allows resolve
+}
+
+func newWorkflowState(tmpFileName, token string) *workflowState {
+	return &workflowState{tmpFileName: tmpFileName, token: token, vars: make(map[string]string), started: time.Now(), ctx: connect3270.ShutdownContext()}
+}
+
+// pause sleeps for d, returning early with the context's error when the
+// workflow is cancelled.
+func (s *workflowState) pause(d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-s.ctx.Done():
+		return workflowContextErr(s.ctx)
+	case <-timer.C:
+		return nil
+	}
+}
+
+// workflowContextErr is ctx.Err(), except that a shutdown reports
+// connect3270.ErrShutdown.
+func workflowContextErr(ctx context.Context) error {
+	if errors.Is(context.Cause(ctx), connect3270.ErrShutdown) {
+		return connect3270.ErrShutdown
+	}
+	return ctx.Err()
+}
+
+// resolve substitutes {{token}}, secret ({{env:NAME}}, {{file:path}}),
+// generator ({{uuid}}, {{randInt:a:b}}, {{date:layout}}, {{timestamp}}) and
+// {{var:name}} placeholders in text.
+func (s *workflowState) resolve(text string) (string, error) {
+	text = resolveTokenPlaceholder(text, s.token)
+	text, err := resolveSecretsFor(text, s.submitted)
+	if err != nil {
+		return "", err
+	}
+	text, err = s.resolveGenerators(text)
+	if err != nil {
+		return "", err
+	}
+	if !strings.Contains(text, "{{var:") {
+		return text, nil
+	}
+	var missing string
+	resolved := workflowVarPattern.ReplaceAllStringFunc(text, func(match string) string {
+		name := workflowVarPattern.FindStringSubmatch(match)[1]
+		value, ok := s.vars[name]
+		if !ok {
+			if missing == "" {
+				missing = name
+			}
+			return match
+		}
+		return value
+	})
+	if missing != "" {
+		return "", fmt.Errorf("variable %q used before ExtractValue set it", missing)
+	}
+	return resolved, nil
+}
+
+var placeholderPattern = regexp.MustCompile(`\{\{[^{}]+\}\}`)
+
+// resolvePattern is resolve for a regular expression: the values of the
+// placeholders are escaped, so they match literally.
+func (s *workflowState) resolvePattern(text string) (string, error) {
+	var resolveErr error
+	pattern := placeholderPattern.ReplaceAllStringFunc(text, func(match string) string {
+		value, err := s.resolve(match)
+		if err != nil {
+			if resolveErr == nil {
+				resolveErr = err
+			}
+			return match
+		}
+		return regexp.QuoteMeta(value)
+	})
+	if resolveErr != nil {
+		return "", resolveErr
+	}
+	return pattern, nil
+}
+
+// referencedVars lists the {{var:name}} names used in text.
+func referencedVars(text string) []string {
+	matches := workflowVarPattern.FindAllStringSubmatch(text, -1)
+	names := make([]string, 0, len(matches))
+	for _, m := range matches {
+		names = append(names, m[1])
+	}
+	return names
+}
+
 var (
 	configFile       string
 	injectionConfig  string
@@ -249,11 +463,14 @@ type workflowStatus struct {
 	TotalSteps  int
 	StepType    string
 	StartedAt   time.Time
+	VUser       int
+	// emulator is the workflow's main session, for the dashboard's live
+	// screen.
+	emulator *connect3270.Emulator
 }
 
 var timingsMutex sync.Mutex
 var workflowDurations []float64
-var workflowDurationSum float64
 var (
 	delayRNGMu           sync.Mutex // protects delayRNG for concurrent workflow runs
 	delayRNGOnce         sync.Once
@@ -265,8 +482,6 @@ func init() {
 	delayRNG = newDelayRNG()
 }
 
-var workflowDurationCount int64
-
 var metricsMutex sync.Mutex
 var cpuHistory []float64
 var memHistory []float64
@@ -276,7 +491,10 @@ var totalMemUsage float64
 var totalMemSamples int64
 var lastCPUUsage float64
 var lastMemUsage float64
-var lastCleanupRun time.Time
+
+const metricsReportInterval = 2 * time.Second
+
+var metricsReporterOnce sync.Once
 
 var showVersion = flag.Bool("version", false, "Show the application version")
 var startDashboard = flag.Bool("dashboard", false, "Start the dashboard and open the webpage")
@@ -299,9 +517,13 @@ type LogEntry struct {
 	Parameters string    `json:"parameters"`
 	Log        string    `json:"log"`
 	Timestamp  time.Time `json:"timestamp"`
+	// Level and Subsystem are set for entries of the subsystem loggers.
+	Level     string         `json:"level,omitempty"`
+	Subsystem string         `json:"subsystem,omitempty"`
+	Attrs     map[string]any `json:"attrs,omitempty"`
 }
 
-var inMemoryLogs []LogEntry
+var inMemoryLogs = newLogRing(inMemoryLogLimit)
 var logMutex sync.Mutex
 
 //go:embed templates/dashboard.gohtml
@@ -322,17 +544,6 @@ func appendLimitedFloat(slice *[]float64, value float64, limit int) {
 	}
 }
 
-func appendLimitedLog(slice *[]LogEntry, entry LogEntry, limit int) {
-	*slice = append(*slice, entry)
-	if limit <= 0 {
-		return
-	}
-	if len(*slice) > limit {
-		excess := len(*slice) - limit
-		*slice = (*slice)[excess:]
-	}
-}
-
 func appendLimitedString(slice *[]string, value string, limit int) {
 	*slice = append(*slice, value)
 	if limit <= 0 {
@@ -343,12 +554,15 @@ func appendLimitedString(slice *[]string, value string, limit int) {
 	}
 }
 
-func registerWorkflowStatus(scriptPort string, config *Configuration, totalSteps int) {
+func registerWorkflowStatus(scriptPort string, e *connect3270.Emulator, config *Configuration, totalSteps int) {
 	if scriptPort == "" || config == nil {
 		return
 	}
+	startScreenServer()
 	workflowStatusMu.Lock()
 	workflowStatuses[scriptPort] = &workflowStatus{
+		emulator:    e,
+		VUser:       max(config.vUser, 1),
 		ScriptPort:  scriptPort,
 		Host:        config.Host,
 		Port:        config.Port,
@@ -439,20 +653,25 @@ func logWorkflowStatuses(statuses []workflowStatus) {
 }
 
 func recordWorkflowDuration(duration float64) {
-	timingsMutex.Lock()
+	recordShardedDuration(duration)
+	if largeScale {
+		// The history is only a dashboard sample; skip it rather than queue on the lock.
+		if !timingsMutex.TryLock() {
+			return
+		}
+	} else {
+		timingsMutex.Lock()
+	}
 	appendLimitedFloat(&workflowDurations, duration, workflowDurationHistoryLimit)
-	workflowDurationSum += duration
-	workflowDurationCount++
 	timingsMutex.Unlock()
 }
 
 func getAverageWorkflowDuration() float64 {
-	timingsMutex.Lock()
-	defer timingsMutex.Unlock()
-	if workflowDurationCount == 0 {
+	sum, count := shardedDurationTotals()
+	if count == 0 {
 		return 0
 	}
-	return workflowDurationSum / float64(workflowDurationCount)
+	return sum / float64(count)
 }
 
 func getAverageCPUUsage() float64 {
@@ -485,21 +704,6 @@ func getLastMemoryUsage() float64 {
 	return lastMemUsage
 }
 
-func maybeCleanupDashboardArtifacts() {
-	metricsMutex.Lock()
-	shouldSkip := time.Since(lastCleanupRun) < dashboardCleanupInterval
-	if !shouldSkip {
-		lastCleanupRun = time.Now()
-	}
-	metricsMutex.Unlock()
-	if shouldSkip {
-		return
-	}
-	dashboardDir := dashboardMetricsDir()
-	// Trigger cleanup by reading and evaluating metrics files.
-	readDashboardMetrics(dashboardDir)
-}
-
 func init() {
 	flag.StringVar(&configFile, "config", "workflow.json", "Path to the configuration file")
 	flag.StringVar(&injectionConfig, "injectionConfig", "", "Path to the injection configuration file")
@@ -514,7 +718,7 @@ func init() {
 	flag.IntVar(&runtimeDuration, "runtime", 0, "Duration to run workflows in seconds")
 	flag.StringVar(&runApp, "runApp", "", "Select which sample 3270 app to run ('1' or '2')")
 	flag.IntVar(&runAppPort, "runApp-port", 3270, "Port for the sample 3270 app")
-	flag.IntVar(&startPort, "startPort", 5000, "Starting port for workflow connections")
+	flag.IntVar(&startPort, "startPort", 5000, "First session number used to label workflow connections (emulators pick their own script ports)")
 	flag.IntVar(&workflowTimeout, "workflowTimeout", 0, "Hard timeout per workflow in seconds (0 to disable)")
 	flag.BoolVar(&showConnectionErrors, "showConnectionErrors", false, "Treat connection failures as errors and report them")
 	flag.IntVar(&dashboardPort, "dashboardPort", 9200, "Port for the dashboard server")
@@ -544,33 +748,9 @@ func init() {
 	}
 }
 
+// storeLog keeps message in the log file and the dashboard console.
 func storeLog(message string) {
-	logMutex.Lock()
-	defer logMutex.Unlock()
-	pid := os.Getpid()
-	args := os.Args[1:]
-	parameters := strings.Join(args, " ")
-
-	logEntry := LogEntry{
-		PID:        strconv.Itoa(pid),
-		Parameters: parameters,
-		Log:        message,
-		Timestamp:  time.Now(),
-	}
-	appendLimitedLog(&inMemoryLogs, logEntry, inMemoryLogLimit)
-
-	logFilePath := filepath.Join("logs", fmt.Sprintf("logs_%d.json", pid))
-	file, err := os.OpenFile(logFilePath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
-	if err != nil {
-		pterm.Error.Println("Log file opening failed - send help:", err)
-		return
-	}
-	defer file.Close()
-
-	encoder := json.NewEncoder(file)
-	if err := encoder.Encode(logEntry); err != nil {
-		pterm.Error.Println("Log encoding broke - computers hate me:", err)
-	}
+	fileLog.Info(message)
 }
 
 // getExecutablePath resolves the most up-to-date 3270Connect binary.
@@ -620,20 +800,25 @@ func fileExists(path string) bool {
 
 func loadConfiguration(filePath string) *Configuration {
 	//spinner, _ := pterm.DefaultSpinner.Start("Loading config - hold onto your hats!")
-	if connect3270.Verbose {
-		pterm.Info.Printf("Loading configuration from %s\n", filePath)
-	}
-	configFile, err := os.Open(filePath)
+	schedulerLog.Debug("Loading configuration from " + filePath)
+	data, err := os.ReadFile(filePath)
 	if err != nil {
 		pterm.Error.Printf("Error opening config file at %s: %v", filePath, err)
 		os.Exit(1)
 	}
-	defer configFile.Close()
+	if data, err = applyOverlays(data, configOverlays); err != nil {
+		pterm.Error.Printf("Error applying overlays: %v", err)
+		os.Exit(1)
+	}
+	if data, err = applyConfigOverrides(data, configOverrides); err != nil {
+		pterm.Error.Printf("Error applying overrides: %v", err)
+		os.Exit(1)
+	}
 	config := Configuration{
 		WaitForField: true, // default to waiting after Connect unless disabled in config
+		submitted:    submittedWorkflow,
 	}
-	decoder := json.NewDecoder(configFile)
-	err = decoder.Decode(&config)
+	err = json.Unmarshal(data, &config)
 	if err != nil {
 		pterm.Error.Printf("Error decoding config JSON: %v", err)
 	}
@@ -643,173 +828,58 @@ func loadConfiguration(filePath string) *Configuration {
 	if config.RampUpDelay <= 0 {
 		config.RampUpDelay = 1.0
 	}
+	if err := applyEnvironment(&config, environmentName); err != nil {
+		pterm.Error.Printf("Error selecting environment: %v", err)
+		os.Exit(1)
+	}
+	if config.Steps, err = expandIncludes(config.Steps, filepath.Dir(filePath)); err != nil {
+		pterm.Error.Printf("Error expanding Include steps: %v", err)
+		os.Exit(1)
+	}
+	if config.OnError, err = expandIncludes(config.OnError, filepath.Dir(filePath)); err != nil {
+		pterm.Error.Printf("Error expanding Include steps in OnError: %v", err)
+		os.Exit(1)
+	}
+	if err = expandSessionIncludes(&config, filepath.Dir(filePath)); err != nil {
+		pterm.Error.Printf("Error expanding Include steps in %v", err)
+		os.Exit(1)
+	}
+	if config.Workflows, err = expandSuiteWorkflows(config.Workflows, filepath.Dir(filePath)); err != nil {
+		pterm.Error.Printf("Error loading suite workflows: %v", err)
+		os.Exit(1)
+	}
 	err = validateConfiguration(&config)
 	if err != nil {
 		pterm.Error.Printf("Invalid configuration: %v", err)
 	}
+	if err := config.resolveSecrets(); err != nil {
+		pterm.Error.Printf("Error resolving secrets: %v\n", err)
+		os.Exit(1)
+	}
 	//spinner.Success("Config loaded - we’re golden!")
 	return &config
 }
 
 func loadInputFile(filePath string) ([]Step, error) {
 	spinner, _ := pterm.DefaultSpinner.Start("Loading input file - fingers crossed!")
-	if connect3270.Verbose {
-		pterm.Info.Printf("Loading input file: %s\n", filePath)
-	}
+	schedulerLog.Debug("Loading input file: " + filePath)
 	data, err := os.ReadFile(filePath)
 	if err != nil {
 		spinner.Fail("Input file read failed - disk gremlins:", err)
 		return nil, fmt.Errorf("error reading input file: %v", err)
 	}
-	if connect3270.Verbose {
-		pterm.Success.Printf("Successfully read input file: %d bytes\n", len(data))
-	}
+	schedulerLog.Debug(fmt.Sprintf("Successfully read input file: %d bytes", len(data)))
 	var steps []Step
 	steps = append(steps, Step{Type: "Connect"})
-	if connect3270.Verbose {
-		pterm.Info.Println("Added initial Connect step")
-	}
-	lines := strings.Split(string(data), "\n")
-	for idx, line := range lines {
-		line = strings.TrimSpace(line)
-		if line == "" {
-			continue
-		}
-		if connect3270.Verbose {
-			pterm.Info.Printf("Processing line %d: %s", idx+1, line)
-		}
-		if strings.HasPrefix(line, "yield ps.sendKeys") {
-			key := strings.TrimPrefix(line, "yield ps.sendKeys(")
-			key = strings.TrimSuffix(key, ");")
-			key = strings.Trim(key, "'")
-			stepType := ""
-			switch key {
-			case "ControlKey.TAB":
-				stepType = "PressTab"
-			case "ControlKey.ENTER":
-				stepType = "PressEnter"
-			case "ControlKey.F1":
-				stepType = "PressPF1"
-			case "ControlKey.F2":
-				stepType = "PressPF2"
-			case "ControlKey.F3":
-				stepType = "PressPF3"
-			case "ControlKey.F4":
-				stepType = "PressPF4"
-			case "ControlKey.F5":
-				stepType = "PressPF5"
-			case "ControlKey.F6":
-				stepType = "PressPF6"
-			case "ControlKey.F7":
-				stepType = "PressPF7"
-			case "ControlKey.F8":
-				stepType = "PressPF8"
-			case "ControlKey.F9":
-				stepType = "PressPF9"
-			case "ControlKey.F10":
-				stepType = "PressPF10"
-			case "ControlKey.F11":
-				stepType = "PressPF11"
-			case "ControlKey.F12":
-				stepType = "PressPF12"
-			case "ControlKey.F13":
-				stepType = "PressPF13"
-			case "ControlKey.F14":
-				stepType = "PressPF14"
-			case "ControlKey.F15":
-				stepType = "PressPF15"
-			case "ControlKey.F16":
-				stepType = "PressPF16"
-			case "ControlKey.F17":
-				stepType = "PressPF17"
-			case "ControlKey.F18":
-				stepType = "PressPF18"
-			case "ControlKey.F19":
-				stepType = "PressPF19"
-			case "ControlKey.F20":
-				stepType = "PressPF20"
-			case "ControlKey.F21":
-				stepType = "PressPF21"
-			case "ControlKey.F22":
-				stepType = "PressPF22"
-			case "ControlKey.F23":
-				stepType = "PressPF23"
-			case "ControlKey.F24":
-				stepType = "PressPF24"
-			default:
-				stepType = "FillString"
-			}
-			step := Step{Type: stepType, Text: key}
-			steps = append(steps, step)
-			if connect3270.Verbose {
-				pterm.Info.Printf("Added step: %s with text: %s\n", stepType, key)
-			}
-		} else if strings.HasPrefix(line, "yield wait.forText") {
-			parts := strings.Split(line, ",")
-			if len(parts) >= 2 {
-				text := strings.TrimPrefix(parts[0], "yield wait.forText('")
-				text = strings.TrimSuffix(text, "'")
-				position := strings.TrimPrefix(parts[1], "new Position(")
-				position = strings.TrimSuffix(position, ");")
-				posParts := strings.Split(position, ",")
-				if len(posParts) == 2 {
-					row, errRow := strconv.Atoi(strings.TrimSpace(posParts[0]))
-					column, errCol := strconv.Atoi(strings.TrimSpace(posParts[1]))
-					if errRow != nil || errCol != nil {
-						if connect3270.Verbose {
-							pterm.Warning.Printf("Error parsing position in line %d - numbers hate me\n", idx+1)
-						}
-						continue
-					}
-					step := Step{
-						Type: "CheckValue",
-						Coordinates: connect3270.Coordinates{
-							Row:    row,
-							Column: column,
-							Length: len(text),
-						},
-						Text: text,
-					}
-					steps = append(steps, step)
-					if connect3270.Verbose {
-						pterm.Info.Printf("Added CheckValue step: text '%s' at (%d,%d), length %d\n", text, row, column, len(text))
-					}
-				}
-			}
-		} else if strings.HasPrefix(line, "// Fill in the first name at row") || strings.HasPrefix(line, "// Fill in the last name at row") {
-			parts := strings.Split(line, " ")
-			if len(parts) >= 8 {
-				row, errRow := strconv.Atoi(parts[6])
-				column, errCol := strconv.Atoi(parts[9])
-				if errRow != nil || errCol != nil {
-					if connect3270.Verbose {
-						pterm.Warning.Printf("Error parsing coords in line %d - math is hard\n", idx+1)
-					}
-					continue
-				}
-				if idx+1 < len(lines) {
-					nextLine := strings.TrimSpace(lines[idx+1])
-					if strings.HasPrefix(nextLine, "yield ps.sendKeys") {
-						key := strings.TrimPrefix(nextLine, "yield ps.sendKeys(")
-						key = strings.TrimSuffix(key, ");")
-						key = strings.Trim(key, "'")
-						step := Step{
-							Type: "FillString",
-							Coordinates: connect3270.Coordinates{
-								Row:    row,
-								Column: column,
-							},
-							Text: key,
-						}
-						steps = append(steps, step)
-						if connect3270.Verbose {
-							pterm.Info.Printf("Added FillString step: text '%s' at (%d,%d)\n", key, row, column)
-						}
-					}
-				}
-			}
-		}
+	schedulerLog.Debug("Added initial Connect step")
+	importer := detectInputImporter(filePath, data)
+	schedulerLog.Debug("Importing input file as " + importer.name)
+	imported, err := importer.parse(data)
+	if err != nil {
+		spinner.Fail("Input file import failed - macro speaks a dialect we don't:", err)
+		return nil, fmt.Errorf("importing %s input file: %v", importer.name, err)
 	}
+	steps = append(steps, imported...)
 	steps = append(steps, Step{Type: "Disconnect"})
 	if connect3270.Verbose {
 		pterm.Info.Println("Added final Disconnect step")
@@ -827,12 +897,11 @@ func loadInputFile(filePath string) ([]Step, error) {
 	return steps, nil
 }
 
-func runWorkflow(scriptPort int, config *Configuration) error {
-	e := connect3270.NewEmulator(config.Host, config.Port, strconv.Itoa(scriptPort))
-	return runWorkflowWithEmulator(e, config, time.Time{})
-}
-
-func runWorkflowWithEmulator(e *connect3270.Emulator, config *Configuration, overallDeadline time.Time) error {
+// runWorkflowWithEmulator runs one iteration of config on e. When config has
+// SessionSetup, session keeps e logged on between the iterations of a vUser:
+// the setup steps run only while it is not, and the session is dropped after
+// an iteration that fails. session may be nil.
+func runWorkflowWithEmulator(e *connect3270.Emulator, config *Configuration, overallDeadline time.Time, session *vUserSession) error {
 	// Check if shutdown was requested before starting workflow execution
 	if connect3270.ShutdownRequested() {
 		return nil // Graceful stop: do not count as started or failed
@@ -843,15 +912,10 @@ func runWorkflowWithEmulator(e *connect3270.Emulator, config *Configuration, ove
 	}
 	scriptPortLabel := e.ScriptPort
 	startTime := time.Now()
-	var workflowDeadline time.Time
-	if workflowTimeout > 0 {
-		workflowDeadline = startTime.Add(time.Duration(workflowTimeout) * time.Second)
-	}
-	atomic.AddInt64(&totalWorkflowsStarted, 1)
-	if connect3270.Verbose {
-		pterm.Info.Printf("Starting workflow for scriptPort %s\n", scriptPortLabel)
+	if config.phase == "" {
+		atomic.AddInt64(&totalWorkflowsStarted, 1)
 	}
-	storeLog(fmt.Sprintf("Starting workflow for scriptPort %s", scriptPortLabel))
+	logOrStore(schedulerLog, connect3270.Verbose, slog.LevelInfo, "Starting workflow for scriptPort "+scriptPortLabel)
 	mutex.Lock()
 	activeWorkflows++
 	mutex.Unlock()
@@ -862,13 +926,74 @@ func runWorkflowWithEmulator(e *connect3270.Emulator, config *Configuration, ove
 	}()
 	e.Host = config.Host
 	e.Port = config.Port
+	e.TLS = config.TLS
+	e.LUName = config.LUName
+	e.CodePage = config.CodePage
+	e.Proxy = config.Proxy
+	if e.LUName == "" && len(config.LUPool) > 0 {
+		// Outside a concurrent run there is one session; it takes the first LU.
+		pool, _ := expandLUPool(config.LUPool)
+		e.LUName = luNameForWorker(pool, 0)
+	}
+	publishWorkflowStarted(e, config)
+	e.TLSOptions = connect3270.TLSOptions{
+		CertFile:    config.TLSCertFile,
+		KeyFile:     config.TLSKeyFile,
+		KeyPassword: config.TLSKeyPassword,
+		CAFile:      config.TLSCAFile,
+		SkipVerify:  config.TLSSkipVerify,
+	}
+	persistent := session.persists(config)
+	if persistent && session.isOpen() && sessionDropped(e) {
+		storeLog(fmt.Sprintf("Session on scriptPort %s was lost between iterations - setting it up again", scriptPortLabel))
+		session.drop(e)
+	}
+	if config.SSHTunnel != nil {
+		tunnel := session.heldTunnel()
+		if tunnel == nil {
+			var err error
+			if tunnel, err = acquireSSHTunnel(config); err != nil {
+				return handleError(err, fmt.Sprintf("SSH tunnel failed - jump host said no: %v", err))
+			}
+			if persistent {
+				// Held for as long as the session stays logged on.
+				session.tunnel = tunnel
+			} else {
+				defer tunnel.release()
+			}
+		}
+		e.Host = "127.0.0.1"
+		e.Port = tunnel.localPort()
+		// The certificate still names the real host, not the tunnel.
+		e.TLSOptions.ServerName = config.Host
+	}
+
+	// Always start from a clean session to avoid reusing stale emulator state
+	// between pooled runs, unless the vUser stays logged on.
+	if !session.isOpen() {
+		_ = e.Disconnect()
+	}
+	defer func() {
+		if !session.isOpen() {
+			session.drop(e)
+		}
+	}()
+
+	// workflowTimeout and shutdown both end the workflow by cancelling ctx,
+	// which interrupts whatever wait or script command is in flight.
+	ctx, cancel := context.WithCancel(connect3270.ShutdownContext())
+	if workflowTimeout > 0 {
+		ctx, cancel = context.WithDeadline(connect3270.ShutdownContext(), startTime.Add(time.Duration(workflowTimeout)*time.Second))
+	}
+	defer cancel()
+	e.SetContext(ctx)
+	// Runs before the deferred Disconnect, so that still gets to quit cleanly.
+	defer e.SetContext(nil)
 
-	// Always start from a clean session to avoid reusing stale emulator state between pooled runs.
-	_ = e.Disconnect()
-	defer e.Disconnect()
 	tmpFileName := config.OutputFilePath
 	cleanupTempFile := false
-	if tmpFileName == "" {
+	inMemoryOutput := useInMemoryOutput(config)
+	if tmpFileName == "" && !inMemoryOutput {
 		tmpFile, err := os.CreateTemp("", "workflowOutput_")
 		if err != nil {
 			return handleError(err, fmt.Sprintf("Temp file creation failed - disk’s playing hide and seek: %v", err))
@@ -882,8 +1007,10 @@ func runWorkflowWithEmulator(e *connect3270.Emulator, config *Configuration, ove
 			os.Remove(tmpFileName)
 		}
 	}()
-	if err := e.InitializeOutput(tmpFileName, runAPI); err != nil {
-		return handleError(err, fmt.Sprintf("Output init failed - setup's cursed: %v", err))
+	if !inMemoryOutput {
+		if err := e.InitializeOutput(tmpFileName, runAPI); err != nil {
+			return handleError(err, fmt.Sprintf("Output init failed - setup's cursed: %v", err))
+		}
 	}
 	workflowFailed := false
 	connectFailed := false
@@ -897,20 +1024,48 @@ func runWorkflowWithEmulator(e *connect3270.Emulator, config *Configuration, ove
 	} else {
 		steps = config.Steps
 	}
+	// setupSteps is how many SessionSetup steps lead steps this iteration.
+	setupSteps := 0
+	setupDone := true
+	if persistent && !session.isOpen() {
+		setupDone = false
+		setupSteps = len(config.SessionSetup)
+		steps = append(append([]Step{}, config.SessionSetup...), steps...)
+	}
+	state := newWorkflowState(tmpFileName, config.Token)
+	state.submitted = config.submitted
+	if persistent {
+		if setupSteps > 0 || session.vars == nil {
+			session.vars = make(map[string]string)
+		}
+		// Values captured at logon stay available to later iterations.
+		state.vars = session.vars
+	}
+	state.everyStepDelay = config.EveryStepDelay
+	state.ctx = ctx
+	state.sessionConfigs = config.Sessions
+	defer state.closeSessions()
 	workflowKey := scriptPortLabel
-	registerWorkflowStatus(workflowKey, config, len(steps))
+	registerWorkflowStatus(workflowKey, e, config, len(steps))
 	defer clearWorkflowStatus(workflowKey)
 
-	for idx, step := range steps {
+	reconnects := 0
+	transactions := newTransactionTracker(steps)
+	junit := newJUnitTracker(config, steps, setupSteps)
+	// failure is why the workflow failed, for the JUnit report and the
+	// results file, and failureIsError says it could not run rather than
+	// found a wrong screen. failingStep names the step that failed, and
+	// detail holds what the dashboard shows of it.
+	var failure error
+	failureIsError := false
+	failingStep := ""
+	var detail failureRecord
+	for idx := 0; idx < len(steps); idx++ {
+		step := steps[idx]
 		if workflowFailed {
 			break
 		}
-		if !workflowDeadline.IsZero() && time.Now().After(workflowDeadline) {
-			workflowFailed = true
-			addError(fmt.Errorf("workflow timed out after %ds", time.Since(startTime)/time.Second))
-			break
-		}
-		if connect3270.ShutdownRequested() {
+		if ctx.Err() != nil {
 			break
 		}
 		updateWorkflowStatus(workflowKey, idx+1, step.Type)
@@ -919,40 +1074,114 @@ func runWorkflowWithEmulator(e *connect3270.Emulator, config *Configuration, ove
 			if err != nil {
 				addError(err)
 			}
-			if delay > 0 {
-				time.Sleep(delay)
+			if delay > 0 && state.pause(delay) != nil {
+				break
 			}
 		}
-		err := executeStep(e, step, tmpFileName, config.Token)
+		stepStart := time.Now()
+		err := executeStep(e, step, state)
 		if err == nil && step.Type == "Connect" && config.WaitForField {
-			waitErr := e.WaitForField(time.Second)
-			if waitErr != nil {
+			se, _ := state.emulatorFor(e, step)
+			if waitErr := se.WaitForField(time.Second); waitErr != nil {
 				err = waitErr
 			}
 		}
+		if err == nil && step.Type == "Connect" && step.Session == "" && config.Printer != nil && state.printer == nil {
+			// Associating needs the terminal's LU, so the printer follows the first Connect.
+			if state.printer, err = startWorkflowPrinter(e, config.Printer, state); err == nil {
+				defer state.printer.Close()
+			}
+		}
+		if err == nil {
+			elapsed := time.Since(stepStart)
+			if idx < setupSteps {
+				recordStepTiming(sessionStepTimingKey("SessionSetup", idx, step), elapsed)
+				setupDone = idx == setupSteps-1
+			} else {
+				recordStepTiming(stepTimingKey(config, idx-setupSteps, step), elapsed)
+			}
+			transactions.stepPassed(idx, step, elapsed)
+			junit.stepDone(idx, elapsed, nil, false)
+		}
+		if err != nil && step.Type != "Connect" && config.Reconnect != nil && reconnects < config.Reconnect.attempts() {
+			if se, lookupErr := state.emulatorFor(e, step); lookupErr == nil && ctx.Err() == nil && sessionDropped(se) {
+				reconnects++
+				reconnectErr := reconnectSession(e, step, config, state, scriptPortLabel)
+				if reconnectErr == nil {
+					idx = resumeIndex(steps, idx) - 1
+					continue
+				}
+				err = fmt.Errorf("%w (reconnect failed: %v)", err, reconnectErr)
+			}
+		}
 		if err != nil {
-			if err.Error() == "shutdown requested" {
-				break // Graceful stop: do not count as failure
+			if ctx.Err() != nil {
+				break // Shutdown or timeout, handled below
 			}
 			if step.Type == "Connect" {
 				connectFailed = true
+				failure, failureIsError = err, true
+				failingStep = resultStepName(config, idx, setupSteps, step)
+				detail.StepIndex, detail.Definition = idx+1, &steps[idx]
+				junit.stepDone(idx, time.Since(stepStart), err, true)
+				publishStepFailed(e, config, idx+1, failingStep, err)
 				if showConnectionErrors {
 					addError(err)
 				}
 				break // Stop executing further steps when connection could not be established
 			} else {
 				workflowFailed = true
+				failed, lookupErr := state.emulatorFor(e, step)
+				if lookupErr != nil {
+					failed = e
+				}
+				screen, captureErr := captureFailureScreen(failed)
+				if captureErr == nil && verboseFailures {
+					var artifact string
+					if artifact, captureErr = writeFailureScreen(screen, scriptPortLabel, idx+1, step, err); captureErr == nil {
+						detail.ScreenFile = artifact
+						err = fmt.Errorf("%w (screen: %s)", err, artifact)
+					}
+				}
+				if captureErr != nil {
+					storeLog(fmt.Sprintf("Failure screen capture skipped for scriptPort %s: %v", scriptPortLabel, captureErr))
+				}
+				detail.Screen = screen
+				failure = err
+				failingStep = resultStepName(config, idx, setupSteps, step)
+				detail.StepIndex, detail.Definition = idx+1, &steps[idx]
+				junit.stepDone(idx, time.Since(stepStart), err, false)
+				publishStepFailed(e, config, idx+1, failingStep, err)
 				addError(err)
 				if verboseFailures {
 					msg := fmt.Sprintf("Workflow failure on scriptPort %s at step %d (%s): %v", scriptPortLabel, idx+1, step.Type, err)
-					storeLog(msg)
-					pterm.Error.Println(msg)
+					schedulerLog.Error(msg)
 				}
 			}
 		}
 	}
 
-	if !workflowFailed && !connectFailed && !connect3270.ShutdownRequested() {
+	timedOut := errors.Is(workflowContextErr(ctx), context.DeadlineExceeded)
+	if timedOut {
+		workflowFailed = true
+		failure, failureIsError = fmt.Errorf("workflow timed out after %ds", time.Since(startTime)/time.Second), true
+		addError(failure)
+	}
+	// Recovery and the end-of-task delay are not bound by workflowTimeout.
+	e.SetContext(nil)
+	state.setSessionContext(nil)
+	state.ctx = connect3270.ShutdownContext()
+	if timedOut && detail.Screen == nil {
+		detail.Screen, _ = captureFailureScreen(e)
+	}
+
+	if workflowFailed && !connect3270.ShutdownRequested() {
+		runOnErrorSteps(e, config.OnError, state, scriptPortLabel)
+	}
+
+	// In an open-model run the arrival rate sets the pace, and holding the
+	// vUser would only drop arrivals.
+	if !workflowFailed && !connectFailed && !connect3270.ShutdownRequested() && arrivalRate(config) == 0 {
 		delay, err := randomDuration(config.EndOfTaskDelay, true)
 		if err != nil {
 			addError(err)
@@ -960,31 +1189,71 @@ func runWorkflowWithEmulator(e *connect3270.Emulator, config *Configuration, ove
 		if delay > 0 {
 			delay = capDelayForDeadline(delay, overallDeadline)
 			if delay > 0 {
-				time.Sleep(delay)
+				_ = state.pause(delay)
 			}
 		}
 	}
 
+	if persistent {
+		// A failed iteration leaves the screen somewhere unknown, so the
+		// next one starts from a fresh logon.
+		session.open = setupDone && !workflowFailed && !connectFailed
+		session.last = config
+	}
+
+	if config.phase != "" {
+		switch {
+		case connectFailed:
+			return fmt.Errorf("could not connect to %s:%d", config.Host, config.Port)
+		case workflowFailed:
+			return fmt.Errorf("a step failed - see the errors summary")
+		}
+		return nil
+	}
+
 	duration := time.Since(startTime).Seconds()
 	recordWorkflowDuration(duration)
 	if connect3270.ShutdownRequested() {
 		return nil
 	}
+	junit.finish(duration, failure, failureIsError)
+	status := "passed"
+	switch {
+	case timedOut:
+		status = "timeout"
+	case workflowFailed:
+		status = "failed"
+	case connectFailed:
+		status = "connect_failed"
+	}
+	if results != nil || eventStream != nil {
+		sample := resultSample{Start: startTime, Duration: duration, VUser: max(config.vUser, 1), Workflow: config.workflowName,
+			Status: status, FailingStep: failingStep, DataRow: config.dataRow}
+		if failure != nil {
+			sample.Error = failure.Error()
+		}
+		recordResult(sample)
+		publishWorkflowResult(e, config, sample)
+	}
+	if failure != nil {
+		detail.At, detail.Category = time.Now().UTC(), failureCategory(status, detail.Definition, failure)
+		detail.VUser, detail.Workflow, detail.Step = max(config.vUser, 1), config.workflowName, failingStep
+		detail.Error, detail.DataRow, detail.Data = failure.Error(), config.dataRow, redactFailureData(config.dataValues)
+		if detail.Definition != nil {
+			detail.Definition = redactFailureStep(*detail.Definition, config.dataValues)
+		}
+		recordFailure(detail)
+	}
 
 	if workflowFailed {
 		atomic.AddInt64(&totalWorkflowsFailed, 1)
 	} else if connectFailed {
 		if showConnectionErrors {
 			msg := fmt.Sprintf("Workflow for scriptPort %s failed to connect; not counted as workflow failure", scriptPortLabel)
-			storeLog(msg)
-			if connect3270.Verbose {
-				pterm.Warning.Println(msg)
-			}
+			logOrStore(schedulerLog, connect3270.Verbose, slog.LevelWarn, msg)
 		}
 	} else {
-		if connect3270.Verbose {
-			storeLog(fmt.Sprintf("Workflow for scriptPort %s completed successfully", scriptPortLabel))
-		}
+		schedulerLog.Debug(fmt.Sprintf("Workflow for scriptPort %s completed successfully", scriptPortLabel))
 		atomic.AddInt64(&totalWorkflowsCompleted, 1)
 	}
 	return nil
@@ -1023,10 +1292,7 @@ func randomDuration(rangeConfig DelayRange, allowZero bool) (time.Duration, erro
 	if rangeConfig.Min < 0 || rangeConfig.Max < 0 {
 		err := fmt.Errorf("DelayRange contains negative values; ignoring delay configuration")
 		negativeDelayLogOnce.Do(func() {
-			if connect3270.Verbose {
-				pterm.Warning.Println(err.Error())
-			}
-			storeLog(err.Error())
+			logOrStore(schedulerLog, connect3270.Verbose, slog.LevelWarn, err.Error())
 			addError(err)
 		})
 		return 0, err
@@ -1062,93 +1328,306 @@ func randomDuration(rangeConfig DelayRange, allowZero bool) (time.Duration, erro
 }
 
 func runAPIWorkflow() {
-	if connect3270.Verbose {
-		pterm.Info.Println("Starting API server mode - buckle up!")
-	}
+	apiLog.Debug("Starting API server mode - buckle up!")
 	connect3270.Headless = true
 	gin.SetMode(gin.ReleaseMode)
+	writeDashboardAPIKey()
+	r := newAPIRouter()
+	apiAddr := apiListenAddr
+	if !isLoopbackAddr(apiAddr) && apiAuth == nil {
+		apiLog.Warn(fmt.Sprintf("The API listens on %s without -apiKeys, -apiJWTSecret or -apiJWTKey: anyone who can reach it can run workflows", apiAddr))
+	}
+	server := &http.Server{Addr: apiAddr, Handler: r, TLSConfig: apiTLS}
+	serve := server.ListenAndServe
+	if apiTLS != nil {
+		// The certificate is in TLSConfig already.
+		serve = func() error { return server.ListenAndServeTLS("", "") }
+		apiAddr = "https://" + apiAddr
+		if apiTLS.ClientCAs != nil {
+			apiAddr += " (client certificates required)"
+		}
+	}
+	pterm.Success.Printf("API server rocking on %s - let’s roll!\n", apiAddr)
+	if apiGRPCPort != 0 {
+		go serveAPIGRPC()
+	}
+	if err := serve(); err != nil {
+		pterm.Error.Printf("API server crashed - send coffee: %v\n", err)
+	}
+}
+
+// newAPIRouter sets up the routes of the API server.
+func newAPIRouter() *gin.Engine {
 	r := gin.Default()
 	r.SetTrustedProxies(nil)
-	r.POST("/api/execute", func(c *gin.Context) {
-		workflowConfig := Configuration{WaitForField: true}
-		if err := c.ShouldBindJSON(&workflowConfig); err != nil {
-			sendErrorResponse(c, http.StatusBadRequest, "Invalid request payload - JSON’s drunk", err)
+	r.Use(apiServerMetrics.middleware())
+	registerHealthRoutes(r)
+	registerDocRoutes(r)
+	if apiAuth != nil {
+		r.Use(apiAuth.middleware())
+	}
+	registerMetricsRoute(r)
+	// /api is the unversioned alias of /api/v1, kept for automation written
+	// before the API had versions; it answers exactly as v1 does.
+	for _, base := range []string{"/api/v1", "/api"} {
+		registerWorkflowRoutes(r.Group(base))
+	}
+	return r
+}
+
+// registerWorkflowRoutes adds the routes of an API version: /execute and
+// the job API.
+func registerWorkflowRoutes(g *gin.RouterGroup) {
+	g.POST("/execute", func(c *gin.Context) {
+		workflowConfig, ok := bindAPIWorkflow(c)
+		if !ok {
 			return
 		}
-		if workflowConfig.Token == "" && rsaToken != "" {
-			workflowConfig.Token = rsaToken
-		}
-		if err := validateConfiguration(&workflowConfig); err != nil {
-			sendErrorResponse(c, http.StatusBadRequest, "Invalid workflow configuration", err)
+		if !apiWorkers.admit() {
+			refuseBusy(c)
 			return
 		}
-		tmpFile, err := os.CreateTemp("", "workflowOutput_")
+		// The request waits in the queue for as long as its caller does.
+		worker, err := apiWorkers.start(c.Request.Context())
 		if err != nil {
-			pterm.Error.Println("Temp file creation failed - disk’s napping:", err)
-			sendErrorResponse(c, http.StatusInternalServerError, "Failed to create temp file", err)
 			return
 		}
-		defer tmpFile.Close()
-		tmpFileName := tmpFile.Name()
-		defer os.Remove(tmpFileName)
-		scriptPort := getNextAvailablePort()
-		e := connect3270.NewEmulator(workflowConfig.Host, workflowConfig.Port, strconv.Itoa(scriptPort))
-		err = e.InitializeOutput(tmpFileName, true)
-		if err != nil {
-			sendErrorResponse(c, http.StatusInternalServerError, "Output init failed - setup’s cursed", err)
-			return
+		defer apiWorkers.done(worker)
+		executeAPIWorkflow(connect3270.ShutdownContext(), workflowConfig, apiWorkers.scriptPort(worker), nil).respond(c)
+	})
+	registerJobRoutes(g)
+}
+
+// bindAPIWorkflow reads the workflow of an API request, answering the
+// request itself when the workflow is no good.
+func bindAPIWorkflow(c *gin.Context) (*Configuration, bool) {
+	data, err := c.GetRawData()
+	if err != nil {
+		sendErrorResponse(c, http.StatusBadRequest, "Invalid request payload - JSON’s drunk", err)
+		return nil, false
+	}
+	workflowConfig, message, err := parseAPIWorkflow(data)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"returnCode": http.StatusBadRequest,
+			"status":     "error",
+			"message":    message,
+			"error":      err.Error(),
+			"errors":     apiWorkflowIssues(data, err),
+		})
+		return nil, false
+	}
+	return workflowConfig, true
+}
+
+// parseAPIWorkflow reads the workflow an API client sent, or says what is
+// wrong with it.
+func parseAPIWorkflow(data []byte) (*Configuration, string, error) {
+	workflowConfig := Configuration{WaitForField: true, submitted: true}
+	if err := binding.JSON.BindBody(data, &workflowConfig); err != nil {
+		return nil, "Invalid request payload - JSON’s drunk", err
+	}
+	if workflowConfig.Token == "" && rsaToken != "" {
+		workflowConfig.Token = rsaToken
+	}
+	// Includes would read files on the server, and the captures of
+	// JSONScreenGrab and Printer write them; the job output has to do.
+	for _, steps := range workflowConfig.stepLists() {
+		if stepsUse(steps, "Include") {
+			return nil, "Include steps are not allowed", errors.New("Include steps read files on the server - send the included steps inline")
 		}
-		for idx, step := range workflowConfig.Steps {
-			if idx > 0 {
-				delay, err := randomDuration(workflowConfig.EveryStepDelay, true)
-				if err != nil {
-					sendErrorResponse(c, http.StatusBadRequest, "Invalid delay configuration", err)
-					e.Disconnect()
-					return
-				}
-				if delay > 0 {
-					time.Sleep(delay)
-				}
-			}
-			if err := executeStep(e, step, tmpFileName, workflowConfig.Token); err != nil {
-				sendErrorResponse(c, http.StatusInternalServerError, fmt.Sprintf("Step '%s' failed - oof", step.Type), err)
+		if screenJSONFileIn(steps) {
+			return nil, "JSONScreenGrab File is not allowed", errScreenJSONFile
+		}
+	}
+	if workflowConfig.Printer != nil {
+		return nil, "Printer is not allowed", errors.New("Printer writes to a file on the server - run printer workflows from the command line")
+	}
+	if field := serverFileField(&workflowConfig); field != "" {
+		return nil, field + " is not allowed", fmt.Errorf("%s reads a file on the server - run workflows that need it from the command line", field)
+	}
+	if err := validateConfiguration(&workflowConfig); err != nil {
+		return nil, "Invalid workflow configuration", err
+	}
+	if err := checkCallbackURL(workflowConfig.CallbackURL); err != nil {
+		return nil, "Invalid callback URL", err
+	}
+	if err := workflowConfig.resolveSecrets(); err != nil {
+		return nil, "Invalid workflow secrets", err
+	}
+	return &workflowConfig, "", nil
+}
+
+// serverFileField names the first setting of config that reads a file on
+// the server, such as a client certificate or an SSH key, if any.
+func serverFileField(config *Configuration) string {
+	fields := []struct{ name, value string }{
+		{"TLSCertFile", config.TLSCertFile},
+		{"TLSKeyFile", config.TLSKeyFile},
+		{"TLSCAFile", config.TLSCAFile},
+	}
+	if t := config.SSHTunnel; t != nil {
+		fields = append(fields, struct{ name, value string }{"SSHTunnel.KeyFile", t.KeyFile}, struct{ name, value string }{"SSHTunnel.KnownHostsFile", t.KnownHostsFile})
+	}
+	for _, f := range fields {
+		if f.value != "" {
+			return f.name
+		}
+	}
+	return ""
+}
+
+// apiOutcome is how an API workflow ended: its output, or the status,
+// message and error /api/execute answers with.
+type apiOutcome struct {
+	code    int
+	message string
+	err     error
+	output  string
+}
+
+func (o apiOutcome) body() gin.H {
+	if o.err != nil {
+		return gin.H{
+			"returnCode": o.code,
+			"status":     "error",
+			"message":    o.message,
+			"error":      o.err.Error(),
+		}
+	}
+	return gin.H{
+		"returnCode": http.StatusOK,
+		"status":     "okay",
+		"message":    "Workflow executed successfully - high five!",
+		"output":     o.output,
+	}
+}
+
+func (o apiOutcome) respond(c *gin.Context) {
+	if o.err != nil {
+		sendErrorResponse(c, o.code, o.message, o.err)
+		return
+	}
+	c.JSON(http.StatusOK, o.body())
+}
+
+// executeAPIWorkflow runs the workflow of an API request once, until ctx
+// is cancelled, labelling its session with scriptPort. job, when set,
+// follows its progress and lets its screens be read while it runs.
+func executeAPIWorkflow(ctx context.Context, workflowConfig *Configuration, scriptPort int, job *apiJob) apiOutcome {
+	tmpFile, err := os.CreateTemp("", "workflowOutput_")
+	if err != nil {
+		pterm.Error.Println("Temp file creation failed - disk’s napping:", err)
+		return apiOutcome{code: http.StatusInternalServerError, message: "Failed to create temp file", err: err}
+	}
+	defer tmpFile.Close()
+	tmpFileName := tmpFile.Name()
+	defer os.Remove(tmpFileName)
+	e := connect3270.NewEmulator(workflowConfig.Host, workflowConfig.Port, strconv.Itoa(scriptPort))
+	err = e.InitializeOutput(tmpFileName, true)
+	if err != nil {
+		return apiOutcome{code: http.StatusInternalServerError, message: "Output init failed - setup’s cursed", err: err}
+	}
+	e.SetContext(ctx)
+	defer e.SetContext(nil)
+	state := newWorkflowState(tmpFileName, workflowConfig.Token)
+	state.submitted = workflowConfig.submitted
+	state.everyStepDelay = workflowConfig.EveryStepDelay
+	state.sessionConfigs = workflowConfig.Sessions
+	state.ctx = ctx
+	defer state.closeSessions()
+	job.attach(e)
+	defer job.detach()
+	// A request is a single iteration, so it logs on and off itself.
+	for idx, step := range sessionSteps(workflowConfig, workflowConfig.Steps) {
+		if idx > 0 {
+			delay, err := randomDuration(workflowConfig.EveryStepDelay, true)
+			if err != nil {
+				job.detach()
 				e.Disconnect()
-				return
+				return apiOutcome{code: http.StatusBadRequest, message: "Invalid delay configuration", err: err}
+			}
+			if delay > 0 && state.pause(delay) != nil {
+				return cancelledAPIWorkflow(ctx, e, state, job)
 			}
 		}
-		if delay, err := randomDuration(workflowConfig.EndOfTaskDelay, true); err != nil {
-			sendErrorResponse(c, http.StatusBadRequest, "Invalid end-of-task delay", err)
-		} else if delay > 0 {
-			time.Sleep(delay)
+		if ctx.Err() != nil {
+			return cancelledAPIWorkflow(ctx, e, state, job)
 		}
-		outputContents, err := e.ReadOutputFile(tmpFileName)
+		job.progress(idx+1, step.Type)
+		began := time.Now()
+		err := job.runStep(e, step, state)
+		job.stepDone(idx+1, step.Type, time.Since(began), err)
 		if err != nil {
-			sendErrorResponse(c, http.StatusInternalServerError, "Output read failed - file’s shy", err)
-			return
+			if ctx.Err() != nil {
+				return cancelledAPIWorkflow(ctx, e, state, job)
+			}
+			job.detach()
+			runOnErrorSteps(e, workflowConfig.OnError, state, "API")
+			e.Disconnect()
+			return apiOutcome{code: http.StatusInternalServerError, message: fmt.Sprintf("Step '%s' failed - oof", step.Type), err: err}
 		}
+	}
+	if delay, err := randomDuration(workflowConfig.EndOfTaskDelay, true); err != nil {
+		job.detach()
 		e.Disconnect()
-		c.JSON(http.StatusOK, gin.H{
-			"returnCode": http.StatusOK,
-			"status":     "okay",
-			"message":    "Workflow executed successfully - high five!",
-			"output":     outputContents,
-		})
-	})
-	apiAddr := fmt.Sprintf("localhost:%d", apiPort) // Bind to localhost
-	pterm.Success.Printf("API server rocking on %s - let’s roll!\n", apiAddr)
-	if err := r.Run(apiAddr); err != nil {
-		pterm.Error.Printf("API server crashed - send coffee: %v\n", err)
+		return apiOutcome{code: http.StatusBadRequest, message: "Invalid end-of-task delay", err: err}
+	} else if delay > 0 && state.pause(delay) != nil {
+		return cancelledAPIWorkflow(ctx, e, state, job)
 	}
+	job.detach()
+	outputContents, err := e.ReadOutputFile(tmpFileName)
+	if err != nil {
+		return apiOutcome{code: http.StatusInternalServerError, message: "Output read failed - file’s shy", err: err}
+	}
+	e.Disconnect()
+	return apiOutcome{output: outputContents}
+}
+
+// cancelledAPIWorkflow ends an API workflow whose ctx was cancelled. The
+// sessions get their context back first, so they still disconnect cleanly
+// instead of staying logged on at the host.
+func cancelledAPIWorkflow(ctx context.Context, e *connect3270.Emulator, state *workflowState, job *apiJob) apiOutcome {
+	job.detach()
+	e.SetContext(nil)
+	state.setSessionContext(nil)
+	state.ctx = connect3270.ShutdownContext()
+	e.Disconnect()
+	return cancelledOutcome(ctx)
 }
 
-func executeStep(e *connect3270.Emulator, step Step, tmpFileName string, token string) error {
+// cancelledOutcome is the answer to an API workflow whose ctx was
+// cancelled, by DELETE /api/jobs/{id} or by shutdown.
+func cancelledOutcome(ctx context.Context) apiOutcome {
+	err := context.Cause(ctx)
+	if errors.Is(err, errJobCancelled) {
+		return apiOutcome{code: http.StatusConflict, message: "Workflow cancelled", err: err}
+	}
+	return apiOutcome{code: http.StatusServiceUnavailable, message: "Workflow stopped by shutdown", err: err}
+}
+
+func executeStep(e *connect3270.Emulator, step Step, state *workflowState) error {
+	e, err := state.emulatorFor(e, step)
+	if err != nil {
+		return err
+	}
 	switch step.Type {
 	case "InitializeOutput":
-		return e.InitializeOutput(tmpFileName, runAPI)
+		if state.tmpFileName == "" {
+			return nil
+		}
+		return e.InitializeOutput(state.tmpFileName, runAPI)
 	case "Connect":
+		if !acquireConnectSlot() {
+			return fmt.Errorf("shutdown requested")
+		}
+		defer releaseConnectSlot()
 		return e.Connect()
 	case "CheckValue":
-		expected := resolveTokenPlaceholder(step.Text, token)
+		expected, err := state.resolve(step.Text)
+		if err != nil {
+			return err
+		}
 		value, err := e.GetValue(step.Coordinates.Row, step.Coordinates.Column, step.Coordinates.Length)
 		if err != nil {
 			return err
@@ -1158,14 +1637,133 @@ func executeStep(e *connect3270.Emulator, step Step, tmpFileName string, token s
 			return fmt.Errorf("CheckValue failed. Expected: %s, Found: %s", expected, value)
 		}
 		return nil
+	case "CheckValueRegex":
+		patternText, err := state.resolvePattern(step.Text)
+		if err != nil {
+			return err
+		}
+		pattern, err := compileStepRegex(patternText)
+		if err != nil {
+			return err
+		}
+		var value string
+		if step.Coordinates.Row == 0 && step.Coordinates.Column == 0 {
+			rows, err := e.ScreenText()
+			if err != nil {
+				return err
+			}
+			value = strings.Join(rows, "\n")
+		} else {
+			value, err = e.GetValue(step.Coordinates.Row, step.Coordinates.Column, step.Coordinates.Length)
+			if err != nil {
+				return err
+			}
+			value = strings.TrimSpace(value)
+		}
+		if !pattern.MatchString(value) {
+			return fmt.Errorf("CheckValueRegex failed. Pattern: %s, Found: %s", pattern.String(), value)
+		}
+		return nil
 	case "FillString":
-		text := resolveTokenPlaceholder(step.Text, token)
+		text, err := state.resolve(step.Text)
+		if err != nil {
+			return err
+		}
 		if step.Coordinates.Row == 0 && step.Coordinates.Column == 0 {
 			return e.SetString(text)
 		}
 		return e.FillString(step.Coordinates.Row, step.Coordinates.Column, text)
+	case "FillBlock":
+		text, err := state.resolve(step.Text)
+		if err != nil {
+			return err
+		}
+		for i, line := range blockLines(text, step.Coordinates.Length) {
+			if line == "" {
+				continue
+			}
+			if err := e.FillString(step.Coordinates.Row+i, step.Coordinates.Column, line); err != nil {
+				return fmt.Errorf("FillBlock line %d: %w", i+1, err)
+			}
+		}
+		return nil
+	case "ExtractValue":
+		value, err := e.GetValue(step.Coordinates.Row, step.Coordinates.Column, step.Coordinates.Length)
+		if err != nil {
+			return err
+		}
+		state.vars[step.Variable] = strings.TrimSpace(value)
+		return nil
+	case "If":
+		matched, err := evaluateCondition(e, step.Condition, state)
+		if err != nil {
+			return err
+		}
+		if matched {
+			return executeNestedSteps(e, step.Steps, state)
+		}
+		return executeNestedSteps(e, step.Else, state)
 	case "AsciiScreenGrab":
-		return e.AsciiScreenGrab(tmpFileName, runAPI)
+		return e.AsciiScreenGrab(state.tmpFileName, runAPI)
+	case "FillFieldByIndex":
+		text, err := state.resolve(step.Text)
+		if err != nil {
+			return err
+		}
+		if err := e.Press(connect3270.Home); err != nil {
+			return err
+		}
+		for i := 1; i < step.Index; i++ {
+			if err := e.Press(connect3270.Tab); err != nil {
+				return err
+			}
+		}
+		return e.SetString(text)
+	case "FillFieldNear":
+		text, err := state.resolve(step.Text)
+		if err != nil {
+			return err
+		}
+		label, err := state.resolve(step.Near)
+		if err != nil {
+			return err
+		}
+		screen, err := e.ReadScreen()
+		if err != nil {
+			return err
+		}
+		field := screen.InputFieldAfter(label)
+		if field == nil {
+			return fmt.Errorf("FillFieldNear failed. No input field found after %q", label)
+		}
+		return e.FillString(field.Row, field.Column, text)
+	case "MoveCursor":
+		return e.MoveCursor(step.Coordinates.Row, step.Coordinates.Column)
+	case "CheckCursor":
+		row, column, err := e.Cursor()
+		if err != nil {
+			return err
+		}
+		if row != step.Coordinates.Row || column != step.Coordinates.Column {
+			return fmt.Errorf("CheckCursor failed. Expected: row %d, column %d, Found: row %d, column %d", step.Coordinates.Row, step.Coordinates.Column, row, column)
+		}
+		return nil
+	case "CheckFieldAttributes":
+		screen, err := e.ReadScreen()
+		if err != nil {
+			return err
+		}
+		field := screen.FieldAt(step.Coordinates.Row, step.Coordinates.Column)
+		if field == nil {
+			return fmt.Errorf("CheckFieldAttributes failed. No field at row %d, column %d", step.Coordinates.Row, step.Coordinates.Column)
+		}
+		return checkFieldAttributes(step.Attributes, field)
+	case "JSONScreenGrab":
+		screen, err := e.ReadScreen()
+		if err != nil {
+			return err
+		}
+		return writeScreenJSON(screen, step.File, state.tmpFileName)
 	case "PressEnter":
 		return e.Press(connect3270.Enter)
 	case "PressTab":
@@ -1176,15 +1774,34 @@ func executeStep(e *connect3270.Emulator, step Step, tmpFileName string, token s
 			timeout = time.Duration(step.Delay * float64(time.Second))
 		}
 		return e.WaitForField(timeout)
+	case "WaitForText":
+		timeout := defaultWaitForTextTimeout
+		if step.Timeout > 0 {
+			timeout = time.Duration(step.Timeout * float64(time.Second))
+		}
+		text, err := state.resolve(step.Text)
+		if err != nil {
+			return err
+		}
+		return e.WaitForText(text, step.Coordinates.Row, step.Coordinates.Column, timeout)
+	case "WaitForPrint":
+		if state.printer == nil {
+			return fmt.Errorf("WaitForPrint: no printer session is open")
+		}
+		timeout := defaultWaitForPrintTimeout
+		if step.Timeout > 0 {
+			timeout = time.Duration(step.Timeout * float64(time.Second))
+		}
+		text, err := state.resolve(step.Text)
+		if err != nil {
+			return err
+		}
+		return state.printer.WaitForOutput(text, timeout)
 	case "Disconnect":
 		if err := e.Disconnect(); err != nil {
 			// Disconnect failures often mean the emulator is already gone; don't fail the workflow for that.
 			msg := fmt.Sprintf("Disconnect ignored: %v", err)
-			if connect3270.Verbose {
-				pterm.Warning.Println(msg)
-			} else {
-				storeLog(msg)
-			}
+			logOrStore(emulatorLog, connect3270.Verbose, slog.LevelWarn, msg)
 			return nil
 		}
 		return nil
@@ -1236,6 +1853,20 @@ func executeStep(e *connect3270.Emulator, step Step, tmpFileName string, token s
 		return e.Press(connect3270.F23)
 	case "PressPF24":
 		return e.Press(connect3270.F24)
+	case "PressPA1":
+		return e.Press(connect3270.PA1)
+	case "PressPA2":
+		return e.Press(connect3270.PA2)
+	case "PressPA3":
+		return e.Press(connect3270.PA3)
+	case "PressClear":
+		return e.Press(connect3270.Clear)
+	case "PressHome":
+		return e.Press(connect3270.Home)
+	case "EraseEOF":
+		return e.Press(connect3270.EraseEOF)
+	case "EraseInput":
+		return e.Press(connect3270.EraseInput)
 	case "StepDelay":
 		stepDelay, err := randomDuration(step.StepDelay, false)
 		if err != nil {
@@ -1244,17 +1875,57 @@ func executeStep(e *connect3270.Emulator, step Step, tmpFileName string, token s
 		if stepDelay <= 0 {
 			return fmt.Errorf("StepDelay requires a positive Min or Max value")
 		}
-		time.Sleep(stepDelay)
-		return nil
+		return state.pause(stepDelay)
 	default:
 		return fmt.Errorf("unknown step type: %s", step.Type)
 	}
 }
 
-func sendErrorResponse(c *gin.Context, statusCode int, message string, err error) {
-	if connect3270.Verbose {
-		pterm.Info.Println("Sending error response - oopsie daisy!")
+// runOnErrorSteps runs a workflow's OnError recovery steps after a failure.
+// Recovery is best effort: every step is attempted and failures are only
+// logged, so the original error stays the one that is reported.
+func runOnErrorSteps(e *connect3270.Emulator, steps []Step, state *workflowState, label string) {
+	if len(steps) == 0 {
+		return
+	}
+	storeLog(fmt.Sprintf("Running %d OnError step(s) for %s", len(steps), label))
+	for _, step := range steps {
+		if state.ctx.Err() != nil {
+			return
+		}
+		if err := executeStep(e, step, state); err != nil {
+			msg := fmt.Sprintf("OnError step %s failed for %s: %v", step.Type, label, err)
+			logOrStore(schedulerLog, connect3270.Verbose || verboseFailures, slog.LevelWarn, msg)
+		}
+	}
+}
+
+// blockLines splits FillBlock text into rows, truncating each to length
+// characters when length is positive.
+func blockLines(text string, length int) []string {
+	lines := strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n")
+	if length > 0 {
+		for i, line := range lines {
+			if runes := []rune(line); len(runes) > length {
+				lines[i] = string(runes[:length])
+			}
+		}
 	}
+	return lines
+}
+
+// compileStepRegex compiles a CheckValueRegex pattern. Patterns are not
+// cached: with placeholders substituted they can differ on every run.
+func compileStepRegex(pattern string) (*regexp.Regexp, error) {
+	compiled, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid CheckValueRegex pattern %q: %v", pattern, err)
+	}
+	return compiled, nil
+}
+
+func sendErrorResponse(c *gin.Context, statusCode int, message string, err error) {
+	apiLog.Debug("Sending error response - oopsie daisy!")
 	c.JSON(statusCode, gin.H{
 		"returnCode": statusCode,
 		"status":     "error",
@@ -1264,6 +1935,11 @@ func sendErrorResponse(c *gin.Context, statusCode int, message string, err error
 }
 
 func printBanner() {
+	if logFormat != "pretty" {
+		// Structured logs have no room for the banner art.
+		pterm.Info.Println("3270Connect version " + version)
+		return
+	}
 
 	clear()
 
@@ -1300,8 +1976,29 @@ func LaunchEmbeddedIfDoubleClicked() {
 
 func main() {
 	flag.Parse()
+	if handled, code := runSubcommand(flag.Args()); handled {
+		os.Exit(code)
+	}
+	if err := configureLogging(os.Stdout); err != nil {
+		pterm.Error.Println(err)
+		os.Exit(1)
+	}
+	defer flushLogs()
+	defer connect3270.DrainProcessPool()
 	metricsConfigFilePath = configFile
 	printBanner()
+	if err := setupBindAddresses(); err != nil {
+		pterm.Error.Println(err.Error())
+		os.Exit(1)
+	}
+	if err := setupDashboardServing(); err != nil {
+		pterm.Error.Println(err.Error())
+		os.Exit(1)
+	}
+	if err := setupDashboardAuth(); err != nil {
+		pterm.Error.Println(err.Error())
+		os.Exit(1)
+	}
 	// If no command-line parameters are provided, force dashboard mode.
 	if len(os.Args) == 1 {
 		*startDashboard = true
@@ -1329,11 +2026,27 @@ func main() {
 		flag.Usage()
 		os.Exit(0)
 	}
+	if recordPath != "" {
+		if err := runRecorder(); err != nil {
+			pterm.Error.Printf("Recording failed: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+	if dryRun {
+		if err := runDryRun(configFile, injectionConfig); err != nil {
+			pterm.Error.Printf("Dry run failed: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
 	setGlobalSettings()
-	if concurrent > 1 || runtimeDuration > 0 {
+	startDiagnosticsServer()
+	if (concurrent > 1 || runtimeDuration > 0 || iterationMode() || searchMode()) && !k8sController {
 		go runDashboard()
 	}
 	go monitorSystemUsage()
+	startMetricsReporter()
 	if runApp != "" {
 		storeLog(fmt.Sprintf("RunApp selected: Sample App %s launched on port %d - PID: %d", runApp, runAppPort, os.Getpid()))
 		switch runApp {
@@ -1359,13 +2072,96 @@ func main() {
 	if rsaToken != "" {
 		config.Token = rsaToken
 	}
+	restoreSignals := func() {}
 	if !runAPI {
 		printWorkflowMetadata(configFile, config)
+		// From here on Ctrl+C and SIGTERM end the run with a summary.
+		restoreSignals = handleStopSignals()
+	}
+	// Distributed workers push their own metrics; the controller has none
+	// until they report.
+	stopMetrics := func() {}
+	if !runAPI && !k8sController {
+		if err := validateMetricsSinks(); err != nil {
+			pterm.Error.Println(err.Error())
+			os.Exit(1)
+		}
+		stopMetrics = startMetricsSinks()
+	}
+	if err := validateJUnit(); err != nil {
+		pterm.Error.Println(err.Error())
+		os.Exit(1)
+	}
+	if !runAPI && !k8sController {
+		if err := openResults(); err != nil {
+			pterm.Error.Printf("Cannot write -results: %v\n", err)
+			os.Exit(1)
+		}
+		if err := openEventStream(); err != nil {
+			pterm.Error.Println(err.Error())
+			os.Exit(1)
+		}
+	}
+	if !runAPI {
+		if err := setupNotifications(configFile, config); err != nil {
+			pterm.Error.Println(err.Error())
+			os.Exit(1)
+		}
+		if err := setupMail(); err != nil {
+			pterm.Error.Println(err.Error())
+			os.Exit(1)
+		}
+		if err := setupArtifacts(); err != nil {
+			pterm.Error.Println(err.Error())
+			os.Exit(1)
+		}
+	} else {
+		if err := setupAPIAuth(); err != nil {
+			pterm.Error.Println(err.Error())
+			os.Exit(1)
+		}
+		if err := setupAPITLS(); err != nil {
+			pterm.Error.Println(err.Error())
+			os.Exit(1)
+		}
+		if err := setupAPIWorkers(); err != nil {
+			pterm.Error.Println(err.Error())
+			os.Exit(1)
+		}
+		if err := setupAPIGRPC(); err != nil {
+			pterm.Error.Println(err.Error())
+			os.Exit(1)
+		}
+		if err := setupAPICallbacks(); err != nil {
+			pterm.Error.Println(err.Error())
+			os.Exit(1)
+		}
+	}
+	if !runAPI && !runSetupWorkflow(config, configFile) {
+		// Clean up whatever the setup got to before it failed.
+		runTeardownWorkflow(config, configFile)
+		stopMetrics()
+		writeJUnitReport()
+		closeResults()
+		closeEventStream()
+		notifyRunComplete(configFile, config, 1)
+		mailRunSummary(configFile, config, 1)
+		uploadRunArtifacts(configFile, config)
+		showErrors()
+		flushLogs()
+		connect3270.DrainProcessPool()
+		os.Exit(1)
+	}
+	if !runAPI {
+		notifyRunStart(config)
 	}
 	if runAPI {
 		runAPIWorkflow()
+	} else if k8sController {
+		runKubernetesController(config, configFile, injectionConfig)
+		runTeardownWorkflow(config, configFile)
 	} else {
-		if concurrent > 1 || runtimeDuration > 0 {
+		if concurrent > 1 || runtimeDuration > 0 || iterationMode() || searchMode() {
 			runConcurrentWorkflows(config, injectionConfig, configFile)
 
 		} else {
@@ -1379,25 +2175,82 @@ func main() {
 						pterm.Info.Printf("Loaded %d injection entries from %s\n", len(injectData), injectionConfig)
 						// Use the first entry for single workflow execution
 						config = injectDynamicValues(config, injectData[0])
+						config.dataRow = 1
 					}
 				} else {
 					pterm.Warning.Printf("Injection file %s not found. Proceeding without injection.\n", injectionConfig)
 				}
 			}
-			runWorkflow(lastUsedPort, config)
+			e := connect3270.NewEmulator(config.Host, config.Port, strconv.Itoa(lastUsedPort))
+			session := &vUserSession{}
+			if len(config.Workflows) > 0 {
+				// A single run walks the suite once, in file order.
+				for _, w := range config.Workflows {
+					if stopRequested() {
+						break
+					}
+					runWorkflowWithEmulator(e, suiteWorkflowConfig(config, w), time.Time{}, session)
+				}
+			} else if !stopRequested() {
+				runWorkflowWithEmulator(e, config, time.Time{}, session)
+			}
+			session.close(e)
 			printSingleWorkflowSummary(configFile, config)
 		}
-		if concurrent > 1 && dashboardStarted {
+		runTeardownWorkflow(config, configFile)
+		// With thresholds the exit code is the point, an iteration run is a
+		// regression suite and an aborted run failed, so do not wait on the
+		// dashboard.
+		if concurrent > 1 && dashboardStarted && effectiveThresholds(config) == nil && !iterationMode() && !runAborted() && !stopRequested() {
+			// Nothing below judges this run, so record it before waiting.
+			stopMetrics()
+			writeJUnitReport()
+			closeResults()
+			closeEventStream()
+			recordRunHistory(configFile, config, 0)
+			notifyRunComplete(configFile, config, 0)
+			mailRunSummary(configFile, config, 0)
+			uploadRunArtifacts(configFile, config)
 			pterm.Info.Printf("All workflows completed but the dashboard is still running on port %d. Press Ctrl+C to exit.", dashboardPort)
+			restoreSignals()
 			select {}
 		}
 	}
+	stopMetrics()
+	writeJUnitReport()
+	closeResults()
+	closeEventStream()
 	showErrors()
+	// Distributed workers leave the verdict to the controller.
+	if !runAPI && reportToURL == "" {
+		// A search breaks the SLA on purpose; its verdict is the report.
+		code := 0
+		if !searchMode() {
+			code = checkThresholds(config)
+		}
+		if runAborted() {
+			code = abortExitCode
+		}
+		if code == 0 && stopRequested() {
+			code = interruptedExitCode
+		}
+		recordRunHistory(configFile, config, code)
+		notifyRunComplete(configFile, config, code)
+		mailRunSummary(configFile, config, code)
+		uploadRunArtifacts(configFile, config)
+		if code != 0 {
+			flushLogs()
+			connect3270.DrainProcessPool()
+			os.Exit(code)
+		}
+	}
 }
 
 func setGlobalSettings() {
 	connect3270.Headless = headless
 	connect3270.Verbose = verbose
+	applyBackendSettings()
+	applyLargeScaleSettings()
 }
 
 var stopTicker chan struct{}
@@ -1408,15 +2261,75 @@ type workflowWorker struct {
 	wg       *sync.WaitGroup
 	emulator *connect3270.Emulator
 	deadline time.Time
+	ports    portRange
+	luName   string
+	// quota is how many workflows the worker runs before it stops, or 0.
+	quota int
+	// idle takes the worker back after each job of an open-model run.
+	idle chan<- struct{}
+	// session keeps the vUser logged on between jobs with SessionSetup.
+	session *vUserSession
+}
+
+// portRange is a block of session numbers reserved for a single worker, so
+// each session has a label no other worker uses. Emulators pick their
+// actual script ports themselves.
+type portRange struct {
+	base int
+	size int
+	next int
+}
+
+// nextPort returns the next port in the range, wrapping back to the base.
+func (r *portRange) nextPort() int {
+	if r.size <= 0 {
+		return r.base
+	}
+	port := r.base + r.next
+	r.next = (r.next + 1) % r.size
+	return port
+}
+
+const (
+	maxScriptPort     = 65000
+	maxWorkerPortSpan = 20
+)
+
+// reserveWorkerPortRanges splits the script port space above startPort into
+// one contiguous block per worker.
+func reserveWorkerPortRanges(basePort, workerCount int) []portRange {
+	if workerCount <= 0 {
+		return nil
+	}
+	if basePort <= 0 {
+		basePort = 5000
+	}
+	first := basePort + 1
+	available := maxScriptPort - first + 1
+	span := maxWorkerPortSpan
+	if available/workerCount < span {
+		span = available / workerCount
+	}
+	if span < 1 {
+		span = 1
+	}
+	ranges := make([]portRange, workerCount)
+	for i := range ranges {
+		ranges[i] = portRange{base: first + i*span, size: span}
+	}
+	return ranges
 }
 
-func newWorkflowWorker(id int, jobs <-chan *Configuration, wg *sync.WaitGroup, deadline time.Time) *workflowWorker {
+func newWorkflowWorker(id int, jobs <-chan *Configuration, wg *sync.WaitGroup, deadline time.Time, ports portRange) *workflowWorker {
+	emulator := connect3270.NewEmulator("", 0, "")
 	return &workflowWorker{
 		id:       id,
 		jobs:     jobs,
 		wg:       wg,
-		emulator: connect3270.NewEmulator("", 0, ""),
+		emulator: emulator,
 		deadline: deadline,
+		ports:    ports,
+		session:  &vUserSession{},
 	}
 }
 
@@ -1426,47 +2339,90 @@ func (w *workflowWorker) start() {
 		if cfg == nil {
 			continue
 		}
-		// Check if shutdown was requested before starting new workflow
-		if connect3270.ShutdownRequested() {
-			if connect3270.Verbose {
-				storeLog(fmt.Sprintf("Worker %d skipping workflow due to shutdown request", w.id))
-			}
+		// Check if shutdown was requested or the run aborted or stopped before starting new workflow
+		if connect3270.ShutdownRequested() || runAborted() || stopRequested() {
+			schedulerLog.Debug(fmt.Sprintf("Worker %d skipping workflow because the run is stopping", w.id))
+			w.jobDone()
 			continue
 		}
-		scriptPort := getNextAvailablePort()
-		w.emulator.ScriptPort = strconv.Itoa(scriptPort)
-		if connect3270.Verbose {
-			storeLog(fmt.Sprintf("Worker %d using script port %d", w.id, scriptPort))
-		}
-		w.emulator.Host = cfg.Host
-		w.emulator.Port = cfg.Port
-		if err := runWorkflowWithEmulator(w.emulator, cfg, w.deadline); err != nil {
-			storeLog(fmt.Sprintf("Worker %d workflow error: %v", w.id, err))
-			if connect3270.Verbose {
-				pterm.Error.Printf("Worker %d workflow error: %v\n", w.id, err)
+		// A session still logged on keeps the script port it was set up on.
+		if !w.session.isOpen() {
+			scriptPort := w.ports.nextPort()
+			w.emulator.ScriptPort = strconv.Itoa(scriptPort)
+			schedulerLog.Debug(fmt.Sprintf("Worker %d using script port %d", w.id, scriptPort))
+			w.emulator.Host = cfg.Host
+			w.emulator.Port = cfg.Port
+		}
+		pinned := *cfg
+		pinned.vUser = w.id + 1
+		if w.luName != "" {
+			pinned.LUName = w.luName
+		}
+		cfg = &pinned
+		if err := runWorkflowWithEmulator(w.emulator, cfg, w.deadline, w.session); err != nil {
+			logOrStore(schedulerLog, connect3270.Verbose, slog.LevelError, fmt.Sprintf("Worker %d workflow error: %v", w.id, err))
+		}
+		if w.quota > 0 {
+			if w.quota--; w.quota == 0 {
+				break
 			}
 		}
+		w.jobDone()
 	}
-	_ = w.emulator.Disconnect()
+	w.session.close(w.emulator)
 }
 
 func runConcurrentWorkflows(config *Configuration, injectionConfig string, configPath string) {
-	if runtimeDuration <= 0 {
-		pterm.Warning.Println("Runtime duration must be greater than zero for concurrent execution.")
+	if err := validateSearch(config); err != nil {
+		pterm.Error.Println(err.Error())
+		return
+	}
+	if err := validateShutdownFlags(); err != nil {
+		pterm.Error.Println(err.Error())
+		return
+	}
+	search := newConcurrencySearch(config, max(concurrent, 1))
+	currentSearch = search
+	if search != nil && runtimeDuration <= 0 {
+		runtimeDuration = search.runtime(config.RampUpDelay)
+	}
+	if runtimeDuration <= 0 && !iterationMode() {
+		pterm.Warning.Println("Runtime duration must be greater than zero for concurrent execution, unless -iterations is set.")
 		return
 	}
 	connect3270.ResetShutdown()
 	overallStart := time.Now()
+	resumed := prepareResume(configPath, injectionConfig)
+	if resumed != nil {
+		// Shift the start back so the deadline and elapsed displays cover the
+		// time already spent before the interruption.
+		overallStart = overallStart.Add(-resumed.elapsed())
+	}
+	checkpoints := newCheckpointWriter(configPath, injectionConfig, overallStart)
 	workerCount := concurrent
 	if workerCount <= 0 {
 		workerCount = 1
 	}
-	deadline := overallStart.Add(time.Duration(runtimeDuration) * time.Second)
+	deadline := runDeadline(overallStart)
 	jobs := make(chan *Configuration, workerCount)
 	var workerWG sync.WaitGroup
+	portRanges := reserveWorkerPortRanges(startPort, workerCount)
+	luPool, _ := expandLUPool(config.LUPool)
+	if len(luPool) > 0 && len(luPool) < workerCount {
+		pterm.Warning.Printf("LUPool has %d LU names for %d vUsers - some sessions will share an LU.\n", len(luPool), workerCount)
+	}
+	// An open-model run keeps track of its free vUsers to drop arrivals
+	// that find none.
+	var idle chan struct{}
+	if arrivalRate(config) > 0 {
+		idle = newIdleVUsers(workerCount)
+	}
 	for i := 0; i < workerCount; i++ {
 		workerWG.Add(1)
-		worker := newWorkflowWorker(i, jobs, &workerWG, deadline)
+		worker := newWorkflowWorker(i, jobs, &workerWG, deadline, portRanges[i])
+		worker.luName = luNameForWorker(luPool, i)
+		worker.idle = idle
+		worker.quota = iterationsPerVUserFlag
 		go worker.start()
 	}
 
@@ -1489,6 +2445,13 @@ func runConcurrentWorkflows(config *Configuration, injectionConfig string, confi
 	if len(injectData) == 0 {
 		injectData = []map[string]string{{}}
 	}
+	budget := newIterationBudget(iterationTotal(config, len(injectData), workerCount))
+	if budget != nil {
+		if resumed != nil {
+			budget.left.Add(-(resumed.Completed + resumed.Failed))
+		}
+		pterm.Info.Printf("Running %d workflow(s) in all on %d vUser(s).\n", budget.total, workerCount)
+	}
 
 	var (
 		multi       MultiPrinter
@@ -1508,8 +2471,13 @@ func runConcurrentWorkflows(config *Configuration, injectionConfig string, confi
 	tickerInterval := time.Second
 	if enableProgressBar {
 		multi = pterm.DefaultMultiPrinter
+		durationTotal := runtimeDuration
+		if runtimeDuration <= 0 {
+			// Without a runtime the bar counts finished iterations.
+			durationTotal = budget.total
+		}
 		durationBar, _ = pterm.DefaultProgressbar.
-			WithTotal(runtimeDuration).
+			WithTotal(durationTotal).
 			WithTitle(padTitle("⏱️ Run Duration")).
 			WithWriter(multi.NewWriter()).
 			WithBarCharacter("-").
@@ -1557,9 +2525,16 @@ func runConcurrentWorkflows(config *Configuration, injectionConfig string, confi
 		tickerInterval = 5 * time.Second
 	}
 
-	deadline = overallStart.Add(time.Duration(runtimeDuration) * time.Second)
+	deadline = runDeadline(overallStart)
 
 	stopTicker = make(chan struct{})
+	stopErrorBudget := make(chan struct{})
+	go watchErrorBudget(config, stopErrorBudget)
+	stopSearch := make(chan struct{})
+	if search != nil {
+		pterm.Info.Printf("Searching for the most vUsers within the SLA: %d more every %s, up to %d.\n", search.step, formatSeconds(search.stepTime.Seconds()), search.max)
+		go search.run(stopSearch)
+	}
 	go func() {
 		ticker := time.NewTicker(tickerInterval)
 		defer ticker.Stop()
@@ -1577,7 +2552,10 @@ func runConcurrentWorkflows(config *Configuration, injectionConfig string, confi
 				totalRows := formatWorkflowTotalsRows(started, completed, failed)
 
 				if enableProgressBar {
-					if durationBar != nil {
+					if durationBar != nil && runtimeDuration <= 0 {
+						durationBar.Current = int(completed + failed)
+						durationBar.UpdateTitle(padTitle(fmt.Sprintf("🔁 Iterations (%d/%d)", completed+failed, budget.total)))
+					} else if durationBar != nil {
 						durationBar.Current = min(elapsed, runtimeDuration)
 						if elapsed < runtimeDuration {
 							durationBar.UpdateTitle(padTitle(fmt.Sprintf("⏱️ Run Duration (%ds left)", runtimeDuration-elapsed)))
@@ -1611,7 +2589,7 @@ func runConcurrentWorkflows(config *Configuration, injectionConfig string, confi
 				return
 			}
 
-			if time.Now().After(deadline) {
+			if !beforeDeadline(time.Now(), deadline) || budget.spent() || search.done() || stopRequested() {
 				active := getActiveWorkflows()
 				started := atomic.LoadInt64(&totalWorkflowsStarted)
 				completed := atomic.LoadInt64(&totalWorkflowsCompleted)
@@ -1623,31 +2601,60 @@ func runConcurrentWorkflows(config *Configuration, injectionConfig string, confi
 		}
 	}()
 
-	injectionCursor := 0
-	rampDelay := time.Duration(config.RampUpDelay * float64(time.Second))
-	if rampDelay <= 0 {
-		rampDelay = time.Second
+	live := newLiveRunConfig(config, injectData)
+	mix := newSuiteMix()
+	if budget != nil {
+		// Iteration runs are for regression suites: walk the suite in file
+		// order so each workflow meets each row once.
+		mix.walkRows = len(injectData)
+	}
+	if hotReload {
+		stopWatching := make(chan struct{})
+		defer close(stopWatching)
+		go watchRunFiles(live, configPath, injectionConfig, stopWatching)
 	}
 
+	injectionCursor := 0
+	if resumed != nil {
+		injectionCursor = resumed.InjectionCursor
+	}
 	stoppedScheduling := false
-	for time.Now().Before(deadline) {
-		if deadline.Sub(time.Now()) <= rampDelay {
+	if idle != nil {
+		scheduleArrivals(live, mix, jobs, idle, deadline, budget, &injectionCursor, checkpoints)
+	}
+	for idle == nil && beforeDeadline(time.Now(), deadline) && !budget.spent() && !runAborted() && !stopRequested() && !search.done() {
+		runConfig, rows := live.current()
+		if len(rows) == 0 {
+			rows = []map[string]string{{}}
+		}
+		rampDelay := time.Duration(runConfig.RampUpDelay * float64(time.Second))
+		if rampDelay <= 0 {
+			rampDelay = time.Second
+		}
+		if !deadline.IsZero() && time.Until(deadline) <= rampDelay {
 			stoppedScheduling = true
 			break // Don't launch new work when we're at/near the deadline; let in-flight finish.
 		}
-		availableSlots := workerCount - getActiveWorkflows()
+		availableSlots := search.limit(workerCount) - getActiveWorkflows()
 		if availableSlots <= 0 {
 			time.Sleep(rampDelay)
 			continue
 		}
 
-		workflowsToStart := min(config.RampUpBatchSize, availableSlots)
+		workflowsToStart := min(runConfig.RampUpBatchSize, availableSlots)
 		startedThisBatch := 0
-		for startedThisBatch < workflowsToStart && time.Now().Before(deadline) {
-			cfg := injectDynamicValues(config, injectData[injectionCursor])
-			injectionCursor = (injectionCursor + 1) % len(injectData)
+		for startedThisBatch < workflowsToStart && beforeDeadline(time.Now(), deadline) && !budget.spent() && !runAborted() && !stopRequested() && !search.done() && len(jobs) < cap(jobs) {
+			injectionCursor %= len(rows)
+			picked, suiteName := mix.next(runConfig)
+			cfg := injectDynamicValues(picked, rows[injectionCursor])
+			if len(rows[injectionCursor]) > 0 {
+				cfg.dataRow = injectionCursor + 1
+			}
+			injectionCursor = (injectionCursor + 1) % len(rows)
 			select {
 			case jobs <- cfg:
+				mix.record(suiteName)
+				budget.take()
 				startedThisBatch++
 			default:
 				// Avoid blocking so we can honor the runtime deadline.
@@ -1668,6 +2675,7 @@ func runConcurrentWorkflows(config *Configuration, injectionConfig string, confi
 			storeLog(combinedMsg)
 		}
 
+		checkpoints.maybeSave(injectionCursor)
 		time.Sleep(rampDelay)
 	}
 	if stoppedScheduling {
@@ -1680,6 +2688,8 @@ func runConcurrentWorkflows(config *Configuration, injectionConfig string, confi
 		storeLog(msg)
 	}
 
+	close(stopErrorBudget)
+	close(stopSearch)
 	if stopTicker != nil {
 		close(stopTicker)
 		stopTicker = nil
@@ -1702,21 +2712,34 @@ func runConcurrentWorkflows(config *Configuration, injectionConfig string, confi
 		multi.Stop()
 	}
 
-	pterm.Success.Println("⏱️ Run duration complete. Waiting for current workflows to finish...")
+	if runAborted() {
+		pterm.Warning.Println("🛑 Run aborted. Waiting for current workflows to finish...")
+	} else if stopRequested() {
+		pterm.Warning.Println("🛑 Run stopped. Waiting for current workflows to finish...")
+	} else if budget.spent() {
+		pterm.Success.Printf("🔁 All %d iterations started. Waiting for them to finish...\n", budget.total)
+	} else if search.done() {
+		pterm.Success.Println("🔎 Concurrency search finished. Waiting for current workflows to finish...")
+	} else {
+		pterm.Success.Println("⏱️ Run duration complete. Waiting for current workflows to finish...")
+	}
 	close(jobs)
+	disarmHardStop := armHardStop()
 
 	graceDone := make(chan struct{})
 	go func() {
 		workerWG.Wait()
 		close(graceDone)
 	}()
-	gracePeriod := defaultGracePeriod
+	gracePeriod := drainGracePeriod()
 	connectOnlyEndedAtRunEnd := 0
 	connectOnlyMarked := false
 	graceSucceeded := false
 	active := getActiveWorkflows()
-	if active == 0 {
-		<-graceDone
+	// An iteration run that got through its budget in time waits for every
+	// workflow it started.
+	if active == 0 || (budget.spent() && beforeDeadline(time.Now(), deadline)) {
+		_ = waitForGraceTimeout(graceDone, 0)
 	} else {
 		statuses := snapshotWorkflowStatuses()
 		connectOnly, nonConnect := splitWorkflowStatuses(statuses)
@@ -1736,10 +2759,13 @@ func runConcurrentWorkflows(config *Configuration, injectionConfig string, confi
 			graceReader := bufio.NewReader(os.Stdin)
 			if !waitForNonConnectCompletion(gracePeriod) {
 				_, nonConnect = splitWorkflowStatuses(snapshotWorkflowStatuses())
-				for len(nonConnect) > 0 {
+				for len(nonConnect) > 0 && !stoppedHard() {
 					pterm.Warning.Printf("Grace period of %s elapsed; %d workflow(s) still running.", formatSeconds(gracePeriod.Seconds()), len(nonConnect))
 					logWorkflowStatuses(nonConnect)
 					if !promptToContinueWaiting(graceReader, gracePeriod) {
+						if stoppedHard() {
+							break
+						}
 						connect3270.RequestShutdown()
 						pterm.Warning.Println("Shutdown requested. Waiting for workflows to stop...")
 						if waitForGraceTimeout(graceDone, gracePeriod) {
@@ -1757,7 +2783,7 @@ func runConcurrentWorkflows(config *Configuration, injectionConfig string, confi
 						break
 					}
 					_, nonConnect = splitWorkflowStatuses(snapshotWorkflowStatuses())
-					if len(nonConnect) == 0 {
+					if len(nonConnect) == 0 && !stoppedHard() {
 						logGracePeriodSuccess(gracePeriod)
 						graceSucceeded = true
 						waitForWorkersSettle(graceDone)
@@ -1789,6 +2815,12 @@ func runConcurrentWorkflows(config *Configuration, injectionConfig string, confi
 			_ = waitForGraceTimeout(graceDone, gracePeriod)
 		}
 	}
+	disarmHardStop()
+	if stoppedHard() {
+		// Give the workers a moment to record the workflows the hard stop
+		// cut off.
+		waitForWorkersSettle(graceDone)
+	}
 	storeLog("All workflows completed after runtimeDuration ended.")
 
 	avgCPU := getAverageCPUUsage()
@@ -1859,6 +2891,14 @@ func runConcurrentWorkflows(config *Configuration, injectionConfig string, confi
 			{"Average Workflow Time", fmt.Sprintf("%.2fs", avgWorkflowTime), "⏱️ Pace Setter"},
 			{"Run Duration", fmt.Sprintf("%ds", elapsed), "🛎️ Completed"},
 		}).Render()
+	printAbortSummary()
+	printStopSummary()
+	printSearchSummary()
+	printReconnectSummary()
+	finalConfig, _ := live.current()
+	printArrivalSummary(arrivalRate(finalConfig), adjustedStarted, float64(elapsed))
+	printTimingSummary()
+	mix.print(finalConfig)
 
 	summaryText := generateSummaryText(configPath, config, adjustedStarted, adjustedCompleted, finalFailed, adjustedActive, avgCPU, avgMem, avgWorkflowTime, float64(elapsed))
 	summaryFile := filepath.Join("logs", fmt.Sprintf("summary_%d.txt", os.Getpid()))
@@ -1866,8 +2906,35 @@ func runConcurrentWorkflows(config *Configuration, injectionConfig string, confi
 		pterm.Warning.Printf("Failed to save summary: %v\n", err)
 	}
 
+	if reportToURL != "" {
+		durationSum, durationCount := shardedDurationTotals()
+		_, transactionStats := timingSnapshot()
+		hostname, _ := os.Hostname()
+		postRunReport(runReport{
+			Worker:         hostname,
+			Started:        adjustedStarted,
+			Completed:      adjustedCompleted,
+			Failed:         finalFailed,
+			Active:         adjustedActive,
+			VUsers:         workerCount,
+			DurationSum:    durationSum,
+			DurationCount:  durationCount,
+			AvgCPU:         avgCPU,
+			AvgMem:         avgMem,
+			ElapsedSeconds: float64(elapsed),
+			Transactions:   transactionStats,
+			Histogram:      durationHistogram(),
+			Dropped:        atomic.LoadInt64(&droppedArrivals),
+			Aborted:        abortReasonText(),
+			Stopped:        stopReasonText(),
+		})
+	}
+
+	checkpoints.save(injectionCursor, true)
+	connect3270.DrainProcessPool()
 	storeLog("All workflows completed")
-	updateMetricsFile()
+	flushLogs()
+	reportMetrics()
 }
 
 // Helper functions for summary status
@@ -1911,6 +2978,25 @@ func generateSummaryText(configPath string, config *Configuration, finalStarted,
 	sb.WriteString(fmt.Sprintf("Average Memory Usage: %.1f%%\n", avgMem))
 	sb.WriteString(fmt.Sprintf("Average Workflow Time: %.2fs\n", avgWorkflowTime))
 	sb.WriteString(fmt.Sprintf("Run Duration: %.0fs\n", elapsed))
+	if n := atomic.LoadInt64(&totalReconnects); n > 0 {
+		sb.WriteString(fmt.Sprintf("Sessions Reconnected: %d\n", n))
+	}
+	if n := atomic.LoadInt64(&droppedArrivals); n > 0 {
+		sb.WriteString(fmt.Sprintf("Dropped Arrivals: %d\n", n))
+	}
+	if reason := abortReasonText(); reason != "" {
+		sb.WriteString(fmt.Sprintf("Run Aborted: %s\n", reason))
+	}
+	if reason := stopReasonText(); reason != "" {
+		sb.WriteString(fmt.Sprintf("Run Stopped: %s\n", reason))
+	}
+	if reason := hardStopText(); reason != "" {
+		sb.WriteString(fmt.Sprintf("Hard Stop: %s (partial results)\n", reason))
+	}
+	if currentSearch != nil {
+		sb.WriteString(fmt.Sprintf("Max Sustainable vUsers: %s\n", currentSearch.verdictText()))
+	}
+	sb.WriteString(timingSummaryText())
 	return sb.String()
 }
 
@@ -1985,15 +3071,19 @@ func infofIfBarsDisabled(format string, args ...interface{}) {
 	pterm.Info.Printf(format, args...)
 }
 
+// waitForGraceTimeout waits for done, for at most gracePeriod when it is
+// positive. A hard stop ends the wait early.
 func waitForGraceTimeout(done <-chan struct{}, gracePeriod time.Duration) bool {
-	if gracePeriod <= 0 {
-		<-done
-		return true
+	var timeout <-chan time.Time
+	if gracePeriod > 0 {
+		timeout = time.After(gracePeriod)
 	}
 	select {
 	case <-done:
 		return true
-	case <-time.After(gracePeriod):
+	case <-timeout:
+		return false
+	case <-hardStopped():
 		return false
 	}
 }
@@ -2007,7 +3097,10 @@ func waitForCompletionWithGrace(done <-chan struct{}, gracePeriod time.Duration)
 }
 
 func waitForWorkersSettle(done <-chan struct{}) {
-	_ = waitForGraceTimeout(done, 2*time.Second)
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+	}
 }
 
 func waitForNonConnectCompletion(gracePeriod time.Duration) bool {
@@ -2016,6 +3109,9 @@ func waitForNonConnectCompletion(gracePeriod time.Duration) bool {
 	}
 	deadline := time.Now().Add(gracePeriod)
 	for {
+		if stoppedHard() {
+			return false
+		}
 		_, nonConnect := splitWorkflowStatuses(snapshotWorkflowStatuses())
 		if len(nonConnect) == 0 {
 			return true
@@ -2032,12 +3128,18 @@ func logGracePeriodSuccess(gracePeriod time.Duration) {
 }
 
 func promptToContinueWaiting(reader *bufio.Reader, gracePeriod time.Duration) bool {
+	if hardStopAfterFlag > 0 {
+		// -hardStopAfter decides when to stop waiting.
+		return !stoppedHard()
+	}
 	for {
 		pterm.Warning.Printf("Grace period of %s elapsed. Continue waiting? (y/N): ", formatSeconds(gracePeriod.Seconds()))
-		input, err := reader.ReadString('\n')
+		input, err := readLineUnlessHardStop(reader)
+		if stoppedHard() {
+			return false
+		}
 		if err != nil {
-			pterm.Warning.Printf("Failed to read grace period response: %v\n", err)
-			storeLog(fmt.Sprintf("Failed to read grace period response: %v", err))
+			schedulerLog.Warn(fmt.Sprintf("Failed to read grace period response: %v", err))
 			return false
 		}
 		input = strings.TrimSpace(strings.ToLower(input))
@@ -2052,6 +3154,26 @@ func promptToContinueWaiting(reader *bufio.Reader, gracePeriod time.Duration) bo
 	}
 }
 
+// readLineUnlessHardStop reads a line from reader, giving up when the run
+// stops hard.
+func readLineUnlessHardStop(reader *bufio.Reader) (string, error) {
+	type line struct {
+		text string
+		err  error
+	}
+	read := make(chan line, 1)
+	go func() {
+		text, err := reader.ReadString('\n')
+		read <- line{text, err}
+	}()
+	select {
+	case l := <-read:
+		return l.text, l.err
+	case <-hardStopped():
+		return "", connect3270.ErrShutdown
+	}
+}
+
 func printSingleWorkflowSummary(configPath string, config *Configuration) {
 	avgCPU := getAverageCPUUsage()
 	avgMem := getAverageMemoryUsage()
@@ -2087,6 +3209,9 @@ func printSingleWorkflowSummary(configPath string, config *Configuration) {
 			{"Average Workflow Time", fmt.Sprintf("%.2fs", avgWorkflowTime), "⏱️ Pace Setter"},
 			{"Run Duration", fmt.Sprintf("%ds", elapsed), "🛎️ Completed"},
 		}).Render()
+	printStopSummary()
+	printReconnectSummary()
+	printTimingSummary()
 
 	// Save summary to file
 	summaryText := generateSummaryText(configPath, config, finalStarted, finalCompleted, finalFailed, 0, avgCPU, avgMem, avgWorkflowTime, float64(elapsed))
@@ -2096,50 +3221,24 @@ func printSingleWorkflowSummary(configPath string, config *Configuration) {
 	}
 
 	storeLog("Workflow completed")
-	updateMetricsFile()
+	flushLogs()
+	reportMetrics()
 }
 
 func clear() {
 	print("\033[H\033[2J")
 }
 
+// getNextAvailablePort hands out the next session number. Emulators are
+// started with -scriptport 0, so nothing has to be probed.
 func getNextAvailablePort() int {
 	mutex.Lock()
 	defer mutex.Unlock()
-	const maxPort = 65000
-	checked := 0
-	for {
-		lastUsedPort++
-		if lastUsedPort > maxPort {
-			lastUsedPort = startPort
-		}
-		if isPortAvailable(lastUsedPort) {
-			return lastUsedPort
-		}
-		checked++
-		if connect3270.Verbose {
-			pterm.Warning.Printf("Port %d is taken - port party’s full!\n", lastUsedPort)
-		}
-		if checked >= (maxPort - startPort + 1) {
-			mutex.Unlock()
-			time.Sleep(100 * time.Millisecond)
-			mutex.Lock()
-			checked = 0
-		}
-	}
-}
-
-func isPortAvailable(port int) bool {
-	addr := ":" + strconv.Itoa(port)
-	ln, err := net.Listen("tcp", addr)
-	if err != nil {
-		if connect3270.Verbose {
-			pterm.Info.Printf("Port %d in use - next contestant please!\n", port)
-		}
-		return false
+	lastUsedPort++
+	if lastUsedPort > maxScriptPort {
+		lastUsedPort = startPort
 	}
-	ln.Close()
-	return true
+	return lastUsedPort
 }
 
 func min(a, b int) int {
@@ -2170,50 +3269,136 @@ func validateDelayRange(name string, dr DelayRange, allowZero bool) error {
 }
 
 func validateConfiguration(config *Configuration) error {
-	if connect3270.Verbose {
-		pterm.Info.Println("Validating config - let’s see if it’s naughty or nice!")
-	}
+	schedulerLog.Debug("Validating config - let’s see if it’s naughty or nice!")
 	if config.Host == "" {
 		return fmt.Errorf("host is empty - where’s the party at?")
 	}
-	if config.Port <= 0 {
+	if config.Port <= 0 && config.portText == "" {
 		return fmt.Errorf("port is invalid - ports cant be negative silly")
 	}
 	if config.LegacyDelay > 0 {
 		return fmt.Errorf("Delay is no longer supported; use EveryStepDelay.Min/Max instead")
 	}
-	if err := validateDelayRange("EveryStepDelay", config.EveryStepDelay, true); err != nil {
+	if err := validateTLSSettings(config); err != nil {
 		return err
 	}
-	if err := validateDelayRange("EndOfTaskDelay", config.EndOfTaskDelay, true); err != nil {
+	if err := validateProxySettings(config); err != nil {
 		return err
 	}
-	if config.OutputFilePath == "" {
-		hasScreenGrab := false
-		for _, step := range config.Steps {
-			if step.Type == "AsciiScreenGrab" {
-				hasScreenGrab = true
-				break
-			}
-		}
-		if hasScreenGrab {
-			return fmt.Errorf("output file path is empty - screen grab needs a home")
-		}
+	if err := validateSSHTunnelSettings(config); err != nil {
+		return err
 	}
-
-	for _, step := range config.Steps {
-		if step.Type == "HumanDelay" {
-			return fmt.Errorf("HumanDelay is no longer supported; use StepDelay with Min/Max instead")
-		}
-		// Allow steps that do not require additional configuration.
-		if step.Type == "Connect" ||
-			step.Type == "AsciiScreenGrab" ||
-			step.Type == "PressEnter" ||
-			step.Type == "PressTab" ||
-			step.Type == "WaitForField" ||
-			step.Type == "Disconnect" ||
-			step.Type == "StepDelay" ||
-			(strings.HasPrefix(step.Type, "PressPF")) {
+	if err := validatePrinterSettings(config); err != nil {
+		return err
+	}
+	if err := validateSessionSettings(config); err != nil {
+		return err
+	}
+	if err := validateReconnectSettings(config); err != nil {
+		return err
+	}
+	if err := validateSessionSetup(config); err != nil {
+		return err
+	}
+	if err := validateThresholds(config); err != nil {
+		return err
+	}
+	if err := validateArrivalRate(config); err != nil {
+		return err
+	}
+	if err := validateErrorBudget(config); err != nil {
+		return err
+	}
+	if err := validateTransactions(config.Steps); err != nil {
+		return err
+	}
+	if stepsUseTransaction(config.OnError) {
+		return fmt.Errorf("Transaction is not allowed on OnError steps")
+	}
+	if err := validateLUSettings(config); err != nil {
+		return err
+	}
+	if config.CodePage != "" && !connect3270.ValidCodePage(config.CodePage) {
+		return fmt.Errorf("CodePage %q is unknown - try cp037, cp273 (german), cp500 (belgian), bracket or another x3270 code page", config.CodePage)
+	}
+	if err := validateBackendSettings(config); err != nil {
+		return err
+	}
+	if err := validateDelayRange("EveryStepDelay", config.EveryStepDelay, true); err != nil {
+		return err
+	}
+	if err := validateDelayRange("EndOfTaskDelay", config.EndOfTaskDelay, true); err != nil {
+		return err
+	}
+	if config.OutputFilePath == "" && (stepsNeedOutputFile(config.Steps) || stepsNeedOutputFile(config.OnError) ||
+		stepsNeedOutputFile(config.SessionSetup) || stepsNeedOutputFile(config.SessionTeardown)) {
+		return fmt.Errorf("output file path is empty - screen grab needs a home")
+	}
+	definedVars := make(map[string]bool)
+	if err := validateSteps(config.SessionSetup, definedVars); err != nil {
+		return fmt.Errorf("SessionSetup: %w", err)
+	}
+	if err := validateSteps(config.Steps, definedVars); err != nil {
+		return err
+	}
+	if err := validateSteps(config.OnError, definedVars); err != nil {
+		return fmt.Errorf("OnError: %w", err)
+	}
+	if err := validateSteps(config.SessionTeardown, definedVars); err != nil {
+		return fmt.Errorf("SessionTeardown: %w", err)
+	}
+	return validateSuite(config)
+}
+
+// stepsNeedOutputFile reports whether any step, including those nested in If
+// blocks, writes to the workflow output file.
+func stepsNeedOutputFile(steps []Step) bool {
+	for _, step := range steps {
+		if step.Type == "AsciiScreenGrab" || (step.Type == "JSONScreenGrab" && step.File == "") {
+			return true
+		}
+		if stepsNeedOutputFile(step.Steps) || stepsNeedOutputFile(step.Else) {
+			return true
+		}
+	}
+	return false
+}
+
+// validateSteps checks a step list in order; definedVars collects the names
+// set by ExtractValue so later {{var:name}} references can be verified.
+func validateSteps(steps []Step, definedVars map[string]bool) error {
+	for _, step := range steps {
+		if step.Type != "If" && (step.Condition != nil || len(step.Steps) > 0 || len(step.Else) > 0) {
+			return fmt.Errorf("Condition, Steps and Else are only allowed on If steps, not %s", step.Type)
+		}
+		for _, name := range referencedVars(step.Text) {
+			if !definedVars[name] {
+				return fmt.Errorf("{{var:%s}} used in %s step before an ExtractValue step sets it", name, step.Type)
+			}
+		}
+		if err := validateGenerators(step.Text); err != nil {
+			return fmt.Errorf("%s step: %w", step.Type, err)
+		}
+		if step.Type == "HumanDelay" {
+			return fmt.Errorf("HumanDelay is no longer supported; use StepDelay with Min/Max instead")
+		}
+		// Allow steps that do not require additional configuration.
+		if step.Type == "Connect" ||
+			step.Type == "AsciiScreenGrab" ||
+			step.Type == "JSONScreenGrab" ||
+			step.Type == "PressEnter" ||
+			step.Type == "PressTab" ||
+			step.Type == "WaitForField" ||
+			step.Type == "Disconnect" ||
+			step.Type == "StepDelay" ||
+			(strings.HasPrefix(step.Type, "PressPF")) ||
+			step.Type == "PressPA1" ||
+			step.Type == "PressPA2" ||
+			step.Type == "PressPA3" ||
+			step.Type == "PressClear" ||
+			step.Type == "PressHome" ||
+			step.Type == "EraseEOF" ||
+			step.Type == "EraseInput" {
 			if step.Type == "StepDelay" {
 				if err := validateDelayRange("StepDelay", step.StepDelay, false); err != nil {
 					return err
@@ -2221,8 +3406,101 @@ func validateConfiguration(config *Configuration) error {
 			}
 			continue
 		}
+		if step.Type == "Include" {
+			return fmt.Errorf("Include of %s was not expanded - load the workflow from a file", step.File)
+		}
+		if step.Type == "If" {
+			if err := validateCondition(step.Condition, definedVars); err != nil {
+				return err
+			}
+			if len(step.Steps) == 0 && len(step.Else) == 0 {
+				return fmt.Errorf("If step needs Steps or Else - a branch to nowhere")
+			}
+			if err := validateSteps(step.Steps, definedVars); err != nil {
+				return err
+			}
+			if err := validateSteps(step.Else, definedVars); err != nil {
+				return err
+			}
+			continue
+		}
+		if step.Type == "FillFieldByIndex" || step.Type == "FillFieldNear" {
+			if step.Text == "" {
+				return fmt.Errorf("text empty in %s step - cat got your tongue?", step.Type)
+			}
+			if step.Type == "FillFieldByIndex" && step.Index <= 0 {
+				return fmt.Errorf("FillFieldByIndex needs an Index of 1 or more (1 is the first input field)")
+			}
+			if step.Type == "FillFieldNear" && strings.TrimSpace(step.Near) == "" {
+				return fmt.Errorf("FillFieldNear needs the Near label text to look for")
+			}
+			for _, name := range referencedVars(step.Near) {
+				if !definedVars[name] {
+					return fmt.Errorf("{{var:%s}} used in %s step before an ExtractValue step sets it", name, step.Type)
+				}
+			}
+			continue
+		}
+		if step.Type == "MoveCursor" || step.Type == "CheckCursor" {
+			if step.Coordinates.Row <= 0 || step.Coordinates.Column <= 0 {
+				return fmt.Errorf("coords missing in %s step - lost in space", step.Type)
+			}
+			continue
+		}
+		if step.Type == "CheckFieldAttributes" {
+			if step.Coordinates.Row == 0 || step.Coordinates.Column == 0 {
+				return fmt.Errorf("coords missing in CheckFieldAttributes step - lost in space")
+			}
+			if a := step.Attributes; a == nil || (a.Protected == nil && a.Hidden == nil && a.Intensified == nil && a.Numeric == nil) {
+				return fmt.Errorf("CheckFieldAttributes needs at least one of Protected, Hidden, Intensified or Numeric")
+			}
+			continue
+		}
+		if step.Type == "ExtractValue" {
+			if !workflowVarPattern.MatchString("{{var:" + step.Variable + "}}") {
+				return fmt.Errorf("ExtractValue needs a Variable name made of letters, digits, '_', '-' or '.'")
+			}
+			if step.Coordinates.Row == 0 || step.Coordinates.Column == 0 || step.Coordinates.Length <= 0 {
+				return fmt.Errorf("ExtractValue needs Row, Column and Length - can’t grab thin air")
+			}
+			definedVars[step.Variable] = true
+			continue
+		}
+		if step.Type == "CheckValueRegex" {
+			if step.Text == "" {
+				return fmt.Errorf("pattern empty in CheckValueRegex step - match what, exactly?")
+			}
+			if _, err := compileStepRegex(step.Text); err != nil {
+				return err
+			}
+			if (step.Coordinates.Row == 0) != (step.Coordinates.Column == 0) {
+				return fmt.Errorf("CheckValueRegex needs both Row and Column, or neither to match the whole screen")
+			}
+			if step.Coordinates.Row > 0 && step.Coordinates.Length <= 0 {
+				return fmt.Errorf("CheckValueRegex needs a Length when Row and Column are set")
+			}
+			continue
+		}
+		if step.Type == "WaitForPrint" {
+			if step.Timeout < 0 {
+				return fmt.Errorf("WaitForPrint Timeout cannot be negative")
+			}
+			continue
+		}
+		if step.Type == "WaitForText" {
+			if step.Text == "" {
+				return fmt.Errorf("text empty in WaitForText step - waiting for nothing takes forever")
+			}
+			if (step.Coordinates.Row == 0) != (step.Coordinates.Column == 0) {
+				return fmt.Errorf("WaitForText needs both Row and Column, or neither to search the whole screen")
+			}
+			if step.Timeout < 0 {
+				return fmt.Errorf("WaitForText Timeout cannot be negative")
+			}
+			continue
+		}
 		// Steps that require coordinates and text.
-		if step.Type == "CheckValue" || step.Type == "FillString" {
+		if step.Type == "CheckValue" || step.Type == "FillString" || step.Type == "FillBlock" {
 			if step.Coordinates.Row == 0 || step.Coordinates.Column == 0 {
 				return fmt.Errorf("coords missing in %s step - lost in space", step.Type)
 			}
@@ -2238,47 +3516,39 @@ func validateConfiguration(config *Configuration) error {
 }
 
 func runDashboard() {
-
-	// Serve embedded static files
-	staticFiles, err := fs.Sub(dashboardTemplateFS, "templates/static")
+	handler, err := newDashboardHandler()
 	if err != nil {
 		pterm.Error.Println("Failed to load embedded static files:", err)
 		return
 	}
-	http.Handle("/static/", http.StripPrefix("/static/", http.FileServer(http.FS(staticFiles))))
 
-	// Register the start-process endpoint
-	http.HandleFunc("/start-process", startProcessHandler)
-	http.HandleFunc("/kill", killProcessHandler) // register kill endpoint
-	http.HandleFunc("/test-connection", testConnectionHandler)
-
-	addr := fmt.Sprintf("localhost:%d", dashboardPort) // Bind to localhost
+	addr := dashboardListenAddr
 	listener, err := net.Listen("tcp", addr)
 	if err != nil {
 		//pterm.Warning.Printf("Dashboard already vibing on port %d - skipping the encore!\n", dashboardPort)
-		go func() {
-			for {
-				updateMetricsFile()
-				time.Sleep(2 * time.Second)
-			}
-		}()
+		// Another process has the dashboard; this run reports to it.
+		startMetricsReporter()
 		return
 	}
 	dashboardStarted = true
+	if dashboardTLS != nil {
+		listener = tls.NewListener(listener, dashboardTLS)
+	}
+	if err := publishDashboardCert(); err != nil {
+		dashboardLog.Warn(fmt.Sprintf("Runs in other processes cannot recognise the dashboard's certificate: %v", err))
+	}
+	if !isLoopbackAddr(addr) && dashboardAuth == nil {
+		dashboardLog.Warn(fmt.Sprintf("The dashboard listens on %s and has no login: anyone who can reach it can start and kill runs", addr))
+	}
 	//openDashboardEmbedded()
 	spinner, _ := pterm.DefaultSpinner.WithRemoveWhenDone(true).Start("Cleaning up old metrics - sweeping the floor!")
-	dashboardDir := dashboardMetricsDir()
-	files, err := filepath.Glob(filepath.Join(dashboardDir, "metrics_*.json"))
-	if err != nil {
-		spinner.Warning("Error listing old metrics - file system’s trolling:", err)
-	} else {
-		for _, f := range files {
-			if err := os.Remove(f); err != nil {
-				pterm.Warning.Printf("Failed to yeet old metrics file %s: %v\n", f, err)
-			} else {
-				//pterm.Info.Printf("Old metrics file %s gone - poof!\n", f)
-			}
-		}
+	if err := dashboardRuns.load(); err != nil {
+		spinner.Warning("Error loading the runs of the last dashboard - starting afresh:", err)
+	}
+	// Metrics files are left by versions that polled them instead.
+	files, _ := filepath.Glob(filepath.Join(dashboardMetricsDir(), "metrics_*.json"))
+	for _, f := range files {
+		os.Remove(f)
 	}
 	logFiles, err := filepath.Glob(filepath.Join("logs", "logs_*.json"))
 	if err == nil {
@@ -2292,12 +3562,60 @@ func runDashboard() {
 	}
 	spinner.Success("Cleanup done - dashboard’s fresh as a daisy!")
 
-	setupConsoleHandler()
-	setupTerminalConsoleHandler()
-	setupWorkflowPreviewHandler()
-	setupOutputPreviewHandler()
-	setupSummaryHandler()
-	http.HandleFunc("/dashboard", func(w http.ResponseWriter, r *http.Request) {
+	pterm.Info.Printf("Dashboard live at %s - check it out!\n", pterm.FgBlue.Sprint(dashboardURL()))
+	pterm.Println()
+	startMetricsReporter()
+	startScheduler()
+	if err := http.Serve(listener, handler); err != nil {
+		pterm.Error.Printf("Dashboard server crashed - send a medic: %v\n", err)
+	}
+}
+
+// newDashboardHandler routes the dashboard on a mux of its own, so that
+// what other packages register on http.DefaultServeMux, such as the pprof
+// handlers, is not served with it.
+func newDashboardHandler() (http.Handler, error) {
+	// Serve embedded static files
+	staticFiles, err := fs.Sub(dashboardTemplateFS, "templates/static")
+	if err != nil {
+		return nil, err
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/static/", http.StripPrefix("/static/", http.FileServer(http.FS(staticFiles))))
+
+	// Register the start-process endpoint
+	mux.HandleFunc("/start-process", startProcessHandler)
+	mux.HandleFunc("/kill", killProcessHandler) // register kill endpoint
+	mux.HandleFunc("/kill-job", killJobHandler)
+	mux.HandleFunc("/kill-all", killAllHandler)
+	mux.HandleFunc("/test-connection", testConnectionHandler)
+	mux.HandleFunc("/dashboard/report", dashboardReportHandler)
+	mux.HandleFunc("/dashboard/sessions", dashboardSessionsHandler)
+	mux.HandleFunc("/dashboard/screen", dashboardScreenHandler)
+	mux.HandleFunc("/dashboard/screen/stream", dashboardScreenStreamHandler)
+	mux.HandleFunc("/dashboard/failures", dashboardFailuresHandler)
+	mux.HandleFunc(dashboardAPIBase, dashboardAPIHandler)
+	mux.HandleFunc(dashboardAPIBase+"/", dashboardAPIHandler)
+	mux.HandleFunc("/dashboard/history", dashboardHistoryHandler)
+	mux.HandleFunc("/dashboard/history/run", dashboardHistoryRunHandler)
+	mux.HandleFunc("/dashboard/history/archive", dashboardHistoryArchiveHandler)
+	mux.HandleFunc("/dashboard/profiles", dashboardProfilesHandler)
+	mux.HandleFunc("/dashboard/profiles/launch", dashboardProfileLaunchHandler)
+	mux.HandleFunc("/dashboard/profiles/workflow", dashboardProfileWorkflowHandler)
+	mux.HandleFunc("/dashboard/injection/preview", dashboardInjectionPreviewHandler)
+	mux.HandleFunc("/dashboard/schedules", dashboardSchedulesHandler)
+	mux.HandleFunc("/dashboard/schedules/enable", dashboardScheduleEnableHandler)
+	mux.HandleFunc("/dashboard/schedules/run", dashboardScheduleRunHandler)
+	mux.HandleFunc("/dashboard/schedules/preview", dashboardSchedulePreviewHandler)
+	mux.HandleFunc("/healthz", healthzHandler)
+	mux.HandleFunc("/readyz", readyzHandler)
+
+	setupConsoleHandler(mux)
+	setupTerminalConsoleHandler(mux)
+	setupWorkflowPreviewHandler(mux)
+	setupOutputPreviewHandler(mux)
+	setupSummaryHandler(mux)
+	mux.HandleFunc("/dashboard", func(w http.ResponseWriter, r *http.Request) {
 		// Check if the dashboardTemplate is nil
 		if dashboardTemplate == nil {
 			pterm.Error.Println("Dashboard template is nil. Ensure the template is loaded correctly.")
@@ -2305,7 +3623,7 @@ func runDashboard() {
 			return
 		}
 
-		metricsList, extendedList := readDashboardMetrics(dashboardDir)
+		metricsList, extendedList := dashboardRuns.list()
 		metricsJSON, _ := json.Marshal(metricsList)
 		autoRefresh := r.URL.Query().Get("autoRefresh")
 		refreshPeriod := r.URL.Query().Get("refreshPeriod")
@@ -2349,6 +3667,12 @@ func runDashboard() {
 			ExtendedMetricsList             []ExtendedMetrics
 			ExtendedJSON                    string
 			Version                         string
+			// BasePath is -dashboardBasePath, which the page's URLs start with.
+			BasePath string
+			// User is who signed in, if the dashboard has a sign-in.
+			User       string
+			CanOperate bool
+			SignOut    bool
 		}{
 			ActiveWorkflows:         agg.ActiveWorkflows,
 			TotalWorkflowsStarted:   agg.TotalWorkflowsStarted,
@@ -2367,6 +3691,12 @@ func runDashboard() {
 			ExtendedMetricsList:     extendedList,
 			ExtendedJSON:            string(extendedJSON),
 			Version:                 version, // Holds the value of the const `version`
+			BasePath:                dashboardBasePath,
+			CanOperate:              true,
+		}
+		if user, ok := signedInUser(r); ok {
+			data.User, data.CanOperate = user.Name, user.canOperate()
+			data.SignOut = dashboardAuth.oidc != nil
 		}
 		// Use a buffer to write the template output first, then write it all at once
 		// This prevents partial responses from being written if the connection closes
@@ -2382,14 +3712,12 @@ func runDashboard() {
 		if _, err := buf.WriteTo(w); err != nil {
 			// Connection was closed by client, just log it without the scary message
 			// This is normal when browser refreshes or navigates away
-			if connect3270.Verbose {
-				pterm.Warning.Printf("Client closed connection during dashboard response: %v\n", err)
-			}
+			dashboardLog.Debug(fmt.Sprintf("Client closed connection during dashboard response: %v", err))
 		}
 	})
-	http.HandleFunc("/dashboard/data", func(w http.ResponseWriter, r *http.Request) {
+	mux.HandleFunc("/dashboard/data", func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Cache-Control", "no-store")
-		_, extendedList := readDashboardMetrics(dashboardDir)
+		_, extendedList := dashboardRuns.list()
 
 		// Prefer live processes for UI stats; fall back to latest snapshot if nothing running.
 		filtered := make([]ExtendedMetrics, 0, len(extendedList))
@@ -2416,17 +3744,11 @@ func runDashboard() {
 			pterm.Warning.Printf("Failed to marshal dashboard data response: %v\n", err)
 		}
 	})
-	pterm.Info.Printf("Dashboard live at %s - check it out!\n", pterm.FgBlue.Sprintf("http://localhost:%d/dashboard", dashboardPort))
-	pterm.Println()
-	go func() {
-		for {
-			updateMetricsFile()
-			time.Sleep(2 * time.Second)
-		}
-	}()
-	if err := http.Serve(listener, nil); err != nil {
-		pterm.Error.Printf("Dashboard server crashed - send a medic: %v\n", err)
+	var handler http.Handler = mux
+	if dashboardAuth != nil {
+		handler = dashboardAuth.wrap(handler)
 	}
+	return withBasePath(handler), nil
 }
 
 type Metrics struct {
@@ -2443,6 +3765,23 @@ type Metrics struct {
 	StartTimestamp          int64     `json:"startTimestamp"`
 	ConfigFilePath          string    `json:"configFilePath,omitempty"`
 	OutputFilePath          string    `json:"outputFilePath,omitempty"`
+	// Steps and Transactions hold per-step and per-transaction timings.
+	Steps        map[string]timingStat `json:"steps,omitempty"`
+	Transactions map[string]timingStat `json:"transactions,omitempty"`
+	// APIPort and APIJobs are the port of an API server and the jobs it is
+	// running, for the dashboard to cancel at APIAddr; APITLSCert is its
+	// certificate when it serves HTTPS.
+	APIPort    int            `json:"apiPort,omitempty"`
+	APIAddr    string         `json:"apiAddr,omitempty"`
+	APIJobs    []apiJobStatus `json:"apiJobs,omitempty"`
+	APITLSCert string         `json:"apiTLSCert,omitempty"`
+	// ScreenPort is where the dashboard reads the screens of the
+	// workflows running, on localhost.
+	ScreenPort int `json:"screenPort,omitempty"`
+	// FailureCategories counts the failed workflows by category, and
+	// Failures holds the latest of each for the dashboard to drill into.
+	FailureCategories map[string]int64 `json:"failureCategories,omitempty"`
+	Failures          []failureRecord  `json:"failures,omitempty"`
 }
 
 type ExtendedMetrics struct {
@@ -2486,16 +3825,16 @@ func shouldCleanupMetric(m ExtendedMetrics, modTime time.Time) bool {
 	return time.Since(modTime) > 10*time.Minute
 }
 
-func cleanupProcessArtifacts(pid int, metricsFile string) {
-	if metricsFile != "" {
-		if err := os.Remove(metricsFile); err != nil && !os.IsNotExist(err) {
-			pterm.Warning.Printf("Failed to remove stale metrics file %s for pid %d: %v\n", metricsFile, pid, err)
-		}
-	}
+func cleanupProcessArtifacts(pid int) {
 	logFilePath := filepath.Join("logs", fmt.Sprintf("logs_%d.json", pid))
 	if err := os.Remove(logFilePath); err != nil && !os.IsNotExist(err) {
 		pterm.Warning.Printf("Failed to remove stale log file %s for pid %d: %v\n", logFilePath, pid, err)
 	}
+	os.Remove(dashboardAPIKeyPath(pid))
+	os.Remove(dashboardScreenKeyPath(pid))
+	for i := 1; i <= logFileMaxBackups; i++ {
+		os.Remove(rotatedLogPath(logFilePath, i))
+	}
 }
 
 func dashboardMetricsDir() string {
@@ -2507,48 +3846,6 @@ func dashboardMetricsDir() string {
 	return filepath.Join(configDir, "3270Connect", "dashboard")
 }
 
-func readDashboardMetrics(baseDir string) ([]Metrics, []ExtendedMetrics) {
-	files, err := filepath.Glob(filepath.Join(baseDir, "metrics_*.json"))
-	if err != nil {
-		pterm.Warning.Printf("Error listing metrics files from %s: %v\n", baseDir, err)
-		return nil, nil
-	}
-	var metricsList []Metrics
-	var extendedList []ExtendedMetrics
-	for _, f := range files {
-		fi, err := os.Stat(f)
-		if err != nil {
-			if os.IsNotExist(err) {
-				continue
-			}
-			pterm.Warning.Printf("Stat on metrics file %s failed: %v\n", f, err)
-			continue
-		}
-
-		data, err := os.ReadFile(f)
-		if err != nil {
-			if os.IsNotExist(err) {
-				continue
-			}
-			pterm.Warning.Printf("Error reading metrics file %s: %v\n", f, err)
-			continue
-		}
-		var m Metrics
-		if err := json.Unmarshal(data, &m); err != nil {
-			pterm.Warning.Printf("Error unmarshaling metrics %s: %v\n", f, err)
-			continue
-		}
-		extendedMetric := m.extend()
-		if shouldCleanupMetric(extendedMetric, fi.ModTime()) {
-			cleanupProcessArtifacts(extendedMetric.PID, f)
-			continue
-		}
-		metricsList = append(metricsList, m)
-		extendedList = append(extendedList, extendedMetric)
-	}
-	return metricsList, extendedList
-}
-
 func aggregateExtendedMetrics(metrics []ExtendedMetrics) Metrics {
 	var agg Metrics
 	for _, metric := range metrics {
@@ -2563,7 +3860,10 @@ func aggregateExtendedMetrics(metrics []ExtendedMetrics) Metrics {
 	return agg
 }
 
-func updateMetricsFile() {
+// reportMetrics reports the metrics of this run to the dashboard: in
+// process when this run hosts it, saving them with the other runs, or
+// to the dashboard of another process.
+func reportMetrics() {
 	metricsMutex.Lock()
 	cpuCopy := make([]float64, len(cpuHistory))
 	copy(cpuCopy, cpuHistory)
@@ -2578,13 +3878,13 @@ func updateMetricsFile() {
 
 	// Fallback sampling in case monitorSystemUsage hasn't populated history yet.
 	if len(cpuCopy) == 0 {
-		if cpuPercents, err := cpu.Percent(0, false); err == nil && len(cpuPercents) > 0 {
-			cpuCopy = append(cpuCopy, cpuPercents[0])
+		if cpuPercent, ok := sampleCPUPercent(); ok {
+			cpuCopy = append(cpuCopy, cpuPercent)
 		}
 	}
 	if len(memCopy) == 0 {
-		if memStats, err := mem.VirtualMemory(); err == nil && memStats != nil {
-			memCopy = append(memCopy, memStats.UsedPercent)
+		if memPercent, ok := sampleMemPercent(); ok {
+			memCopy = append(memCopy, memPercent)
 		}
 	}
 
@@ -2606,6 +3906,7 @@ func updateMetricsFile() {
 			outputPath = absPath
 		}
 	}
+	stepStats, transactionStats := timingSnapshot()
 	metrics := Metrics{
 		PID:                     pid,
 		ActiveWorkflows:         getActiveWorkflows(),
@@ -2625,80 +3926,29 @@ func updateMetricsFile() {
 		}(),
 		ConfigFilePath: configPath,
 		OutputFilePath: outputPath,
+		Steps:          stepStats,
+		Transactions:   transactionStats,
 	}
-
-	// Process extended metrics by using the extend() method on metrics.
-	extendedMetrics := metrics.extend()
-
-	data, err := json.Marshal(extendedMetrics)
-	if err != nil {
-		pterm.Warning.Printf("Extended metrics marshaling failed for pid %d - JSON’s sulking: %v\n", pid, err)
-		return
-	}
-	dashboardDir := dashboardMetricsDir()
-	os.MkdirAll(dashboardDir, 0755)
-	filePath := filepath.Join(dashboardDir, fmt.Sprintf("metrics_%d.json", pid))
-	if err := os.WriteFile(filePath, data, 0644); err != nil {
-		pterm.Warning.Printf("Metrics file write failed for pid %d - disk’s grumpy: %v\n", pid, err)
+	metrics.FailureCategories, metrics.Failures = failureSnapshot()
+	if runAPI {
+		metrics.APIPort, metrics.APIAddr = apiPort, localAddr(apiListenAddr)
+		metrics.APIJobs, metrics.APITLSCert = apiJobs.active(), apiTLSCertPath()
 	}
-	maybeCleanupDashboardArtifacts()
-}
+	metrics.ScreenPort = screenServerPort()
 
-func aggregateMetrics() Metrics {
-	dashboardDir, err := os.UserConfigDir()
-	if err != nil {
-		pterm.Warning.Println("User config dir fetch failed:", err)
-		dashboardDir = filepath.Join(".", "dashboard")
-	} else {
-		dashboardDir = filepath.Join(dashboardDir, "3270Connect", "dashboard")
+	if dashboardStarted {
+		dashboardRuns.report(metrics)
+		if err := dashboardRuns.save(); err != nil {
+			pterm.Warning.Printf("Saving the dashboard's runs failed - disk’s grumpy: %v\n", err)
+		}
+		return
 	}
-	files, err := filepath.Glob(filepath.Join(dashboardDir, "metrics_*.json"))
+	snapshot, err := json.Marshal(metrics)
 	if err != nil {
-		pterm.Warning.Println("Metrics files listing failed:", err)
-		return Metrics{}
-	}
-	var agg Metrics
-	for _, f := range files {
-		// Check if file exists before attempting to read it
-		fi, err := os.Stat(f)
-		if err != nil {
-			if os.IsNotExist(err) {
-				continue
-			}
-			pterm.Warning.Printf("Stat on metrics file %s failed: %v\n", f, err)
-			continue
-		}
-
-		data, err := os.ReadFile(f)
-		if err != nil {
-			// File may have been deleted between Stat and ReadFile, silently continue
-			if os.IsNotExist(err) {
-				continue
-			}
-			pterm.Warning.Printf("Reading file %s failed: %v\n", f, err)
-			continue
-		}
-		var m Metrics
-		if err := json.Unmarshal(data, &m); err != nil {
-			pterm.Warning.Printf("Unmarshaling file %s failed: %v\n", f, err)
-			continue
-		}
-		extendedMetric := m.extend()
-		if shouldCleanupMetric(extendedMetric, fi.ModTime()) {
-			cleanupProcessArtifacts(extendedMetric.PID, f)
-			continue
-		}
-		agg.TotalWorkflowsStarted += extendedMetric.TotalWorkflowsStarted
-		agg.TotalWorkflowsCompleted += extendedMetric.TotalWorkflowsCompleted
-		agg.TotalWorkflowsFailed += extendedMetric.TotalWorkflowsFailed
-		agg.ActiveWorkflows += extendedMetric.ActiveWorkflows
-		agg.Durations = append(agg.Durations, extendedMetric.Durations...)
-		agg.CPUUsage = append(agg.CPUUsage, extendedMetric.CPUUsage...)
-		agg.MemoryUsage = append(agg.MemoryUsage, extendedMetric.MemoryUsage...)
-		agg.RuntimeDuration = extendedMetric.RuntimeDuration // Keep last or overwrite
-		agg.StartTimestamp = extendedMetric.StartTimestamp
+		pterm.Warning.Printf("Metrics marshaling failed for pid %d - JSON’s sulking: %v\n", pid, err)
+		return
 	}
-	return agg
+	deliverMetrics(pid, snapshot)
 }
 
 func (m Metrics) extend() ExtendedMetrics {
@@ -2738,13 +3988,7 @@ func monitorSystemUsage() {
 	defer ticker.Stop()
 
 	for range ticker.C {
-		cpuPercents, err := cpu.Percent(0, false)
-		if err == nil && len(cpuPercents) > 0 {
-			var sum float64
-			for _, p := range cpuPercents {
-				sum += p
-			}
-			overall := sum / float64(len(cpuPercents))
+		if overall, ok := sampleCPUPercent(); ok {
 			metricsMutex.Lock()
 			appendLimitedFloat(&cpuHistory, overall, cpuHistoryLimit)
 			totalCPUUsage += overall
@@ -2752,150 +3996,116 @@ func monitorSystemUsage() {
 			lastCPUUsage = overall
 			metricsMutex.Unlock()
 		}
-		memStats, err := mem.VirtualMemory()
-		if err == nil && memStats != nil {
+		if memPercent, ok := sampleMemPercent(); ok {
 			metricsMutex.Lock()
-			appendLimitedFloat(&memHistory, memStats.UsedPercent, memHistoryLimit)
-			totalMemUsage += memStats.UsedPercent
+			appendLimitedFloat(&memHistory, memPercent, memHistoryLimit)
+			totalMemUsage += memPercent
 			totalMemSamples++
-			lastMemUsage = memStats.UsedPercent
+			lastMemUsage = memPercent
 			metricsMutex.Unlock()
 		}
-
-		// Keep dashboard system interface metrics fresh even if the dashboard update loop isn't running.
-		updateMetricsFile()
 	}
 }
 
-func setupConsoleHandler() {
-	http.HandleFunc("/console", func(w http.ResponseWriter, r *http.Request) {
-		pidFilter := r.URL.Query().Get("pid")
-		var filtered []LogEntry
-		if pidFilter != "" {
-			logFilePath := filepath.Join("logs", fmt.Sprintf("logs_%s.json", pidFilter))
-			file, err := os.Open(logFilePath)
-			if err != nil {
-				if os.IsNotExist(err) {
-					w.Header().Set("Content-Type", "application/json")
-					w.WriteHeader(http.StatusOK)
-					json.NewEncoder(w).Encode([]LogEntry{})
-					return
-				}
-				pterm.Warning.Printf("Log file opening failed for PID %s: %v\n", pidFilter, err)
-				http.Error(w, "Error opening log file", http.StatusInternalServerError)
-				return
+// startMetricsReporter launches the single background loop that keeps the
+// dashboard's view of this process current. Safe to call more than once.
+func startMetricsReporter() {
+	metricsReporterOnce.Do(func() {
+		go func() {
+			ticker := time.NewTicker(metricsReportInterval)
+			defer ticker.Stop()
+			for range ticker.C {
+				reportMetrics()
 			}
-			defer file.Close()
-			decoder := json.NewDecoder(file)
-			for {
-				var logEntry LogEntry
-				if err := decoder.Decode(&logEntry); err != nil {
-					if err == io.EOF {
-						break
-					}
-					pterm.Warning.Println("Log entry decoding failed:", err)
-					http.Error(w, "Error decoding log entry", http.StatusInternalServerError)
-					return
+		}()
+	})
+}
+
+// errLogDecode is the error of a log file that does not decode.
+var errLogDecode = errors.New("Error decoding log entry")
+
+// loadLogEntries reads the log of process pid, or of every process when
+// pid is empty, newest first. A process without a log has no entries. A
+// log that does not decode fails the read of one process, and is skipped
+// when reading all of them.
+func loadLogEntries(pid string) ([]LogEntry, error) {
+	var entries []LogEntry
+	if pid != "" {
+		logFilePath := filepath.Join("logs", fmt.Sprintf("logs_%s.json", pid))
+		file, err := os.Open(logFilePath)
+		if err != nil {
+			if os.IsNotExist(err) {
+				return []LogEntry{}, nil
+			}
+			pterm.Warning.Printf("Log file opening failed for PID %s: %v\n", pid, err)
+			return nil, errors.New("Error opening log file")
+		}
+		defer file.Close()
+		decoder := json.NewDecoder(file)
+		for {
+			var logEntry LogEntry
+			if err := decoder.Decode(&logEntry); err != nil {
+				if err == io.EOF {
+					break
 				}
-				filtered = append(filtered, logEntry)
+				pterm.Warning.Println("Log entry decoding failed:", err)
+				return nil, errLogDecode
 			}
-		} else {
-			logFiles, err := filepath.Glob(filepath.Join("logs", "logs_*.json"))
-			if err == nil {
-				for _, lf := range logFiles {
-					file, err := os.Open(lf)
-					if err != nil {
-						pterm.Warning.Printf("Log file %s opening failed: %v\n", lf, err)
-						continue
-					}
-					func() {
-						defer file.Close()
-						decoder := json.NewDecoder(file)
-						for {
-							var logEntry LogEntry
-							if err := decoder.Decode(&logEntry); err != nil {
-								if err == io.EOF {
-									break
-								}
-								pterm.Warning.Println("Log entry decoding failed:", err)
-								break // Exit decoding loop on error
+			entries = append(entries, logEntry)
+		}
+	} else {
+		logFiles, err := filepath.Glob(filepath.Join("logs", "logs_*.json"))
+		if err == nil {
+			for _, lf := range logFiles {
+				file, err := os.Open(lf)
+				if err != nil {
+					pterm.Warning.Printf("Log file %s opening failed: %v\n", lf, err)
+					continue
+				}
+				func() {
+					defer file.Close()
+					decoder := json.NewDecoder(file)
+					for {
+						var logEntry LogEntry
+						if err := decoder.Decode(&logEntry); err != nil {
+							if err == io.EOF {
+								break
 							}
-							filtered = append(filtered, logEntry)
+							pterm.Warning.Println("Log entry decoding failed:", err)
+							break // Exit decoding loop on error
 						}
-					}()
-				}
+						entries = append(entries, logEntry)
+					}
+				}()
 			}
 		}
-		sort.Slice(filtered, func(i, j int) bool {
-			return filtered[i].Timestamp.After(filtered[j].Timestamp)
-		})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Timestamp.After(entries[j].Timestamp)
+	})
+	return entries, nil
+}
+
+func setupConsoleHandler(mux *http.ServeMux) {
+	mux.HandleFunc("/console", func(w http.ResponseWriter, r *http.Request) {
+		filtered, err := loadLogEntries(r.URL.Query().Get("pid"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusOK)
 		json.NewEncoder(w).Encode(filtered)
 	})
 }
 
-func setupTerminalConsoleHandler() {
-	http.HandleFunc("/terminal-console", func(w http.ResponseWriter, r *http.Request) {
-		pidFilter := r.URL.Query().Get("pid")
-		var filtered []LogEntry
-		if pidFilter != "" {
-			logFilePath := filepath.Join("logs", fmt.Sprintf("logs_%s.json", pidFilter))
-			file, err := os.Open(logFilePath)
-			if err != nil {
-				if os.IsNotExist(err) {
-					w.Header().Set("Content-Type", "text/plain")
-					w.WriteHeader(http.StatusOK)
-					return
-				}
-				pterm.Warning.Printf("Log file opening failed for PID %s: %v\n", pidFilter, err)
-				http.Error(w, "Error opening log file", http.StatusInternalServerError)
-				return
-			}
-			defer file.Close()
-			decoder := json.NewDecoder(file)
-			for {
-				var logEntry LogEntry
-				if err := decoder.Decode(&logEntry); err != nil {
-					if err == io.EOF {
-						break
-					}
-					pterm.Warning.Println("Log entry decoding failed:", err)
-					http.Error(w, "Error decoding log entry", http.StatusInternalServerError)
-					return
-				}
-				filtered = append(filtered, logEntry)
-			}
-		} else {
-			logFiles, err := filepath.Glob(filepath.Join("logs", "logs_*.json"))
-			if err == nil {
-				for _, lf := range logFiles {
-					file, err := os.Open(lf)
-					if err != nil {
-						pterm.Warning.Printf("Log file %s opening failed: %v\n", lf, err)
-						continue
-					}
-					func() {
-						defer file.Close()
-						decoder := json.NewDecoder(file)
-						for {
-							var logEntry LogEntry
-							if err := decoder.Decode(&logEntry); err != nil {
-								if err == io.EOF {
-									break
-								}
-								pterm.Warning.Println("Log entry decoding failed:", err)
-								break // Exit decoding loop on error
-							}
-							filtered = append(filtered, logEntry)
-						}
-					}()
-				}
-			}
+func setupTerminalConsoleHandler(mux *http.ServeMux) {
+	mux.HandleFunc("/terminal-console", func(w http.ResponseWriter, r *http.Request) {
+		filtered, err := loadLogEntries(r.URL.Query().Get("pid"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
 		}
-		sort.Slice(filtered, func(i, j int) bool {
-			return filtered[i].Timestamp.After(filtered[j].Timestamp)
-		})
 		w.Header().Set("Content-Type", "text/plain")
 		w.WriteHeader(http.StatusOK)
 		for _, entry := range filtered {
@@ -2904,13 +4114,17 @@ func setupTerminalConsoleHandler() {
 	})
 }
 
-func setupWorkflowPreviewHandler() {
-	http.HandleFunc("/dashboard/workflow", func(w http.ResponseWriter, r *http.Request) {
+func setupWorkflowPreviewHandler(mux *http.ServeMux) {
+	mux.HandleFunc("/dashboard/workflow", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead && r.Method != http.MethodPut {
+			http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+			return
+		}
 		pid := r.URL.Query().Get("pid")
 		metric, err := loadExtendedMetricByPID(pid)
 		if err != nil {
 			if os.IsNotExist(err) {
-				http.Error(w, "No metrics file found for PID "+pid, http.StatusNotFound)
+				http.Error(w, "No run reported with PID "+pid, http.StatusNotFound)
 			} else {
 				http.Error(w, "Unable to load metrics: "+err.Error(), http.StatusInternalServerError)
 			}
@@ -2921,7 +4135,11 @@ func setupWorkflowPreviewHandler() {
 			http.Error(w, "Workflow configuration is not available for PID "+pid, http.StatusNotFound)
 			return
 		}
-		file, err := os.Open(configPath)
+		if r.Method == http.MethodPut {
+			saveRunWorkflow(w, r, configPath)
+			return
+		}
+		data, err := os.ReadFile(configPath)
 		if err != nil {
 			if os.IsNotExist(err) {
 				http.Error(w, "Workflow file not found: "+configPath, http.StatusNotFound)
@@ -2930,23 +4148,18 @@ func setupWorkflowPreviewHandler() {
 			}
 			return
 		}
-		defer file.Close()
-		w.Header().Set("Content-Type", "application/json")
-		w.Header().Set("Cache-Control", "no-store")
-		w.Header().Set("X-Content-Type-Options", "nosniff")
-		if _, err := io.Copy(w, file); err != nil {
-			http.Error(w, "Failed to stream workflow file: "+err.Error(), http.StatusInternalServerError)
-		}
+		writeEditedWorkflow(w, data)
 	})
+	mux.HandleFunc("/dashboard/workflow/validate", dashboardWorkflowValidateHandler)
 }
 
-func setupOutputPreviewHandler() {
-	http.HandleFunc("/dashboard/output", func(w http.ResponseWriter, r *http.Request) {
+func setupOutputPreviewHandler(mux *http.ServeMux) {
+	mux.HandleFunc("/dashboard/output", func(w http.ResponseWriter, r *http.Request) {
 		pid := r.URL.Query().Get("pid")
 		metric, err := loadExtendedMetricByPID(pid)
 		if err != nil {
 			if os.IsNotExist(err) {
-				http.Error(w, "No metrics file found for PID "+pid, http.StatusNotFound)
+				http.Error(w, "No run reported with PID "+pid, http.StatusNotFound)
 			} else {
 				http.Error(w, "Unable to load metrics: "+err.Error(), http.StatusInternalServerError)
 			}
@@ -2976,8 +4189,8 @@ func setupOutputPreviewHandler() {
 	})
 }
 
-func setupSummaryHandler() {
-	http.HandleFunc("/dashboard/summary", func(w http.ResponseWriter, r *http.Request) {
+func setupSummaryHandler(mux *http.ServeMux) {
+	mux.HandleFunc("/dashboard/summary", func(w http.ResponseWriter, r *http.Request) {
 		pid := r.URL.Query().Get("pid")
 		summaryFile := filepath.Join("logs", fmt.Sprintf("summary_%s.txt", pid))
 		file, err := os.Open(summaryFile)
@@ -2995,15 +4208,13 @@ func loadExtendedMetricByPID(pid string) (*ExtendedMetrics, error) {
 	if pid == "" {
 		return nil, fmt.Errorf("missing pid")
 	}
-	dir := dashboardMetricsDir()
-	filePath := filepath.Join(dir, fmt.Sprintf("metrics_%s.json", pid))
-	data, err := os.ReadFile(filePath)
+	n, err := strconv.Atoi(pid)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("invalid pid %q", pid)
 	}
-	var metric ExtendedMetrics
-	if err := json.Unmarshal(data, &metric); err != nil {
-		return nil, err
+	metric, ok := dashboardRuns.get(n)
+	if !ok {
+		return nil, os.ErrNotExist
 	}
 	return &metric, nil
 }
@@ -3015,23 +4226,28 @@ func getActiveWorkflows() int {
 }
 
 func showErrors() {
-	errorMutex.Lock()
-	defer errorMutex.Unlock()
-	if len(errorList) == 0 {
+	errorCount := errorBreakdown()
+	if len(errorCount) == 0 {
 		pterm.Println()
 		pterm.Info.Println("No errors encountered during the workflows.")
 		return
 	}
 
 	pterm.Error.Println("Errors Summary:")
+	for errMsg, count := range errorCount {
+		pterm.Error.Printf("%d occurrence(s) of: %s\n", count, errMsg)
+	}
+}
+
+// errorBreakdown counts the errors of the run by message.
+func errorBreakdown() map[string]int {
+	errorMutex.Lock()
+	defer errorMutex.Unlock()
 	errorCount := make(map[string]int)
 	for _, err := range errorList {
 		errorCount[err.Error()]++
 	}
-
-	for errMsg, count := range errorCount {
-		pterm.Error.Printf("%d occurrence(s) of: %s\n", count, errMsg)
-	}
+	return errorCount
 }
 
 func handleError(err error, message string) error {
@@ -3064,8 +4280,7 @@ func startProcessHandler(w http.ResponseWriter, r *http.Request) {
 		executablePath := getExecutablePath()
 		command := fmt.Sprintf("%s -runApp %s -runApp-port %s", executablePath, runApp, runAppPort)
 		go func() {
-			pterm.Info.Printf("Executing sample app command: %s\n", command)
-			storeLog("Executing sample app command: " + command)
+			apiLog.Info("Executing sample app command: " + command)
 			// Adjust for OS differences if needed
 			commandParts := strings.Fields(command)
 			executable := commandParts[0]
@@ -3075,7 +4290,7 @@ func startProcessHandler(w http.ResponseWriter, r *http.Request) {
 			cmd.Stdout = os.Stdout
 			cmd.Stderr = os.Stderr
 			if err := cmd.Run(); err != nil {
-				pterm.Error.Printf("Failed to execute sample app command: %v\n", err)
+				apiLog.Error(fmt.Sprintf("Failed to execute sample app command: %v", err))
 			}
 		}()
 		storeLog("Sample app started successfully")
@@ -3090,137 +4305,25 @@ func startProcessHandler(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Failed to parse form data", http.StatusBadRequest)
 		return
 	}
-
-	file, handler, err := r.FormFile("configFile")
-	if err != nil {
-		http.Error(w, "Failed to retrieve file", http.StatusBadRequest)
-		return
-	}
-	defer file.Close()
-
-	fileBytes, err := io.ReadAll(file)
+	profile, err := launchProfileFromForm(r)
 	if err != nil {
-		http.Error(w, "Failed to read configuration file", http.StatusInternalServerError)
-		return
-	}
-
-	var config Configuration
-	if err := json.Unmarshal(fileBytes, &config); err != nil {
-		storeLog("Failed to parse configuration JSON: " + err.Error())
-		http.Error(w, "Invalid configuration file", http.StatusBadRequest)
+		http.Error(w, err.Error(), launchStatus(err))
 		return
 	}
-
-	if override := strings.TrimSpace(r.FormValue("overrideHost")); override != "" {
-		config.Host = override
-	}
-	if override := strings.TrimSpace(r.FormValue("overridePort")); override != "" {
-		portValue, convErr := strconv.Atoi(override)
-		if convErr != nil {
-			http.Error(w, "Invalid port override", http.StatusBadRequest)
-			return
-		}
-		config.Port = portValue
-	}
-	if override := strings.TrimSpace(r.FormValue("overrideOutputFilePath")); override != "" {
-		config.OutputFilePath = override
-	}
-	if override := strings.TrimSpace(r.FormValue("overrideRampUpBatchSize")); override != "" {
-		batchValue, convErr := strconv.Atoi(override)
-		if convErr != nil {
-			http.Error(w, "Invalid ramp up batch size override", http.StatusBadRequest)
-			return
-		}
-		config.RampUpBatchSize = batchValue
-	}
-	if override := strings.TrimSpace(r.FormValue("overrideRampUpDelay")); override != "" {
-		delayValue, convErr := strconv.ParseFloat(override, 64)
-		if convErr != nil {
-			http.Error(w, "Invalid ramp up delay override", http.StatusBadRequest)
-			return
+	// A profile name saves the form too, to start the same run again.
+	if name := strings.TrimSpace(r.FormValue("saveProfile")); name != "" {
+		if name, err = profileName(name); err == nil {
+			_, err = saveLaunchProfile(r, name, profile)
 		}
-		config.RampUpDelay = delayValue
-	}
-
-	if err := validateConfiguration(&config); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
-		return
-	}
-
-	updatedJSON, err := json.MarshalIndent(config, "", "  ")
-	if err != nil {
-		http.Error(w, "Failed to serialize configuration", http.StatusInternalServerError)
-		return
-	}
-
-	tempFilePath := filepath.Join(os.TempDir(), handler.Filename)
-	if err := os.WriteFile(tempFilePath, updatedJSON, 0644); err != nil {
-		http.Error(w, "Failed to save file", http.StatusInternalServerError)
-		return
-	}
-
-	// Retrieve the injection configuration file (optional)
-	var injectionConfigPath string
-	injectionFile, injectionHandler, err := r.FormFile("injectionConfig")
-	if err == nil {
-		defer injectionFile.Close()
-		injectionConfigPath = filepath.Join(os.TempDir(), injectionHandler.Filename)
-		injectionTempFile, err := os.Create(injectionConfigPath)
 		if err != nil {
-			http.Error(w, "Failed to save injection configuration file", http.StatusInternalServerError)
+			http.Error(w, err.Error(), launchStatus(err))
 			return
 		}
-		defer injectionTempFile.Close()
-
-		if _, err := io.Copy(injectionTempFile, injectionFile); err != nil {
-			http.Error(w, "Failed to save injection configuration file", http.StatusInternalServerError)
-			return
-		}
-	}
-
-	// Retrieve other form fields
-	concurrent := r.FormValue("concurrent")
-	runtime := r.FormValue("runtime")
-	startPort := r.FormValue("startPort")
-	headless := r.FormValue("headless") == "on" // use "on" for checked
-	tokenValue := strings.TrimSpace(r.FormValue("token"))
-
-	commandArgs := []string{
-		getExecutablePath(),
-		"-config", tempFilePath,
-		"-concurrent", concurrent,
-		"-runtime", runtime,
-		"-startPort", startPort,
 	}
-	if headless {
-		commandArgs = append(commandArgs, "-headless")
-	}
-	if injectionConfigPath != "" {
-		commandArgs = append(commandArgs, "-injectionConfig", injectionConfigPath)
-	}
-	if tokenValue != "" {
-		commandArgs = append(commandArgs, "-token", tokenValue)
-	}
-
-	maskedArgs := make([]string, len(commandArgs))
-	copy(maskedArgs, commandArgs)
-	for i := 0; i < len(maskedArgs); i++ {
-		if maskedArgs[i] == "-token" && i+1 < len(maskedArgs) {
-			maskedArgs[i+1] = "[REDACTED]"
-		}
+	if _, err := launch(profile, strings.TrimSpace(r.FormValue("token")), nil); err != nil {
+		http.Error(w, err.Error(), launchStatus(err))
+		return
 	}
-	commandForLog := strings.Join(maskedArgs, " ")
-	storeLog("Command to execute: " + commandForLog)
-	go func(args []string, logCommand string) {
-		pterm.Info.Printf("Executing command: %s\n", logCommand)
-
-		cmd := exec.Command(args[0], args[1:]...)
-		cmd.Stdout = os.Stdout
-		cmd.Stderr = os.Stderr
-		if err := cmd.Run(); err != nil {
-			pterm.Error.Printf("Failed to execute command: %v\n", err)
-		}
-	}(commandArgs, commandForLog)
 	storeLog("Process started successfully")
 	w.WriteHeader(http.StatusOK)
 	w.Write([]byte("Process started successfully"))
@@ -3304,16 +4407,26 @@ func killProcessHandler(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Invalid PID", http.StatusBadRequest)
 		return
 	}
+	if status, err := killRun(pid); err != nil {
+		http.Error(w, err.Error(), status)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("Process killed successfully"))
+}
+
+// killRun kills the process of pid, hard if need be, and clears its
+// workflows in flight. On failure it gives the HTTP status to answer with.
+func killRun(pid int) (int, error) {
+	pidStr := strconv.Itoa(pid)
 	proc, err := os.FindProcess(pid)
 	if err != nil {
 		storeLog("Process not found: " + pidStr)
-		http.Error(w, "Process not found", http.StatusNotFound)
-		return
+		return http.StatusNotFound, errors.New("Process not found")
 	}
 	if pid == os.Getpid() {
 		storeLog("Attempting to kill the dashboard process itself")
-		http.Error(w, "Cannot kill the dashboard process itself", http.StatusForbidden)
-		return
+		return http.StatusForbidden, errors.New("Cannot kill the dashboard process itself")
 	}
 	if err := proc.Kill(); err != nil {
 		storeLog("Failed to kill process gracefully, attempting hard kill for PID: " + pidStr)
@@ -3325,71 +4438,19 @@ func killProcessHandler(w http.ResponseWriter, r *http.Request) {
 		}
 		if hardKillErr != nil {
 			storeLog("Failed to hard kill process: " + pidStr)
-			http.Error(w, "Failed to kill process", http.StatusInternalServerError)
-			return
+			return http.StatusInternalServerError, errors.New("Failed to kill process")
 		}
 	}
 
-	// Update the metrics file to reflect the "Killed" status
-	updateKilledStatus(pid)
-
-	// Force the dashboard to reload the updated metrics
-	updateMetricsFile()
-
-	storeLog("Process killed successfully PID: " + pidStr)
-	w.WriteHeader(http.StatusOK)
-	w.Write([]byte("Process killed successfully"))
-}
-
-func updateKilledStatus(pid int) {
-	//pterm.Info.Printf("Updating killed status for process with PID %d\n", pid)
-	storeLog(fmt.Sprintf("Updating killed status for process with PID %d", pid))
-
-	dashboardDir, err := os.UserConfigDir()
-	if err != nil {
-		pterm.Warning.Println("Failed to get UserConfigDir, defaulting to local dashboard directory")
-		dashboardDir = filepath.Join(".", "dashboard")
-	} else {
-		dashboardDir = filepath.Join(dashboardDir, "3270Connect", "dashboard")
-	}
-	metricsFile := filepath.Join(dashboardDir, fmt.Sprintf("metrics_%d.json", pid))
-	//pterm.Info.Printf("Reading metrics file: %s\n", metricsFile)
-	storeLog(fmt.Sprintf("Reading metrics file: %s", metricsFile))
-
-	data, err := os.ReadFile(metricsFile)
-	if err != nil {
-		pterm.Warning.Printf("Failed to read metrics file for PID %d: %v\n", pid, err)
-		storeLog(fmt.Sprintf("Failed to read metrics file for PID %d: %v", pid, err))
-		return
-	}
-	var metrics Metrics
-	if err := json.Unmarshal(data, &metrics); err != nil {
-		pterm.Warning.Printf("Failed to unmarshal metrics for PID %d: %v\n", pid, err)
-		storeLog(fmt.Sprintf("Failed to unmarshal metrics for PID %d: %v", pid, err))
-		return
-	}
-
-	//pterm.Info.Printf("Clearing active workflows for PID %d\n", pid)
+	// The run cannot report any more; clear its workflows in flight.
 	storeLog(fmt.Sprintf("Clearing active workflows for killed PID %d", pid))
-	// Only clear active workflows - preserve execution statistics for accurate aggregation
-	metrics.ActiveWorkflows = 0
-
-	extendedMetrics := metrics.extend()
-	extendedMetrics.Status = "Killed"
-
-	updatedData, err := json.Marshal(extendedMetrics)
-	if err != nil {
-		pterm.Warning.Printf("Failed to marshal updated metrics for PID %d: %v\n", pid, err)
-		storeLog(fmt.Sprintf("Failed to marshal updated metrics for PID %d: %v", pid, err))
-		return
+	if !dashboardRuns.markKilled(pid) {
+		dashboardLog.Warn(fmt.Sprintf("PID %d never reported to the dashboard", pid))
 	}
-	if err := os.WriteFile(metricsFile, updatedData, 0644); err != nil {
-		pterm.Warning.Printf("Failed to write updated metrics for PID %d: %v\n", pid, err)
-		storeLog(fmt.Sprintf("Failed to write updated metrics for PID %d: %v", pid, err))
-		return
-	}
-	//pterm.Info.Printf("Successfully updated metrics for PID %d to status 'Killed'\n", pid)
-	storeLog(fmt.Sprintf("Successfully updated metrics for PID %d to status 'Killed'", pid))
+	reportMetrics()
+
+	storeLog("Process killed successfully PID: " + pidStr)
+	return http.StatusOK, nil
 }
 
 func loadInjectionData(filePath string) ([]map[string]string, error) {
@@ -3397,7 +4458,12 @@ func loadInjectionData(filePath string) ([]map[string]string, error) {
 	if err != nil {
 		return nil, err
 	}
+	return parseInjectionData(data)
+}
 
+// parseInjectionData reads injection rows: an array of objects, an object
+// wrapping one in "entries" or "data", or a single object.
+func parseInjectionData(data []byte) ([]map[string]string, error) {
 	var raw interface{}
 	if err := json.Unmarshal(data, &raw); err != nil {
 		return nil, fmt.Errorf("failed to parse injection data: %w", err)
@@ -3455,16 +4521,60 @@ func loadInjectionData(filePath string) ([]map[string]string, error) {
 
 func injectDynamicValues(config *Configuration, injection map[string]string) *Configuration {
 	newConfig := *config // Create a copy of the configuration
-	newConfig.Steps = make([]Step, len(config.Steps))
-	copy(newConfig.Steps, config.Steps)
+	if len(injection) > 0 {
+		newConfig.dataValues = injection
+	}
+	newConfig.Steps = injectStepValues(config.Steps, injection)
+	newConfig.OnError = injectStepValues(config.OnError, injection)
+	newConfig.SessionSetup = injectStepValues(config.SessionSetup, injection)
+	newConfig.SessionTeardown = injectStepValues(config.SessionTeardown, injection)
+	if config.Workflows != nil {
+		newConfig.Workflows = make([]SuiteWorkflow, len(config.Workflows))
+		for i, w := range config.Workflows {
+			w.Steps = injectStepValues(w.Steps, injection)
+			w.OnError = injectStepValues(w.OnError, injection)
+			newConfig.Workflows[i] = w
+		}
+	}
+	return &newConfig
+}
 
-	for i, step := range newConfig.Steps {
+// injectStepValues copies steps with injection placeholders replaced,
+// descending into If blocks.
+func injectStepValues(steps []Step, injection map[string]string) []Step {
+	if steps == nil {
+		return nil
+	}
+	out := make([]Step, len(steps))
+	copy(out, steps)
+	for i := range out {
 		for placeholder, value := range injection {
-			if strings.Contains(step.Text, placeholder) {
-				newConfig.Steps[i].Text = strings.ReplaceAll(newConfig.Steps[i].Text, placeholder, value)
+			if strings.Contains(out[i].Text, placeholder) {
+				if out[i].Type == "CheckValueRegex" {
+					value = regexp.QuoteMeta(value)
+				}
+				out[i].Text = strings.ReplaceAll(out[i].Text, placeholder, value)
+			}
+			if strings.Contains(out[i].Near, placeholder) {
+				out[i].Near = strings.ReplaceAll(out[i].Near, placeholder, value)
 			}
 		}
+		if cond := out[i].Condition; cond != nil {
+			injected := *cond
+			for placeholder, value := range injection {
+				injected.ScreenContains = strings.ReplaceAll(injected.ScreenContains, placeholder, value)
+			}
+			if cond.ValueEquals != nil {
+				valueEquals := *cond.ValueEquals
+				for placeholder, value := range injection {
+					valueEquals.Text = strings.ReplaceAll(valueEquals.Text, placeholder, value)
+				}
+				injected.ValueEquals = &valueEquals
+			}
+			out[i].Condition = &injected
+		}
+		out[i].Steps = injectStepValues(out[i].Steps, injection)
+		out[i].Else = injectStepValues(out[i].Else, injection)
 	}
-
-	return &newConfig
+	return out
 }
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+
+	connect3270 "github.com/3270io/3270Connect/connect3270"
+	"github.com/gin-gonic/gin"
+)
+
+// healthCheck is one of the checks /readyz runs: "ok", or why the server
+// cannot take work.
+type healthCheck struct {
+	Name   string `json:"name"`
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// readinessChecks runs the checks of /readyz: the server is not shutting
+// down, the emulator binary can be extracted, and when emulators listen
+// on script ports, one can be obtained.
+func readinessChecks() ([]healthCheck, bool) {
+	ready := true
+	check := func(name string, err error) healthCheck {
+		if err != nil {
+			ready = false
+			return healthCheck{Name: name, Status: "failed", Error: err.Error()}
+		}
+		return healthCheck{Name: name, Status: "ok"}
+	}
+	checks := []healthCheck{check("shutdown", shutdownErr())}
+	checks = append(checks, check("emulator", connect3270.CheckBinary()))
+	if connect3270.UsesScriptPorts() {
+		checks = append(checks, check("scriptPort", obtainScriptPort()))
+	}
+	return checks, ready
+}
+
+// errShuttingDown fails readiness once shutdown has begun, so load
+// balancers stop sending work while the runs in flight finish.
+var errShuttingDown = errors.New("shutting down")
+
+func shutdownErr() error {
+	if connect3270.ShutdownRequested() {
+		return errShuttingDown
+	}
+	return nil
+}
+
+// obtainScriptPort asks the OS for a free port, as x3270 does with
+// -scriptport 0, and gives it back.
+func obtainScriptPort() error {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return err
+	}
+	return ln.Close()
+}
+
+// healthzHandler answers liveness probes: the process is up and serving.
+func healthzHandler(w http.ResponseWriter, r *http.Request) {
+	writeHealth(w, http.StatusOK, map[string]any{"status": "ok", "version": version})
+}
+
+// readyzHandler answers readiness probes with 200 when the server can run
+// workflows and 503, with the checks that failed, when it cannot.
+func readyzHandler(w http.ResponseWriter, r *http.Request) {
+	checks, ready := readinessChecks()
+	if !ready {
+		writeHealth(w, http.StatusServiceUnavailable, map[string]any{"status": "not ready", "checks": checks})
+		return
+	}
+	writeHealth(w, http.StatusOK, map[string]any{"status": "ready", "checks": checks})
+}
+
+func writeHealth(w http.ResponseWriter, code int, body map[string]any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(body)
+}
+
+// registerHealthRoutes serves /healthz and /readyz on the API server.
+// Like the docs they are registered before the authentication middleware,
+// since probes carry no key.
+func registerHealthRoutes(r *gin.Engine) {
+	r.GET("/healthz", gin.WrapF(healthzHandler))
+	r.GET("/readyz", gin.WrapF(readyzHandler))
+}
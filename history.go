@@ -0,0 +1,715 @@
+package main
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"text/tabwriter"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+var historyDBFlag string
+
+func init() {
+	flag.StringVar(&historyDBFlag, "historyDB", filepath.Join("logs", "history.db"), "SQLite database every run is recorded in, for 3270Connect report (empty to keep no history)")
+}
+
+// historyTimeLayout stores times in UTC at a fixed width, so they sort as
+// text.
+const historyTimeLayout = "2006-01-02T15:04:05.000Z07:00"
+
+const historySchema = `
+CREATE TABLE IF NOT EXISTS runs (
+	id          INTEGER PRIMARY KEY AUTOINCREMENT,
+	config_path TEXT NOT NULL,
+	config_hash TEXT NOT NULL,
+	started_at  TEXT NOT NULL,
+	ended_at    TEXT NOT NULL,
+	mode        TEXT NOT NULL,
+	vusers      INTEGER NOT NULL,
+	started     INTEGER NOT NULL,
+	completed   INTEGER NOT NULL,
+	failed      INTEGER NOT NULL,
+	avg_seconds REAL NOT NULL,
+	p50_seconds REAL NOT NULL,
+	p90_seconds REAL NOT NULL,
+	p95_seconds REAL NOT NULL,
+	p99_seconds REAL NOT NULL,
+	exit_code   INTEGER NOT NULL,
+	outcome     TEXT NOT NULL,
+	pid         INTEGER NOT NULL DEFAULT 0,
+	archived    INTEGER NOT NULL DEFAULT 0,
+	summary     TEXT NOT NULL DEFAULT '',
+	charts      TEXT NOT NULL DEFAULT ''
+);
+CREATE INDEX IF NOT EXISTS runs_config_hash ON runs (config_hash);
+CREATE TABLE IF NOT EXISTS run_timings (
+	run_id      INTEGER NOT NULL REFERENCES runs (id) ON DELETE CASCADE,
+	kind        TEXT NOT NULL,
+	name        TEXT NOT NULL,
+	count       INTEGER NOT NULL,
+	min_seconds REAL NOT NULL,
+	avg_seconds REAL NOT NULL,
+	max_seconds REAL NOT NULL,
+	p50_seconds REAL NOT NULL,
+	p95_seconds REAL NOT NULL,
+	p99_seconds REAL NOT NULL
+);
+CREATE INDEX IF NOT EXISTS run_timings_run ON run_timings (run_id);
+CREATE TABLE IF NOT EXISTS run_errors (
+	run_id  INTEGER NOT NULL REFERENCES runs (id) ON DELETE CASCADE,
+	message TEXT NOT NULL,
+	count   INTEGER NOT NULL
+);
+CREATE INDEX IF NOT EXISTS run_errors_run ON run_errors (run_id);
+`
+
+// historyAddedColumns are the columns runs gained after its first
+// release, added to older databases as they are opened.
+var historyAddedColumns = []struct{ name, definition string }{
+	{"pid", "INTEGER NOT NULL DEFAULT 0"},
+	{"archived", "INTEGER NOT NULL DEFAULT 0"},
+	{"summary", "TEXT NOT NULL DEFAULT ''"},
+	{"charts", "TEXT NOT NULL DEFAULT ''"},
+}
+
+// runRecord is a run as the history keeps it. Durations are in seconds.
+type runRecord struct {
+	ID         int64          `json:"id"`
+	ConfigPath string         `json:"configPath"`
+	ConfigHash string         `json:"configHash"`
+	StartedAt  time.Time      `json:"startedAt"`
+	EndedAt    time.Time      `json:"endedAt"`
+	Mode       string         `json:"mode"`
+	VUsers     int            `json:"vUsers"`
+	Started    int64          `json:"started"`
+	Completed  int64          `json:"completed"`
+	Failed     int64          `json:"failed"`
+	Avg        float64        `json:"avg"`
+	P50        float64        `json:"p50"`
+	P90        float64        `json:"p90"`
+	P95        float64        `json:"p95"`
+	P99        float64        `json:"p99"`
+	ExitCode   int            `json:"exitCode"`
+	Outcome    string         `json:"outcome"`
+	PID        int            `json:"pid,omitempty"`
+	Archived   bool           `json:"archived,omitempty"`
+	Timings    []timingRecord `json:"timings,omitempty"`
+	Errors     []errorRecord  `json:"errors,omitempty"`
+	// Summary is the performance summary the run wrote, and Charts the
+	// series its dashboard charts showed last.
+	Summary string     `json:"summary,omitempty"`
+	Charts  *runCharts `json:"charts,omitempty"`
+}
+
+// runCharts are the series of a run's dashboard charts: workflow
+// durations in seconds, and CPU and memory use in percent.
+type runCharts struct {
+	Durations   []float64 `json:"durations,omitempty"`
+	CPUUsage    []float64 `json:"cpuUsage,omitempty"`
+	MemoryUsage []float64 `json:"memoryUsage,omitempty"`
+}
+
+func (r runRecord) errorRate() float64 {
+	if finished := r.Completed + r.Failed; finished > 0 {
+		return float64(r.Failed) / float64(finished) * 100
+	}
+	return 0
+}
+
+// timingRecord is the timing of a transaction or a step of a recorded run.
+type timingRecord struct {
+	Kind  string  `json:"kind"`
+	Name  string  `json:"name"`
+	Count int64   `json:"count"`
+	Min   float64 `json:"min"`
+	Avg   float64 `json:"avg"`
+	Max   float64 `json:"max"`
+	P50   float64 `json:"p50"`
+	P95   float64 `json:"p95"`
+	P99   float64 `json:"p99"`
+}
+
+type errorRecord struct {
+	Message string `json:"message"`
+	Count   int    `json:"count"`
+}
+
+// openHistory opens the history database at path, creating it and its
+// tables as needed.
+func openHistory(path string) (*sql.DB, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return nil, err
+	}
+	// One connection keeps concurrent writers from tripping over
+	// SQLITE_BUSY and the foreign keys pragma in force.
+	db.SetMaxOpenConns(1)
+	if _, err := db.Exec("PRAGMA foreign_keys = ON; PRAGMA busy_timeout = 5000;" + historySchema); err != nil {
+		db.Close()
+		return nil, err
+	}
+	if err := addHistoryColumns(db); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+// addHistoryColumns brings the runs table of an older database up to
+// date.
+func addHistoryColumns(db *sql.DB) error {
+	rows, err := db.Query("SELECT name FROM pragma_table_info('runs')")
+	if err != nil {
+		return err
+	}
+	have := make(map[string]bool)
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			rows.Close()
+			return err
+		}
+		have[name] = true
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	for _, c := range historyAddedColumns {
+		if !have[c.name] {
+			if _, err := db.Exec("ALTER TABLE runs ADD COLUMN " + c.name + " " + c.definition); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// configHash identifies a workflow configuration across runs, whatever file
+// it was loaded from. The runtime token is left out, as it changes with
+// every run.
+func configHash(config *Configuration) string {
+	c := *config
+	c.Token = ""
+	data, err := json.Marshal(&c)
+	if err != nil {
+		data = nil
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// runModeName names how the run generated load.
+func runModeName(config *Configuration) string {
+	switch {
+	case k8sController:
+		return "distributed"
+	case searchMode():
+		return "search"
+	case iterationMode():
+		return "iterations"
+	case arrivalRate(config) > 0:
+		return "arrival rate"
+	case concurrent > 1 || runtimeDuration > 0:
+		return "concurrent"
+	}
+	return "single"
+}
+
+// runOutcome sums up how the run ended, given its exit code.
+func runOutcome(exitCode int) string {
+	switch {
+	case abortReasonText() != "":
+		return "aborted: " + abortReasonText()
+	case hardStopText() != "":
+		return "hard stop: " + hardStopText()
+	case stopReasonText() != "":
+		return "stopped: " + stopReasonText()
+	case exitCode == thresholdExitCode:
+		return "SLA thresholds broken"
+	case exitCode != 0:
+		return fmt.Sprintf("exit code %d", exitCode)
+	}
+	return "completed"
+}
+
+// newRunRecord gathers the record of the run that just ended.
+func newRunRecord(configPath string, config *Configuration, exitCode int) runRecord {
+	if abs, err := filepath.Abs(configPath); err == nil {
+		configPath = abs
+	}
+	completed := atomic.LoadInt64(&totalWorkflowsCompleted)
+	failed := atomic.LoadInt64(&totalWorkflowsFailed)
+	r := runRecord{
+		ConfigPath: configPath,
+		ConfigHash: configHash(config),
+		StartedAt:  programStart,
+		EndedAt:    time.Now(),
+		Mode:       runModeName(config),
+		VUsers:     max(concurrent, 1),
+		// A distributed controller only takes on the totals of its workers.
+		Started:   max64(atomic.LoadInt64(&totalWorkflowsStarted), completed+failed),
+		Completed: completed,
+		Failed:    failed,
+		Avg:       getAverageWorkflowDuration(),
+		P50:       durationPercentile(50),
+		P90:       durationPercentile(90),
+		P95:       durationPercentile(95),
+		P99:       durationPercentile(99),
+		ExitCode:  exitCode,
+		Outcome:   runOutcome(exitCode),
+		PID:       os.Getpid(),
+		Charts:    currentRunCharts(),
+	}
+	if summary, err := os.ReadFile(filepath.Join("logs", fmt.Sprintf("summary_%d.txt", r.PID))); err == nil {
+		r.Summary = string(summary)
+	}
+	steps, transactions := timingSnapshot()
+	names := make([]string, 0, len(transactions))
+	for name := range transactions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	timingStatsMu.Lock()
+	order := append([]string(nil), stepOrder...)
+	timingStatsMu.Unlock()
+	r.Timings = append(timingRecords("transaction", names, transactions), timingRecords("step", order, steps)...)
+	for message, count := range errorBreakdown() {
+		r.Errors = append(r.Errors, errorRecord{Message: message, Count: count})
+	}
+	sort.Slice(r.Errors, func(i, j int) bool {
+		if r.Errors[i].Count != r.Errors[j].Count {
+			return r.Errors[i].Count > r.Errors[j].Count
+		}
+		return r.Errors[i].Message < r.Errors[j].Message
+	})
+	return r
+}
+
+// currentRunCharts copies the series this run's dashboard charts show.
+func currentRunCharts() *runCharts {
+	c := &runCharts{}
+	metricsMutex.Lock()
+	c.CPUUsage = append(c.CPUUsage, cpuHistory...)
+	c.MemoryUsage = append(c.MemoryUsage, memHistory...)
+	metricsMutex.Unlock()
+	timingsMutex.Lock()
+	c.Durations = append(c.Durations, workflowDurations...)
+	timingsMutex.Unlock()
+	return c
+}
+
+func max64(a, b int64) int64 {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+// timingRecords returns the timings of stats, in the order of names.
+func timingRecords(kind string, names []string, stats map[string]timingStat) []timingRecord {
+	records := make([]timingRecord, 0, len(names))
+	for _, name := range names {
+		s := stats[name]
+		records = append(records, timingRecord{
+			Kind:  kind,
+			Name:  name,
+			Count: s.Count,
+			Min:   s.Min,
+			Avg:   s.Avg,
+			Max:   s.Max,
+			P50:   s.percentile(50),
+			P95:   s.percentile(95),
+			P99:   s.percentile(99),
+		})
+	}
+	return records
+}
+
+// saveRunRecord stores r and returns its run ID.
+func saveRunRecord(db *sql.DB, r runRecord) (int64, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	var charts []byte
+	if r.Charts != nil {
+		if charts, err = json.Marshal(r.Charts); err != nil {
+			return 0, err
+		}
+	}
+	res, err := tx.Exec(`INSERT INTO runs (config_path, config_hash, started_at, ended_at, mode, vusers,
+		started, completed, failed, avg_seconds, p50_seconds, p90_seconds, p95_seconds, p99_seconds, exit_code, outcome,
+		pid, archived, summary, charts)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		r.ConfigPath, r.ConfigHash, r.StartedAt.UTC().Format(historyTimeLayout), r.EndedAt.UTC().Format(historyTimeLayout),
+		r.Mode, r.VUsers, r.Started, r.Completed, r.Failed, r.Avg, r.P50, r.P90, r.P95, r.P99, r.ExitCode, r.Outcome,
+		r.PID, r.Archived, r.Summary, string(charts))
+	if err != nil {
+		return 0, err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+	for _, t := range r.Timings {
+		if _, err := tx.Exec(`INSERT INTO run_timings (run_id, kind, name, count, min_seconds, avg_seconds, max_seconds,
+			p50_seconds, p95_seconds, p99_seconds) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			id, t.Kind, t.Name, t.Count, t.Min, t.Avg, t.Max, t.P50, t.P95, t.P99); err != nil {
+			return 0, err
+		}
+	}
+	for _, e := range r.Errors {
+		if _, err := tx.Exec(`INSERT INTO run_errors (run_id, message, count) VALUES (?, ?, ?)`, id, e.Message, e.Count); err != nil {
+			return 0, err
+		}
+	}
+	return id, tx.Commit()
+}
+
+// recordRunHistory adds the run that just ended to the -historyDB
+// database. A history that cannot be written is reported but does not fail
+// the run.
+func recordRunHistory(configPath string, config *Configuration, exitCode int) {
+	if historyDBFlag == "" {
+		return
+	}
+	db, err := openHistory(historyDBFlag)
+	if err == nil {
+		var id int64
+		if id, err = saveRunRecord(db, newRunRecord(configPath, config, exitCode)); err == nil {
+			reportLog.Info(fmt.Sprintf("Run recorded in %s as run %d - see 3270Connect report %d", historyDBFlag, id, id))
+		}
+		db.Close()
+	}
+	if err != nil {
+		reportLog.Warn(fmt.Sprintf("Failed to record the run in %s: %v", historyDBFlag, err))
+	}
+}
+
+// historyFilter selects runs from the history; zero fields match every run.
+// archived picks archived runs (1) or the others (-1).
+type historyFilter struct {
+	id         int64
+	pid        int
+	configPath string
+	hashPrefix string
+	since      time.Time
+	archived   int
+	limit      int
+}
+
+// loadRuns returns the runs that match f, newest first, without their
+// timings, errors, summary and charts.
+func loadRuns(db *sql.DB, f historyFilter) ([]runRecord, error) {
+	query := `SELECT id, config_path, config_hash, started_at, ended_at, mode, vusers, started, completed, failed,
+		avg_seconds, p50_seconds, p90_seconds, p95_seconds, p99_seconds, exit_code, outcome, pid, archived FROM runs`
+	var where []string
+	var args []interface{}
+	if f.id != 0 {
+		where = append(where, "id = ?")
+		args = append(args, f.id)
+	}
+	if f.pid != 0 {
+		where = append(where, "pid = ?")
+		args = append(args, f.pid)
+	}
+	switch {
+	case f.archived > 0:
+		where = append(where, "archived != 0")
+	case f.archived < 0:
+		where = append(where, "archived = 0")
+	}
+	if f.configPath != "" {
+		where = append(where, "config_path = ?")
+		args = append(args, f.configPath)
+	}
+	if f.hashPrefix != "" {
+		where = append(where, "config_hash LIKE ?")
+		args = append(args, strings.ToLower(f.hashPrefix)+"%")
+	}
+	if !f.since.IsZero() {
+		where = append(where, "started_at >= ?")
+		args = append(args, f.since.UTC().Format(historyTimeLayout))
+	}
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	query += " ORDER BY id DESC"
+	if f.limit > 0 {
+		query += " LIMIT " + strconv.Itoa(f.limit)
+	}
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var runs []runRecord
+	for rows.Next() {
+		var r runRecord
+		var startedAt, endedAt string
+		if err := rows.Scan(&r.ID, &r.ConfigPath, &r.ConfigHash, &startedAt, &endedAt, &r.Mode, &r.VUsers,
+			&r.Started, &r.Completed, &r.Failed, &r.Avg, &r.P50, &r.P90, &r.P95, &r.P99, &r.ExitCode, &r.Outcome, &r.PID, &r.Archived); err != nil {
+			return nil, err
+		}
+		r.StartedAt, _ = time.Parse(historyTimeLayout, startedAt)
+		r.EndedAt, _ = time.Parse(historyTimeLayout, endedAt)
+		runs = append(runs, r)
+	}
+	return runs, rows.Err()
+}
+
+// errNoRun means the history has no run of the ID asked for.
+var errNoRun = errors.New("no such run in the history")
+
+// loadRun returns a run with its timings, errors, summary and charts.
+func loadRun(db *sql.DB, id int64) (*runRecord, error) {
+	runs, err := loadRuns(db, historyFilter{id: id})
+	if err != nil {
+		return nil, err
+	}
+	if len(runs) == 0 {
+		return nil, fmt.Errorf("run %d: %w", id, errNoRun)
+	}
+	r := &runs[0]
+	var charts string
+	if err := db.QueryRow(`SELECT summary, charts FROM runs WHERE id = ?`, id).Scan(&r.Summary, &charts); err != nil {
+		return nil, err
+	}
+	if charts != "" {
+		r.Charts = &runCharts{}
+		if err := json.Unmarshal([]byte(charts), r.Charts); err != nil {
+			return nil, fmt.Errorf("run %d charts: %w", id, err)
+		}
+	}
+	rows, err := db.Query(`SELECT kind, name, count, min_seconds, avg_seconds, max_seconds, p50_seconds, p95_seconds, p99_seconds
+		FROM run_timings WHERE run_id = ? ORDER BY rowid`, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var t timingRecord
+		if err := rows.Scan(&t.Kind, &t.Name, &t.Count, &t.Min, &t.Avg, &t.Max, &t.P50, &t.P95, &t.P99); err != nil {
+			return nil, err
+		}
+		r.Timings = append(r.Timings, t)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	errRows, err := db.Query(`SELECT message, count FROM run_errors WHERE run_id = ? ORDER BY count DESC, message`, id)
+	if err != nil {
+		return nil, err
+	}
+	defer errRows.Close()
+	for errRows.Next() {
+		var e errorRecord
+		if err := errRows.Scan(&e.Message, &e.Count); err != nil {
+			return nil, err
+		}
+		r.Errors = append(r.Errors, e)
+	}
+	return r, errRows.Err()
+}
+
+// archiveRun archives run id, which keeps it out of the dashboard's list
+// of runs, or brings it back.
+func archiveRun(db *sql.DB, id int64, archived bool) error {
+	res, err := db.Exec(`UPDATE runs SET archived = ? WHERE id = ?`, archived, id)
+	return checkRunChanged(res, err, id)
+}
+
+// deleteRun removes run id from the history, with its timings and errors.
+func deleteRun(db *sql.DB, id int64) error {
+	res, err := db.Exec(`DELETE FROM runs WHERE id = ?`, id)
+	return checkRunChanged(res, err, id)
+}
+
+func checkRunChanged(res sql.Result, err error, id int64) error {
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return fmt.Errorf("run %d: %w", id, errNoRun)
+	}
+	return nil
+}
+
+// parseSince reads a -since value: a date, a date and time, or a duration
+// back from now such as 72h.
+func parseSince(value string, now time.Time) (time.Time, error) {
+	for _, layout := range []string{"2006-01-02", "2006-01-02T15:04", time.RFC3339} {
+		if t, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	if d, err := time.ParseDuration(value); err == nil && d > 0 {
+		return now.Add(-d), nil
+	}
+	return time.Time{}, fmt.Errorf("-since %q must be a date such as 2024-05-31 or a duration such as 72h", value)
+}
+
+// runReportCommand lists the runs in the history, or shows one of them.
+func runReportCommand(args []string, out io.Writer) int {
+	fs := flag.NewFlagSet("report", flag.ContinueOnError)
+	dbPath := fs.String("db", filepath.Join("logs", "history.db"), "History database to read")
+	configPath := fs.String("config", "", "Only list runs of this workflow file")
+	hash := fs.String("hash", "", "Only list runs whose configuration hash starts with this")
+	since := fs.String("since", "", "Only list runs started since this date (2024-05-31) or this long ago (72h)")
+	limit := fs.Int("limit", 20, "List at most this many runs (0 for all)")
+	asJSON := fs.Bool("json", false, "Print the runs as JSON")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: 3270Connect report [-db logs/history.db] [-config workflow.json] [-hash prefix] [-since date|duration] [-limit n] [-json] [run-id]")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() > 1 {
+		fs.Usage()
+		return 2
+	}
+	if _, err := os.Stat(*dbPath); err != nil {
+		fmt.Fprintf(os.Stderr, "no run history at %s: %v\n", *dbPath, err)
+		return 1
+	}
+	db, err := openHistory(*dbPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", *dbPath, err)
+		return 1
+	}
+	defer db.Close()
+
+	if fs.NArg() == 1 {
+		id, err := strconv.ParseInt(fs.Arg(0), 10, 64)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "run ID %q must be a number\n", fs.Arg(0))
+			return 2
+		}
+		r, err := loadRun(db, id)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		if *asJSON {
+			return writeReportJSON(out, r)
+		}
+		printRunRecord(out, *r)
+		return 0
+	}
+
+	f := historyFilter{hashPrefix: *hash, limit: *limit}
+	if *configPath != "" {
+		if f.configPath, err = filepath.Abs(*configPath); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 2
+		}
+	}
+	if *since != "" {
+		if f.since, err = parseSince(*since, time.Now()); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 2
+		}
+	}
+	runs, err := loadRuns(db, f)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", *dbPath, err)
+		return 1
+	}
+	if *asJSON {
+		return writeReportJSON(out, runs)
+	}
+	if len(runs) == 0 {
+		fmt.Fprintln(out, "No runs recorded.")
+		return 0
+	}
+	printRunList(out, runs)
+	return 0
+}
+
+func writeReportJSON(out io.Writer, v interface{}) int {
+	enc := json.NewEncoder(out)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
+
+func printRunList(out io.Writer, runs []runRecord) {
+	tw := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tStarted\tDuration\tWorkflow\tHash\tMode\tvUsers\tCompleted\tFailed\tError Rate\tP95\tOutcome")
+	for _, r := range runs {
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t%s\t%s\t%d\t%d\t%d\t%.2f%%\t%.2fs\t%s\n",
+			r.ID, r.StartedAt.Local().Format("2006-01-02 15:04"), formatSeconds(r.EndedAt.Sub(r.StartedAt).Seconds()),
+			filepath.Base(r.ConfigPath), shortHash(r.ConfigHash), r.Mode, r.VUsers, r.Completed, r.Failed, r.errorRate(), r.P95, r.Outcome)
+	}
+	tw.Flush()
+}
+
+func printRunRecord(out io.Writer, r runRecord) {
+	fmt.Fprintf(out, "Run %d: %s\n", r.ID, r.Outcome)
+	tw := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "Workflow\t%s\n", r.ConfigPath)
+	fmt.Fprintf(tw, "Configuration Hash\t%s\n", r.ConfigHash)
+	fmt.Fprintf(tw, "Started\t%s\n", r.StartedAt.Local().Format("2006-01-02 15:04:05"))
+	fmt.Fprintf(tw, "Ended\t%s\n", r.EndedAt.Local().Format("2006-01-02 15:04:05"))
+	fmt.Fprintf(tw, "Mode\t%s, %d vUser(s)\n", r.Mode, r.VUsers)
+	fmt.Fprintf(tw, "Workflows\t%d started, %d completed, %d failed (%.2f%%)\n", r.Started, r.Completed, r.Failed, r.errorRate())
+	fmt.Fprintf(tw, "Workflow Time\tavg %.3fs, p50 %.3fs, p90 %.3fs, p95 %.3fs, p99 %.3fs\n", r.Avg, r.P50, r.P90, r.P95, r.P99)
+	if r.ExitCode >= 0 {
+		fmt.Fprintf(tw, "Exit Code\t%d\n", r.ExitCode)
+	}
+	if r.PID != 0 {
+		fmt.Fprintf(tw, "PID\t%d\n", r.PID)
+	}
+	if r.Archived {
+		fmt.Fprintln(tw, "Archived\tyes")
+	}
+	tw.Flush()
+	if len(r.Timings) > 0 {
+		fmt.Fprintln(out)
+		tw = tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "Timing\tKind\tCount\tMin\tAvg\tMax\tP50\tP95\tP99")
+		for _, t := range r.Timings {
+			fmt.Fprintf(tw, "%s\t%s\t%d\t%.3fs\t%.3fs\t%.3fs\t%.3fs\t%.3fs\t%.3fs\n", t.Name, t.Kind, t.Count, t.Min, t.Avg, t.Max, t.P50, t.P95, t.P99)
+		}
+		tw.Flush()
+	}
+	if len(r.Errors) > 0 {
+		fmt.Fprintln(out)
+		fmt.Fprintln(out, "Errors:")
+		for _, e := range r.Errors {
+			fmt.Fprintf(out, "  %d occurrence(s) of: %s\n", e.Count, e.Message)
+		}
+	}
+}
+
+func shortHash(hash string) string {
+	if len(hash) > 12 {
+		return hash[:12]
+	}
+	return hash
+}
@@ -0,0 +1,279 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"time"
+)
+
+const hotReloadPollInterval = 2 * time.Second
+
+var hotReload bool
+
+func init() {
+	flag.BoolVar(&hotReload, "hotReload", false, "Watch the workflow and injection files during a concurrent run and apply safe changes to later iterations")
+}
+
+// liveRunConfig holds the workflow and injection rows the scheduler hands to
+// new iterations. With -hotReload the watcher swaps them in place; workflows
+// already in flight keep the copy they were started with.
+type liveRunConfig struct {
+	mu         sync.RWMutex
+	config     *Configuration
+	injectData []map[string]string
+}
+
+func newLiveRunConfig(config *Configuration, injectData []map[string]string) *liveRunConfig {
+	return &liveRunConfig{config: config, injectData: injectData}
+}
+
+func (l *liveRunConfig) current() (*Configuration, []map[string]string) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.config, l.injectData
+}
+
+func (l *liveRunConfig) setConfig(config *Configuration) {
+	l.mu.Lock()
+	l.config = config
+	l.mu.Unlock()
+}
+
+func (l *liveRunConfig) setInjectData(rows []map[string]string) {
+	l.mu.Lock()
+	l.injectData = rows
+	l.mu.Unlock()
+}
+
+// mergeSafeConfigChanges copies the settings that can change mid-run from
+// updated onto a copy of current. Anything that would alter what a workflow
+// does on the host (target, steps, outputs) is reported and left untouched.
+func mergeSafeConfigChanges(current, updated *Configuration) (*Configuration, []string, []string) {
+	merged := *current
+	merged.Steps = make([]Step, len(current.Steps))
+	copy(merged.Steps, current.Steps)
+	var applied, rejected []string
+
+	if merged.EveryStepDelay != updated.EveryStepDelay {
+		merged.EveryStepDelay = updated.EveryStepDelay
+		applied = append(applied, "EveryStepDelay")
+	}
+	if merged.EndOfTaskDelay != updated.EndOfTaskDelay {
+		merged.EndOfTaskDelay = updated.EndOfTaskDelay
+		applied = append(applied, "EndOfTaskDelay")
+	}
+	if merged.RampUpBatchSize != updated.RampUpBatchSize {
+		merged.RampUpBatchSize = updated.RampUpBatchSize
+		applied = append(applied, "RampUpBatchSize")
+	}
+	if merged.RampUpDelay != updated.RampUpDelay {
+		merged.RampUpDelay = updated.RampUpDelay
+		applied = append(applied, "RampUpDelay")
+	}
+	// A run keeps the load model it started with; only an open-model rate can change.
+	if (current.ArrivalRate > 0) != (updated.ArrivalRate > 0) {
+		rejected = append(rejected, "ArrivalRate")
+	} else if merged.ArrivalRate != updated.ArrivalRate {
+		merged.ArrivalRate = updated.ArrivalRate
+		applied = append(applied, "ArrivalRate")
+	}
+
+	if current.Host != updated.Host || current.Port != updated.Port {
+		rejected = append(rejected, "Host/Port")
+	}
+	if current.OutputFilePath != updated.OutputFilePath || current.InputFilePath != updated.InputFilePath {
+		rejected = append(rejected, "OutputFilePath/InputFilePath")
+	}
+	if !reflect.DeepEqual(current.OnError, updated.OnError) {
+		rejected = append(rejected, "OnError")
+	}
+	if !reflect.DeepEqual(current.SessionSetup, updated.SessionSetup) || !reflect.DeepEqual(current.SessionTeardown, updated.SessionTeardown) {
+		rejected = append(rejected, "SessionSetup/SessionTeardown")
+	}
+	if weights, changed, same := mergeSuiteWeights(current.Workflows, updated.Workflows); !same {
+		rejected = append(rejected, "Workflows")
+	} else if changed {
+		merged.Workflows = weights
+		applied = append(applied, "Workflows.Weight")
+	}
+	if !sameStepActions(current.Steps, updated.Steps) {
+		rejected = append(rejected, "Steps")
+	} else {
+		stepDelaysChanged := false
+		for i := range merged.Steps {
+			if merged.Steps[i].StepDelay != updated.Steps[i].StepDelay || merged.Steps[i].Delay != updated.Steps[i].Delay {
+				merged.Steps[i].StepDelay = updated.Steps[i].StepDelay
+				merged.Steps[i].Delay = updated.Steps[i].Delay
+				stepDelaysChanged = true
+			}
+		}
+		if stepDelaysChanged {
+			applied = append(applied, "StepDelay")
+		}
+	}
+	return &merged, applied, rejected
+}
+
+// sameStepActions reports whether two step lists perform the same actions,
+// ignoring their think times.
+func sameStepActions(a, b []Step) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].Type != b[i].Type || a[i].Coordinates != b[i].Coordinates || a[i].Text != b[i].Text || a[i].Variable != b[i].Variable || a[i].File != b[i].File ||
+			a[i].Index != b[i].Index || a[i].Near != b[i].Near {
+			return false
+		}
+		// Branches of If steps are compared wholesale, think times included.
+		if !reflect.DeepEqual(a[i].Attributes, b[i].Attributes) || !reflect.DeepEqual(a[i].Condition, b[i].Condition) || !reflect.DeepEqual(a[i].Steps, b[i].Steps) || !reflect.DeepEqual(a[i].Else, b[i].Else) {
+			return false
+		}
+	}
+	return true
+}
+
+// readConfiguration loads and validates a workflow file without exiting on
+// failure, so a bad edit during a run can be rejected instead of fatal.
+// Secrets are left to resolveSecrets.
+func readConfiguration(filePath string) (*Configuration, error) {
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+	if data, err = applyOverlays(data, configOverlays); err != nil {
+		return nil, err
+	}
+	if data, err = applyConfigOverrides(data, configOverrides); err != nil {
+		return nil, err
+	}
+	config := Configuration{WaitForField: true, submitted: submittedWorkflow}
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("decoding config JSON: %w", err)
+	}
+	if err := applyEnvironment(&config, environmentName); err != nil {
+		return nil, err
+	}
+	if config.Steps, err = expandIncludes(config.Steps, filepath.Dir(filePath)); err != nil {
+		return nil, err
+	}
+	if config.OnError, err = expandIncludes(config.OnError, filepath.Dir(filePath)); err != nil {
+		return nil, err
+	}
+	if err = expandSessionIncludes(&config, filepath.Dir(filePath)); err != nil {
+		return nil, err
+	}
+	if config.Workflows, err = expandSuiteWorkflows(config.Workflows, filepath.Dir(filePath)); err != nil {
+		return nil, err
+	}
+	if config.RampUpBatchSize <= 0 {
+		config.RampUpBatchSize = 10
+	}
+	if config.RampUpDelay <= 0 {
+		config.RampUpDelay = 1.0
+	}
+	if err := validateConfiguration(&config); err != nil {
+		return nil, err
+	}
+	return &config, nil
+}
+
+// watchedFile tracks the last seen modification of a file on disk.
+type watchedFile struct {
+	path    string
+	modTime time.Time
+	size    int64
+}
+
+func newWatchedFile(path string) *watchedFile {
+	w := &watchedFile{path: path}
+	w.changed()
+	return w
+}
+
+// changed reports whether the file was modified since the previous call.
+func (w *watchedFile) changed() bool {
+	info, err := os.Stat(w.path)
+	if err != nil {
+		return false
+	}
+	if info.ModTime().Equal(w.modTime) && info.Size() == w.size {
+		return false
+	}
+	w.modTime = info.ModTime()
+	w.size = info.Size()
+	return true
+}
+
+// watchRunFiles polls the workflow and injection files until stop is closed
+// and pushes safe changes into live.
+func watchRunFiles(live *liveRunConfig, configPath, injectionPath string, stop <-chan struct{}) {
+	var files []*watchedFile
+	configFile := newWatchedFile(configPath)
+	files = append(files, configFile)
+	var injectionFile *watchedFile
+	if injectionPath != "" {
+		injectionFile = newWatchedFile(injectionPath)
+		files = append(files, injectionFile)
+	}
+	pterm.Info.Printf("Hot reload enabled - watching %d file(s) for changes.\n", len(files))
+
+	ticker := time.NewTicker(hotReloadPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+		if configFile.changed() {
+			reloadWorkflowFile(live, configPath)
+		}
+		if injectionFile != nil && injectionFile.changed() {
+			reloadInjectionFile(live, injectionPath)
+		}
+	}
+}
+
+func reloadWorkflowFile(live *liveRunConfig, configPath string) {
+	updated, err := readConfiguration(configPath)
+	if err == nil {
+		err = updated.resolveSecrets()
+	}
+	if err != nil {
+		msg := fmt.Sprintf("Hot reload skipped for %s: %v", configPath, err)
+		schedulerLog.Warn(msg)
+		return
+	}
+	current, _ := live.current()
+	merged, applied, rejected := mergeSafeConfigChanges(current, updated)
+	if len(rejected) > 0 {
+		msg := fmt.Sprintf("Hot reload ignored changes to %v; restart the run to apply them.", rejected)
+		schedulerLog.Warn(msg)
+	}
+	if len(applied) == 0 {
+		return
+	}
+	live.setConfig(merged)
+	msg := fmt.Sprintf("Hot reload applied %v from %s to upcoming iterations.", applied, configPath)
+	infoIfBarsDisabled(msg)
+	storeLog(msg)
+}
+
+func reloadInjectionFile(live *liveRunConfig, injectionPath string) {
+	rows, err := loadInjectionData(injectionPath)
+	if err != nil {
+		msg := fmt.Sprintf("Hot reload skipped for %s: %v", injectionPath, err)
+		schedulerLog.Warn(msg)
+		return
+	}
+	live.setInjectData(rows)
+	msg := fmt.Sprintf("Hot reload loaded %d injection entries from %s.", len(rows), injectionPath)
+	infoIfBarsDisabled(msg)
+	storeLog(msg)
+}
@@ -0,0 +1,499 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	connect3270 "github.com/3270io/3270Connect/connect3270"
+)
+
+// inputImporter turns a macro or script recorded by another emulator into
+// workflow steps for InputFilePath. loadInputFile adds the surrounding
+// Connect and Disconnect steps.
+type inputImporter struct {
+	name   string
+	detect func(path string, data []byte) bool
+	parse  func(data []byte) ([]Step, error)
+}
+
+// inputImporters are tried in order; the sendKeys script importer accepts
+// anything the others do not.
+var inputImporters = []inputImporter{
+	{name: "Host On-Demand macro", detect: isHODMacro, parse: parseHODMacro},
+	{name: "PCOMM macro", detect: isPCOMMMacro, parse: parsePCOMMMacro},
+	{name: "sendKeys script", detect: func(string, []byte) bool { return true }, parse: parseSendKeysScript},
+}
+
+func detectInputImporter(path string, data []byte) inputImporter {
+	for _, imp := range inputImporters {
+		if imp.detect(path, data) {
+			return imp
+		}
+	}
+	return inputImporters[len(inputImporters)-1]
+}
+
+// isMacroFile reports whether path looks like a macro one of the dedicated
+// importers understands.
+func isMacroFile(path string, data []byte) bool {
+	return isHODMacro(path, data) || isPCOMMMacro(path, data)
+}
+
+// parseSendKeysScript reads the "yield ps.sendKeys(...)" / "yield
+// wait.forText(...)" script dialect.
+func parseSendKeysScript(data []byte) ([]Step, error) {
+	var steps []Step
+	lines := strings.Split(string(data), "\n")
+	for idx, line := range lines {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		if connect3270.Verbose {
+			pterm.Info.Printf("Processing line %d: %s", idx+1, line)
+		}
+		if strings.HasPrefix(line, "yield ps.sendKeys") {
+			key := strings.TrimPrefix(line, "yield ps.sendKeys(")
+			key = strings.TrimSuffix(key, ");")
+			key = strings.Trim(key, "'")
+			stepType := ""
+			switch key {
+			case "ControlKey.TAB":
+				stepType = "PressTab"
+			case "ControlKey.ENTER":
+				stepType = "PressEnter"
+			case "ControlKey.F1":
+				stepType = "PressPF1"
+			case "ControlKey.F2":
+				stepType = "PressPF2"
+			case "ControlKey.F3":
+				stepType = "PressPF3"
+			case "ControlKey.F4":
+				stepType = "PressPF4"
+			case "ControlKey.F5":
+				stepType = "PressPF5"
+			case "ControlKey.F6":
+				stepType = "PressPF6"
+			case "ControlKey.F7":
+				stepType = "PressPF7"
+			case "ControlKey.F8":
+				stepType = "PressPF8"
+			case "ControlKey.F9":
+				stepType = "PressPF9"
+			case "ControlKey.F10":
+				stepType = "PressPF10"
+			case "ControlKey.F11":
+				stepType = "PressPF11"
+			case "ControlKey.F12":
+				stepType = "PressPF12"
+			case "ControlKey.F13":
+				stepType = "PressPF13"
+			case "ControlKey.F14":
+				stepType = "PressPF14"
+			case "ControlKey.F15":
+				stepType = "PressPF15"
+			case "ControlKey.F16":
+				stepType = "PressPF16"
+			case "ControlKey.F17":
+				stepType = "PressPF17"
+			case "ControlKey.F18":
+				stepType = "PressPF18"
+			case "ControlKey.F19":
+				stepType = "PressPF19"
+			case "ControlKey.F20":
+				stepType = "PressPF20"
+			case "ControlKey.F21":
+				stepType = "PressPF21"
+			case "ControlKey.F22":
+				stepType = "PressPF22"
+			case "ControlKey.F23":
+				stepType = "PressPF23"
+			case "ControlKey.F24":
+				stepType = "PressPF24"
+			case "ControlKey.PA1":
+				stepType = "PressPA1"
+			case "ControlKey.PA2":
+				stepType = "PressPA2"
+			case "ControlKey.PA3":
+				stepType = "PressPA3"
+			case "ControlKey.CLEAR":
+				stepType = "PressClear"
+			case "ControlKey.HOME":
+				stepType = "PressHome"
+			case "ControlKey.ERASE_EOF":
+				stepType = "EraseEOF"
+			default:
+				stepType = "FillString"
+			}
+			step := Step{Type: stepType, Text: key}
+			steps = append(steps, step)
+			if connect3270.Verbose {
+				pterm.Info.Printf("Added step: %s with text: %s\n", stepType, key)
+			}
+		} else if strings.HasPrefix(line, "yield wait.forText") {
+			parts := strings.Split(line, ",")
+			if len(parts) >= 2 {
+				text := strings.TrimPrefix(parts[0], "yield wait.forText('")
+				text = strings.TrimSuffix(text, "'")
+				position := strings.TrimPrefix(parts[1], "new Position(")
+				position = strings.TrimSuffix(position, ");")
+				posParts := strings.Split(position, ",")
+				if len(posParts) == 2 {
+					row, errRow := strconv.Atoi(strings.TrimSpace(posParts[0]))
+					column, errCol := strconv.Atoi(strings.TrimSpace(posParts[1]))
+					if errRow != nil || errCol != nil {
+						if connect3270.Verbose {
+							pterm.Warning.Printf("Error parsing position in line %d - numbers hate me\n", idx+1)
+						}
+						continue
+					}
+					step := Step{
+						Type: "CheckValue",
+						Coordinates: connect3270.Coordinates{
+							Row:    row,
+							Column: column,
+							Length: len(text),
+						},
+						Text: text,
+					}
+					steps = append(steps, step)
+					if connect3270.Verbose {
+						pterm.Info.Printf("Added CheckValue step: text '%s' at (%d,%d), length %d\n", text, row, column, len(text))
+					}
+				}
+			}
+		} else if strings.HasPrefix(line, "// Fill in the first name at row") || strings.HasPrefix(line, "// Fill in the last name at row") {
+			parts := strings.Split(line, " ")
+			if len(parts) >= 8 {
+				row, errRow := strconv.Atoi(parts[6])
+				column, errCol := strconv.Atoi(parts[9])
+				if errRow != nil || errCol != nil {
+					if connect3270.Verbose {
+						pterm.Warning.Printf("Error parsing coords in line %d - math is hard\n", idx+1)
+					}
+					continue
+				}
+				if idx+1 < len(lines) {
+					nextLine := strings.TrimSpace(lines[idx+1])
+					if strings.HasPrefix(nextLine, "yield ps.sendKeys") {
+						key := strings.TrimPrefix(nextLine, "yield ps.sendKeys(")
+						key = strings.TrimSuffix(key, ");")
+						key = strings.Trim(key, "'")
+						step := Step{
+							Type: "FillString",
+							Coordinates: connect3270.Coordinates{
+								Row:    row,
+								Column: column,
+							},
+							Text: key,
+						}
+						steps = append(steps, step)
+						if connect3270.Verbose {
+							pterm.Info.Printf("Added FillString step: text '%s' at (%d,%d)\n", key, row, column)
+						}
+					}
+				}
+			}
+		}
+	}
+	return steps, nil
+}
+
+// keyMnemonicSteps maps the bracketed key mnemonics used by PCOMM and Host
+// On-Demand ("[enter]", "[pf3]", ...) to steps.
+var keyMnemonicSteps = map[string]string{
+	"enter": "PressEnter", "tab": "PressTab", "clear": "PressClear", "home": "PressHome",
+	"eraseeof": "EraseEOF", "erase eof": "EraseEOF", "eraseinput": "EraseInput",
+	"pa1": "PressPA1", "pa2": "PressPA2", "pa3": "PressPA3",
+}
+
+func init() {
+	for i := 1; i <= 24; i++ {
+		keyMnemonicSteps["pf"+strconv.Itoa(i)] = "PressPF" + strconv.Itoa(i)
+	}
+}
+
+// keyedTextSteps splits text such as "logon tso[enter]" into FillString and
+// key steps. The first text segment is typed at row/col when both are set,
+// later ones at the cursor. "[[" and "]]" stand for literal brackets.
+func keyedTextSteps(text string, row, col int) ([]Step, error) {
+	var steps []Step
+	var literal strings.Builder
+	flush := func() {
+		if literal.Len() == 0 {
+			return
+		}
+		step := Step{Type: "FillString", Text: literal.String()}
+		if row > 0 && col > 0 {
+			step.Coordinates = connect3270.Coordinates{Row: row, Column: col}
+			row, col = 0, 0
+		}
+		steps = append(steps, step)
+		literal.Reset()
+	}
+	for i := 0; i < len(text); i++ {
+		switch {
+		case strings.HasPrefix(text[i:], "[[") || strings.HasPrefix(text[i:], "]]"):
+			literal.WriteByte(text[i])
+			i++
+		case text[i] == '[':
+			end := strings.IndexByte(text[i:], ']')
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated key mnemonic in %q", text)
+			}
+			name := strings.ToLower(text[i+1 : i+end])
+			stepType, ok := keyMnemonicSteps[name]
+			if !ok {
+				return nil, fmt.Errorf("unsupported key [%s]", name)
+			}
+			flush()
+			steps = append(steps, Step{Type: stepType})
+			i += end
+		default:
+			literal.WriteByte(text[i])
+		}
+	}
+	flush()
+	return steps, nil
+}
+
+func isPCOMMMacro(path string, data []byte) bool {
+	return strings.EqualFold(filepath.Ext(path), ".mac") ||
+		bytes.Contains(data, []byte("[PCOMM SCRIPT")) || bytes.Contains(bytes.ToLower(data), []byte("auteclps"))
+}
+
+var (
+	pcommCall   = regexp.MustCompile(`(?i)autECLPS\.(SendKeys|SetText|SetCursorPos|WaitForString)\b\s*\(?(.*?)\)?\s*$`)
+	pcommString = regexp.MustCompile(`^"((?:[^"]|"")*)"`)
+)
+
+// parsePCOMMMacro reads an IBM Personal Communications VBScript macro
+// (.mac). SendKeys, SetText, SetCursorPos and WaitForString become steps;
+// OIA waits are left to the workflow's own screen readiness handling.
+func parsePCOMMMacro(data []byte) ([]Step, error) {
+	var steps []Step
+	for idx, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		m := pcommCall.FindStringSubmatch(line)
+		if m == nil {
+			continue
+		}
+		text, rest := "", strings.TrimSpace(m[2])
+		if sm := pcommString.FindStringSubmatch(rest); sm != nil {
+			text = strings.ReplaceAll(sm[1], `""`, `"`)
+			rest = strings.TrimSpace(strings.TrimPrefix(rest[len(sm[0]):], ","))
+		}
+		args := macroInts(rest)
+		switch strings.ToLower(m[1]) {
+		case "sendkeys", "settext":
+			row, col := 0, 0
+			if len(args) >= 2 {
+				row, col = args[0], args[1]
+			}
+			keySteps, err := keyedTextSteps(text, row, col)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", idx+1, err)
+			}
+			steps = append(steps, keySteps...)
+		case "setcursorpos":
+			if len(args) < 2 {
+				return nil, fmt.Errorf("line %d: SetCursorPos needs a row and column", idx+1)
+			}
+			steps = append(steps, Step{Type: "MoveCursor", Coordinates: connect3270.Coordinates{Row: args[0], Column: args[1]}})
+		case "waitforstring":
+			step := Step{Type: "WaitForText", Text: text}
+			if len(args) >= 2 && args[0] > 0 && args[1] > 0 {
+				step.Coordinates = connect3270.Coordinates{Row: args[0], Column: args[1]}
+			}
+			if len(args) >= 3 && args[2] > 0 {
+				step.Timeout = float64(args[2]) / 1000
+			}
+			steps = append(steps, step)
+		}
+	}
+	return steps, nil
+}
+
+// macroInts parses a comma separated list of integer arguments, stopping
+// at the first argument that is not a number.
+func macroInts(list string) []int {
+	var values []int
+	for _, part := range strings.Split(list, ",") {
+		n, err := strconv.Atoi(strings.TrimSpace(part))
+		if err != nil {
+			break
+		}
+		values = append(values, n)
+	}
+	return values
+}
+
+func isHODMacro(path string, data []byte) bool {
+	return bytes.Contains(data, []byte("<HAScript"))
+}
+
+// hodMacro is the subset of Host On-Demand macro XML that maps to steps.
+type hodMacro struct {
+	Screens []hodScreen `xml:"screen"`
+}
+
+type hodScreen struct {
+	Name        string       `xml:"name,attr"`
+	Entry       bool         `xml:"entryscreen,attr"`
+	Exit        bool         `xml:"exitscreen,attr"`
+	Strings     []hodString  `xml:"description>string"`
+	Actions     hodActions   `xml:"actions"`
+	NextScreens []hodNextRef `xml:"nextscreens>nextscreen"`
+}
+
+type hodString struct {
+	Value    string `xml:"value,attr"`
+	Row      int    `xml:"row,attr"`
+	Col      int    `xml:"col,attr"`
+	Optional bool   `xml:"optional,attr"`
+	Invert   bool   `xml:"invertmatch,attr"`
+}
+
+type hodNextRef struct {
+	Name string `xml:"name,attr"`
+}
+
+// hodActions keeps the actions of a screen in document order.
+type hodActions struct {
+	Items []hodAction `xml:",any"`
+}
+
+type hodAction struct {
+	XMLName   xml.Name
+	Value     string `xml:"value,attr"`
+	Row       int    `xml:"row,attr"`
+	Col       int    `xml:"col,attr"`
+	Name      string `xml:"name,attr"`
+	Default   string `xml:"default,attr"`
+	Encrypted bool   `xml:"encrypted,attr"`
+	SRow      int    `xml:"srow,attr"`
+	SCol      int    `xml:"scol,attr"`
+	ERow      int    `xml:"erow,attr"`
+	ECol      int    `xml:"ecol,attr"`
+	AssignTo  string `xml:"assigntovar,attr"`
+}
+
+var macroVarName = regexp.MustCompile(`[^A-Za-z0-9_.-]+`)
+
+// parseHODMacro reads Host On-Demand macro XML. Screens are visited from the
+// entry screen along their first next screen (document order when that
+// chain is missing); each screen's description strings become WaitForText
+// steps and its actions become input steps.
+func parseHODMacro(data []byte) ([]Step, error) {
+	var macro hodMacro
+	if err := xml.Unmarshal(data, &macro); err != nil {
+		return nil, fmt.Errorf("decoding Host On-Demand macro: %w", err)
+	}
+	var steps []Step
+	for _, screen := range orderHODScreens(macro.Screens) {
+		for _, s := range screen.Strings {
+			if s.Optional || s.Invert || s.Value == "" {
+				continue
+			}
+			step := Step{Type: "WaitForText", Text: s.Value}
+			if s.Row > 0 && s.Col > 0 {
+				step.Coordinates = connect3270.Coordinates{Row: s.Row, Column: s.Col}
+			}
+			steps = append(steps, step)
+		}
+		for _, action := range screen.Actions.Items {
+			actionSteps, err := hodActionSteps(action)
+			if err != nil {
+				return nil, fmt.Errorf("screen %s: %w", screen.Name, err)
+			}
+			steps = append(steps, actionSteps...)
+		}
+	}
+	return steps, nil
+}
+
+func hodActionSteps(action hodAction) ([]Step, error) {
+	switch action.XMLName.Local {
+	case "input":
+		if action.Encrypted {
+			// Encrypted input cannot be recovered; read it from the environment.
+			name := "HOD_INPUT"
+			if action.Row > 0 {
+				name = fmt.Sprintf("HOD_INPUT_R%dC%d", action.Row, action.Col)
+			}
+			return []Step{hodFillStep("{{env:"+name+"}}", action.Row, action.Col)}, nil
+		}
+		return keyedTextSteps(action.Value, action.Row, action.Col)
+	case "prompt":
+		// Prompts ask the user at run time; take the answer from the environment.
+		name := strings.ToUpper(macroVarName.ReplaceAllString(action.Name, "_"))
+		if name == "" {
+			name = "HOD_PROMPT"
+		}
+		return []Step{hodFillStep("{{env:"+name+"}}", action.Row, action.Col)}, nil
+	case "mouseclick":
+		return []Step{{Type: "MoveCursor", Coordinates: connect3270.Coordinates{Row: action.Row, Column: action.Col}}}, nil
+	case "pause":
+		ms, err := strconv.Atoi(action.Value)
+		if err != nil || ms <= 0 {
+			return nil, nil
+		}
+		return []Step{{Type: "StepDelay", StepDelay: DelayRange{Min: float64(ms) / 1000}}}, nil
+	case "extract":
+		if action.SRow <= 0 || action.SCol <= 0 || action.ERow != action.SRow || action.ECol < action.SCol {
+			return nil, fmt.Errorf("extract %s must cover part of a single row", action.Name)
+		}
+		name := strings.Trim(action.AssignTo, "$")
+		if name == "" {
+			name = action.Name
+		}
+		return []Step{{
+			Type:        "ExtractValue",
+			Coordinates: connect3270.Coordinates{Row: action.SRow, Column: action.SCol, Length: action.ECol - action.SCol + 1},
+			Variable:    macroVarName.ReplaceAllString(name, "_"),
+		}}, nil
+	}
+	return nil, nil
+}
+
+func hodFillStep(text string, row, col int) Step {
+	step := Step{Type: "FillString", Text: text}
+	if row > 0 && col > 0 {
+		step.Coordinates = connect3270.Coordinates{Row: row, Column: col}
+	}
+	return step
+}
+
+func orderHODScreens(screens []hodScreen) []hodScreen {
+	byName := make(map[string]hodScreen, len(screens))
+	var current *hodScreen
+	for i := range screens {
+		byName[screens[i].Name] = screens[i]
+		if screens[i].Entry && current == nil {
+			current = &screens[i]
+		}
+	}
+	if current == nil {
+		return screens
+	}
+	var ordered []hodScreen
+	seen := make(map[string]bool)
+	for current != nil && !seen[current.Name] {
+		seen[current.Name] = true
+		ordered = append(ordered, *current)
+		if current.Exit || len(current.NextScreens) == 0 {
+			break
+		}
+		next, ok := byName[current.NextScreens[0].Name]
+		if !ok {
+			break
+		}
+		current = &next
+	}
+	return ordered
+}
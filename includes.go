@@ -0,0 +1,92 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// maxIncludeDepth bounds Include nesting as a backstop to cycle detection.
+const maxIncludeDepth = 16
+
+// expandIncludes replaces Include steps with the steps of the referenced
+// workflow files. Relative paths resolve against baseDir, the directory of
+// the including file.
+func expandIncludes(steps []Step, baseDir string) ([]Step, error) {
+	return expandIncludeSteps(steps, baseDir, nil)
+}
+
+func expandIncludeSteps(steps []Step, baseDir string, stack []string) ([]Step, error) {
+	if steps == nil {
+		return nil, nil
+	}
+	out := make([]Step, 0, len(steps))
+	for _, step := range steps {
+		if step.Type != "Include" {
+			var err error
+			if step.Steps, err = expandIncludeSteps(step.Steps, baseDir, stack); err != nil {
+				return nil, err
+			}
+			if step.Else, err = expandIncludeSteps(step.Else, baseDir, stack); err != nil {
+				return nil, err
+			}
+			out = append(out, step)
+			continue
+		}
+		if strings.TrimSpace(step.File) == "" {
+			return nil, fmt.Errorf("Include step needs a File - include what?")
+		}
+		path := step.File
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(baseDir, path)
+		}
+		absPath, err := filepath.Abs(path)
+		if err != nil {
+			return nil, err
+		}
+		for _, seen := range stack {
+			if seen == absPath {
+				return nil, fmt.Errorf("Include cycle detected: %s", strings.Join(append(stack, absPath), " -> "))
+			}
+		}
+		if len(stack) >= maxIncludeDepth {
+			return nil, fmt.Errorf("Include nesting deeper than %d levels at %s", maxIncludeDepth, absPath)
+		}
+		included, err := loadIncludedSteps(absPath)
+		if err != nil {
+			return nil, fmt.Errorf("Include %s: %w", step.File, err)
+		}
+		expanded, err := expandIncludeSteps(included, filepath.Dir(absPath), append(stack, absPath))
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, expanded...)
+	}
+	return out, nil
+}
+
+// loadIncludedSteps reads either a bare JSON array of steps or a full
+// workflow file, of which only the Steps are used.
+func loadIncludedSteps(path string) ([]Step, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	trimmed := strings.TrimSpace(string(data))
+	if strings.HasPrefix(trimmed, "[") {
+		var steps []Step
+		if err := json.Unmarshal(data, &steps); err != nil {
+			return nil, err
+		}
+		return steps, nil
+	}
+	var workflow struct {
+		Steps []Step
+	}
+	if err := json.Unmarshal(data, &workflow); err != nil {
+		return nil, err
+	}
+	return workflow.Steps, nil
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// iterationCount is the value of -iterations: a number of workflow runs, or
+// "rows" for one per injection data row.
+type iterationCount struct {
+	n    int
+	rows bool
+}
+
+func (c *iterationCount) String() string {
+	if c.rows {
+		return "rows"
+	}
+	return strconv.Itoa(c.n)
+}
+
+func (c *iterationCount) Set(value string) error {
+	if value == "rows" {
+		*c = iterationCount{rows: true}
+		return nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		return fmt.Errorf("want a number of workflow runs or \"rows\"")
+	}
+	*c = iterationCount{n: n}
+	return nil
+}
+
+var (
+	iterationsFlag         iterationCount
+	iterationsPerVUserFlag int
+)
+
+func init() {
+	flag.Var(&iterationsFlag, "iterations", "Run this many workflows in all instead of for -runtime seconds, or \"rows\" to run each workflow once per injection row")
+	flag.IntVar(&iterationsPerVUserFlag, "iterationsPerVUser", 0, "Run this many workflows on every vUser instead of for -runtime seconds")
+}
+
+// iterationMode reports whether the run counts workflows instead of, or as
+// well as, timing out after -runtime.
+func iterationMode() bool {
+	return iterationsFlag.rows || iterationsFlag.n > 0 || iterationsPerVUserFlag > 0
+}
+
+// iterationTotal returns how many workflows an iteration run starts in all:
+// with "rows", every workflow of a suite once for each injection row.
+func iterationTotal(config *Configuration, rows, vUsers int) int {
+	switch {
+	case iterationsPerVUserFlag > 0:
+		return iterationsPerVUserFlag * vUsers
+	case iterationsFlag.rows:
+		rows = max(rows, 1)
+		if len(config.Workflows) > 0 {
+			return rows * len(config.Workflows)
+		}
+		return rows
+	}
+	return iterationsFlag.n
+}
+
+// iterationBudget counts down the workflows an iteration run has left to
+// start. A nil budget never runs out.
+type iterationBudget struct {
+	total int
+	left  atomic.Int64
+}
+
+func newIterationBudget(total int) *iterationBudget {
+	if total <= 0 {
+		return nil
+	}
+	b := &iterationBudget{total: total}
+	b.left.Store(int64(total))
+	return b
+}
+
+// take uses up one workflow of the budget.
+func (b *iterationBudget) take() {
+	if b != nil {
+		b.left.Add(-1)
+	}
+}
+
+// spent reports whether every workflow of the budget has been started.
+func (b *iterationBudget) spent() bool {
+	return b != nil && b.left.Load() <= 0
+}
+
+// runDeadline returns when a concurrent run stops starting workflows: after
+// -runtime, or never for an iteration run without one.
+func runDeadline(overallStart time.Time) time.Time {
+	if runtimeDuration <= 0 {
+		return time.Time{}
+	}
+	return overallStart.Add(time.Duration(runtimeDuration) * time.Second)
+}
+
+// beforeDeadline reports whether t comes before deadline; every time does
+// when there is none.
+func beforeDeadline(t, deadline time.Time) bool {
+	return deadline.IsZero() || t.Before(deadline)
+}
@@ -0,0 +1,336 @@
+package main
+
+import (
+	"encoding/xml"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	junitPath     string
+	junitCaseMode string
+)
+
+func init() {
+	flag.StringVar(&junitPath, "junit", "", "Write the results as a JUnit XML report to this file, for Jenkins and GitLab")
+	flag.StringVar(&junitCaseMode, "junitCases", "workflow", "What a JUnit test case is: workflow (each workflow run) or check (each Check step)")
+}
+
+// junitMaxCases caps the test cases of a report; a long load run would
+// otherwise keep one per workflow in memory.
+const junitMaxCases = 100000
+
+func validateJUnit() error {
+	if junitPath == "" {
+		return nil
+	}
+	if junitCaseMode != "workflow" && junitCaseMode != "check" {
+		return fmt.Errorf("-junitCases must be workflow or check, not %q", junitCaseMode)
+	}
+	return nil
+}
+
+// junitCase is the outcome of one test case. Failure is set when a check
+// failed and Error when the workflow could not run, such as a connection
+// failure or a timeout.
+type junitCase struct {
+	Suite   string
+	Name    string
+	Seconds float64
+	Failure string
+	Error   string
+	Skipped string
+}
+
+var (
+	junitMu         sync.Mutex
+	junitCases      []junitCase
+	junitDropped    int
+	junitIterations = make(map[string]int)
+)
+
+func addJUnitCases(cases ...junitCase) {
+	junitMu.Lock()
+	defer junitMu.Unlock()
+	for _, c := range cases {
+		if len(junitCases) >= junitMaxCases {
+			junitDropped++
+			continue
+		}
+		junitCases = append(junitCases, c)
+	}
+}
+
+// junitSuiteName names the test suite of a workflow: the suite workflow it
+// runs, or else the workflow file.
+func junitSuiteName(config *Configuration) string {
+	if config.workflowName != "" {
+		return config.workflowName
+	}
+	return strings.TrimSuffix(filepath.Base(configFile), filepath.Ext(configFile))
+}
+
+// isCheckStep reports whether a step asserts something about the screen.
+func isCheckStep(step Step) bool {
+	return strings.HasPrefix(step.Type, "Check")
+}
+
+// junitStepName names a step in a test case.
+func junitStepName(n int, step Step) string {
+	name := fmt.Sprintf("step %d %s", n, step.Type)
+	if isCheckStep(step) && step.Text != "" {
+		name += fmt.Sprintf(" %q", step.Text)
+	}
+	if step.Coordinates.Row > 0 {
+		name += fmt.Sprintf(" at %d,%d", step.Coordinates.Row, step.Coordinates.Column)
+	}
+	return name
+}
+
+// junitTracker follows one workflow run for the JUnit report; it is nil
+// without -junit, and its methods then do nothing.
+type junitTracker struct {
+	suite      string
+	iteration  int
+	steps      []Step
+	setupSteps int
+	cases      []junitCase
+	reached    int
+	// failed says a step case already carries the failure.
+	failed bool
+}
+
+func newJUnitTracker(config *Configuration, steps []Step, setupSteps int) *junitTracker {
+	if junitPath == "" || config.phase != "" {
+		return nil
+	}
+	suite := junitSuiteName(config)
+	junitMu.Lock()
+	junitIterations[suite]++
+	iteration := junitIterations[suite]
+	junitMu.Unlock()
+	return &junitTracker{suite: suite, iteration: iteration, steps: steps, setupSteps: setupSteps}
+}
+
+func (t *junitTracker) stepName(idx int) string {
+	if idx < t.setupSteps {
+		return "SessionSetup " + junitStepName(idx+1, t.steps[idx])
+	}
+	return junitStepName(idx-t.setupSteps+1, t.steps[idx])
+}
+
+// stepDone records a step that ran. In check mode a check is a case of its
+// own, and so is any other step that failed; isError says the step could not
+// run, rather than finding the screen wrong.
+func (t *junitTracker) stepDone(idx int, d time.Duration, err error, isError bool) {
+	if t == nil {
+		return
+	}
+	t.reached = idx + 1
+	if junitCaseMode != "check" || (!isCheckStep(t.steps[idx]) && err == nil) {
+		return
+	}
+	c := junitCase{Suite: t.suite, Name: fmt.Sprintf("%s (iteration %d)", t.stepName(idx), t.iteration), Seconds: d.Seconds()}
+	switch {
+	case err != nil && isError:
+		c.Error = err.Error()
+	case err != nil:
+		c.Failure = err.Error()
+	}
+	t.failed = t.failed || err != nil
+	t.cases = append(t.cases, c)
+}
+
+// finish records the workflow run. failure is why it failed, and isError
+// says it could not run, such as on a connection failure or a timeout.
+func (t *junitTracker) finish(seconds float64, failure error, isError bool) {
+	if t == nil {
+		return
+	}
+	if junitCaseMode == "check" {
+		if failure != nil && !t.failed {
+			// The workflow ended outside a step, such as on a timeout.
+			c := junitCase{Suite: t.suite, Name: fmt.Sprintf("workflow (iteration %d)", t.iteration), Seconds: seconds, Failure: failure.Error()}
+			if isError {
+				c.Failure, c.Error = "", failure.Error()
+			}
+			t.cases = append(t.cases, c)
+		}
+		for idx := t.reached; idx < len(t.steps); idx++ {
+			if isCheckStep(t.steps[idx]) {
+				t.cases = append(t.cases, junitCase{Suite: t.suite, Name: fmt.Sprintf("%s (iteration %d)", t.stepName(idx), t.iteration), Skipped: "an earlier step failed"})
+			}
+		}
+		addJUnitCases(t.cases...)
+		return
+	}
+	c := junitCase{Suite: t.suite, Name: fmt.Sprintf("iteration %d", t.iteration), Seconds: seconds}
+	switch {
+	case failure != nil && isError:
+		c.Error = failure.Error()
+	case failure != nil:
+		c.Failure = failure.Error()
+	}
+	addJUnitCases(c)
+}
+
+// recordJUnitPhase records the Setup or Teardown workflow as a case of its
+// own suite.
+func recordJUnitPhase(phase, path string, d time.Duration, err error) {
+	if junitPath == "" {
+		return
+	}
+	c := junitCase{Suite: phase, Name: filepath.Base(path), Seconds: d.Seconds()}
+	if err != nil {
+		c.Error = err.Error()
+	}
+	addJUnitCases(c)
+}
+
+type junitTestSuites struct {
+	XMLName  xml.Name         `xml:"testsuites"`
+	Name     string           `xml:"name,attr"`
+	Tests    int              `xml:"tests,attr"`
+	Failures int              `xml:"failures,attr"`
+	Errors   int              `xml:"errors,attr"`
+	Skipped  int              `xml:"skipped,attr"`
+	Time     string           `xml:"time,attr"`
+	Suites   []junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	Name       string           `xml:"name,attr"`
+	Tests      int              `xml:"tests,attr"`
+	Failures   int              `xml:"failures,attr"`
+	Errors     int              `xml:"errors,attr"`
+	Skipped    int              `xml:"skipped,attr"`
+	Time       string           `xml:"time,attr"`
+	Timestamp  string           `xml:"timestamp,attr"`
+	Properties *junitProperties `xml:"properties"`
+	Cases      []junitTestCase  `xml:"testcase"`
+}
+
+type junitProperties struct {
+	Property []junitProperty `xml:"property"`
+}
+
+type junitProperty struct {
+	Name  string `xml:"name,attr"`
+	Value string `xml:"value,attr"`
+}
+
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	Classname string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitMessage `xml:"failure,omitempty"`
+	Error     *junitMessage `xml:"error,omitempty"`
+	Skipped   *junitMessage `xml:"skipped,omitempty"`
+}
+
+type junitMessage struct {
+	Message string `xml:"message,attr"`
+	Type    string `xml:"type,attr,omitempty"`
+	Text    string `xml:",chardata"`
+}
+
+func junitSeconds(s float64) string {
+	return fmt.Sprintf("%.3f", s)
+}
+
+// buildJUnitReport groups the cases into suites, in the order each suite
+// first appears.
+func buildJUnitReport(name string, cases []junitCase, started time.Time, dropped int) junitTestSuites {
+	report := junitTestSuites{Name: name}
+	index := make(map[string]int)
+	var total float64
+	suiteTimes := make(map[string]float64)
+	for _, c := range cases {
+		i, ok := index[c.Suite]
+		if !ok {
+			i = len(report.Suites)
+			index[c.Suite] = i
+			report.Suites = append(report.Suites, junitTestSuite{Name: c.Suite, Timestamp: started.Format("2006-01-02T15:04:05")})
+		}
+		suite := &report.Suites[i]
+		tc := junitTestCase{Name: c.Name, Classname: name + "." + c.Suite, Time: junitSeconds(c.Seconds)}
+		switch {
+		case c.Error != "":
+			tc.Error = &junitMessage{Message: c.Error, Type: "error", Text: c.Error}
+			suite.Errors++
+		case c.Failure != "":
+			tc.Failure = &junitMessage{Message: c.Failure, Type: "failure", Text: c.Failure}
+			suite.Failures++
+		case c.Skipped != "":
+			tc.Skipped = &junitMessage{Message: c.Skipped}
+			suite.Skipped++
+		}
+		suite.Tests++
+		suite.Cases = append(suite.Cases, tc)
+		suiteTimes[c.Suite] += c.Seconds
+		total += c.Seconds
+	}
+	for i := range report.Suites {
+		suite := &report.Suites[i]
+		suite.Time = junitSeconds(suiteTimes[suite.Name])
+		report.Tests += suite.Tests
+		report.Failures += suite.Failures
+		report.Errors += suite.Errors
+		report.Skipped += suite.Skipped
+	}
+	if dropped > 0 && len(report.Suites) > 0 {
+		report.Suites[0].Properties = &junitProperties{Property: []junitProperty{{Name: "droppedTestCases", Value: fmt.Sprintf("%d", dropped)}}}
+	}
+	report.Time = junitSeconds(total)
+	return report
+}
+
+// writeJUnitReport writes the cases recorded so far to -junit.
+func writeJUnitReport() {
+	if junitPath == "" {
+		return
+	}
+	junitMu.Lock()
+	cases := append([]junitCase(nil), junitCases...)
+	dropped := junitDropped
+	junitMu.Unlock()
+	// Setup runs first and Teardown last, whatever order the rest ran in.
+	sort.SliceStable(cases, func(i, j int) bool {
+		return junitPhaseOrder(cases[i].Suite) < junitPhaseOrder(cases[j].Suite)
+	})
+	name := strings.TrimSuffix(filepath.Base(configFile), filepath.Ext(configFile))
+	report := buildJUnitReport(name, cases, programStart, dropped)
+	data, err := xml.MarshalIndent(report, "", "  ")
+	if err == nil {
+		if dir := filepath.Dir(junitPath); dir != "." {
+			err = os.MkdirAll(dir, 0755)
+		}
+	}
+	if err == nil {
+		err = os.WriteFile(junitPath, append([]byte(xml.Header), append(data, '\n')...), 0644)
+	}
+	if err != nil {
+		pterm.Warning.Printf("Failed to write the JUnit report %s: %v\n", junitPath, err)
+		return
+	}
+	if dropped > 0 {
+		pterm.Warning.Printf("The JUnit report holds the first %d test cases; %d more were left out.\n", junitMaxCases, dropped)
+	}
+	reportLog.Info(fmt.Sprintf("JUnit report: %s (%d tests, %d failures, %d errors, %d skipped)", junitPath, report.Tests, report.Failures, report.Errors, report.Skipped))
+}
+
+func junitPhaseOrder(suite string) int {
+	switch suite {
+	case "Setup":
+		return 0
+	case "Teardown":
+		return 2
+	}
+	return 1
+}
@@ -0,0 +1,672 @@
+package main
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	connect3270 "github.com/3270io/3270Connect/connect3270"
+)
+
+var (
+	kafkaBrokers  string
+	kafkaTopic    string
+	kafkaTLS      bool
+	kafkaUser     string
+	kafkaPassword string
+)
+
+func init() {
+	flag.StringVar(&kafkaBrokers, "kafka", "", "Publish workflow started, completed and failed and step failure events as JSON to Kafka through these comma-separated brokers (host:port)")
+	flag.StringVar(&kafkaTopic, "kafkaTopic", "3270connect-events", "Kafka topic of the -kafka events")
+	flag.BoolVar(&kafkaTLS, "kafkaTLS", false, "Connect to the -kafka brokers over TLS")
+	flag.StringVar(&kafkaUser, "kafkaUser", "", "SASL/PLAIN user name for -kafka; needs -kafkaTLS")
+	flag.StringVar(&kafkaPassword, "kafkaPassword", "", "SASL/PLAIN password for -kafkaUser; accepts {{env:NAME}} and {{file:path}}")
+}
+
+const (
+	// kafkaTimeout bounds each exchange with a broker, and how long the end
+	// of a run waits for the events still queued.
+	kafkaTimeout = 10 * time.Second
+	// kafkaQueueSize is how many events may wait for the broker before new
+	// ones are dropped, so a slow broker never slows the workflows.
+	kafkaQueueSize = 10000
+	// kafkaBatchSize and kafkaLinger bound how many events go in one
+	// produce request, and how long an event waits for others to join it.
+	kafkaBatchSize = 500
+	kafkaLinger    = 250 * time.Millisecond
+	// kafkaMaxResponse bounds the responses read from a broker, which are
+	// small for the requests sent, so a bad frame length cannot exhaust
+	// memory.
+	kafkaMaxResponse = 4 << 20
+)
+
+// errKafkaCleartextPassword refuses SASL/PLAIN over plain TCP, which would
+// send the password in cleartext.
+var errKafkaCleartextPassword = errors.New("-kafkaUser needs -kafkaTLS - SASL/PLAIN would send the password in cleartext")
+
+// Workflow lifecycle events published to Kafka.
+const (
+	eventWorkflowStarted   = "workflow.started"
+	eventWorkflowCompleted = "workflow.completed"
+	eventWorkflowFailed    = "workflow.failed"
+	eventStepFailed        = "step.failed"
+)
+
+// workflowEvent is a Kafka message. Time, Host and LUName line it up with
+// what the host recorded of the session, such as its SMF and RMF records.
+type workflowEvent struct {
+	Event string    `json:"event"`
+	Time  time.Time `json:"time"`
+	// Run identifies the run the event belongs to; it is also the message
+	// key, so the events of a run stay in order on one partition.
+	Run string `json:"run"`
+	// Runner is the host name of the machine running the workflows.
+	Runner     string `json:"runner"`
+	Host       string `json:"host"`
+	LUName     string `json:"luName,omitempty"`
+	Workflow   string `json:"workflow,omitempty"`
+	VUser      int    `json:"vUser"`
+	ScriptPort string `json:"scriptPort,omitempty"`
+	DataRow    int    `json:"dataRow,omitempty"`
+	// Step names the failed step, and StepNumber counts it from 1.
+	Step       string `json:"step,omitempty"`
+	StepNumber int    `json:"stepNumber,omitempty"`
+	// Status is passed, failed, timeout or connect_failed, as in -results.
+	Status   string  `json:"status,omitempty"`
+	Duration float64 `json:"durationSeconds,omitempty"`
+	Error    string  `json:"error,omitempty"`
+}
+
+// runID identifies this run: when it started, where and in which process.
+func runID() string {
+	host, _ := os.Hostname()
+	return fmt.Sprintf("%s-%s-%d", programStart.UTC().Format("20060102T150405Z"), sanitizeArtifactName(host), os.Getpid())
+}
+
+// kafkaProducer publishes events to the -kafka topic in the background.
+// It is nil without -kafka, and its methods then do nothing.
+type kafkaProducer struct {
+	brokers  []string
+	topic    string
+	tls      bool
+	user     string
+	password string
+	run      string
+	runner   string
+
+	events    chan []byte
+	done      chan struct{}
+	published atomic.Int64
+	dropped   atomic.Int64
+
+	// conn and partition belong to the publishing goroutine.
+	conn      *kafkaConn
+	partition int32
+	failing   bool
+}
+
+var eventStream *kafkaProducer
+
+// openEventStream checks the -kafka flags and starts publishing; the
+// brokers are connected to with the first event.
+func openEventStream() error {
+	if strings.TrimSpace(kafkaBrokers) == "" {
+		return nil
+	}
+	p := &kafkaProducer{topic: kafkaTopic, tls: kafkaTLS, user: kafkaUser, run: runID()}
+	p.runner, _ = os.Hostname()
+	for _, broker := range strings.Split(kafkaBrokers, ",") {
+		if broker = strings.TrimSpace(broker); broker == "" {
+			continue
+		}
+		if _, _, err := net.SplitHostPort(broker); err != nil {
+			return fmt.Errorf("-kafka broker %q must be host:port: %v", broker, err)
+		}
+		p.brokers = append(p.brokers, broker)
+	}
+	if strings.TrimSpace(p.topic) == "" {
+		return fmt.Errorf("-kafkaTopic cannot be empty")
+	}
+	if p.user != "" {
+		if !p.tls {
+			return errKafkaCleartextPassword
+		}
+		password, err := resolveSecretPlaceholders(kafkaPassword)
+		if err != nil {
+			return fmt.Errorf("-kafkaPassword: %w", err)
+		}
+		p.password = password
+	}
+	p.events = make(chan []byte, kafkaQueueSize)
+	p.done = make(chan struct{})
+	eventStream = p
+	storeLog(fmt.Sprintf("Publishing workflow events to Kafka topic %s through %s as run %s", p.topic, strings.Join(p.brokers, ", "), p.run))
+	go p.loop()
+	return nil
+}
+
+// publish queues ev for the topic; when the queue is full it is dropped.
+func (p *kafkaProducer) publish(ev workflowEvent) {
+	ev.Time, ev.Run, ev.Runner = time.Now().UTC(), p.run, p.runner
+	data, err := json.Marshal(ev)
+	if err != nil {
+		p.dropped.Add(1)
+		return
+	}
+	select {
+	case p.events <- data:
+	default:
+		p.dropped.Add(1)
+	}
+}
+
+// loop sends the queued events in batches until the queue is closed.
+func (p *kafkaProducer) loop() {
+	defer close(p.done)
+	defer func() {
+		if p.conn != nil {
+			p.conn.Close()
+		}
+	}()
+	for first := range p.events {
+		batch := [][]byte{first}
+		linger := time.NewTimer(kafkaLinger)
+	collect:
+		for len(batch) < kafkaBatchSize {
+			select {
+			case ev, ok := <-p.events:
+				if !ok {
+					break collect
+				}
+				batch = append(batch, ev)
+			case <-linger.C:
+				break collect
+			}
+		}
+		linger.Stop()
+		p.send(batch)
+	}
+}
+
+// send produces batch, with one retry on a fresh connection. A broker that
+// fails is warned about once, and its events are dropped until it recovers.
+func (p *kafkaProducer) send(batch [][]byte) {
+	err := p.produce(batch)
+	if err != nil {
+		err = p.produce(batch)
+	}
+	if err != nil {
+		p.dropped.Add(int64(len(batch)))
+		if !p.failing {
+			p.failing = true
+			reportLog.Warn(fmt.Sprintf("Publishing workflow events to Kafka failed, dropping them until it recovers: %v", err))
+		}
+		return
+	}
+	p.published.Add(int64(len(batch)))
+	if p.failing {
+		p.failing = false
+		reportLog.Info("Publishing workflow events to Kafka again")
+	}
+}
+
+func (p *kafkaProducer) produce(batch [][]byte) error {
+	if p.conn == nil {
+		if err := p.connect(); err != nil {
+			return err
+		}
+	}
+	err := p.conn.produce(p.topic, p.partition, []byte(p.run), batch, time.Now())
+	if err != nil {
+		p.conn.Close()
+		p.conn = nil
+	}
+	return err
+}
+
+// connect asks the brokers where the topic lives and connects to the
+// leader of this run's partition.
+func (p *kafkaProducer) connect() error {
+	var errs []error
+	for _, broker := range p.brokers {
+		conn, err := p.dial(broker)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		partitions, err := conn.metadata(p.topic)
+		if err != nil {
+			conn.Close()
+			errs = append(errs, fmt.Errorf("%s: %w", broker, err))
+			continue
+		}
+		partition := partitions[int(crc32.ChecksumIEEE([]byte(p.run))%uint32(len(partitions)))]
+		if partition.leader != broker {
+			conn.Close()
+			if conn, err = p.dial(partition.leader); err != nil {
+				errs = append(errs, err)
+				continue
+			}
+		}
+		p.conn, p.partition = conn, partition.id
+		storeLog(fmt.Sprintf("Publishing workflow events to partition %d of %s on %s", partition.id, p.topic, partition.leader))
+		return nil
+	}
+	return errors.Join(errs...)
+}
+
+func (p *kafkaProducer) dial(addr string) (*kafkaConn, error) {
+	dialer := &net.Dialer{Timeout: kafkaTimeout}
+	var conn net.Conn
+	var err error
+	if p.tls {
+		host, _, _ := net.SplitHostPort(addr)
+		conn, err = tls.DialWithDialer(dialer, "tcp", addr, &tls.Config{ServerName: host})
+	} else {
+		conn, err = dialer.Dial("tcp", addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+	c := &kafkaConn{conn: conn, r: bufio.NewReader(conn)}
+	if p.user != "" {
+		if err := c.authenticate(p.user, p.password); err != nil {
+			c.Close()
+			return nil, fmt.Errorf("%s: %w", addr, err)
+		}
+	}
+	return c, nil
+}
+
+// closeEventStream publishes the events still queued, waiting up to
+// kafkaTimeout, and reports how many were published.
+func closeEventStream() {
+	p := eventStream
+	if p == nil {
+		return
+	}
+	eventStream = nil
+	close(p.events)
+	select {
+	case <-p.done:
+	case <-time.After(kafkaTimeout):
+		reportLog.Warn("Gave up waiting for the workflow events to be published to Kafka")
+	}
+	msg := fmt.Sprintf("Published %d workflow events to Kafka topic %s", p.published.Load(), p.topic)
+	if dropped := p.dropped.Load(); dropped > 0 {
+		reportLog.Warn(fmt.Sprintf("%s; dropped %d", msg, dropped))
+		return
+	}
+	reportLog.Info(msg)
+}
+
+// publishWorkflowStarted publishes workflow.started for a workflow run
+// about to connect.
+func publishWorkflowStarted(e *connect3270.Emulator, config *Configuration) {
+	p := eventStream
+	if p == nil || config.phase != "" {
+		return
+	}
+	p.publish(workflowEvent{
+		Event:      eventWorkflowStarted,
+		Host:       net.JoinHostPort(config.Host, strconv.Itoa(config.Port)),
+		LUName:     e.LUName,
+		Workflow:   config.workflowName,
+		VUser:      max(config.vUser, 1),
+		ScriptPort: e.ScriptPort,
+		DataRow:    config.dataRow,
+	})
+}
+
+// publishStepFailed publishes step.failed for the step numbered stepNumber
+// from 1.
+func publishStepFailed(e *connect3270.Emulator, config *Configuration, stepNumber int, step string, err error) {
+	p := eventStream
+	if p == nil || config.phase != "" {
+		return
+	}
+	p.publish(workflowEvent{
+		Event:      eventStepFailed,
+		Host:       net.JoinHostPort(config.Host, strconv.Itoa(config.Port)),
+		LUName:     e.LUName,
+		Workflow:   config.workflowName,
+		VUser:      max(config.vUser, 1),
+		ScriptPort: e.ScriptPort,
+		DataRow:    config.dataRow,
+		Step:       step,
+		StepNumber: stepNumber,
+		Error:      err.Error(),
+	})
+}
+
+// publishWorkflowResult publishes workflow.completed or workflow.failed for
+// a finished workflow run.
+func publishWorkflowResult(e *connect3270.Emulator, config *Configuration, s resultSample) {
+	p := eventStream
+	if p == nil {
+		return
+	}
+	event := eventWorkflowCompleted
+	if s.Status != "passed" {
+		event = eventWorkflowFailed
+	}
+	p.publish(workflowEvent{
+		Event:      event,
+		Host:       net.JoinHostPort(config.Host, strconv.Itoa(config.Port)),
+		LUName:     e.LUName,
+		Workflow:   s.Workflow,
+		VUser:      s.VUser,
+		ScriptPort: e.ScriptPort,
+		DataRow:    s.DataRow,
+		Step:       s.FailingStep,
+		Status:     s.Status,
+		Duration:   s.Duration,
+		Error:      s.Error,
+	})
+}
+
+// The Kafka protocol, as much of it as producing needs: SASL/PLAIN,
+// Metadata v4 and Produce v3 with v2 record batches, which brokers from
+// 1.0 on understand.
+const (
+	kafkaAPIProduce          = 0
+	kafkaAPIMetadata         = 3
+	kafkaAPISaslHandshake    = 17
+	kafkaAPISaslAuthenticate = 36
+)
+
+// kafkaConn is a connection to a broker.
+type kafkaConn struct {
+	conn        net.Conn
+	r           *bufio.Reader
+	correlation int32
+}
+
+func (c *kafkaConn) Close() error { return c.conn.Close() }
+
+// roundTrip sends a request and returns the body of its response.
+func (c *kafkaConn) roundTrip(apiKey, version int16, body []byte) ([]byte, error) {
+	c.correlation++
+	var req kafkaEncoder
+	req.int16(apiKey)
+	req.int16(version)
+	req.int32(c.correlation)
+	req.string("3270connect")
+	req.raw(body)
+	frame := binary.BigEndian.AppendUint32(nil, uint32(len(req.buf)))
+	c.conn.SetDeadline(time.Now().Add(kafkaTimeout))
+	if _, err := c.conn.Write(append(frame, req.buf...)); err != nil {
+		return nil, err
+	}
+	var size [4]byte
+	if _, err := io.ReadFull(c.r, size[:]); err != nil {
+		return nil, err
+	}
+	length := binary.BigEndian.Uint32(size[:])
+	if length > kafkaMaxResponse {
+		return nil, fmt.Errorf("kafka response of %d bytes is over the %d byte limit", length, kafkaMaxResponse)
+	}
+	resp := make([]byte, length)
+	if _, err := io.ReadFull(c.r, resp); err != nil {
+		return nil, err
+	}
+	if len(resp) < 4 || int32(binary.BigEndian.Uint32(resp)) != c.correlation {
+		return nil, fmt.Errorf("kafka response out of order")
+	}
+	return resp[4:], nil
+}
+
+// authenticate logs on with SASL/PLAIN.
+func (c *kafkaConn) authenticate(user, password string) error {
+	var req kafkaEncoder
+	req.string("PLAIN")
+	resp, err := c.roundTrip(kafkaAPISaslHandshake, 1, req.buf)
+	if err != nil {
+		return err
+	}
+	d := kafkaDecoder{buf: resp}
+	if code := d.int16(); code != 0 {
+		return kafkaError("SASL handshake", code)
+	}
+	req = kafkaEncoder{}
+	req.bytes([]byte("\x00" + user + "\x00" + password))
+	if resp, err = c.roundTrip(kafkaAPISaslAuthenticate, 0, req.buf); err != nil {
+		return err
+	}
+	d = kafkaDecoder{buf: resp}
+	if code, msg := d.int16(), d.nullableString(); code != 0 {
+		return fmt.Errorf("SASL authentication failed: %s", msg)
+	}
+	return d.err
+}
+
+// kafkaPartition is a partition of a topic and the broker leading it.
+type kafkaPartition struct {
+	id     int32
+	leader string
+}
+
+// metadata returns the partitions of topic, which the broker may create.
+func (c *kafkaConn) metadata(topic string) ([]kafkaPartition, error) {
+	var req kafkaEncoder
+	req.int32(1)
+	req.string(topic)
+	req.int8(1) // allow_auto_topic_creation
+	resp, err := c.roundTrip(kafkaAPIMetadata, 4, req.buf)
+	if err != nil {
+		return nil, err
+	}
+	d := kafkaDecoder{buf: resp}
+	d.int32() // throttle_time_ms
+	brokers := make(map[int32]string)
+	for n := d.count(); n > 0; n-- {
+		id, host, port := d.int32(), d.string(), d.int32()
+		d.nullableString() // rack
+		brokers[id] = net.JoinHostPort(host, strconv.Itoa(int(port)))
+	}
+	d.nullableString() // cluster_id
+	d.int32()          // controller_id
+	var partitions []kafkaPartition
+	var topicErr error
+	for n := d.count(); n > 0; n-- {
+		code, name := d.int16(), d.string()
+		d.int8() // is_internal
+		if code != 0 && name == topic {
+			topicErr = kafkaError("topic "+topic, code)
+		}
+		for p := d.count(); p > 0; p-- {
+			code, id, leader := d.int16(), d.int32(), d.int32()
+			d.int32s() // replica_nodes
+			d.int32s() // isr_nodes
+			if addr, ok := brokers[leader]; ok && code == 0 && name == topic {
+				partitions = append(partitions, kafkaPartition{id: id, leader: addr})
+			}
+		}
+	}
+	if d.err != nil {
+		return nil, d.err
+	}
+	if topicErr != nil {
+		return nil, topicErr
+	}
+	if len(partitions) == 0 {
+		return nil, fmt.Errorf("topic %s has no partition with a leader", topic)
+	}
+	return partitions, nil
+}
+
+// produce appends values to partition of topic, with key, and waits for the
+// leader to write them.
+func (c *kafkaConn) produce(topic string, partition int32, key []byte, values [][]byte, now time.Time) error {
+	batch := kafkaRecordBatch(key, values, now)
+	var req kafkaEncoder
+	req.int16(-1) // transactional_id
+	req.int16(1)  // acks: the leader
+	req.int32(int32(kafkaTimeout / time.Millisecond))
+	req.int32(1)
+	req.string(topic)
+	req.int32(1)
+	req.int32(partition)
+	req.bytes(batch)
+	resp, err := c.roundTrip(kafkaAPIProduce, 3, req.buf)
+	if err != nil {
+		return err
+	}
+	d := kafkaDecoder{buf: resp}
+	for n := d.count(); n > 0; n-- {
+		d.string()
+		for p := d.count(); p > 0; p-- {
+			d.int32()
+			if code := d.int16(); code != 0 {
+				return kafkaError("produce", code)
+			}
+			d.int64() // base_offset
+			d.int64() // log_append_time_ms
+		}
+	}
+	return d.err
+}
+
+// kafkaRecordBatch encodes values as a v2 record batch, all with key.
+func kafkaRecordBatch(key []byte, values [][]byte, now time.Time) []byte {
+	var records kafkaEncoder
+	for i, value := range values {
+		var rec kafkaEncoder
+		rec.int8(0)   // attributes
+		rec.varint(0) // timestamp_delta
+		rec.varint(int64(i))
+		rec.varint(int64(len(key)))
+		rec.raw(key)
+		rec.varint(int64(len(value)))
+		rec.raw(value)
+		rec.varint(0) // headers
+		records.varint(int64(len(rec.buf)))
+		records.raw(rec.buf)
+	}
+	ms := now.UnixMilli()
+	var tail kafkaEncoder
+	tail.int16(0) // attributes: no compression
+	tail.int32(int32(len(values) - 1))
+	tail.int64(ms) // first_timestamp
+	tail.int64(ms) // max_timestamp
+	tail.int64(-1) // producer_id
+	tail.int16(-1) // producer_epoch
+	tail.int32(-1) // base_sequence
+	tail.int32(int32(len(values)))
+	tail.raw(records.buf)
+
+	var batch kafkaEncoder
+	batch.int64(0) // base_offset
+	batch.int32(int32(4 + 1 + 4 + len(tail.buf)))
+	batch.int32(-1) // partition_leader_epoch
+	batch.int8(2)   // magic
+	batch.int32(int32(crc32.Checksum(tail.buf, crc32.MakeTable(crc32.Castagnoli))))
+	batch.raw(tail.buf)
+	return batch.buf
+}
+
+func kafkaError(what string, code int16) error {
+	if name, ok := kafkaErrorNames[code]; ok {
+		return fmt.Errorf("%s: %s", what, name)
+	}
+	return fmt.Errorf("%s: kafka error %d", what, code)
+}
+
+var kafkaErrorNames = map[int16]string{
+	3:  "unknown topic or partition",
+	5:  "leader not available",
+	6:  "not leader for partition",
+	7:  "request timed out",
+	10: "message too large",
+	29: "topic authorization failed",
+	31: "cluster authorization failed",
+	33: "unsupported SASL mechanism",
+	58: "SASL authentication failed",
+}
+
+// kafkaEncoder writes the big-endian primitives of the Kafka protocol.
+type kafkaEncoder struct{ buf []byte }
+
+func (e *kafkaEncoder) raw(b []byte)   { e.buf = append(e.buf, b...) }
+func (e *kafkaEncoder) int8(v int8)    { e.buf = append(e.buf, byte(v)) }
+func (e *kafkaEncoder) int16(v int16)  { e.buf = binary.BigEndian.AppendUint16(e.buf, uint16(v)) }
+func (e *kafkaEncoder) int32(v int32)  { e.buf = binary.BigEndian.AppendUint32(e.buf, uint32(v)) }
+func (e *kafkaEncoder) int64(v int64)  { e.buf = binary.BigEndian.AppendUint64(e.buf, uint64(v)) }
+func (e *kafkaEncoder) varint(v int64) { e.buf = binary.AppendVarint(e.buf, v) }
+
+func (e *kafkaEncoder) string(s string) {
+	e.int16(int16(len(s)))
+	e.buf = append(e.buf, s...)
+}
+
+func (e *kafkaEncoder) bytes(b []byte) {
+	e.int32(int32(len(b)))
+	e.raw(b)
+}
+
+// kafkaDecoder reads what kafkaEncoder writes; past the end of buf it
+// reads zeros and sets err.
+type kafkaDecoder struct {
+	buf []byte
+	err error
+}
+
+func (d *kafkaDecoder) next(n int) []byte {
+	if d.err != nil || n < 0 || len(d.buf) < n {
+		if d.err == nil {
+			d.err = fmt.Errorf("kafka response too short")
+		}
+		d.buf = nil
+		return make([]byte, max(n, 0))
+	}
+	b := d.buf[:n]
+	d.buf = d.buf[n:]
+	return b
+}
+
+func (d *kafkaDecoder) int8() int8   { return int8(d.next(1)[0]) }
+func (d *kafkaDecoder) int16() int16 { return int16(binary.BigEndian.Uint16(d.next(2))) }
+func (d *kafkaDecoder) int32() int32 { return int32(binary.BigEndian.Uint32(d.next(4))) }
+func (d *kafkaDecoder) int64() int64 { return int64(binary.BigEndian.Uint64(d.next(8))) }
+
+// count reads the length of an array.
+func (d *kafkaDecoder) count() int {
+	n := int(d.int32())
+	if n < 0 || n > len(d.buf) {
+		// Every element takes at least a byte.
+		if n > len(d.buf) && d.err == nil {
+			d.err = fmt.Errorf("kafka response too short")
+		}
+		return 0
+	}
+	return n
+}
+
+func (d *kafkaDecoder) int32s() {
+	d.next(4 * d.count())
+}
+
+func (d *kafkaDecoder) string() string {
+	return string(d.next(int(d.int16())))
+}
+
+func (d *kafkaDecoder) nullableString() string {
+	n := d.int16()
+	if n < 0 {
+		return ""
+	}
+	return string(d.next(int(n)))
+}
@@ -0,0 +1,722 @@
+package main
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	serviceAccountDir     = "/var/run/secrets/kubernetes.io/serviceaccount"
+	k8sConfigMountPath    = "/etc/3270connect"
+	k8sStartupAllowance   = 5 * time.Minute
+	k8sStatusPollInterval = 5 * time.Second
+	// k8sStopAllowance is how long a stopped controller waits for the
+	// reports of its workers once their drain is over.
+	k8sStopAllowance = 15 * time.Second
+	// reportTokenEnv holds, in a worker, the token its reports must carry
+	// for the controller to take them.
+	reportTokenEnv = "REPORT_TOKEN"
+)
+
+var (
+	k8sController     bool
+	k8sWorkers        int
+	k8sImage          string
+	k8sNamespace      string
+	k8sReportPort     int
+	k8sControllerAddr string
+	reportToURL       string
+)
+
+func init() {
+	flag.BoolVar(&k8sController, "k8s", false, "Run as a Kubernetes controller that spreads -concurrent vUsers across worker Jobs")
+	flag.IntVar(&k8sWorkers, "k8sWorkers", 2, "Number of worker Jobs to launch in -k8s mode")
+	flag.StringVar(&k8sImage, "k8sImage", "3270io/3270connect-linux:latest", "Container image for -k8s worker Jobs")
+	flag.StringVar(&k8sNamespace, "k8sNamespace", "", "Namespace for -k8s worker Jobs (defaults to the controller's namespace)")
+	flag.IntVar(&k8sReportPort, "k8sReportPort", 9300, "Port the -k8s controller listens on for worker reports")
+	flag.StringVar(&k8sControllerAddr, "k8sControllerAddr", "", "Address workers use to reach the -k8s controller (defaults to $POD_IP)")
+	flag.StringVar(&reportToURL, "reportTo", "", "POST the final run report as JSON to this URL (used by -k8s workers)")
+}
+
+// runReport is the per-process outcome of a concurrent run, exchanged between
+// -k8s workers and their controller.
+type runReport struct {
+	Worker         string  `json:"worker"`
+	Started        int64   `json:"started"`
+	Completed      int64   `json:"completed"`
+	Failed         int64   `json:"failed"`
+	Active         int     `json:"active"`
+	VUsers         int     `json:"vUsers"`
+	DurationSum    float64 `json:"durationSum"`
+	DurationCount  int64   `json:"durationCount"`
+	AvgCPU         float64 `json:"avgCpu"`
+	AvgMem         float64 `json:"avgMem"`
+	ElapsedSeconds float64 `json:"elapsedSeconds"`
+	// Transactions holds the worker's per-transaction timings.
+	Transactions map[string]timingStat `json:"transactions,omitempty"`
+	// Histogram buckets the worker's workflow durations for percentiles.
+	Histogram map[int]int64 `json:"histogram,omitempty"`
+	// Dropped counts the arrivals of an open-model run that found every
+	// vUser busy.
+	Dropped int64 `json:"droppedArrivals,omitempty"`
+	// Aborted says why the worker's error budget stopped it, if it did.
+	Aborted string `json:"aborted,omitempty"`
+	// Stopped says why the worker stopped early, such as a SIGTERM on
+	// scale-down, if it did.
+	Stopped string `json:"stopped,omitempty"`
+}
+
+func (r runReport) averageDuration() float64 {
+	if r.DurationCount == 0 {
+		return 0
+	}
+	return r.DurationSum / float64(r.DurationCount)
+}
+
+// mergeRunReports combines worker reports into one run-wide report.
+func mergeRunReports(reports []runReport) runReport {
+	merged := runReport{Worker: "merged"}
+	if len(reports) == 0 {
+		return merged
+	}
+	for _, r := range reports {
+		merged.Started += r.Started
+		merged.Completed += r.Completed
+		merged.Failed += r.Failed
+		merged.Active += r.Active
+		merged.VUsers += r.VUsers
+		merged.DurationSum += r.DurationSum
+		merged.DurationCount += r.DurationCount
+		merged.AvgCPU += r.AvgCPU
+		merged.AvgMem += r.AvgMem
+		if r.ElapsedSeconds > merged.ElapsedSeconds {
+			merged.ElapsedSeconds = r.ElapsedSeconds
+		}
+		merged.Transactions = mergeTimingStats(merged.Transactions, r.Transactions)
+		for bucket, n := range r.Histogram {
+			if merged.Histogram == nil {
+				merged.Histogram = make(map[int]int64)
+			}
+			merged.Histogram[bucket] += n
+		}
+		merged.Dropped += r.Dropped
+		if merged.Aborted == "" && r.Aborted != "" {
+			merged.Aborted = r.Worker + ": " + r.Aborted
+		}
+		if merged.Stopped == "" && r.Stopped != "" {
+			merged.Stopped = r.Worker + ": " + r.Stopped
+		}
+	}
+	merged.AvgCPU /= float64(len(reports))
+	merged.AvgMem /= float64(len(reports))
+	return merged
+}
+
+// splitIterations divides an -iterations total across worker Jobs in
+// proportion to their vUsers.
+func splitIterations(total int, shares []int) []int {
+	split := make([]int, len(shares))
+	vUsers := 0
+	for _, share := range shares {
+		vUsers += share
+	}
+	if total <= 0 || vUsers == 0 {
+		return split
+	}
+	given := 0
+	for i, share := range shares {
+		split[i] = total * share / vUsers
+		given += split[i]
+	}
+	for i := 0; given < total; i = (i + 1) % len(split) {
+		split[i]++
+		given++
+	}
+	return split
+}
+
+// distributeVUsers splits total vUsers across workers as evenly as possible.
+func distributeVUsers(total, workers int) []int {
+	if workers <= 0 {
+		return nil
+	}
+	if total < workers {
+		workers = total
+	}
+	shares := make([]int, workers)
+	for i := range shares {
+		shares[i] = total / workers
+		if i < total%workers {
+			shares[i]++
+		}
+	}
+	return shares
+}
+
+// postRunReport sends the final report to -reportTo, retrying briefly so a
+// worker finishing while the controller is busy is not lost.
+func postRunReport(report runReport) {
+	if strings.TrimSpace(reportToURL) == "" {
+		return
+	}
+	body, err := json.Marshal(report)
+	if err != nil {
+		pterm.Warning.Printf("Failed to encode run report: %v\n", err)
+		return
+	}
+	client := &http.Client{Timeout: 10 * time.Second}
+	var lastErr error
+	for attempt := 0; attempt < 5; attempt++ {
+		req, err := http.NewRequest(http.MethodPost, reportToURL, bytes.NewReader(body))
+		if err != nil {
+			pterm.Warning.Printf("Failed to deliver run report to %s: %v\n", reportToURL, err)
+			return
+		}
+		req.Header.Set("Content-Type", "application/json")
+		if token := os.Getenv(reportTokenEnv); token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := client.Do(req)
+		if err == nil {
+			resp.Body.Close()
+			if resp.StatusCode < 300 {
+				storeLog("Run report delivered to " + reportToURL)
+				return
+			}
+			err = fmt.Errorf("unexpected status %s", resp.Status)
+		}
+		lastErr = err
+		time.Sleep(time.Duration(attempt+1) * time.Second)
+	}
+	pterm.Warning.Printf("Failed to deliver run report to %s: %v\n", reportToURL, lastErr)
+}
+
+// k8sClient is a minimal in-cluster Kubernetes REST client.
+type k8sClient struct {
+	baseURL   string
+	token     string
+	namespace string
+	http      *http.Client
+}
+
+func newInClusterK8sClient() (*k8sClient, error) {
+	host := os.Getenv("KUBERNETES_SERVICE_HOST")
+	port := os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || port == "" {
+		return nil, errors.New("not running inside a Kubernetes cluster (KUBERNETES_SERVICE_HOST unset)")
+	}
+	token, err := os.ReadFile(filepath.Join(serviceAccountDir, "token"))
+	if err != nil {
+		return nil, fmt.Errorf("reading service account token: %w", err)
+	}
+	caData, err := os.ReadFile(filepath.Join(serviceAccountDir, "ca.crt"))
+	if err != nil {
+		return nil, fmt.Errorf("reading service account CA: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(caData) {
+		return nil, errors.New("service account CA bundle contains no certificates")
+	}
+	namespace := strings.TrimSpace(k8sNamespace)
+	if namespace == "" {
+		if data, err := os.ReadFile(filepath.Join(serviceAccountDir, "namespace")); err == nil {
+			namespace = strings.TrimSpace(string(data))
+		}
+	}
+	if namespace == "" {
+		namespace = "default"
+	}
+	return &k8sClient{
+		baseURL:   "https://" + net.JoinHostPort(host, port),
+		token:     strings.TrimSpace(string(token)),
+		namespace: namespace,
+		http: &http.Client{
+			Timeout:   30 * time.Second,
+			Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}},
+		},
+	}, nil
+}
+
+func (c *k8sClient) do(method, path string, payload interface{}, out interface{}) error {
+	var body io.Reader
+	if payload != nil {
+		data, err := json.Marshal(payload)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, c.baseURL+path, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.token)
+	req.Header.Set("Accept", "application/json")
+	if payload != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("%s %s: %s: %s", method, path, resp.Status, strings.TrimSpace(string(data)))
+	}
+	if out != nil {
+		return json.Unmarshal(data, out)
+	}
+	return nil
+}
+
+func (c *k8sClient) createSecret(name string, files map[string]string) error {
+	secret := map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "Secret",
+		"metadata":   map[string]interface{}{"name": name, "labels": k8sRunLabels(name)},
+		"type":       "Opaque",
+		"stringData": files,
+	}
+	return c.do(http.MethodPost, fmt.Sprintf("/api/v1/namespaces/%s/secrets", c.namespace), secret, nil)
+}
+
+func (c *k8sClient) createJob(job map[string]interface{}) error {
+	return c.do(http.MethodPost, fmt.Sprintf("/apis/batch/v1/namespaces/%s/jobs", c.namespace), job, nil)
+}
+
+// jobFailed reports whether the named Job has given up on its pod.
+func (c *k8sClient) jobFailed(name string) (bool, error) {
+	var job struct {
+		Status struct {
+			Failed     int `json:"failed"`
+			Conditions []struct {
+				Type   string `json:"type"`
+				Status string `json:"status"`
+			} `json:"conditions"`
+		} `json:"status"`
+	}
+	if err := c.do(http.MethodGet, fmt.Sprintf("/apis/batch/v1/namespaces/%s/jobs/%s", c.namespace, name), nil, &job); err != nil {
+		return false, err
+	}
+	for _, cond := range job.Status.Conditions {
+		if cond.Type == "Failed" && cond.Status == "True" {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (c *k8sClient) deleteJob(name string) error {
+	opts := map[string]interface{}{"propagationPolicy": "Background"}
+	return c.do(http.MethodDelete, fmt.Sprintf("/apis/batch/v1/namespaces/%s/jobs/%s", c.namespace, name), opts, nil)
+}
+
+func (c *k8sClient) deleteSecret(name string) error {
+	return c.do(http.MethodDelete, fmt.Sprintf("/api/v1/namespaces/%s/secrets/%s", c.namespace, name), nil, nil)
+}
+
+func k8sRunLabels(runName string) map[string]string {
+	return map[string]string{
+		"app.kubernetes.io/name":       "3270connect",
+		"app.kubernetes.io/managed-by": "3270connect-controller",
+		"3270connect.io/run":           runName,
+	}
+}
+
+// buildWorkerJob renders the batch/v1 Job for one worker share.
+func buildWorkerJob(runName string, index int, image, secretName string, args []string) map[string]interface{} {
+	name := fmt.Sprintf("%s-w%d", runName, index)
+	backoffLimit := 0
+	return map[string]interface{}{
+		"apiVersion": "batch/v1",
+		"kind":       "Job",
+		"metadata":   map[string]interface{}{"name": name, "labels": k8sRunLabels(runName)},
+		"spec": map[string]interface{}{
+			"backoffLimit": backoffLimit,
+			"template": map[string]interface{}{
+				"metadata": map[string]interface{}{"labels": k8sRunLabels(runName)},
+				"spec": map[string]interface{}{
+					"restartPolicy": "Never",
+					"containers": []interface{}{
+						map[string]interface{}{
+							"name":  "worker",
+							"image": image,
+							"args":  args,
+							"env": []interface{}{
+								map[string]interface{}{
+									"name": reportTokenEnv,
+									"valueFrom": map[string]interface{}{
+										"secretKeyRef": map[string]interface{}{"name": secretName, "key": "report-token"},
+									},
+								},
+							},
+							"volumeMounts": []interface{}{
+								map[string]interface{}{"name": "run-config", "mountPath": k8sConfigMountPath, "readOnly": true},
+							},
+						},
+					},
+					"volumes": []interface{}{
+						map[string]interface{}{"name": "run-config", "secret": map[string]interface{}{"secretName": secretName}},
+					},
+				},
+			},
+		},
+	}
+}
+
+// workerArgs builds a worker's command line. arrivalRate and iterations are
+// the worker's share of an open-model run's rate and of -iterations, or 0.
+func workerArgs(vUsers int, arrivalRate float64, iterations int, hasInjection bool, reportURL string) []string {
+	args := []string{
+		"-config", k8sConfigMountPath + "/workflow.json",
+		"-headless",
+		"-concurrent", strconv.Itoa(vUsers),
+		"-runtime", strconv.Itoa(runtimeDuration),
+		"-startPort", strconv.Itoa(startPort),
+		"-reportTo", reportURL,
+	}
+	if arrivalRate > 0 {
+		args = append(args, "-arrivalRate", strconv.FormatFloat(arrivalRate, 'f', -1, 64))
+	}
+	if iterations > 0 {
+		args = append(args, "-iterations", strconv.Itoa(iterations))
+	}
+	if iterationsPerVUserFlag > 0 {
+		args = append(args, "-iterationsPerVUser", strconv.Itoa(iterationsPerVUserFlag))
+	}
+	if hasInjection {
+		args = append(args, "-injectionConfig", k8sConfigMountPath+"/injection.json")
+	}
+	if workflowTimeout > 0 {
+		args = append(args, "-workflowTimeout", strconv.Itoa(workflowTimeout))
+	}
+	if largeScale {
+		args = append(args, "-largeScale")
+	}
+	if verboseFailures {
+		args = append(args, "-verboseFailures")
+	}
+	if logLevelFlag != "" {
+		args = append(args, "-logLevel", logLevelFlag)
+	}
+	if logFormat != "pretty" {
+		args = append(args, "-logFormat", logFormat)
+	}
+	if gracePeriodFlag != defaultGracePeriod.Seconds() {
+		args = append(args, "-gracePeriod", strconv.FormatFloat(gracePeriodFlag, 'f', -1, 64))
+	}
+	if hardStopAfterFlag > 0 {
+		args = append(args, "-hardStopAfter", strconv.FormatFloat(hardStopAfterFlag, 'f', -1, 64))
+	}
+	if statsdAddr != "" || influxURL != "" {
+		args = append(args, "-metricsInterval", strconv.FormatFloat(metricsPushInterval, 'f', -1, 64), "-metricsPrefix", metricsPrefix)
+	}
+	if statsdAddr != "" {
+		args = append(args, "-statsd", statsdAddr)
+	}
+	if influxURL != "" {
+		args = append(args, "-influxURL", influxURL)
+		if influxToken != "" {
+			args = append(args, "-influxToken", "{{file:"+k8sConfigMountPath+"/influx-token}}")
+		}
+	}
+	if kafkaBrokers != "" {
+		args = append(args, "-kafka", kafkaBrokers, "-kafkaTopic", kafkaTopic)
+		if kafkaTLS {
+			args = append(args, "-kafkaTLS")
+		}
+		if kafkaUser != "" {
+			args = append(args, "-kafkaUser", kafkaUser, "-kafkaPassword", "{{file:"+k8sConfigMountPath+"/kafka-password}}")
+		}
+	}
+	return args
+}
+
+// reportCollector receives worker reports on the controller. Only reports
+// carrying the run's token are taken, as anything in the cluster can reach
+// the report port.
+type reportCollector struct {
+	token   string
+	mu      sync.Mutex
+	reports map[string]runReport
+	updated chan struct{}
+}
+
+func newReportCollector(token string) *reportCollector {
+	return &reportCollector{token: token, reports: make(map[string]runReport), updated: make(chan struct{}, 1)}
+}
+
+func (rc *reportCollector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(rc.token)) != 1 {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	var report runReport
+	if err := json.NewDecoder(io.LimitReader(r.Body, 1<<20)).Decode(&report); err != nil {
+		http.Error(w, "Invalid report payload", http.StatusBadRequest)
+		return
+	}
+	if report.Worker == "" {
+		report.Worker = r.RemoteAddr
+	}
+	rc.mu.Lock()
+	rc.reports[report.Worker] = report
+	rc.mu.Unlock()
+	storeLog(fmt.Sprintf("Received run report from %s", report.Worker))
+	select {
+	case rc.updated <- struct{}{}:
+	default:
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (rc *reportCollector) snapshot() []runReport {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	reports := make([]runReport, 0, len(rc.reports))
+	for _, r := range rc.reports {
+		reports = append(reports, r)
+	}
+	return reports
+}
+
+// runKubernetesController launches worker Jobs for a share of the vUsers each,
+// waits for their reports, and prints one merged summary.
+func runKubernetesController(config *Configuration, configPath, injectionPath string) {
+	if runtimeDuration <= 0 {
+		pterm.Error.Println("-k8s mode requires -runtime greater than zero.")
+		return
+	}
+	if iterationsFlag.rows {
+		pterm.Error.Println("-k8s mode cannot split -iterations rows across worker Jobs; give a number of iterations.")
+		return
+	}
+	if searchMode() {
+		pterm.Error.Println("-findMaxVUsers runs in a single process and cannot be combined with -k8s.")
+		return
+	}
+	if junitPath != "" || resultsPath != "" {
+		pterm.Error.Println("-junit and -results record the workflows of a single process and cannot be combined with -k8s.")
+		return
+	}
+	if err := validateShutdownFlags(); err != nil {
+		pterm.Error.Println(err.Error())
+		return
+	}
+	if err := validateMetricsSinks(); err != nil {
+		pterm.Error.Println(err.Error())
+		return
+	}
+	client, err := newInClusterK8sClient()
+	if err != nil {
+		pterm.Error.Printf("Kubernetes controller unavailable: %v\n", err)
+		return
+	}
+	addr := strings.TrimSpace(k8sControllerAddr)
+	if addr == "" {
+		addr = os.Getenv("POD_IP")
+	}
+	if addr == "" {
+		pterm.Error.Println("Set -k8sControllerAddr (or expose POD_IP via the downward API) so workers can report back.")
+		return
+	}
+
+	workflowJSON, err := json.MarshalIndent(config, "", "  ")
+	if err != nil {
+		pterm.Error.Printf("Failed to encode workflow for workers: %v\n", err)
+		return
+	}
+	files := map[string]string{"workflow.json": string(workflowJSON)}
+	if injectionPath != "" {
+		data, err := os.ReadFile(injectionPath)
+		if err != nil {
+			pterm.Error.Printf("Failed to read injection data for workers: %v\n", err)
+			return
+		}
+		files["injection.json"] = string(data)
+	}
+	if influxURL != "" && influxToken != "" {
+		// The token goes to the workers in the run secret, not on their
+		// command line.
+		token, err := resolveSecretPlaceholders(influxToken)
+		if err != nil {
+			pterm.Error.Printf("Failed to resolve -influxToken for workers: %v\n", err)
+			return
+		}
+		files["influx-token"] = token
+	}
+	if kafkaUser != "" {
+		if !kafkaTLS {
+			pterm.Error.Println(errKafkaCleartextPassword.Error())
+			return
+		}
+		password, err := resolveSecretPlaceholders(kafkaPassword)
+		if err != nil {
+			pterm.Error.Printf("Failed to resolve -kafkaPassword for workers: %v\n", err)
+			return
+		}
+		files["kafka-password"] = password
+	}
+
+	// Workers get the token from the run secret through their environment.
+	reportToken := randomToken()
+	files["report-token"] = reportToken
+	collector := newReportCollector(reportToken)
+	mux := http.NewServeMux()
+	mux.Handle("/report", collector)
+	listener, err := net.Listen("tcp", fmt.Sprintf(":%d", k8sReportPort))
+	if err != nil {
+		pterm.Error.Printf("Failed to listen for worker reports on port %d: %v\n", k8sReportPort, err)
+		return
+	}
+	server := &http.Server{Handler: mux}
+	go server.Serve(listener)
+	defer server.Close()
+	reportURL := fmt.Sprintf("http://%s/report", net.JoinHostPort(addr, strconv.Itoa(k8sReportPort)))
+
+	runName := fmt.Sprintf("3270connect-%d", time.Now().Unix())
+	if err := client.createSecret(runName, files); err != nil {
+		pterm.Error.Printf("Failed to create run secret: %v\n", err)
+		return
+	}
+	defer func() {
+		if err := client.deleteSecret(runName); err != nil {
+			pterm.Warning.Printf("Failed to delete run secret %s: %v\n", runName, err)
+		}
+	}()
+
+	shares := distributeVUsers(concurrent, k8sWorkers)
+	iterations := splitIterations(iterationsFlag.n, shares)
+	jobNames := make([]string, 0, len(shares))
+	for i, share := range shares {
+		if iterationsFlag.n > 0 && iterations[i] == 0 {
+			continue // fewer iterations than workers
+		}
+		// Each worker takes the share of the arrival rate its vUsers are of the total.
+		rate := arrivalRate(config) * float64(share) / float64(concurrent)
+		job := buildWorkerJob(runName, i, k8sImage, runName, workerArgs(share, rate, iterations[i], injectionPath != "", reportURL))
+		name := fmt.Sprintf("%s-w%d", runName, i)
+		if err := client.createJob(job); err != nil {
+			pterm.Error.Printf("Failed to create worker Job %s: %v\n", name, err)
+			continue
+		}
+		jobNames = append(jobNames, name)
+		pterm.Info.Printf("Launched worker Job %s with %d vUsers\n", name, share)
+	}
+	jobsDeleted := false
+	deleteJobs := func() {
+		if jobsDeleted {
+			return
+		}
+		jobsDeleted = true
+		for _, name := range jobNames {
+			if err := client.deleteJob(name); err != nil {
+				pterm.Warning.Printf("Failed to delete worker Job %s: %v\n", name, err)
+			}
+		}
+	}
+	defer deleteJobs()
+	if len(jobNames) == 0 {
+		return
+	}
+
+	overallStart := time.Now()
+	waitUntil := overallStart.Add(time.Duration(runtimeDuration)*time.Second + drainAllowance() + k8sStartupAllowance)
+	failedJobs := make(map[string]bool)
+	for !stoppedHard() && time.Now().Before(waitUntil) && len(collector.snapshot())+len(failedJobs) < len(jobNames) {
+		if stopRequested() && !jobsDeleted {
+			// Deleting the Jobs sends their workers SIGTERM; they drain and
+			// report what they ran.
+			pterm.Info.Println("Stopping the worker Jobs and waiting for their reports...")
+			deleteJobs()
+			waitUntil = time.Now().Add(sigtermDrain + k8sStopAllowance)
+		}
+		select {
+		case <-collector.updated:
+		case <-hardStopped():
+		case <-time.After(k8sStatusPollInterval):
+			for _, name := range jobNames {
+				if failedJobs[name] {
+					continue
+				}
+				if failed, err := client.jobFailed(name); err == nil && failed {
+					failedJobs[name] = true
+					pterm.Warning.Printf("Worker Job %s failed before reporting.\n", name)
+				}
+			}
+		}
+	}
+
+	reports := collector.snapshot()
+	if len(reports) < len(jobNames) {
+		pterm.Warning.Printf("Received %d of %d worker reports; summary is partial.\n", len(reports), len(jobNames))
+	}
+	merged := mergeRunReports(reports)
+	// The controller runs no workflows itself; taking on the merged totals
+	// lets the thresholds judge the whole run.
+	atomic.StoreInt64(&totalWorkflowsCompleted, merged.Completed)
+	atomic.StoreInt64(&totalWorkflowsFailed, merged.Failed)
+	atomic.StoreInt64(&droppedArrivals, merged.Dropped)
+	if merged.Aborted != "" {
+		runAbortReason.Store(&merged.Aborted)
+	}
+	if merged.Stopped != "" {
+		runStopReason.CompareAndSwap(nil, &merged.Stopped)
+	}
+	seedDurationHistogram(merged.Histogram)
+	printMergedRunReport(configPath, config, merged, len(jobNames))
+}
+
+func printMergedRunReport(configPath string, config *Configuration, merged runReport, workerJobs int) {
+	printWorkflowMetadata(configPath, config)
+	pterm.DefaultSection.WithStyle(pterm.NewStyle(pterm.FgCyan)).Println("Distributed Run Summary - Performance Report")
+	pterm.Println()
+	pterm.DefaultTable.
+		WithHasHeader().
+		WithLeftAlignment().
+		WithData(TableData{
+			{"Metric", "Value"},
+			{"Worker Jobs", strconv.Itoa(workerJobs)},
+			{"Total vUsers", strconv.Itoa(merged.VUsers)},
+			{"Total Workflows Started", fmt.Sprintf("%d", merged.Started)},
+			{"Total Workflows Completed", fmt.Sprintf("%d", merged.Completed)},
+			{"Total Workflows Failed", fmt.Sprintf("%d", merged.Failed)},
+			{"Average CPU Usage", fmt.Sprintf("%.1f%%", merged.AvgCPU)},
+			{"Average Memory Usage", fmt.Sprintf("%.1f%%", merged.AvgMem)},
+			{"Average Workflow Time", fmt.Sprintf("%.2fs", merged.averageDuration())},
+			{"Run Duration", fmt.Sprintf("%.0fs", merged.ElapsedSeconds)},
+		}).Render()
+	printAbortSummary()
+	printStopSummary()
+	printArrivalSummary(arrivalRate(config), merged.Started, merged.ElapsedSeconds)
+	addTransactionTimings(merged.Transactions)
+	printTimingSummary()
+
+	summaryText := generateSummaryText(configPath, config, merged.Started, merged.Completed, merged.Failed, merged.Active, merged.AvgCPU, merged.AvgMem, merged.averageDuration(), merged.ElapsedSeconds)
+	summaryFile := filepath.Join("logs", fmt.Sprintf("summary_%d.txt", os.Getpid()))
+	if err := os.WriteFile(summaryFile, []byte(summaryText), 0644); err != nil {
+		pterm.Warning.Printf("Failed to save summary: %v\n", err)
+	}
+}
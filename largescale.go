@@ -0,0 +1,115 @@
+package main
+
+import (
+	"flag"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	connect3270 "github.com/3270io/3270Connect/connect3270"
+)
+
+const (
+	largeScaleConnectLimit = 64
+	durationShardCount     = 16
+)
+
+var (
+	largeScale            bool
+	maxConcurrentConnects int
+	connectSlots          chan struct{}
+	reuseEmulators        bool
+)
+
+func init() {
+	flag.BoolVar(&largeScale, "largeScale", false, "Tune internals for very high vUser counts (5,000+)")
+	flag.IntVar(&maxConcurrentConnects, "maxConnects", 0, "Maximum emulator sessions connecting at once (0 = unlimited; -largeScale defaults to 64)")
+	flag.BoolVar(&reuseEmulators, "reuseEmulators", false, "Keep emulator processes running between workflows and reconnect them instead of starting new ones (on with -largeScale)")
+}
+
+// applyLargeScaleSettings wires the backpressure limits selected by
+// -largeScale and -maxConnects, and emulator reuse. Call once after flag
+// parsing.
+func applyLargeScaleSettings() {
+	if largeScale && maxConcurrentConnects == 0 {
+		maxConcurrentConnects = largeScaleConnectLimit
+	}
+	if maxConcurrentConnects > 0 {
+		connectSlots = make(chan struct{}, maxConcurrentConnects)
+	} else {
+		connectSlots = nil
+	}
+	connect3270.ReuseProcesses = reuseEmulators || largeScale
+}
+
+// acquireConnectSlot blocks until an emulator launch slot is free. It returns
+// false when shutdown was requested while waiting.
+func acquireConnectSlot() bool {
+	if connectSlots == nil {
+		return true
+	}
+	for {
+		select {
+		case connectSlots <- struct{}{}:
+			return true
+		case <-time.After(200 * time.Millisecond):
+			if connect3270.ShutdownRequested() {
+				return false
+			}
+		}
+	}
+}
+
+func releaseConnectSlot() {
+	if connectSlots == nil {
+		return
+	}
+	select {
+	case <-connectSlots:
+	default:
+	}
+}
+
+// useInMemoryOutput reports whether a workflow can skip the per-run temp output
+// file. Without an OutputFilePath nothing ever reads it back.
+func useInMemoryOutput(config *Configuration) bool {
+	return largeScale && strings.TrimSpace(config.OutputFilePath) == ""
+}
+
+// durationShard accumulates workflow durations; shards spread the lock
+// traffic from thousands of workers finishing at once. The buckets keep the
+// distribution for percentiles.
+type durationShard struct {
+	mu      sync.Mutex
+	sum     float64
+	count   int64
+	buckets [durationBucketCount]int64
+}
+
+var (
+	durationShards    [durationShardCount]durationShard
+	durationShardNext atomic.Uint32
+)
+
+func recordShardedDuration(duration float64) {
+	shard := &durationShards[durationShardNext.Add(1)%durationShardCount]
+	shard.mu.Lock()
+	shard.sum += duration
+	shard.count++
+	shard.buckets[durationBucket(duration)]++
+	shard.mu.Unlock()
+}
+
+func shardedDurationTotals() (float64, int64) {
+	var sum float64
+	var count int64
+	for i := range durationShards {
+		shard := &durationShards[i]
+		shard.mu.Lock()
+		sum += shard.sum
+		count += shard.count
+		shard.mu.Unlock()
+	}
+	return sum, count
+}
@@ -0,0 +1,356 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	connect3270 "github.com/3270io/3270Connect/connect3270"
+)
+
+var (
+	logLevelFlag  string
+	logFormatFlag string
+)
+
+func init() {
+	flag.StringVar(&logLevelFlag, "logLevel", "", "Lowest level logged: debug, info, warn or error (default info; debug with -verbose)")
+	flag.StringVar(&logFormatFlag, "logFormat", "pretty", "Console log format: pretty, text or json")
+}
+
+// levelSuccess ranks success messages between info and warnings.
+const levelSuccess = slog.Level(2)
+
+// Every log record goes through log/slog. The console handler prints it,
+// pretty by default, and the log store keeps it in the per-PID log file and
+// for the dashboard console. The pterm message printers log to the console
+// alone and storeLog to the log store alone; the subsystem loggers log to
+// both, with a subsystem attribute.
+var (
+	// consoleLog carries the pterm message printers.
+	consoleLog = slog.New(&routedHandler{dest: toConsole})
+	// fileLog carries storeLog.
+	fileLog = slog.New(&routedHandler{dest: toFile})
+
+	emulatorLog  = subsystemLogger("emulator")
+	schedulerLog = subsystemLogger("scheduler")
+	dashboardLog = subsystemLogger("dashboard")
+	apiLog       = subsystemLogger("api")
+	reportLog    = subsystemLogger("report")
+)
+
+func subsystemLogger(name string) *slog.Logger {
+	return slog.New(&routedHandler{dest: toConsole | toFile}).With(subsystemKey, name)
+}
+
+const (
+	subsystemKey = "subsystem"
+	// printerKey carries the pterm printer of a console message, whose
+	// prefix the pretty handler shows.
+	printerKey = "printer"
+)
+
+// logOrStore logs msg to the console and the log file when show is set,
+// and otherwise keeps it in the log file alone.
+func logOrStore(l *slog.Logger, show bool, level slog.Level, msg string) {
+	if h, ok := l.Handler().(*routedHandler); ok && !show {
+		fileOnly := *h
+		fileOnly.dest = toFile
+		l = slog.New(&fileOnly)
+	}
+	l.Log(context.Background(), level, msg)
+}
+
+// logSuccess logs a success message, which slog has no method for.
+func logSuccess(l *slog.Logger, msg string, args ...any) {
+	l.Log(context.Background(), levelSuccess, msg, args...)
+}
+
+var (
+	logLevel  = new(slog.LevelVar)
+	logFormat = "pretty"
+	// consoleHandler prints the records that reach the console.
+	consoleHandler slog.Handler = &prettyHandler{}
+	logStore                    = &logStoreHandler{}
+)
+
+func parseLogLevel(value string) (slog.Level, error) {
+	switch strings.ToLower(value) {
+	case "debug":
+		return slog.LevelDebug, nil
+	case "info":
+		return slog.LevelInfo, nil
+	case "warn", "warning":
+		return slog.LevelWarn, nil
+	case "error":
+		return slog.LevelError, nil
+	}
+	return 0, fmt.Errorf("-logLevel must be debug, info, warn or error, not %q", value)
+}
+
+// configureLogging applies -logLevel and -logFormat to the console, out.
+// -verbose logs at debug unless -logLevel says otherwise, and debug makes
+// emulators verbose too.
+func configureLogging(out io.Writer) error {
+	level := slog.LevelInfo
+	if verbose {
+		level = slog.LevelDebug
+	}
+	if logLevelFlag != "" {
+		var err error
+		if level, err = parseLogLevel(logLevelFlag); err != nil {
+			return err
+		}
+	}
+	logLevel.Set(level)
+	if level <= slog.LevelDebug {
+		verbose = true
+	}
+
+	opts := &slog.HandlerOptions{Level: logLevel, ReplaceAttr: replaceLogAttr}
+	switch strings.ToLower(logFormatFlag) {
+	case "pretty", "":
+		logFormat, consoleHandler = "pretty", &prettyHandler{out: out}
+	case "text":
+		logFormat, consoleHandler = "text", slog.NewTextHandler(out, opts)
+	case "json":
+		logFormat, consoleHandler = "json", slog.NewJSONHandler(out, opts)
+	default:
+		return fmt.Errorf("-logFormat must be pretty, text or json, not %q", logFormatFlag)
+	}
+	connect3270.Logger = emulatorLog
+	return nil
+}
+
+// replaceLogAttr names the success level and drops the pterm printer for
+// the text and JSON handlers.
+func replaceLogAttr(groups []string, a slog.Attr) slog.Attr {
+	switch {
+	case len(groups) == 0 && a.Key == slog.LevelKey:
+		if level, ok := a.Value.Any().(slog.Level); ok && level == levelSuccess {
+			return slog.String(slog.LevelKey, "SUCCESS")
+		}
+	case a.Key == printerKey:
+		return slog.Attr{}
+	case len(groups) == 0 && a.Key == slog.MessageKey:
+		return slog.String(slog.MessageKey, strings.TrimRight(a.Value.String(), "\n"))
+	}
+	return a
+}
+
+type logDest int
+
+const (
+	toConsole logDest = 1 << iota
+	toFile
+)
+
+// routedHandler sends records to the console handler and the log store as
+// configured when they are logged, so loggers made before configureLogging
+// follow it.
+type routedHandler struct {
+	dest  logDest
+	attrs []slog.Attr
+	group string
+}
+
+// fileLevel is the lowest level the log store keeps: info, as storeLog
+// always did, or lower with -logLevel debug.
+func fileLevel() slog.Level {
+	if level := logLevel.Level(); level < slog.LevelInfo {
+		return level
+	}
+	return slog.LevelInfo
+}
+
+func (h *routedHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return (h.dest&toConsole != 0 && level >= logLevel.Level()) || (h.dest&toFile != 0 && level >= fileLevel())
+}
+
+func (h *routedHandler) Handle(ctx context.Context, r slog.Record) error {
+	if len(h.attrs) > 0 {
+		r = r.Clone()
+		r.AddAttrs(h.attrs...)
+	}
+	var err error
+	if h.dest&toConsole != 0 && r.Level >= logLevel.Level() {
+		err = consoleHandler.Handle(ctx, r)
+	}
+	if h.dest&toFile != 0 && r.Level >= fileLevel() {
+		if fileErr := logStore.Handle(ctx, r); err == nil {
+			err = fileErr
+		}
+	}
+	return err
+}
+
+func (h *routedHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	next := *h
+	next.attrs = append(append([]slog.Attr(nil), h.attrs...), h.grouped(attrs)...)
+	return &next
+}
+
+func (h *routedHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	next := *h
+	next.group = h.group + name + "."
+	return &next
+}
+
+// grouped prefixes attribute keys with the open groups; the log store and
+// the pretty console show attributes flat.
+func (h *routedHandler) grouped(attrs []slog.Attr) []slog.Attr {
+	if h.group == "" {
+		return attrs
+	}
+	out := make([]slog.Attr, len(attrs))
+	for i, a := range attrs {
+		out[i] = slog.Attr{Key: h.group + a.Key, Value: a.Value}
+	}
+	return out
+}
+
+// recordFields splits a record's attributes into its subsystem, its pterm
+// printer and the rest.
+func recordFields(r slog.Record) (subsystem string, printer *MessagePrinter, attrs []slog.Attr) {
+	r.Attrs(func(a slog.Attr) bool {
+		switch a.Key {
+		case subsystemKey:
+			subsystem = a.Value.String()
+		case printerKey:
+			printer, _ = a.Value.Any().(*MessagePrinter)
+		default:
+			attrs = append(attrs, a)
+		}
+		return true
+	})
+	return subsystem, printer, attrs
+}
+
+// formatLogAttrs renders attributes as key=value pairs.
+func formatLogAttrs(attrs []slog.Attr) string {
+	var b strings.Builder
+	for _, a := range attrs {
+		value := a.Value.Resolve().String()
+		if strings.ContainsAny(value, " \t\"=") || value == "" {
+			value = strconv.Quote(value)
+		}
+		fmt.Fprintf(&b, " %s=%s", a.Key, value)
+	}
+	return b.String()
+}
+
+// prettyHandler is the console as it has always looked: a coloured level
+// prefix, then the message.
+type prettyHandler struct {
+	// out is os.Stdout when nil.
+	out   io.Writer
+	attrs []slog.Attr
+}
+
+func (h *prettyHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= logLevel.Level()
+}
+
+func (h *prettyHandler) Handle(_ context.Context, r slog.Record) error {
+	if len(h.attrs) > 0 {
+		r = r.Clone()
+		r.AddAttrs(h.attrs...)
+	}
+	_, printer, attrs := recordFields(r)
+	if printer == nil {
+		printer = printerForLevel(r.Level)
+	}
+	msg := r.Message
+	if len(attrs) > 0 {
+		msg = strings.TrimRight(msg, "\n") + pterm.FgWhite.Sprint(formatLogAttrs(attrs))
+	}
+	out := h.out
+	if out == nil {
+		out = os.Stdout
+	}
+	_, err := fmt.Fprintln(out, printer.render(msg))
+	return err
+}
+
+func (h *prettyHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	next := *h
+	next.attrs = append(append([]slog.Attr(nil), h.attrs...), attrs...)
+	return &next
+}
+
+func (h *prettyHandler) WithGroup(string) slog.Handler { return h }
+
+// debugPrinter prefixes debug messages, which pterm has no printer for.
+var debugPrinter = &MessagePrinter{Prefix: Prefix{Text: "DEBUG", Style: pterm.NewStyle(pterm.FgWhite)}}
+
+func printerForLevel(level slog.Level) *MessagePrinter {
+	switch {
+	case level >= slog.LevelError:
+		return pterm.Error
+	case level >= slog.LevelWarn:
+		return pterm.Warning
+	case level >= levelSuccess:
+		return pterm.Success
+	case level >= slog.LevelInfo:
+		return pterm.Info
+	}
+	return debugPrinter
+}
+
+// logStoreHandler keeps records as LogEntry values: in memory for the
+// dashboard console and in the per-PID log file.
+type logStoreHandler struct{}
+
+func (logStoreHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= fileLevel()
+}
+
+func (logStoreHandler) Handle(_ context.Context, r slog.Record) error {
+	subsystem, _, attrs := recordFields(r)
+	entry := LogEntry{
+		PID:        strconv.Itoa(os.Getpid()),
+		Parameters: processParameters(),
+		Log:        strings.TrimRight(r.Message, "\n") + formatLogAttrs(attrs),
+		Timestamp:  r.Time,
+		Subsystem:  subsystem,
+	}
+	if entry.Timestamp.IsZero() {
+		entry.Timestamp = time.Now()
+	}
+	// Plain storeLog entries carry no level, as before.
+	if subsystem != "" || r.Level != slog.LevelInfo {
+		entry.Level = levelName(r.Level)
+	}
+	if len(attrs) > 0 {
+		entry.Attrs = make(map[string]any, len(attrs))
+		for _, a := range attrs {
+			entry.Attrs[a.Key] = a.Value.Resolve().Any()
+		}
+	}
+	logMutex.Lock()
+	inMemoryLogs.add(entry)
+	logMutex.Unlock()
+
+	// Disk writes are batched by the background spill writer.
+	defaultLogSpill().enqueue(entry)
+	return nil
+}
+
+func (h logStoreHandler) WithAttrs([]slog.Attr) slog.Handler { return h }
+func (h logStoreHandler) WithGroup(string) slog.Handler      { return h }
+
+func levelName(level slog.Level) string {
+	if level == levelSuccess {
+		return "SUCCESS"
+	}
+	return level.String()
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	logFlushInterval  = 500 * time.Millisecond
+	logFlushBatchSize = 256
+	logPendingLimit   = 10000
+	logFileMaxBytes   = 10 << 20
+	logFileMaxBackups = 3
+)
+
+// logRing keeps the most recent log entries in a fixed-size buffer so hot-path
+// logging never grows memory or reallocates.
+type logRing struct {
+	entries []LogEntry
+	next    int
+	full    bool
+}
+
+func newLogRing(capacity int) *logRing {
+	if capacity <= 0 {
+		capacity = 1
+	}
+	return &logRing{entries: make([]LogEntry, capacity)}
+}
+
+func (r *logRing) add(entry LogEntry) {
+	r.entries[r.next] = entry
+	r.next++
+	if r.next == len(r.entries) {
+		r.next = 0
+		r.full = true
+	}
+}
+
+// snapshot returns the buffered entries ordered oldest first.
+func (r *logRing) snapshot() []LogEntry {
+	if !r.full {
+		out := make([]LogEntry, r.next)
+		copy(out, r.entries[:r.next])
+		return out
+	}
+	out := make([]LogEntry, 0, len(r.entries))
+	out = append(out, r.entries[r.next:]...)
+	out = append(out, r.entries[:r.next]...)
+	return out
+}
+
+// logSpill batches log entries to the per-PID JSON log file from a background
+// goroutine, rotating the file once it grows past maxBytes.
+type logSpill struct {
+	mu       sync.Mutex
+	pending  []LogEntry
+	dropped  int64
+	wake     chan struct{}
+	writeMu  sync.Mutex
+	path     string
+	file     *os.File
+	size     int64
+	maxBytes int64
+	backups  int
+}
+
+func newLogSpill(path string, maxBytes int64, backups int) *logSpill {
+	return &logSpill{
+		path:     path,
+		maxBytes: maxBytes,
+		backups:  backups,
+		wake:     make(chan struct{}, 1),
+	}
+}
+
+var (
+	logSpillOnce   sync.Once
+	activeLogSpill *logSpill
+	logParamsOnce  sync.Once
+	logParameters  string
+)
+
+func defaultLogSpill() *logSpill {
+	logSpillOnce.Do(func() {
+		path := filepath.Join("logs", fmt.Sprintf("logs_%d.json", os.Getpid()))
+		activeLogSpill = newLogSpill(path, logFileMaxBytes, logFileMaxBackups)
+		go activeLogSpill.run(logFlushInterval)
+	})
+	return activeLogSpill
+}
+
+func processParameters() string {
+	logParamsOnce.Do(func() {
+		logParameters = strings.Join(os.Args[1:], " ")
+	})
+	return logParameters
+}
+
+func (s *logSpill) enqueue(entry LogEntry) {
+	s.mu.Lock()
+	if len(s.pending) >= logPendingLimit {
+		// Shed the oldest pending entry rather than block the caller.
+		s.pending = s.pending[1:]
+		s.dropped++
+	}
+	s.pending = append(s.pending, entry)
+	shouldWake := len(s.pending) >= logFlushBatchSize
+	s.mu.Unlock()
+	if shouldWake {
+		select {
+		case s.wake <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (s *logSpill) run(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-s.wake:
+		}
+		s.flush()
+	}
+}
+
+// flush writes all pending entries to disk. It is safe to call concurrently
+// with the background writer.
+func (s *logSpill) flush() {
+	s.mu.Lock()
+	batch := s.pending
+	dropped := s.dropped
+	s.pending = nil
+	s.dropped = 0
+	s.mu.Unlock()
+	if len(batch) == 0 && dropped == 0 {
+		return
+	}
+
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	if dropped > 0 {
+		batch = append([]LogEntry{{
+			PID:        strconv.Itoa(os.Getpid()),
+			Parameters: processParameters(),
+			Log:        fmt.Sprintf("Log writer fell behind; dropped %d entries", dropped),
+			Timestamp:  time.Now(),
+		}}, batch...)
+	}
+	if err := s.openLocked(); err != nil {
+		pterm.Error.Println("Log file opening failed - send help:", err)
+		return
+	}
+	writer := bufio.NewWriter(s.file)
+	counter := &countingWriter{w: writer}
+	encoder := json.NewEncoder(counter)
+	for _, entry := range batch {
+		if err := encoder.Encode(entry); err != nil {
+			pterm.Error.Println("Log encoding broke - computers hate me:", err)
+			break
+		}
+	}
+	if err := writer.Flush(); err != nil {
+		pterm.Error.Println("Log file write failed:", err)
+	}
+	s.size += counter.n
+	if s.maxBytes > 0 && s.size >= s.maxBytes {
+		s.rotateLocked()
+	}
+}
+
+func (s *logSpill) openLocked() error {
+	if s.file != nil {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(s.path), 0755); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(s.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	s.file = file
+	s.size = 0
+	if info, err := file.Stat(); err == nil {
+		s.size = info.Size()
+	}
+	return nil
+}
+
+func (s *logSpill) rotateLocked() {
+	if s.file != nil {
+		s.file.Close()
+		s.file = nil
+	}
+	s.size = 0
+	if s.backups <= 0 {
+		os.Remove(s.path)
+		return
+	}
+	os.Remove(rotatedLogPath(s.path, s.backups))
+	for i := s.backups - 1; i >= 1; i-- {
+		os.Rename(rotatedLogPath(s.path, i), rotatedLogPath(s.path, i+1))
+	}
+	if err := os.Rename(s.path, rotatedLogPath(s.path, 1)); err != nil && !os.IsNotExist(err) {
+		pterm.Warning.Printf("Log rotation failed for %s: %v\n", s.path, err)
+	}
+}
+
+func (s *logSpill) close() {
+	s.flush()
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	if s.file != nil {
+		s.file.Close()
+		s.file = nil
+	}
+}
+
+func rotatedLogPath(path string, index int) string {
+	return fmt.Sprintf("%s.%d", path, index)
+}
+
+// flushLogs forces pending log entries to disk; call before the process exits.
+func flushLogs() {
+	defaultLogSpill().flush()
+}
+
+type countingWriter struct {
+	w *bufio.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// luNamePattern matches a VTAM logical unit name.
+var luNamePattern = regexp.MustCompile(`^[A-Za-z@#$][A-Za-z0-9@#$]{0,7}$`)
+
+// splitLUNumber splits "TERM007" into "TERM" and "007".
+func splitLUNumber(name string) (string, string) {
+	i := len(name)
+	for i > 0 && name[i-1] >= '0' && name[i-1] <= '9' {
+		i--
+	}
+	return name[:i], name[i:]
+}
+
+// expandLUPool turns the LUPool entries into a list of LU names, expanding
+// ranges like "TERM001-TERM050" (or "TERM001-050") with their zero padding.
+func expandLUPool(entries []string) ([]string, error) {
+	var names []string
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		start, end, isRange := strings.Cut(entry, "-")
+		if !isRange {
+			if !luNamePattern.MatchString(entry) {
+				return nil, fmt.Errorf("LUPool entry %q is not an LU name or a range like TERM001-TERM050", entry)
+			}
+			names = append(names, entry)
+			continue
+		}
+		prefix, first := splitLUNumber(start)
+		endPrefix, last := splitLUNumber(end)
+		if prefix == "" || first == "" || last == "" || (endPrefix != "" && !strings.EqualFold(endPrefix, prefix)) {
+			return nil, fmt.Errorf("LUPool range %q should look like TERM001-TERM050", entry)
+		}
+		from, _ := strconv.Atoi(first)
+		to, _ := strconv.Atoi(last)
+		if to < from {
+			return nil, fmt.Errorf("LUPool range %q runs backwards", entry)
+		}
+		for n := from; n <= to; n++ {
+			name := fmt.Sprintf("%s%0*d", prefix, len(first), n)
+			if !luNamePattern.MatchString(name) {
+				return nil, fmt.Errorf("LUPool range %q produces %q, which is not a valid LU name", entry, name)
+			}
+			names = append(names, name)
+		}
+	}
+	return names, nil
+}
+
+// validateLUSettings checks LUName and LUPool.
+func validateLUSettings(config *Configuration) error {
+	if config.LUName != "" {
+		// x3270 accepts a comma-separated list and tries each LU in turn.
+		for _, name := range strings.Split(config.LUName, ",") {
+			if !luNamePattern.MatchString(strings.TrimSpace(name)) {
+				return fmt.Errorf("LUName %q is not a valid LU name - use up to 8 letters, digits, @, # or $", name)
+			}
+		}
+	}
+	if len(config.LUPool) > 0 {
+		if config.LUName != "" {
+			return fmt.Errorf("LUName and LUPool cannot both be set")
+		}
+		if _, err := expandLUPool(config.LUPool); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// luNameForWorker returns the LU a concurrent worker binds to: every worker
+// keeps one entry of the pool so no two live sessions share an LU as long
+// as the pool is at least as large as the number of vUsers.
+func luNameForWorker(pool []string, worker int) string {
+	if len(pool) == 0 {
+		return ""
+	}
+	return pool[worker%len(pool)]
+}
@@ -0,0 +1,295 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/base64"
+	"flag"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+var (
+	smtpAddr     string
+	smtpUser     string
+	smtpPassword string
+	mailFrom     string
+	mailTo       string
+	mailOn       string
+)
+
+func init() {
+	flag.StringVar(&smtpAddr, "smtp", "", "Mail the run summary through this SMTP server (host:port) when the run ends; port 465 uses TLS, others STARTTLS when offered")
+	flag.StringVar(&smtpUser, "smtpUser", "", "User name for -smtp")
+	flag.StringVar(&smtpPassword, "smtpPassword", "", "Password for -smtpUser; accepts {{env:NAME}} and {{file:path}}")
+	flag.StringVar(&mailFrom, "mailFrom", "", "Sender of the run summary mail (default: 3270connect@ this host)")
+	flag.StringVar(&mailTo, "mailTo", "", "Comma-separated recipients of the run summary mail")
+	flag.StringVar(&mailOn, "mailOn", "always", "Which runs to mail: always, success or failure")
+}
+
+const (
+	// smtpTimeout bounds the whole conversation with the mail server.
+	smtpTimeout = 30 * time.Second
+	// mailAttachmentLimit leaves out attachments most mail servers would
+	// refuse; the mail says which.
+	mailAttachmentLimit = 10 << 20
+)
+
+// mailSettings is a checked -smtp configuration.
+type mailSettings struct {
+	addr     string
+	host     string
+	user     string
+	password string
+	// from is the From header, and fromAddr its address.
+	from     string
+	fromAddr string
+	to       []string
+	on       string
+}
+
+var mailer *mailSettings
+
+// setupMail checks the -smtp flags; without -mailTo there is nothing to do.
+func setupMail() error {
+	if strings.TrimSpace(mailTo) == "" {
+		return nil
+	}
+	if smtpAddr == "" {
+		return fmt.Errorf("-mailTo needs -smtp, the mail server to send through")
+	}
+	host, _, err := net.SplitHostPort(smtpAddr)
+	if err != nil {
+		return fmt.Errorf("-smtp %q must be host:port: %v", smtpAddr, err)
+	}
+	switch mailOn {
+	case "always", "success", "failure":
+	default:
+		return fmt.Errorf("-mailOn must be always, success or failure, not %q", mailOn)
+	}
+	password, err := resolveSecretPlaceholders(smtpPassword)
+	if err != nil {
+		return fmt.Errorf("-smtpPassword: %w", err)
+	}
+	m := &mailSettings{addr: smtpAddr, host: host, user: smtpUser, password: password, from: mailFrom, on: mailOn}
+	if m.from == "" {
+		hostname, _ := os.Hostname()
+		m.from = "3270connect@" + hostname
+	}
+	from, err := mail.ParseAddress(m.from)
+	if err != nil {
+		return fmt.Errorf("-mailFrom %q: %v", m.from, err)
+	}
+	m.fromAddr = from.Address
+	for _, to := range strings.Split(mailTo, ",") {
+		if to = strings.TrimSpace(to); to == "" {
+			continue
+		}
+		addr, err := mail.ParseAddress(to)
+		if err != nil {
+			return fmt.Errorf("-mailTo %q: %v", to, err)
+		}
+		m.to = append(m.to, addr.Address)
+	}
+	mailer = m
+	return nil
+}
+
+// mailAttachment is a file sent with the run summary.
+type mailAttachment struct {
+	name string
+	data []byte
+}
+
+// runAttachments gathers the run's reports: the summary, the screens the
+// workflow saved, and the JUnit report. Missing files are skipped.
+func runAttachments(config *Configuration) (attachments []mailAttachment, skipped []string) {
+	paths := []string{filepath.Join("logs", fmt.Sprintf("summary_%d.txt", os.Getpid()))}
+	if config != nil && config.OutputFilePath != "" {
+		paths = append(paths, config.OutputFilePath)
+	}
+	if junitPath != "" {
+		paths = append(paths, junitPath)
+	}
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil || info.IsDir() {
+			continue
+		}
+		if info.Size() > mailAttachmentLimit {
+			skipped = append(skipped, fmt.Sprintf("%s (%d MB)", path, info.Size()>>20))
+			continue
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			skipped = append(skipped, fmt.Sprintf("%s (%v)", path, err))
+			continue
+		}
+		attachments = append(attachments, mailAttachment{name: filepath.Base(path), data: data})
+	}
+	return attachments, skipped
+}
+
+// buildRunMail writes the MIME message of note, with the attachments.
+func buildRunMail(from string, to []string, note notification, attachments []mailAttachment, skipped []string, now time.Time) ([]byte, error) {
+	var body strings.Builder
+	fmt.Fprintf(&body, "%s\n\nWorkflow: %s\nHost: %s\n\n%s\n", note.Title, note.Workflow, note.Host, noticeTable(note.Summary))
+	if len(note.Details) > 0 {
+		body.WriteString("\nErrors:\n")
+		for _, detail := range note.Details {
+			fmt.Fprintf(&body, "- %s\n", detail)
+		}
+	}
+	if len(skipped) > 0 {
+		fmt.Fprintf(&body, "\nNot attached, as too large or unreadable: %s\n", strings.Join(skipped, ", "))
+	}
+
+	var msg bytes.Buffer
+	w := multipart.NewWriter(&msg)
+	fmt.Fprintf(&msg, "From: %s\r\n", from)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(to, ", "))
+	fmt.Fprintf(&msg, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", note.Title))
+	fmt.Fprintf(&msg, "Date: %s\r\n", now.Format(time.RFC1123Z))
+	fmt.Fprintf(&msg, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&msg, "Content-Type: multipart/mixed; boundary=%s\r\n\r\n", w.Boundary())
+
+	part, err := w.CreatePart(textproto.MIMEHeader{
+		"Content-Type":              {"text/plain; charset=utf-8"},
+		"Content-Transfer-Encoding": {"base64"},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := writeBase64Lines(part, []byte(body.String())); err != nil {
+		return nil, err
+	}
+	for _, a := range attachments {
+		contentType := mime.TypeByExtension(filepath.Ext(a.name))
+		if contentType == "" {
+			contentType = "application/octet-stream"
+		}
+		part, err := w.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {contentType},
+			"Content-Transfer-Encoding": {"base64"},
+			"Content-Disposition":       {mime.FormatMediaType("attachment", map[string]string{"filename": a.name})},
+		})
+		if err != nil {
+			return nil, err
+		}
+		if err := writeBase64Lines(part, a.data); err != nil {
+			return nil, err
+		}
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return msg.Bytes(), nil
+}
+
+// writeBase64Lines writes data in base64, in the 76 character lines mail
+// allows.
+func writeBase64Lines(w io.Writer, data []byte) error {
+	encoded := base64.StdEncoding.EncodeToString(data)
+	for len(encoded) > 76 {
+		if _, err := fmt.Fprintf(w, "%s\r\n", encoded[:76]); err != nil {
+			return err
+		}
+		encoded = encoded[76:]
+	}
+	_, err := fmt.Fprintf(w, "%s\r\n", encoded)
+	return err
+}
+
+// send delivers msg through the mail server.
+func (m *mailSettings) send(msg []byte) error {
+	deadline := time.Now().Add(smtpTimeout)
+	var conn net.Conn
+	var err error
+	dialer := &net.Dialer{Deadline: deadline}
+	if _, port, _ := net.SplitHostPort(m.addr); port == "465" {
+		conn, err = tls.DialWithDialer(dialer, "tcp", m.addr, &tls.Config{ServerName: m.host})
+	} else {
+		conn, err = dialer.Dial("tcp", m.addr)
+	}
+	if err != nil {
+		return err
+	}
+	conn.SetDeadline(deadline)
+	c, err := smtp.NewClient(conn, m.host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+	if _, isTLS := conn.(*tls.Conn); !isTLS {
+		if ok, _ := c.Extension("STARTTLS"); ok {
+			if err := c.StartTLS(&tls.Config{ServerName: m.host}); err != nil {
+				return err
+			}
+		}
+	}
+	if m.user != "" {
+		// PlainAuth refuses to send the password unencrypted, except to
+		// localhost.
+		if err := c.Auth(smtp.PlainAuth("", m.user, m.password, m.host)); err != nil {
+			return err
+		}
+	}
+	if err := c.Mail(m.fromAddr); err != nil {
+		return err
+	}
+	for _, to := range m.to {
+		if err := c.Rcpt(to); err != nil {
+			return err
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(msg); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
+// mailRunSummary mails the summary of the finished run, with its reports,
+// when -mailOn asks for a run that ended this way.
+func mailRunSummary(configPath string, config *Configuration, exitCode int) {
+	m := mailer
+	if m == nil {
+		return
+	}
+	record := newRunRecord(configPath, config, exitCode)
+	failed := runFailed(record)
+	if (m.on == "success" && failed) || (m.on == "failure" && !failed) {
+		return
+	}
+	note := runCompleteNotice(filepath.Base(configPath), record)
+	if config != nil {
+		note.Host = fmt.Sprintf("%s:%d", config.Host, config.Port)
+	}
+	attachments, skipped := runAttachments(config)
+	msg, err := buildRunMail(m.from, m.to, note, attachments, skipped, time.Now())
+	if err == nil {
+		err = m.send(msg)
+	}
+	if err != nil {
+		reportLog.Warn(fmt.Sprintf("Mailing the run summary through %s failed: %v", m.addr, err))
+		return
+	}
+	reportLog.Info(fmt.Sprintf("Run summary mailed to %s", strings.Join(m.to, ", ")))
+}
@@ -0,0 +1,405 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	statsdAddr          string
+	influxURL           string
+	influxToken         string
+	metricsPushInterval float64
+	metricsPrefix       string
+)
+
+func init() {
+	flag.StringVar(&statsdAddr, "statsd", "", "Push run metrics to this StatsD server over UDP (host:port)")
+	flag.StringVar(&influxURL, "influxURL", "", "Push run metrics in InfluxDB line protocol to this write URL, such as http://influx:8086/api/v2/write?org=qa&bucket=load")
+	flag.StringVar(&influxToken, "influxToken", "", "InfluxDB API token for -influxURL; accepts {{env:NAME}} and {{file:path}}")
+	flag.Float64Var(&metricsPushInterval, "metricsInterval", 10, "Seconds between pushes to -statsd and -influxURL")
+	flag.StringVar(&metricsPrefix, "metricsPrefix", "3270connect", "Prefix of the StatsD metric names and InfluxDB measurements")
+}
+
+// statsdPacketSize keeps StatsD packets within the MTU of most networks, so
+// none is fragmented and lost.
+const statsdPacketSize = 1432
+
+// metricsSample is what a push reports: the workflow counters of the run so
+// far, and the timings of the interval since the last push.
+type metricsSample struct {
+	At                         time.Time
+	Started, Completed, Failed int64
+	// The counters' growth since the last push.
+	NewStarted, NewCompleted, NewFailed int64
+	Active                              int
+	Workflow                            timingStat
+	Transactions, Steps                 map[string]timingStat
+}
+
+// metricsSink is a system run metrics are pushed to.
+type metricsSink interface {
+	name() string
+	push(s metricsSample) error
+}
+
+// metricsSampler takes the samples of a run, keeping what the last one saw
+// so each reports its interval.
+type metricsSampler struct {
+	started, completed, failed int64
+	workflow                   [durationBucketCount]int64
+	workflowCount              int64
+	transactions, steps        map[string]timingStat
+}
+
+func (m *metricsSampler) sample(now time.Time) metricsSample {
+	s := metricsSample{
+		At:        now,
+		Started:   atomic.LoadInt64(&totalWorkflowsStarted),
+		Completed: atomic.LoadInt64(&totalWorkflowsCompleted),
+		Failed:    atomic.LoadInt64(&totalWorkflowsFailed),
+		Active:    getActiveWorkflows(),
+	}
+	s.NewStarted, s.NewCompleted, s.NewFailed = s.Started-m.started, s.Completed-m.completed, s.Failed-m.failed
+	m.started, m.completed, m.failed = s.Started, s.Completed, s.Failed
+
+	// Workflow times are only kept as buckets, which give percentiles but
+	// no average.
+	buckets, count := durationBucketTotals()
+	s.Workflow = timingStat{Count: count - m.workflowCount, Histogram: make(map[int]int64)}
+	for i, n := range buckets {
+		if d := n - m.workflow[i]; d > 0 {
+			s.Workflow.Histogram[i] = d
+		}
+	}
+	m.workflow, m.workflowCount = buckets, count
+
+	steps, transactions := timingSnapshot()
+	s.Steps, s.Transactions = intervalTimings(steps, m.steps), intervalTimings(transactions, m.transactions)
+	m.steps, m.transactions = steps, transactions
+	return s
+}
+
+// intervalTimings returns the timings recorded between prev and cur, leaving
+// out those with none.
+func intervalTimings(cur, prev map[string]timingStat) map[string]timingStat {
+	interval := make(map[string]timingStat)
+	for name, stat := range cur {
+		if d := timingDelta(stat, prev[name]); d.Count > 0 {
+			interval[name] = d
+		}
+	}
+	return interval
+}
+
+// timingDelta returns the timings cur holds that prev, an earlier copy of
+// the same stat, does not. The minimum and maximum cannot be told apart, so
+// they are left out.
+func timingDelta(cur, prev timingStat) timingStat {
+	d := timingStat{Count: cur.Count - prev.Count}
+	if d.Count <= 0 {
+		return timingStat{}
+	}
+	d.Avg = (cur.Avg*float64(cur.Count) - prev.Avg*float64(prev.Count)) / float64(d.Count)
+	d.Histogram = make(map[int]int64, len(cur.Histogram))
+	for bucket, n := range cur.Histogram {
+		if n -= prev.Histogram[bucket]; n > 0 {
+			d.Histogram[bucket] = n
+		}
+	}
+	return d
+}
+
+func sortedTimingNames(stats map[string]timingStat) []string {
+	names := make([]string, 0, len(stats))
+	for name := range stats {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// statsdSink sends StatsD counters and gauges over UDP. Workflow counts are
+// counters of the interval; times are gauges in milliseconds, as StatsD
+// would otherwise take each interval's average for a single timing.
+type statsdSink struct {
+	addr string
+	conn net.Conn
+}
+
+func newStatsdSink(addr string) (*statsdSink, error) {
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return &statsdSink{addr: addr, conn: conn}, nil
+}
+
+func (s *statsdSink) name() string { return "StatsD " + s.addr }
+
+var statsdUnsafe = regexp.MustCompile(`[^A-Za-z0-9_-]+`)
+
+// statsdName turns a transaction or step name into a StatsD name segment.
+func statsdName(name string) string {
+	return strings.Trim(statsdUnsafe.ReplaceAllString(name, "_"), "_")
+}
+
+func statsdLines(prefix string, s metricsSample) []string {
+	lines := []string{
+		fmt.Sprintf("%s.workflows.started:%d|c", prefix, s.NewStarted),
+		fmt.Sprintf("%s.workflows.completed:%d|c", prefix, s.NewCompleted),
+		fmt.Sprintf("%s.workflows.failed:%d|c", prefix, s.NewFailed),
+		fmt.Sprintf("%s.workflows.active:%d|g", prefix, s.Active),
+	}
+	timing := func(name string, t timingStat, withAvg bool) {
+		lines = append(lines, fmt.Sprintf("%s.count:%d|c", name, t.Count))
+		if withAvg {
+			lines = append(lines, fmt.Sprintf("%s.avg_ms:%.1f|g", name, t.Avg*1000))
+		}
+		for _, p := range []int{50, 95, 99} {
+			lines = append(lines, fmt.Sprintf("%s.p%d_ms:%.1f|g", name, p, t.percentile(float64(p))*1000))
+		}
+	}
+	if s.Workflow.Count > 0 {
+		timing(prefix+".workflow", s.Workflow, false)
+	}
+	for _, name := range sortedTimingNames(s.Transactions) {
+		timing(prefix+".transaction."+statsdName(name), s.Transactions[name], true)
+	}
+	for _, name := range sortedTimingNames(s.Steps) {
+		timing(prefix+".step."+statsdName(name), s.Steps[name], true)
+	}
+	return lines
+}
+
+func (s *statsdSink) push(sample metricsSample) error {
+	var packet bytes.Buffer
+	send := func() error {
+		if packet.Len() == 0 {
+			return nil
+		}
+		_, err := s.conn.Write(packet.Bytes())
+		packet.Reset()
+		return err
+	}
+	for _, line := range statsdLines(metricsPrefix, sample) {
+		if packet.Len() > 0 && packet.Len()+1+len(line) > statsdPacketSize {
+			if err := send(); err != nil {
+				return err
+			}
+		}
+		if packet.Len() > 0 {
+			packet.WriteByte('\n')
+		}
+		packet.WriteString(line)
+	}
+	return send()
+}
+
+// influxSink posts InfluxDB line protocol. Workflow counts are the run's
+// totals so far, as Influx counters usually are; times are of the interval,
+// in seconds.
+type influxSink struct {
+	url    string
+	token  string
+	host   string
+	client *http.Client
+}
+
+func newInfluxSink(writeURL, token string) *influxSink {
+	host, _ := os.Hostname()
+	return &influxSink{url: writeURL, token: token, host: host, client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+func (s *influxSink) name() string { return "InfluxDB " + redactURL(s.url) }
+
+var (
+	influxMeasurementEscaper = strings.NewReplacer(",", `\,`, " ", `\ `)
+	influxTagEscaper         = strings.NewReplacer(",", `\,`, " ", `\ `, "=", `\=`)
+)
+
+func influxLines(prefix, host string, s metricsSample) []string {
+	measurement := influxMeasurementEscaper.Replace(prefix)
+	tags := ""
+	if host != "" {
+		tags = ",host=" + influxTagEscaper.Replace(host)
+	}
+	ts := s.At.UnixNano()
+	lines := []string{fmt.Sprintf("%s_workflows%s started=%di,completed=%di,failed=%di,active=%di %d",
+		measurement, tags, s.Started, s.Completed, s.Failed, s.Active, ts)}
+	timing := func(kind, name string, t timingStat, withAvg bool) {
+		fields := fmt.Sprintf("count=%di", t.Count)
+		if withAvg {
+			fields += fmt.Sprintf(",avg=%g", t.Avg)
+		}
+		fields += fmt.Sprintf(",p50=%g,p95=%g,p99=%g", t.percentile(50), t.percentile(95), t.percentile(99))
+		nameTag := ""
+		if name != "" {
+			nameTag = ",name=" + influxTagEscaper.Replace(name)
+		}
+		lines = append(lines, fmt.Sprintf("%s_timing%s,kind=%s%s %s %d", measurement, tags, kind, nameTag, fields, ts))
+	}
+	if s.Workflow.Count > 0 {
+		timing("workflow", "", s.Workflow, false)
+	}
+	for _, name := range sortedTimingNames(s.Transactions) {
+		timing("transaction", name, s.Transactions[name], true)
+	}
+	for _, name := range sortedTimingNames(s.Steps) {
+		timing("step", name, s.Steps[name], true)
+	}
+	return lines
+}
+
+func (s *influxSink) push(sample metricsSample) error {
+	body := strings.Join(influxLines(metricsPrefix, s.host, sample), "\n") + "\n"
+	req, err := http.NewRequest(http.MethodPost, s.url, strings.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	if s.token != "" {
+		req.Header.Set("Authorization", "Token "+s.token)
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
+
+// redactURL drops the credentials a URL may carry, for messages.
+func redactURL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return raw
+	}
+	u.User = nil
+	q := u.Query()
+	for _, key := range []string{"p", "password", "token"} {
+		if q.Has(key) {
+			q.Set(key, "xxxxx")
+		}
+	}
+	u.RawQuery = q.Encode()
+	return u.String()
+}
+
+func validateMetricsSinks() error {
+	if statsdAddr == "" && influxURL == "" {
+		return nil
+	}
+	if metricsPushInterval <= 0 {
+		return fmt.Errorf("-metricsInterval must be greater than zero")
+	}
+	if strings.TrimSpace(metricsPrefix) == "" {
+		return fmt.Errorf("-metricsPrefix cannot be empty")
+	}
+	if statsdAddr != "" {
+		if _, _, err := net.SplitHostPort(statsdAddr); err != nil {
+			return fmt.Errorf("-statsd %q must be host:port: %v", statsdAddr, err)
+		}
+	}
+	if influxURL != "" {
+		u, err := url.Parse(influxURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("-influxURL %q must be an http or https URL", influxURL)
+		}
+	}
+	return nil
+}
+
+// startMetricsSinks pushes run metrics to -statsd and -influxURL every
+// -metricsInterval until the returned function is called, which pushes the
+// last interval. A sink that fails is warned about once and retried at the
+// next push, so a metrics server that is down never fails the run.
+func startMetricsSinks() func() {
+	if statsdAddr == "" && influxURL == "" {
+		return func() {}
+	}
+	var sinks []metricsSink
+	if statsdAddr != "" {
+		sink, err := newStatsdSink(statsdAddr)
+		if err != nil {
+			pterm.Warning.Printf("Not pushing metrics to StatsD %s: %v\n", statsdAddr, err)
+		} else {
+			sinks = append(sinks, sink)
+		}
+	}
+	if influxURL != "" {
+		token, err := resolveSecretPlaceholders(influxToken)
+		if err != nil {
+			pterm.Warning.Printf("Not pushing metrics to InfluxDB: %v\n", err)
+		} else {
+			sinks = append(sinks, newInfluxSink(influxURL, token))
+		}
+	}
+	if len(sinks) == 0 {
+		return func() {}
+	}
+	for _, sink := range sinks {
+		storeLog(fmt.Sprintf("Pushing run metrics to %s every %s", sink.name(), formatSeconds(metricsPushInterval)))
+	}
+
+	sampler := &metricsSampler{}
+	var pushMu sync.Mutex
+	failing := make(map[string]bool)
+	push := func() {
+		pushMu.Lock()
+		defer pushMu.Unlock()
+		sample := sampler.sample(time.Now())
+		for _, sink := range sinks {
+			err := sink.push(sample)
+			switch {
+			case err != nil && !failing[sink.name()]:
+				failing[sink.name()] = true
+				msg := fmt.Sprintf("Pushing metrics to %s failed: %v", sink.name(), err)
+				reportLog.Warn(msg)
+			case err == nil && failing[sink.name()]:
+				failing[sink.name()] = false
+				storeLog(fmt.Sprintf("Pushing metrics to %s works again", sink.name()))
+			}
+		}
+	}
+
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(time.Duration(metricsPushInterval * float64(time.Second)))
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				push()
+			}
+		}
+	}()
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			close(done)
+			<-stopped
+			push()
+		})
+	}
+}
@@ -0,0 +1,367 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	notifyURLs   string
+	notifyEvents string
+)
+
+func init() {
+	flag.StringVar(&notifyURLs, "notify", "", "Post run events to these comma-separated webhook URLs (Slack, Teams or JSON); prefix one with slack:, teams: or webhook: to pick its format, and use {{env:NAME}} or {{file:path}} for secret URLs")
+	flag.StringVar(&notifyEvents, "notifyOn", "start,complete,breach", "Run events to post to -notify: start, complete, failure (a run that failed) and breach (SLA thresholds or the error budget broken)")
+}
+
+// notifyTimeout bounds each post, and how long the end of a run waits for
+// the posts still on their way.
+const notifyTimeout = 10 * time.Second
+
+// Run events a webhook can be told about.
+const (
+	eventRunStart    = "run.start"
+	eventRunComplete = "run.complete"
+	eventSLABreach   = "sla.breach"
+)
+
+// notification is a run event as the webhooks are told about it.
+type notification struct {
+	Event string `json:"event"`
+	Title string `json:"title"`
+	// Status is started, passed, failed or breached.
+	Status   string      `json:"status"`
+	Workflow string      `json:"workflow"`
+	Host     string      `json:"host"`
+	Time     time.Time   `json:"time"`
+	Summary  []noticeRow `json:"summary"`
+	Details  []string    `json:"details,omitempty"`
+	Run      *runRecord  `json:"run,omitempty"`
+}
+
+// noticeRow is a line of a notification's summary table.
+type noticeRow struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// webhook is a URL run events are posted to, in the format its service
+// reads.
+type webhook struct {
+	url    string
+	format string
+}
+
+// name identifies the webhook in logs without the secret in its URL.
+func (w webhook) name() string {
+	host := w.url
+	if u, err := url.Parse(w.url); err == nil {
+		host = u.Host
+	}
+	return fmt.Sprintf("%s webhook %s", w.format, host)
+}
+
+// parseWebhook reads a -notify entry: a URL, optionally after slack:,
+// teams: or webhook:. Without a prefix the format follows the host.
+func parseWebhook(entry string) (webhook, error) {
+	format := ""
+	for _, prefix := range []string{"slack", "teams", "webhook"} {
+		if rest, ok := strings.CutPrefix(entry, prefix+":"); ok && !strings.HasPrefix(rest, "//") {
+			format, entry = prefix, rest
+			break
+		}
+	}
+	u, err := url.Parse(entry)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return webhook{}, fmt.Errorf("-notify needs http or https webhook URLs, not %q", redactURL(entry))
+	}
+	if format == "" {
+		host := strings.ToLower(u.Hostname())
+		switch {
+		case host == "hooks.slack.com":
+			format = "slack"
+		case strings.HasSuffix(host, ".webhook.office.com") || host == "outlook.office.com" || strings.HasSuffix(host, ".logic.azure.com"):
+			format = "teams"
+		default:
+			format = "webhook"
+		}
+	}
+	return webhook{url: entry, format: format}, nil
+}
+
+// parseNotifyEvents reads -notifyOn.
+func parseNotifyEvents(value string) (map[string]bool, error) {
+	events := make(map[string]bool)
+	for _, event := range strings.Split(value, ",") {
+		event = strings.ToLower(strings.TrimSpace(event))
+		switch event {
+		case "":
+		case "start", "complete", "failure", "breach":
+			events[event] = true
+		default:
+			return nil, fmt.Errorf("-notifyOn takes start, complete, failure and breach, not %q", event)
+		}
+	}
+	return events, nil
+}
+
+// notifier posts run events to the -notify webhooks; it is nil without
+// -notify, and its methods then do nothing.
+type notifier struct {
+	hooks    []webhook
+	events   map[string]bool
+	workflow string
+	host     string
+	client   *http.Client
+	pending  sync.WaitGroup
+}
+
+var notifications *notifier
+
+// setupNotifications checks -notify and -notifyOn for the run of config.
+func setupNotifications(configPath string, config *Configuration) error {
+	if strings.TrimSpace(notifyURLs) == "" {
+		return nil
+	}
+	events, err := parseNotifyEvents(notifyEvents)
+	if err != nil {
+		return err
+	}
+	n := &notifier{
+		events:   events,
+		workflow: filepath.Base(configPath),
+		host:     fmt.Sprintf("%s:%d", config.Host, config.Port),
+		client:   &http.Client{Timeout: notifyTimeout},
+	}
+	for _, entry := range strings.Split(notifyURLs, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		resolved, err := resolveSecretPlaceholders(entry)
+		if err != nil {
+			return fmt.Errorf("-notify: %w", err)
+		}
+		hook, err := parseWebhook(resolved)
+		if err != nil {
+			return err
+		}
+		n.hooks = append(n.hooks, hook)
+	}
+	notifications = n
+	return nil
+}
+
+// post sends note to every webhook in the background; wait waits for them.
+func (n *notifier) post(note notification) {
+	note.Workflow, note.Host, note.Time = n.workflow, n.host, time.Now().UTC()
+	for _, hook := range n.hooks {
+		body, err := webhookPayload(hook.format, note)
+		if err != nil {
+			reportLog.Warn(fmt.Sprintf("Cannot build the %s notification for %s: %v", note.Event, hook.name(), err))
+			continue
+		}
+		n.pending.Add(1)
+		go func(hook webhook) {
+			defer n.pending.Done()
+			if err := n.send(hook, body); err != nil {
+				reportLog.Warn(fmt.Sprintf("Posting the %s notification to %s failed: %v", note.Event, hook.name(), err))
+				return
+			}
+			storeLog(fmt.Sprintf("Posted the %s notification to %s", note.Event, hook.name()))
+		}(hook)
+	}
+}
+
+func (n *notifier) send(hook webhook, body []byte) error {
+	resp, err := n.client.Post(hook.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		// The error carries the URL, and with it the webhook's secret.
+		if urlErr, ok := err.(*url.Error); ok {
+			err = urlErr.Err
+		}
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
+
+// wait waits up to notifyTimeout for the posts on their way.
+func (n *notifier) wait() {
+	done := make(chan struct{})
+	go func() {
+		n.pending.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(notifyTimeout):
+		reportLog.Warn("Gave up waiting for the run notifications to be posted")
+	}
+}
+
+// notifyRunStart tells the webhooks the load has started.
+func notifyRunStart(config *Configuration) {
+	n := notifications
+	if n == nil || !n.events["start"] {
+		return
+	}
+	rows := []noticeRow{{"Mode", runModeName(config)}, {"vUsers", fmt.Sprint(max(concurrent, 1))}}
+	switch {
+	case runtimeDuration > 0:
+		rows = append(rows, noticeRow{"Runtime", formatSeconds(float64(runtimeDuration))})
+	case iterationsPerVUserFlag > 0:
+		rows = append(rows, noticeRow{"Iterations per vUser", fmt.Sprint(iterationsPerVUserFlag)})
+	case iterationMode():
+		rows = append(rows, noticeRow{"Iterations", iterationsFlag.String()})
+	}
+	n.post(notification{
+		Event:   eventRunStart,
+		Title:   fmt.Sprintf("3270Connect run of %s started", n.workflow),
+		Status:  "started",
+		Summary: rows,
+	})
+}
+
+// notifyBreach tells the webhooks the run broke its SLA: broken lists the
+// thresholds it broke, or why the error budget aborted it.
+func notifyBreach(title string, rows []noticeRow, broken []string) {
+	n := notifications
+	if n == nil || !n.events["breach"] {
+		return
+	}
+	n.post(notification{
+		Event:   eventSLABreach,
+		Title:   fmt.Sprintf("3270Connect run of %s: %s", n.workflow, title),
+		Status:  "breached",
+		Summary: rows,
+		Details: broken,
+	})
+}
+
+// notifyRunComplete tells the webhooks how the run ended and waits for
+// every notification to be posted.
+func notifyRunComplete(configPath string, config *Configuration, exitCode int) {
+	n := notifications
+	if n == nil {
+		return
+	}
+	note := runCompleteNotice(n.workflow, newRunRecord(configPath, config, exitCode))
+	if n.events["complete"] || (n.events["failure"] && note.Status == "failed") {
+		n.post(note)
+	}
+	n.wait()
+}
+
+// runFailed reports whether a finished run failed: it exited non-zero, or
+// any of its workflows failed.
+func runFailed(r runRecord) bool {
+	return r.ExitCode != 0 || r.Failed > 0
+}
+
+// runCompleteNotice is the run.complete notification of a finished run.
+func runCompleteNotice(workflow string, r runRecord) notification {
+	status := "passed"
+	if runFailed(r) {
+		status = "failed"
+	}
+	return notification{
+		Event:   eventRunComplete,
+		Title:   fmt.Sprintf("3270Connect run of %s %s", workflow, status),
+		Status:  status,
+		Summary: runSummaryRows(r),
+		Details: errorDetails(r.Errors),
+		Run:     &r,
+	}
+}
+
+// runSummaryRows is the summary table of a finished run.
+func runSummaryRows(r runRecord) []noticeRow {
+	return []noticeRow{
+		{"Outcome", r.Outcome},
+		{"Mode", r.Mode},
+		{"vUsers", fmt.Sprint(r.VUsers)},
+		{"Duration", formatSeconds(float64(int64(r.EndedAt.Sub(r.StartedAt).Seconds())))},
+		{"Workflows Started", fmt.Sprint(r.Started)},
+		{"Workflows Completed", fmt.Sprint(r.Completed)},
+		{"Workflows Failed", fmt.Sprint(r.Failed)},
+		{"Error Rate", fmt.Sprintf("%.2f%%", r.errorRate())},
+		{"Average Workflow Time", fmt.Sprintf("%.3fs", r.Avg)},
+		{"P95 Workflow Time", fmt.Sprintf("%.3fs", r.P95)},
+	}
+}
+
+// errorDetails lists the most frequent errors of a run.
+func errorDetails(errors []errorRecord) []string {
+	var details []string
+	for i, e := range errors {
+		if i == 5 {
+			details = append(details, fmt.Sprintf("and %d more kinds of errors", len(errors)-i))
+			break
+		}
+		details = append(details, fmt.Sprintf("%dx %s", e.Count, e.Message))
+	}
+	return details
+}
+
+// noticeTable renders rows as a plain text table.
+func noticeTable(rows []noticeRow) string {
+	width := 0
+	for _, row := range rows {
+		width = max(width, len(row.Name))
+	}
+	var b strings.Builder
+	for _, row := range rows {
+		fmt.Fprintf(&b, "%-*s  %s\n", width, row.Name, row.Value)
+	}
+	return strings.TrimSuffix(b.String(), "\n")
+}
+
+// webhookPayload formats note for a webhook: a Slack message, a Teams
+// message card, or else the notification itself as JSON.
+func webhookPayload(format string, note notification) ([]byte, error) {
+	switch format {
+	case "slack":
+		text := fmt.Sprintf("*%s* (%s)", note.Title, note.Host)
+		if len(note.Summary) > 0 {
+			text += "\n```\n" + noticeTable(note.Summary) + "\n```"
+		}
+		for _, detail := range note.Details {
+			text += "\n• " + detail
+		}
+		return json.Marshal(map[string]string{"text": text})
+	case "teams":
+		facts := make([]map[string]string, 0, len(note.Summary)+1)
+		facts = append(facts, map[string]string{"name": "Host", "value": note.Host})
+		for _, row := range note.Summary {
+			facts = append(facts, map[string]string{"name": row.Name, "value": row.Value})
+		}
+		section := map[string]any{"facts": facts}
+		if len(note.Details) > 0 {
+			section["text"] = "- " + strings.Join(note.Details, "\n- ")
+		}
+		return json.Marshal(map[string]any{
+			"@type":      "MessageCard",
+			"@context":   "https://schema.org/extensions",
+			"summary":    note.Title,
+			"title":      note.Title,
+			"themeColor": noticeColors[note.Status],
+			"sections":   []any{section},
+		})
+	}
+	return json.Marshal(note)
+}
+
+var noticeColors = map[string]string{"started": "1D4ED8", "passed": "15803D", "failed": "B91C1C", "breached": "B91C1C"}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"runtime"
+)
+
+// browserCommand gives the command that opens url in the default browser
+// on macOS and Linux. It fails when there is no desktop to show it on,
+// such as over SSH or in a container.
+func browserCommand(url string) ([]string, error) {
+	if os.Getenv("SSH_CONNECTION") != "" {
+		return nil, errors.New("signed in over SSH")
+	}
+	switch runtime.GOOS {
+	case "darwin":
+		return []string{"open", url}, nil
+	case "windows":
+		return nil, errors.New("Windows opens the dashboard in a window of its own")
+	}
+	if os.Getenv("DISPLAY") == "" && os.Getenv("WAYLAND_DISPLAY") == "" {
+		return nil, errors.New("no desktop session")
+	}
+	path, err := exec.LookPath("xdg-open")
+	if err != nil {
+		return nil, errors.New("xdg-open is not installed")
+	}
+	return []string{path, url}, nil
+}
@@ -3,6 +3,27 @@
 
 package main
 
+import "os/exec"
+
+// openDashboardEmbedded opens the dashboard in the default browser, as
+// there is no embedded window outside Windows. Without a desktop to open
+// it on, it tells where the dashboard is instead.
 func openDashboardEmbedded() {
-	pterm.Warning.Println("Embedded dashboard is only supported on Windows.")
+	if !*startDashboard {
+		pterm.Warning.Println("Dashboard mode not enabled. Skipping browser launch.")
+		return
+	}
+	url := dashboardURL()
+	args, err := browserCommand(url)
+	if err != nil {
+		pterm.Info.Printf("Not opening a browser (%v) - the dashboard is at %s\n", err, url)
+		return
+	}
+	cmd := exec.Command(args[0], args[1:]...)
+	if err := cmd.Start(); err != nil {
+		pterm.Warning.Printf("Could not open a browser - the dashboard is at %s: %v\n", url, err)
+		return
+	}
+	// The opener hands the URL over and exits; reap it.
+	go cmd.Wait()
 }
@@ -34,7 +34,7 @@ func openDashboardEmbedded() {
 		pterm.Warning.Printf("Icon file %s not found. Skipping icon setup.\n", iconPath)
 	}
 
-	w.Navigate("http://localhost:9200/dashboard")
+	w.Navigate(dashboardURL())
 
 	defer func() {
 		pterm.Info.Println("WebView2 window closed. Initiating shutdown.")
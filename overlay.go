@@ -0,0 +1,112 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// overlayList collects repeated -overlay flags in the order given.
+type overlayList []string
+
+func (l *overlayList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *overlayList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+var configOverlays overlayList
+
+func init() {
+	flag.Var(&configOverlays, "overlay", "Deep-merge this workflow file onto -config before running (repeatable; later overlays win)")
+}
+
+// applyOverlays merges each overlay file onto the workflow JSON in order.
+func applyOverlays(data []byte, paths []string) ([]byte, error) {
+	if len(paths) == 0 {
+		return data, nil
+	}
+	var doc interface{}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	for _, path := range paths {
+		overlayData, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("reading overlay: %w", err)
+		}
+		var overlay map[string]interface{}
+		if err := json.Unmarshal(overlayData, &overlay); err != nil {
+			return nil, fmt.Errorf("decoding overlay %s: %w", path, err)
+		}
+		if doc, err = mergeOverlay(doc, overlay); err != nil {
+			return nil, fmt.Errorf("overlay %s: %w", path, err)
+		}
+	}
+	return json.Marshal(doc)
+}
+
+// mergeOverlay merges an overlay document onto base. Objects merge key by
+// key, null removes a key, and anything else (lists included) replaces the
+// base value. Top-level keys written as paths, such as "Steps[3].Text",
+// patch a single value the way -set does.
+func mergeOverlay(base interface{}, overlay map[string]interface{}) (interface{}, error) {
+	var paths []string
+	plain := make(map[string]interface{})
+	for key, value := range overlay {
+		if strings.ContainsAny(key, ".[") {
+			paths = append(paths, key)
+		} else {
+			plain[key] = value
+		}
+	}
+	doc := mergeJSON(base, plain)
+	sort.Strings(paths)
+	for _, path := range paths {
+		elems, err := parseOverridePath(path)
+		if err != nil {
+			return nil, err
+		}
+		value := overlay[path]
+		leaf := func(reflect.Type) interface{} { return value }
+		if doc, err = setOverride(doc, reflect.TypeOf(Configuration{}), elems, leaf, ""); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+	}
+	return doc, nil
+}
+
+func mergeJSON(base, overlay interface{}) interface{} {
+	overlayObj, ok := overlay.(map[string]interface{})
+	if !ok {
+		return overlay
+	}
+	baseObj, ok := base.(map[string]interface{})
+	if !ok {
+		baseObj = make(map[string]interface{})
+	}
+	for key, value := range overlayObj {
+		// Match keys case-insensitively like encoding/json does, so an
+		// overlay's "host" replaces the base "Host".
+		target := key
+		for existing := range baseObj {
+			if strings.EqualFold(existing, key) {
+				target = existing
+				break
+			}
+		}
+		if value == nil {
+			delete(baseObj, target)
+			continue
+		}
+		baseObj[target] = mergeJSON(baseObj[target], value)
+	}
+	return baseObj
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// overrideList collects repeated -set flags in the order given.
+type overrideList []string
+
+func (l *overrideList) String() string {
+	return strings.Join(*l, " ")
+}
+
+func (l *overrideList) Set(value string) error {
+	if !strings.Contains(value, "=") {
+		return fmt.Errorf("expected key=value, got %q", value)
+	}
+	*l = append(*l, value)
+	return nil
+}
+
+var configOverrides overrideList
+
+func init() {
+	flag.Var(&configOverrides, "set", "Override a workflow setting after loading, e.g. -set Host=mainframe2 or -set Steps[3].Text=NEWUSER (repeatable)")
+}
+
+// overridePathElem is one hop of a -set path: a field or map key, or a
+// 0-based list index.
+type overridePathElem struct {
+	key     string
+	index   int
+	isIndex bool
+}
+
+// parseOverridePath splits "Steps[3].Text" into its hops.
+func parseOverridePath(path string) ([]overridePathElem, error) {
+	var elems []overridePathElem
+	for _, part := range strings.Split(path, ".") {
+		name := part
+		var indexes []string
+		if open := strings.Index(part, "["); open >= 0 {
+			name = part[:open]
+			rest := part[open:]
+			for rest != "" {
+				end := strings.Index(rest, "]")
+				if rest[0] != '[' || end < 0 {
+					return nil, fmt.Errorf("malformed index in %q", path)
+				}
+				indexes = append(indexes, rest[1:end])
+				rest = rest[end+1:]
+			}
+		}
+		if name == "" {
+			return nil, fmt.Errorf("empty field name in %q", path)
+		}
+		elems = append(elems, overridePathElem{key: name})
+		for _, idx := range indexes {
+			n, err := strconv.Atoi(idx)
+			if err != nil || n < 0 {
+				return nil, fmt.Errorf("index %q in %q is not a non-negative number", idx, path)
+			}
+			elems = append(elems, overridePathElem{index: n, isIndex: true})
+		}
+	}
+	return elems, nil
+}
+
+// applyConfigOverrides patches the workflow JSON with key=value overrides
+// before it is decoded. Paths follow the Go field names, so mistakes are
+// reported instead of silently adding unused keys.
+func applyConfigOverrides(data []byte, overrides []string) ([]byte, error) {
+	if len(overrides) == 0 {
+		return data, nil
+	}
+	var doc interface{}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	for _, override := range overrides {
+		path, value, _ := strings.Cut(override, "=")
+		elems, err := parseOverridePath(strings.TrimSpace(path))
+		if err != nil {
+			return nil, fmt.Errorf("-set %s: %w", override, err)
+		}
+		leaf := func(t reflect.Type) interface{} { return overrideValue(t, value) }
+		if doc, err = setOverride(doc, reflect.TypeOf(Configuration{}), elems, leaf, ""); err != nil {
+			return nil, fmt.Errorf("-set %s: %w", override, err)
+		}
+	}
+	return json.Marshal(doc)
+}
+
+// setOverride replaces the value at elems inside node with leaf's result
+// for the target type. t is the Go type node decodes into.
+func setOverride(node interface{}, t reflect.Type, elems []overridePathElem, leaf func(reflect.Type) interface{}, at string) (interface{}, error) {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if len(elems) == 0 {
+		return leaf(t), nil
+	}
+	elem := elems[0]
+	if elem.isIndex {
+		at = fmt.Sprintf("%s[%d]", at, elem.index)
+		list, ok := node.([]interface{})
+		if t.Kind() != reflect.Slice || !ok {
+			return nil, fmt.Errorf("%s is not a list", strings.TrimSuffix(at, fmt.Sprintf("[%d]", elem.index)))
+		}
+		if elem.index >= len(list) {
+			return nil, fmt.Errorf("%s is out of range - the list has %d entries", at, len(list))
+		}
+		child, err := setOverride(list[elem.index], t.Elem(), elems[1:], leaf, at)
+		if err != nil {
+			return nil, err
+		}
+		list[elem.index] = child
+		return list, nil
+	}
+
+	key := elem.key
+	var childType reflect.Type
+	switch t.Kind() {
+	case reflect.Struct:
+		fields := jsonFields(t)
+		field, known := fields[strings.ToLower(key)]
+		if !known {
+			return nil, fmt.Errorf("unknown field %q%s", key, suggestField(key, fields))
+		}
+		key, childType = field.Name, field.Type
+	case reflect.Map:
+		childType = t.Elem()
+	default:
+		return nil, fmt.Errorf("%s has no field %q", at, key)
+	}
+	at = joinFieldPath(at, key)
+	obj, _ := node.(map[string]interface{})
+	if obj == nil {
+		obj = make(map[string]interface{})
+	}
+	// encoding/json matches struct keys case-insensitively, so patch the key
+	// the file already uses.
+	if t.Kind() == reflect.Struct {
+		for existing := range obj {
+			if strings.EqualFold(existing, key) {
+				key = existing
+				break
+			}
+		}
+	}
+	child, err := setOverride(obj[key], childType, elems[1:], leaf, at)
+	if err != nil {
+		return nil, err
+	}
+	obj[key] = child
+	return obj, nil
+}
+
+// overrideValue types a -set value for its target: strings are taken
+// verbatim, anything else is parsed as JSON when it can be, so numbers,
+// booleans and objects such as {"Min":1,"Max":2} work.
+func overrideValue(t reflect.Type, value string) interface{} {
+	if t.Kind() == reflect.String {
+		return value
+	}
+	if json.Valid([]byte(value)) {
+		return json.RawMessage(value)
+	}
+	return value
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	connect3270 "github.com/3270io/3270Connect/connect3270"
+)
+
+var (
+	setupWorkflowFlag    string
+	teardownWorkflowFlag string
+)
+
+func init() {
+	flag.StringVar(&setupWorkflowFlag, "setup", "", "Workflow file run once before the load, such as seeding test accounts; the load is skipped if it fails")
+	flag.StringVar(&teardownWorkflowFlag, "teardown", "", "Workflow file run once after the load, even when the setup failed or the run was aborted")
+}
+
+// phaseWorkflowPath returns the Setup or Teardown workflow file of a run:
+// the flag, or else the configured path, which is relative to baseDir, the
+// directory of the configuration file.
+func phaseWorkflowPath(flagValue, configured, baseDir string) string {
+	if flagValue != "" {
+		return flagValue
+	}
+	if configured == "" || filepath.IsAbs(configured) {
+		return configured
+	}
+	return filepath.Join(baseDir, configured)
+}
+
+// runPhaseWorkflow runs the Setup or Teardown workflow at path once, on a
+// session of its own. It is kept out of the run's totals, and its steps are
+// timed under the phase name.
+func runPhaseWorkflow(phase, path string) error {
+	config, err := readConfiguration(path)
+	if err != nil {
+		return err
+	}
+	if err := config.resolveSecrets(); err != nil {
+		return err
+	}
+	if len(config.Workflows) > 0 {
+		return fmt.Errorf("a %s workflow cannot be a suite", phase)
+	}
+	if rsaToken != "" {
+		config.Token = rsaToken
+	}
+	config.phase = phase
+	config.workflowName = phase
+	e := connect3270.NewEmulator(config.Host, config.Port, strconv.Itoa(getNextAvailablePort()))
+	session := &vUserSession{}
+	defer session.close(e)
+	return runWorkflowWithEmulator(e, config, time.Time{}, session)
+}
+
+// runSetupWorkflow runs the Setup workflow of a load run, if it has one, and
+// reports whether the load may go ahead. Distributed workers leave both
+// phases to the controller.
+func runSetupWorkflow(config *Configuration, configPath string) bool {
+	path := phaseWorkflowPath(setupWorkflowFlag, config.Setup, filepath.Dir(configPath))
+	if path == "" || reportToURL != "" {
+		return true
+	}
+	pterm.Info.Printf("Running Setup workflow %s\n", path)
+	start := time.Now()
+	err := runPhaseWorkflow("Setup", path)
+	recordJUnitPhase("Setup", path, time.Since(start), err)
+	if err != nil {
+		msg := fmt.Sprintf("Setup workflow %s failed: %v", path, err)
+		schedulerLog.Error(msg + " - skipping the load.")
+		return false
+	}
+	logSuccess(schedulerLog, fmt.Sprintf("Setup workflow %s completed", path))
+	return true
+}
+
+// runTeardownWorkflow runs the Teardown workflow of a load run, if it has
+// one, however the load ended. A failure is reported but leaves the exit
+// code alone.
+func runTeardownWorkflow(config *Configuration, configPath string) {
+	path := phaseWorkflowPath(teardownWorkflowFlag, config.Teardown, filepath.Dir(configPath))
+	if path == "" || reportToURL != "" {
+		return
+	}
+	pterm.Info.Printf("Running Teardown workflow %s\n", path)
+	// The load may have ended by requesting a shutdown; the teardown runs
+	// regardless.
+	connect3270.ResetShutdown()
+	start := time.Now()
+	err := runPhaseWorkflow("Teardown", path)
+	recordJUnitPhase("Teardown", path, time.Since(start), err)
+	if err != nil {
+		msg := fmt.Sprintf("Teardown workflow %s failed: %v", path, err)
+		schedulerLog.Error(msg)
+		return
+	}
+	logSuccess(schedulerLog, fmt.Sprintf("Teardown workflow %s completed", path))
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	crand "crypto/rand"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// generatorPattern matches the data generator placeholders:
+// {{uuid}}, {{timestamp}}, {{randInt:min:max}} and {{date}} / {{date:layout}}.
+var generatorPattern = regexp.MustCompile(`\{\{(uuid|timestamp|randInt:(-?\d+):(-?\d+)|date(?::([^}]+))?)\}\}`)
+
+// resolveGenerators evaluates generator placeholders. Each distinct
+// placeholder is evaluated once per workflow run, so the same {{uuid}} typed
+// on one screen can be checked on the next.
+func (s *workflowState) resolveGenerators(text string) (string, error) {
+	if !strings.Contains(text, "{{") {
+		return text, nil
+	}
+	var genErr error
+	resolved := generatorPattern.ReplaceAllStringFunc(text, func(match string) string {
+		if value, ok := s.generated[match]; ok {
+			return value
+		}
+		value, err := generateValue(generatorPattern.FindStringSubmatch(match), s.started)
+		if err != nil {
+			if genErr == nil {
+				genErr = err
+			}
+			return match
+		}
+		if s.generated == nil {
+			s.generated = make(map[string]string)
+		}
+		s.generated[match] = value
+		return value
+	})
+	return resolved, genErr
+}
+
+func generateValue(m []string, now time.Time) (string, error) {
+	switch {
+	case m[1] == "uuid":
+		return newUUID()
+	case m[1] == "timestamp":
+		return strconv.FormatInt(now.UnixMilli(), 10), nil
+	case strings.HasPrefix(m[1], "randInt:"):
+		min, errMin := strconv.ParseInt(m[2], 10, 64)
+		max, errMax := strconv.ParseInt(m[3], 10, 64)
+		if errMin != nil || errMax != nil || min > max {
+			return "", fmt.Errorf("invalid %s - use {{randInt:min:max}} with min <= max", m[0])
+		}
+		delayRNGMu.Lock()
+		n := min + delayRNG.Int63n(max-min+1)
+		delayRNGMu.Unlock()
+		return strconv.FormatInt(n, 10), nil
+	default:
+		layout := m[4]
+		if layout == "" {
+			layout = "2006-01-02"
+		}
+		return now.Format(layout), nil
+	}
+}
+
+// newUUID returns a random (version 4) UUID.
+func newUUID() (string, error) {
+	var b [16]byte
+	if _, err := crand.Read(b[:]); err != nil {
+		return "", err
+	}
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16]), nil
+}
+
+// validateGenerators checks generator placeholders that can be rejected
+// before a run starts.
+func validateGenerators(text string) error {
+	for _, m := range generatorPattern.FindAllStringSubmatch(text, -1) {
+		if strings.HasPrefix(m[1], "randInt:") {
+			if _, err := generateValue(m, time.Now()); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
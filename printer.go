@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"time"
+
+	connect3270 "github.com/3270io/3270Connect/connect3270"
+)
+
+const defaultWaitForPrintTimeout = 30 * time.Second
+
+// PrinterConfig opens a TN3270E printer session alongside the workflow's
+// terminal once it connects. Without an LUName the printer is associated
+// with the terminal's LU and receives whatever the application prints for
+// it.
+type PrinterConfig struct {
+	LUName     string `json:"LUName,omitempty"`
+	OutputFile string
+}
+
+// validatePrinterSettings checks the Printer block of config and that
+// WaitForPrint steps have a printer to wait on.
+func validatePrinterSettings(config *Configuration) error {
+	p := config.Printer
+	if p == nil {
+		if stepsUse(config.Steps, "WaitForPrint") || stepsUse(config.OnError, "WaitForPrint") {
+			return fmt.Errorf("WaitForPrint needs a Printer block - nothing is printing")
+		}
+		return nil
+	}
+	if p.OutputFile == "" {
+		return fmt.Errorf("Printer.OutputFile is empty - printed output needs a home")
+	}
+	for _, text := range []string{p.LUName, p.OutputFile} {
+		if err := validateGenerators(text); err != nil {
+			return fmt.Errorf("Printer: %w", err)
+		}
+	}
+	return nil
+}
+
+// stepsUse reports whether any step, including those nested in If blocks,
+// is of type stepType.
+func stepsUse(steps []Step, stepType string) bool {
+	for _, step := range steps {
+		if step.Type == stepType || stepsUse(step.Steps, stepType) || stepsUse(step.Else, stepType) {
+			return true
+		}
+	}
+	return false
+}
+
+// startWorkflowPrinter opens the printer session for a workflow whose
+// terminal has just connected. Placeholders in the settings are resolved
+// per workflow, so {{uuid}} in OutputFile gives each vUser its own file.
+func startWorkflowPrinter(e *connect3270.Emulator, config *PrinterConfig, state *workflowState) (*connect3270.Printer, error) {
+	luName, err := state.resolve(config.LUName)
+	if err != nil {
+		return nil, err
+	}
+	outputFile, err := state.resolve(config.OutputFile)
+	if err != nil {
+		return nil, err
+	}
+	printer, err := e.StartPrinter(luName, filepath.Clean(outputFile))
+	if err != nil {
+		return nil, err
+	}
+	storeLog(fmt.Sprintf("Printer session %s open, printing to %s", printer.LUName(), outputFile))
+	return printer, nil
+}
+
+// describePrinter returns the Printer block for the configuration summary.
+func describePrinter(p *PrinterConfig) string {
+	target := "associated with the terminal"
+	if p.LUName != "" {
+		target = "LU " + p.LUName
+	}
+	return fmt.Sprintf("%s -> %s", target, p.OutputFile)
+}
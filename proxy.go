@@ -0,0 +1,28 @@
+package main
+
+import (
+	"fmt"
+
+	connect3270 "github.com/3270io/3270Connect/connect3270"
+)
+
+// validateProxySettings checks the Proxy URL of config, once any secrets in
+// it are resolved.
+func validateProxySettings(config *Configuration) error {
+	if config.Proxy == "" || hasSecretPlaceholder(config.Proxy) {
+		return nil
+	}
+	if _, err := connect3270.ParseProxy(config.Proxy); err != nil {
+		return fmt.Errorf("Proxy: %w", err)
+	}
+	return nil
+}
+
+// displayProxy returns the Proxy URL with any password hidden, for output.
+func displayProxy(raw string) string {
+	u, err := connect3270.ParseProxy(raw)
+	if err != nil {
+		return raw
+	}
+	return u.Redacted()
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"sync/atomic"
+	"time"
+
+	connect3270 "github.com/3270io/3270Connect/connect3270"
+)
+
+const (
+	defaultReconnectAttempts = 3
+	defaultReconnectDelay    = time.Second
+)
+
+// ReconnectConfig lets a workflow ride out the host dropping its session:
+// the session is connected again and the workflow resumes at the last
+// Checkpoint step it passed, instead of failing the iteration.
+type ReconnectConfig struct {
+	// MaxAttempts caps the reconnects of one workflow run; 0 means 3.
+	MaxAttempts int `json:"MaxAttempts,omitempty"`
+	// Delay is the pause in seconds before each reconnect; 0 means 1.
+	Delay float64 `json:"Delay,omitempty"`
+}
+
+func (r *ReconnectConfig) attempts() int {
+	if r.MaxAttempts > 0 {
+		return r.MaxAttempts
+	}
+	return defaultReconnectAttempts
+}
+
+func (r *ReconnectConfig) delay() time.Duration {
+	if r.Delay > 0 {
+		return secondsToDuration(r.Delay)
+	}
+	return defaultReconnectDelay
+}
+
+// totalReconnects counts sessions reconnected after a host drop.
+var totalReconnects int64
+
+// validateReconnectSettings checks the Reconnect block and that Checkpoint
+// is only set on top-level steps, the only places a workflow resumes at.
+func validateReconnectSettings(config *Configuration) error {
+	if r := config.Reconnect; r != nil {
+		if r.MaxAttempts < 0 {
+			return fmt.Errorf("Reconnect.MaxAttempts cannot be negative")
+		}
+		if r.Delay < 0 {
+			return fmt.Errorf("Reconnect.Delay cannot be negative")
+		}
+	}
+	for _, step := range config.Steps {
+		if nestedCheckpoint(step.Steps) || nestedCheckpoint(step.Else) {
+			return fmt.Errorf("Checkpoint is only allowed on top-level steps, not inside If blocks")
+		}
+	}
+	if nestedCheckpoint(config.OnError) {
+		return fmt.Errorf("Checkpoint is not allowed on OnError steps")
+	}
+	return nil
+}
+
+func nestedCheckpoint(steps []Step) bool {
+	for _, step := range steps {
+		if step.Checkpoint || nestedCheckpoint(step.Steps) || nestedCheckpoint(step.Else) {
+			return true
+		}
+	}
+	return false
+}
+
+// sessionDropped reports whether e has lost its host connection, which
+// tells a host drop apart from a step that failed on a live screen.
+func sessionDropped(e *connect3270.Emulator) bool {
+	connected, err := e.HostConnected()
+	return err != nil || !connected
+}
+
+// resumeIndex returns the step to carry on from after the session of
+// steps[failed] was reconnected: the last Checkpoint at or before it, or
+// else the step after that session's first Connect.
+func resumeIndex(steps []Step, failed int) int {
+	session := steps[failed].Session
+	resume := -1
+	for i := 0; i <= failed; i++ {
+		if steps[i].Checkpoint {
+			resume = i
+		}
+	}
+	if resume < 0 {
+		resume = 0
+		for i := 0; i < failed; i++ {
+			if steps[i].Type == "Connect" && steps[i].Session == session {
+				resume = i
+				break
+			}
+		}
+	}
+	// The Connect itself was just redone.
+	if steps[resume].Type == "Connect" && steps[resume].Session == session {
+		resume++
+	}
+	return resume
+}
+
+// reconnectSession connects the session of step again, pausing first. It
+// leaves the session in the state a workflow Connect step does.
+func reconnectSession(e *connect3270.Emulator, step Step, config *Configuration, state *workflowState, label string) error {
+	se, err := state.emulatorFor(e, step)
+	if err != nil {
+		return err
+	}
+	msg := fmt.Sprintf("Host dropped the session on scriptPort %s at %s step - reconnecting", label, step.Type)
+	logOrStore(schedulerLog, connect3270.Verbose, slog.LevelWarn, msg)
+	_ = se.Disconnect()
+	if err := state.pause(config.Reconnect.delay()); err != nil {
+		return err
+	}
+	if err := executeStep(se, Step{Type: "Connect"}, state); err != nil {
+		return err
+	}
+	if config.WaitForField {
+		if err := se.WaitForField(time.Second); err != nil {
+			return err
+		}
+	}
+	atomic.AddInt64(&totalReconnects, 1)
+	return nil
+}
+
+// printReconnectSummary notes reconnects under the run summary table.
+func printReconnectSummary() {
+	if n := atomic.LoadInt64(&totalReconnects); n > 0 {
+		pterm.Info.Printf("Sessions reconnected after host drops: %d\n", n)
+	}
+}
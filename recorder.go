@@ -0,0 +1,235 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	connect3270 "github.com/3270io/3270Connect/connect3270"
+)
+
+var (
+	recordPath      string
+	recordHost      string
+	recordKeepTrace bool
+)
+
+func init() {
+	flag.StringVar(&recordPath, "record", "", "Open an interactive x3270 session and write the actions taken in it to this workflow file")
+	flag.StringVar(&recordHost, "recordHost", "", "host:port to record against (defaults to Host and Port from -config)")
+	flag.BoolVar(&recordKeepTrace, "keep-trace", false, "Keep the raw x3270 trace of -record, which holds everything typed in cleartext, passwords included")
+}
+
+// recordSession runs the interactive session of -record.
+var recordSession = connect3270.RecordSession
+
+// recordedStep is the compact JSON form of a recorded step, leaving out the
+// fields a recorder never sets.
+type recordedStep struct {
+	Type        string
+	Coordinates *recordedCoordinates `json:",omitempty"`
+	Text        string               `json:",omitempty"`
+	Variable    string               `json:",omitempty"`
+	Timeout     float64              `json:",omitempty"`
+	StepDelay   *DelayRange          `json:",omitempty"`
+}
+
+type recordedCoordinates struct {
+	Row    int
+	Column int
+	Length int `json:",omitempty"`
+}
+
+type recordedWorkflow struct {
+	Host  string
+	Port  int
+	Steps []recordedStep
+}
+
+func writeRecordedWorkflow(path, host string, port int, steps []Step) error {
+	workflow := recordedWorkflow{Host: host, Port: port}
+	for _, step := range steps {
+		rs := recordedStep{Type: step.Type, Text: step.Text, Variable: step.Variable, Timeout: step.Timeout}
+		if step.StepDelay != (DelayRange{}) {
+			delay := step.StepDelay
+			rs.StepDelay = &delay
+		}
+		if c := step.Coordinates; c != (connect3270.Coordinates{}) {
+			rs.Coordinates = &recordedCoordinates{Row: c.Row, Column: c.Column, Length: c.Length}
+		}
+		workflow.Steps = append(workflow.Steps, rs)
+	}
+	data, err := json.MarshalIndent(workflow, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0644)
+}
+
+// recordTarget works out the host and port to record against.
+func recordTarget() (string, int, error) {
+	if recordHost != "" {
+		host, portText, err := net.SplitHostPort(recordHost)
+		if err != nil {
+			return "", 0, fmt.Errorf("-recordHost %q: %w", recordHost, err)
+		}
+		port, err := strconv.Atoi(portText)
+		if err != nil || port <= 0 {
+			return "", 0, fmt.Errorf("-recordHost %q has an invalid port", recordHost)
+		}
+		return host, port, nil
+	}
+	data, err := os.ReadFile(configFile)
+	if err != nil {
+		return "", 0, fmt.Errorf("no -recordHost given and %s could not be read: %w", configFile, err)
+	}
+	var config Configuration
+	if err := json.Unmarshal(data, &config); err != nil {
+		return "", 0, fmt.Errorf("no -recordHost given and %s is not a valid workflow: %w", configFile, err)
+	}
+	if err := config.resolveSecrets(); err != nil {
+		return "", 0, fmt.Errorf("no -recordHost given and %s: %w", configFile, err)
+	}
+	if strings.TrimSpace(config.Host) == "" || config.Port <= 0 {
+		return "", 0, fmt.Errorf("no -recordHost given and %s has no Host/Port", configFile)
+	}
+	return config.Host, config.Port, nil
+}
+
+// runRecorder drives -record: an interactive session with tracing on, then
+// the trace converted into a workflow file.
+func runRecorder() error {
+	host, port, err := recordTarget()
+	if err != nil {
+		return err
+	}
+	tracePath := recordPath + ".trace"
+	os.Remove(tracePath)
+	pterm.Info.Printf("Recording %s:%d - use the session as normal and close the emulator when you are done.\n", host, port)
+	if err := recordSession(host, port, tracePath); err != nil {
+		if fileExists(tracePath) {
+			pterm.Warning.Printf("The trace %s holds everything typed in cleartext - delete it when done.\n", tracePath)
+		}
+		return err
+	}
+
+	// The trace holds every keystroke, passwords included, so it only
+	// outlives the recording when asked for or needed to look into a
+	// failed conversion.
+	steps, err := convertTraceFile(tracePath, recordPath, host, port, true)
+	if err != nil {
+		pterm.Warning.Printf("Keeping the trace %s to look into - it holds everything typed in cleartext, so delete it when done.\n", tracePath)
+		return fmt.Errorf("%s: %w", tracePath, err)
+	}
+	if recordKeepTrace {
+		pterm.Warning.Printf("Trace kept in %s - it holds everything typed in cleartext, passwords included.\n", tracePath)
+	} else if err := os.Remove(tracePath); err != nil {
+		pterm.Warning.Printf("Could not delete the trace %s, which holds everything typed in cleartext: %v\n", tracePath, err)
+	}
+	pterm.Success.Printf("Recorded %d steps to %s.\n", steps, recordPath)
+	return nil
+}
+
+// runConvertCommand implements `3270Connect convert [flags] file...`, which
+// turns existing x3270/s3270 -trace files, PCOMM macros and Host On-Demand
+// macros into workflows.
+func runConvertCommand(args []string) int {
+	fs := flag.NewFlagSet("convert", flag.ContinueOnError)
+	output := fs.String("o", "", "Output workflow file (only with a single trace; defaults to the trace name with .json)")
+	host := fs.String("host", "", "host:port for the workflow; replaces the traced host and is required for macros")
+	checks := fs.Bool("checks", true, "Generate a CheckValue step for every screen")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: 3270Connect convert [-o workflow.json] [-host host:port] [-checks=false] trace-or-macro...")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() == 0 || (*output != "" && fs.NArg() > 1) {
+		fs.Usage()
+		return 2
+	}
+	var hostOverride string
+	var portOverride int
+	if *host != "" {
+		h, portText, err := net.SplitHostPort(*host)
+		port, convErr := strconv.Atoi(portText)
+		if err != nil || convErr != nil || port <= 0 {
+			fmt.Fprintf(os.Stderr, "-host %q must be host:port\n", *host)
+			return 2
+		}
+		hostOverride, portOverride = h, port
+	}
+
+	exitCode := 0
+	for _, tracePath := range fs.Args() {
+		target := *output
+		if target == "" {
+			target = strings.TrimSuffix(tracePath, filepath.Ext(tracePath)) + ".json"
+		}
+		convert := convertTraceFile
+		if data, err := os.ReadFile(tracePath); err == nil && isMacroFile(tracePath, data) {
+			convert = convertMacroFile
+		}
+		steps, err := convert(tracePath, target, hostOverride, portOverride, *checks)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", tracePath, err)
+			exitCode = 1
+			continue
+		}
+		fmt.Printf("%s: %d steps written to %s\n", tracePath, steps, target)
+	}
+	return exitCode
+}
+
+func convertTraceFile(tracePath, target, host string, port int, checks bool) (int, error) {
+	traceFile, err := os.Open(tracePath)
+	if err != nil {
+		return 0, err
+	}
+	defer traceFile.Close()
+	session, err := parseTrace(traceFile)
+	if err != nil {
+		return 0, err
+	}
+	if host != "" {
+		session.host, session.port = host, port
+	}
+	steps, notes := traceToSteps(session, checks)
+	for _, note := range notes {
+		fmt.Fprintf(os.Stderr, "%s: %s\n", tracePath, note)
+	}
+	if err := writeRecordedWorkflow(target, session.host, session.port, steps); err != nil {
+		return 0, err
+	}
+	return len(steps), nil
+}
+
+// convertMacroFile imports a PCOMM or Host On-Demand macro. Macros do not
+// record the host, so host and port must be given; checks is unused because
+// macros carry their own screen waits.
+func convertMacroFile(macroPath, target, host string, port int, checks bool) (int, error) {
+	if host == "" {
+		return 0, fmt.Errorf("macros do not name a host - pass -host host:port")
+	}
+	data, err := os.ReadFile(macroPath)
+	if err != nil {
+		return 0, err
+	}
+	importer := detectInputImporter(macroPath, data)
+	imported, err := importer.parse(data)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", importer.name, err)
+	}
+	steps := append([]Step{{Type: "Connect"}}, imported...)
+	steps = append(steps, Step{Type: "Disconnect"})
+	if err := writeRecordedWorkflow(target, host, port, steps); err != nil {
+		return 0, err
+	}
+	return len(steps), nil
+}
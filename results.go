@@ -0,0 +1,203 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	resultsPath   string
+	resultsFormat string
+)
+
+func init() {
+	flag.StringVar(&resultsPath, "results", "", "Write every workflow run (start, duration, vUser, status, failing step, data row) to this CSV or NDJSON file")
+	flag.StringVar(&resultsFormat, "resultsFormat", "", "Format of -results: csv or ndjson (default: from the file extension, else csv)")
+}
+
+// resultsFlushInterval is how often the results file catches up, so it can
+// be followed while the run goes on.
+const resultsFlushInterval = 2 * time.Second
+
+// resultSample is one workflow run in the results file.
+type resultSample struct {
+	Start    time.Time `json:"start"`
+	Duration float64   `json:"durationSeconds"`
+	// VUser numbers the vUser that ran the workflow from 1.
+	VUser    int    `json:"vUser"`
+	Workflow string `json:"workflow,omitempty"`
+	// Status is passed, failed, timeout or connect_failed.
+	Status      string `json:"status"`
+	FailingStep string `json:"failingStep,omitempty"`
+	Error       string `json:"error,omitempty"`
+	// DataRow numbers the injection row the workflow used from 1; 0 when
+	// it used none.
+	DataRow int `json:"dataRow,omitempty"`
+}
+
+var resultsCSVHeader = []string{"start", "duration_seconds", "vuser", "workflow", "status", "failing_step", "error", "data_row"}
+
+func (s resultSample) csvRecord() []string {
+	dataRow := ""
+	if s.DataRow > 0 {
+		dataRow = strconv.Itoa(s.DataRow)
+	}
+	return []string{
+		s.Start.UTC().Format(time.RFC3339Nano),
+		strconv.FormatFloat(s.Duration, 'f', 6, 64),
+		strconv.Itoa(s.VUser),
+		s.Workflow,
+		s.Status,
+		s.FailingStep,
+		s.Error,
+		dataRow,
+	}
+}
+
+// resultsFileFormat returns the format of the results file at path.
+func resultsFileFormat(path, format string) (string, error) {
+	switch strings.ToLower(format) {
+	case "csv":
+		return "csv", nil
+	case "ndjson", "jsonl", "json":
+		return "ndjson", nil
+	case "":
+	default:
+		return "", fmt.Errorf("-resultsFormat must be csv or ndjson, not %q", format)
+	}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".ndjson", ".jsonl", ".json":
+		return "ndjson", nil
+	}
+	return "csv", nil
+}
+
+// resultStepName names the step at idx of a workflow run as the timings do;
+// the first setupSteps steps are SessionSetup's.
+func resultStepName(config *Configuration, idx, setupSteps int, step Step) string {
+	if idx < setupSteps {
+		return sessionStepTimingKey("SessionSetup", idx, step)
+	}
+	return stepTimingKey(config, idx-setupSteps, step)
+}
+
+// resultsWriter appends samples to the results file as workflows finish.
+type resultsWriter struct {
+	mu     sync.Mutex
+	file   *os.File
+	buf    *bufio.Writer
+	csv    *csv.Writer
+	json   *json.Encoder
+	err    error
+	done   chan struct{}
+	closed bool
+}
+
+var results *resultsWriter
+
+// openResults creates the -results file and starts keeping it current.
+func openResults() error {
+	if resultsPath == "" {
+		return nil
+	}
+	format, err := resultsFileFormat(resultsPath, resultsFormat)
+	if err != nil {
+		return err
+	}
+	if dir := filepath.Dir(resultsPath); dir != "." {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+	}
+	file, err := os.Create(resultsPath)
+	if err != nil {
+		return err
+	}
+	w := &resultsWriter{file: file, buf: bufio.NewWriter(file), done: make(chan struct{})}
+	if format == "csv" {
+		w.csv = csv.NewWriter(w.buf)
+		w.csv.Write(resultsCSVHeader)
+	} else {
+		w.json = json.NewEncoder(w.buf)
+	}
+	results = w
+	storeLog(fmt.Sprintf("Writing workflow results to %s (%s)", resultsPath, format))
+	go func() {
+		ticker := time.NewTicker(resultsFlushInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-w.done:
+				return
+			case <-ticker.C:
+				w.mu.Lock()
+				w.flush()
+				w.mu.Unlock()
+			}
+		}
+	}()
+	return nil
+}
+
+// recordResult appends a workflow run to the results file, if there is one.
+func recordResult(s resultSample) {
+	w := results
+	if w == nil {
+		return
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed || w.err != nil {
+		return
+	}
+	if w.csv != nil {
+		w.err = w.csv.Write(s.csvRecord())
+	} else {
+		w.err = w.json.Encode(s)
+	}
+	if w.err != nil {
+		pterm.Warning.Printf("Writing %s failed, so it stops here: %v\n", resultsPath, w.err)
+	}
+}
+
+// flush writes out what is buffered; the caller holds mu.
+func (w *resultsWriter) flush() {
+	if w.csv != nil {
+		w.csv.Flush()
+	}
+	if err := w.buf.Flush(); err != nil && w.err == nil {
+		w.err = err
+		pterm.Warning.Printf("Writing %s failed, so it stops here: %v\n", resultsPath, err)
+	}
+}
+
+// closeResults finishes the results file.
+func closeResults() {
+	w := results
+	if w == nil {
+		return
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return
+	}
+	w.closed = true
+	close(w.done)
+	w.flush()
+	if err := w.file.Close(); err != nil && w.err == nil {
+		w.err = err
+	}
+	if w.err == nil {
+		pterm.Info.Printf("Workflow results: %s\n", resultsPath)
+	}
+}
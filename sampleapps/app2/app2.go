@@ -1,15 +1,11 @@
 package app2
 
 import (
-	"encoding/json"
 	"fmt"
-	"io/ioutil"
 	"net"
 	"os"
-	"path/filepath"
 	"strconv"
 	"strings"
-	"time"
 
 	"github.com/mmcdole/gofeed"
 	"github.com/pterm/pterm"
@@ -166,51 +162,6 @@ func displayDetails(conn net.Conn, item *gofeed.Item) {
 	}
 }
 
-// Add Metrics type for dashboard compatibility
-type Metrics struct {
-	PID                     int       `json:"pid"`
-	ActiveWorkflows         int       `json:"activeWorkflows"`
-	TotalWorkflowsStarted   int       `json:"totalWorkflowsStarted"`
-	TotalWorkflowsCompleted int       `json:"totalWorkflowsCompleted"`
-	TotalWorkflowsFailed    int       `json:"totalWorkflowsFailed"`
-	Durations               []float64 `json:"durations"`
-	CPUUsage                []float64 `json:"cpuUsage"`
-	MemoryUsage             []float64 `json:"memoryUsage"`
-	Params                  string    `json:"params"`
-	RuntimeDuration         int       `json:"runtimeDuration"`
-	StartTimestamp          int64     `json:"startTimestamp"`
-}
-
-// startMetricsUpdater periodically writes a minimal metrics file.
-func startMetricsUpdater() {
-	pid := os.Getpid()
-	for {
-		metrics := Metrics{
-			PID:                     pid,
-			ActiveWorkflows:         0,
-			TotalWorkflowsStarted:   0,
-			TotalWorkflowsCompleted: 0,
-			TotalWorkflowsFailed:    0,
-			Durations:               []float64{},
-			CPUUsage:                []float64{},
-			MemoryUsage:             []float64{},
-			Params:                  "-runApp 2",
-			RuntimeDuration:         0,
-			StartTimestamp:          time.Now().Unix(),
-		}
-		dir, err := os.UserConfigDir()
-		if err != nil {
-			dir = filepath.Join(".", "dashboard")
-		} else {
-			dir = filepath.Join(dir, "3270Connect", "dashboard")
-		}
-		os.MkdirAll(dir, 0755)
-		data, _ := json.Marshal(metrics)
-		ioutil.WriteFile(filepath.Join(dir, fmt.Sprintf("metrics_%d.json", pid)), data, 0644)
-		time.Sleep(5 * time.Second)
-	}
-}
-
 func handle(conn net.Conn) {
 	defer conn.Close()
 	go3270.NegotiateTelnet(conn)
@@ -281,9 +232,9 @@ func handle(conn net.Conn) {
 	}
 }
 
-// In RunApplication, start the metrics updater before listening for connections.
+// RunApplication serves the sample application on port. The run hosting
+// it reports to the dashboard, with -runApp in its parameters.
 func RunApplication(port int) {
-	go startMetricsUpdater()
 	address := fmt.Sprintf(":%d", port)
 	ln, err := net.Listen("tcp", address)
 	if err != nil {
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"os"
+	"sync"
+
+	connect3270 "github.com/3270io/3270Connect/connect3270"
+)
+
+var errScreenJSONFile = errors.New("JSONScreenGrab cannot write to a File on the server - leave File out to get the capture in the job output")
+
+// screenJSONMu serializes appends so concurrent vUsers sharing a capture
+// file never interleave their lines.
+var screenJSONMu sync.Mutex
+
+// writeScreenJSON appends a structured screen capture. With a File set it
+// writes one JSON object per line there; otherwise it goes to the workflow
+// output, HTML-wrapped in CLI mode like AsciiScreenGrab and raw in API mode.
+// API workflows cannot name a File, as it could be any path on the server.
+func writeScreenJSON(screen *connect3270.Screen, file, outputPath string) error {
+	if runAPI && file != "" {
+		return errScreenJSONFile
+	}
+	data, err := json.Marshal(screen)
+	if err != nil {
+		return err
+	}
+	path := file
+	content := string(data) + "\n"
+	if path == "" {
+		path = outputPath
+		if !runAPI {
+			content = fmt.Sprintf("<pre class=\"screen-json\">%s</pre>\n</body></html>", html.EscapeString(string(data)))
+		}
+	}
+	if path == "" {
+		return fmt.Errorf("JSONScreenGrab has nowhere to write - set File or OutputFilePath")
+	}
+
+	screenJSONMu.Lock()
+	defer screenJSONMu.Unlock()
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.WriteString(content); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// screenJSONFileIn reports whether a JSONScreenGrab of steps, including
+// those nested in If blocks, names a File.
+func screenJSONFileIn(steps []Step) bool {
+	for _, step := range steps {
+		if step.Type == "JSONScreenGrab" && step.File != "" || screenJSONFileIn(step.Steps) || screenJSONFileIn(step.Else) {
+			return true
+		}
+	}
+	return false
+}
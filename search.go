@@ -0,0 +1,260 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"math"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	findMaxVUsers  bool
+	searchStepFlag int
+	searchStepTime float64
+)
+
+func init() {
+	flag.BoolVar(&findMaxVUsers, "findMaxVUsers", false, "Search for the most vUsers the host sustains: add -searchStep vUsers every -searchStepTime seconds, up to -concurrent, until a step breaks -maxErrorRate or -maxP95")
+	flag.IntVar(&searchStepFlag, "searchStep", 10, "vUsers added at each step of -findMaxVUsers")
+	flag.Float64Var(&searchStepTime, "searchStepTime", 60, "Seconds each step of -findMaxVUsers runs before it is judged")
+}
+
+func searchMode() bool {
+	return findMaxVUsers
+}
+
+// validateSearch checks that a concurrency search has steps to take and an
+// SLA to judge them by.
+func validateSearch(config *Configuration) error {
+	if !searchMode() {
+		return nil
+	}
+	if searchStepFlag <= 0 || searchStepTime <= 0 {
+		return fmt.Errorf("-searchStep and -searchStepTime must be greater than zero")
+	}
+	if t := effectiveThresholds(config); t == nil || (t.MaxErrorRate == nil && t.MaxP95Duration == 0) {
+		return fmt.Errorf("-findMaxVUsers needs an SLA to search against: set -maxErrorRate or -maxP95, or Thresholds in the workflow file")
+	}
+	if arrivalRate(config) > 0 {
+		return fmt.Errorf("-findMaxVUsers raises the vUsers of a closed-model run and cannot be combined with an arrival rate")
+	}
+	if iterationMode() {
+		return fmt.Errorf("-findMaxVUsers runs for a time per step and cannot be combined with -iterations")
+	}
+	return nil
+}
+
+// searchStep is one concurrency level of a search and how it fared.
+type searchStep struct {
+	VUsers    int
+	Finished  int64
+	ErrorRate float64
+	P95       float64
+	// Breach says how the step broke the SLA; empty when it held.
+	Breach string
+}
+
+// concurrencySearch raises the vUsers of a run step by step until the
+// workflows of a step break the SLA. Each step is judged on the workflows
+// that finished during it, not on the run so far.
+type concurrencySearch struct {
+	sla      ThresholdConfig
+	step     int
+	max      int
+	stepTime time.Duration
+
+	vUsers   atomic.Int64
+	finished atomic.Bool
+	mu       sync.Mutex
+	steps    []searchStep
+}
+
+// currentSearch is the search of this run, for the run summary; nil when
+// the run does not search.
+var currentSearch *concurrencySearch
+
+// newConcurrencySearch returns the search of a run of up to maxVUsers, or
+// nil when -findMaxVUsers is not set.
+func newConcurrencySearch(config *Configuration, maxVUsers int) *concurrencySearch {
+	if !searchMode() {
+		return nil
+	}
+	s := &concurrencySearch{
+		sla:      *effectiveThresholds(config),
+		step:     searchStepFlag,
+		max:      maxVUsers,
+		stepTime: time.Duration(searchStepTime * float64(time.Second)),
+	}
+	// The count of completed workflows is the run's business, not a step's.
+	s.sla.MinCompleted = 0
+	s.vUsers.Store(int64(min(s.step, s.max)))
+	return s
+}
+
+// runtime returns the seconds a search without -runtime runs for: time for
+// every step, plus a ramp-up delay of slack since scheduling stops short of
+// the deadline.
+func (s *concurrencySearch) runtime(rampUpDelay float64) int {
+	levels := (s.max + s.step - 1) / s.step
+	return int(math.Ceil(float64(levels)*s.stepTime.Seconds()+math.Max(rampUpDelay, 1))) + 1
+}
+
+// limit returns how many of the run's vUsers may be busy now: all of them
+// without a search.
+func (s *concurrencySearch) limit(vUsers int) int {
+	if s == nil {
+		return vUsers
+	}
+	return int(s.vUsers.Load())
+}
+
+// done reports whether the search has its answer, so no new workflows
+// should start.
+func (s *concurrencySearch) done() bool {
+	return s != nil && s.finished.Load()
+}
+
+// judgeSearchStep returns how the workflows that finished during a step
+// broke the SLA, or "" when they held it.
+func judgeSearchStep(sla *ThresholdConfig, completed, failed int64, p95 float64) string {
+	if completed+failed == 0 {
+		return "no workflow finished within the step"
+	}
+	var broken []string
+	for _, r := range evaluateThresholds(sla, completed, failed, p95) {
+		if !r.passed {
+			broken = append(broken, fmt.Sprintf("%s %s (limit %s)", r.name, r.actual, r.limit))
+		}
+	}
+	return strings.Join(broken, "; ")
+}
+
+// run judges a step every -searchStepTime and raises the vUsers after each
+// one that held the SLA, until a step breaks it, the last step is judged
+// or stop is closed.
+func (s *concurrencySearch) run(stop <-chan struct{}) {
+	baseCompleted := atomic.LoadInt64(&totalWorkflowsCompleted)
+	baseFailed := atomic.LoadInt64(&totalWorkflowsFailed)
+	baseBuckets, baseCount := durationBucketTotals()
+	ticker := time.NewTicker(s.stepTime)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+		completed := atomic.LoadInt64(&totalWorkflowsCompleted)
+		failed := atomic.LoadInt64(&totalWorkflowsFailed)
+		buckets, count := durationBucketTotals()
+		stepBuckets := buckets
+		for i := range stepBuckets {
+			stepBuckets[i] -= baseBuckets[i]
+		}
+		p95 := bucketPercentile(&stepBuckets, count-baseCount, 95)
+		vUsers := int(s.vUsers.Load())
+		step := searchStep{
+			VUsers:   vUsers,
+			Finished: completed - baseCompleted + failed - baseFailed,
+			P95:      p95,
+			Breach:   judgeSearchStep(&s.sla, completed-baseCompleted, failed-baseFailed, p95),
+		}
+		if step.Finished > 0 {
+			step.ErrorRate = float64(failed-baseFailed) / float64(step.Finished) * 100
+		}
+		s.mu.Lock()
+		s.steps = append(s.steps, step)
+		s.mu.Unlock()
+
+		if step.Breach != "" || vUsers >= s.max {
+			s.finished.Store(true)
+			msg := fmt.Sprintf("Concurrency search finished at %d vUsers.", vUsers)
+			infoIfBarsDisabled(msg)
+			storeLog(msg)
+			return
+		}
+		next := min(vUsers+s.step, s.max)
+		s.vUsers.Store(int64(next))
+		msg := fmt.Sprintf("Concurrency search: %d vUsers held the SLA, raising to %d.", vUsers, next)
+		infoIfBarsDisabled(msg)
+		storeLog(msg)
+		baseCompleted, baseFailed = completed, failed
+		baseBuckets, baseCount = buckets, count
+	}
+}
+
+// verdict returns the most vUsers a step held the SLA with, 0 when even the
+// first step broke it, and whether a step broke it at all.
+func (s *concurrencySearch) verdict() (int, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sustained := 0
+	for _, step := range s.steps {
+		if step.Breach != "" {
+			return sustained, true
+		}
+		sustained = step.VUsers
+	}
+	return sustained, false
+}
+
+// verdictText sums up the search in a line.
+func (s *concurrencySearch) verdictText() string {
+	sustained, breached := s.verdict()
+	switch {
+	case breached && sustained == 0:
+		return fmt.Sprintf("even %d vUsers break the SLA", min(s.step, s.max))
+	case breached:
+		return fmt.Sprintf("%d vUsers", sustained)
+	case sustained >= s.max:
+		return fmt.Sprintf("at least %d vUsers (no step broke the SLA)", sustained)
+	}
+	return fmt.Sprintf("at least %d vUsers (the search stopped before a step broke the SLA)", sustained)
+}
+
+// printSearchSummary lists the steps of a concurrency search under the run
+// summary and gives the answer.
+func printSearchSummary() {
+	s := currentSearch
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	rows := TableData{{"vUsers", "Workflows", "Error Rate", "P95 Workflow Time", "Result"}}
+	for _, step := range s.steps {
+		result := "PASS"
+		if step.Breach != "" {
+			result = "FAIL: " + step.Breach
+		}
+		rows = append(rows, []string{
+			fmt.Sprintf("%d", step.VUsers),
+			fmt.Sprintf("%d", step.Finished),
+			fmt.Sprintf("%.2f%%", step.ErrorRate),
+			fmt.Sprintf("%.2fs", step.P95),
+			result,
+		})
+	}
+	s.mu.Unlock()
+	pterm.Println()
+	pterm.DefaultSection.WithStyle(pterm.NewStyle(pterm.FgCyan)).Println("Concurrency Search - The Verdict")
+	pterm.DefaultTable.WithHasHeader().WithLeftAlignment().WithData(rows).Render()
+
+	sustained, breached := s.verdict()
+	msg := "Maximum sustainable concurrency: " + s.verdictText()
+	storeLog(msg)
+	switch {
+	case breached && sustained == 0:
+		pterm.Error.Println(msg)
+	case breached || sustained >= s.max:
+		pterm.Success.Println(msg)
+		if !breached {
+			pterm.Info.Println("Raise -concurrent to search further.")
+		}
+	default:
+		pterm.Warning.Println(msg)
+		pterm.Info.Println("Raise -runtime or lower -searchStepTime to finish the search.")
+	}
+}
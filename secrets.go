@@ -0,0 +1,287 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// secretPattern matches {{provider:argument}} placeholders. Only providers
+// listed in secretProviders are replaced; others such as {{var:name}} or
+// {{randInt:1:9}} are left for their own resolvers.
+var secretPattern = regexp.MustCompile(`\{\{([A-Za-z]+):([^}]+)\}\}`)
+
+var (
+	submittedWorkflow bool
+	submittedSecrets  string
+)
+
+func init() {
+	flag.BoolVar(&submittedWorkflow, "submittedWorkflow", false, "Run -config as a workflow submitted through the dashboard, which may only use the secrets -submittedSecrets allows")
+	flag.StringVar(&submittedSecrets, "submittedSecrets", "", "Comma-separated secret placeholders that workflows submitted through the API, gRPC or the dashboard may use, as provider:prefix (e.g. env:TN3270_,file:/run/secrets/); none by default")
+}
+
+// secretProviders resolve credentials kept outside the workflow file.
+var secretProviders = map[string]func(string) (string, error){
+	"env":  lookupEnvSecret,
+	"file": readFileSecret,
+}
+
+func lookupEnvSecret(name string) (string, error) {
+	value, ok := os.LookupEnv(name)
+	if !ok {
+		return "", fmt.Errorf("environment variable %s is not set for {{env:%s}}", name, name)
+	}
+	return value, nil
+}
+
+// readFileSecret returns the file contents without the trailing newline most
+// editors and secret mounts add.
+func readFileSecret(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("reading {{file:%s}}: %w", path, err)
+	}
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
+
+// resolveSecretPlaceholders substitutes {{env:NAME}} and {{file:path}} in text.
+func resolveSecretPlaceholders(text string) (string, error) {
+	return resolveSecretsFor(text, false)
+}
+
+// resolveSecretsFor is resolveSecretPlaceholders, but for a submitted
+// workflow, one sent through the API, gRPC or the dashboard, only the
+// placeholders -submittedSecrets allows are resolved. Any other is an error,
+// so senders cannot read the files and environment of the server.
+func resolveSecretsFor(text string, submitted bool) (string, error) {
+	if !strings.Contains(text, "{{") {
+		return text, nil
+	}
+	var resolveErr error
+	resolved := secretPattern.ReplaceAllStringFunc(text, func(match string) string {
+		m := secretPattern.FindStringSubmatch(match)
+		provider, ok := secretProviders[m[1]]
+		if !ok {
+			return match
+		}
+		if submitted && !submittedSecretAllowed(m[1], m[2]) {
+			if resolveErr == nil {
+				resolveErr = fmt.Errorf("{{%s:%s}} is not allowed in submitted workflows - see -submittedSecrets", m[1], m[2])
+			}
+			return match
+		}
+		value, err := provider(m[2])
+		if err != nil {
+			if resolveErr == nil {
+				resolveErr = err
+			}
+			return match
+		}
+		return value
+	})
+	if resolveErr != nil {
+		return "", resolveErr
+	}
+	return resolved, nil
+}
+
+// submittedSecretAllowed reports whether -submittedSecrets lets submitted
+// workflows use the placeholder {{provider:argument}}: an entry of the same
+// provider must be a prefix of argument. File paths are cleaned first, and
+// Vault paths that could resolve elsewhere are refused, so ".." cannot climb
+// out of an allowed directory.
+func submittedSecretAllowed(provider, argument string) bool {
+	switch provider {
+	case "file":
+		argument = filepath.Clean(argument)
+	case "vault":
+		path, _, _ := strings.Cut(argument, "#")
+		if checkVaultPath(path) != nil {
+			return false
+		}
+	}
+	for _, entry := range strings.Split(submittedSecrets, ",") {
+		name, prefix, ok := strings.Cut(strings.TrimSpace(entry), ":")
+		if ok && name == provider && strings.HasPrefix(argument, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// hasSecretPlaceholder reports whether text holds a placeholder of one of
+// the secretProviders.
+func hasSecretPlaceholder(text string) bool {
+	for _, m := range secretPattern.FindAllStringSubmatch(text, -1) {
+		if _, ok := secretProviders[m[1]]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// defaultTLSPort is the conventional port of secure TN3270.
+const defaultTLSPort = 992
+
+// UnmarshalJSON lets Port be given as a number or as a string such as
+// "{{env:TN3270_PORT}}". Placeholders are left for resolveSecrets, so a
+// workflow can be checked without its secrets. With TLS set, Port defaults
+// to 992.
+func (c *Configuration) UnmarshalJSON(data []byte) error {
+	type plainConfiguration Configuration
+	aux := struct {
+		*plainConfiguration
+		Port json.RawMessage
+	}{plainConfiguration: (*plainConfiguration)(c)}
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+	if err := decodePort(aux.Port, &c.Port, &c.portText); err != nil {
+		return err
+	}
+	if c.TLS && c.Port == 0 && c.portText == "" {
+		c.Port = defaultTLSPort
+	}
+	return nil
+}
+
+// MarshalJSON writes a Port still to be resolved as its placeholder.
+func (c Configuration) MarshalJSON() ([]byte, error) {
+	type plainConfiguration Configuration
+	if c.portText == "" {
+		return json.Marshal(plainConfiguration(c))
+	}
+	return json.Marshal(struct {
+		plainConfiguration
+		Port string
+	}{plainConfiguration(c), c.portText})
+}
+
+// decodePort accepts a JSON number or a string for a port. A string with
+// secret placeholders is kept in text until resolveSecrets. An absent value
+// keeps both.
+func decodePort(raw json.RawMessage, port *int, text *string) error {
+	if len(raw) == 0 || string(raw) == "null" {
+		return nil
+	}
+	var portText string
+	if err := json.Unmarshal(raw, &portText); err != nil {
+		var number int
+		if err := json.Unmarshal(raw, &number); err != nil {
+			return fmt.Errorf("Port must be a number or a placeholder string: %w", err)
+		}
+		*port, *text = number, ""
+		return nil
+	}
+	if hasSecretPlaceholder(portText) {
+		*port, *text = 0, portText
+		return nil
+	}
+	number, err := strconv.Atoi(strings.TrimSpace(portText))
+	if err != nil {
+		return fmt.Errorf("Port %q is not a number", portText)
+	}
+	*port, *text = number, ""
+	return nil
+}
+
+// resolveSecrets substitutes the secret placeholders of the connection
+// settings once the workflow is valid, and repeats the checks that need
+// the real values. Placeholders in steps are resolved as each step runs;
+// here they are only checked, so a missing secret fails the run up front.
+func (c *Configuration) resolveSecrets() error {
+	resolve := func(text string) (string, error) {
+		return resolveSecretsFor(text, c.submitted)
+	}
+	var err error
+	if c.Host, err = resolve(c.Host); err != nil {
+		return fmt.Errorf("Host: %w", err)
+	}
+	for _, field := range []*string{&c.TLSCertFile, &c.TLSKeyFile, &c.TLSKeyPassword, &c.TLSCAFile} {
+		if *field, err = resolve(*field); err != nil {
+			return fmt.Errorf("TLS settings: %w", err)
+		}
+	}
+	if c.Proxy, err = resolve(c.Proxy); err != nil {
+		return fmt.Errorf("Proxy: %w", err)
+	}
+	if c.SSHTunnel != nil {
+		tunnel := *c.SSHTunnel
+		if err := tunnel.resolvePlaceholders(resolve); err != nil {
+			return fmt.Errorf("SSHTunnel: %w", err)
+		}
+		c.SSHTunnel = &tunnel
+	}
+	if c.portText != "" {
+		text, err := resolve(c.portText)
+		if err != nil {
+			return fmt.Errorf("Port: %w", err)
+		}
+		port, err := strconv.Atoi(strings.TrimSpace(text))
+		if err != nil || port <= 0 {
+			return fmt.Errorf("Port from %s is not a port number", c.portText)
+		}
+		c.Port, c.portText = port, ""
+	}
+	for _, steps := range c.stepLists() {
+		if err := checkStepSecrets(steps, resolve); err != nil {
+			return err
+		}
+	}
+	if p := c.Printer; p != nil {
+		for _, text := range []string{p.LUName, p.OutputFile} {
+			if _, err := resolve(text); err != nil {
+				return fmt.Errorf("Printer: %w", err)
+			}
+		}
+	}
+	if err := validateTLSSettings(c); err != nil {
+		return err
+	}
+	if err := validateProxySettings(c); err != nil {
+		return err
+	}
+	return validateSSHTunnelSettings(c)
+}
+
+// stepLists gives every list of steps of c, suite workflows included.
+func (c *Configuration) stepLists() [][]Step {
+	lists := [][]Step{c.Steps, c.OnError, c.SessionSetup, c.SessionTeardown}
+	for _, w := range c.Workflows {
+		lists = append(lists, w.Steps, w.OnError)
+	}
+	return lists
+}
+
+// checkStepSecrets checks that the secret placeholders of steps, including
+// those nested in If blocks, resolve. This also reads every Vault secret the
+// steps use before the run starts.
+func checkStepSecrets(steps []Step, resolve func(string) (string, error)) error {
+	for _, step := range steps {
+		texts := []string{step.Text, step.Near}
+		if cond := step.Condition; cond != nil {
+			texts = append(texts, cond.ScreenContains)
+			if cond.ValueEquals != nil {
+				texts = append(texts, cond.ValueEquals.Text)
+			}
+		}
+		for _, text := range texts {
+			if _, err := resolve(text); err != nil {
+				return fmt.Errorf("%s step: %w", step.Type, err)
+			}
+		}
+		if err := checkStepSecrets(step.Steps, resolve); err != nil {
+			return err
+		}
+		if err := checkStepSecrets(step.Else, resolve); err != nil {
+			return err
+		}
+	}
+	return nil
+}
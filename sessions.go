@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+
+	connect3270 "github.com/3270io/3270Connect/connect3270"
+)
+
+// SessionConfig holds the settings of a named extra session. Everything
+// not set here (host, TLS, proxy, tunnel) is shared with the main session.
+type SessionConfig struct {
+	LUName string `json:"LUName,omitempty"`
+}
+
+var sessionIDPattern = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+
+// validateSessionSettings checks the Sessions block and the Session of every
+// step. Steps on a named session need a Connect on that session first.
+func validateSessionSettings(config *Configuration) error {
+	for id, s := range config.Sessions {
+		if !sessionIDPattern.MatchString(id) {
+			return fmt.Errorf("session ID %q should be made of letters, digits, '_', '-' or '.'", id)
+		}
+		for _, name := range strings.Split(s.LUName, ",") {
+			if name = strings.TrimSpace(name); name != "" && !luNamePattern.MatchString(name) {
+				return fmt.Errorf("Sessions.%s.LUName %q is not a valid LU name", id, name)
+			}
+		}
+	}
+	connected := make(map[string]bool)
+	if err := validateStepSessions(config.Steps, connected); err != nil {
+		return err
+	}
+	for _, w := range config.Workflows {
+		if err := validateStepSessions(w.Steps, make(map[string]bool)); err != nil {
+			return fmt.Errorf("workflow %s: %w", w.Name, err)
+		}
+	}
+	// OnError may run after any step, so only the session IDs are checked.
+	return validateStepSessions(config.OnError, nil)
+}
+
+func validateStepSessions(steps []Step, connected map[string]bool) error {
+	for _, step := range steps {
+		if step.Session != "" {
+			if !sessionIDPattern.MatchString(step.Session) {
+				return fmt.Errorf("%s step: Session %q should be made of letters, digits, '_', '-' or '.'", step.Type, step.Session)
+			}
+			if step.Type == "If" || step.Type == "Include" {
+				return fmt.Errorf("Session is not allowed on %s steps - set it on the steps inside", step.Type)
+			}
+			if step.Type == "Connect" && connected != nil {
+				connected[step.Session] = true
+			} else if connected != nil && !connected[step.Session] {
+				return fmt.Errorf("%s step on session %s comes before that session's Connect step", step.Type, step.Session)
+			}
+		}
+		if err := validateStepSessions(step.Steps, connected); err != nil {
+			return err
+		}
+		if err := validateStepSessions(step.Else, connected); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// emulatorFor returns the emulator step runs on: main for steps without a
+// Session, otherwise the named session, which its Connect step opens.
+func (s *workflowState) emulatorFor(main *connect3270.Emulator, step Step) (*connect3270.Emulator, error) {
+	if step.Session == "" {
+		return main, nil
+	}
+	if e, ok := s.sessions[step.Session]; ok {
+		return e, nil
+	}
+	if step.Type != "Connect" {
+		return nil, fmt.Errorf("session %s is not connected - add a Connect step with Session %q first", step.Session, step.Session)
+	}
+	e := connect3270.NewEmulator(main.Host, main.Port, main.ScriptPort+"/"+step.Session)
+	e.TLS = main.TLS
+	e.TLSOptions = main.TLSOptions
+	e.CodePage = main.CodePage
+	e.Proxy = main.Proxy
+	if s.sessionConfigs != nil {
+		e.LUName = s.sessionConfigs[step.Session].LUName
+	}
+	e.SetContext(s.ctx)
+	if s.sessions == nil {
+		s.sessions = make(map[string]*connect3270.Emulator)
+	}
+	s.sessions[step.Session] = e
+	return e, nil
+}
+
+// setSessionContext calls SetContext on every named session.
+func (s *workflowState) setSessionContext(ctx context.Context) {
+	for _, e := range s.sessions {
+		e.SetContext(ctx)
+	}
+}
+
+// closeSessions disconnects the named sessions.
+func (s *workflowState) closeSessions() {
+	for id, e := range s.sessions {
+		e.SetContext(nil)
+		if err := e.Disconnect(); err != nil && connect3270.Verbose {
+			storeLog(fmt.Sprintf("Disconnecting session %s: %v", id, err))
+		}
+	}
+	s.sessions = nil
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	connect3270 "github.com/3270io/3270Connect/connect3270"
+)
+
+var (
+	gracePeriodFlag   float64
+	hardStopAfterFlag float64
+)
+
+const (
+	// sigtermDrain is how long a run stopped by SIGTERM drains before it
+	// stops hard. Docker kills the process 10 seconds after SIGTERM and
+	// Kubernetes 30 seconds after, and the summary has to be out by then.
+	sigtermDrain = 5 * time.Second
+	// interruptedExitCode is the exit status of a run stopped by a signal
+	// that nothing else failed.
+	interruptedExitCode = 130
+)
+
+func init() {
+	flag.Float64Var(&gracePeriodFlag, "gracePeriod", defaultGracePeriod.Seconds(), "Seconds to wait for workflows in flight once scheduling stops, before asking whether to keep waiting")
+	flag.Float64Var(&hardStopAfterFlag, "hardStopAfter", 0, "Stop workflows still in flight this many seconds after scheduling stops, without asking (0 = ask after each grace period)")
+}
+
+// drainGracePeriod returns how long the end of a run waits for workflows in
+// flight before it asks, or cuts them off.
+func drainGracePeriod() time.Duration {
+	if gracePeriodFlag < 0 {
+		return 0
+	}
+	return time.Duration(gracePeriodFlag * float64(time.Second))
+}
+
+// drainAllowance returns how long the end of a run takes at most when nobody
+// answers the grace period prompt: the grace period, or -hardStopAfter when
+// that is longer.
+func drainAllowance() time.Duration {
+	if hard := time.Duration(hardStopAfterFlag * float64(time.Second)); hard > drainGracePeriod() {
+		return hard
+	}
+	return drainGracePeriod()
+}
+
+func validateShutdownFlags() error {
+	if gracePeriodFlag < 0 {
+		return fmt.Errorf("-gracePeriod cannot be negative")
+	}
+	if hardStopAfterFlag < 0 {
+		return fmt.Errorf("-hardStopAfter cannot be negative")
+	}
+	return nil
+}
+
+// A run ends in two stages. Stopping scheduling lets the workflows in flight
+// finish, within the grace period; a hard stop cuts them off at once and
+// leaves a partial summary. The deadline, the iteration budget and the
+// error budget only stop scheduling; Ctrl+C stops scheduling and, pressed
+// again, stops hard.
+var (
+	// runStopReason says why scheduling was stopped early; nil while it
+	// has not been.
+	runStopReason atomic.Pointer[string]
+	// hardStopReason says why the workflows in flight were cut off; nil
+	// while they have not been.
+	hardStopReason atomic.Pointer[string]
+	hardStopMu     sync.Mutex
+	hardStopCh     = make(chan struct{})
+)
+
+// resetRunStop clears the stops of an earlier run.
+func resetRunStop() {
+	runStopReason.Store(nil)
+	hardStopMu.Lock()
+	defer hardStopMu.Unlock()
+	hardStopReason.Store(nil)
+	hardStopCh = make(chan struct{})
+}
+
+func stopRequested() bool {
+	return runStopReason.Load() != nil
+}
+
+func stopReasonText() string {
+	if reason := runStopReason.Load(); reason != nil {
+		return *reason
+	}
+	return ""
+}
+
+// stopScheduling stops the run from starting new workflows and lets the
+// ones in flight drain.
+func stopScheduling(reason string) {
+	if runStopReason.CompareAndSwap(nil, &reason) {
+		schedulerLog.Warn(fmt.Sprintf("Stopping the run: %s - no new workflows will start. Draining the run.", reason))
+	}
+}
+
+// hardStop cuts off every workflow in flight and ends the drain.
+func hardStop(reason string) {
+	hardStopMu.Lock()
+	defer hardStopMu.Unlock()
+	if !hardStopReason.CompareAndSwap(nil, &reason) {
+		return
+	}
+	schedulerLog.Error(fmt.Sprintf("Hard stop: %s. Stopping the workflows in flight.", reason))
+	connect3270.RequestShutdown()
+	close(hardStopCh)
+}
+
+// hardStopped is closed when the run stops hard.
+func hardStopped() <-chan struct{} {
+	hardStopMu.Lock()
+	defer hardStopMu.Unlock()
+	return hardStopCh
+}
+
+func stoppedHard() bool {
+	return hardStopReason.Load() != nil
+}
+
+func hardStopText() string {
+	if reason := hardStopReason.Load(); reason != nil {
+		return *reason
+	}
+	return ""
+}
+
+// handleStopSignals turns the first Ctrl+C of a run into a stop of
+// scheduling and the second into a hard stop; a third exits without a
+// summary. SIGTERM, sent by Docker and Kubernetes, also stops scheduling,
+// then stops hard after sigtermDrain, so the summary is written, the
+// metrics flushed and a worker's report posted before the process is
+// killed. The returned function restores the default handling.
+func handleStopSignals() func() {
+	signals := make(chan os.Signal, 3)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	done := make(chan struct{})
+	go func() {
+		received := 0
+		for {
+			var sig os.Signal
+			select {
+			case <-done:
+				return
+			case sig = <-signals:
+			}
+			received++
+			switch {
+			case received == 1 && sig == syscall.SIGTERM:
+				stopScheduling("Terminated (SIGTERM)")
+				drain := sigtermDrain
+				if after := time.Duration(hardStopAfterFlag * float64(time.Second)); after > 0 && after < drain {
+					drain = after
+				}
+				time.AfterFunc(drain, func() {
+					hardStop(fmt.Sprintf("SIGTERM drain of %s elapsed", formatSeconds(drain.Seconds())))
+				})
+			case received == 1:
+				stopScheduling("Interrupted (Ctrl+C)")
+				pterm.Info.Println("Press Ctrl+C again to stop the workflows in flight now.")
+			case received == 2:
+				hardStop("stop signal received again")
+			default:
+				flushLogs()
+				os.Exit(interruptedExitCode)
+			}
+		}
+	}()
+	return func() {
+		signal.Stop(signals)
+		close(done)
+	}
+}
+
+// armHardStop cuts off the workflows still in flight -hardStopAfter after
+// scheduling stopped. The returned function disarms it.
+func armHardStop() func() {
+	if hardStopAfterFlag <= 0 {
+		return func() {}
+	}
+	after := time.Duration(hardStopAfterFlag * float64(time.Second))
+	timer := time.AfterFunc(after, func() {
+		hardStop(fmt.Sprintf("-hardStopAfter of %s elapsed", formatSeconds(after.Seconds())))
+	})
+	return func() { timer.Stop() }
+}
+
+// printStopSummary notes under the run summary that the run was stopped
+// early, and that its numbers are partial when it stopped hard.
+func printStopSummary() {
+	if reason := stopReasonText(); reason != "" {
+		pterm.Warning.Printf("Run stopped early: %s\n", reason)
+	}
+	if reason := hardStopText(); reason != "" {
+		pterm.Warning.Printf("Hard stop: %s - workflows in flight were cut off, so this summary is partial.\n", reason)
+	}
+}
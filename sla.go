@@ -0,0 +1,237 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"math"
+	"strings"
+	"sync/atomic"
+)
+
+const (
+	// Workflow durations are bucketed on a 2% logarithmic scale from 1ms
+	// up to about two hours, so percentiles are exact to within 2%.
+	durationBucketGrowth = 1.02
+	durationBucketCount  = 800
+
+	// thresholdExitCode is the exit status of a run that broke a threshold.
+	thresholdExitCode = 3
+)
+
+var (
+	maxErrorRateFlag float64
+	maxP95Flag       float64
+	minCompletedFlag int64
+)
+
+func init() {
+	flag.Float64Var(&maxErrorRateFlag, "maxErrorRate", -1, "Fail the run (exit code 3) when more than this percentage of workflows fail")
+	flag.Float64Var(&maxP95Flag, "maxP95", 0, "Fail the run (exit code 3) when the 95th percentile workflow time exceeds this many seconds")
+	flag.Int64Var(&minCompletedFlag, "minCompleted", 0, "Fail the run (exit code 3) when fewer workflows than this complete")
+}
+
+// ThresholdConfig sets the limits a run must stay within. A run that breaks
+// one ends with exit code 3, so a CI pipeline can fail the build on it.
+type ThresholdConfig struct {
+	// MaxErrorRate is the highest share of failed workflows, in percent of
+	// the completed and failed ones. 0 allows no failures at all.
+	MaxErrorRate *float64 `json:"MaxErrorRate,omitempty"`
+	// MaxP95Duration is the highest 95th percentile workflow time in seconds.
+	MaxP95Duration float64 `json:"MaxP95Duration,omitempty"`
+	// MinCompleted is the fewest workflows that must complete.
+	MinCompleted int64 `json:"MinCompleted,omitempty"`
+}
+
+func (t *ThresholdConfig) empty() bool {
+	return t == nil || (t.MaxErrorRate == nil && t.MaxP95Duration == 0 && t.MinCompleted == 0)
+}
+
+// effectiveThresholds returns the Thresholds of config with the
+// -maxErrorRate, -maxP95 and -minCompleted flags applied over them.
+func effectiveThresholds(config *Configuration) *ThresholdConfig {
+	var t ThresholdConfig
+	if config.Thresholds != nil {
+		t = *config.Thresholds
+	}
+	if maxErrorRateFlag >= 0 {
+		rate := maxErrorRateFlag
+		t.MaxErrorRate = &rate
+	}
+	if maxP95Flag > 0 {
+		t.MaxP95Duration = maxP95Flag
+	}
+	if minCompletedFlag > 0 {
+		t.MinCompleted = minCompletedFlag
+	}
+	if t.empty() {
+		return nil
+	}
+	return &t
+}
+
+func validateThresholds(config *Configuration) error {
+	t := config.Thresholds
+	if t == nil {
+		return nil
+	}
+	if t.MaxErrorRate != nil && (*t.MaxErrorRate < 0 || *t.MaxErrorRate > 100) {
+		return fmt.Errorf("Thresholds.MaxErrorRate must be a percentage between 0 and 100")
+	}
+	if t.MaxP95Duration < 0 {
+		return fmt.Errorf("Thresholds.MaxP95Duration cannot be negative")
+	}
+	if t.MinCompleted < 0 {
+		return fmt.Errorf("Thresholds.MinCompleted cannot be negative")
+	}
+	return nil
+}
+
+// durationBucket returns the histogram bucket of a duration in seconds.
+func durationBucket(seconds float64) int {
+	ms := seconds * 1000
+	if ms <= 1 {
+		return 0
+	}
+	bucket := int(math.Ceil(math.Log(ms) / math.Log(durationBucketGrowth)))
+	return min(bucket, durationBucketCount-1)
+}
+
+// durationPercentile returns the workflow time, in seconds, that p percent
+// of workflows finished within: the upper bound of its bucket.
+func durationPercentile(p float64) float64 {
+	buckets, count := durationBucketTotals()
+	return bucketPercentile(&buckets, count, p)
+}
+
+func bucketPercentile(buckets *[durationBucketCount]int64, count int64, p float64) float64 {
+	if count == 0 {
+		return 0
+	}
+	rank := int64(math.Ceil(p / 100 * float64(count)))
+	var seen int64
+	for bucket, n := range buckets {
+		if seen += n; seen >= rank {
+			return math.Pow(durationBucketGrowth, float64(bucket)) / 1000
+		}
+	}
+	return math.Pow(durationBucketGrowth, durationBucketCount-1) / 1000
+}
+
+func durationBucketTotals() ([durationBucketCount]int64, int64) {
+	var buckets [durationBucketCount]int64
+	var count int64
+	for i := range durationShards {
+		shard := &durationShards[i]
+		shard.mu.Lock()
+		for bucket, n := range shard.buckets {
+			buckets[bucket] += n
+			count += n
+		}
+		shard.mu.Unlock()
+	}
+	return buckets, count
+}
+
+// durationHistogram returns the non-empty buckets, for checkpoints and
+// distributed run reports.
+func durationHistogram() map[int]int64 {
+	buckets, _ := durationBucketTotals()
+	histogram := make(map[int]int64)
+	for bucket, n := range buckets {
+		if n > 0 {
+			histogram[bucket] = n
+		}
+	}
+	return histogram
+}
+
+// seedDurationHistogram adds the buckets of a checkpoint or of worker
+// reports to this run's.
+func seedDurationHistogram(histogram map[int]int64) {
+	shard := &durationShards[0]
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+	for bucket, n := range histogram {
+		if bucket >= 0 && bucket < durationBucketCount {
+			shard.buckets[bucket] += n
+		}
+	}
+}
+
+// thresholdResult is the verdict on one threshold.
+type thresholdResult struct {
+	name   string
+	limit  string
+	actual string
+	passed bool
+}
+
+// evaluateThresholds checks the run's totals against t.
+func evaluateThresholds(t *ThresholdConfig, completed, failed int64, p95 float64) []thresholdResult {
+	var results []thresholdResult
+	if t.MaxErrorRate != nil {
+		rate := 0.0
+		if finished := completed + failed; finished > 0 {
+			rate = float64(failed) / float64(finished) * 100
+		}
+		results = append(results, thresholdResult{
+			name:   "Error Rate",
+			limit:  fmt.Sprintf("<= %g%%", *t.MaxErrorRate),
+			actual: fmt.Sprintf("%.2f%%", rate),
+			passed: rate <= *t.MaxErrorRate,
+		})
+	}
+	if t.MaxP95Duration > 0 {
+		results = append(results, thresholdResult{
+			name:   "P95 Workflow Time",
+			limit:  fmt.Sprintf("<= %gs", t.MaxP95Duration),
+			actual: fmt.Sprintf("%.2fs", p95),
+			passed: p95 <= t.MaxP95Duration,
+		})
+	}
+	if t.MinCompleted > 0 {
+		results = append(results, thresholdResult{
+			name:   "Completed Workflows",
+			limit:  fmt.Sprintf(">= %d", t.MinCompleted),
+			actual: fmt.Sprintf("%d", completed),
+			passed: completed >= t.MinCompleted,
+		})
+	}
+	return results
+}
+
+// checkThresholds judges the finished run against the configured
+// thresholds, prints the verdict and returns the exit code it calls for.
+func checkThresholds(config *Configuration) int {
+	t := effectiveThresholds(config)
+	if t == nil {
+		return 0
+	}
+	results := evaluateThresholds(t,
+		atomic.LoadInt64(&totalWorkflowsCompleted),
+		atomic.LoadInt64(&totalWorkflowsFailed),
+		durationPercentile(95))
+	rows := TableData{{"Threshold", "Limit", "Actual", "Result"}}
+	var broken []string
+	var notice []noticeRow
+	for _, r := range results {
+		verdict := "PASS"
+		if !r.passed {
+			verdict = "FAIL"
+			broken = append(broken, fmt.Sprintf("%s %s (limit %s)", r.name, r.actual, r.limit))
+		}
+		rows = append(rows, []string{r.name, r.limit, r.actual, verdict})
+		notice = append(notice, noticeRow{r.name, fmt.Sprintf("%s (limit %s) %s", r.actual, r.limit, verdict)})
+	}
+	pterm.Println()
+	pterm.DefaultSection.WithStyle(pterm.NewStyle(pterm.FgCyan)).Println("SLA Thresholds - The Verdict")
+	pterm.DefaultTable.WithHasHeader().WithLeftAlignment().WithData(rows).Render()
+	if len(broken) == 0 {
+		logSuccess(reportLog, "All SLA thresholds met.")
+		return 0
+	}
+	msg := "SLA thresholds broken: " + strings.Join(broken, "; ")
+	reportLog.Error(msg)
+	notifyBreach("SLA thresholds broken", notice, broken)
+	return thresholdExitCode
+}
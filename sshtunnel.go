@@ -0,0 +1,271 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+const (
+	defaultSSHPort    = 22
+	sshConnectTimeout = 15 * time.Second
+)
+
+// SSHTunnelConfig describes the SSH jump host the TN3270 connection is
+// forwarded through. Keys are tried first, then the password, then any
+// running ssh-agent.
+type SSHTunnelConfig struct {
+	Host                  string
+	Port                  int `json:"Port,omitempty"`
+	User                  string
+	KeyFile               string `json:"KeyFile,omitempty"`
+	KeyPassphrase         string `json:"KeyPassphrase,omitempty"`
+	Password              string `json:"Password,omitempty"`
+	KnownHostsFile        string `json:"KnownHostsFile,omitempty"`
+	InsecureIgnoreHostKey bool   `json:"InsecureIgnoreHostKey,omitempty"`
+}
+
+func (c *SSHTunnelConfig) address() string {
+	port := c.Port
+	if port == 0 {
+		port = defaultSSHPort
+	}
+	return net.JoinHostPort(c.Host, strconv.Itoa(port))
+}
+
+// resolvePlaceholders resolves secret placeholders in the string settings
+// with resolve.
+func (c *SSHTunnelConfig) resolvePlaceholders(resolve func(string) (string, error)) error {
+	for _, field := range []*string{&c.Host, &c.User, &c.KeyFile, &c.KeyPassphrase, &c.Password, &c.KnownHostsFile} {
+		var err error
+		if *field, err = resolve(*field); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validateSSHTunnelSettings checks the SSHTunnel block of config.
+func validateSSHTunnelSettings(config *Configuration) error {
+	t := config.SSHTunnel
+	if t == nil {
+		return nil
+	}
+	if t.Host == "" {
+		return fmt.Errorf("SSHTunnel.Host is empty - which jump host?")
+	}
+	if t.User == "" {
+		return fmt.Errorf("SSHTunnel.User is empty")
+	}
+	if t.Port < 0 || t.Port > 65535 {
+		return fmt.Errorf("SSHTunnel.Port %d is invalid", t.Port)
+	}
+	if t.KeyPassphrase != "" && t.KeyFile == "" {
+		return fmt.Errorf("SSHTunnel.KeyPassphrase needs a KeyFile to go with it")
+	}
+	if config.Proxy != "" {
+		return fmt.Errorf("Proxy and SSHTunnel cannot both be set")
+	}
+	for _, f := range []struct{ name, path string }{
+		{"SSHTunnel.KeyFile", t.KeyFile},
+		{"SSHTunnel.KnownHostsFile", t.KnownHostsFile},
+	} {
+		if f.path == "" || hasSecretPlaceholder(f.path) {
+			continue
+		}
+		if _, err := os.Stat(f.path); err != nil {
+			return fmt.Errorf("%s: %w", f.name, err)
+		}
+	}
+	return nil
+}
+
+// sshTunnel is a local listener whose connections are forwarded to the
+// TN3270 host over one SSH connection. All vUsers of a run share it.
+type sshTunnel struct {
+	key      string
+	client   *ssh.Client
+	listener net.Listener
+	target   string
+	refs     int
+}
+
+var (
+	sshTunnelsMu sync.Mutex
+	sshTunnels   = make(map[string]*sshTunnel)
+)
+
+// acquireSSHTunnel returns the running tunnel for config, opening it on
+// first use. Each call must be paired with release.
+func acquireSSHTunnel(config *Configuration) (*sshTunnel, error) {
+	target := net.JoinHostPort(config.Host, strconv.Itoa(config.Port))
+	key := config.SSHTunnel.User + "@" + config.SSHTunnel.address() + "->" + target
+	sshTunnelsMu.Lock()
+	defer sshTunnelsMu.Unlock()
+	if t, ok := sshTunnels[key]; ok {
+		t.refs++
+		return t, nil
+	}
+	client, err := dialSSH(config.SSHTunnel)
+	if err != nil {
+		return nil, fmt.Errorf("SSH tunnel via %s: %w", config.SSHTunnel.address(), err)
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		client.Close()
+		return nil, fmt.Errorf("SSH tunnel listener: %w", err)
+	}
+	t := &sshTunnel{key: key, client: client, listener: listener, target: target, refs: 1}
+	sshTunnels[key] = t
+	storeLog(fmt.Sprintf("SSH tunnel open: 127.0.0.1:%d -> %s via %s", t.localPort(), target, config.SSHTunnel.address()))
+	go t.serve()
+	go func() {
+		// A dropped SSH connection takes the listener with it; the next
+		// workflow opens a fresh tunnel.
+		_ = client.Wait()
+		t.shutdown()
+	}()
+	return t, nil
+}
+
+func (t *sshTunnel) localPort() int {
+	return t.listener.Addr().(*net.TCPAddr).Port
+}
+
+// release drops one reference and closes the tunnel once nothing uses it.
+func (t *sshTunnel) release() {
+	sshTunnelsMu.Lock()
+	t.refs--
+	last := t.refs <= 0
+	if last {
+		t.unregisterLocked()
+	}
+	sshTunnelsMu.Unlock()
+	if last {
+		t.close()
+	}
+}
+
+func (t *sshTunnel) shutdown() {
+	sshTunnelsMu.Lock()
+	t.unregisterLocked()
+	sshTunnelsMu.Unlock()
+	t.close()
+}
+
+// unregisterLocked stops new workflows from picking up t. Callers hold
+// sshTunnelsMu.
+func (t *sshTunnel) unregisterLocked() {
+	if sshTunnels[t.key] == t {
+		delete(sshTunnels, t.key)
+		storeLog(fmt.Sprintf("SSH tunnel closed: 127.0.0.1:%d -> %s", t.localPort(), t.target))
+	}
+}
+
+func (t *sshTunnel) close() {
+	t.listener.Close()
+	t.client.Close()
+}
+
+func (t *sshTunnel) serve() {
+	for {
+		local, err := t.listener.Accept()
+		if err != nil {
+			return
+		}
+		go t.forward(local)
+	}
+}
+
+func (t *sshTunnel) forward(local net.Conn) {
+	defer local.Close()
+	remote, err := t.client.Dial("tcp", t.target)
+	if err != nil {
+		storeLog(fmt.Sprintf("SSH tunnel could not reach %s: %v", t.target, err))
+		return
+	}
+	defer remote.Close()
+	done := make(chan struct{}, 2)
+	go func() {
+		_, _ = io.Copy(remote, local)
+		done <- struct{}{}
+	}()
+	go func() {
+		_, _ = io.Copy(local, remote)
+		done <- struct{}{}
+	}()
+	<-done
+}
+
+func dialSSH(c *SSHTunnelConfig) (*ssh.Client, error) {
+	hostKeyCallback, err := sshHostKeyCallback(c)
+	if err != nil {
+		return nil, err
+	}
+	var auth []ssh.AuthMethod
+	if c.KeyFile != "" {
+		pem, err := os.ReadFile(c.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		var signer ssh.Signer
+		if c.KeyPassphrase != "" {
+			signer, err = ssh.ParsePrivateKeyWithPassphrase(pem, []byte(c.KeyPassphrase))
+		} else {
+			signer, err = ssh.ParsePrivateKey(pem)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("KeyFile %s: %w", c.KeyFile, err)
+		}
+		auth = append(auth, ssh.PublicKeys(signer))
+	}
+	if c.Password != "" {
+		auth = append(auth, ssh.Password(c.Password))
+	}
+	if sock := os.Getenv("SSH_AUTH_SOCK"); sock != "" {
+		if conn, err := net.Dial("unix", sock); err == nil {
+			defer conn.Close()
+			auth = append(auth, ssh.PublicKeysCallback(agent.NewClient(conn).Signers))
+		}
+	}
+	if len(auth) == 0 {
+		return nil, errors.New("no KeyFile, Password or ssh-agent to log in with")
+	}
+	return ssh.Dial("tcp", c.address(), &ssh.ClientConfig{
+		User:            c.User,
+		Auth:            auth,
+		HostKeyCallback: hostKeyCallback,
+		Timeout:         sshConnectTimeout,
+	})
+}
+
+// sshHostKeyCallback checks the jump host against KnownHostsFile, or
+// ~/.ssh/known_hosts when none is given.
+func sshHostKeyCallback(c *SSHTunnelConfig) (ssh.HostKeyCallback, error) {
+	if c.InsecureIgnoreHostKey {
+		return ssh.InsecureIgnoreHostKey(), nil
+	}
+	file := c.KnownHostsFile
+	if file == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, err
+		}
+		file = filepath.Join(home, ".ssh", "known_hosts")
+	}
+	callback, err := knownhosts.New(file)
+	if err != nil {
+		return nil, fmt.Errorf("known hosts: %w (set KnownHostsFile or InsecureIgnoreHostKey)", err)
+	}
+	return callback, nil
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// SuiteWorkflow is one entry of a weighted workflow suite. Concurrent runs
+// pick an entry for every iteration in proportion to its Weight, so one run
+// can replay a realistic transaction mix.
+type SuiteWorkflow struct {
+	Name    string
+	Weight  float64 `json:"Weight,omitempty"`
+	File    string  `json:"File,omitempty"`
+	Steps   []Step  `json:"Steps,omitempty"`
+	OnError []Step  `json:"OnError,omitempty"`
+}
+
+// weight returns the relative weight of the entry; an omitted Weight counts as 1.
+func (w SuiteWorkflow) weight() float64 {
+	if w.Weight == 0 {
+		return 1
+	}
+	return w.Weight
+}
+
+// expandSuiteWorkflows loads File entries and inlines Include steps so the
+// suite is self-contained once loaded. Relative paths resolve against baseDir.
+func expandSuiteWorkflows(workflows []SuiteWorkflow, baseDir string) ([]SuiteWorkflow, error) {
+	if workflows == nil {
+		return nil, nil
+	}
+	out := make([]SuiteWorkflow, len(workflows))
+	for i, w := range workflows {
+		if strings.TrimSpace(w.File) != "" {
+			if len(w.Steps) > 0 {
+				return nil, fmt.Errorf("workflow %q sets both File and Steps - pick one", w.Name)
+			}
+			w.Steps = []Step{{Type: "Include", File: w.File}}
+			w.File = ""
+		}
+		var err error
+		if w.Steps, err = expandIncludes(w.Steps, baseDir); err != nil {
+			return nil, fmt.Errorf("workflow %q: %w", w.Name, err)
+		}
+		if w.OnError, err = expandIncludes(w.OnError, baseDir); err != nil {
+			return nil, fmt.Errorf("workflow %q OnError: %w", w.Name, err)
+		}
+		out[i] = w
+	}
+	return out, nil
+}
+
+// validateSuite checks the Workflows of a suite configuration. Each workflow
+// is validated on its own since only the variables of SessionSetup are
+// certain to carry over between them.
+func validateSuite(config *Configuration) error {
+	if len(config.Workflows) == 0 {
+		return nil
+	}
+	if len(config.Steps) > 0 {
+		return fmt.Errorf("Steps and Workflows cannot both be set - move the steps into a workflow")
+	}
+	seen := make(map[string]bool)
+	for i, w := range config.Workflows {
+		if err := validateSuiteEntry(w, seen); err != nil {
+			return fmt.Errorf("Workflows[%d]: %w", i, err)
+		}
+		if config.OutputFilePath == "" && (stepsNeedOutputFile(w.Steps) || stepsNeedOutputFile(w.OnError)) {
+			return fmt.Errorf("output file path is empty - screen grab in workflow %q needs a home", w.Name)
+		}
+		definedVars := setupVars(config)
+		if err := validateSteps(w.Steps, definedVars); err != nil {
+			return fmt.Errorf("workflow %q: %w", w.Name, err)
+		}
+		if err := validateSteps(w.OnError, definedVars); err != nil {
+			return fmt.Errorf("workflow %q OnError: %w", w.Name, err)
+		}
+		if err := validateTransactions(w.Steps); err != nil {
+			return fmt.Errorf("workflow %q: %w", w.Name, err)
+		}
+		if stepsUseTransaction(w.OnError) {
+			return fmt.Errorf("workflow %q: Transaction is not allowed on OnError steps", w.Name)
+		}
+	}
+	return nil
+}
+
+// validateSuiteEntry checks the name, weight and step list of one workflow.
+func validateSuiteEntry(w SuiteWorkflow, seen map[string]bool) error {
+	name := strings.TrimSpace(w.Name)
+	if name == "" {
+		return fmt.Errorf("workflow needs a Name")
+	}
+	if seen[name] {
+		return fmt.Errorf("duplicate workflow name %q", name)
+	}
+	seen[name] = true
+	if w.Weight < 0 {
+		return fmt.Errorf("workflow %q has a negative Weight", name)
+	}
+	if len(w.Steps) == 0 {
+		return fmt.Errorf("workflow %q has no Steps", name)
+	}
+	return nil
+}
+
+// suiteWorkflowConfig returns a copy of config that runs a single workflow of
+// the suite. Workflows without their own OnError use the top-level one.
+func suiteWorkflowConfig(config *Configuration, w SuiteWorkflow) *Configuration {
+	picked := *config
+	picked.Workflows = nil
+	picked.Steps = w.Steps
+	picked.workflowName = w.Name
+	if len(w.OnError) > 0 {
+		picked.OnError = w.OnError
+	}
+	return &picked
+}
+
+// pickSuiteWorkflow maps r in [0, 1) onto the workflows by weight.
+func pickSuiteWorkflow(workflows []SuiteWorkflow, r float64) int {
+	total := 0.0
+	for _, w := range workflows {
+		total += w.weight()
+	}
+	target := r * total
+	for i, w := range workflows {
+		if target < w.weight() {
+			return i
+		}
+		target -= w.weight()
+	}
+	return len(workflows) - 1
+}
+
+// suiteMix counts how often each workflow of a suite was scheduled.
+type suiteMix struct {
+	mu     sync.Mutex
+	counts map[string]int64
+	// walkRows, when set, walks the suite in file order, moving on to the
+	// next workflow after this many picks: one per injection row.
+	walkRows int
+	picks    int
+}
+
+func newSuiteMix() *suiteMix {
+	return &suiteMix{counts: make(map[string]int64)}
+}
+
+// next picks the workflow for the next iteration and returns a config that
+// runs it, or config itself when it is not a suite.
+func (m *suiteMix) next(config *Configuration) (*Configuration, string) {
+	if len(config.Workflows) == 0 {
+		return config, ""
+	}
+	if m.walkRows > 0 {
+		m.mu.Lock()
+		w := config.Workflows[m.picks/m.walkRows%len(config.Workflows)]
+		m.picks++
+		m.mu.Unlock()
+		return suiteWorkflowConfig(config, w), w.Name
+	}
+	delayRNGMu.Lock()
+	r := delayRNG.Float64()
+	delayRNGMu.Unlock()
+	w := config.Workflows[pickSuiteWorkflow(config.Workflows, r)]
+	return suiteWorkflowConfig(config, w), w.Name
+}
+
+func (m *suiteMix) record(name string) {
+	if name == "" {
+		return
+	}
+	m.mu.Lock()
+	m.counts[name]++
+	m.mu.Unlock()
+}
+
+// print renders the scheduled mix next to the configured weights.
+func (m *suiteMix) print(config *Configuration) {
+	if len(config.Workflows) == 0 {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var scheduled int64
+	for _, n := range m.counts {
+		scheduled += n
+	}
+	totalWeight := 0.0
+	for _, w := range config.Workflows {
+		totalWeight += w.weight()
+	}
+	rows := TableData{{"Workflow", "Weight", "Scheduled", "Share"}}
+	entries := append([]SuiteWorkflow(nil), config.Workflows...)
+	sort.SliceStable(entries, func(a, b int) bool { return entries[a].weight() > entries[b].weight() })
+	for _, w := range entries {
+		share := 0.0
+		if scheduled > 0 {
+			share = float64(m.counts[w.Name]) / float64(scheduled) * 100
+		}
+		rows = append(rows, []string{
+			w.Name,
+			fmt.Sprintf("%.0f%%", w.weight()/totalWeight*100),
+			fmt.Sprintf("%d", m.counts[w.Name]),
+			fmt.Sprintf("%.1f%%", share),
+		})
+	}
+	pterm.Println()
+	pterm.DefaultSection.WithStyle(pterm.NewStyle(pterm.FgCyan)).Println("Suite Mix - Who Got Picked")
+	pterm.DefaultTable.WithHasHeader().WithLeftAlignment().WithData(rows).Render()
+}
+
+// mergeSuiteWeights applies weight-only changes to a suite during hot reload.
+// It also reports whether any weight changed, and whether the suites are
+// otherwise the same; anything besides the weights needs a restart.
+func mergeSuiteWeights(current, updated []SuiteWorkflow) ([]SuiteWorkflow, bool, bool) {
+	if len(current) != len(updated) {
+		return current, false, false
+	}
+	merged := make([]SuiteWorkflow, len(current))
+	changed := false
+	for i := range current {
+		a, b := current[i], updated[i]
+		if a.Name != b.Name || !reflect.DeepEqual(a.Steps, b.Steps) || !reflect.DeepEqual(a.OnError, b.OnError) {
+			return current, false, false
+		}
+		merged[i] = a
+		if a.Weight != b.Weight {
+			merged[i].Weight = b.Weight
+			changed = true
+		}
+	}
+	return merged, changed, true
+}
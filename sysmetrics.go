@@ -0,0 +1,136 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/shirou/gopsutil/cpu"
+	"github.com/shirou/gopsutil/mem"
+)
+
+// containerSampler reports CPU and memory usage relative to the limits of the
+// container the process runs in, rather than the whole node.
+type containerSampler interface {
+	cpuPercent() (float64, bool)
+	memPercent() (float64, bool)
+}
+
+var (
+	containerSamplerOnce sync.Once
+	activeSampler        containerSampler
+)
+
+func currentContainerSampler() containerSampler {
+	containerSamplerOnce.Do(func() {
+		activeSampler = newContainerSampler()
+		if activeSampler != nil {
+			storeLog("Using container cgroup limits for CPU and memory metrics")
+		}
+	})
+	return activeSampler
+}
+
+// sampleCPUPercent returns the current CPU usage, preferring container limits.
+func sampleCPUPercent() (float64, bool) {
+	if sampler := currentContainerSampler(); sampler != nil {
+		if value, ok := sampler.cpuPercent(); ok {
+			return value, true
+		}
+	}
+	cpuPercents, err := cpu.Percent(0, false)
+	if err != nil || len(cpuPercents) == 0 {
+		return 0, false
+	}
+	var sum float64
+	for _, p := range cpuPercents {
+		sum += p
+	}
+	return sum / float64(len(cpuPercents)), true
+}
+
+// sampleMemPercent returns the current memory usage, preferring container limits.
+func sampleMemPercent() (float64, bool) {
+	if sampler := currentContainerSampler(); sampler != nil {
+		if value, ok := sampler.memPercent(); ok {
+			return value, true
+		}
+	}
+	memStats, err := mem.VirtualMemory()
+	if err != nil || memStats == nil {
+		return 0, false
+	}
+	return memStats.UsedPercent, true
+}
+
+// parseCgroupCPUMax parses a cgroup v2 cpu.max value ("max 100000" or
+// "200000 100000") into the number of CPUs the group may use.
+func parseCgroupCPUMax(raw string) (float64, bool) {
+	fields := strings.Fields(raw)
+	if len(fields) != 2 || fields[0] == "max" {
+		return 0, false
+	}
+	quota, err := strconv.ParseFloat(fields[0], 64)
+	if err != nil || quota <= 0 {
+		return 0, false
+	}
+	period, err := strconv.ParseFloat(fields[1], 64)
+	if err != nil || period <= 0 {
+		return 0, false
+	}
+	return quota / period, true
+}
+
+// parseCgroupLimit parses a memory limit, treating "max" and the v1
+// "unlimited" sentinel (a page-aligned near-MaxInt64 value) as no limit.
+func parseCgroupLimit(raw string) (uint64, bool) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" || raw == "max" {
+		return 0, false
+	}
+	value, err := strconv.ParseUint(raw, 10, 64)
+	if err != nil || value == 0 || value >= 1<<62 {
+		return 0, false
+	}
+	return value, true
+}
+
+// parseCgroupStat returns a single key from a cgroup stat file.
+func parseCgroupStat(raw, key string) (uint64, bool) {
+	for _, line := range strings.Split(raw, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 2 && fields[0] == key {
+			value, err := strconv.ParseUint(fields[1], 10, 64)
+			return value, err == nil
+		}
+	}
+	return 0, false
+}
+
+// cpuUsageTracker turns a cumulative CPU-time counter into a percentage of
+// the allowed CPUs between successive samples.
+type cpuUsageTracker struct {
+	mu       sync.Mutex
+	lastUsed time.Duration
+	lastAt   time.Time
+}
+
+func (t *cpuUsageTracker) percent(used time.Duration, now time.Time, cpus float64) (float64, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	prevUsed, prevAt := t.lastUsed, t.lastAt
+	t.lastUsed, t.lastAt = used, now
+	if prevAt.IsZero() || cpus <= 0 {
+		return 0, false
+	}
+	wall := now.Sub(prevAt)
+	if wall <= 0 || used < prevUsed {
+		return 0, false
+	}
+	percent := float64(used-prevUsed) / (float64(wall) * cpus) * 100
+	if percent > 100 {
+		percent = 100
+	}
+	return percent, true
+}
@@ -0,0 +1,296 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// timingStat summarizes the durations, in seconds, of a step or a
+// transaction over a run.
+type timingStat struct {
+	Count int64   `json:"count"`
+	Min   float64 `json:"min"`
+	Avg   float64 `json:"avg"`
+	Max   float64 `json:"max"`
+	// Histogram buckets the durations as durationBucket does, for
+	// percentiles.
+	Histogram map[int]int64 `json:"histogram,omitempty"`
+	total     float64
+}
+
+func (t *timingStat) add(seconds float64) {
+	if t.Count == 0 || seconds < t.Min {
+		t.Min = seconds
+	}
+	if seconds > t.Max {
+		t.Max = seconds
+	}
+	t.Count++
+	t.total += seconds
+	t.Avg = t.total / float64(t.Count)
+	if t.Histogram == nil {
+		t.Histogram = make(map[int]int64)
+	}
+	t.Histogram[durationBucket(seconds)]++
+}
+
+// merge folds another summary of the same step or transaction into t.
+func (t *timingStat) merge(o timingStat) {
+	if o.Count == 0 {
+		return
+	}
+	if t.Count == 0 || o.Min < t.Min {
+		t.Min = o.Min
+	}
+	if o.Max > t.Max {
+		t.Max = o.Max
+	}
+	t.total = t.Avg*float64(t.Count) + o.Avg*float64(o.Count)
+	t.Count += o.Count
+	t.Avg = t.total / float64(t.Count)
+	for bucket, n := range o.Histogram {
+		if t.Histogram == nil {
+			t.Histogram = make(map[int]int64)
+		}
+		t.Histogram[bucket] += n
+	}
+}
+
+// percentile returns the duration, in seconds, that p percent of the
+// timings were within: the upper bound of its bucket.
+func (t timingStat) percentile(p float64) float64 {
+	var buckets [durationBucketCount]int64
+	var count int64
+	for bucket, n := range t.Histogram {
+		if bucket >= 0 && bucket < durationBucketCount {
+			buckets[bucket] += n
+			count += n
+		}
+	}
+	return bucketPercentile(&buckets, count, p)
+}
+
+// clone returns a copy of t that shares no histogram with it.
+func (t timingStat) clone() timingStat {
+	if t.Histogram != nil {
+		histogram := make(map[int]int64, len(t.Histogram))
+		for bucket, n := range t.Histogram {
+			histogram[bucket] = n
+		}
+		t.Histogram = histogram
+	}
+	return t
+}
+
+// mergeTimingStats folds the stats of from into into, by name.
+func mergeTimingStats(into map[string]timingStat, from map[string]timingStat) map[string]timingStat {
+	for name, stat := range from {
+		if into == nil {
+			into = make(map[string]timingStat)
+		}
+		merged := into[name]
+		merged.merge(stat)
+		into[name] = merged
+	}
+	return into
+}
+
+var (
+	timingStatsMu      sync.Mutex
+	stepTimings        = make(map[string]*timingStat)
+	transactionTimings = make(map[string]*timingStat)
+	// stepOrder keeps steps in the order they first ran, which is the
+	// order of the workflow.
+	stepOrder []string
+)
+
+func recordStepTiming(key string, d time.Duration) {
+	timingStatsMu.Lock()
+	defer timingStatsMu.Unlock()
+	stat, ok := stepTimings[key]
+	if !ok {
+		stat = &timingStat{}
+		stepTimings[key] = stat
+		stepOrder = append(stepOrder, key)
+	}
+	stat.add(d.Seconds())
+}
+
+func recordTransactionTiming(name string, d time.Duration) {
+	timingStatsMu.Lock()
+	defer timingStatsMu.Unlock()
+	stat, ok := transactionTimings[name]
+	if !ok {
+		stat = &timingStat{}
+		transactionTimings[name] = stat
+	}
+	stat.add(d.Seconds())
+}
+
+// addTransactionTimings folds transaction timings reported by distributed
+// workers into this process's, for the merged summary.
+func addTransactionTimings(transactions map[string]timingStat) {
+	timingStatsMu.Lock()
+	defer timingStatsMu.Unlock()
+	for name, stat := range transactions {
+		merged, ok := transactionTimings[name]
+		if !ok {
+			merged = &timingStat{}
+			transactionTimings[name] = merged
+		}
+		merged.merge(stat)
+	}
+}
+
+// timingSnapshot returns copies of the step and transaction timings.
+func timingSnapshot() (steps, transactions map[string]timingStat) {
+	timingStatsMu.Lock()
+	defer timingStatsMu.Unlock()
+	if len(stepTimings) > 0 {
+		steps = make(map[string]timingStat, len(stepTimings))
+		for key, stat := range stepTimings {
+			steps[key] = stat.clone()
+		}
+	}
+	if len(transactionTimings) > 0 {
+		transactions = make(map[string]timingStat, len(transactionTimings))
+		for name, stat := range transactionTimings {
+			transactions[name] = stat.clone()
+		}
+	}
+	return steps, transactions
+}
+
+// stepTimingKey names a step in the timings: its position and type, after
+// the workflow name in a suite.
+func stepTimingKey(config *Configuration, idx int, step Step) string {
+	key := fmt.Sprintf("%d %s", idx+1, step.Type)
+	if config.workflowName != "" {
+		key = config.workflowName + " / " + key
+	}
+	return key
+}
+
+// transactionTracker adds up the step times of each transaction during one
+// workflow run and records a transaction once its last step has passed.
+// A transaction whose step failed is not recorded.
+type transactionTracker struct {
+	last    map[string]int // index of the last step of each transaction
+	elapsed map[string]time.Duration
+}
+
+func newTransactionTracker(steps []Step) *transactionTracker {
+	t := &transactionTracker{last: make(map[string]int), elapsed: make(map[string]time.Duration)}
+	for idx, step := range steps {
+		if step.Transaction != "" {
+			t.last[step.Transaction] = idx
+		}
+	}
+	return t
+}
+
+func (t *transactionTracker) stepPassed(idx int, step Step, d time.Duration) {
+	if step.Transaction == "" {
+		return
+	}
+	t.elapsed[step.Transaction] += d
+	if t.last[step.Transaction] == idx {
+		recordTransactionTiming(step.Transaction, t.elapsed[step.Transaction])
+		delete(t.elapsed, step.Transaction)
+	}
+}
+
+// validateTransactions checks that Transaction is only set on top-level
+// steps; an If step times everything inside it.
+func validateTransactions(steps []Step) error {
+	for _, step := range steps {
+		if strings.TrimSpace(step.Transaction) != step.Transaction {
+			return fmt.Errorf("Transaction %q has leading or trailing spaces", step.Transaction)
+		}
+		if stepsUseTransaction(step.Steps) || stepsUseTransaction(step.Else) {
+			return fmt.Errorf("Transaction is only allowed on top-level steps - set it on the If step instead")
+		}
+	}
+	return nil
+}
+
+func stepsUseTransaction(steps []Step) bool {
+	for _, step := range steps {
+		if step.Transaction != "" || stepsUseTransaction(step.Steps) || stepsUseTransaction(step.Else) {
+			return true
+		}
+	}
+	return false
+}
+
+func formatTimingRow(name string, stat timingStat) []string {
+	return []string{
+		name,
+		fmt.Sprintf("%d", stat.Count),
+		fmt.Sprintf("%.3fs", stat.Min),
+		fmt.Sprintf("%.3fs", stat.Avg),
+		fmt.Sprintf("%.3fs", stat.Max),
+	}
+}
+
+// printTimingSummary renders the transaction timings under the run
+// summary, or the step timings when no transactions are named.
+func printTimingSummary() {
+	steps, transactions := timingSnapshot()
+	rows := TableData{{"Transaction", "Count", "Min", "Avg", "Max"}}
+	title := "Transactions - Where the Time Goes"
+	if len(transactions) > 0 {
+		names := make([]string, 0, len(transactions))
+		for name := range transactions {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			rows = append(rows, formatTimingRow(name, transactions[name]))
+		}
+	} else if len(steps) > 0 {
+		rows[0][0] = "Step"
+		title = "Step Timings - Where the Time Goes"
+		timingStatsMu.Lock()
+		order := append([]string(nil), stepOrder...)
+		timingStatsMu.Unlock()
+		for _, key := range order {
+			rows = append(rows, formatTimingRow(key, steps[key]))
+		}
+	} else {
+		return
+	}
+	pterm.Println()
+	pterm.DefaultSection.WithStyle(pterm.NewStyle(pterm.FgCyan)).Println(title)
+	pterm.DefaultTable.WithHasHeader().WithLeftAlignment().WithData(rows).Render()
+}
+
+// timingSummaryText returns the timings for the summary file.
+func timingSummaryText() string {
+	steps, transactions := timingSnapshot()
+	var sb strings.Builder
+	write := func(heading string, names []string, stats map[string]timingStat) {
+		if len(names) == 0 {
+			return
+		}
+		sb.WriteString(heading + ":\n")
+		for _, name := range names {
+			s := stats[name]
+			sb.WriteString(fmt.Sprintf("  %s: count %d, min %.3fs, avg %.3fs, max %.3fs\n", name, s.Count, s.Min, s.Avg, s.Max))
+		}
+	}
+	names := make([]string, 0, len(transactions))
+	for name := range transactions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	write("Transactions", names, transactions)
+	timingStatsMu.Lock()
+	order := append([]string(nil), stepOrder...)
+	timingStatsMu.Unlock()
+	write("Steps", order, steps)
+	return sb.String()
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+// validateTLSSettings checks the client certificate settings of config.
+func validateTLSSettings(config *Configuration) error {
+	certSettings := config.TLSCertFile != "" || config.TLSKeyFile != "" || config.TLSKeyPassword != "" || config.TLSCAFile != ""
+	if !config.TLS && (certSettings || config.TLSSkipVerify) {
+		return fmt.Errorf("TLS certificate settings need \"TLS\": true")
+	}
+	if config.TLSKeyFile != "" && config.TLSCertFile == "" {
+		return fmt.Errorf("TLSKeyFile needs a TLSCertFile to go with it")
+	}
+	if config.TLSKeyPassword != "" && config.TLSCertFile == "" {
+		return fmt.Errorf("TLSKeyPassword needs a TLSCertFile to go with it")
+	}
+	for _, f := range []struct{ name, path string }{
+		{"TLSCertFile", config.TLSCertFile},
+		{"TLSKeyFile", config.TLSKeyFile},
+		{"TLSCAFile", config.TLSCAFile},
+	} {
+		if f.path == "" || hasSecretPlaceholder(f.path) {
+			continue
+		}
+		if _, err := os.Stat(f.path); err != nil {
+			return fmt.Errorf("%s: %w", f.name, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,467 @@
+package main
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+
+	connect3270 "github.com/3270io/3270Connect/connect3270"
+)
+
+// An x3270 trace (-trace) logs every record exchanged with the host as hex
+// lines such as "< 0x0   f5c311405b1d..." (from the host) and
+// "> 0x0   7dc6e9..." (to the host). Replaying the inbound records rebuilds
+// each screen; the outbound records hold the AID key and the fields typed.
+
+var (
+	traceHexLine   = regexp.MustCompile(`^([<>]) 0x([0-9a-f]+)\s+([0-9a-f]+)\s*$`)
+	traceConnected = regexp.MustCompile(`Connected to ([^,\s]+), port (\d+)`)
+	traceModel     = regexp.MustCompile(`Model \S+, (\d+) rows x (\d+) cols`)
+)
+
+// traceRecord is one 3270 data stream record, without telnet framing. A
+// record with reconnect set marks a new connection later in the trace.
+type traceRecord struct {
+	outbound  bool
+	reconnect bool
+	data      []byte
+}
+
+// traceSession is what parseTrace recovers from a trace file.
+type traceSession struct {
+	host    string
+	port    int
+	rows    int
+	columns int
+	records []traceRecord
+}
+
+func parseTrace(r io.Reader) (*traceSession, error) {
+	session := &traceSession{rows: 24, columns: 80}
+	tn3270e := false
+	var current *traceRecord
+	flush := func() {
+		if current != nil {
+			if data, ok := unframeRecord(current.data, tn3270e); ok {
+				session.records = append(session.records, traceRecord{outbound: current.outbound, data: data})
+			}
+			current = nil
+		}
+	}
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1<<20)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if m := traceHexLine.FindStringSubmatch(line); m != nil {
+			outbound := m[1] == ">"
+			offset, _ := strconv.ParseUint(m[2], 16, 32)
+			if current != nil && (offset == 0 || current.outbound != outbound) {
+				flush()
+			}
+			if current == nil {
+				current = &traceRecord{outbound: outbound}
+			}
+			chunk, err := hex.DecodeString(m[3])
+			if err != nil {
+				return nil, fmt.Errorf("bad trace data %q: %w", line, err)
+			}
+			current.data = append(current.data, chunk...)
+			continue
+		}
+		if strings.Contains(line, "SENT WILL TN3270E") {
+			tn3270e = true
+		}
+		if m := traceConnected.FindStringSubmatch(line); m != nil {
+			flush()
+			if session.host == "" {
+				session.host = m[1]
+				session.port, _ = strconv.Atoi(m[2])
+			} else {
+				session.records = append(session.records, traceRecord{reconnect: true})
+				tn3270e = false
+			}
+		}
+		if m := traceModel.FindStringSubmatch(line); m != nil {
+			session.rows, _ = strconv.Atoi(m[1])
+			session.columns, _ = strconv.Atoi(m[2])
+		}
+	}
+	flush()
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if session.host == "" {
+		return nil, fmt.Errorf("no \"Connected to\" line found - is this an x3270 -trace file?")
+	}
+	return session, nil
+}
+
+// unframeRecord strips telnet framing (IAC EOR, doubled IACs and, in
+// TN3270E mode, the 5-byte header) and drops telnet negotiation.
+func unframeRecord(raw []byte, tn3270e bool) ([]byte, bool) {
+	if len(raw) == 0 || raw[0] == 0xff {
+		return nil, false
+	}
+	raw = bytesTrimSuffix(raw, []byte{0xff, 0xef})
+	data := make([]byte, 0, len(raw))
+	for i := 0; i < len(raw); i++ {
+		data = append(data, raw[i])
+		if raw[i] == 0xff && i+1 < len(raw) && raw[i+1] == 0xff {
+			i++
+		}
+	}
+	if tn3270e {
+		// Only 3270-DATA records describe the screen.
+		if len(data) < 5 || data[0] != 0x00 {
+			return nil, false
+		}
+		data = data[5:]
+	}
+	return data, len(data) > 0
+}
+
+func bytesTrimSuffix(b, suffix []byte) []byte {
+	if len(b) >= len(suffix) && string(b[len(b)-len(suffix):]) == string(suffix) {
+		return b[:len(b)-len(suffix)]
+	}
+	return b
+}
+
+// traceScreen replays inbound 3270 data to track what the user saw.
+type traceScreen struct {
+	rows, columns int
+	buffer        []byte // EBCDIC characters
+	attrs         []int  // field attribute at each cell, or -1
+}
+
+func newTraceScreen(rows, columns int) *traceScreen {
+	s := &traceScreen{rows: rows, columns: columns}
+	s.erase()
+	return s
+}
+
+func (s *traceScreen) erase() {
+	s.buffer = make([]byte, s.rows*s.columns)
+	s.attrs = make([]int, s.rows*s.columns)
+	for i := range s.attrs {
+		s.attrs[i] = -1
+	}
+}
+
+func (s *traceScreen) address(b1, b2 byte) int {
+	var addr int
+	if b1&0xc0 == 0 {
+		addr = int(b1&0x3f)<<8 | int(b2) // 14-bit addressing
+	} else {
+		addr = int(b1&0x3f)<<6 | int(b2&0x3f) // 12-bit addressing
+	}
+	return addr % len(s.buffer)
+}
+
+// apply processes one record sent by the host.
+func (s *traceScreen) apply(data []byte) {
+	switch data[0] {
+	case 0xf5, 0x05, 0x7e, 0x0d: // Erase/Write, Erase/Write Alternate
+		s.erase()
+		s.writeOrders(data[1:])
+	case 0xf1, 0x01: // Write
+		s.writeOrders(data[1:])
+	case 0x6f, 0x0f: // Erase All Unprotected
+		for i := range s.buffer {
+			if s.attrs[i] < 0 && !s.protectedAt(i) {
+				s.buffer[i] = 0
+			}
+		}
+	case 0xf3, 0x11: // Write Structured Field
+		for p := 1; p+3 <= len(data); {
+			length := int(data[p])<<8 | int(data[p+1])
+			if length < 3 || p+length > len(data) {
+				length = len(data) - p
+			}
+			// Outbound 3270DS: partition, then a write command and its data.
+			if data[p+2] == 0x40 && length > 4 {
+				s.apply(data[p+4 : p+length])
+			}
+			p += length
+		}
+	}
+}
+
+func (s *traceScreen) writeOrders(data []byte) {
+	if len(data) == 0 {
+		return
+	}
+	size := len(s.buffer)
+	addr := 0
+	put := func(b byte) {
+		s.buffer[addr] = b
+		s.attrs[addr] = -1
+		addr = (addr + 1) % size
+	}
+	for p := 1; p < len(data); p++ { // data[0] is the WCC
+		switch b := data[p]; b {
+		case 0x11: // Set Buffer Address
+			if p+2 < len(data) {
+				addr = s.address(data[p+1], data[p+2])
+			}
+			p += 2
+		case 0x1d: // Start Field
+			if p+1 < len(data) {
+				s.buffer[addr] = 0
+				s.attrs[addr] = int(data[p+1])
+				addr = (addr + 1) % size
+			}
+			p++
+		case 0x29, 0x2c: // Start Field Extended, Modify Field
+			if p+1 >= len(data) {
+				return
+			}
+			pairs := int(data[p+1])
+			fa := 0
+			for i := 0; i < pairs && p+3+2*i < len(data); i++ {
+				if data[p+2+2*i] == 0xc0 {
+					fa = int(data[p+3+2*i])
+				}
+			}
+			if b == 0x29 || s.attrs[addr] >= 0 {
+				s.buffer[addr] = 0
+				s.attrs[addr] = fa
+			}
+			if b == 0x29 {
+				addr = (addr + 1) % size
+			}
+			p += 1 + 2*pairs
+		case 0x28: // Set Attribute
+			p += 2
+		case 0x13: // Insert Cursor
+		case 0x05: // Program Tab
+			addr = s.nextInputField(addr)
+		case 0x3c: // Repeat to Address
+			if p+3 >= len(data) {
+				return
+			}
+			stop := s.address(data[p+1], data[p+2])
+			char := data[p+3]
+			p += 3
+			if char == 0x08 && p+1 < len(data) { // Graphic Escape
+				p++
+				char = data[p]
+			}
+			for {
+				put(char)
+				if addr == stop {
+					break
+				}
+			}
+		case 0x12: // Erase Unprotected to Address
+			if p+2 >= len(data) {
+				return
+			}
+			stop := s.address(data[p+1], data[p+2])
+			p += 2
+			for addr != stop {
+				if s.attrs[addr] < 0 && !s.protectedAt(addr) {
+					s.buffer[addr] = 0
+				}
+				addr = (addr + 1) % size
+			}
+		case 0x08: // Graphic Escape
+			if p+1 < len(data) {
+				p++
+				put(data[p])
+			}
+		default:
+			put(b)
+		}
+	}
+}
+
+// fieldAttr returns the attribute of the field containing pos, or -1 on an
+// unformatted screen.
+func (s *traceScreen) fieldAttr(pos int) int {
+	size := len(s.buffer)
+	for i := 0; i < size; i++ {
+		if a := s.attrs[(pos-i+size)%size]; a >= 0 {
+			return a
+		}
+	}
+	return -1
+}
+
+func (s *traceScreen) protectedAt(pos int) bool {
+	a := s.fieldAttr(pos)
+	return a >= 0 && a&0x20 != 0
+}
+
+// inputAt reports whether pos is inside an unprotected field.
+func (s *traceScreen) inputAt(pos int) bool {
+	a := s.fieldAttr(pos)
+	return a >= 0 && a&0x20 == 0
+}
+
+func (s *traceScreen) hiddenAt(pos int) bool {
+	a := s.fieldAttr(pos)
+	return a >= 0 && a&0x0c == 0x0c
+}
+
+func (s *traceScreen) nextInputField(pos int) int {
+	size := len(s.buffer)
+	for i := 0; i < size; i++ {
+		at := (pos + i) % size
+		if a := s.attrs[at]; a >= 0 && a&0x20 == 0 {
+			return (at + 1) % size
+		}
+	}
+	return 0
+}
+
+// landmark picks a stable piece of protected text, preferably without
+// digits (which tend to be dates, times or counters), to confirm the screen.
+func (s *traceScreen) landmark() (connect3270.Coordinates, string, bool) {
+	var fallback *connect3270.Coordinates
+	var fallbackText string
+	for row := 0; row < s.rows; row++ {
+		line := []rune(s.rowText(row))
+		for col := 0; col < len(line); {
+			pos := row*s.columns + col
+			if line[col] == ' ' || s.attrs[pos] >= 0 || s.hiddenAt(pos) || s.inputAt(pos) {
+				col++
+				continue
+			}
+			end := col
+			for end < len(line) && s.attrs[row*s.columns+end] < 0 && !(line[end] == ' ' && end+1 < len(line) && line[end+1] == ' ') {
+				end++
+			}
+			text := strings.TrimSpace(string(line[col:end]))
+			if len([]rune(text)) >= 3 {
+				coords := connect3270.Coordinates{Row: row + 1, Column: col + 1, Length: len([]rune(text))}
+				if !strings.ContainsFunc(text, unicode.IsDigit) {
+					return coords, text, true
+				}
+				if fallback == nil {
+					fallback, fallbackText = &coords, text
+				}
+			}
+			col = end + 1
+		}
+	}
+	if fallback != nil {
+		return *fallback, fallbackText, true
+	}
+	return connect3270.Coordinates{}, "", false
+}
+
+func (s *traceScreen) rowText(row int) string {
+	var b strings.Builder
+	for _, c := range s.buffer[row*s.columns : (row+1)*s.columns] {
+		b.WriteRune(ebcdicToRune(c))
+	}
+	return b.String()
+}
+
+// traceAIDSteps maps attention identifiers to the step that sends them.
+var traceAIDSteps = map[byte]string{
+	0x7d: "PressEnter", 0x6d: "PressClear",
+	0x6c: "PressPA1", 0x6e: "PressPA2", 0x6b: "PressPA3",
+	0xf1: "PressPF1", 0xf2: "PressPF2", 0xf3: "PressPF3", 0xf4: "PressPF4",
+	0xf5: "PressPF5", 0xf6: "PressPF6", 0xf7: "PressPF7", 0xf8: "PressPF8",
+	0xf9: "PressPF9", 0x7a: "PressPF10", 0x7b: "PressPF11", 0x7c: "PressPF12",
+	0xc1: "PressPF13", 0xc2: "PressPF14", 0xc3: "PressPF15", 0xc4: "PressPF16",
+	0xc5: "PressPF17", 0xc6: "PressPF18", 0xc7: "PressPF19", 0xc8: "PressPF20",
+	0xc9: "PressPF21", 0x4a: "PressPF22", 0x4b: "PressPF23", 0x4c: "PressPF24",
+}
+
+// traceToSteps turns a parsed trace into workflow steps: Connect, then for
+// every key the user pressed a CheckValue on the screen they saw, a
+// FillString per field they typed in and the key itself, and finally
+// Disconnect. Text typed into hidden fields is replaced with
+// {{env:RECORDED_SECRET_n}} placeholders and reported in the notes. With
+// checks false no CheckValue steps are generated.
+func traceToSteps(session *traceSession, checks bool) ([]Step, []string) {
+	screen := newTraceScreen(session.rows, session.columns)
+	steps := []Step{{Type: "Connect"}}
+	var notes []string
+	secrets := 0
+	checkScreen := func() {
+		if !checks {
+			return
+		}
+		if coords, text, ok := screen.landmark(); ok {
+			steps = append(steps, Step{Type: "CheckValue", Coordinates: coords, Text: text})
+		}
+	}
+	for _, record := range session.records {
+		if record.reconnect {
+			checkScreen()
+			steps = append(steps, Step{Type: "Disconnect"}, Step{Type: "Connect"})
+			screen = newTraceScreen(session.rows, session.columns)
+			continue
+		}
+		if !record.outbound {
+			screen.apply(record.data)
+			continue
+		}
+		stepType, ok := traceAIDSteps[record.data[0]]
+		if !ok {
+			continue // query replies and other non-keyboard records
+		}
+		checkScreen()
+		for _, field := range readModifiedFields(record.data, screen) {
+			text := field.text
+			if screen.hiddenAt(field.addr) {
+				secrets++
+				text = fmt.Sprintf("{{env:RECORDED_SECRET_%d}}", secrets)
+				notes = append(notes, fmt.Sprintf("Text typed into the hidden field at row %d, column %d was replaced with %s.",
+					field.addr/session.columns+1, field.addr%session.columns+1, text))
+			}
+			steps = append(steps, Step{
+				Type:        "FillString",
+				Coordinates: connect3270.Coordinates{Row: field.addr/session.columns + 1, Column: field.addr%session.columns + 1},
+				Text:        text,
+			})
+		}
+		steps = append(steps, Step{Type: stepType})
+	}
+	checkScreen()
+	steps = append(steps, Step{Type: "Disconnect"})
+	return steps, notes
+}
+
+type modifiedField struct {
+	addr int
+	text string
+}
+
+// readModifiedFields decodes the fields in a Read Modified reply: the AID,
+// the cursor address, then SBA address / data pairs.
+func readModifiedFields(data []byte, screen *traceScreen) []modifiedField {
+	var fields []modifiedField
+	var current *modifiedField
+	for p := 3; p < len(data); p++ {
+		if data[p] == 0x11 && p+2 < len(data) {
+			if current != nil && strings.TrimSpace(current.text) != "" {
+				fields = append(fields, *current)
+			}
+			current = &modifiedField{addr: screen.address(data[p+1], data[p+2])}
+			p += 2
+			continue
+		}
+		if current != nil {
+			current.text += string(ebcdicToRune(data[p]))
+		}
+	}
+	if current != nil && strings.TrimSpace(current.text) != "" {
+		fields = append(fields, *current)
+	}
+	return fields
+}
+
+func ebcdicToRune(b byte) rune {
+	return connect3270.EBCDICToRune(b)
+}
@@ -0,0 +1,493 @@
+package main
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+
+	connect3270 "github.com/3270io/3270Connect/connect3270"
+)
+
+// workflowSchema is the JSON Schema for workflow files, also published with
+// the docs.
+//
+//go:embed docs/workflow.schema.json
+var workflowSchema []byte
+
+// The emulator always runs model 3279-2.
+const (
+	screenRows    = 24
+	screenColumns = 80
+)
+
+// validationIssue is one problem found by `3270Connect validate`, or in a
+// workflow the API refused. Line and Column are 1-based; zero means the
+// problem has no single position.
+type validationIssue struct {
+	Line    int    `json:"line,omitempty"`
+	Column  int    `json:"column,omitempty"`
+	Path    string `json:"path,omitempty"`
+	Message string `json:"message"`
+}
+
+func (i validationIssue) format(file string) string {
+	var b strings.Builder
+	b.WriteString(file)
+	if i.Line > 0 {
+		fmt.Fprintf(&b, ":%d:%d", i.Line, i.Column)
+	}
+	b.WriteString(": ")
+	if i.Path != "" {
+		b.WriteString(i.Path + ": ")
+	}
+	b.WriteString(i.Message)
+	return b.String()
+}
+
+// runSubcommand handles `3270Connect validate <file>...`,
+// `3270Connect convert <trace>...` and `3270Connect schema`. It reports whether args named a subcommand and the
+// exit code to use.
+func runSubcommand(args []string) (bool, int) {
+	if len(args) == 0 {
+		return false, 0
+	}
+	switch args[0] {
+	case "schema":
+		os.Stdout.Write(workflowSchema)
+		return true, 0
+	case "validate":
+		if len(args) < 2 {
+			fmt.Fprintln(os.Stderr, "usage: 3270Connect validate workflow.json [more.json ...]")
+			return true, 2
+		}
+		return true, runValidateCommand(args[1:], os.Stdout)
+	case "convert":
+		return true, runConvertCommand(args[1:])
+	case "report":
+		return true, runReportCommand(args[1:], os.Stdout)
+	case "compare":
+		return true, runCompareCommand(args[1:], os.Stdout)
+	case "hash-password":
+		return true, runHashPasswordCommand(os.Stdin, os.Stdout)
+	}
+	return false, 0
+}
+
+func runValidateCommand(files []string, out io.Writer) int {
+	exitCode := 0
+	for _, file := range files {
+		issues, err := validateWorkflowFile(file)
+		if err != nil {
+			fmt.Fprintf(out, "%s: %v\n", file, err)
+			exitCode = 1
+			continue
+		}
+		if len(issues) == 0 {
+			fmt.Fprintf(out, "%s: OK\n", file)
+			continue
+		}
+		for _, issue := range issues {
+			fmt.Fprintln(out, issue.format(file))
+		}
+		exitCode = 1
+	}
+	return exitCode
+}
+
+// validateWorkflowFile checks a workflow without connecting to any host:
+// JSON syntax, unknown fields, value types, step rules and coordinate ranges.
+func validateWorkflowFile(path string) ([]validationIssue, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return validateWorkflowJSON(data, filepath.Dir(path)), nil
+}
+
+func validateWorkflowJSON(data []byte, baseDir string) []validationIssue {
+	walker := &schemaWalker{data: data, offsets: make(map[string]int64)}
+	if err := walker.walkDocument(); err != nil {
+		line, col := 0, 0
+		var syntaxErr *json.SyntaxError
+		if errors.As(err, &syntaxErr) {
+			line, col = offsetPosition(data, syntaxErr.Offset-1)
+		}
+		return []validationIssue{{Line: line, Column: col, Message: "invalid JSON: " + err.Error()}}
+	}
+	issues := walker.issues
+
+	var config Configuration
+	if err := json.Unmarshal(data, &config); err != nil {
+		issue := validationIssue{Message: err.Error()}
+		if path := headerIssuePath(err); path != "" {
+			issue = walker.issueAt(path, err.Error())
+		}
+		var typeErr *json.UnmarshalTypeError
+		if errors.As(err, &typeErr) {
+			issue.Line, issue.Column = offsetPosition(data, typeErr.Offset-1)
+			// Field numbers list elements as .0; paths elsewhere use [0].
+			issue.Path = listIndex.ReplaceAllString(typeErr.Field, "[$1]")
+			issue.Message = fmt.Sprintf("expected %s, got JSON %s", typeErr.Type, typeErr.Value)
+		}
+		return append(issues, issue)
+	}
+
+	// Settings outside the step lists.
+	header := config
+	header.Steps, header.OnError, header.Workflows = nil, nil, nil
+	header.SessionSetup, header.SessionTeardown = nil, nil
+	if len(config.Environments) > 0 && environmentName == "" {
+		// Without -env every profile is checked.
+		for _, name := range environmentNames(&config) {
+			profile := header
+			_ = applyEnvironment(&profile, name)
+			if err := validateConfiguration(&profile); err != nil {
+				issues = append(issues, walker.issueAt("Environments."+name, fmt.Sprintf("environment %s: %v", name, err)))
+			}
+		}
+	} else if err := applyEnvironment(&header, environmentName); err != nil {
+		issues = append(issues, walker.issueAt("Environments", err.Error()))
+	} else if err := validateConfiguration(&header); err != nil {
+		issues = append(issues, walker.issueAt(headerIssuePath(err), err.Error()))
+	}
+	if config.OutputFilePath == "" && (stepsNeedOutputFile(config.Steps) || stepsNeedOutputFile(config.OnError) ||
+		stepsNeedOutputFile(config.SessionSetup) || stepsNeedOutputFile(config.SessionTeardown)) {
+		issues = append(issues, walker.issueAt("OutputFilePath", "output file path is empty - screen grab needs a home"))
+	}
+	if err := validateSessionSetup(&config); err != nil {
+		issues = append(issues, walker.issueAt("SessionSetup", err.Error()))
+	}
+	for _, phase := range []struct{ key, file string }{{"Setup", config.Setup}, {"Teardown", config.Teardown}} {
+		if phase.file == "" {
+			continue
+		}
+		if _, err := readConfiguration(phaseWorkflowPath("", phase.file, baseDir)); err != nil {
+			issues = append(issues, walker.issueAt(phase.key, fmt.Sprintf("%s workflow %s: %v", phase.key, phase.file, err)))
+		}
+	}
+
+	// Steps are checked one at a time so each problem points at its step.
+	checkSteps := func(listPath string, steps []Step, definedVars map[string]bool) {
+		for i, step := range steps {
+			path := fmt.Sprintf("%s[%d]", listPath, i)
+			issues = append(issues, walker.coordinateIssues(path, step)...)
+			expanded, err := expandIncludes([]Step{step}, baseDir)
+			if err == nil {
+				err = validateSteps(expanded, definedVars)
+			}
+			if err != nil {
+				issues = append(issues, walker.issueAt(path, err.Error()))
+			}
+		}
+	}
+	definedVars := make(map[string]bool)
+	checkSteps("SessionSetup", config.SessionSetup, definedVars)
+	checkSteps("Steps", config.Steps, definedVars)
+	checkSteps("OnError", config.OnError, definedVars)
+	checkSteps("SessionTeardown", config.SessionTeardown, definedVars)
+
+	if len(config.Workflows) > 0 && len(config.Steps) > 0 {
+		issues = append(issues, walker.issueAt("Workflows", "Steps and Workflows cannot both be set - move the steps into a workflow"))
+	}
+	seen := make(map[string]bool)
+	for i, w := range config.Workflows {
+		path := fmt.Sprintf("Workflows[%d]", i)
+		if strings.TrimSpace(w.File) != "" {
+			expanded, err := expandSuiteWorkflows([]SuiteWorkflow{w}, baseDir)
+			if err != nil {
+				issues = append(issues, walker.issueAt(path+".File", err.Error()))
+				continue
+			}
+			// Problems in the loaded steps are reported at the entry.
+			w = expanded[0]
+		}
+		if err := validateSuiteEntry(w, seen); err != nil {
+			issues = append(issues, walker.issueAt(path, err.Error()))
+		}
+		if config.OutputFilePath == "" && (stepsNeedOutputFile(w.Steps) || stepsNeedOutputFile(w.OnError)) {
+			issues = append(issues, walker.issueAt("OutputFilePath", fmt.Sprintf("output file path is empty - screen grab in workflow %q needs a home", w.Name)))
+		}
+		suiteVars := setupVars(&config)
+		checkSteps(path+".Steps", w.Steps, suiteVars)
+		checkSteps(path+".OnError", w.OnError, suiteVars)
+	}
+	sort.SliceStable(issues, func(a, b int) bool {
+		if issues[a].Line != issues[b].Line {
+			return issues[a].Line < issues[b].Line
+		}
+		return issues[a].Column < issues[b].Column
+	})
+	return issues
+}
+
+var listIndex = regexp.MustCompile(`\.(\d+)`)
+
+// apiWorkflowIssues explains, field by field, why the API refused the
+// workflow in data with err.
+func apiWorkflowIssues(data []byte, err error) []validationIssue {
+	issues := validateWorkflowJSON(data, ".")
+	if len(issues) == 0 {
+		issues = append(issues, validationIssue{Message: err.Error()})
+	}
+	return issues
+}
+
+// headerIssuePath guesses which top-level setting a validateConfiguration
+// error is about, for its position.
+func headerIssuePath(err error) string {
+	msg := err.Error()
+	for _, key := range []string{"EveryStepDelay", "EndOfTaskDelay", "Delay", "TLSCertFile", "TLSKeyFile", "TLSKeyPassword", "TLSCAFile", "CodePage", "Proxy", "SSHTunnel", "Printer", "Thresholds", "ErrorBudget", "LUPool", "LUName"} {
+		if strings.Contains(msg, key) {
+			return key
+		}
+	}
+	switch lower := strings.ToLower(msg); {
+	case strings.HasPrefix(lower, "host"):
+		return "Host"
+	case strings.HasPrefix(lower, "port"):
+		return "Port"
+	case strings.HasPrefix(msg, "TLS"):
+		return "TLS"
+	}
+	return ""
+}
+
+// coordinateIssues reports coordinates outside the screen for a step and
+// any steps nested in it.
+func (w *schemaWalker) coordinateIssues(path string, step Step) []validationIssue {
+	var issues []validationIssue
+	check := func(p string, c connect3270.Coordinates) {
+		switch {
+		case c.Row < 0 || c.Row > screenRows:
+			issues = append(issues, w.issueAt(p+".Row", fmt.Sprintf("row %d is outside the %dx%d screen", c.Row, screenRows, screenColumns)))
+		case c.Column < 0 || c.Column > screenColumns:
+			issues = append(issues, w.issueAt(p+".Column", fmt.Sprintf("column %d is outside the %dx%d screen", c.Column, screenRows, screenColumns)))
+		case c.Length < 0:
+			issues = append(issues, w.issueAt(p+".Length", "length cannot be negative"))
+		case c.Row > 0 && c.Column > 0 && (c.Row-1)*screenColumns+c.Column-1+c.Length > screenRows*screenColumns:
+			issues = append(issues, w.issueAt(p+".Length", fmt.Sprintf("length %d runs past the end of the screen", c.Length)))
+		}
+	}
+	check(path+".Coordinates", step.Coordinates)
+	if step.Condition != nil && step.Condition.ValueEquals != nil {
+		check(path+".Condition.ValueEquals.Coordinates", step.Condition.ValueEquals.Coordinates)
+	}
+	for i, nested := range step.Steps {
+		issues = append(issues, w.coordinateIssues(fmt.Sprintf("%s.Steps[%d]", path, i), nested)...)
+	}
+	for i, nested := range step.Else {
+		issues = append(issues, w.coordinateIssues(fmt.Sprintf("%s.Else[%d]", path, i), nested)...)
+	}
+	return issues
+}
+
+// schemaWalker streams the workflow JSON, recording where every value starts
+// and reporting keys that do not map to a Configuration or Step field.
+type schemaWalker struct {
+	data    []byte
+	dec     *json.Decoder
+	offsets map[string]int64
+	issues  []validationIssue
+}
+
+func (w *schemaWalker) walkDocument() error {
+	w.dec = json.NewDecoder(bytes.NewReader(w.data))
+	w.offsets[""] = 0
+	if err := w.walk(reflect.TypeOf(Configuration{}), ""); err != nil {
+		return err
+	}
+	if _, err := w.dec.Token(); err != io.EOF {
+		if err == nil {
+			return fmt.Errorf("unexpected data after the workflow object")
+		}
+		return err
+	}
+	return nil
+}
+
+// walk consumes one JSON value. t is the Go type it decodes into, or nil
+// when the value is not checked.
+func (w *schemaWalker) walk(t reflect.Type, path string) error {
+	for t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	tok, err := w.dec.Token()
+	if err != nil {
+		return err
+	}
+	delim, ok := tok.(json.Delim)
+	if !ok {
+		return nil
+	}
+	switch delim {
+	case '{':
+		var fields map[string]reflect.StructField
+		if t != nil && t.Kind() == reflect.Struct {
+			fields = jsonFields(t)
+		}
+		for w.dec.More() {
+			offset := w.dec.InputOffset()
+			keyTok, err := w.dec.Token()
+			if err != nil {
+				return err
+			}
+			key := keyTok.(string)
+			childPath := joinFieldPath(path, key)
+			w.offsets[childPath] = offset
+			var childType reflect.Type
+			if t != nil && t.Kind() == reflect.Map {
+				childType = t.Elem()
+			}
+			if fields != nil {
+				field, known := fields[strings.ToLower(key)]
+				if !known && !(path == "" && key == "$schema") {
+					w.issues = append(w.issues, w.issueAt(childPath, fmt.Sprintf("unknown field %q%s", key, suggestField(key, fields))))
+				} else {
+					childType = field.Type
+				}
+			}
+			if err := w.walk(childType, childPath); err != nil {
+				return err
+			}
+		}
+	case '[':
+		var elem reflect.Type
+		if t != nil && t.Kind() == reflect.Slice {
+			elem = t.Elem()
+		}
+		for i := 0; w.dec.More(); i++ {
+			childPath := fmt.Sprintf("%s[%d]", path, i)
+			w.offsets[childPath] = w.dec.InputOffset()
+			if err := w.walk(elem, childPath); err != nil {
+				return err
+			}
+		}
+	}
+	_, err = w.dec.Token() // closing delimiter
+	return err
+}
+
+func joinFieldPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+// jsonFields maps the lower-cased JSON names of t's fields, matching the
+// case-insensitive key handling of encoding/json.
+func jsonFields(t reflect.Type) map[string]reflect.StructField {
+	fields := make(map[string]reflect.StructField)
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" {
+			continue
+		}
+		name := f.Name
+		if tag := f.Tag.Get("json"); tag != "" {
+			if tag == "-" {
+				continue
+			}
+			if tagName, _, _ := strings.Cut(tag, ","); tagName != "" {
+				name = tagName
+			}
+		}
+		f.Name = name
+		fields[strings.ToLower(name)] = f
+	}
+	return fields
+}
+
+// suggestField names the field a misspelled key most likely meant.
+func suggestField(key string, fields map[string]reflect.StructField) string {
+	best, bestDistance := "", 3
+	for lower, f := range fields {
+		if d := editDistance(strings.ToLower(key), lower); d < bestDistance {
+			best, bestDistance = f.Name, d
+		}
+	}
+	if best == "" {
+		return ""
+	}
+	return fmt.Sprintf(" (did you mean %q?)", best)
+}
+
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur := make([]int, len(b)+1)
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = prev[j-1] + cost
+			if prev[j]+1 < cur[j] {
+				cur[j] = prev[j] + 1
+			}
+			if cur[j-1]+1 < cur[j] {
+				cur[j] = cur[j-1] + 1
+			}
+		}
+		prev = cur
+	}
+	return prev[len(b)]
+}
+
+// issueAt builds an issue positioned at path, falling back to the closest
+// enclosing value the walker saw.
+func (w *schemaWalker) issueAt(path, message string) validationIssue {
+	issue := validationIssue{Path: path, Message: message}
+	for p := path; ; {
+		if offset, ok := w.offsets[p]; ok {
+			issue.Line, issue.Column = offsetPosition(w.data, tokenStart(w.data, offset))
+			break
+		}
+		cut := strings.LastIndexAny(p, ".[")
+		if cut < 0 {
+			if p == "" {
+				break
+			}
+			p = ""
+			continue
+		}
+		p = p[:cut]
+	}
+	return issue
+}
+
+// offsetPosition converts a byte offset into a 1-based line and column.
+func offsetPosition(data []byte, offset int64) (int, int) {
+	line, col := 1, 1
+	for i := int64(0); i < offset && i < int64(len(data)); i++ {
+		if data[i] == '\n' {
+			line++
+			col = 1
+		} else {
+			col++
+		}
+	}
+	return line, col
+}
+
+// tokenStart skips the separators json.Decoder leaves between its input
+// offset and the next token.
+func tokenStart(data []byte, offset int64) int64 {
+	for offset < int64(len(data)) && strings.IndexByte(" \t\r\n,:", data[offset]) >= 0 {
+		offset++
+	}
+	return offset
+}
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	vaultAddr     string
+	vaultToken    string
+	vaultRole     string
+	vaultAuthPath string
+)
+
+func init() {
+	flag.StringVar(&vaultAddr, "vaultAddr", "", "Vault server address for {{vault:path#field}} placeholders (defaults to $VAULT_ADDR)")
+	flag.StringVar(&vaultToken, "vaultToken", "", "Vault token (defaults to $VAULT_TOKEN, then ~/.vault-token)")
+	flag.StringVar(&vaultRole, "vaultRole", "", "Log in to Vault with this Kubernetes auth role instead of a token (defaults to $VAULT_ROLE)")
+	flag.StringVar(&vaultAuthPath, "vaultAuthPath", "kubernetes", "Mount path of the Vault Kubernetes auth method used with -vaultRole")
+	secretProviders["vault"] = vaultSecret
+}
+
+// vaultClient reads KV secrets over the Vault HTTP API.
+type vaultClient struct {
+	addr      string
+	token     string
+	namespace string
+	http      *http.Client
+}
+
+// vaultConnection is the client every read shares, made on first use.
+type vaultConnection struct {
+	once   sync.Once
+	client *vaultClient
+	err    error
+}
+
+func (c *vaultConnection) get() (*vaultClient, error) {
+	c.once.Do(func() { c.client, c.err = newVaultClient() })
+	return c.client, c.err
+}
+
+// vaultRead is the one read of a secret path. done is closed once data or
+// err is set.
+type vaultRead struct {
+	done chan struct{}
+	data map[string]interface{}
+	err  error
+}
+
+var (
+	vaultMu      sync.Mutex
+	activeVault  = new(vaultConnection)
+	vaultSecrets = make(map[string]*vaultRead)
+)
+
+// vaultSecret resolves "path#field". Each secret path is read once per
+// process, and a failed read is not retried. resolveSecrets looks up every
+// placeholder of the workflow when it is loaded, so a missing secret fails
+// the run up front and virtual users do not wait on Vault mid-run. Reads of
+// different paths do not wait for each other.
+func vaultSecret(ref string) (string, error) {
+	path, field, ok := strings.Cut(ref, "#")
+	path = strings.Trim(strings.TrimSpace(path), "/")
+	if !ok || path == "" || field == "" {
+		return "", fmt.Errorf("invalid {{vault:%s}} - use {{vault:secret/path#field}}", ref)
+	}
+	if err := checkVaultPath(path); err != nil {
+		return "", fmt.Errorf("invalid {{vault:%s}}: %w", ref, err)
+	}
+
+	vaultMu.Lock()
+	read, started := vaultSecrets[path]
+	if !started {
+		read = &vaultRead{done: make(chan struct{})}
+		vaultSecrets[path] = read
+	}
+	connection := activeVault
+	vaultMu.Unlock()
+	if started {
+		<-read.done
+	} else {
+		client, err := connection.get()
+		if err == nil {
+			read.data, err = client.read(path)
+		}
+		read.err = err
+		close(read.done)
+	}
+	if read.err != nil {
+		return "", read.err
+	}
+	value, ok := read.data[field]
+	if !ok {
+		return "", fmt.Errorf("Vault secret %s has no field %q", path, field)
+	}
+	if s, isString := value.(string); isString {
+		return s, nil
+	}
+	return fmt.Sprint(value), nil
+}
+
+// checkVaultPath refuses paths that Vault, or a proxy in front of it, could
+// resolve to another path: "." and ".." segments, and escapes or queries
+// that could hide them.
+func checkVaultPath(path string) error {
+	if strings.ContainsAny(path, "%?\\") {
+		return errors.New("Vault paths cannot hold %, ? or \\")
+	}
+	for _, segment := range strings.Split(path, "/") {
+		if segment == "." || segment == ".." {
+			return errors.New("Vault paths cannot hold . or .. segments")
+		}
+	}
+	return nil
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v = strings.TrimSpace(v); v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+func newVaultClient() (*vaultClient, error) {
+	addr := strings.TrimRight(firstNonEmpty(vaultAddr, os.Getenv("VAULT_ADDR")), "/")
+	if addr == "" {
+		return nil, errors.New("{{vault:...}} placeholders need -vaultAddr or VAULT_ADDR")
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if caPath := os.Getenv("VAULT_CACERT"); caPath != "" {
+		caData, err := os.ReadFile(caPath)
+		if err != nil {
+			return nil, fmt.Errorf("reading VAULT_CACERT: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caData) {
+			return nil, fmt.Errorf("VAULT_CACERT %s contains no certificates", caPath)
+		}
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool}
+	}
+	client := &vaultClient{
+		addr:      addr,
+		namespace: os.Getenv("VAULT_NAMESPACE"),
+		http:      &http.Client{Timeout: 30 * time.Second, Transport: transport},
+	}
+
+	if role := firstNonEmpty(vaultRole, os.Getenv("VAULT_ROLE")); role != "" {
+		if err := client.loginKubernetes(role); err != nil {
+			return nil, err
+		}
+		return client, nil
+	}
+	client.token = firstNonEmpty(vaultToken, os.Getenv("VAULT_TOKEN"))
+	if client.token == "" {
+		if home, err := os.UserHomeDir(); err == nil {
+			if data, err := os.ReadFile(filepath.Join(home, ".vault-token")); err == nil {
+				client.token = strings.TrimSpace(string(data))
+			}
+		}
+	}
+	if client.token == "" {
+		return nil, errors.New("no Vault credentials - set -vaultToken, VAULT_TOKEN or -vaultRole")
+	}
+	return client, nil
+}
+
+// loginKubernetes exchanges the pod's service account token for a Vault token.
+func (c *vaultClient) loginKubernetes(role string) error {
+	jwt, err := os.ReadFile(filepath.Join(serviceAccountDir, "token"))
+	if err != nil {
+		return fmt.Errorf("reading service account token for Vault login: %w", err)
+	}
+	var resp struct {
+		Auth struct {
+			ClientToken string `json:"client_token"`
+		} `json:"auth"`
+	}
+	payload := map[string]string{"role": role, "jwt": strings.TrimSpace(string(jwt))}
+	authPath := strings.Trim(vaultAuthPath, "/")
+	if _, err := c.do(http.MethodPost, "/v1/auth/"+authPath+"/login", payload, &resp); err != nil {
+		return fmt.Errorf("Vault login with role %s: %w", role, err)
+	}
+	if resp.Auth.ClientToken == "" {
+		return fmt.Errorf("Vault login with role %s returned no token", role)
+	}
+	c.token = resp.Auth.ClientToken
+	return nil
+}
+
+// read returns the fields of a KV secret. Paths are tried as given first and
+// then with "data/" after the mount, so both KV v1 paths and the short
+// "secret/app" form of KV v2 paths work.
+func (c *vaultClient) read(path string) (map[string]interface{}, error) {
+	var resp struct {
+		Data map[string]interface{} `json:"data"`
+	}
+	status, err := c.do(http.MethodGet, "/v1/"+path, nil, &resp)
+	if status == http.StatusNotFound {
+		if mount, rest, ok := strings.Cut(path, "/"); ok && !strings.HasPrefix(rest, "data/") {
+			status, err = c.do(http.MethodGet, "/v1/"+mount+"/data/"+rest, nil, &resp)
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading Vault secret %s: %w", path, err)
+	}
+	// KV v2 wraps the fields in data.data next to data.metadata.
+	if inner, ok := resp.Data["data"].(map[string]interface{}); ok {
+		if _, hasMeta := resp.Data["metadata"]; hasMeta {
+			return inner, nil
+		}
+	}
+	return resp.Data, nil
+}
+
+func (c *vaultClient) do(method, path string, payload interface{}, out interface{}) (int, error) {
+	var body io.Reader
+	if payload != nil {
+		data, err := json.Marshal(payload)
+		if err != nil {
+			return 0, err
+		}
+		body = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, c.addr+path, body)
+	if err != nil {
+		return 0, err
+	}
+	if c.token != "" {
+		req.Header.Set("X-Vault-Token", c.token)
+	}
+	if c.namespace != "" {
+		req.Header.Set("X-Vault-Namespace", c.namespace)
+	}
+	if payload != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if resp.StatusCode >= 300 {
+		return resp.StatusCode, fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(data)))
+	}
+	return resp.StatusCode, json.Unmarshal(data, out)
+}
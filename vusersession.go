@@ -0,0 +1,193 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	connect3270 "github.com/3270io/3270Connect/connect3270"
+)
+
+// sessionTeardownTimeout bounds the log-off at the end of a run, which also
+// runs after the grace period has cancelled everything else.
+const sessionTeardownTimeout = 30 * time.Second
+
+// vUserSession is the host session a vUser keeps logged on between
+// iterations when the workflow has SessionSetup steps. It is owned by one
+// worker and not shared.
+type vUserSession struct {
+	open bool
+	// vars are the values captured by ExtractValue since the logon.
+	vars map[string]string
+	// last is the configuration of the latest iteration; its
+	// SessionTeardown logs the session off.
+	last   *Configuration
+	tunnel *sshTunnel
+}
+
+// persists reports whether iterations of config keep s logged on.
+func (s *vUserSession) persists(config *Configuration) bool {
+	return s != nil && len(config.SessionSetup) > 0
+}
+
+func (s *vUserSession) isOpen() bool {
+	return s != nil && s.open
+}
+
+// heldTunnel returns the SSH tunnel the session holds, or nil.
+func (s *vUserSession) heldTunnel() *sshTunnel {
+	if s == nil {
+		return nil
+	}
+	return s.tunnel
+}
+
+// drop disconnects the session, so the next iteration logs on again.
+func (s *vUserSession) drop(e *connect3270.Emulator) {
+	_ = e.Disconnect()
+	if s == nil {
+		return
+	}
+	s.open = false
+	s.vars = nil
+	if s.tunnel != nil {
+		s.tunnel.release()
+		s.tunnel = nil
+	}
+}
+
+// close logs the session off at the end of the run with the SessionTeardown
+// steps, then drops it.
+func (s *vUserSession) close(e *connect3270.Emulator) {
+	if s.isOpen() && len(s.last.SessionTeardown) > 0 {
+		runSessionTeardown(e, s)
+	}
+	s.drop(e)
+}
+
+// runSessionTeardown runs the SessionTeardown steps on the open session. A
+// failed step is logged and ends the teardown; the session is dropped
+// either way.
+func runSessionTeardown(e *connect3270.Emulator, s *vUserSession) {
+	config := s.last
+	label := e.ScriptPort
+	ctx, cancel := context.WithTimeout(context.Background(), sessionTeardownTimeout)
+	defer cancel()
+	e.SetContext(ctx)
+	defer e.SetContext(nil)
+	state := newWorkflowState(config.OutputFilePath, config.Token)
+	state.submitted = config.submitted
+	state.vars = s.vars
+	state.everyStepDelay = config.EveryStepDelay
+	state.ctx = ctx
+	storeLog(fmt.Sprintf("Running %d SessionTeardown step(s) for %s", len(config.SessionTeardown), label))
+	for idx, step := range config.SessionTeardown {
+		stepStart := time.Now()
+		if err := executeStep(e, step, state); err != nil {
+			err = fmt.Errorf("SessionTeardown step %s failed for %s: %w", step.Type, label, err)
+			msg := err.Error()
+			logOrStore(schedulerLog, connect3270.Verbose || verboseFailures, slog.LevelWarn, msg)
+			addError(err)
+			return
+		}
+		recordStepTiming(sessionStepTimingKey("SessionTeardown", idx, step), time.Since(stepStart))
+	}
+}
+
+// sessionStepTimingKey names a SessionSetup or SessionTeardown step in the
+// step timings. The blocks are shared by every workflow of a suite, so the
+// key carries no workflow name.
+func sessionStepTimingKey(block string, idx int, step Step) string {
+	return fmt.Sprintf("%s %d %s", block, idx+1, step.Type)
+}
+
+// sessionSteps returns the steps of a run that logs on and off only once:
+// SessionSetup, steps, then SessionTeardown.
+func sessionSteps(config *Configuration, steps []Step) []Step {
+	if len(config.SessionSetup) == 0 {
+		return steps
+	}
+	all := make([]Step, 0, len(config.SessionSetup)+len(steps)+len(config.SessionTeardown))
+	all = append(all, config.SessionSetup...)
+	all = append(all, steps...)
+	return append(all, config.SessionTeardown...)
+}
+
+// expandSessionIncludes inlines the Include steps of SessionSetup and
+// SessionTeardown.
+func expandSessionIncludes(config *Configuration, baseDir string) error {
+	var err error
+	if config.SessionSetup, err = expandIncludes(config.SessionSetup, baseDir); err != nil {
+		return fmt.Errorf("SessionSetup: %w", err)
+	}
+	if config.SessionTeardown, err = expandIncludes(config.SessionTeardown, baseDir); err != nil {
+		return fmt.Errorf("SessionTeardown: %w", err)
+	}
+	return nil
+}
+
+// validateSessionSetup checks that SessionSetup logs on a session that the
+// workflow steps can keep using. The step contents are checked with the
+// other step lists.
+func validateSessionSetup(config *Configuration) error {
+	if len(config.SessionSetup) == 0 {
+		if len(config.SessionTeardown) > 0 {
+			return fmt.Errorf("SessionTeardown needs SessionSetup - without it every workflow logs off on its own")
+		}
+		return nil
+	}
+	if config.Reconnect != nil {
+		return fmt.Errorf("Reconnect cannot be combined with SessionSetup - a dropped session is set up again at the next iteration")
+	}
+	if config.Printer != nil {
+		return fmt.Errorf("Printer cannot be combined with SessionSetup")
+	}
+	if stepsUseSession(config.SessionSetup) || stepsUseSession(config.SessionTeardown) {
+		return fmt.Errorf("named sessions are not allowed in SessionSetup or SessionTeardown steps")
+	}
+	if stepsUseTransaction(config.SessionSetup) || stepsUseTransaction(config.SessionTeardown) {
+		return fmt.Errorf("Transaction is not allowed on SessionSetup or SessionTeardown steps")
+	}
+	if !stepsConnect(config.SessionSetup) {
+		return fmt.Errorf("SessionSetup must Connect to the host")
+	}
+	if stepsConnect(config.Steps) || stepsConnect(config.SessionTeardown) {
+		return fmt.Errorf("Steps cannot Connect when SessionSetup is set - the session is already logged on")
+	}
+	for _, w := range config.Workflows {
+		if stepsConnect(w.Steps) {
+			return fmt.Errorf("workflow %q cannot Connect when SessionSetup is set - the session is already logged on", w.Name)
+		}
+	}
+	return nil
+}
+
+// setupVars returns the variables SessionSetup captures, which every
+// workflow of the session can use.
+func setupVars(config *Configuration) map[string]bool {
+	vars := make(map[string]bool)
+	// Problems in the setup steps are reported where they are validated.
+	_ = validateSteps(config.SessionSetup, vars)
+	return vars
+}
+
+// stepsConnect reports whether steps, including those nested in If blocks,
+// connect the main session.
+func stepsConnect(steps []Step) bool {
+	for _, step := range steps {
+		if (step.Type == "Connect" && step.Session == "") || stepsConnect(step.Steps) || stepsConnect(step.Else) {
+			return true
+		}
+	}
+	return false
+}
+
+func stepsUseSession(steps []Step) bool {
+	for _, step := range steps {
+		if step.Session != "" || stepsUseSession(step.Steps) || stepsUseSession(step.Else) {
+			return true
+		}
+	}
+	return false
+}